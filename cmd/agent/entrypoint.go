@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/grafana/agent/pkg/tempo"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/grafana/agent/pkg/util/server"
+	"github.com/grafana/agent/pkg/util/usage"
 	"github.com/oklog/run"
 	"google.golang.org/grpc"
 	"gopkg.in/yaml.v2"
@@ -38,6 +40,7 @@ type Entrypoint struct {
 	lokiLogs    *loki.Loki
 	tempoTraces *tempo.Tempo
 	manager     *integrations.Manager
+	usage       *usage.Sampler
 
 	reloadListener net.Listener
 	reloadServer   *http.Server
@@ -67,24 +70,31 @@ func NewEntrypoint(logger *util.Logger, cfg *config.Config, reloader Reloader) (
 		ep.reloadServer = &http.Server{Handler: reloadMux}
 	}
 
-	ep.srv = server.New(prometheus.DefaultRegisterer, logger)
+	// /metrics serves the metrics of every subsystem. Instances read back
+	// their remote_write progress from the same gatherer.
+	regs := newSubsystemRegisterers(cfg.Metrics)
+	ep.srv = server.New(prometheus.DefaultRegisterer, regs.gatherer, logger)
 
-	ep.promMetrics, err = prom.New(prometheus.DefaultRegisterer, cfg.Prometheus, logger)
+	if cfg.Metrics.UsageSampleInterval > 0 {
+		ep.usage = usage.NewSampler(logger, prometheus.DefaultRegisterer, cfg.Metrics.UsageSampleInterval, cfg.Metrics.UsageProfileDuration)
+	}
+
+	ep.promMetrics, err = prom.New(regs.prometheus, regs.gatherer, cfg.Prometheus, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	ep.lokiLogs, err = loki.New(prometheus.DefaultRegisterer, cfg.Loki, logger)
+	ep.lokiLogs, err = loki.New(regs.loki, cfg.Loki, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	ep.tempoTraces, err = tempo.New(prometheus.DefaultRegisterer, cfg.Tempo, cfg.Server.LogLevel.Logrus)
+	ep.tempoTraces, err = tempo.New(regs.tempo, cfg.Tempo, ep.lokiLogs, ep.promMetrics.InstanceManager(), cfg.Server.LogLevel.Logrus)
 	if err != nil {
 		return nil, err
 	}
 
-	ep.manager, err = integrations.NewManager(cfg.Integrations, logger, ep.promMetrics.InstanceManager(), ep.promMetrics.Validate)
+	ep.manager, err = integrations.NewManager(regs.integrations, cfg.Integrations, logger, ep.promMetrics.InstanceManager(), ep.promMetrics.Validate)
 	if err != nil {
 		return nil, err
 	}
@@ -245,6 +255,16 @@ func (ep *Entrypoint) Start() error {
 		})
 	}
 
+	if ep.usage != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			ep.usage.Run(ctx)
+			return nil
+		}, func(e error) {
+			cancel()
+		})
+	}
+
 	g.Add(func() error {
 		return ep.srv.Run()
 	}, func(e error) {
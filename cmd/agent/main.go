@@ -20,6 +20,9 @@ import (
 	// Register Prometheus SD components
 	_ "github.com/prometheus/prometheus/discovery/install"
 
+	// Register Agent SD components
+	_ "github.com/grafana/agent/pkg/prom/discovery/http"
+
 	// Register integrations
 	_ "github.com/grafana/agent/pkg/integrations/install"
 )
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/grafana/agent/pkg/config"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// subsystemRegisterers holds the registerers that each subsystem registers
+// its metrics to, and the gatherer that collects all of them for /metrics.
+type subsystemRegisterers struct {
+	prometheus   prometheus.Registerer
+	loki         prometheus.Registerer
+	tempo        prometheus.Registerer
+	integrations prometheus.Registerer
+
+	gatherer prometheus.Gatherer
+}
+
+// newSubsystemRegisterers creates registerers for the subsystems. All
+// subsystems use the default registerer unless cfg.SubsystemPrefixes is set.
+// Metrics registered globally by libraries always go to the default
+// registerer and keep their names.
+func newSubsystemRegisterers(cfg config.MetricsConfig) subsystemRegisterers {
+	var drop []string
+	if cfg.DropHighCardinality {
+		drop = util.HighCardinalityMetrics
+	}
+
+	gatherer := prometheus.DefaultGatherer
+	if len(drop) > 0 {
+		gatherer = util.DropMetrics(gatherer, drop)
+	}
+
+	if !cfg.SubsystemPrefixes {
+		return subsystemRegisterers{
+			prometheus:   prometheus.DefaultRegisterer,
+			loki:         prometheus.DefaultRegisterer,
+			tempo:        prometheus.DefaultRegisterer,
+			integrations: prometheus.DefaultRegisterer,
+			gatherer:     gatherer,
+		}
+	}
+
+	var (
+		promReg         = util.NewSubsystemRegistry(cfg.PrometheusPrefix, drop)
+		lokiReg         = util.NewSubsystemRegistry(cfg.LokiPrefix, drop)
+		tempoReg        = util.NewSubsystemRegistry(cfg.TempoPrefix, drop)
+		integrationsReg = util.NewSubsystemRegistry(cfg.IntegrationsPrefix, drop)
+	)
+	return subsystemRegisterers{
+		prometheus:   promReg,
+		loki:         lokiReg,
+		tempo:        tempoReg,
+		integrations: integrationsReg,
+		gatherer:     prometheus.Gatherers{gatherer, promReg, lokiReg, tempoReg, integrationsReg},
+	}
+}
@@ -4,27 +4,41 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	// Adds version information
 	_ "github.com/grafana/agent/pkg/build"
 	"github.com/grafana/agent/pkg/client/grafanacloud"
 	"github.com/grafana/agent/pkg/config"
+	"github.com/grafana/agent/pkg/loki"
 	"github.com/olekukonko/tablewriter"
+	dto "github.com/prometheus/client_model/go"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/storage/remote"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/grafana/agent/pkg/agentctl"
 	"github.com/grafana/agent/pkg/client"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 
 	// Register Prometheus SD components
 	_ "github.com/prometheus/prometheus/discovery/install"
 
+	// Register Agent SD components
+	_ "github.com/grafana/agent/pkg/prom/discovery/http"
+
 	// Register integrations
 	_ "github.com/grafana/agent/pkg/integrations/install"
 )
@@ -43,7 +57,12 @@ func main() {
 		walStatsCmd(),
 		targetStatsCmd(),
 		samplesCmd(),
+		walDumpCmd(),
+		remoteWriteCheckCmd(),
+		walPushCmd(),
 		cloudConfigCmd(),
+		promtailConvertCmd(),
+		analyzeCmd(),
 	)
 
 	_ = cmd.Execute()
@@ -53,6 +72,7 @@ func configSyncCmd() *cobra.Command {
 	var (
 		agentAddr string
 		dryRun    bool
+		headers   []string
 	)
 
 	cmd := &cobra.Command{
@@ -77,10 +97,16 @@ source-of-truth directory.`,
 				os.Exit(1)
 			}
 
+			header, err := parseHeaders(headers)
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid --header", "err", err)
+				os.Exit(1)
+			}
+
 			directory := args[0]
-			cli := client.New(agentAddr)
+			cli := client.NewWithHeader(agentAddr, header)
 
-			err := agentctl.ConfigSync(logger, cli.PrometheusClient, directory, dryRun)
+			err = agentctl.ConfigSync(logger, cli.PrometheusClient, directory, dryRun)
 			if err != nil {
 				level.Error(logger).Log("msg", "failed to sync config", "err", err)
 				os.Exit(1)
@@ -90,9 +116,23 @@ source-of-truth directory.`,
 
 	cmd.Flags().StringVarP(&agentAddr, "addr", "a", "http://localhost:12345", "address of the agent to connect to")
 	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "use the dry run option to validate config files without attempting to upload")
+	cmd.Flags().StringArrayVarP(&headers, "header", "H", nil, "header to send with every request, as \"Name: value\". Can be repeated. Used to send the role header of a config API with authorization.")
 	return cmd
 }
 
+// parseHeaders parses headers of the form "Name: value".
+func parseHeaders(headers []string) (http.Header, error) {
+	h := make(http.Header, len(headers))
+	for _, header := range headers {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%q must be of the form \"Name: value\"", header)
+		}
+		h.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return h, nil
+}
+
 func configCheckCmd() *cobra.Command {
 	var expandEnv bool
 
@@ -184,6 +224,329 @@ $ agentctl sample-stats -s '{job="a"}' /tmp/wal
 	return cmd
 }
 
+func walDumpCmd() *cobra.Command {
+	var (
+		selector string
+		minTime  string
+		maxTime  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "wal-dump [WAL directory]",
+		Short: "Write the series and samples in the WAL as OpenMetrics",
+		Long: `wal-dump reads a WAL directory and writes the series and samples within it
+to stdout using the OpenMetrics text format. The WAL doesn't store metric types,
+so all metrics are written with the unknown type.
+
+wal-dump reads the WAL offline and can be used to inspect what an agent had
+buffered while a remote_write endpoint was unavailable. Stop the agent or copy
+the WAL directory first, since the agent may truncate the WAL while it's read.
+
+Write all samples of the 'up' series between two times:
+
+$ agentctl wal-dump -s up --min-time 2021-04-01T10:00:00Z --max-time 2021-04-01T11:00:00Z /tmp/wal
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			directory := args[0]
+			if _, err := os.Stat(directory); os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "%s does not exist\n", directory)
+				os.Exit(1)
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "error getting wal: %v\n", err)
+				os.Exit(1)
+			}
+
+			// Check if ./wal is a subdirectory, use that instead.
+			if _, err := os.Stat(filepath.Join(directory, "wal")); err == nil {
+				directory = filepath.Join(directory, "wal")
+			}
+
+			opts := agentctl.DumpOptions{Selector: selector}
+			for _, t := range []struct {
+				flag  string
+				value string
+				into  *time.Time
+			}{
+				{"--min-time", minTime, &opts.MinTime},
+				{"--max-time", maxTime, &opts.MaxTime},
+			} {
+				if t.value == "" {
+					continue
+				}
+				parsed, err := time.Parse(time.RFC3339, t.value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "invalid %s: %v\n", t.flag, err)
+					os.Exit(1)
+				}
+				*t.into = parsed
+			}
+
+			if err := agentctl.DumpWAL(os.Stdout, directory, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to dump WAL: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&selector, "selector", "s", "{}", "label selector of series to write")
+	cmd.Flags().StringVar(&minTime, "min-time", "", "only write samples at or after this RFC3339 time")
+	cmd.Flags().StringVar(&maxTime, "max-time", "", "only write samples at or before this RFC3339 time")
+	return cmd
+}
+
+func remoteWriteCheckCmd() *cobra.Command {
+	var (
+		expandEnv bool
+		urls      []string
+		username  string
+		password  string
+		token     string
+		timeout   time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "remote-write-check [config file]",
+		Short: "Send a test sample to remote_write endpoints",
+		Long: `remote-write-check sends a single sample of the agentctl_remote_write_check
+metric to every remote_write endpoint of the given Agent configuration file and
+reports the HTTP status code and latency of each request. Requests use the same
+authentication, TLS settings and headers as the agent, so credentials and
+network paths can be validated before deploying a configuration.
+
+Endpoints can also be given with --url instead of, or in addition to, a
+configuration file.
+
+If all requests succeed the exit code will be 0. Otherwise the exit code will
+be 1.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			if len(args) == 0 && len(urls) == 0 {
+				fmt.Fprintln(os.Stderr, "a config file or --url must be given")
+				os.Exit(1)
+			}
+
+			var remoteWrites []*promconfig.RemoteWriteConfig
+			if len(args) > 0 {
+				cfg := config.Config{}
+				if err := config.LoadFile(args[0], expandEnv, &cfg); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to load config: %s\n", err)
+					os.Exit(1)
+				}
+				if err := cfg.ApplyDefaults(); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to load config: %s\n", err)
+					os.Exit(1)
+				}
+				remoteWrites = agentctl.ConfigRemoteWrites(&cfg)
+			}
+
+			for _, u := range urls {
+				parsed, err := url.Parse(u)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "invalid --url %q: %s\n", u, err)
+					os.Exit(1)
+				}
+
+				rw := promconfig.DefaultRemoteWriteConfig
+				rw.URL = &config_util.URL{URL: parsed}
+				rw.RemoteTimeout = model.Duration(timeout)
+				if username != "" {
+					rw.HTTPClientConfig.BasicAuth = &config_util.BasicAuth{
+						Username: username,
+						Password: config_util.Secret(password),
+					}
+				}
+				rw.HTTPClientConfig.BearerToken = config_util.Secret(token)
+				if err := rw.HTTPClientConfig.Validate(); err != nil {
+					fmt.Fprintf(os.Stderr, "invalid --url options: %s\n", err)
+					os.Exit(1)
+				}
+				remoteWrites = append(remoteWrites, &rw)
+			}
+
+			if len(remoteWrites) == 0 {
+				fmt.Fprintln(os.Stderr, "no remote_write endpoints configured")
+				os.Exit(1)
+			}
+
+			results := agentctl.CheckRemoteWrites(context.Background(), remoteWrites, time.Now())
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Name", "URL", "Status", "Latency", "Error"})
+
+			failed := false
+			for _, r := range results {
+				var status, errStr string
+				if r.StatusCode != 0 {
+					status = fmt.Sprintf("%d", r.StatusCode)
+				}
+				if r.Err != nil {
+					failed = true
+					errStr = r.Err.Error()
+				}
+				table.Append([]string{r.Name, r.URL, status, r.Latency.Round(time.Millisecond).String(), errStr})
+			}
+			table.Render()
+
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().BoolVarP(&expandEnv, "expand-env", "e", false, "expands ${var} in config according to the values of the environment variables")
+	cmd.Flags().StringSliceVar(&urls, "url", nil, "remote_write URL to check in addition to the endpoints in the config file. May be repeated")
+	cmd.Flags().StringVar(&username, "basic-auth-username", "", "basic auth username for --url endpoints")
+	cmd.Flags().StringVar(&password, "basic-auth-password", "", "basic auth password for --url endpoints")
+	cmd.Flags().StringVar(&token, "bearer-token", "", "bearer token for --url endpoints")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "timeout of requests to --url endpoints")
+	return cmd
+}
+
+func walPushCmd() *cobra.Command {
+	var (
+		selector   string
+		minTime    string
+		maxTime    string
+		username   string
+		password   string
+		token      string
+		timeout    time.Duration
+		batchSize  int
+		rateLimit  float64
+		maxRetries int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "wal-push [WAL directory] [remote_write URL]",
+		Short: "Push the samples in a WAL to a remote_write endpoint",
+		Long: `wal-push reads a WAL directory and pushes the samples within it to a
+remote_write endpoint. It can be used to recover the data of an agent that was
+decommissioned before its WAL was drained, from the abandoned WAL or a copy of
+it. Stop the agent or copy the WAL directory first, since the agent may
+truncate the WAL while it's read.
+
+Samples are pushed in the order they were written to the WAL. The endpoint
+must accept samples as old as the oldest sample in the WAL; remote_write
+endpoints commonly reject samples older than an hour. Requests failing with a
+5xx or 429 response are retried with backoff. If the push fails, it can be
+resumed with --min-time.
+
+Push all samples of the WAL at a rate of at most 10k samples per second:
+
+$ agentctl wal-push --rate 10000 /tmp/wal http://localhost:9009/api/prom/push
+`,
+		Args: cobra.ExactArgs(2),
+		Run: func(_ *cobra.Command, args []string) {
+			directory := args[0]
+			if _, err := os.Stat(directory); os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "%s does not exist\n", directory)
+				os.Exit(1)
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "error getting wal: %v\n", err)
+				os.Exit(1)
+			}
+
+			// Check if ./wal is a subdirectory, use that instead.
+			if _, err := os.Stat(filepath.Join(directory, "wal")); err == nil {
+				directory = filepath.Join(directory, "wal")
+			}
+
+			parsed, err := url.Parse(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid remote_write URL: %s\n", err)
+				os.Exit(1)
+			}
+			httpConfig := config_util.HTTPClientConfig{
+				BearerToken: config_util.Secret(token),
+			}
+			if username != "" {
+				httpConfig.BasicAuth = &config_util.BasicAuth{
+					Username: username,
+					Password: config_util.Secret(password),
+				}
+			}
+			if err := httpConfig.Validate(); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid remote_write options: %s\n", err)
+				os.Exit(1)
+			}
+
+			client, err := remote.NewWriteClient("wal-push", &remote.ClientConfig{
+				URL:              &config_util.URL{URL: parsed},
+				Timeout:          model.Duration(timeout),
+				HTTPClientConfig: httpConfig,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to create remote_write client: %s\n", err)
+				os.Exit(1)
+			}
+
+			opts := agentctl.DefaultPushOptions
+			opts.Selector = selector
+			opts.BatchSize = batchSize
+			opts.SamplesPerSecond = rateLimit
+			opts.MaxRetries = maxRetries
+			for _, t := range []struct {
+				flag  string
+				value string
+				into  *time.Time
+			}{
+				{"--min-time", minTime, &opts.MinTime},
+				{"--max-time", maxTime, &opts.MaxTime},
+			} {
+				if t.value == "" {
+					continue
+				}
+				parsed, err := time.Parse(time.RFC3339, t.value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "invalid %s: %v\n", t.flag, err)
+					os.Exit(1)
+				}
+				*t.into = parsed
+			}
+
+			var (
+				start      = time.Now()
+				lastReport time.Time
+			)
+			report := func(p agentctl.PushProgress) {
+				percent := 100.0
+				if p.TotalSamples > 0 {
+					percent = 100 * float64(p.Samples) / float64(p.TotalSamples)
+				}
+				fmt.Fprintf(os.Stderr, "pushed %d/%d samples (%.1f%%) in %d requests, %d retries, %s elapsed\n",
+					p.Samples, p.TotalSamples, percent, p.Batches, p.Retries, time.Since(start).Round(time.Second))
+			}
+			opts.Progress = func(p agentctl.PushProgress) {
+				if time.Since(lastReport) < 5*time.Second {
+					return
+				}
+				lastReport = time.Now()
+				report(p)
+			}
+
+			progress, err := agentctl.PushWAL(context.Background(), client, directory, opts)
+			report(progress)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to push WAL: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&selector, "selector", "s", "{}", "label selector of series to push")
+	cmd.Flags().StringVar(&minTime, "min-time", "", "only push samples at or after this RFC3339 time")
+	cmd.Flags().StringVar(&maxTime, "max-time", "", "only push samples at or before this RFC3339 time")
+	cmd.Flags().StringVar(&username, "basic-auth-username", "", "basic auth username for the remote_write endpoint")
+	cmd.Flags().StringVar(&password, "basic-auth-password", "", "basic auth password for the remote_write endpoint")
+	cmd.Flags().StringVar(&token, "bearer-token", "", "bearer token for the remote_write endpoint")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "timeout of requests to the remote_write endpoint")
+	cmd.Flags().IntVar(&batchSize, "batch-size", agentctl.DefaultPushOptions.BatchSize, "maximum number of samples per request")
+	cmd.Flags().Float64Var(&rateLimit, "rate", 0, "maximum number of samples pushed per second. 0 is unlimited")
+	cmd.Flags().IntVar(&maxRetries, "max-retries", agentctl.DefaultPushOptions.MaxRetries, "number of times a failed request is retried before giving up")
+	return cmd
+}
+
 func targetStatsCmd() *cobra.Command {
 	var (
 		jobLabel      string
@@ -354,6 +717,125 @@ config that may be used with this agent.`,
 	return cmd
 }
 
+func promtailConvertCmd() *cobra.Command {
+	var name string
+
+	cmd := &cobra.Command{
+		Use:   "promtail-convert [promtail config file]",
+		Short: "Convert a Promtail config file into an Agent loki config",
+		Long: `promtail-convert reads an existing Promtail config file and prints the
+equivalent loki block for an Agent config file. The clients, positions,
+scrape_configs, and target_config of the Promtail config are kept as-is, so
+existing positions files continue to be used. The server block is ignored.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			bb, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read Promtail config: %s\n", err)
+				os.Exit(1)
+			}
+
+			cfg, err := loki.ConvertPromtailConfig(bb, name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to convert Promtail config: %s\n", err)
+				os.Exit(1)
+			}
+
+			bb, err = loki.MarshalConfig(cfg, false)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to marshal converted config: %s\n", err)
+				os.Exit(1)
+			}
+
+			// Nest the converted config under a loki key so it can be pasted
+			// into an Agent config file.
+			var section yaml.MapSlice
+			if err := yaml.Unmarshal(bb, &section); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to marshal converted config: %s\n", err)
+				os.Exit(1)
+			}
+			out, err := yaml.Marshal(yaml.MapSlice{{Key: "loki", Value: section}})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to marshal converted config: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Print(string(out))
+		},
+	}
+
+	cmd.Flags().StringVarP(&name, "name", "n", "default", "name of the loki config created from the Promtail config")
+	return cmd
+}
+
+func analyzeCmd() *cobra.Command {
+	var (
+		agentAddr   string
+		metricsFile string
+		timeout     time.Duration
+		opts        = agentctl.DefaultAnalyzeOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Recommend config changes from the metrics of a running agent",
+		Long: `analyze reads the metrics of a running agent and recommends config changes
+for problems they show, such as instances with too many series, remote_write
+queues that need more shards or are retrying often, scrapes running late,
+and high memory usage per series.
+
+Counters are compared over the lifetime of the agent, so analyze an agent that
+has been running for a while under its usual load. Metrics can also be read
+from a file saved from the agent's /metrics endpoint with --file.`,
+		Args: cobra.ExactArgs(0),
+
+		Run: func(_ *cobra.Command, _ []string) {
+			var (
+				families map[string]*dto.MetricFamily
+				err      error
+			)
+			if metricsFile != "" {
+				var f *os.File
+				f, err = os.Open(metricsFile)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to open metrics file: %s\n", err)
+					os.Exit(1)
+				}
+				defer f.Close()
+				families, err = agentctl.ParseMetrics(f)
+			} else {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				families, err = agentctl.FetchMetrics(ctx, http.DefaultClient, agentAddr)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read agent metrics: %s\n", err)
+				os.Exit(1)
+			}
+
+			recs := agentctl.Analyze(families, opts)
+			if len(recs) == 0 {
+				fmt.Println("No recommendations.")
+				return
+			}
+			for i, rec := range recs {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("%s: %s\n", rec.Subject, rec.Setting)
+				fmt.Printf("  Found:  %s\n", rec.Finding)
+				fmt.Printf("  Advice: %s\n", rec.Advice)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "addr", "a", "http://localhost:12345", "address of the agent to analyze")
+	cmd.Flags().StringVarP(&metricsFile, "file", "f", "", "read metrics from a file instead of the agent")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "timeout of the request for the agent's metrics")
+	cmd.Flags().Float64Var(&opts.MaxSeriesPerInstance, "max-series", opts.MaxSeriesPerInstance, "number of active series per instance above which reducing series is recommended")
+	cmd.Flags().Float64Var(&opts.MaxRetryRatio, "max-retry-ratio", opts.MaxRetryRatio, "ratio of retried to sent samples above which more remote_write backoff is recommended")
+	return cmd
+}
+
 func must(err error) {
 	if err != nil {
 		panic(err)
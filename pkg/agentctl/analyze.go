@@ -0,0 +1,383 @@
+package agentctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DefaultAnalyzeOptions holds the default options for Analyze.
+var DefaultAnalyzeOptions = AnalyzeOptions{
+	MaxSeriesPerInstance: 1_000_000,
+	MaxChurnRatio:        0.5,
+	MaxBytesPerSeries:    16 * 1024,
+	MaxWALSizeBytes:      1 << 30,
+	MaxRetryRatio:        0.05,
+	MaxScrapeLateness:    0.1,
+}
+
+// AnalyzeOptions holds the thresholds above which Analyze recommends config
+// changes.
+type AnalyzeOptions struct {
+	// MaxSeriesPerInstance is the number of active series of an instance
+	// above which dropping series or sharding is recommended.
+	MaxSeriesPerInstance float64
+
+	// MaxChurnRatio is the ratio of removed series still held by the WAL to
+	// its active series above which more frequent truncations are
+	// recommended.
+	MaxChurnRatio float64
+
+	// MaxBytesPerSeries is the resident memory of the agent per active series
+	// above which keeping fewer series in memory is recommended.
+	MaxBytesPerSeries float64
+
+	// MaxWALSizeBytes is the size of the WAL of an instance above which
+	// limiting its size is recommended.
+	MaxWALSizeBytes float64
+
+	// MaxRetryRatio is the ratio of retried samples to sent samples of a
+	// remote_write queue above which backing off more is recommended.
+	MaxRetryRatio float64
+
+	// MaxScrapeLateness is the fraction of the scrape interval by which the
+	// 99th percentile of the time between scrapes may exceed it before longer
+	// scrape intervals are recommended.
+	MaxScrapeLateness float64
+}
+
+// Recommendation is a config change suggested by Analyze.
+type Recommendation struct {
+	// Subject is the part of the agent the recommendation is for, such as an
+	// instance or one of its remote_writes.
+	Subject string
+	// Setting is the config setting to change.
+	Setting string
+	// Finding is what was observed in the metrics of the agent.
+	Finding string
+	// Advice describes how to change Setting.
+	Advice string
+}
+
+// FetchMetrics scrapes the metrics of the agent running at addr.
+func FetchMetrics(ctx context.Context, client *http.Client, addr string) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(expfmt.FmtText))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d fetching metrics", resp.StatusCode)
+	}
+	return ParseMetrics(resp.Body)
+}
+
+// ParseMetrics parses metrics in the Prometheus text format.
+func ParseMetrics(r io.Reader) (map[string]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(r)
+}
+
+// Analyze inspects the metrics of an agent and returns recommendations for
+// config changes, sorted by subject and setting. Counters are compared over the lifetime
+// of the agent, so the metrics of an agent that ran for a while under its
+// usual load give the best results.
+func Analyze(families map[string]*dto.MetricFamily, opts AnalyzeOptions) []Recommendation {
+	var recs []Recommendation
+	m := newAnalyzedMetrics(families)
+
+	recs = append(recs, analyzeSeries(m, opts)...)
+	recs = append(recs, analyzeMemory(m, opts)...)
+	recs = append(recs, analyzeRemoteWrite(m, opts)...)
+	recs = append(recs, analyzeScrapes(m, opts)...)
+
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].Subject != recs[j].Subject {
+			return recs[i].Subject < recs[j].Subject
+		}
+		return recs[i].Setting < recs[j].Setting
+	})
+	return recs
+}
+
+func analyzeSeries(m analyzedMetrics, opts AnalyzeOptions) []Recommendation {
+	var recs []Recommendation
+
+	deleted := m.byInstance("agent_wal_storage_deleted_series")
+	walSize := m.byInstance("agent_wal_storage_size_bytes")
+
+	for inst, active := range m.byInstance("agent_wal_storage_active_series") {
+		subject := instanceSubject(inst)
+
+		if active > opts.MaxSeriesPerInstance {
+			recs = append(recs, Recommendation{
+				Subject: subject,
+				Setting: "scrape_configs[].metric_relabel_configs",
+				Finding: fmt.Sprintf("%s active series", formatCount(active)),
+				Advice: "Drop unused high-cardinality metrics or labels with metric_relabel_configs " +
+					"(agentctl target-stats lists the series of a target), or spread targets over more " +
+					"agents with host_filter or the scraping service.",
+			})
+		}
+
+		if active > 0 && deleted[inst] > opts.MaxChurnRatio*active {
+			recs = append(recs, Recommendation{
+				Subject: subject,
+				Setting: "wal_truncate_frequency",
+				Finding: fmt.Sprintf("%s series that stopped receiving samples are held for %s active series",
+					formatCount(deleted[inst]), formatCount(active)),
+				Advice: "Series churn is high. Lower wal_truncate_frequency so removed series are " +
+					"released sooner, and check for labels whose values change often, such as pod or request IDs.",
+			})
+		}
+	}
+
+	for inst, size := range walSize {
+		if size > opts.MaxWALSizeBytes {
+			recs = append(recs, Recommendation{
+				Subject: instanceSubject(inst),
+				Setting: "max_wal_size_bytes",
+				Finding: fmt.Sprintf("WAL is %s on disk", formatBytes(size)),
+				Advice: "Set max_wal_size_bytes to truncate the WAL early once it grows past a size, " +
+					"or lower wal_truncate_frequency.",
+			})
+		}
+	}
+
+	return recs
+}
+
+func analyzeMemory(m analyzedMetrics, opts AnalyzeOptions) []Recommendation {
+	rss, ok := m.sum("process_resident_memory_bytes")
+	if !ok {
+		return nil
+	}
+	series, _ := m.sum("agent_wal_storage_active_series")
+	if series == 0 {
+		return nil
+	}
+
+	perSeries := rss / series
+	if perSeries <= opts.MaxBytesPerSeries {
+		return nil
+	}
+	return []Recommendation{{
+		Subject: "agent",
+		Setting: "wal_truncate_frequency",
+		Finding: fmt.Sprintf("%s resident memory for %s active series (%s per series)",
+			formatBytes(rss), formatCount(series), formatBytes(perSeries)),
+		Advice: "Memory per series is high. Lower wal_truncate_frequency so series that stopped " +
+			"receiving samples are removed from memory sooner, and limit long label values with " +
+			"label_value_length_limit.",
+	}}
+}
+
+func analyzeRemoteWrite(m analyzedMetrics, opts AnalyzeOptions) []Recommendation {
+	var recs []Recommendation
+
+	shardsMax := m.byQueue("prometheus_remote_storage_shards_max")
+	sent := m.byQueue("prometheus_remote_storage_samples_total")
+	retried := m.byQueue("prometheus_remote_storage_samples_retried_total")
+
+	for q, desired := range m.byQueue("prometheus_remote_storage_shards_desired") {
+		max, ok := shardsMax[q]
+		if !ok || desired <= max {
+			continue
+		}
+		recs = append(recs, Recommendation{
+			Subject: q.subject(),
+			Setting: "queue_config.max_shards",
+			Finding: fmt.Sprintf("%.0f shards are needed to keep up but max_shards is %.0f", math.Ceil(desired), max),
+			Advice: fmt.Sprintf("Raise queue_config.max_shards to at least %.0f, or raise max_samples_per_send "+
+				"so each shard sends more samples per request.", math.Ceil(desired)),
+		})
+	}
+
+	for q, total := range sent {
+		if total == 0 || retried[q] <= opts.MaxRetryRatio*total {
+			continue
+		}
+		recs = append(recs, Recommendation{
+			Subject: q.subject(),
+			Setting: "queue_config.min_backoff",
+			Finding: fmt.Sprintf("%.1f%% of samples were retried", 100*retried[q]/total),
+			Advice: "The endpoint is failing or rate limiting requests. Raise queue_config.min_backoff " +
+				"and max_backoff so retries are spread out, and lower max_shards if the endpoint is overloaded.",
+		})
+	}
+
+	return recs
+}
+
+func analyzeScrapes(m analyzedMetrics, opts AnalyzeOptions) []Recommendation {
+	var recs []Recommendation
+
+	for inst, queued := range m.byInstance("agent_prometheus_instance_scrapes_queued") {
+		if queued == 0 {
+			continue
+		}
+		recs = append(recs, Recommendation{
+			Subject: instanceSubject(inst),
+			Setting: "max_concurrent_scrapes",
+			Finding: fmt.Sprintf("%.0f scrapes are waiting for max_concurrent_scrapes", queued),
+			Advice:  "Raise max_concurrent_scrapes, or raise scrape_interval so fewer scrapes overlap.",
+		})
+	}
+
+	// prometheus_target_interval_length_seconds is shared by every instance,
+	// so scrape intervals can only be told apart by their length.
+	for _, s := range m["prometheus_target_interval_length_seconds"] {
+		if s.labels["quantile"] != "0.99" {
+			continue
+		}
+		interval, err := time.ParseDuration(s.labels["interval"])
+		if err != nil || interval <= 0 {
+			continue
+		}
+		if s.value <= interval.Seconds()*(1+opts.MaxScrapeLateness) {
+			continue
+		}
+		recs = append(recs, Recommendation{
+			Subject: fmt.Sprintf("scrape_interval %s", interval),
+			Setting: "scrape_configs[].scrape_interval",
+			Finding: fmt.Sprintf("99th percentile of the time between scrapes is %s",
+				time.Duration(s.value*float64(time.Second)).Round(time.Millisecond)),
+			Advice: "Scrapes run late because targets are slow or the agent is overloaded. Raise the " +
+				"scrape_interval of slow jobs, lower their scrape_timeout, or spread targets over more agents.",
+		})
+	}
+
+	return recs
+}
+
+type analyzedSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// analyzedMetrics holds the samples of gauges, counters and summaries by
+// metric name.
+type analyzedMetrics map[string][]analyzedSample
+
+func newAnalyzedMetrics(families map[string]*dto.MetricFamily) analyzedMetrics {
+	m := make(analyzedMetrics, len(families))
+	for name, mf := range families {
+		for _, metric := range mf.GetMetric() {
+			labels := make(map[string]string, len(metric.GetLabel()))
+			for _, l := range metric.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+
+			switch {
+			case metric.Gauge != nil:
+				m[name] = append(m[name], analyzedSample{labels: labels, value: metric.Gauge.GetValue()})
+			case metric.Counter != nil:
+				m[name] = append(m[name], analyzedSample{labels: labels, value: metric.Counter.GetValue()})
+			case metric.Untyped != nil:
+				m[name] = append(m[name], analyzedSample{labels: labels, value: metric.Untyped.GetValue()})
+			case metric.Summary != nil:
+				for _, q := range metric.Summary.GetQuantile() {
+					ql := make(map[string]string, len(labels)+1)
+					for k, v := range labels {
+						ql[k] = v
+					}
+					ql["quantile"] = strconv.FormatFloat(q.GetQuantile(), 'f', -1, 64)
+					m[name] = append(m[name], analyzedSample{labels: ql, value: q.GetValue()})
+				}
+			}
+		}
+	}
+	return m
+}
+
+// sum returns the sum of all samples of a metric and whether it was found.
+func (m analyzedMetrics) sum(name string) (float64, bool) {
+	samples, ok := m[name]
+	var total float64
+	for _, s := range samples {
+		total += s.value
+	}
+	return total, ok
+}
+
+// byInstance sums the samples of a metric by the instance they belong to.
+func (m analyzedMetrics) byInstance(name string) map[string]float64 {
+	res := make(map[string]float64)
+	for _, s := range m[name] {
+		res[instanceName(s.labels)] += s.value
+	}
+	return res
+}
+
+// remoteQueue identifies the remote_write queue of an instance.
+type remoteQueue struct {
+	instance string
+	remote   string
+}
+
+func (q remoteQueue) subject() string {
+	return fmt.Sprintf("%s remote_write %q", instanceSubject(q.instance), q.remote)
+}
+
+// byQueue sums the samples of a metric by the remote_write queue they belong
+// to.
+func (m analyzedMetrics) byQueue(name string) map[remoteQueue]float64 {
+	res := make(map[remoteQueue]float64)
+	for _, s := range m[name] {
+		q := remoteQueue{instance: instanceName(s.labels), remote: s.labels["remote_name"]}
+		res[q] += s.value
+	}
+	return res
+}
+
+// instanceName returns the instance or instance group a sample belongs to.
+func instanceName(labels map[string]string) string {
+	if name, ok := labels["instance_name"]; ok {
+		return name
+	}
+	return labels["instance_group_name"]
+}
+
+func instanceSubject(name string) string {
+	if name == "" {
+		return "instance"
+	}
+	return fmt.Sprintf("instance %q", name)
+}
+
+func formatCount(v float64) string {
+	switch {
+	case v >= 1e6:
+		return fmt.Sprintf("%.1fM", v/1e6)
+	case v >= 1e3:
+		return fmt.Sprintf("%.1fk", v/1e3)
+	default:
+		return fmt.Sprintf("%.0f", v)
+	}
+}
+
+func formatBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
@@ -0,0 +1,119 @@
+package agentctl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const analyzeTestMetrics = `
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes 4.294967296e+09
+# TYPE agent_wal_storage_active_series gauge
+agent_wal_storage_active_series{instance_name="busy"} 2e+06
+agent_wal_storage_active_series{instance_name="quiet"} 1000
+# TYPE agent_wal_storage_deleted_series gauge
+agent_wal_storage_deleted_series{instance_name="busy"} 100
+agent_wal_storage_deleted_series{instance_name="quiet"} 5000
+# TYPE agent_wal_storage_size_bytes gauge
+agent_wal_storage_size_bytes{instance_name="busy"} 5.36870912e+09
+agent_wal_storage_size_bytes{instance_name="quiet"} 1024
+# TYPE agent_prometheus_instance_scrapes_queued gauge
+agent_prometheus_instance_scrapes_queued{instance_name="busy"} 12
+agent_prometheus_instance_scrapes_queued{instance_name="quiet"} 0
+# TYPE prometheus_remote_storage_shards_desired gauge
+prometheus_remote_storage_shards_desired{instance_name="busy",remote_name="cloud",url="http://cloud"} 312.4
+prometheus_remote_storage_shards_desired{instance_name="quiet",remote_name="cloud",url="http://cloud"} 1
+# TYPE prometheus_remote_storage_shards_max gauge
+prometheus_remote_storage_shards_max{instance_name="busy",remote_name="cloud",url="http://cloud"} 200
+prometheus_remote_storage_shards_max{instance_name="quiet",remote_name="cloud",url="http://cloud"} 200
+# TYPE prometheus_remote_storage_samples_total counter
+prometheus_remote_storage_samples_total{instance_name="busy",remote_name="cloud",url="http://cloud"} 1000
+prometheus_remote_storage_samples_total{instance_name="quiet",remote_name="cloud",url="http://cloud"} 1000
+# TYPE prometheus_remote_storage_samples_retried_total counter
+prometheus_remote_storage_samples_retried_total{instance_name="busy",remote_name="cloud",url="http://cloud"} 10
+prometheus_remote_storage_samples_retried_total{instance_name="quiet",remote_name="cloud",url="http://cloud"} 200
+# TYPE prometheus_target_interval_length_seconds summary
+prometheus_target_interval_length_seconds{interval="15s",quantile="0.5"} 15.001
+prometheus_target_interval_length_seconds{interval="15s",quantile="0.99"} 21.5
+prometheus_target_interval_length_seconds_sum{interval="15s"} 1500
+prometheus_target_interval_length_seconds_count{interval="15s"} 100
+prometheus_target_interval_length_seconds{interval="1m0s",quantile="0.99"} 60.2
+prometheus_target_interval_length_seconds_sum{interval="1m0s"} 600
+prometheus_target_interval_length_seconds_count{interval="1m0s"} 10
+`
+
+func TestAnalyze(t *testing.T) {
+	families, err := ParseMetrics(strings.NewReader(analyzeTestMetrics))
+	require.NoError(t, err)
+
+	recs := Analyze(families, DefaultAnalyzeOptions)
+
+	var found []string
+	for _, rec := range recs {
+		found = append(found, fmt.Sprintf("%s: %s", rec.Subject, rec.Setting))
+	}
+	require.Equal(t, []string{
+		`instance "busy": max_concurrent_scrapes`,
+		`instance "busy": max_wal_size_bytes`,
+		`instance "busy": scrape_configs[].metric_relabel_configs`,
+		`instance "busy" remote_write "cloud": queue_config.max_shards`,
+		`instance "quiet": wal_truncate_frequency`,
+		`instance "quiet" remote_write "cloud": queue_config.min_backoff`,
+		`scrape_interval 15s: scrape_configs[].scrape_interval`,
+	}, found)
+
+	require.Equal(t, "313 shards are needed to keep up but max_shards is 200", recs[3].Finding)
+	require.Equal(t, "20.0% of samples were retried", recs[5].Finding)
+	require.Equal(t, "99th percentile of the time between scrapes is 21.5s", recs[6].Finding)
+}
+
+func TestAnalyze_Memory(t *testing.T) {
+	families, err := ParseMetrics(strings.NewReader(`
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes 2.147483648e+09
+# TYPE agent_wal_storage_active_series gauge
+agent_wal_storage_active_series{instance_name="a"} 40000
+agent_wal_storage_active_series{instance_name="b"} 25536
+`))
+	require.NoError(t, err)
+
+	recs := Analyze(families, DefaultAnalyzeOptions)
+	require.Len(t, recs, 1)
+	require.Equal(t, "agent", recs[0].Subject)
+	require.Equal(t, "2.0GiB resident memory for 65.5k active series (32.0KiB per series)", recs[0].Finding)
+}
+
+func TestAnalyze_Healthy(t *testing.T) {
+	families, err := ParseMetrics(strings.NewReader(`
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes 1.048576e+08
+# TYPE agent_wal_storage_active_series gauge
+agent_wal_storage_active_series{instance_name="default"} 50000
+`))
+	require.NoError(t, err)
+	require.Empty(t, Analyze(families, DefaultAnalyzeOptions))
+}
+
+func TestFetchMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, analyzeTestMetrics)
+	}))
+	defer srv.Close()
+
+	families, err := FetchMetrics(context.Background(), srv.Client(), srv.URL+"/")
+	require.NoError(t, err)
+	require.Contains(t, families, "agent_wal_storage_active_series")
+
+	_, err = FetchMetrics(context.Background(), srv.Client(), srv.URL+"/missing")
+	require.EqualError(t, err, "unexpected status code 404 fetching metrics")
+}
@@ -0,0 +1,149 @@
+package agentctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/agent/pkg/config"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// RemoteWriteCheckMetric is the name of the series sent by CheckRemoteWrites.
+const RemoteWriteCheckMetric = "agentctl_remote_write_check"
+
+// RemoteWriteCheckResult is the result of sending a check request to a
+// remote_write endpoint.
+type RemoteWriteCheckResult struct {
+	Name string
+	URL  string
+
+	// StatusCode is the HTTP status code of the response. 0 if no response was
+	// received.
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+}
+
+// ConfigRemoteWrites returns the remote_writes used by the Prometheus
+// instances and integrations of cfg. Defaults must already be applied to cfg.
+// Remote_writes shared between instances are only returned once.
+func ConfigRemoteWrites(cfg *config.Config) []*promconfig.RemoteWriteConfig {
+	var (
+		res  []*promconfig.RemoteWriteConfig
+		seen = make(map[*promconfig.RemoteWriteConfig]struct{})
+	)
+	add := func(rws []*promconfig.RemoteWriteConfig) {
+		for _, rw := range rws {
+			if rw == nil {
+				continue
+			}
+			if _, ok := seen[rw]; ok {
+				continue
+			}
+			seen[rw] = struct{}{}
+			res = append(res, rw)
+		}
+	}
+
+	for _, c := range cfg.Prometheus.Configs {
+		add(c.RemoteWrite)
+	}
+	add(cfg.Prometheus.Global.RemoteWrite)
+	add(cfg.Integrations.PrometheusRemoteWrite)
+	return res
+}
+
+// CheckRemoteWrites sends a single sample of RemoteWriteCheckMetric to each of
+// remoteWrites, using the same HTTP client, authentication and headers as
+// the agent would. Requests aren't retried.
+func CheckRemoteWrites(ctx context.Context, remoteWrites []*promconfig.RemoteWriteConfig, now time.Time) []RemoteWriteCheckResult {
+	req, err := checkWriteRequest(now)
+
+	res := make([]RemoteWriteCheckResult, 0, len(remoteWrites))
+	for i, rw := range remoteWrites {
+		r := RemoteWriteCheckResult{Name: rw.Name}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("remote_write[%d]", i)
+		}
+		if rw.URL != nil {
+			r.URL = rw.URL.String()
+		}
+
+		if err != nil {
+			r.Err = err
+		} else {
+			r.StatusCode, r.Latency, r.Err = checkRemoteWrite(ctx, r.Name, rw, req)
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+func checkRemoteWrite(ctx context.Context, name string, rw *promconfig.RemoteWriteConfig, req []byte) (int, time.Duration, error) {
+	if rw.URL == nil {
+		return 0, 0, errors.New("no url configured")
+	}
+
+	wc, err := remote.NewWriteClient(name, &remote.ClientConfig{
+		URL:              rw.URL,
+		Timeout:          rw.RemoteTimeout,
+		HTTPClientConfig: rw.HTTPClientConfig,
+		SigV4Config:      rw.SigV4Config,
+		Headers:          rw.Headers,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The write client doesn't expose the status code of responses, so it's
+	// recorded by the transport instead.
+	rt := &statusRoundTripper{}
+	if c, ok := wc.(*remote.Client); ok {
+		rt.next = c.Client.Transport
+		if rt.next == nil {
+			rt.next = http.DefaultTransport
+		}
+		c.Client.Transport = rt
+	}
+
+	start := time.Now()
+	err = wc.Store(ctx, req)
+	return rt.statusCode, time.Since(start), err
+}
+
+// checkWriteRequest returns a snappy-compressed write request with a single
+// sample of RemoteWriteCheckMetric at now.
+func checkWriteRequest(now time.Time) ([]byte, error) {
+	wr := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: RemoteWriteCheckMetric}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: timestamp.FromTime(now)}},
+		}},
+	}
+	buf, err := proto.Marshal(wr)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, buf), nil
+}
+
+type statusRoundTripper struct {
+	next       http.RoundTripper
+	statusCode int
+}
+
+func (rt *statusRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req)
+	if resp != nil {
+		rt.statusCode = resp.StatusCode
+	}
+	return resp, err
+}
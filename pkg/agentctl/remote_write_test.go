@@ -0,0 +1,117 @@
+package agentctl
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/agent/pkg/config"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRemoteWrites(t *testing.T) {
+	var received prompb.WriteRequest
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "user" || pass != "pass" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		buf, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		require.NoError(t, proto.Unmarshal(buf, &received))
+	}))
+	defer ok.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	remoteWrites := []*promconfig.RemoteWriteConfig{
+		{
+			Name:          "ok",
+			URL:           mustURL(t, ok.URL),
+			RemoteTimeout: model.Duration(5 * time.Second),
+			HTTPClientConfig: config_util.HTTPClientConfig{
+				BasicAuth: &config_util.BasicAuth{Username: "user", Password: "pass"},
+			},
+		},
+		{
+			Name:          "unauthorized",
+			URL:           mustURL(t, ok.URL),
+			RemoteTimeout: model.Duration(5 * time.Second),
+		},
+		{
+			URL:           mustURL(t, failing.URL),
+			RemoteTimeout: model.Duration(5 * time.Second),
+		},
+	}
+
+	now := time.Unix(1000, 0)
+	res := CheckRemoteWrites(context.Background(), remoteWrites, now)
+	require.Len(t, res, 3)
+
+	require.Equal(t, "ok", res[0].Name)
+	require.Equal(t, ok.URL, res[0].URL)
+	require.Equal(t, http.StatusOK, res[0].StatusCode)
+	require.NoError(t, res[0].Err)
+
+	require.Equal(t, http.StatusUnauthorized, res[1].StatusCode)
+	require.Error(t, res[1].Err)
+
+	require.Equal(t, "remote_write[2]", res[2].Name)
+	require.Equal(t, http.StatusServiceUnavailable, res[2].StatusCode)
+	require.Error(t, res[2].Err)
+
+	require.Len(t, received.Timeseries, 1)
+	require.Equal(t, []prompb.Label{{Name: "__name__", Value: RemoteWriteCheckMetric}}, received.Timeseries[0].Labels)
+	require.Equal(t, []prompb.Sample{{Value: 1, Timestamp: 1000000}}, received.Timeseries[0].Samples)
+}
+
+func TestConfigRemoteWrites(t *testing.T) {
+	cfgText := `
+prometheus:
+  wal_directory: /tmp/wal
+  global:
+    remote_write:
+    - url: http://global:9009/api/prom/push
+  configs:
+  - name: a
+  - name: b
+    remote_write:
+    - url: http://b:9009/api/prom/push
+`
+	var cfg config.Config
+	require.NoError(t, config.LoadBytes([]byte(cfgText), false, &cfg))
+	require.NoError(t, cfg.ApplyDefaults())
+
+	var urls []string
+	for _, rw := range ConfigRemoteWrites(&cfg) {
+		urls = append(urls, rw.URL.String())
+	}
+	require.Equal(t, []string{
+		"http://global:9009/api/prom/push",
+		"http://b:9009/api/prom/push",
+	}, urls)
+}
+
+func mustURL(t *testing.T, u string) *config_util.URL {
+	t.Helper()
+	parsed, err := url.Parse(u)
+	require.NoError(t, err)
+	return &config_util.URL{URL: parsed}
+}
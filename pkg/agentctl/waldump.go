@@ -0,0 +1,162 @@
+package agentctl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// DumpOptions selects the samples written by DumpWAL.
+type DumpOptions struct {
+	// Selector is a label selector for the series to write. All series are
+	// written if empty.
+	Selector string
+
+	// MinTime and MaxTime select the time range of samples to write,
+	// inclusive. Zero values leave the range unbounded.
+	MinTime time.Time
+	MaxTime time.Time
+}
+
+type dumpSeries struct {
+	labels  labels.Labels
+	samples []record.RefSample
+}
+
+// DumpWAL reads the WAL in walDir and writes its series and samples to w
+// using the OpenMetrics text format. Series are grouped by metric name and
+// samples of each series are sorted by timestamp. Series without samples in
+// the selected time range aren't written.
+func DumpWAL(w io.Writer, walDir string, opts DumpOptions) error {
+	selectorStr := opts.Selector
+	if selectorStr == "" {
+		selectorStr = "{}"
+	}
+	selector, err := parser.ParseMetricSelector(selectorStr)
+	if err != nil {
+		return err
+	}
+
+	minT, maxT := timeRange(opts.MinTime, opts.MaxTime)
+
+	wl, err := wal.Open(nil, walDir)
+	if err != nil {
+		return err
+	}
+	defer wl.Close()
+
+	labelsByRef := make(map[uint64]labels.Labels)
+	err = walIterate(wl, func(r *wal.Reader) error {
+		return collectSeries(r, selector, labelsByRef)
+	})
+	if err != nil {
+		return fmt.Errorf("could not collect series: %w", err)
+	}
+
+	// A series may have been assigned multiple refs over time, so samples
+	// are grouped by labels rather than by ref.
+	seriesByLabels := make(map[string]*dumpSeries)
+	err = walIterate(wl, func(r *wal.Reader) error {
+		return iterateSamples(r, labelsByRef, minT, maxT, func(lbls labels.Labels, s record.RefSample) error {
+			key := lbls.String()
+			ds, ok := seriesByLabels[key]
+			if !ok {
+				ds = &dumpSeries{labels: lbls}
+				seriesByLabels[key] = ds
+			}
+			ds.samples = append(ds.samples, s)
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("could not collect samples: %w", err)
+	}
+
+	series := make([]*dumpSeries, 0, len(seriesByLabels))
+	for _, ds := range seriesByLabels {
+		if ds.labels.Get(labels.MetricName) == "" {
+			// OpenMetrics can't represent series without a metric name.
+			continue
+		}
+		series = append(series, ds)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		ni, nj := series[i].labels.Get(labels.MetricName), series[j].labels.Get(labels.MetricName)
+		if ni != nj {
+			return ni < nj
+		}
+		return labels.Compare(series[i].labels, series[j].labels) < 0
+	})
+
+	return writeOpenMetrics(w, series)
+}
+
+// timeRange converts minTime and maxTime to an inclusive range of
+// timestamps. Zero times leave their side of the range unbounded.
+func timeRange(minTime, maxTime time.Time) (minT, maxT int64) {
+	minT, maxT = math.MinInt64, math.MaxInt64
+	if !minTime.IsZero() {
+		minT = timestamp.FromTime(minTime)
+	}
+	if !maxTime.IsZero() {
+		maxT = timestamp.FromTime(maxTime)
+	}
+	return minT, maxT
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeOpenMetrics(w io.Writer, series []*dumpSeries) error {
+	bw := bufio.NewWriter(w)
+
+	var lastName string
+	for _, ds := range series {
+		name := ds.labels.Get(labels.MetricName)
+		if name != lastName {
+			// The WAL doesn't store metric types.
+			fmt.Fprintf(bw, "# TYPE %s unknown\n", name)
+			lastName = name
+		}
+
+		var lbls strings.Builder
+		for _, l := range ds.labels {
+			if l.Name == labels.MetricName {
+				continue
+			}
+			if lbls.Len() > 0 {
+				lbls.WriteByte(',')
+			}
+			lbls.WriteString(l.Name)
+			lbls.WriteString(`="`)
+			lbls.WriteString(labelValueReplacer.Replace(l.Value))
+			lbls.WriteByte('"')
+		}
+		seriesStr := name
+		if lbls.Len() > 0 {
+			seriesStr += "{" + lbls.String() + "}"
+		}
+
+		sort.SliceStable(ds.samples, func(i, j int) bool { return ds.samples[i].T < ds.samples[j].T })
+		for _, s := range ds.samples {
+			fmt.Fprintf(bw, "%s %s %s\n",
+				seriesStr,
+				strconv.FormatFloat(s.V, 'g', -1, 64),
+				strconv.FormatFloat(float64(s.T)/1000, 'f', -1, 64),
+			)
+		}
+	}
+
+	fmt.Fprint(bw, "# EOF\n")
+	return bw.Flush()
+}
@@ -0,0 +1,43 @@
+package agentctl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpWAL(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	var buf bytes.Buffer
+	err := DumpWAL(&buf, walDir, DumpOptions{
+		Selector: `{__name__=~"metric_[01]"}`,
+		MinTime:  timestamp.Time(2),
+		MaxTime:  timestamp.Time(3),
+	})
+	require.NoError(t, err)
+
+	expect := strings.Join([]string{
+		`# TYPE metric_0 unknown`,
+		`metric_0{initial="no",instance="test-instance",job="test-job"} 1 0.002`,
+		`# TYPE metric_1 unknown`,
+		`metric_1{initial="yes",instance="test-instance",job="test-job"} 1 0.003`,
+		`# EOF`,
+	}, "\n") + "\n"
+	require.Equal(t, expect, buf.String())
+}
+
+func TestDumpWAL_AllSeries(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, DumpWAL(&buf, walDir, DumpOptions{}))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	// 10 metrics with a TYPE line and 2 samples each, followed by # EOF.
+	require.Len(t, lines, 10*3+1)
+	require.Equal(t, "# EOF", lines[len(lines)-1])
+}
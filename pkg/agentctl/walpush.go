@@ -0,0 +1,268 @@
+package agentctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"golang.org/x/time/rate"
+)
+
+// DefaultPushOptions holds the default options for PushWAL.
+var DefaultPushOptions = PushOptions{
+	BatchSize:  500,
+	MaxRetries: 10,
+	MinBackoff: time.Second,
+	MaxBackoff: 30 * time.Second,
+}
+
+// PushOptions configures PushWAL.
+type PushOptions struct {
+	// Selector is a label selector for the series to push. All series are
+	// pushed if empty.
+	Selector string
+
+	// MinTime and MaxTime select the time range of samples to push,
+	// inclusive. Zero values leave the range unbounded.
+	MinTime time.Time
+	MaxTime time.Time
+
+	// BatchSize is the maximum number of samples sent per request.
+	BatchSize int
+
+	// SamplesPerSecond limits the rate samples are pushed at. 0 disables
+	// rate limiting.
+	SamplesPerSecond float64
+
+	// MaxRetries is the number of times a request failing with a recoverable
+	// error (5xx or 429 responses) is retried before giving up. Backoff
+	// between retries starts at MinBackoff and doubles up to MaxBackoff.
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Progress, if set, is called after each batch is sent.
+	Progress func(PushProgress)
+}
+
+// PushProgress reports how much of a WAL has been pushed.
+type PushProgress struct {
+	// Samples is the number of samples pushed so far.
+	Samples int
+	// TotalSamples is the number of samples that will be pushed.
+	TotalSamples int
+	// Batches is the number of requests sent so far, excluding retries.
+	Batches int
+	// Retries is the number of requests that have been retried.
+	Retries int
+}
+
+// PushWAL reads the WAL in walDir and pushes its samples to the remote_write
+// endpoint of client. Samples are sent in the order they were written to the
+// WAL, so the endpoint must accept samples as old as the oldest sample in
+// the WAL.
+//
+// The progress at the time PushWAL returns is always returned, so a failed
+// push can be resumed by setting opts.MinTime.
+func PushWAL(ctx context.Context, client remote.WriteClient, walDir string, opts PushOptions) (PushProgress, error) {
+	var progress PushProgress
+
+	if opts.BatchSize <= 0 {
+		return progress, errors.New("batch size must be greater than 0")
+	}
+
+	selectorStr := opts.Selector
+	if selectorStr == "" {
+		selectorStr = "{}"
+	}
+	selector, err := parser.ParseMetricSelector(selectorStr)
+	if err != nil {
+		return progress, err
+	}
+	minT, maxT := timeRange(opts.MinTime, opts.MaxTime)
+
+	wl, err := wal.Open(nil, walDir)
+	if err != nil {
+		return progress, err
+	}
+	defer wl.Close()
+
+	labelsByRef := make(map[uint64]labels.Labels)
+	err = walIterate(wl, func(r *wal.Reader) error {
+		return collectSeries(r, selector, labelsByRef)
+	})
+	if err != nil {
+		return progress, fmt.Errorf("could not collect series: %w", err)
+	}
+
+	// Samples are counted up front so progress can be reported against the
+	// total.
+	err = walIterate(wl, func(r *wal.Reader) error {
+		return iterateSamples(r, labelsByRef, minT, maxT, func(labels.Labels, record.RefSample) error {
+			progress.TotalSamples++
+			return nil
+		})
+	})
+	if err != nil {
+		return progress, fmt.Errorf("could not count samples: %w", err)
+	}
+
+	p := &walPusher{
+		client:   client,
+		opts:     opts,
+		progress: &progress,
+	}
+	if opts.SamplesPerSecond > 0 {
+		p.limiter = rate.NewLimiter(rate.Limit(opts.SamplesPerSecond), opts.BatchSize)
+	}
+
+	err = walIterate(wl, func(r *wal.Reader) error {
+		return iterateSamples(r, labelsByRef, minT, maxT, func(lbls labels.Labels, s record.RefSample) error {
+			p.add(lbls, s)
+			if p.pending < opts.BatchSize {
+				return nil
+			}
+			return p.flush(ctx)
+		})
+	})
+	if err == nil {
+		err = p.flush(ctx)
+	}
+	return progress, err
+}
+
+// iterateSamples calls f for each sample in r of a series in labelsByRef
+// with a timestamp between minT and maxT.
+func iterateSamples(r *wal.Reader, labelsByRef map[uint64]labels.Labels, minT, maxT int64, f func(labels.Labels, record.RefSample) error) error {
+	var dec record.Decoder
+	for r.Next() {
+		rec := r.Record()
+		if dec.Type(rec) != record.Samples {
+			continue
+		}
+
+		samples, err := dec.Samples(rec, nil)
+		if err != nil {
+			return err
+		}
+		for _, s := range samples {
+			lbls, ok := labelsByRef[s.Ref]
+			if !ok || s.T < minT || s.T > maxT {
+				continue
+			}
+			if err := f(lbls, s); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
+// walPusher batches samples into write requests.
+type walPusher struct {
+	client   remote.WriteClient
+	opts     PushOptions
+	limiter  *rate.Limiter
+	progress *PushProgress
+
+	// series holds the pending samples of the current batch, grouped by the
+	// labels of their series.
+	series  map[string]*prompb.TimeSeries
+	order   []string
+	pending int
+}
+
+func (p *walPusher) add(lbls labels.Labels, s record.RefSample) {
+	if p.series == nil {
+		p.series = make(map[string]*prompb.TimeSeries)
+	}
+
+	key := lbls.String()
+	ts, ok := p.series[key]
+	if !ok {
+		ts = &prompb.TimeSeries{Labels: labelsToLabelsProto(lbls)}
+		p.series[key] = ts
+		p.order = append(p.order, key)
+	}
+	ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: s.T, Value: s.V})
+	p.pending++
+}
+
+func (p *walPusher) flush(ctx context.Context) error {
+	if p.pending == 0 {
+		return nil
+	}
+
+	wr := prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(p.order))}
+	for _, key := range p.order {
+		wr.Timeseries = append(wr.Timeseries, *p.series[key])
+	}
+	buf, err := proto.Marshal(&wr)
+	if err != nil {
+		return err
+	}
+	req := snappy.Encode(nil, buf)
+
+	if p.limiter != nil {
+		if err := p.limiter.WaitN(ctx, p.pending); err != nil {
+			return err
+		}
+	}
+	if err := p.store(ctx, req); err != nil {
+		return err
+	}
+
+	p.progress.Samples += p.pending
+	p.progress.Batches++
+	if p.opts.Progress != nil {
+		p.opts.Progress(*p.progress)
+	}
+
+	p.series, p.order, p.pending = nil, nil, 0
+	return nil
+}
+
+// store sends req, retrying recoverable errors with exponential backoff.
+func (p *walPusher) store(ctx context.Context, req []byte) error {
+	backoff := p.opts.MinBackoff
+	for try := 0; ; try++ {
+		err := p.client.Store(ctx, req)
+		if err == nil {
+			return nil
+		}
+
+		var recoverable remote.RecoverableError
+		if !errors.As(err, &recoverable) || try >= p.opts.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		p.progress.Retries++
+
+		backoff *= 2
+		if p.opts.MaxBackoff > 0 && backoff > p.opts.MaxBackoff {
+			backoff = p.opts.MaxBackoff
+		}
+	}
+}
+
+func labelsToLabelsProto(lbls labels.Labels) []prompb.Label {
+	res := make([]prompb.Label, 0, len(lbls))
+	for _, l := range lbls {
+		res = append(res, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
@@ -0,0 +1,153 @@
+package agentctl
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushWAL(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	var (
+		mut      sync.Mutex
+		requests int
+		samples  = make(map[string][]prompb.Sample)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		requests++
+		if requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		buf, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+
+		var req prompb.WriteRequest
+		require.NoError(t, proto.Unmarshal(buf, &req))
+		for _, ts := range req.Timeseries {
+			var name string
+			for _, l := range ts.Labels {
+				if l.Name == "__name__" {
+					name = l.Value
+				}
+			}
+			samples[name] = append(samples[name], ts.Samples...)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := remote.NewWriteClient("test", &remote.ClientConfig{
+		URL:     mustURL(t, srv.URL),
+		Timeout: model.Duration(5 * time.Second),
+	})
+	require.NoError(t, err)
+
+	var reports []PushProgress
+
+	opts := DefaultPushOptions
+	opts.BatchSize = 8
+	opts.MinBackoff = time.Millisecond
+	opts.Progress = func(p PushProgress) { reports = append(reports, p) }
+
+	progress, err := PushWAL(context.Background(), client, walDir, opts)
+	require.NoError(t, err)
+	require.Equal(t, PushProgress{Samples: 20, TotalSamples: 20, Batches: 3, Retries: 1}, progress)
+	require.Len(t, reports, 3)
+	require.Equal(t, 8, reports[0].Samples)
+
+	// Each metric has two series with one sample each.
+	require.Len(t, samples, 10)
+	for name, ss := range samples {
+		require.Len(t, ss, 2, name)
+	}
+}
+
+func TestPushWAL_Filtered(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	var received []prompb.TimeSeries
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		buf, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+
+		var req prompb.WriteRequest
+		require.NoError(t, proto.Unmarshal(buf, &req))
+		received = append(received, req.Timeseries...)
+	}))
+	defer srv.Close()
+
+	client, err := remote.NewWriteClient("test", &remote.ClientConfig{
+		URL:     mustURL(t, srv.URL),
+		Timeout: model.Duration(5 * time.Second),
+	})
+	require.NoError(t, err)
+
+	opts := DefaultPushOptions
+	opts.Selector = `{__name__=~"metric_[01]"}`
+	opts.MinTime = timestamp.Time(2)
+	opts.MaxTime = timestamp.Time(3)
+
+	progress, err := PushWAL(context.Background(), client, walDir, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, progress.Samples)
+	require.Equal(t, []prompb.TimeSeries{
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "metric_0"},
+				{Name: "initial", Value: "no"},
+				{Name: "instance", Value: "test-instance"},
+				{Name: "job", Value: "test-job"},
+			},
+			Samples: []prompb.Sample{{Timestamp: 2, Value: 1}},
+		},
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "metric_1"},
+				{Name: "initial", Value: "yes"},
+				{Name: "instance", Value: "test-instance"},
+				{Name: "job", Value: "test-job"},
+			},
+			Samples: []prompb.Sample{{Timestamp: 3, Value: 1}},
+		},
+	}, received)
+}
+
+func TestPushWAL_NonRecoverableError(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	client, err := remote.NewWriteClient("test", &remote.ClientConfig{
+		URL:     mustURL(t, srv.URL),
+		Timeout: model.Duration(5 * time.Second),
+	})
+	require.NoError(t, err)
+
+	progress, err := PushWAL(context.Background(), client, walDir, DefaultPushOptions)
+	require.Error(t, err)
+	require.Equal(t, PushProgress{TotalSamples: 20}, progress)
+}
@@ -23,8 +23,14 @@ type Client struct {
 
 // New creates a new Client.
 func New(addr string) *Client {
+	return NewWithHeader(addr, nil)
+}
+
+// NewWithHeader creates a new Client that sends header with every request,
+// such as the role header required by a config API with authorization.
+func NewWithHeader(addr string, header http.Header) *Client {
 	return &Client{
-		PrometheusClient: &prometheusClient{addr: addr},
+		PrometheusClient: &prometheusClient{addr: addr, header: header},
 	}
 }
 
@@ -55,7 +61,8 @@ type PrometheusClient interface {
 }
 
 type prometheusClient struct {
-	addr string
+	addr   string
+	header http.Header
 }
 
 func (c *prometheusClient) Instances(ctx context.Context) ([]string, error) {
@@ -134,6 +141,9 @@ func (c *prometheusClient) doRequest(ctx context.Context, method string, url str
 	if err != nil {
 		return nil, err
 	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
 	return http.DefaultClient.Do(req)
 }
 
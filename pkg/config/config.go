@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/weaveworks/common/server"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/grafana/agent/pkg/integrations"
 	"github.com/grafana/agent/pkg/loki"
 	"github.com/grafana/agent/pkg/prom"
+	"github.com/grafana/agent/pkg/scrub"
 	"github.com/grafana/agent/pkg/tempo"
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/version"
@@ -23,6 +25,7 @@ var DefaultConfig = Config{
 	// All subsystems with a DefaultConfig should be listed here.
 	Prometheus:   prom.DefaultConfig,
 	Integrations: integrations.DefaultManagerConfig,
+	Metrics:      DefaultMetricsConfig,
 }
 
 // Config contains underlying configurations for the agent
@@ -33,11 +36,63 @@ type Config struct {
 	Integrations integrations.ManagerConfig `yaml:"integrations,omitempty"`
 	Tempo        tempo.Config               `yaml:"tempo,omitempty"`
 
+	// Scrubbing hashes or removes sensitive labels from metrics, logs and
+	// traces before they leave the Agent. Instances may override it.
+	Scrubbing *scrub.Config `yaml:"scrubbing,omitempty"`
+
 	// We support a secondary server just for the /-/reload endpoint, since
 	// invoking /-/reload against the primary server can cause the server
 	// to restart.
 	ReloadAddress string `yaml:"-"`
 	ReloadPort    int    `yaml:"-"`
+
+	// Metrics controls how subsystems expose the Agent's own metrics.
+	Metrics MetricsConfig `yaml:"-"`
+}
+
+// DefaultMetricsConfig holds the default settings for MetricsConfig.
+var DefaultMetricsConfig = MetricsConfig{
+	PrometheusPrefix:   "agent_prom_",
+	LokiPrefix:         "agent_logs_",
+	TempoPrefix:        "agent_tempo_",
+	IntegrationsPrefix: "agent_integrations_",
+
+	UsageProfileDuration: time.Second,
+}
+
+// MetricsConfig controls how subsystems expose the Agent's own metrics. It
+// can only be set by flags since subsystems register their metrics once at
+// startup.
+type MetricsConfig struct {
+	// SubsystemPrefixes registers the metrics of each subsystem into its own
+	// registry, exposing them with the subsystem's prefix.
+	SubsystemPrefixes  bool
+	PrometheusPrefix   string
+	LokiPrefix         string
+	TempoPrefix        string
+	IntegrationsPrefix string
+
+	// DropHighCardinality removes util.HighCardinalityMetrics from the
+	// Agent's metrics.
+	DropHighCardinality bool
+
+	// How often to sample the CPU time and goroutines of each instance and
+	// integration, and how long to profile the CPU for each sample. 0
+	// disables sampling. Profiles take at most a tenth of the interval.
+	UsageSampleInterval  time.Duration
+	UsageProfileDuration time.Duration
+}
+
+// RegisterFlags registers flags for the MetricsConfig.
+func (c *MetricsConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.SubsystemPrefixes, "metrics.subsystem-prefixes", false, "expose the metrics of each subsystem with the subsystem's prefix")
+	f.StringVar(&c.PrometheusPrefix, "metrics.prometheus-prefix", DefaultMetricsConfig.PrometheusPrefix, "prefix of prometheus metrics when -metrics.subsystem-prefixes is set")
+	f.StringVar(&c.LokiPrefix, "metrics.loki-prefix", DefaultMetricsConfig.LokiPrefix, "prefix of loki metrics when -metrics.subsystem-prefixes is set")
+	f.StringVar(&c.TempoPrefix, "metrics.tempo-prefix", DefaultMetricsConfig.TempoPrefix, "prefix of tempo metrics when -metrics.subsystem-prefixes is set")
+	f.StringVar(&c.IntegrationsPrefix, "metrics.integrations-prefix", DefaultMetricsConfig.IntegrationsPrefix, "prefix of integrations metrics when -metrics.subsystem-prefixes is set")
+	f.BoolVar(&c.DropHighCardinality, "metrics.drop-high-cardinality", false, "drop internal metrics with a series per file, scrape job, or discovered target group")
+	f.DurationVar(&c.UsageSampleInterval, "metrics.usage-sample-interval", 0, "how often to sample the CPU time and goroutines of each instance and integration. 0 disables sampling")
+	f.DurationVar(&c.UsageProfileDuration, "metrics.usage-profile-duration", DefaultMetricsConfig.UsageProfileDuration, "how long to profile the CPU for each usage sample, capped to a tenth of -metrics.usage-sample-interval")
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -49,6 +104,8 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // ApplyDefaults sets default values in the config
 func (c *Config) ApplyDefaults() error {
+	c.applyScrubbing()
+
 	if err := c.Prometheus.ApplyDefaults(); err != nil {
 		return err
 	}
@@ -70,12 +127,33 @@ func (c *Config) ApplyDefaults() error {
 	return nil
 }
 
+// applyScrubbing passes the scrubbing config to the instances of every
+// subsystem that don't set their own.
+func (c *Config) applyScrubbing() {
+	if c.Scrubbing == nil {
+		return
+	}
+
+	c.Prometheus.Global.Scrubbing = c.Scrubbing
+	for _, ic := range c.Loki.Configs {
+		if ic.Scrubbing == nil {
+			ic.Scrubbing = c.Scrubbing
+		}
+	}
+	for i := range c.Tempo.Configs {
+		if c.Tempo.Configs[i].Scrubbing == nil {
+			c.Tempo.Configs[i].Scrubbing = c.Scrubbing
+		}
+	}
+}
+
 // RegisterFlags registers flags in underlying configs
 func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	c.Server.MetricsNamespace = "agent"
 	c.Server.RegisterInstrumentation = true
 	c.Prometheus.RegisterFlags(f)
 	c.Server.RegisterFlags(f)
+	c.Metrics.RegisterFlags(f)
 
 	f.StringVar(&c.ReloadAddress, "reload-addr", "127.0.0.1", "address to expose a secondary server for /-/reload on.")
 	f.IntVar(&c.ReloadPort, "reload-port", 0, "port to expose a secondary server for /-/reload on. 0 disables secondary server.")
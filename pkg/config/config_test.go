@@ -78,6 +78,24 @@ prometheus:
 	require.Equal(t, expect, c.Prometheus.Global)
 }
 
+func TestConfig_OverrideByEnvironmentOnLoad_Defaults(t *testing.T) {
+	cfg := `
+prometheus:
+  wal_directory: ${AGENT_TEST_UNSET_WAL_DIR:-/tmp/wal}
+  global:
+    external_labels:
+      replacement: $${1}`
+	_ = os.Unsetenv("AGENT_TEST_UNSET_WAL_DIR")
+
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	c, err := load(fs, []string{"-config.file", "test"}, func(_ string, _ bool, c *Config) error {
+		return LoadBytes([]byte(cfg), true, c)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/wal", c.Prometheus.WALDir)
+	require.Equal(t, "${1}", c.Prometheus.Global.Prometheus.ExternalLabels.Get("replacement"))
+}
+
 func TestConfig_FlagsAreAccepted(t *testing.T) {
 	cfg := `
 prometheus:
@@ -131,3 +149,39 @@ func TestConfig_Defaults(t *testing.T) {
 	require.Equal(t, prom.DefaultConfig, c.Prometheus)
 	require.Equal(t, integrations.DefaultManagerConfig, c.Integrations)
 }
+
+func TestConfig_Scrubbing(t *testing.T) {
+	cfg := `
+scrubbing:
+  salt: secret
+  rules:
+    - labels: [user_id]
+prometheus:
+  wal_directory: /tmp/wal
+  configs:
+    - name: default
+    - name: override
+      scrubbing:
+        rules:
+          - labels: [client_ip]
+            action: drop
+loki:
+  positions_directory: /tmp/positions
+  configs:
+    - name: default
+tempo:
+  configs:
+    - name: default
+`
+
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	c, err := load(fs, []string{"-config.file", "test"}, func(_ string, _ bool, c *Config) error {
+		return LoadBytes([]byte(cfg), false, c)
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, c.Scrubbing, c.Prometheus.Configs[0].Scrubbing)
+	require.Equal(t, []string{"client_ip"}, c.Prometheus.Configs[1].Scrubbing.Rules[0].Labels)
+	require.Equal(t, c.Scrubbing, c.Loki.Configs[0].Scrubbing)
+	require.Equal(t, c.Scrubbing, c.Tempo.Configs[0].Scrubbing)
+}
@@ -22,6 +22,10 @@ type Common struct {
 	RelabelConfigs       []*relabel.Config `yaml:"relabel_configs,omitempty"`
 	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs,omitempty"`
 	WALTruncateFrequency time.Duration     `yaml:"wal_truncate_frequency,omitempty"`
+	MinWALTime           time.Duration     `yaml:"min_wal_time,omitempty"`
+	MaxWALTime           time.Duration     `yaml:"max_wal_time,omitempty"`
+	OutOfOrderTolerance  time.Duration     `yaml:"out_of_order_tolerance,omitempty"`
+	DropStaleMarkers     bool              `yaml:"drop_stale_markers,omitempty"`
 }
 
 // ScrapeConfig is a subset of options used by integrations to inform how samples
@@ -53,6 +53,11 @@ func (c *Config) Name() string {
 	return "consul_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/prometheus/consul_exporter"
+}
+
 // CommonConfig returns the common set of settings for this integration.
 func (c *Config) CommonConfig() config.Common {
 	return c.Common
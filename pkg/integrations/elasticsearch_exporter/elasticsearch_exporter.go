@@ -77,6 +77,11 @@ func (c *Config) Name() string {
 	return "elasticsearch_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/justwatchcom/elasticsearch_exporter"
+}
+
 // CommonConfig returns the common settings shared across all configs for
 // integrations.
 func (c *Config) CommonConfig() config.Common {
@@ -0,0 +1,179 @@
+package host_facts //nolint:golint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Base URLs of the metadata services of each cloud provider.
+const (
+	awsMetadataURL   = "http://169.254.169.254"
+	gcpMetadataURL   = "http://metadata.google.internal"
+	azureMetadataURL = "http://169.254.169.254"
+)
+
+// cloudDetector detects the cloud provider of the host through the metadata
+// services of the supported providers, which are only reachable from
+// instances of that provider.
+type cloudDetector struct {
+	client  *http.Client
+	timeout time.Duration
+
+	awsURL, gcpURL, azureURL string
+}
+
+func newCloudDetector(timeout time.Duration) *cloudDetector {
+	return &cloudDetector{
+		// Metadata services must never be reached through a proxy.
+		client:  &http.Client{Transport: &http.Transport{Proxy: nil}},
+		timeout: timeout,
+
+		awsURL:   awsMetadataURL,
+		gcpURL:   gcpMetadataURL,
+		azureURL: azureMetadataURL,
+	}
+}
+
+// detect queries all metadata services at once and returns the facts of the
+// first provider that responded, or an error if none did.
+func (d *cloudDetector) detect(ctx context.Context) (*cloudFacts, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	providers := []func(context.Context) (*cloudFacts, error){d.aws, d.gcp, d.azure}
+
+	type result struct {
+		facts *cloudFacts
+		err   error
+	}
+	results := make([]chan result, len(providers))
+	for i, p := range providers {
+		results[i] = make(chan result, 1)
+		go func(p func(context.Context) (*cloudFacts, error), ch chan<- result) {
+			f, err := p(ctx)
+			ch <- result{facts: f, err: err}
+		}(p, results[i])
+	}
+
+	var errs []string
+	for _, ch := range results {
+		res := <-ch
+		if res.err == nil {
+			return res.facts, nil
+		}
+		errs = append(errs, res.err.Error())
+	}
+	return nil, errors.New(strings.Join(errs, "; "))
+}
+
+func (d *cloudDetector) aws(ctx context.Context) (*cloudFacts, error) {
+	// Get a token for IMDSv2. Instances which only allow IMDSv1 don't
+	// require one.
+	header := http.Header{}
+	token, err := d.request(ctx, http.MethodPut, d.awsURL+"/latest/api/token", http.Header{
+		"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": []string{"60"},
+	})
+	if err == nil {
+		header.Set("X-Aws-Ec2-Metadata-Token", token)
+	}
+
+	f := &cloudFacts{Provider: "aws"}
+	for _, v := range []struct {
+		path string
+		dst  *string
+	}{
+		{"instance-type", &f.InstanceType},
+		{"placement/region", &f.Region},
+		{"placement/availability-zone", &f.Zone},
+	} {
+		*v.dst, err = d.request(ctx, http.MethodGet, d.awsURL+"/latest/meta-data/"+v.path, header)
+		if err != nil {
+			return nil, fmt.Errorf("aws: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func (d *cloudDetector) gcp(ctx context.Context) (*cloudFacts, error) {
+	header := http.Header{"Metadata-Flavor": []string{"Google"}}
+
+	// Both values are resource names, such as
+	// projects/123/machineTypes/n1-standard-1, of which only the last part is
+	// kept.
+	machineType, err := d.request(ctx, http.MethodGet, d.gcpURL+"/computeMetadata/v1/instance/machine-type", header)
+	if err != nil {
+		return nil, fmt.Errorf("gcp: %w", err)
+	}
+	zone, err := d.request(ctx, http.MethodGet, d.gcpURL+"/computeMetadata/v1/instance/zone", header)
+	if err != nil {
+		return nil, fmt.Errorf("gcp: %w", err)
+	}
+
+	f := &cloudFacts{
+		Provider:     "gcp",
+		InstanceType: path.Base(machineType),
+		Zone:         path.Base(zone),
+	}
+	if i := strings.LastIndex(f.Zone, "-"); i > 0 {
+		f.Region = f.Zone[:i]
+	}
+	return f, nil
+}
+
+func (d *cloudDetector) azure(ctx context.Context) (*cloudFacts, error) {
+	body, err := d.request(ctx, http.MethodGet, d.azureURL+"/metadata/instance/compute?api-version=2021-02-01&format=json", http.Header{
+		"Metadata": []string{"true"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("azure: %w", err)
+	}
+
+	var compute struct {
+		VMSize   string `json:"vmSize"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := json.Unmarshal([]byte(body), &compute); err != nil {
+		return nil, fmt.Errorf("azure: failed to decode instance metadata: %w", err)
+	}
+
+	return &cloudFacts{
+		Provider:     "azure",
+		InstanceType: compute.VMSize,
+		Region:       compute.Location,
+		Zone:         compute.Zone,
+	}, nil
+}
+
+// request sends a request to a metadata service and returns the body of the
+// response.
+func (d *cloudDetector) request(ctx context.Context, method, url string, header http.Header) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = header
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: unexpected status code %d", method, url, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
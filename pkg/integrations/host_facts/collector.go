@@ -0,0 +1,115 @@
+package host_facts //nolint:golint
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/grafana/agent/pkg/build"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector exposes the last gathered facts. Nothing is exposed until facts
+// have been gathered once.
+type collector struct {
+	mut   sync.RWMutex
+	facts *facts
+
+	osInfo      *prometheus.Desc
+	kernelInfo  *prometheus.Desc
+	cpuInfo     *prometheus.Desc
+	cpuCount    *prometheus.Desc
+	cloudInfo   *prometheus.Desc
+	agentInfo   *prometheus.Desc
+	lastRefresh *prometheus.Desc
+}
+
+func newCollector() *collector {
+	return &collector{
+		osInfo: prometheus.NewDesc(
+			"host_facts_os_info",
+			"Operating system of the host. os and arch are the platform the Agent was built for.",
+			[]string{"os", "arch", "id", "name", "version", "pretty_name"},
+			nil,
+		),
+		kernelInfo: prometheus.NewDesc(
+			"host_facts_kernel_info",
+			"Kernel of the host. machine is the hardware architecture reported by the kernel.",
+			[]string{"release", "version", "machine"},
+			nil,
+		),
+		cpuInfo: prometheus.NewDesc(
+			"host_facts_cpu_info",
+			"Vendor and model of the CPUs of the host.",
+			[]string{"vendor", "model"},
+			nil,
+		),
+		cpuCount: prometheus.NewDesc(
+			"host_facts_cpu_count",
+			"Number of logical CPUs usable by the Agent.",
+			nil,
+			nil,
+		),
+		cloudInfo: prometheus.NewDesc(
+			"host_facts_cloud_info",
+			"Cloud provider, instance type, region and zone of the host. Only exposed when running in a supported cloud.",
+			[]string{"provider", "instance_type", "region", "zone"},
+			nil,
+		),
+		agentInfo: prometheus.NewDesc(
+			"host_facts_agent_info",
+			"Version of the Agent running on the host.",
+			[]string{"version", "revision", "goversion"},
+			nil,
+		),
+		lastRefresh: prometheus.NewDesc(
+			"host_facts_last_refresh_timestamp_seconds",
+			"Unix timestamp of when facts were last gathered.",
+			nil,
+			nil,
+		),
+	}
+}
+
+// set replaces the exposed facts.
+func (c *collector) set(f facts) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.facts = &f
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.osInfo
+	ch <- c.kernelInfo
+	ch <- c.cpuInfo
+	ch <- c.cpuCount
+	ch <- c.cloudInfo
+	ch <- c.agentInfo
+	ch <- c.lastRefresh
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	f := c.facts
+	if f == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.osInfo, prometheus.GaugeValue, 1,
+		runtime.GOOS, runtime.GOARCH, f.OS.ID, f.OS.Name, f.OS.Version, f.OS.PrettyName)
+	ch <- prometheus.MustNewConstMetric(c.kernelInfo, prometheus.GaugeValue, 1,
+		f.Kernel.Release, f.Kernel.Version, f.Kernel.Machine)
+	ch <- prometheus.MustNewConstMetric(c.cpuInfo, prometheus.GaugeValue, 1,
+		f.CPU.Vendor, f.CPU.Model)
+	ch <- prometheus.MustNewConstMetric(c.cpuCount, prometheus.GaugeValue, float64(runtime.NumCPU()))
+	if f.Cloud != nil {
+		ch <- prometheus.MustNewConstMetric(c.cloudInfo, prometheus.GaugeValue, 1,
+			f.Cloud.Provider, f.Cloud.InstanceType, f.Cloud.Region, f.Cloud.Zone)
+	}
+	ch <- prometheus.MustNewConstMetric(c.agentInfo, prometheus.GaugeValue, 1,
+		build.Version, build.Revision, runtime.Version())
+	ch <- prometheus.MustNewConstMetric(c.lastRefresh, prometheus.GaugeValue, float64(f.Gathered.Unix()))
+}
@@ -0,0 +1,158 @@
+package host_facts //nolint:golint
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// facts describes the host. Facts which can't be determined are left empty.
+type facts struct {
+	OS     osFacts
+	Kernel kernelFacts
+	CPU    cpuFacts
+	Cloud  *cloudFacts
+
+	Gathered time.Time
+}
+
+type osFacts struct {
+	// ID and Name identify the distribution or product, such as "ubuntu" and
+	// "Ubuntu".
+	ID         string
+	Name       string
+	Version    string
+	PrettyName string
+}
+
+type kernelFacts struct {
+	Release string
+	Version string
+	// Machine is the hardware architecture reported by the kernel, which may
+	// differ from the architecture the Agent was built for.
+	Machine string
+}
+
+type cpuFacts struct {
+	Vendor string
+	Model  string
+}
+
+type cloudFacts struct {
+	Provider     string
+	InstanceType string
+	Region       string
+	Zone         string
+}
+
+// gatherer gathers facts about the host.
+type gatherer struct {
+	log        log.Logger
+	procfsPath string
+	rootfsPath string
+	cloud      *cloudDetector
+}
+
+func (g *gatherer) gather(ctx context.Context) facts {
+	var f facts
+
+	if err := g.gatherOS(&f); err != nil {
+		level.Warn(g.log).Log("msg", "failed to gather some OS facts", "err", err)
+	}
+	if err := g.gatherCPU(&f); err != nil {
+		level.Warn(g.log).Log("msg", "failed to gather some CPU facts", "err", err)
+	}
+	if g.cloud != nil {
+		// Failing to reach any metadata service is expected outside of
+		// clouds, so it's not logged as a warning.
+		cloud, err := g.cloud.detect(ctx)
+		if err != nil {
+			level.Debug(g.log).Log("msg", "no cloud metadata service found", "err", err)
+		}
+		f.Cloud = cloud
+	}
+
+	if f.OS.ID == "" {
+		f.OS.ID = runtime.GOOS
+	}
+	f.Gathered = time.Now()
+	return f
+}
+
+// parseOSRelease parses an os-release file, as documented in os-release(5).
+func parseOSRelease(r io.Reader) (osFacts, error) {
+	vars := make(map[string]string)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		vars[kv[0]] = unquote(kv[1])
+	}
+
+	return osFacts{
+		ID:         vars["ID"],
+		Name:       vars["NAME"],
+		Version:    vars["VERSION_ID"],
+		PrettyName: vars["PRETTY_NAME"],
+	}, s.Err()
+}
+
+// unquote removes the shell quoting from an os-release value.
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		v = v[1 : len(v)-1]
+	}
+	return strings.NewReplacer(`\"`, `"`, `\'`, `'`, `\$`, `$`, "\\`", "`", `\\`, `\`).Replace(v)
+}
+
+// cpuModelKeys are the keys of /proc/cpuinfo holding the CPU model, in order
+// of preference. Architectures other than x86 use different keys, and most
+// arm64 kernels don't report a model at all.
+var cpuModelKeys = []string{
+	"model name", // x86, some arm64
+	"Processor",  // 32-bit arm
+	"cpu",        // ppc64
+	"Model",      // Raspberry Pi
+	"Hardware",   // arm SoCs
+}
+
+// parseCPUInfo parses the vendor and model of the first CPU of a
+// /proc/cpuinfo file. s390x lists its CPUs differently and only reports a
+// vendor.
+func parseCPUInfo(r io.Reader) (cpuFacts, error) {
+	vars := make(map[string]string)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		if _, ok := vars[key]; !ok {
+			vars[key] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	f := cpuFacts{Vendor: vars["vendor_id"]}
+	for _, key := range cpuModelKeys {
+		if v := vars[key]; v != "" {
+			f.Model = v
+			break
+		}
+	}
+	return f, s.Err()
+}
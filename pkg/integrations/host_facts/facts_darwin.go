@@ -0,0 +1,42 @@
+// +build darwin
+
+package host_facts //nolint:golint
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func (g *gatherer) gatherOS(f *facts) error {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return fmt.Errorf("uname failed: %w", err)
+	}
+	f.Kernel = kernelFacts{
+		Release: unix.ByteSliceToString(uname.Release[:]),
+		Version: unix.ByteSliceToString(uname.Version[:]),
+		Machine: unix.ByteSliceToString(uname.Machine[:]),
+	}
+
+	f.OS = osFacts{ID: "macos", Name: "macOS"}
+	version, err := unix.Sysctl("kern.osproductversion")
+	if err != nil {
+		return fmt.Errorf("failed to get macOS version: %w", err)
+	}
+	f.OS.Version = version
+	f.OS.PrettyName = "macOS " + version
+	return nil
+}
+
+func (g *gatherer) gatherCPU(f *facts) error {
+	model, err := unix.Sysctl("machdep.cpu.brand_string")
+	if err != nil {
+		return fmt.Errorf("failed to get CPU model: %w", err)
+	}
+	f.CPU.Model = model
+
+	// Apple silicon doesn't report a vendor.
+	f.CPU.Vendor, _ = unix.Sysctl("machdep.cpu.vendor")
+	return nil
+}
@@ -0,0 +1,53 @@
+// +build linux
+
+package host_facts //nolint:golint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// osReleasePaths are the paths to the os-release file relative to the root
+// filesystem, in order of preference.
+var osReleasePaths = []string{"etc/os-release", "usr/lib/os-release"}
+
+func (g *gatherer) gatherOS(f *facts) error {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return fmt.Errorf("uname failed: %w", err)
+	}
+	f.Kernel = kernelFacts{
+		Release: unix.ByteSliceToString(uname.Release[:]),
+		Version: unix.ByteSliceToString(uname.Version[:]),
+		Machine: unix.ByteSliceToString(uname.Machine[:]),
+	}
+
+	for _, path := range osReleasePaths {
+		file, err := os.Open(filepath.Join(g.rootfsPath, path))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		f.OS, err = parseOSRelease(file)
+		return err
+	}
+	return errors.New("no os-release file found")
+}
+
+func (g *gatherer) gatherCPU(f *facts) error {
+	file, err := os.Open(filepath.Join(g.procfsPath, "cpuinfo"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	f.CPU, err = parseCPUInfo(file)
+	return err
+}
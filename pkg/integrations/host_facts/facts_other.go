@@ -0,0 +1,8 @@
+// +build !linux,!darwin,!windows
+
+package host_facts //nolint:golint
+
+// Only the OS the Agent was built for is known on other platforms.
+
+func (g *gatherer) gatherOS(f *facts) error  { return nil }
+func (g *gatherer) gatherCPU(f *facts) error { return nil }
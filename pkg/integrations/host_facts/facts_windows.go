@@ -0,0 +1,78 @@
+// +build windows
+
+package host_facts //nolint:golint
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+func (g *gatherer) gatherOS(f *facts) error {
+	v := windows.RtlGetVersion()
+	f.Kernel = kernelFacts{
+		Release: fmt.Sprintf("%d.%d.%d", v.MajorVersion, v.MinorVersion, v.BuildNumber),
+		Machine: machine(),
+	}
+
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open CurrentVersion registry key: %w", err)
+	}
+	defer k.Close()
+
+	f.OS = osFacts{ID: "windows"}
+	f.OS.Name, _, err = k.GetStringValue("ProductName")
+	if err != nil {
+		return fmt.Errorf("failed to get ProductName: %w", err)
+	}
+
+	// DisplayVersion (such as 21H1) replaced ReleaseId (such as 2009) in
+	// Windows 10 20H2.
+	f.OS.Version, _, err = k.GetStringValue("DisplayVersion")
+	if err != nil {
+		f.OS.Version, _, _ = k.GetStringValue("ReleaseId")
+	}
+	f.OS.PrettyName = f.OS.Name
+	if f.OS.Version != "" {
+		f.OS.PrettyName += " " + f.OS.Version
+	}
+
+	// The update build revision is the last part of the full build number,
+	// such as 19043.1083.
+	if ubr, _, err := k.GetIntegerValue("UBR"); err == nil {
+		f.Kernel.Version = f.Kernel.Release + "." + strconv.FormatUint(ubr, 10)
+	}
+	return nil
+}
+
+// machine returns the architecture of the host, such as AMD64 or ARM64, which
+// is different from the architecture of the Agent when it runs under
+// emulation.
+func machine() string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Session Manager\Environment`, registry.QUERY_VALUE)
+	if err != nil {
+		return ""
+	}
+	defer k.Close()
+
+	arch, _, _ := k.GetStringValue("PROCESSOR_ARCHITECTURE")
+	return arch
+}
+
+func (g *gatherer) gatherCPU(f *facts) error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DESCRIPTION\System\CentralProcessor\0`, registry.QUERY_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open CentralProcessor registry key: %w", err)
+	}
+	defer k.Close()
+
+	f.CPU.Model, _, err = k.GetStringValue("ProcessorNameString")
+	if err != nil {
+		return fmt.Errorf("failed to get ProcessorNameString: %w", err)
+	}
+	f.CPU.Vendor, _, _ = k.GetStringValue("VendorIdentifier")
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package host_facts exposes slow-changing inventory of the host the Agent
+// runs on, such as its OS version, kernel, CPU model and cloud instance type,
+// and the version of the Agent, as info metrics.
+package host_facts //nolint:golint
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/integrations"
+	"github.com/grafana/agent/pkg/integrations/config"
+)
+
+// DefaultConfig holds non-zero default options for the Config when it is
+// unmarshaled from YAML.
+var DefaultConfig = Config{
+	RefreshInterval:      time.Hour,
+	ProcFSPath:           "/proc",
+	RootFSPath:           "/",
+	CloudMetadata:        true,
+	CloudMetadataTimeout: 2 * time.Second,
+}
+
+// Config controls the host_facts integration.
+type Config struct {
+	Common config.Common `yaml:",inline"`
+
+	// How often facts are gathered. Scrapes in between expose the last
+	// gathered facts.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+
+	// Paths to the proc and root filesystems of the host, for Agents running
+	// in a container. Only used on Linux.
+	ProcFSPath string `yaml:"procfs_path,omitempty"`
+	RootFSPath string `yaml:"rootfs_path,omitempty"`
+
+	// Whether to query cloud metadata services for the instance type, region
+	// and zone of the host.
+	CloudMetadata        bool          `yaml:"cloud_metadata,omitempty"`
+	CloudMetadataTimeout time.Duration `yaml:"cloud_metadata_timeout,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for Config.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// Name returns the name of the integration this config is for.
+func (c *Config) Name() string {
+	return "host_facts"
+}
+
+// CommonConfig returns the common set of options shared across all configs for
+// integrations.
+func (c *Config) CommonConfig() config.Common {
+	return c.Common
+}
+
+// NewIntegration converts this config into an instance of a configuration.
+func (c *Config) NewIntegration(l log.Logger) (integrations.Integration, error) {
+	return New(l, c)
+}
+
+func init() {
+	integrations.RegisterIntegration(&Config{})
+}
+
+// New creates a new host_facts integration.
+func New(log log.Logger, c *Config) (integrations.Integration, error) {
+	if c.RefreshInterval <= 0 {
+		return nil, errors.New("refresh_interval must be greater than 0s")
+	}
+
+	g := &gatherer{
+		log:        log,
+		procfsPath: c.ProcFSPath,
+		rootfsPath: c.RootFSPath,
+	}
+	if c.CloudMetadata {
+		g.cloud = newCloudDetector(c.CloudMetadataTimeout)
+	}
+	col := newCollector()
+
+	return integrations.NewCollectorIntegration(
+		c.Name(),
+		integrations.WithCollectors(col),
+		integrations.WithRunner(func(ctx context.Context) error {
+			t := time.NewTicker(c.RefreshInterval)
+			defer t.Stop()
+
+			for {
+				col.set(g.gather(ctx))
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-t.C:
+				}
+			}
+		}),
+	), nil
+}
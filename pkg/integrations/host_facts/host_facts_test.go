@@ -0,0 +1,180 @@
+package host_facts //nolint:golint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_UnmarshalYAML(t *testing.T) {
+	in := `
+refresh_interval: 6h
+cloud_metadata: false
+`
+	var c Config
+	require.NoError(t, yaml.Unmarshal([]byte(in), &c))
+	require.Equal(t, 6*time.Hour, c.RefreshInterval)
+	require.False(t, c.CloudMetadata)
+	require.Equal(t, DefaultConfig.ProcFSPath, c.ProcFSPath)
+}
+
+func TestParseOSRelease(t *testing.T) {
+	in := `# comment
+NAME="Ubuntu"
+VERSION="20.04.2 LTS (Focal Fossa)"
+ID=ubuntu
+VERSION_ID='20.04'
+PRETTY_NAME="Ubuntu \"Focal\" 20.04"
+`
+	f, err := parseOSRelease(strings.NewReader(in))
+	require.NoError(t, err)
+	require.Equal(t, osFacts{
+		ID:         "ubuntu",
+		Name:       "Ubuntu",
+		Version:    "20.04",
+		PrettyName: `Ubuntu "Focal" 20.04`,
+	}, f)
+}
+
+func TestParseCPUInfo(t *testing.T) {
+	tt := []struct {
+		name   string
+		in     string
+		expect cpuFacts
+	}{
+		{
+			name: "x86",
+			in: `processor	: 0
+vendor_id	: GenuineIntel
+model name	: Intel(R) Xeon(R) CPU @ 2.20GHz
+
+processor	: 1
+vendor_id	: GenuineIntel
+model name	: Intel(R) Xeon(R) CPU @ 2.20GHz
+`,
+			expect: cpuFacts{Vendor: "GenuineIntel", Model: "Intel(R) Xeon(R) CPU @ 2.20GHz"},
+		},
+		{
+			name: "raspberry pi",
+			in: `processor	: 0
+BogoMIPS	: 108.00
+CPU implementer	: 0x41
+
+Hardware	: BCM2835
+Model		: Raspberry Pi 4 Model B Rev 1.4
+`,
+			expect: cpuFacts{Model: "Raspberry Pi 4 Model B Rev 1.4"},
+		},
+		{
+			name: "ppc64",
+			in: `processor	: 0
+cpu		: POWER9 (architected), altivec supported
+`,
+			expect: cpuFacts{Model: "POWER9 (architected), altivec supported"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := parseCPUInfo(strings.NewReader(tc.in))
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, f)
+		})
+	}
+}
+
+func TestCloudDetector(t *testing.T) {
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			_, _ = w.Write([]byte("token"))
+			return
+		}
+		if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/instance-type":
+			_, _ = w.Write([]byte("m5.large"))
+		case "/latest/meta-data/placement/region":
+			_, _ = w.Write([]byte("us-east-1"))
+		case "/latest/meta-data/placement/availability-zone":
+			_, _ = w.Write([]byte("us-east-1a"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer aws.Close()
+
+	gcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/machine-type":
+			_, _ = w.Write([]byte("projects/123/machineTypes/n1-standard-1"))
+		case "/computeMetadata/v1/instance/zone":
+			_, _ = w.Write([]byte("projects/123/zones/us-central1-a"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer gcp.Close()
+
+	d := newCloudDetector(time.Second)
+	d.awsURL, d.gcpURL, d.azureURL = aws.URL, notFound.URL, notFound.URL
+	f, err := d.detect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &cloudFacts{Provider: "aws", InstanceType: "m5.large", Region: "us-east-1", Zone: "us-east-1a"}, f)
+
+	d.awsURL, d.gcpURL = notFound.URL, gcp.URL
+	f, err = d.detect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &cloudFacts{Provider: "gcp", InstanceType: "n1-standard-1", Region: "us-central1", Zone: "us-central1-a"}, f)
+
+	d.gcpURL = notFound.URL
+	_, err = d.detect(context.Background())
+	require.Error(t, err)
+}
+
+func TestCollector(t *testing.T) {
+	c := newCollector()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	names := func() []string {
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+
+		var names []string
+		for _, mf := range mfs {
+			names = append(names, mf.GetName())
+		}
+		return names
+	}
+	require.Empty(t, names(), "nothing should be exposed before facts are gathered")
+
+	c.set(facts{
+		OS:    osFacts{ID: "ubuntu"},
+		Cloud: &cloudFacts{Provider: "aws"},
+	})
+	require.Equal(t, []string{
+		"host_facts_agent_info",
+		"host_facts_cloud_info",
+		"host_facts_cpu_count",
+		"host_facts_cpu_info",
+		"host_facts_kernel_info",
+		"host_facts_last_refresh_timestamp_seconds",
+		"host_facts_os_info",
+	}, names())
+
+	c.set(facts{OS: osFacts{ID: "ubuntu"}})
+	require.NotContains(t, names(), "host_facts_cloud_info", "cloud info should only be exposed in clouds")
+}
@@ -6,10 +6,13 @@ import (
 	_ "github.com/grafana/agent/pkg/integrations/consul_exporter"        // register consul_exporter
 	_ "github.com/grafana/agent/pkg/integrations/dnsmasq_exporter"       // register dnsmasq_exporter
 	_ "github.com/grafana/agent/pkg/integrations/elasticsearch_exporter" // register elasticsearch_exporter
+	_ "github.com/grafana/agent/pkg/integrations/host_facts"             // register host_facts
 	_ "github.com/grafana/agent/pkg/integrations/memcached_exporter"     // register memcached_exporter
+	_ "github.com/grafana/agent/pkg/integrations/mqtt"                   // register mqtt
 	_ "github.com/grafana/agent/pkg/integrations/mysqld_exporter"        // register mysqld_exporter
 	_ "github.com/grafana/agent/pkg/integrations/node_exporter"          // register node_exporter
 	_ "github.com/grafana/agent/pkg/integrations/postgres_exporter"      // register postgres_exporter
+	_ "github.com/grafana/agent/pkg/integrations/postgres_replication"   // register postgres_replication
 	_ "github.com/grafana/agent/pkg/integrations/process_exporter"       // register process_exporter
 	_ "github.com/grafana/agent/pkg/integrations/redis_exporter"         // register redis_exporter
 	_ "github.com/grafana/agent/pkg/integrations/statsd_exporter"        // register statsd_exporter
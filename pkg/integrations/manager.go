@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"path"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,9 +15,11 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/gorilla/mux"
 	"github.com/grafana/agent/pkg/prom"
+	"github.com/grafana/agent/pkg/prom/cluster/configapi"
 	"github.com/grafana/agent/pkg/prom/instance"
 	"github.com/grafana/agent/pkg/prom/instance/configstore"
 	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/agent/pkg/util/usage"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
@@ -25,13 +28,6 @@ import (
 	"github.com/prometheus/prometheus/pkg/relabel"
 )
 
-var (
-	integrationAbnormalExits = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "agent_prometheus_integration_abnormal_exits_total",
-		Help: "Total number of times an agent integration exited unexpectedly, causing it to be restarted.",
-	}, []string{"integration_name"})
-)
-
 // DefaultManagerConfig holds the default settings for integrations.
 var DefaultManagerConfig = ManagerConfig{
 	ScrapeIntegrations:        true,
@@ -116,12 +112,26 @@ func (c *ManagerConfig) DefaultRelabelConfigs(hostname string) []*relabel.Config
 // Prometheus configuration must have a WAL directory configured.
 func (c *ManagerConfig) ApplyDefaults(cfg *prom.Config) error {
 	for _, ic := range c.Integrations {
-		if !ic.CommonConfig().Enabled {
+		common := ic.CommonConfig()
+		if !common.Enabled {
 			continue
 		}
 
+		// Unset WAL times fall back to the instance defaults, so the
+		// effective values are compared.
+		minWALTime, maxWALTime := instance.DefaultConfig.MinWALTime, instance.DefaultConfig.MaxWALTime
+		if common.MinWALTime > 0 {
+			minWALTime = common.MinWALTime
+		}
+		if common.MaxWALTime > 0 {
+			maxWALTime = common.MaxWALTime
+		}
+		if minWALTime > maxWALTime {
+			return fmt.Errorf("integration %s: min_wal_time must be less than max_wal_time", ic.Name())
+		}
+
 		scrapeIntegration := c.ScrapeIntegrations
-		if common := ic.CommonConfig(); common.ScrapeIntegration != nil {
+		if common.ScrapeIntegration != nil {
 			scrapeIntegration = *common.ScrapeIntegration
 		}
 
@@ -152,12 +162,14 @@ type Manager struct {
 
 	integrationsMut sync.RWMutex
 	integrations    map[string]*integrationProcess
+
+	abnormalExits *prometheus.CounterVec
 }
 
 // NewManager creates a new integrations manager. NewManager must be given an
 // InstanceManager which is responsible for accepting instance configs to
 // scrape and send metrics from running integrations.
-func NewManager(c ManagerConfig, logger log.Logger, im instance.Manager, validate configstore.Validator) (*Manager, error) {
+func NewManager(reg prometheus.Registerer, c ManagerConfig, logger log.Logger, im instance.Manager, validate configstore.Validator) (*Manager, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	m := &Manager{
@@ -170,6 +182,11 @@ func NewManager(c ManagerConfig, logger log.Logger, im instance.Manager, validat
 		validator: validate,
 
 		integrations: make(map[string]*integrationProcess, len(c.Integrations)),
+
+		abnormalExits: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_integration_abnormal_exits_total",
+			Help: "Total number of times an agent integration exited unexpectedly, causing it to be restarted.",
+		}, []string{"integration_name"}),
 	}
 
 	var err error
@@ -333,8 +350,12 @@ func (p *integrationProcess) Run() {
 	p.wg.Add(1)
 	defer p.wg.Done()
 
+	usage.Do(p.ctx, usage.ComponentIntegration, p.cfg.Name(), p.run)
+}
+
+func (p *integrationProcess) run(ctx context.Context) {
 	for {
-		err := p.i.Run(p.ctx)
+		err := p.i.Run(ctx)
 		if err != nil && err != context.Canceled {
 			p.wait(p.cfg, err)
 		} else {
@@ -348,7 +369,7 @@ func (m *Manager) instanceBackoff(cfg Config, err error) {
 	m.cfgMut.RLock()
 	defer m.cfgMut.RUnlock()
 
-	integrationAbnormalExits.WithLabelValues(cfg.Name()).Inc()
+	m.abnormalExits.WithLabelValues(cfg.Name()).Inc()
 	level.Error(m.logger).Log("msg", "integration stopped abnormally, restarting after backoff", "err", err, "integration", cfg.Name(), "backoff", m.cfg.IntegrationRestartBackoff)
 	time.Sleep(m.cfg.IntegrationRestartBackoff)
 }
@@ -392,6 +413,14 @@ func (m *Manager) instanceConfigForIntegration(icfg Config, i Integration, cfg M
 	if common.WALTruncateFrequency > 0 {
 		instanceCfg.WALTruncateFrequency = common.WALTruncateFrequency
 	}
+	if common.MinWALTime > 0 {
+		instanceCfg.MinWALTime = common.MinWALTime
+	}
+	if common.MaxWALTime > 0 {
+		instanceCfg.MaxWALTime = common.MaxWALTime
+	}
+	instanceCfg.OutOfOrderTolerance = common.OutOfOrderTolerance
+	instanceCfg.DropStaleMarkers = common.DropStaleMarkers
 	return instanceCfg
 }
 
@@ -424,8 +453,11 @@ func (m *Manager) scrapeServiceDiscovery(cfg ManagerConfig) discovery.Configs {
 	}
 }
 
-// WireAPI hooks up /metrics routes per-integration.
+// WireAPI hooks up /metrics routes per-integration and the API for listing
+// running integrations.
 func (m *Manager) WireAPI(r *mux.Router) {
+	r.HandleFunc("/agent/api/v1/integrations", m.ListIntegrationsHandler).Methods("GET")
+
 	type handlerCacheEntry struct {
 		handler http.Handler
 		process *integrationProcess
@@ -462,6 +494,7 @@ func (m *Manager) WireAPI(r *mux.Router) {
 			level.Error(m.logger).Log("msg", "could not create http handler for integration", "integration", p.cfg.Name(), "err", err)
 			return http.HandlerFunc(internalServiceError)
 		}
+		handler = usage.Handler(usage.ComponentIntegration, p.cfg.Name(), handler)
 
 		cacheEntry = handlerCacheEntry{handler: handler, process: p}
 		handlerCache[key] = cacheEntry
@@ -478,6 +511,40 @@ func (m *Manager) WireAPI(r *mux.Router) {
 	})
 }
 
+// IntegrationStatus describes a running integration.
+type IntegrationStatus struct {
+	Name string `json:"name"`
+
+	// UpstreamModule and UpstreamVersion are the Go module and version of the
+	// exporter embedded by the integration. They're empty for integrations that
+	// don't embed an exporter.
+	UpstreamModule  string `json:"upstream_module,omitempty"`
+	UpstreamVersion string `json:"upstream_version,omitempty"`
+}
+
+// ListIntegrationsHandler writes the set of currently running integrations to
+// the http.ResponseWriter.
+func (m *Manager) ListIntegrationsHandler(w http.ResponseWriter, _ *http.Request) {
+	m.integrationsMut.RLock()
+	statuses := make([]IntegrationStatus, 0, len(m.integrations))
+	for _, p := range m.integrations {
+		status := IntegrationStatus{Name: p.cfg.Name()}
+		if module, version, ok := upstreamInfo(p.cfg); ok {
+			status.UpstreamModule = module
+			status.UpstreamVersion = version
+		}
+		statuses = append(statuses, status)
+	}
+	m.integrationsMut.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	err := configapi.WriteResponse(w, http.StatusOK, statuses)
+	if err != nil {
+		level.Error(m.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
 func internalServiceError(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
 }
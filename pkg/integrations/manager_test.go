@@ -2,15 +2,19 @@ package integrations
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/kit/log"
 	"github.com/grafana/agent/pkg/integrations/config"
+	"github.com/grafana/agent/pkg/prom"
 	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/relabel"
@@ -112,7 +116,7 @@ func TestManager_instanceConfigForIntegration(t *testing.T) {
 	icfg := mockConfig{integration: mock}
 
 	im := instance.NewBasicManager(instance.DefaultBasicManagerConfig, log.NewNopLogger(), mockInstanceFactory)
-	m, err := NewManager(mockManagerConfig(), log.NewNopLogger(), im, noOpValidator)
+	m, err := NewManager(prometheus.NewRegistry(), mockManagerConfig(), log.NewNopLogger(), im, noOpValidator)
 	require.NoError(t, err)
 	defer m.Stop()
 
@@ -123,6 +127,37 @@ func TestManager_instanceConfigForIntegration(t *testing.T) {
 	require.Equal(t, "/integrations/mock/metrics", cfg.ScrapeConfigs[0].MetricsPath)
 }
 
+func TestManager_instanceConfigForIntegration_WALTimes(t *testing.T) {
+	mock := newMockIntegration()
+	mock.commonCfg.MinWALTime = time.Minute
+	mock.commonCfg.MaxWALTime = 30 * time.Minute
+	icfg := mockConfig{integration: mock}
+
+	im := instance.NewBasicManager(instance.DefaultBasicManagerConfig, log.NewNopLogger(), mockInstanceFactory)
+	m, err := NewManager(prometheus.NewRegistry(), mockManagerConfig(), log.NewNopLogger(), im, noOpValidator)
+	require.NoError(t, err)
+	defer m.Stop()
+
+	cfg := m.instanceConfigForIntegration(icfg, mock, mockManagerConfig())
+	require.Equal(t, time.Minute, cfg.MinWALTime)
+	require.Equal(t, 30*time.Minute, cfg.MaxWALTime)
+	require.Equal(t, instance.DefaultConfig.WALTruncateFrequency, cfg.WALTruncateFrequency)
+}
+
+func TestManagerConfig_ApplyDefaults_WALTimes(t *testing.T) {
+	mock := newMockIntegration()
+	mock.commonCfg.Enabled = true
+	mock.commonCfg.MinWALTime = 5 * time.Hour
+
+	cfg := mockManagerConfig()
+	cfg.Integrations = Configs{mockConfig{integration: mock}}
+	err := cfg.ApplyDefaults(&prom.Config{WALDir: "/tmp/wal"})
+	require.EqualError(t, err, "integration mock: min_wal_time must be less than max_wal_time")
+
+	mock.commonCfg.MaxWALTime = 6 * time.Hour
+	require.NoError(t, cfg.ApplyDefaults(&prom.Config{WALDir: "/tmp/wal"}))
+}
+
 // TestManager_NoIntegrationsScrape ensures that configs don't get generates
 // when the ScrapeIntegrations flag is disabled.
 func TestManager_NoIntegrationsScrape(t *testing.T) {
@@ -135,7 +170,7 @@ func TestManager_NoIntegrationsScrape(t *testing.T) {
 	cfg.ScrapeIntegrations = false
 	cfg.Integrations = append(cfg.Integrations, &icfg)
 
-	m, err := NewManager(cfg, log.NewNopLogger(), im, noOpValidator)
+	m, err := NewManager(prometheus.NewRegistry(), cfg, log.NewNopLogger(), im, noOpValidator)
 	require.NoError(t, err)
 	defer m.Stop()
 
@@ -160,7 +195,7 @@ func TestManager_NoIntegrationScrape(t *testing.T) {
 	cfg := mockManagerConfig()
 	cfg.Integrations = append(cfg.Integrations, icfg)
 
-	m, err := NewManager(cfg, log.NewNopLogger(), im, noOpValidator)
+	m, err := NewManager(prometheus.NewRegistry(), cfg, log.NewNopLogger(), im, noOpValidator)
 	require.NoError(t, err)
 	defer m.Stop()
 
@@ -178,7 +213,7 @@ func TestManager_StartsIntegrations(t *testing.T) {
 	cfg.Integrations = append(cfg.Integrations, icfg)
 
 	im := instance.NewBasicManager(instance.DefaultBasicManagerConfig, log.NewNopLogger(), mockInstanceFactory)
-	m, err := NewManager(cfg, log.NewNopLogger(), im, noOpValidator)
+	m, err := NewManager(prometheus.NewRegistry(), cfg, log.NewNopLogger(), im, noOpValidator)
 	require.NoError(t, err)
 	defer m.Stop()
 
@@ -200,7 +235,7 @@ func TestManager_RestartsIntegrations(t *testing.T) {
 	cfg.Integrations = append(cfg.Integrations, icfg)
 
 	im := instance.NewBasicManager(instance.DefaultBasicManagerConfig, log.NewNopLogger(), mockInstanceFactory)
-	m, err := NewManager(cfg, log.NewNopLogger(), im, noOpValidator)
+	m, err := NewManager(prometheus.NewRegistry(), cfg, log.NewNopLogger(), im, noOpValidator)
 	require.NoError(t, err)
 	defer m.Stop()
 
@@ -219,7 +254,7 @@ func TestManager_GracefulStop(t *testing.T) {
 	cfg.Integrations = append(cfg.Integrations, icfg)
 
 	im := instance.NewBasicManager(instance.DefaultBasicManagerConfig, log.NewNopLogger(), mockInstanceFactory)
-	m, err := NewManager(cfg, log.NewNopLogger(), im, noOpValidator)
+	m, err := NewManager(prometheus.NewRegistry(), cfg, log.NewNopLogger(), im, noOpValidator)
 	require.NoError(t, err)
 
 	test.Poll(t, time.Second, 1, func() interface{} {
@@ -236,6 +271,40 @@ func TestManager_GracefulStop(t *testing.T) {
 	})
 }
 
+func TestManager_ListIntegrationsHandler(t *testing.T) {
+	cfg := mockManagerConfig()
+	cfg.Integrations = append(cfg.Integrations,
+		mockConfig{integration: newMockIntegration()},
+		mockUpstreamConfig{
+			mockConfig: mockConfig{integration: newMockIntegration()},
+			module:     "github.com/prometheus/client_golang",
+		},
+	)
+
+	im := instance.NewBasicManager(instance.DefaultBasicManagerConfig, log.NewNopLogger(), mockInstanceFactory)
+	m, err := NewManager(prometheus.NewRegistry(), cfg, log.NewNopLogger(), im, noOpValidator)
+	require.NoError(t, err)
+	defer m.Stop()
+
+	version := UpstreamVersion("github.com/prometheus/client_golang")
+	require.NotEqual(t, UnknownVersion, version)
+
+	rec := httptest.NewRecorder()
+	m.ListIntegrationsHandler(rec, httptest.NewRequest("GET", "/agent/api/v1/integrations", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Status string              `json:"status"`
+		Data   []IntegrationStatus `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "success", resp.Status)
+	require.Equal(t, []IntegrationStatus{
+		{Name: "mock"},
+		{Name: "mock_upstream", UpstreamModule: "github.com/prometheus/client_golang", UpstreamVersion: version},
+	}, resp.Data)
+}
+
 type mockConfig struct {
 	integration *mockIntegration
 }
@@ -249,6 +318,14 @@ func (c mockConfig) NewIntegration(_ log.Logger) (Integration, error) {
 	return c.integration, nil
 }
 
+type mockUpstreamConfig struct {
+	mockConfig
+	module string
+}
+
+func (c mockUpstreamConfig) Name() string           { return "mock_upstream" }
+func (c mockUpstreamConfig) UpstreamModule() string { return c.module }
+
 type mockIntegration struct {
 	commonCfg    config.Common
 	startedCount *atomic.Uint32
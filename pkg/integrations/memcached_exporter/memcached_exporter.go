@@ -40,6 +40,11 @@ func (c *Config) Name() string {
 	return "memcached_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/prometheus/memcached_exporter"
+}
+
 // CommonConfig returns the common settings shared across all integratons.
 func (c *Config) CommonConfig() config.Common {
 	return c.Common
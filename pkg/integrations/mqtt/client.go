@@ -0,0 +1,196 @@
+package mqtt //nolint:golint
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// protocolVersion311 is the protocol version of MQTT 3.1.1 used when
+// connecting to brokers.
+const protocolVersion311 = 4
+
+// disconnectQuiesce is how long to wait for in-flight work to finish when
+// disconnecting, in milliseconds.
+const disconnectQuiesce = 250
+
+// message is a message received on a subscribed topic.
+type message struct {
+	Topic   string
+	Payload []byte
+}
+
+// subscription is a topic filter to subscribe to with its maximum QoS.
+type subscription struct {
+	Filter string
+	QoS    byte
+}
+
+// clientOptions configures a connection to a broker.
+type clientOptions struct {
+	Broker         string
+	ClientID       string
+	Username       string
+	Password       string
+	TLSConfig      *tls.Config
+	KeepAlive      time.Duration
+	ConnectTimeout time.Duration
+}
+
+// pahoOptions returns the options of a paho client connecting to the broker.
+// Reconnecting is left to the caller so connection failures can be tracked.
+func (o clientOptions) pahoOptions() (*paho.ClientOptions, error) {
+	network, addr, useTLS, err := parseBroker(o.Broker)
+	if err != nil {
+		return nil, err
+	}
+	scheme := network
+	if useTLS {
+		scheme = "ssl"
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(scheme + "://" + addr).
+		SetClientID(o.ClientID).
+		SetUsername(o.Username).
+		SetPassword(o.Password).
+		SetProtocolVersion(protocolVersion311).
+		SetCleanSession(true).
+		SetKeepAlive(o.KeepAlive).
+		SetConnectTimeout(o.ConnectTimeout).
+		SetAutoReconnect(false).
+		SetConnectRetry(false)
+	if useTLS {
+		opts.SetTLSConfig(o.TLSConfig)
+	}
+	return opts, nil
+}
+
+// connect connects to the broker and subscribes to subs, passing received
+// messages to handle. The returned channel receives the error the
+// connection failed with. The client must be disconnected by the caller.
+func connect(ctx context.Context, opts clientOptions, subs []subscription, handle func(message)) (paho.Client, <-chan error, error) {
+	pahoOpts, err := opts.pahoOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lost := make(chan error, 1)
+	pahoOpts.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		select {
+		case lost <- err:
+		default:
+		}
+	})
+
+	cli := paho.NewClient(pahoOpts)
+	if err := waitToken(ctx, cli.Connect()); err != nil {
+		return nil, nil, err
+	}
+
+	filters := make(map[string]byte, len(subs))
+	for _, s := range subs {
+		filters[s.Filter] = s.QoS
+	}
+	tok := cli.SubscribeMultiple(filters, func(_ paho.Client, msg paho.Message) {
+		handle(message{Topic: msg.Topic(), Payload: msg.Payload()})
+	})
+	if err := waitToken(ctx, tok); err != nil {
+		cli.Disconnect(disconnectQuiesce)
+		return nil, nil, err
+	}
+	for filter, code := range tok.(*paho.SubscribeToken).Result() {
+		if code == 0x80 {
+			cli.Disconnect(disconnectQuiesce)
+			return nil, nil, fmt.Errorf("subscription to %q refused", filter)
+		}
+	}
+	return cli, lost, nil
+}
+
+// waitToken waits for tok to complete or ctx to be canceled.
+func waitToken(ctx context.Context, tok paho.Token) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tok.Done():
+		return tok.Error()
+	}
+}
+
+// parseBroker returns the network and address to dial for a broker URL.
+// tcp:// and mqtt:// connect in plain text, ssl://, tls:// and mqtts://
+// with TLS. A missing port defaults to 1883, or 8883 with TLS.
+func parseBroker(broker string) (network, addr string, useTLS bool, err error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		useTLS = true
+		port = "8883"
+	default:
+		return "", "", false, fmt.Errorf("unsupported broker scheme %q, expected tcp, mqtt, ssl, tls or mqtts", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", "", false, fmt.Errorf("broker %q is missing a host", broker)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return "tcp", net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// topicMatches reports whether topic matches the topic filter, which may
+// contain the + single-level and # multi-level wildcards.
+func topicMatches(filter, topic string) bool {
+	// Wildcards at the first level don't match topics starting with $, which
+	// are reserved for broker internals.
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if f != "+" && f != tl[i] {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+// validTopicFilter returns an error if filter isn't a valid MQTT topic
+// filter.
+func validTopicFilter(filter string) error {
+	if filter == "" {
+		return errors.New("topic filter must not be empty")
+	}
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		switch {
+		case l == "#" && i != len(levels)-1:
+			return fmt.Errorf("topic filter %q: # must be the last level", filter)
+		case l != "#" && l != "+" && strings.ContainsAny(l, "#+"):
+			return fmt.Errorf("topic filter %q: wildcards must occupy a whole level", filter)
+		}
+	}
+	return nil
+}
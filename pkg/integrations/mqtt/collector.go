@@ -0,0 +1,283 @@
+package mqtt //nolint:golint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labelValuesSep separates label values in the keys of mapping.series. It
+// can't appear in valid UTF-8.
+const labelValuesSep = "\xff"
+
+// mapping turns messages of a topic into samples of one metric.
+type mapping struct {
+	cfg       MappingConfig
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+
+	// Names of the variable labels of the metric, in the order of the values
+	// in the keys of series.
+	labelNames []string
+
+	series map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+	updated     time.Time
+}
+
+func newMapping(cfg MappingConfig) *mapping {
+	var labelNames []string
+	for name := range cfg.TopicLabels {
+		labelNames = append(labelNames, name)
+	}
+	for name := range cfg.LabelFields {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+
+	help := cfg.Help
+	if help == "" {
+		help = fmt.Sprintf("Value of %s in messages published to %s.", fieldOrPayload(cfg.ValueField), cfg.Topic)
+	}
+
+	valueType := prometheus.GaugeValue
+	if cfg.Type == MetricTypeCounter {
+		valueType = prometheus.CounterValue
+	}
+
+	return &mapping{
+		cfg:        cfg,
+		desc:       prometheus.NewDesc(cfg.Metric, help, labelNames, cfg.Labels),
+		valueType:  valueType,
+		labelNames: labelNames,
+		series:     make(map[string]*sample),
+	}
+}
+
+func fieldOrPayload(field string) string {
+	if field == "" {
+		return "the payload"
+	}
+	return field
+}
+
+// update sets the sample of the series msg belongs to. msg must match the
+// topic of the mapping.
+func (m *mapping) update(msg message, now time.Time) error {
+	var (
+		value  float64
+		fields map[string]interface{}
+	)
+
+	if m.cfg.ValueField == "" {
+		// The payload is the value itself, which is common for devices
+		// publishing a topic per reading.
+		v, err := strconv.ParseFloat(strings.TrimSpace(string(msg.Payload)), 64)
+		if err != nil {
+			return fmt.Errorf("payload is not a number: %w", err)
+		}
+		value = v
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(msg.Payload))
+		dec.UseNumber()
+		if err := dec.Decode(&fields); err != nil {
+			return fmt.Errorf("payload is not a JSON object: %w", err)
+		}
+
+		raw, ok := lookupField(fields, m.cfg.ValueField)
+		if !ok {
+			return fmt.Errorf("field %s not found", m.cfg.ValueField)
+		}
+		v, err := toFloat(raw)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", m.cfg.ValueField, err)
+		}
+		value = v
+	}
+
+	levels := strings.Split(msg.Topic, "/")
+	labelValues := make([]string, len(m.labelNames))
+	for i, name := range m.labelNames {
+		if idx, ok := m.cfg.TopicLabels[name]; ok {
+			if idx >= len(levels) {
+				return fmt.Errorf("topic %s has no level %d for label %s", msg.Topic, idx, name)
+			}
+			labelValues[i] = levels[idx]
+			continue
+		}
+
+		field := m.cfg.LabelFields[name]
+		raw, ok := lookupField(fields, field)
+		if !ok {
+			return fmt.Errorf("field %s for label %s not found", field, name)
+		}
+		labelValues[i] = toLabelValue(raw)
+	}
+
+	key := strings.Join(labelValues, labelValuesSep)
+	s, ok := m.series[key]
+	if !ok {
+		s = &sample{labelValues: labelValues}
+		m.series[key] = s
+	}
+	s.value = value
+	s.updated = now
+	return nil
+}
+
+// lookupField returns the field at the dot-separated path in fields.
+func lookupField(fields map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = fields
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = obj[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// toFloat converts a JSON number, boolean or string holding a number to a
+// float.
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+func toLabelValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// collector exposes the samples of every mapping along with metrics about
+// the integration itself.
+type collector struct {
+	mut      sync.Mutex
+	mappings []*mapping
+	ttl      time.Duration
+	now      func() time.Time
+
+	connected       prometheus.Gauge
+	connectFailures prometheus.Counter
+	received        prometheus.Counter
+	invalid         *prometheus.CounterVec
+}
+
+func newCollector(cfgs []MappingConfig, ttl time.Duration) *collector {
+	c := &collector{
+		ttl: ttl,
+		now: time.Now,
+
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mqtt_connected",
+			Help: "Whether the integration is connected to the broker.",
+		}),
+		connectFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mqtt_connect_failures_total",
+			Help: "Total number of failed attempts to connect and subscribe to the broker.",
+		}),
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mqtt_messages_received_total",
+			Help: "Total number of messages received from the broker.",
+		}),
+		invalid: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mqtt_messages_invalid_total",
+			Help: "Total number of messages that couldn't be mapped to a metric, by metric.",
+		}, []string{"metric"}),
+	}
+	for _, cfg := range cfgs {
+		c.mappings = append(c.mappings, newMapping(cfg))
+	}
+	return c
+}
+
+// handle updates the samples of every mapping whose topic matches msg.
+func (c *collector) handle(msg message) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.received.Inc()
+
+	var firstErr error
+	now := c.now()
+	for _, m := range c.mappings {
+		if !topicMatches(m.cfg.Topic, msg.Topic) {
+			continue
+		}
+		if err := m.update(msg, now); err != nil {
+			c.invalid.WithLabelValues(m.cfg.Metric).Inc()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("message on %s for metric %s: %w", msg.Topic, m.cfg.Metric, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	c.connected.Describe(ch)
+	c.connectFailures.Describe(ch)
+	c.received.Describe(ch)
+	c.invalid.Describe(ch)
+	for _, m := range c.mappings {
+		ch <- m.desc
+	}
+}
+
+// Collect implements prometheus.Collector. Series that weren't updated
+// within the TTL are removed.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.connected.Collect(ch)
+	c.connectFailures.Collect(ch)
+	c.received.Collect(ch)
+	c.invalid.Collect(ch)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	now := c.now()
+	for _, m := range c.mappings {
+		for key, s := range m.series {
+			if c.ttl > 0 && now.Sub(s.updated) > c.ttl {
+				delete(m.series, key)
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, s.value, s.labelValues...)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package mqtt subscribes to topics of an MQTT broker and exposes values
+// published to them as metrics, for IoT and industrial devices that can
+// publish readings but can't run an exporter.
+package mqtt //nolint:golint
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/integrations"
+	"github.com/grafana/agent/pkg/integrations/config"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+// Supported metric types of mappings.
+const (
+	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = "counter"
+)
+
+// DefaultConfig holds non-zero default options for the Config when it is
+// unmarshaled from YAML.
+var DefaultConfig = Config{
+	Broker:         "tcp://localhost:1883",
+	KeepAlive:      30 * time.Second,
+	ConnectTimeout: 10 * time.Second,
+	ReconnectDelay: 5 * time.Second,
+}
+
+// Config controls the mqtt integration.
+type Config struct {
+	Common config.Common `yaml:",inline"`
+
+	// URL of the broker, such as tcp://broker:1883 or ssl://broker:8883.
+	Broker string `yaml:"broker,omitempty"`
+
+	// Client identifier sent to the broker. When empty, the broker assigns
+	// one.
+	ClientID string `yaml:"client_id,omitempty"`
+
+	Username string             `yaml:"username,omitempty"`
+	Password config_util.Secret `yaml:"password,omitempty"`
+
+	// TLS settings used when the broker URL uses the ssl, tls or mqtts
+	// scheme.
+	TLSConfig config_util.TLSConfig `yaml:"tls_config,omitempty"`
+
+	// Interval of pings sent to keep the connection alive. The connection is
+	// considered dead after 1.5 times this interval without packets from the
+	// broker.
+	KeepAlive time.Duration `yaml:"keep_alive,omitempty"`
+
+	// Timeout for connecting and subscribing to the broker.
+	ConnectTimeout time.Duration `yaml:"connect_timeout,omitempty"`
+
+	// Time to wait before reconnecting after the connection failed.
+	ReconnectDelay time.Duration `yaml:"reconnect_delay,omitempty"`
+
+	// Series that don't receive a new message within SeriesTTL are removed.
+	// 0 keeps series forever.
+	SeriesTTL time.Duration `yaml:"series_ttl,omitempty"`
+
+	// Rules mapping messages to metrics.
+	Mappings []MappingConfig `yaml:"mappings,omitempty"`
+}
+
+// MappingConfig maps messages published to a topic to a metric.
+type MappingConfig struct {
+	// Topic filter to subscribe to. May contain the + and # wildcards.
+	Topic string `yaml:"topic"`
+
+	// Maximum QoS to subscribe with, 0 or 1.
+	QoS byte `yaml:"qos,omitempty"`
+
+	// Name, help and type of the metric. Type is gauge or counter and
+	// defaults to gauge.
+	Metric string `yaml:"metric"`
+	Help   string `yaml:"help,omitempty"`
+	Type   string `yaml:"type,omitempty"`
+
+	// Dot-separated path of the field of JSON object payloads holding the
+	// value. When empty, the whole payload must be a number.
+	ValueField string `yaml:"value_field,omitempty"`
+
+	// Static labels added to the metric.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// Labels set from fields of JSON object payloads, by dot-separated path.
+	// Requires ValueField.
+	LabelFields map[string]string `yaml:"label_fields,omitempty"`
+
+	// Labels set from levels of the topic, by 0-based index.
+	TopicLabels map[string]int `yaml:"topic_labels,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for Config.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// Name returns the name of the integration this config is for.
+func (c *Config) Name() string {
+	return "mqtt"
+}
+
+// CommonConfig returns the common set of options shared across all configs for
+// integrations.
+func (c *Config) CommonConfig() config.Common {
+	return c.Common
+}
+
+// NewIntegration converts this config into an instance of a configuration.
+func (c *Config) NewIntegration(l log.Logger) (integrations.Integration, error) {
+	return New(l, c)
+}
+
+func init() {
+	integrations.RegisterIntegration(&Config{})
+}
+
+// validate returns an error if the config is invalid.
+func (c *Config) validate() error {
+	if _, _, _, err := parseBroker(c.Broker); err != nil {
+		return fmt.Errorf("invalid broker: %w", err)
+	}
+	switch {
+	case c.Password != "" && c.Username == "":
+		return errors.New("password requires username to be set")
+	case c.KeepAlive < 0:
+		return errors.New("keep_alive must not be negative")
+	case c.ReconnectDelay <= 0:
+		return errors.New("reconnect_delay must be greater than 0s")
+	case c.SeriesTTL < 0:
+		return errors.New("series_ttl must not be negative")
+	case len(c.Mappings) == 0:
+		return errors.New("at least one mapping is required")
+	}
+
+	metrics := make(map[string]struct{}, len(c.Mappings))
+	for _, m := range c.Mappings {
+		if err := m.validate(); err != nil {
+			return err
+		}
+		if _, exist := metrics[m.Metric]; exist {
+			return fmt.Errorf("found multiple mappings for metric %s", m.Metric)
+		}
+		metrics[m.Metric] = struct{}{}
+	}
+	return nil
+}
+
+func (m *MappingConfig) validate() error {
+	if err := validTopicFilter(m.Topic); err != nil {
+		return err
+	}
+	if !model.IsValidMetricName(model.LabelValue(m.Metric)) {
+		return fmt.Errorf("%q is not a valid metric name", m.Metric)
+	}
+	switch {
+	case m.QoS > 1:
+		return fmt.Errorf("mapping for metric %s: qos must be 0 or 1", m.Metric)
+	case m.Type != "" && m.Type != MetricTypeGauge && m.Type != MetricTypeCounter:
+		return fmt.Errorf("mapping for metric %s: unsupported type %q, expected %q or %q", m.Metric, m.Type, MetricTypeGauge, MetricTypeCounter)
+	case len(m.LabelFields) > 0 && m.ValueField == "":
+		return fmt.Errorf("mapping for metric %s: label_fields requires value_field to be set", m.Metric)
+	}
+
+	names := make(map[string]struct{})
+	checkName := func(name string) error {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("mapping for metric %s: %q is not a valid label name", m.Metric, name)
+		}
+		if _, exist := names[name]; exist {
+			return fmt.Errorf("mapping for metric %s: label %s is set more than once", m.Metric, name)
+		}
+		names[name] = struct{}{}
+		return nil
+	}
+	for name := range m.Labels {
+		if err := checkName(name); err != nil {
+			return err
+		}
+	}
+	for name := range m.LabelFields {
+		if err := checkName(name); err != nil {
+			return err
+		}
+	}
+	for name, idx := range m.TopicLabels {
+		if err := checkName(name); err != nil {
+			return err
+		}
+		if idx < 0 {
+			return fmt.Errorf("mapping for metric %s: topic level of label %s must not be negative", m.Metric, name)
+		}
+	}
+	return nil
+}
+
+// New creates a new mqtt integration.
+func New(logger log.Logger, c *Config) (integrations.Integration, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if _, _, useTLS, _ := parseBroker(c.Broker); useTLS {
+		var err error
+		tlsConfig, err = config_util.NewTLSConfig(&c.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls_config: %w", err)
+		}
+	}
+
+	opts := clientOptions{
+		Broker:         c.Broker,
+		ClientID:       c.ClientID,
+		Username:       c.Username,
+		Password:       string(c.Password),
+		TLSConfig:      tlsConfig,
+		KeepAlive:      c.KeepAlive,
+		ConnectTimeout: c.ConnectTimeout,
+	}
+
+	// Mappings sharing a topic filter share its subscription, with the
+	// highest QoS any of them asked for.
+	var subs []subscription
+	subIndex := make(map[string]int)
+	for _, m := range c.Mappings {
+		if idx, ok := subIndex[m.Topic]; ok {
+			if m.QoS > subs[idx].QoS {
+				subs[idx].QoS = m.QoS
+			}
+			continue
+		}
+		subIndex[m.Topic] = len(subs)
+		subs = append(subs, subscription{Filter: m.Topic, QoS: m.QoS})
+	}
+
+	col := newCollector(c.Mappings, c.SeriesTTL)
+
+	return integrations.NewCollectorIntegration(
+		c.Name(),
+		integrations.WithCollectors(col),
+		integrations.WithRunner(func(ctx context.Context) error {
+			for {
+				err := run(ctx, logger, opts, subs, col)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				level.Warn(logger).Log("msg", "mqtt connection failed, reconnecting", "broker", c.Broker, "delay", c.ReconnectDelay, "err", err)
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(c.ReconnectDelay):
+				}
+			}
+		}),
+	), nil
+}
+
+// run connects to the broker and handles messages until the connection
+// fails or ctx is canceled.
+func run(ctx context.Context, logger log.Logger, opts clientOptions, subs []subscription, col *collector) error {
+	cli, lost, err := connect(ctx, opts, subs, func(msg message) {
+		if err := col.handle(msg); err != nil {
+			level.Debug(logger).Log("msg", "failed to map mqtt message", "err", err)
+		}
+	})
+	if err != nil {
+		col.connectFailures.Inc()
+		return err
+	}
+	defer cli.Disconnect(disconnectQuiesce)
+	level.Info(logger).Log("msg", "connected to mqtt broker", "broker", opts.Broker)
+
+	col.connected.Set(1)
+	defer col.connected.Set(0)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-lost:
+		return err
+	}
+}
@@ -0,0 +1,300 @@
+package mqtt //nolint:golint
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_UnmarshalYAML(t *testing.T) {
+	in := `
+broker: ssl://broker:8883
+mappings:
+  - topic: sensors/+/temperature
+    metric: sensor_temperature_celsius
+    value_field: value
+    topic_labels:
+      sensor: 1
+`
+	var c Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(in), &c))
+	require.Equal(t, "ssl://broker:8883", c.Broker)
+	require.Equal(t, DefaultConfig.KeepAlive, c.KeepAlive)
+	require.Equal(t, map[string]int{"sensor": 1}, c.Mappings[0].TopicLabels)
+	require.NoError(t, c.validate())
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := MappingConfig{Topic: "sensors/#", Metric: "sensor_value"}
+
+	tt := []struct {
+		name   string
+		mutate func(c *Config)
+		err    string
+	}{
+		{
+			name:   "unsupported broker scheme",
+			mutate: func(c *Config) { c.Broker = "http://broker" },
+			err:    `invalid broker: unsupported broker scheme "http", expected tcp, mqtt, ssl, tls or mqtts`,
+		},
+		{
+			name:   "no mappings",
+			mutate: func(c *Config) { c.Mappings = nil },
+			err:    "at least one mapping is required",
+		},
+		{
+			name: "wildcard not last",
+			mutate: func(c *Config) {
+				c.Mappings[0].Topic = "sensors/#/value"
+			},
+			err: `topic filter "sensors/#/value": # must be the last level`,
+		},
+		{
+			name: "duplicate metric",
+			mutate: func(c *Config) {
+				c.Mappings = append(c.Mappings, MappingConfig{Topic: "other", Metric: "sensor_value"})
+			},
+			err: "found multiple mappings for metric sensor_value",
+		},
+		{
+			name: "label fields without value field",
+			mutate: func(c *Config) {
+				c.Mappings[0].LabelFields = map[string]string{"device": "device.id"}
+			},
+			err: "mapping for metric sensor_value: label_fields requires value_field to be set",
+		},
+		{
+			name: "label set twice",
+			mutate: func(c *Config) {
+				c.Mappings[0].Labels = map[string]string{"device": "a"}
+				c.Mappings[0].TopicLabels = map[string]int{"device": 1}
+			},
+			err: "mapping for metric sensor_value: label device is set more than once",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig
+			c.Mappings = []MappingConfig{valid}
+			tc.mutate(&c)
+			require.EqualError(t, c.validate(), tc.err)
+		})
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	tt := []struct {
+		filter, topic string
+		expect        bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"#", "$SYS/uptime", false},
+		{"+/uptime", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+	}
+
+	for _, tc := range tt {
+		require.Equal(t, tc.expect, topicMatches(tc.filter, tc.topic), "filter %s, topic %s", tc.filter, tc.topic)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c := newCollector([]MappingConfig{
+		{
+			Topic:       "plant/+/sensors",
+			Metric:      "sensor_temperature_celsius",
+			ValueField:  "readings.temperature",
+			Labels:      map[string]string{"site": "plant"},
+			LabelFields: map[string]string{"device": "id"},
+			TopicLabels: map[string]int{"line": 1},
+		},
+		{
+			Topic:  "plant/+/counter",
+			Metric: "items_total",
+			Type:   MetricTypeCounter,
+		},
+	}, time.Minute)
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	require.NoError(t, c.handle(message{Topic: "plant/1/sensors", Payload: []byte(`{"id": 7, "readings": {"temperature": 21.5}}`)}))
+	require.NoError(t, c.handle(message{Topic: "plant/1/counter", Payload: []byte("42\n")}))
+	require.Error(t, c.handle(message{Topic: "plant/2/sensors", Payload: []byte(`{"id": 8}`)}))
+	require.Error(t, c.handle(message{Topic: "plant/2/counter", Payload: []byte(`{"count": 1}`)}))
+
+	mfs := gather(t, c)
+	require.Equal(t, []string{`device="7",line="1",site="plant"`}, seriesLabels(mfs["sensor_temperature_celsius"]))
+	require.Equal(t, 21.5, mfs["sensor_temperature_celsius"].Metric[0].GetGauge().GetValue())
+	require.Equal(t, 42.0, mfs["items_total"].Metric[0].GetCounter().GetValue())
+	require.Equal(t, 4.0, mfs["mqtt_messages_received_total"].Metric[0].GetCounter().GetValue())
+	require.Len(t, mfs["mqtt_messages_invalid_total"].Metric, 2)
+
+	// Series not updated within the TTL are removed.
+	now = now.Add(2 * time.Minute)
+	mfs = gather(t, c)
+	require.NotContains(t, mfs, "sensor_temperature_celsius")
+	require.NotContains(t, mfs, "items_total")
+}
+
+func TestRun(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	brokerErr := make(chan error, 1)
+	go func() {
+		brokerErr <- serveFakeBroker(l, []message{
+			{Topic: "sensors/a", Payload: []byte("1")},
+			{Topic: "sensors/b", Payload: []byte("2")},
+		})
+	}()
+
+	col := newCollector([]MappingConfig{{
+		Topic:       "sensors/+",
+		Metric:      "sensor_value",
+		TopicLabels: map[string]int{"sensor": 1},
+	}}, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- run(ctx, log.NewNopLogger(), clientOptions{
+			Broker:         "tcp://" + l.Addr().String(),
+			ClientID:       "agent",
+			Username:       "user",
+			Password:       "pass",
+			KeepAlive:      time.Minute,
+			ConnectTimeout: time.Second,
+		}, []subscription{{Filter: "sensors/+", QoS: 1}}, col)
+	}()
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(col.received) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1.0, testutil.ToFloat64(col.connected))
+
+	mfs := gather(t, col)
+	require.Equal(t, []string{`sensor="a"`, `sensor="b"`}, seriesLabels(mfs["sensor_value"]))
+
+	// The client disconnects from the broker once ctx is canceled.
+	cancel()
+	require.NoError(t, <-runErr)
+	require.NoError(t, <-brokerErr)
+	require.Equal(t, 0.0, testutil.ToFloat64(col.connected))
+}
+
+// serveFakeBroker accepts a single client, checks its CONNECT and SUBSCRIBE
+// packets and publishes msgs to it with QoS 1.
+func serveFakeBroker(l net.Listener, msgs []message) error {
+	conn, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cp, err := packets.ReadPacket(conn)
+	if err != nil {
+		return err
+	}
+	connect, ok := cp.(*packets.ConnectPacket)
+	if !ok || connect.ProtocolVersion != protocolVersion311 || connect.Username != "user" || string(connect.Password) != "pass" {
+		return fmt.Errorf("unexpected CONNECT: %s", cp)
+	}
+	if err := packets.NewControlPacket(packets.Connack).Write(conn); err != nil {
+		return err
+	}
+
+	cp, err = packets.ReadPacket(conn)
+	if err != nil {
+		return err
+	}
+	sub, ok := cp.(*packets.SubscribePacket)
+	if !ok {
+		return fmt.Errorf("expected SUBSCRIBE, got %s", cp)
+	}
+	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	suback.MessageID = sub.MessageID
+	suback.ReturnCodes = sub.Qoss
+	if err := suback.Write(conn); err != nil {
+		return err
+	}
+
+	for i, msg := range msgs {
+		publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		publish.Qos = 1
+		publish.MessageID = uint16(i + 1)
+		publish.TopicName = msg.Topic
+		publish.Payload = msg.Payload
+		if err := publish.Write(conn); err != nil {
+			return err
+		}
+
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return err
+		}
+		if ack, ok := cp.(*packets.PubackPacket); !ok || ack.MessageID != publish.MessageID {
+			return fmt.Errorf("expected PUBACK for message %d, got %s", publish.MessageID, cp)
+		}
+	}
+
+	cp, err = packets.ReadPacket(conn)
+	if err != nil {
+		return err
+	}
+	if _, ok := cp.(*packets.DisconnectPacket); !ok {
+		return fmt.Errorf("expected DISCONNECT, got %s", cp)
+	}
+	return nil
+}
+
+func gather(t *testing.T, c prometheus.Collector) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+
+	res := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		res[mf.GetName()] = mf
+	}
+	return res
+}
+
+func seriesLabels(mf *dto.MetricFamily) []string {
+	var res []string
+	for _, m := range mf.GetMetric() {
+		var s string
+		for i, l := range m.GetLabel() {
+			if i > 0 {
+				s += ","
+			}
+			s += l.GetName() + `="` + l.GetValue() + `"`
+		}
+		res = append(res, s)
+	}
+	return res
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/grafana/agent/pkg/integrations"
 	"github.com/grafana/agent/pkg/integrations/config"
+	config_util "github.com/prometheus/common/config"
 	"github.com/prometheus/mysqld_exporter/collector"
 )
 
@@ -36,7 +37,7 @@ type Config struct {
 	Common config.Common `yaml:",inline"`
 
 	// DataSourceName to use to connect to MySQL.
-	DataSourceName string `yaml:"data_source_name,omitempty"`
+	DataSourceName config_util.Secret `yaml:"data_source_name,omitempty"`
 
 	// Collectors to mark as enabled in addition to the default.
 	EnableCollectors []string `yaml:"enable_collectors,omitempty"`
@@ -79,6 +80,11 @@ func (c *Config) Name() string {
 	return "mysqld_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/prometheus/mysqld_exporter"
+}
+
 // CommonConfig returns the common settings shared across all integrations.
 func (c *Config) CommonConfig() config.Common {
 	return c.Common
@@ -96,7 +102,7 @@ func init() {
 // New creates a new mysqld_exporter integration. The integration scrapes
 // metrics from a mysqld process.
 func New(log log.Logger, c *Config) (integrations.Integration, error) {
-	dsn := c.DataSourceName
+	dsn := string(c.DataSourceName)
 	if len(dsn) == 0 {
 		dsn = os.Getenv("MYSQLD_EXPORTER_DATA_SOURCE_NAME")
 	}
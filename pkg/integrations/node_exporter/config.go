@@ -123,6 +123,11 @@ func (c *Config) Name() string {
 	return "node_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/prometheus/node_exporter"
+}
+
 // CommonConfig returns the common configs that are shared across all integrations.
 func (c *Config) CommonConfig() config.Common {
 	return c.Common
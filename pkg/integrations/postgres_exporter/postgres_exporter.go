@@ -9,6 +9,7 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/grafana/agent/pkg/integrations"
 	"github.com/grafana/agent/pkg/integrations/config"
+	config_util "github.com/prometheus/common/config"
 	"github.com/wrouesnel/postgres_exporter/exporter"
 )
 
@@ -17,7 +18,7 @@ type Config struct {
 	Common config.Common `yaml:",inline"`
 
 	// DataSourceNames to use to connect to Postgres.
-	DataSourceNames []string `yaml:"data_source_names,omitempty"`
+	DataSourceNames []config_util.Secret `yaml:"data_source_names,omitempty"`
 
 	DisableSettingsMetrics bool     `yaml:"disable_settings_metrics,omitempty"`
 	AutodiscoverDatabases  bool     `yaml:"autodiscover_databases,omitempty"`
@@ -31,6 +32,11 @@ func (c *Config) Name() string {
 	return "postgres_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/wrouesnel/postgres_exporter"
+}
+
 // CommonConfig returns the common set of options shared across all configs for
 // integrations.
 func (c *Config) CommonConfig() config.Common {
@@ -49,7 +55,10 @@ func init() {
 // New creates a new postgres_exporter integration. The integration scrapes
 // metrics from a postgres process.
 func New(log log.Logger, c *Config) (integrations.Integration, error) {
-	dsn := c.DataSourceNames
+	dsn := make([]string, 0, len(c.DataSourceNames))
+	for _, name := range c.DataSourceNames {
+		dsn = append(dsn, string(name))
+	}
 	if len(dsn) == 0 {
 		dsn = strings.Split(os.Getenv("POSTGRES_EXPORTER_DATA_SOURCE_NAME"), ",")
 	}
@@ -0,0 +1,246 @@
+package postgres_replication //nolint:golint
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// stateQuery reports whether the cluster is a standby and, for standbys,
+	// how far replay is behind. The lag columns are NULL on primaries.
+	stateQuery = `
+SELECT
+  pg_is_in_recovery(),
+  EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()),
+  pg_wal_lsn_diff(pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn())`
+
+	// replicasQuery reports the lag of the streaming replicas connected to a
+	// primary.
+	replicasQuery = `
+SELECT
+  COALESCE(application_name, ''),
+  COALESCE(client_addr::text, ''),
+  pg_wal_lsn_diff(pg_current_wal_lsn(), sent_lsn),
+  pg_wal_lsn_diff(pg_current_wal_lsn(), write_lsn),
+  pg_wal_lsn_diff(pg_current_wal_lsn(), flush_lsn),
+  pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn),
+  EXTRACT(EPOCH FROM write_lag),
+  EXTRACT(EPOCH FROM flush_lag),
+  EXTRACT(EPOCH FROM replay_lag)
+FROM pg_stat_replication`
+
+	// slotsQuery reports the WAL retained by the replication slots of a
+	// primary and, for logical slots, how far their consumer is behind.
+	slotsQuery = `
+SELECT
+  slot_name,
+  slot_type,
+  COALESCE(database, ''),
+  active,
+  pg_wal_lsn_diff(pg_current_wal_lsn(), restart_lsn),
+  pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn)
+FROM pg_replication_slots`
+)
+
+// collector collects replication metrics from a set of clusters. Each
+// cluster is queried on every scrape.
+type collector struct {
+	log          log.Logger
+	clusters     []*cluster
+	queryTimeout time.Duration
+
+	up                *prometheus.Desc
+	isPrimary         *prometheus.Desc
+	replicaLagBytes   *prometheus.Desc
+	replicaLagSeconds *prometheus.Desc
+	slotActive        *prometheus.Desc
+	slotRetainedBytes *prometheus.Desc
+	slotLagBytes      *prometheus.Desc
+	standbyLagSeconds *prometheus.Desc
+	standbyLagBytes   *prometheus.Desc
+}
+
+func newCollector(log log.Logger, clusters []*cluster, queryTimeout time.Duration) *collector {
+	var (
+		replicaLabels = []string{"cluster", "application_name", "client_addr", "stage"}
+		slotLabels    = []string{"cluster", "slot_name", "slot_type", "database"}
+	)
+
+	return &collector{
+		log:          log,
+		clusters:     clusters,
+		queryTimeout: queryTimeout,
+
+		up: prometheus.NewDesc(
+			"postgres_replication_up",
+			"Whether the last queries against the cluster succeeded.",
+			[]string{"cluster"}, nil,
+		),
+		isPrimary: prometheus.NewDesc(
+			"postgres_replication_is_primary",
+			"Whether the cluster is a primary (1) or a standby (0).",
+			[]string{"cluster"}, nil,
+		),
+		replicaLagBytes: prometheus.NewDesc(
+			"postgres_replication_replica_lag_bytes",
+			"Bytes of WAL generated by the primary that a streaming replica hasn't reached the given stage (sent, write, flush, replay) for.",
+			replicaLabels, nil,
+		),
+		replicaLagSeconds: prometheus.NewDesc(
+			"postgres_replication_replica_lag_seconds",
+			"Time between the primary flushing WAL and a streaming replica reporting the given stage (write, flush, replay) for it.",
+			replicaLabels, nil,
+		),
+		slotActive: prometheus.NewDesc(
+			"postgres_replication_slot_active",
+			"Whether a consumer is connected to the replication slot.",
+			slotLabels, nil,
+		),
+		slotRetainedBytes: prometheus.NewDesc(
+			"postgres_replication_slot_retained_wal_bytes",
+			"Bytes of WAL the primary retains for the replication slot.",
+			slotLabels, nil,
+		),
+		slotLagBytes: prometheus.NewDesc(
+			"postgres_replication_slot_lag_bytes",
+			"Bytes of WAL generated by the primary that the consumer of a logical replication slot hasn't confirmed yet.",
+			slotLabels, nil,
+		),
+		standbyLagSeconds: prometheus.NewDesc(
+			"postgres_replication_standby_replay_lag_seconds",
+			"Time since the last transaction replayed by the standby was committed on the primary.",
+			[]string{"cluster"}, nil,
+		),
+		standbyLagBytes: prometheus.NewDesc(
+			"postgres_replication_standby_replay_lag_bytes",
+			"Bytes of WAL received by the standby that haven't been replayed yet.",
+			[]string{"cluster"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.isPrimary
+	ch <- c.replicaLagBytes
+	ch <- c.replicaLagSeconds
+	ch <- c.slotActive
+	ch <- c.slotRetainedBytes
+	ch <- c.slotLagBytes
+	ch <- c.standbyLagSeconds
+	ch <- c.standbyLagBytes
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	for _, cl := range c.clusters {
+		ctx, cancel := context.WithTimeout(context.Background(), c.queryTimeout)
+		err := c.collectCluster(ctx, cl, ch)
+		cancel()
+
+		up := 1.0
+		if err != nil {
+			up = 0
+			level.Warn(c.log).Log("msg", "failed to collect replication metrics", "cluster", cl.name, "err", err)
+		}
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up, cl.name)
+	}
+}
+
+func (c *collector) collectCluster(ctx context.Context, cl *cluster, ch chan<- prometheus.Metric) error {
+	var (
+		inRecovery           bool
+		lagSeconds, lagBytes sql.NullFloat64
+	)
+	err := cl.db.QueryRowContext(ctx, stateQuery).Scan(&inRecovery, &lagSeconds, &lagBytes)
+	if err != nil {
+		return err
+	}
+
+	if inRecovery {
+		ch <- prometheus.MustNewConstMetric(c.isPrimary, prometheus.GaugeValue, 0, cl.name)
+		sendNullable(ch, c.standbyLagSeconds, lagSeconds, cl.name)
+		sendNullable(ch, c.standbyLagBytes, lagBytes, cl.name)
+		return nil
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.isPrimary, prometheus.GaugeValue, 1, cl.name)
+	if err := c.collectReplicas(ctx, cl, ch); err != nil {
+		return err
+	}
+	return c.collectSlots(ctx, cl, ch)
+}
+
+func (c *collector) collectReplicas(ctx context.Context, cl *cluster, ch chan<- prometheus.Metric) error {
+	rows, err := cl.db.QueryContext(ctx, replicasQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			appName, clientAddr                            string
+			sentBytes, writeBytes, flushBytes, replayBytes sql.NullFloat64
+			writeLag, flushLag, replayLag                  sql.NullFloat64
+		)
+		err := rows.Scan(&appName, &clientAddr, &sentBytes, &writeBytes, &flushBytes, &replayBytes, &writeLag, &flushLag, &replayLag)
+		if err != nil {
+			return err
+		}
+
+		sendNullable(ch, c.replicaLagBytes, sentBytes, cl.name, appName, clientAddr, "sent")
+		sendNullable(ch, c.replicaLagBytes, writeBytes, cl.name, appName, clientAddr, "write")
+		sendNullable(ch, c.replicaLagBytes, flushBytes, cl.name, appName, clientAddr, "flush")
+		sendNullable(ch, c.replicaLagBytes, replayBytes, cl.name, appName, clientAddr, "replay")
+		sendNullable(ch, c.replicaLagSeconds, writeLag, cl.name, appName, clientAddr, "write")
+		sendNullable(ch, c.replicaLagSeconds, flushLag, cl.name, appName, clientAddr, "flush")
+		sendNullable(ch, c.replicaLagSeconds, replayLag, cl.name, appName, clientAddr, "replay")
+	}
+	return rows.Err()
+}
+
+func (c *collector) collectSlots(ctx context.Context, cl *cluster, ch chan<- prometheus.Metric) error {
+	rows, err := cl.db.QueryContext(ctx, slotsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name, slotType, database string
+			active                   bool
+			retainedBytes, lagBytes  sql.NullFloat64
+		)
+		if err := rows.Scan(&name, &slotType, &database, &active, &retainedBytes, &lagBytes); err != nil {
+			return err
+		}
+		if !cl.keepSlot(name) {
+			continue
+		}
+
+		activeValue := 0.0
+		if active {
+			activeValue = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.slotActive, prometheus.GaugeValue, activeValue, cl.name, name, slotType, database)
+		sendNullable(ch, c.slotRetainedBytes, retainedBytes, cl.name, name, slotType, database)
+		sendNullable(ch, c.slotLagBytes, lagBytes, cl.name, name, slotType, database)
+	}
+	return rows.Err()
+}
+
+// sendNullable sends a gauge for v if it isn't NULL.
+func sendNullable(ch chan<- prometheus.Metric, desc *prometheus.Desc, v sql.NullFloat64, labelValues ...string) {
+	if !v.Valid {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v.Float64, labelValues...)
+}
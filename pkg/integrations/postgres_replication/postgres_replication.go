@@ -0,0 +1,195 @@
+// Package postgres_replication monitors the replication of PostgreSQL
+// clusters: the lag of streaming replicas, the lag and WAL retention of
+// replication slots, and the replay lag of standbys.
+package postgres_replication //nolint:golint
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/integrations"
+	"github.com/grafana/agent/pkg/integrations/config"
+	config_util "github.com/prometheus/common/config"
+
+	// Register the postgres driver.
+	_ "github.com/lib/pq"
+)
+
+// DefaultConfig holds non-zero default options for the Config when it is
+// unmarshaled from YAML.
+var DefaultConfig = Config{
+	QueryTimeout: 10 * time.Second,
+}
+
+// Config controls the postgres_replication integration.
+type Config struct {
+	Common config.Common `yaml:",inline"`
+
+	// Clusters to monitor.
+	Clusters []ClusterConfig `yaml:"clusters,omitempty"`
+
+	// Timeout of the queries run against each cluster per scrape.
+	QueryTimeout time.Duration `yaml:"query_timeout,omitempty"`
+}
+
+// ClusterConfig configures how to monitor a single PostgreSQL cluster.
+type ClusterConfig struct {
+	// Name of the cluster, used as the cluster label of its metrics.
+	Name string `yaml:"name"`
+
+	// DataSourceName is the conninfo string or URL used to connect to the
+	// cluster.
+	DataSourceName config_util.Secret `yaml:"data_source_name"`
+
+	// Replication slots to collect metrics for. Slots are included if they
+	// match any regex of IncludeSlots, or if IncludeSlots is empty, and don't
+	// match any regex of ExcludeSlots. Regexes are anchored.
+	IncludeSlots []string `yaml:"include_slots,omitempty"`
+	ExcludeSlots []string `yaml:"exclude_slots,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for Config.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// Name returns the name of the integration this config is for.
+func (c *Config) Name() string {
+	return "postgres_replication"
+}
+
+// CommonConfig returns the common set of options shared across all configs for
+// integrations.
+func (c *Config) CommonConfig() config.Common {
+	return c.Common
+}
+
+// NewIntegration converts this config into an instance of a configuration.
+func (c *Config) NewIntegration(l log.Logger) (integrations.Integration, error) {
+	return New(l, c)
+}
+
+func init() {
+	integrations.RegisterIntegration(&Config{})
+}
+
+// New creates a new postgres_replication integration.
+func New(log log.Logger, c *Config) (integrations.Integration, error) {
+	if len(c.Clusters) == 0 {
+		return nil, errors.New("cannot create postgres_replication; no clusters configured")
+	}
+
+	clusters := make([]*cluster, 0, len(c.Clusters))
+	closeAll := func() {
+		for _, cl := range clusters {
+			cl.db.Close()
+		}
+	}
+
+	names := make(map[string]struct{}, len(c.Clusters))
+	for _, cc := range c.Clusters {
+		if cc.Name == "" {
+			closeAll()
+			return nil, errors.New("cluster name must not be empty")
+		}
+		if _, ok := names[cc.Name]; ok {
+			closeAll()
+			return nil, fmt.Errorf("found multiple clusters named %q", cc.Name)
+		}
+		names[cc.Name] = struct{}{}
+
+		cl, err := newCluster(cc)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("cluster %q: %w", cc.Name, err)
+		}
+		clusters = append(clusters, cl)
+	}
+
+	return integrations.NewCollectorIntegration(
+		c.Name(),
+		integrations.WithCollectors(newCollector(log, clusters, c.QueryTimeout)),
+		integrations.WithRunner(func(ctx context.Context) error {
+			<-ctx.Done()
+			closeAll()
+			return ctx.Err()
+		}),
+	), nil
+}
+
+// cluster is a PostgreSQL cluster to monitor.
+type cluster struct {
+	name string
+	db   *sql.DB
+
+	includeSlots []*regexp.Regexp
+	excludeSlots []*regexp.Regexp
+}
+
+func newCluster(cc ClusterConfig) (*cluster, error) {
+	if cc.DataSourceName == "" {
+		return nil, errors.New("data_source_name must not be empty")
+	}
+
+	include, err := compileSlotFilters(cc.IncludeSlots)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include_slots: %w", err)
+	}
+	exclude, err := compileSlotFilters(cc.ExcludeSlots)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude_slots: %w", err)
+	}
+
+	// sql.Open doesn't connect, so clusters that are down don't prevent the
+	// integration from starting.
+	db, err := sql.Open("postgres", string(cc.DataSourceName))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	return &cluster{
+		name:         cc.Name,
+		db:           db,
+		includeSlots: include,
+		excludeSlots: exclude,
+	}, nil
+}
+
+func compileSlotFilters(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// keepSlot returns whether metrics should be collected for a slot.
+func (c *cluster) keepSlot(name string) bool {
+	for _, re := range c.excludeSlots {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(c.includeSlots) == 0 {
+		return true
+	}
+	for _, re := range c.includeSlots {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
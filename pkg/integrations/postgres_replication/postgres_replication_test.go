@@ -0,0 +1,123 @@
+package postgres_replication //nolint:golint
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_UnmarshalYAML(t *testing.T) {
+	in := `
+clusters:
+  - name: main
+    data_source_name: postgresql://localhost:5432/postgres?sslmode=disable
+    include_slots: [debezium_.*]
+`
+	var c Config
+	require.NoError(t, yaml.Unmarshal([]byte(in), &c))
+	require.Equal(t, DefaultConfig.QueryTimeout, c.QueryTimeout)
+	require.Len(t, c.Clusters, 1)
+	require.Equal(t, "main", c.Clusters[0].Name)
+	require.Equal(t, []string{"debezium_.*"}, c.Clusters[0].IncludeSlots)
+}
+
+func TestNew_Validation(t *testing.T) {
+	tt := []struct {
+		name        string
+		clusters    []ClusterConfig
+		expectedErr string
+	}{
+		{
+			name:        "no clusters",
+			expectedErr: "cannot create postgres_replication; no clusters configured",
+		},
+		{
+			name:        "missing name",
+			clusters:    []ClusterConfig{{DataSourceName: "dsn"}},
+			expectedErr: "cluster name must not be empty",
+		},
+		{
+			name:        "duplicate name",
+			clusters:    []ClusterConfig{{Name: "a", DataSourceName: "dsn"}, {Name: "a", DataSourceName: "dsn"}},
+			expectedErr: `found multiple clusters named "a"`,
+		},
+		{
+			name:        "missing data_source_name",
+			clusters:    []ClusterConfig{{Name: "a"}},
+			expectedErr: `cluster "a": data_source_name must not be empty`,
+		},
+		{
+			name:        "invalid slot filter",
+			clusters:    []ClusterConfig{{Name: "a", DataSourceName: "dsn", ExcludeSlots: []string{"("}}},
+			expectedErr: `cluster "a": invalid exclude_slots`,
+		},
+		{
+			name:     "valid",
+			clusters: []ClusterConfig{{Name: "a", DataSourceName: "dsn"}, {Name: "b", DataSourceName: "dsn"}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig
+			c.Clusters = tc.clusters
+
+			_, err := New(log.NewNopLogger(), &c)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestCluster_KeepSlot(t *testing.T) {
+	tt := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected map[string]bool
+	}{
+		{
+			name:     "no filters",
+			expected: map[string]bool{"a": true, "b": true},
+		},
+		{
+			name:     "include",
+			include:  []string{"logical_.*"},
+			expected: map[string]bool{"logical_a": true, "physical_a": false, "x_logical_a": false},
+		},
+		{
+			name:     "exclude",
+			exclude:  []string{"tmp"},
+			expected: map[string]bool{"tmp": false, "tmp_a": true, "a": true},
+		},
+		{
+			name:     "exclude wins over include",
+			include:  []string{"logical_.*"},
+			exclude:  []string{"logical_tmp"},
+			expected: map[string]bool{"logical_a": true, "logical_tmp": false},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cl, err := newCluster(ClusterConfig{
+				Name:           "test",
+				DataSourceName: "postgresql://localhost:5432/postgres?sslmode=disable",
+				IncludeSlots:   tc.include,
+				ExcludeSlots:   tc.exclude,
+			})
+			require.NoError(t, err)
+			defer cl.db.Close()
+
+			for slot, keep := range tc.expected {
+				require.Equal(t, keep, cl.keepSlot(slot), "slot %s", slot)
+			}
+		})
+	}
+}
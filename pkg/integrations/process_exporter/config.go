@@ -43,6 +43,11 @@ func (c *Config) Name() string {
 	return "process_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/ncabatoff/process-exporter"
+}
+
 // CommonConfig returns the set of common settings shared across all integrations.
 func (c *Config) CommonConfig() config.Common {
 	return c.Common
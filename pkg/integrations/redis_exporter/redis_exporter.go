@@ -16,6 +16,7 @@ import (
 
 	"github.com/grafana/agent/pkg/integrations"
 	"github.com/grafana/agent/pkg/integrations/config"
+	config_util "github.com/prometheus/common/config"
 )
 
 // DefaultConfig holds non-zero default options for the Config when it is
@@ -39,33 +40,33 @@ type Config struct {
 	//
 	// The exporter binary config differs to this, but these
 	// are the only fields that are relevant to the exporter struct.
-	RedisAddr               string        `yaml:"redis_addr,omitempty"`
-	RedisUser               string        `yaml:"redis_user,omitempty"`
-	RedisPassword           string        `yaml:"redis_password,omitempty"`
-	RedisPasswordFile       string        `yaml:"redis_password_file,omitempty"`
-	Namespace               string        `yaml:"namespace,omitempty"`
-	ConfigCommand           string        `yaml:"config_command,omitempty"`
-	CheckKeys               string        `yaml:"check_keys,omitempty"`
-	CheckKeyGroups          string        `yaml:"check_key_groups,omitempty"`
-	CheckKeyGroupsBatchSize int64         `yaml:"check_key_groups_batch_size,omitempty"`
-	MaxDistinctKeyGroups    int64         `yaml:"max_distinct_key_groups,omitempty"`
-	CheckSingleKeys         string        `yaml:"check_single_keys,omitempty"`
-	CheckStreams            string        `yaml:"check_streams,omitempty"`
-	CheckSingleStreams      string        `yaml:"check_single_streams,omitempty"`
-	CountKeys               string        `yaml:"count_keys,omitempty"`
-	ScriptPath              string        `yaml:"script_path,omitempty"`
-	ConnectionTimeout       time.Duration `yaml:"connection_timeout,omitempty"`
-	TLSClientKeyFile        string        `yaml:"tls_client_key_file,omitempty"`
-	TLSClientCertFile       string        `yaml:"tls_client_cert_file,omitempty"`
-	TLSCaCertFile           string        `yaml:"tls_ca_cert_file,omitempty"`
-	SetClientName           bool          `yaml:"set_client_name,omitempty"`
-	IsTile38                bool          `yaml:"is_tile38,omitempty"`
-	ExportClientList        bool          `yaml:"export_client_list,omitempty"`
-	ExportClientPort        bool          `yaml:"export_client_port,omitempty"`
-	RedisMetricsOnly        bool          `yaml:"redis_metrics_only,omitempty"`
-	PingOnConnect           bool          `yaml:"ping_on_connect,omitempty"`
-	InclSystemMetrics       bool          `yaml:"incl_system_metrics,omitempty"`
-	SkipTLSVerification     bool          `yaml:"skip_tls_verification,omitempty"`
+	RedisAddr               string             `yaml:"redis_addr,omitempty"`
+	RedisUser               string             `yaml:"redis_user,omitempty"`
+	RedisPassword           config_util.Secret `yaml:"redis_password,omitempty"`
+	RedisPasswordFile       string             `yaml:"redis_password_file,omitempty"`
+	Namespace               string             `yaml:"namespace,omitempty"`
+	ConfigCommand           string             `yaml:"config_command,omitempty"`
+	CheckKeys               string             `yaml:"check_keys,omitempty"`
+	CheckKeyGroups          string             `yaml:"check_key_groups,omitempty"`
+	CheckKeyGroupsBatchSize int64              `yaml:"check_key_groups_batch_size,omitempty"`
+	MaxDistinctKeyGroups    int64              `yaml:"max_distinct_key_groups,omitempty"`
+	CheckSingleKeys         string             `yaml:"check_single_keys,omitempty"`
+	CheckStreams            string             `yaml:"check_streams,omitempty"`
+	CheckSingleStreams      string             `yaml:"check_single_streams,omitempty"`
+	CountKeys               string             `yaml:"count_keys,omitempty"`
+	ScriptPath              string             `yaml:"script_path,omitempty"`
+	ConnectionTimeout       time.Duration      `yaml:"connection_timeout,omitempty"`
+	TLSClientKeyFile        string             `yaml:"tls_client_key_file,omitempty"`
+	TLSClientCertFile       string             `yaml:"tls_client_cert_file,omitempty"`
+	TLSCaCertFile           string             `yaml:"tls_ca_cert_file,omitempty"`
+	SetClientName           bool               `yaml:"set_client_name,omitempty"`
+	IsTile38                bool               `yaml:"is_tile38,omitempty"`
+	ExportClientList        bool               `yaml:"export_client_list,omitempty"`
+	ExportClientPort        bool               `yaml:"export_client_port,omitempty"`
+	RedisMetricsOnly        bool               `yaml:"redis_metrics_only,omitempty"`
+	PingOnConnect           bool               `yaml:"ping_on_connect,omitempty"`
+	InclSystemMetrics       bool               `yaml:"incl_system_metrics,omitempty"`
+	SkipTLSVerification     bool               `yaml:"skip_tls_verification,omitempty"`
 }
 
 // GetExporterOptions returns relevant Config properties as a redis_exporter
@@ -74,7 +75,7 @@ type Config struct {
 func (c Config) GetExporterOptions() re.Options {
 	return re.Options{
 		User:                    c.RedisUser,
-		Password:                c.RedisPassword,
+		Password:                string(c.RedisPassword),
 		Namespace:               c.Namespace,
 		ConfigCommandName:       c.ConfigCommand,
 		CheckKeys:               c.CheckKeys,
@@ -109,6 +110,11 @@ func (c *Config) Name() string {
 	return "redis_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/oliver006/redis_exporter"
+}
+
 // CommonConfig returns the common set of settings shared across all configs
 // for integrations.
 func (c *Config) CommonConfig() config.Common {
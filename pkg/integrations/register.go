@@ -28,6 +28,10 @@ func RegisterIntegration(cfg Config) {
 	}
 	registeredIntegrations = append(registeredIntegrations, cfg)
 	configFieldNames[reflect.TypeOf(cfg)] = cfg.Name()
+
+	if _, version, ok := upstreamInfo(cfg); ok {
+		integrationBuildInfo.WithLabelValues(cfg.Name(), version).Set(1)
+	}
 }
 
 // Configs is a list of integrations.
@@ -80,6 +80,11 @@ func (c *Config) Name() string {
 	return "statsd_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/prometheus/statsd_exporter"
+}
+
 // CommonConfig returns the common settings shared across all integrations.
 func (c *Config) CommonConfig() config.Common {
 	return c.Common
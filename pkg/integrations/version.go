@@ -0,0 +1,70 @@
+package integrations
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// UnknownVersion is reported as the upstream version when the version of an
+// embedded exporter can't be determined from the Agent's build information.
+const UnknownVersion = "unknown"
+
+var integrationBuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "agent_integration_build_info",
+	Help: "A metric with a constant '1' value labeled by the name of the integration and the version of the upstream exporter it embeds.",
+}, []string{"integration", "upstream_version"})
+
+// UpstreamConfig is implemented by Configs for integrations that embed an
+// upstream exporter.
+type UpstreamConfig interface {
+	// UpstreamModule returns the Go module path of the embedded exporter.
+	UpstreamModule() string
+}
+
+var (
+	buildInfoOnce sync.Once
+	buildInfo     *debug.BuildInfo
+)
+
+// UpstreamVersion returns the version of the Go module that was compiled into
+// the Agent. If the module is replaced, the version of the replacement is
+// returned instead. Returns UnknownVersion if the module isn't part of the
+// build.
+func UpstreamVersion(module string) string {
+	buildInfoOnce.Do(func() {
+		buildInfo, _ = debug.ReadBuildInfo()
+	})
+	if buildInfo == nil {
+		return UnknownVersion
+	}
+
+	for _, dep := range buildInfo.Deps {
+		if dep.Path != module {
+			continue
+		}
+		version := dep.Version
+		if dep.Replace != nil {
+			version = dep.Replace.Version
+		}
+		if version == "" {
+			return UnknownVersion
+		}
+		return version
+	}
+	return UnknownVersion
+}
+
+// upstreamInfo returns the upstream module and version of the integration
+// represented by cfg. ok is false if the integration doesn't embed an upstream
+// exporter.
+func upstreamInfo(cfg Config) (module, version string, ok bool) {
+	uc, ok := cfg.(UpstreamConfig)
+	if !ok {
+		return "", "", false
+	}
+	module = uc.UpstreamModule()
+	return module, UpstreamVersion(module), true
+}
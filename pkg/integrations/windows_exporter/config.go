@@ -26,6 +26,7 @@ type Config struct {
 	MSSQL       MSSQLConfig       `yaml:"mssql,omitempty"`
 	MSMQ        MSMQConfig        `yaml:"msmq,omitempty"`
 	LogicalDisk LogicalDiskConfig `yaml:"logical_disk,omitempty"`
+	MSCluster   MSClusterConfig   `yaml:"mscluster,omitempty"`
 }
 
 // Name returns the name used, "windows_explorer"
@@ -33,6 +34,11 @@ func (c *Config) Name() string {
 	return "windows_exporter"
 }
 
+// UpstreamModule returns the Go module of the embedded exporter.
+func (c *Config) UpstreamModule() string {
+	return "github.com/prometheus-community/windows_exporter"
+}
+
 // CommonConfig returns the common fields that all integrations have
 func (c *Config) CommonConfig() config.Common {
 	return c.Common
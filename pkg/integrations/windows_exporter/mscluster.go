@@ -0,0 +1,138 @@
+package windows_exporter //nolint:golint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Prefixes of the metrics of the failover cluster collectors that are
+// filtered by node and resource name.
+const (
+	msclusterNodePrefix     = "mscluster_node_"
+	msclusterResourcePrefix = "mscluster_resource_"
+)
+
+// MSClusterConfig handles settings for the windows_exporter failover cluster
+// collectors (mscluster_cluster, mscluster_network, mscluster_node,
+// mscluster_resource and mscluster_resourcegroup). The upstream collectors
+// don't support filtering, so the Agent filters the metrics they return.
+type MSClusterConfig struct {
+	NodeWhiteList     string `yaml:"node_whitelist,omitempty"`
+	NodeBlackList     string `yaml:"node_blacklist,omitempty"`
+	ResourceWhiteList string `yaml:"resource_whitelist,omitempty"`
+	ResourceBlackList string `yaml:"resource_blacklist,omitempty"`
+}
+
+// nameFilter keeps names matching the whitelist and not matching the
+// blacklist. Both regexes are anchored.
+type nameFilter struct {
+	whitelist *regexp.Regexp
+	blacklist *regexp.Regexp
+}
+
+func newNameFilter(field, whitelist, blacklist string) (*nameFilter, error) {
+	if whitelist == "" && blacklist == "" {
+		return nil, nil
+	}
+
+	var (
+		f   nameFilter
+		err error
+	)
+	if whitelist != "" {
+		if f.whitelist, err = regexp.Compile("^(?:" + whitelist + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid mscluster %s_whitelist: %w", field, err)
+		}
+	}
+	if blacklist != "" {
+		if f.blacklist, err = regexp.Compile("^(?:" + blacklist + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid mscluster %s_blacklist: %w", field, err)
+		}
+	}
+	return &f, nil
+}
+
+func (f *nameFilter) keep(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.whitelist != nil && !f.whitelist.MatchString(name) {
+		return false
+	}
+	return f.blacklist == nil || !f.blacklist.MatchString(name)
+}
+
+// wrap returns c filtered by the node and resource filters, or c itself if
+// no filter is set.
+func (c *MSClusterConfig) wrap(col prometheus.Collector) (prometheus.Collector, error) {
+	nodes, err := newNameFilter("node", c.NodeWhiteList, c.NodeBlackList)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := newNameFilter("resource", c.ResourceWhiteList, c.ResourceBlackList)
+	if err != nil {
+		return nil, err
+	}
+	if nodes == nil && resources == nil {
+		return col, nil
+	}
+	return &msclusterFilter{Collector: col, nodes: nodes, resources: resources}, nil
+}
+
+// msclusterFilter drops the node and resource metrics of the failover
+// cluster collectors whose name label is filtered out.
+type msclusterFilter struct {
+	prometheus.Collector
+	nodes     *nameFilter
+	resources *nameFilter
+}
+
+// Collect implements prometheus.Collector.
+func (f *msclusterFilter) Collect(ch chan<- prometheus.Metric) {
+	inner := make(chan prometheus.Metric)
+	go func() {
+		f.Collector.Collect(inner)
+		close(inner)
+	}()
+
+	for m := range inner {
+		if f.keep(m) {
+			ch <- m
+		}
+	}
+}
+
+func (f *msclusterFilter) keep(m prometheus.Metric) bool {
+	// Desc has no accessor for the metric name, but its string form always
+	// includes it.
+	desc := m.Desc().String()
+
+	var filter *nameFilter
+	switch {
+	case strings.Contains(desc, `fqName: "`+msclusterNodePrefix):
+		filter = f.nodes
+	case strings.Contains(desc, `fqName: "`+msclusterResourcePrefix):
+		filter = f.resources
+	default:
+		return true
+	}
+	if filter == nil {
+		return true
+	}
+
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		// Let the registry report the broken metric.
+		return true
+	}
+	for _, l := range pb.GetLabel() {
+		if l.GetName() == "name" {
+			return filter.keep(l.GetValue())
+		}
+	}
+	return true
+}
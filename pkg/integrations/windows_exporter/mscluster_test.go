@@ -0,0 +1,82 @@
+package windows_exporter //nolint:golint
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMSClusterConfig_Wrap(t *testing.T) {
+	node := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "mscluster_node_state"}, []string{"name"})
+	node.WithLabelValues("node-1").Set(1)
+	node.WithLabelValues("node-2").Set(1)
+	node.WithLabelValues("witness").Set(1)
+
+	resource := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "mscluster_resource_state"}, []string{"type", "name"})
+	resource.WithLabelValues("IP Address", "Cluster IP Address").Set(1)
+	resource.WithLabelValues("Physical Disk", "Cluster Disk 1").Set(1)
+
+	// Resource groups share the name label but aren't filtered.
+	group := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "mscluster_resourcegroup_state"}, []string{"name"})
+	group.WithLabelValues("Cluster Group").Set(1)
+
+	cfg := MSClusterConfig{
+		NodeWhiteList:     "node-.*",
+		NodeBlackList:     "node-2",
+		ResourceBlackList: "Cluster Disk.*",
+	}
+	col, err := cfg.wrap(collectorFunc(func(ch chan<- prometheus.Metric) {
+		node.Collect(ch)
+		resource.Collect(ch)
+		group.Collect(ch)
+	}))
+	require.NoError(t, err)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(unchecked{col})
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+
+	var series []string
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "name" {
+					series = append(series, mf.GetName()+"/"+l.GetValue())
+				}
+			}
+		}
+	}
+	sort.Strings(series)
+	require.Equal(t, []string{
+		"mscluster_node_state/node-1",
+		"mscluster_resource_state/Cluster IP Address",
+		"mscluster_resourcegroup_state/Cluster Group",
+	}, series)
+}
+
+func TestMSClusterConfig_Wrap_NoFilter(t *testing.T) {
+	col := collectorFunc(func(chan<- prometheus.Metric) {})
+
+	var cfg MSClusterConfig
+	wrapped, err := cfg.wrap(col)
+	require.NoError(t, err)
+	require.IsType(t, col, wrapped, "collector should not be wrapped without filters")
+
+	cfg.NodeWhiteList = "("
+	_, err = cfg.wrap(col)
+	require.EqualError(t, err, "invalid mscluster node_whitelist: error parsing regexp: missing closing ): `^(?:()$`")
+}
+
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(chan<- *prometheus.Desc)    {}
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) { f(ch) }
+
+// unchecked registers a collector without describing its metrics, like the
+// windows_exporter collector does.
+type unchecked struct{ prometheus.Collector }
+
+func (unchecked) Describe(chan<- *prometheus.Desc) {}
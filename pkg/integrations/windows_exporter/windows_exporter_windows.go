@@ -15,6 +15,10 @@ func New(log log.Logger, c *Config) (integrations.Integration, error) {
 	if err != nil {
 		return nil, err
 	}
+	col, err := c.MSCluster.wrap(wc)
+	if err != nil {
+		return nil, err
+	}
 	_ = level.Info(log).Log("msg", "Enabled windows_exporter collectors")
-	return integrations.NewCollectorIntegration(c.Name(), integrations.WithCollectors(wc)), nil
+	return integrations.NewCollectorIntegration(c.Name(), integrations.WithCollectors(col)), nil
 }
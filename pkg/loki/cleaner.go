@@ -0,0 +1,154 @@
+package loki
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Default settings for the positions cleaner.
+const (
+	DefaultPositionsCleanupAge    = 12 * time.Hour
+	DefaultPositionsCleanupPeriod = 30 * time.Minute
+)
+
+var (
+	positionsCleanedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_loki_positions_cleaner_cleaned_total",
+			Help: "Total number of successfully removed abandoned positions files",
+		},
+	)
+
+	positionsCleanupErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_loki_positions_cleaner_errors_total",
+			Help: "Total number of errors finding or removing abandoned positions files",
+		},
+	)
+)
+
+// positionsCleaner periodically removes positions files from the positions
+// directory that don't belong to any running instance and haven't been
+// written to in some configured amount of time.
+//
+// Only files directly inside of the positions directory are considered;
+// positions files configured elsewhere are never touched.
+type positionsCleaner struct {
+	log    log.Logger
+	dir    string
+	inUse  map[string]bool
+	minAge time.Duration
+	period time.Duration
+	dryRun bool
+	done   chan bool
+}
+
+// newPositionsCleaner creates a new positionsCleaner and starts a goroutine to
+// run cleanup in a loop. inUse is the set of positions files used by running
+// instances. A period of 0 disables the periodic cleanup. When dryRun is true,
+// abandoned positions files are logged but not removed.
+func newPositionsCleaner(l log.Logger, dir string, inUse map[string]bool, minAge, period time.Duration, dryRun bool) *positionsCleaner {
+	c := &positionsCleaner{
+		log:    log.With(l, "component", "positions_cleaner"),
+		dir:    filepath.Clean(dir),
+		inUse:  make(map[string]bool, len(inUse)),
+		minAge: DefaultPositionsCleanupAge,
+		period: DefaultPositionsCleanupPeriod,
+		dryRun: dryRun,
+		done:   make(chan bool),
+	}
+	for path := range inUse {
+		c.inUse[filepath.Clean(path)] = true
+	}
+
+	if minAge > 0 {
+		c.minAge = minAge
+	}
+	if period >= 0 {
+		c.period = period
+	}
+
+	go c.run()
+	return c
+}
+
+func (c *positionsCleaner) run() {
+	// A period of 0 means don't run a cleanup task
+	if c.period == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.cleanup(time.Now())
+		}
+	}
+}
+
+// cleanup removes abandoned positions files and returns the paths of the
+// files that were removed. In dry-run mode, the files that would have been
+// removed are returned instead.
+func (c *positionsCleaner) cleanup(now time.Time) []string {
+	infos, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		positionsCleanupErrors.Inc()
+		level.Warn(c.log).Log("msg", "unable to read positions directory", "path", c.dir, "err", err)
+		return nil
+	}
+
+	var removed []string
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || filepath.Ext(info.Name()) != ".yml" {
+			continue
+		}
+
+		path := filepath.Join(c.dir, info.Name())
+		if c.inUse[path] {
+			continue
+		}
+
+		// Promtail rewrites positions files on every sync, so the mtime reflects
+		// the last time an instance was using the file.
+		if diff := now.Sub(info.ModTime()); diff <= c.minAge {
+			level.Debug(c.log).Log("msg", "abandoned positions file is too new to remove", "path", path, "diff", diff)
+			continue
+		}
+
+		if c.dryRun {
+			level.Info(c.log).Log("msg", "would delete abandoned positions file (dry run)", "path", path)
+			removed = append(removed, path)
+			continue
+		}
+
+		level.Info(c.log).Log("msg", "deleting abandoned positions file", "path", path)
+		if err := os.Remove(path); err != nil {
+			positionsCleanupErrors.Inc()
+			level.Error(c.log).Log("msg", "failed to delete abandoned positions file", "path", path, "err", err)
+			continue
+		}
+		positionsCleanedTotal.Inc()
+		removed = append(removed, path)
+	}
+
+	return removed
+}
+
+// Stop stops the cleaner.
+func (c *positionsCleaner) Stop() {
+	close(c.done)
+}
@@ -0,0 +1,76 @@
+package loki
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositionsCleaner_cleanup(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "positions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		now = time.Now()
+		old = now.Add(-time.Hour)
+
+		inUseFile     = filepath.Join(dir, "in-use.yml")
+		abandonedFile = filepath.Join(dir, "abandoned.yml")
+		recentFile    = filepath.Join(dir, "recent.yml")
+		otherFile     = filepath.Join(dir, "other.txt")
+	)
+
+	for _, path := range []string{inUseFile, abandonedFile, recentFile, otherFile} {
+		require.NoError(t, ioutil.WriteFile(path, []byte("positions: {}\n"), 0600))
+	}
+	for _, path := range []string{inUseFile, abandonedFile, otherFile} {
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+
+	c := newPositionsCleaner(log.NewNopLogger(), dir, map[string]bool{inUseFile: true}, 5*time.Minute, 0, false)
+	defer c.Stop()
+
+	removed := c.cleanup(now)
+	require.Equal(t, []string{abandonedFile}, removed)
+
+	require.FileExists(t, inUseFile)
+	require.FileExists(t, recentFile)
+	require.FileExists(t, otherFile)
+	require.NoFileExists(t, abandonedFile)
+}
+
+func TestPositionsCleaner_dryRun(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "positions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		now           = time.Now()
+		old           = now.Add(-time.Hour)
+		abandonedFile = filepath.Join(dir, "abandoned.yml")
+	)
+	require.NoError(t, ioutil.WriteFile(abandonedFile, []byte("positions: {}\n"), 0600))
+	require.NoError(t, os.Chtimes(abandonedFile, old, old))
+
+	c := newPositionsCleaner(log.NewNopLogger(), dir, nil, 5*time.Minute, 0, true)
+	defer c.Stop()
+
+	removed := c.cleanup(now)
+	require.Equal(t, []string{abandonedFile}, removed)
+	require.FileExists(t, abandonedFile)
+}
+
+func TestPositionsCleaner_missingDirectory(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "positionsMissingDirectory")
+
+	c := newPositionsCleaner(log.NewNopLogger(), dir, nil, 5*time.Minute, 0, false)
+	defer c.Stop()
+
+	require.Empty(t, c.cleanup(time.Now()))
+}
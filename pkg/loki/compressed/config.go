@@ -0,0 +1,67 @@
+package compressed
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/grafana/loki/pkg/logentry/stages"
+	"github.com/prometheus/common/model"
+)
+
+// Default settings for compressed scrape configs.
+const (
+	DefaultRefreshInterval = 10 * time.Second
+	DefaultBytesPerSecond  = 4 << 20
+)
+
+// Config reads the log lines of gzip and zstd compressed files.
+type Config struct {
+	// JobName identifies the config. It's used for the metrics of pipeline
+	// stages and of the files read.
+	JobName string `yaml:"job_name"`
+
+	// Paths are glob patterns of the files to read.
+	Paths []string `yaml:"paths"`
+
+	// RefreshInterval is how often Paths are matched again to find new or
+	// grown files.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+
+	// BytesPerSecond limits how many decompressed bytes are read per second,
+	// bounding the CPU used for decompression. 0 disables the limit.
+	BytesPerSecond int `yaml:"bytes_per_second,omitempty"`
+
+	// Labels are added to every log line.
+	Labels model.LabelSet `yaml:"labels,omitempty"`
+
+	PipelineStages stages.PipelineStages `yaml:"pipeline_stages,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.RefreshInterval = DefaultRefreshInterval
+	c.BytesPerSecond = DefaultBytesPerSecond
+
+	type config Config
+	if err := unmarshal((*config)(c)); err != nil {
+		return err
+	}
+
+	switch {
+	case c.JobName == "":
+		return fmt.Errorf("compressed scrape configs must have a job_name")
+	case len(c.Paths) == 0:
+		return fmt.Errorf("compressed scrape config %s must have at least one path", c.JobName)
+	case c.RefreshInterval <= 0:
+		return fmt.Errorf("refresh_interval of compressed scrape config %s must be greater than 0", c.JobName)
+	case c.BytesPerSecond < 0:
+		return fmt.Errorf("bytes_per_second of compressed scrape config %s must not be negative", c.JobName)
+	}
+	for _, pattern := range c.Paths {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid path %q of compressed scrape config %s: %w", pattern, c.JobName, err)
+		}
+	}
+	return nil
+}
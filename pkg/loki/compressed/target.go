@@ -0,0 +1,436 @@
+// Package compressed implements Loki scrape configs that read the log lines
+// of gzip and zstd compressed files, like rotated logs and archives of
+// historical logs. Promtail file targets read every file as plain text, so
+// they send compressed files as binary data.
+package compressed
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/loki/pkg/logentry/stages"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/promtail/positions"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"golang.org/x/time/rate"
+)
+
+// FilenameLabel is the label holding the path of the file a log line was
+// read from.
+const FilenameLabel = "filename"
+
+const (
+	// defaultSyncPeriod is how often positions are written when no sync
+	// period is configured.
+	defaultSyncPeriod = 10 * time.Second
+
+	// maxLineSize is the size above which log lines are truncated, so files
+	// without line endings don't have to be held in memory.
+	maxLineSize = 1 << 20
+
+	// maxWindowSize is the largest zstd window accepted. Frames asking for
+	// more memory than this to be decoded are rejected.
+	maxWindowSize = 64 << 20
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type metrics struct {
+	readBytes   *prometheus.CounterVec
+	readLines   *prometheus.CounterVec
+	failedFiles *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	return &metrics{
+		readBytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_loki_compressed_read_bytes_total",
+			Help: "Total number of decompressed bytes read from compressed files.",
+		}, []string{"job"}),
+		readLines: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_loki_compressed_read_lines_total",
+			Help: "Total number of log lines read from compressed files.",
+		}, []string{"job"}),
+		failedFiles: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_loki_compressed_failed_files_total",
+			Help: "Total number of times a compressed file couldn't be read.",
+		}, []string{"job"}),
+	}
+}
+
+// Manager runs compressed scrape configs, sending the log lines of the files
+// they match to an entry handler.
+type Manager struct {
+	positions positions.Positions
+	readers   []*reader
+
+	mut     sync.Mutex
+	claimed map[string]string // path -> job name reading it
+}
+
+// NewManager creates and starts a Manager. How much of every file was read
+// is stored in positionsFile, so lines aren't read again after a restart.
+func NewManager(l log.Logger, reg prometheus.Registerer, positionsFile string, syncPeriod time.Duration, next api.EntryHandler, configs []Config) (*Manager, error) {
+	l = log.With(l, "component", "compressed")
+
+	if syncPeriod <= 0 {
+		syncPeriod = defaultSyncPeriod
+	}
+	ps, err := positions.New(l, positions.Config{
+		SyncPeriod:    syncPeriod,
+		PositionsFile: positionsFile,
+		// Losing the positions only means reading some lines again, so an
+		// invalid file is replaced instead of preventing logs from being
+		// read.
+		IgnoreInvalidYaml: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed positions file: %w", err)
+	}
+
+	m := &Manager{
+		positions: ps,
+		claimed:   make(map[string]string),
+	}
+	met := newMetrics(reg)
+
+	for _, cfg := range configs {
+		r, err := newReader(log.With(l, "job", cfg.JobName), reg, met, cfg, ps, next, m.claim)
+		if err != nil {
+			m.Stop()
+			return nil, fmt.Errorf("failed to create compressed scrape config %s: %w", cfg.JobName, err)
+		}
+		m.readers = append(m.readers, r)
+	}
+	for _, r := range m.readers {
+		r.wg.Add(1)
+		go r.run()
+	}
+	return m, nil
+}
+
+// claim returns true if path is read by the config named job. A file matched
+// by several configs is only read by the first one that found it, since its
+// position is stored under its path.
+func (m *Manager) claim(path, job string) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if owner, ok := m.claimed[path]; ok {
+		return owner == job
+	}
+	m.claimed[path] = job
+	return true
+}
+
+// Stop stops reading files and saves their positions.
+func (m *Manager) Stop() {
+	for _, r := range m.readers {
+		r.stop()
+	}
+	m.positions.Stop()
+}
+
+// reader periodically matches the paths of a config and reads the lines of
+// compressed files that are new or grew since they were last read. Files are
+// read one at a time.
+type reader struct {
+	cfg       Config
+	log       log.Logger
+	metrics   *metrics
+	handler   api.EntryHandler
+	positions positions.Positions
+	limiter   *rate.Limiter
+	claim     func(path, job string) bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newReader(l log.Logger, reg prometheus.Registerer, met *metrics, cfg Config, ps positions.Positions, next api.EntryHandler, claim func(path, job string) bool) (*reader, error) {
+	jobName := cfg.JobName
+	pipeline, err := stages.NewPipeline(l, cfg.PipelineStages, &jobName, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	var limiter *rate.Limiter
+	if cfg.BytesPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(cfg.BytesPerSecond), cfg.BytesPerSecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &reader{
+		cfg:       cfg,
+		log:       l,
+		metrics:   met,
+		handler:   pipeline.Wrap(next),
+		positions: ps,
+		limiter:   limiter,
+		claim:     claim,
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+func (r *reader) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		r.refresh()
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh reads every file matched by the paths of the config.
+func (r *reader) refresh() {
+	for _, pattern := range r.cfg.Paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			level.Warn(r.log).Log("msg", "failed to match compressed files", "pattern", pattern, "err", err)
+			continue
+		}
+
+		for _, path := range matches {
+			if r.ctx.Err() != nil {
+				return
+			}
+			if !r.claim(path, r.cfg.JobName) {
+				continue
+			}
+			if err := r.readFile(path); err != nil && r.ctx.Err() == nil {
+				r.metrics.failedFiles.WithLabelValues(r.cfg.JobName).Inc()
+				level.Warn(r.log).Log("msg", "failed to read compressed file", "path", path, "err", err)
+			}
+		}
+	}
+}
+
+// readFile sends the lines of path that weren't read yet. Nothing is read if
+// the file has the same size as when it was last read, and it's read from
+// the start if it got smaller, since it was then replaced.
+//
+// Reading stops at the end of the data written so far if the file is still
+// being compressed, and continues from there once the file grew.
+func (r *reader) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return nil
+	}
+
+	size := fi.Size()
+	offset, readSize := r.position(path)
+	switch {
+	case size == readSize:
+		return nil
+	case size < readSize:
+		offset = 0
+	}
+
+	header := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var dr io.Reader
+	switch {
+	case bytes.HasPrefix(header[:n], gzipMagic):
+		gr, err := gzip.NewReader(f)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// The header isn't fully written yet.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer gr.Close()
+		dr = gr
+	case bytes.HasPrefix(header[:n], zstdMagic):
+		zr, err := zstd.NewReader(f,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxMemory(maxWindowSize),
+		)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		dr = zr
+	default:
+		level.Debug(r.log).Log("msg", "skipping file that isn't gzip or zstd compressed", "path", path)
+		return nil
+	}
+
+	br := bufio.NewReaderSize(&limitedReader{ctx: r.ctx, r: dr, limiter: r.limiter}, 64<<10)
+	if _, err := io.CopyN(ioutil.Discard, br, offset); err == io.EOF {
+		// There's less data than was read before, so the file was replaced
+		// by one of the same size or larger.
+		r.positions.Remove(path)
+		return fmt.Errorf("file is shorter than its stored position %d, reading it again on the next refresh", offset)
+	} else if err != nil {
+		return err
+	}
+
+	labels := r.cfg.Labels.Clone()
+	if labels == nil {
+		labels = model.LabelSet{}
+	}
+	labels[FilenameLabel] = model.LabelValue(path)
+
+	for {
+		line, n, err := readLine(br)
+
+		// A line without a line ending is only complete at the end of the
+		// file.
+		if err == nil || (err == io.EOF && n > 0) {
+			select {
+			case r.handler.Chan() <- api.Entry{
+				Labels: labels.Clone(),
+				Entry:  logproto.Entry{Timestamp: time.Now(), Line: string(line)},
+			}:
+			case <-r.ctx.Done():
+				return r.ctx.Err()
+			}
+
+			offset += int64(n)
+			r.metrics.readBytes.WithLabelValues(r.cfg.JobName).Add(float64(n))
+			r.metrics.readLines.WithLabelValues(r.cfg.JobName).Inc()
+			// The size is only stored once the file was read so it's read
+			// again after a restart.
+			r.putPosition(path, offset, 0)
+		}
+
+		switch {
+		case err == nil:
+			continue
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			// The file was read up to the end of the data written so far.
+			r.putPosition(path, offset, size)
+			return nil
+		case r.ctx.Err() != nil:
+			return r.ctx.Err()
+		default:
+			// Corrupted files aren't read again until they change.
+			r.putPosition(path, offset, size)
+			return err
+		}
+	}
+}
+
+// position returns how many decompressed bytes of path were read and the
+// size of the file when they were.
+func (r *reader) position(path string) (offset, size int64) {
+	parts := strings.SplitN(r.positions.GetString(path), ":", 2)
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	offset, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+	size, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+	return offset, size
+}
+
+func (r *reader) putPosition(path string, offset, size int64) {
+	r.positions.PutString(path, fmt.Sprintf("%d:%d", offset, size))
+}
+
+func (r *reader) stop() {
+	r.cancel()
+	r.wg.Wait()
+	r.handler.Stop()
+}
+
+// readLine reads a line from br without its line ending, along with the
+// number of bytes read. Lines longer than maxLineSize are truncated.
+func readLine(br *bufio.Reader) (line []byte, n int, err error) {
+	for {
+		chunk, err := br.ReadSlice('\n')
+		n += len(chunk)
+		if room := maxLineSize - len(line); room > 0 {
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		return line, n, err
+	}
+}
+
+// limitedReader is an io.Reader that waits on limiter for the bytes read
+// before returning them. A nil limiter allows any rate.
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.limiter == nil {
+		return r.r.Read(p)
+	}
+
+	// Reads can't be larger than what the limiter allows at once.
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return 0, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,232 @@
+package compressed
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v2"
+)
+
+func TestManager(t *testing.T) {
+	var (
+		dir           = t.TempDir()
+		positionsFile = filepath.Join(t.TempDir(), "positions.yml")
+		gzipFile      = filepath.Join(dir, "app.log.1.gz")
+		zstdFile      = filepath.Join(dir, "app.log.2.zst")
+	)
+	writeFile(t, gzipFile, gzipData(t, "gzip 1\ngzip 2\n"))
+	writeFile(t, zstdFile, zstdData(t, "zstd 1\r\nzstd 2"))
+	// Plain text files are left to Promtail file targets.
+	writeFile(t, filepath.Join(dir, "app.log"), []byte("plain\n"))
+
+	var cfg Config
+	err := yaml.UnmarshalStrict([]byte(fmt.Sprintf(`
+job_name: rotated
+paths: [%s/app.log*]
+refresh_interval: 10ms
+labels:
+  host: node-1
+`, dir)), &cfg)
+	require.NoError(t, err)
+
+	run := func(sink *entrySink, expect []string) {
+		t.Helper()
+
+		m, err := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), positionsFile, time.Hour, sink, []Config{cfg})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return len(sink.received()) >= len(expect)
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// Files are matched again by every refresh, so lines must not be
+		// sent twice.
+		time.Sleep(100 * time.Millisecond)
+		m.Stop()
+		require.ElementsMatch(t, expect, sink.received())
+	}
+
+	run(newEntrySink(), []string{
+		fmt.Sprintf(`{filename="%s", host="node-1"} gzip 1`, gzipFile),
+		fmt.Sprintf(`{filename="%s", host="node-1"} gzip 2`, gzipFile),
+		fmt.Sprintf(`{filename="%s", host="node-1"} zstd 1`, zstdFile),
+		fmt.Sprintf(`{filename="%s", host="node-1"} zstd 2`, zstdFile),
+	})
+
+	// Files aren't read again after a restart, and only the lines of a new
+	// gzip member are read from a file that grew.
+	f, err := os.OpenFile(gzipFile, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write(gzipData(t, "gzip 3\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	run(newEntrySink(), []string{
+		fmt.Sprintf(`{filename="%s", host="node-1"} gzip 3`, gzipFile),
+	})
+
+	buf, err := ioutil.ReadFile(positionsFile)
+	require.NoError(t, err)
+	require.Contains(t, string(buf), zstdFile+`: "14:`)
+}
+
+func TestManager_PartialFile(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "app.log.1.gz")
+	)
+
+	var lines strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&lines, "line %d\n", i)
+	}
+	data := gzipData(t, lines.String())
+
+	// Only the first half of the file was compressed so far.
+	writeFile(t, path, data[:len(data)/2])
+
+	cfg := Config{JobName: "rotated", Paths: []string{filepath.Join(dir, "*.gz")}, RefreshInterval: 10 * time.Millisecond}
+	sink := newEntrySink()
+	m, err := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), filepath.Join(t.TempDir(), "positions.yml"), time.Hour, sink, []Config{cfg})
+	require.NoError(t, err)
+	defer m.Stop()
+
+	require.Eventually(t, func() bool {
+		return len(sink.received()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	writeFile(t, path, data)
+	require.Eventually(t, func() bool {
+		return len(sink.received()) >= 1000
+	}, 5*time.Second, 10*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	received := sink.received()
+	require.Len(t, received, 1000)
+	for i, line := range received {
+		require.True(t, strings.HasSuffix(line, fmt.Sprintf(" line %d", i)), line)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("a", maxLineSize+10)
+	br := bufio.NewReaderSize(strings.NewReader(long+"\nshort\r\nlast"), 64<<10)
+
+	line, n, err := readLine(br)
+	require.NoError(t, err)
+	require.Equal(t, long[:maxLineSize], string(line))
+	require.Equal(t, len(long)+1, n)
+
+	line, n, err = readLine(br)
+	require.NoError(t, err)
+	require.Equal(t, "short", string(line))
+	require.Equal(t, 7, n)
+
+	line, n, err = readLine(br)
+	require.Error(t, err)
+	require.Equal(t, "last", string(line))
+	require.Equal(t, 4, n)
+}
+
+func TestLimitedReader(t *testing.T) {
+	r := &limitedReader{
+		ctx:     context.Background(),
+		r:       bytes.NewReader(make([]byte, 30000)),
+		limiter: rate.NewLimiter(rate.Limit(20000), 10000),
+	}
+
+	start := time.Now()
+	n, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Len(t, n, 30000)
+	// The burst is read at once, the rest at the limited rate.
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Second-50*time.Millisecond))
+}
+
+func TestConfig(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte("{job_name: rotated, paths: [/var/log/*.gz]}"), &cfg))
+	require.Equal(t, DefaultRefreshInterval, cfg.RefreshInterval)
+	require.Equal(t, DefaultBytesPerSecond, cfg.BytesPerSecond)
+
+	for input, expect := range map[string]string{
+		"{paths: [/var/log/*.gz]}":                              "compressed scrape configs must have a job_name",
+		"{job_name: rotated}":                                   "compressed scrape config rotated must have at least one path",
+		"{job_name: rotated, paths: ['[']}":                     `invalid path "[" of compressed scrape config rotated: syntax error in pattern`,
+		"{job_name: rotated, paths: [a], bytes_per_second: -1}": "bytes_per_second of compressed scrape config rotated must not be negative",
+		"{job_name: rotated, paths: [a], refresh_interval: 0s}": "refresh_interval of compressed scrape config rotated must be greater than 0",
+	} {
+		var cfg Config
+		require.EqualError(t, yaml.UnmarshalStrict([]byte(input), &cfg), expect, input)
+	}
+}
+
+func gzipData(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zstdData(t *testing.T, s string) []byte {
+	t.Helper()
+
+	w, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer w.Close()
+	return w.EncodeAll([]byte(s), nil)
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+}
+
+type entrySink struct {
+	ch chan api.Entry
+
+	mut     sync.Mutex
+	entries []string
+}
+
+func newEntrySink() *entrySink {
+	s := &entrySink{ch: make(chan api.Entry)}
+	go func() {
+		for e := range s.ch {
+			s.mut.Lock()
+			s.entries = append(s.entries, e.Labels.String()+" "+e.Line)
+			s.mut.Unlock()
+		}
+	}()
+	return s
+}
+
+func (s *entrySink) Chan() chan<- api.Entry { return s.ch }
+
+func (s *entrySink) Stop() {}
+
+// received returns the entries received so far, in order.
+func (s *entrySink) received() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]string(nil), s.entries...)
+}
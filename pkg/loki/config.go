@@ -4,7 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/grafana/agent/pkg/loki/compressed"
+	"github.com/grafana/agent/pkg/loki/docker"
+	"github.com/grafana/agent/pkg/scrub"
 	"github.com/grafana/loki/pkg/promtail/client"
 	"github.com/grafana/loki/pkg/promtail/positions"
 	"github.com/grafana/loki/pkg/promtail/scrapeconfig"
@@ -13,12 +18,18 @@ import (
 
 // Config controls the configuration of the Loki log scraper.
 type Config struct {
-	PositionsDirectory string            `yaml:"positions_directory,omitempty"`
-	Configs            []*InstanceConfig `yaml:"configs,omitempty"`
+	PositionsDirectory     string            `yaml:"positions_directory,omitempty"`
+	PositionsCleanupAge    time.Duration     `yaml:"positions_cleanup_age,omitempty"`
+	PositionsCleanupPeriod time.Duration     `yaml:"positions_cleanup_period,omitempty"`
+	PositionsCleanupDryRun bool              `yaml:"positions_cleanup_dry_run,omitempty"`
+	Configs                []*InstanceConfig `yaml:"configs,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.PositionsCleanupAge = DefaultPositionsCleanupAge
+	c.PositionsCleanupPeriod = DefaultPositionsCleanupPeriod
+
 	type config Config
 	err := unmarshal((*config)(c))
 	if err != nil {
@@ -37,15 +48,25 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 //   3. No InstanceConfig may have an empty name.
 //   4. If InstanceConfig positions path is empty, shared PositionsDirectory
 //      must not be empty.
+//   5. No two windows_events scrape configs may have the same bookmark path.
+//   6. No two docker scrape configs of an InstanceConfig may have the same
+//      job name.
+//   7. No two loki_push_api scrape configs of an InstanceConfig may have the
+//      same job name.
+//   8. No two compressed scrape configs of an InstanceConfig may have the
+//      same job name.
 //
 // Defaults:
 //
 //   1. If a positions config is empty, it will be generated based on
 //      the InstanceConfig name and Config.PositionsDirectory.
+//   2. If the bookmark path of a windows_events scrape config is empty, it
+//      will be generated next to the positions file, based on the job name.
 func (c *Config) ApplyDefaults() error {
 	var (
 		names     = map[string]struct{}{}
 		positions = map[string]string{} // positions file name -> config using it
+		bookmarks = map[string]string{} // bookmark file name -> config using it
 	)
 
 	for idx, ic := range c.Configs {
@@ -67,6 +88,49 @@ func (c *Config) ApplyDefaults() error {
 			return fmt.Errorf("Loki configs %s and %s must have different positions file paths", orig, ic.Name)
 		}
 		positions[ic.PositionsConfig.PositionsFile] = ic.Name
+
+		for i := range ic.ScrapeConfig {
+			sc := &ic.ScrapeConfig[i]
+			if sc.WindowsConfig == nil {
+				continue
+			}
+			if sc.WindowsConfig.BoorkmarkPath == "" {
+				base := strings.TrimSuffix(ic.PositionsConfig.PositionsFile, filepath.Ext(ic.PositionsConfig.PositionsFile))
+				sc.WindowsConfig.BoorkmarkPath = base + "-" + sc.JobName + ".xml"
+			}
+			if orig, ok := bookmarks[sc.WindowsConfig.BoorkmarkPath]; ok {
+				return fmt.Errorf("Loki configs %s and %s must have different windows_events bookmark paths", orig, ic.Name)
+			}
+			bookmarks[sc.WindowsConfig.BoorkmarkPath] = ic.Name
+		}
+
+		// Push servers name their metrics after the job.
+		pushJobs := map[string]struct{}{}
+		for _, sc := range ic.ScrapeConfig {
+			if sc.PushConfig == nil {
+				continue
+			}
+			if _, ok := pushJobs[sc.JobName]; ok {
+				return fmt.Errorf("Loki config %s has two loki_push_api scrape configs with job name %s", ic.Name, sc.JobName)
+			}
+			pushJobs[sc.JobName] = struct{}{}
+		}
+
+		jobs := make(map[string]struct{}, len(ic.DockerScrapeConfigs))
+		for _, dc := range ic.DockerScrapeConfigs {
+			if _, ok := jobs[dc.JobName]; ok {
+				return fmt.Errorf("Loki config %s has two docker scrape configs with job name %s", ic.Name, dc.JobName)
+			}
+			jobs[dc.JobName] = struct{}{}
+		}
+
+		compressedJobs := make(map[string]struct{}, len(ic.CompressedScrapeConfigs))
+		for _, cc := range ic.CompressedScrapeConfigs {
+			if _, ok := compressedJobs[cc.JobName]; ok {
+				return fmt.Errorf("Loki config %s has two compressed scrape configs with job name %s", ic.Name, cc.JobName)
+			}
+			compressedJobs[cc.JobName] = struct{}{}
+		}
 	}
 
 	return nil
@@ -80,6 +144,23 @@ type InstanceConfig struct {
 	PositionsConfig positions.Config      `yaml:"positions,omitempty"`
 	ScrapeConfig    []scrapeconfig.Config `yaml:"scrape_configs,omitempty"`
 	TargetConfig    file.Config           `yaml:"target_config,omitempty"`
+
+	// DockerScrapeConfigs discover the containers of Docker daemons and tail
+	// their logs.
+	DockerScrapeConfigs []docker.Config `yaml:"docker_scrape_configs,omitempty"`
+
+	// CompressedScrapeConfigs read the log lines of gzip and zstd compressed
+	// files.
+	CompressedScrapeConfigs []compressed.Config `yaml:"compressed_scrape_configs,omitempty"`
+
+	// ExtractTraceIDs extracts trace IDs found in log lines into the trace_id
+	// field before any pipeline stage runs, so stages can correlate log lines
+	// with traces.
+	ExtractTraceIDs bool `yaml:"extract_trace_ids,omitempty"`
+
+	// Labels hashed or removed from log entries before they're sent. Set
+	// from the top-level scrubbing config of the Agent when unset.
+	Scrubbing *scrub.Config `yaml:"scrubbing,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -96,3 +177,19 @@ func (c *InstanceConfig) UnmarshalYAML(unmarshal func(interface{}) error) error
 	type instanceConfig InstanceConfig
 	return unmarshal((*instanceConfig)(c))
 }
+
+// dockerPositionsFile returns the path of the file storing the positions of
+// the containers read by the docker scrape configs, next to the positions
+// file of the config.
+func (c *InstanceConfig) dockerPositionsFile() string {
+	base := strings.TrimSuffix(c.PositionsConfig.PositionsFile, filepath.Ext(c.PositionsConfig.PositionsFile))
+	return base + "-docker.yml"
+}
+
+// compressedPositionsFile returns the path of the file storing how much of
+// the files of the compressed scrape configs was read, next to the positions
+// file of the config.
+func (c *InstanceConfig) compressedPositionsFile() string {
+	base := strings.TrimSuffix(c.PositionsConfig.PositionsFile, filepath.Ext(c.PositionsConfig.PositionsFile))
+	return base + "-compressed.yml"
+}
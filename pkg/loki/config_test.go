@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
@@ -77,6 +78,82 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 				- name: config-a
 		  `),
 		},
+		{
+			name: "re-used windows_events bookmark path",
+			err:  fmt.Errorf("Loki configs config-a and config-b must have different windows_events bookmark paths"),
+			cfg: untab(`
+				positions_directory: /tmp
+				configs:
+				- name: config-a
+				  scrape_configs:
+				  - job_name: windows
+				    windows_events:
+				      eventlog_name: Application
+				      bookmark_path: /tmp/bookmark.xml
+				- name: config-b
+				  scrape_configs:
+				  - job_name: windows
+				    windows_events:
+				      eventlog_name: System
+				      bookmark_path: /tmp/bookmark.xml
+		  `),
+		},
+		{
+			name: "re-used docker job name",
+			err:  fmt.Errorf("Loki config config-a has two docker scrape configs with job name containers"),
+			cfg: untab(`
+				positions_directory: /tmp
+				configs:
+				- name: config-a
+				  docker_scrape_configs:
+				  - job_name: containers
+				  - job_name: containers
+				    host: tcp://localhost:2375
+		  `),
+		},
+		{
+			name: "re-used compressed job name",
+			err:  fmt.Errorf("Loki config config-a has two compressed scrape configs with job name rotated"),
+			cfg: untab(`
+				positions_directory: /tmp
+				configs:
+				- name: config-a
+				  compressed_scrape_configs:
+				  - job_name: rotated
+				    paths: [/var/log/*.gz]
+				  - job_name: rotated
+				    paths: [/var/log/*.zst]
+		  `),
+		},
+		{
+			name: "re-used loki_push_api job name",
+			err:  fmt.Errorf("Loki config config-a has two loki_push_api scrape configs with job name push"),
+			cfg: untab(`
+				positions_directory: /tmp
+				configs:
+				- name: config-a
+				  scrape_configs:
+				  - job_name: push
+				    loki_push_api:
+				      server:
+				        http_listen_port: 3500
+				  - job_name: push
+				    loki_push_api:
+				      server:
+				        http_listen_port: 3501
+		  `),
+		},
+		{
+			name: "docker scrape config without job name",
+			err:  fmt.Errorf("docker scrape configs must have a job_name"),
+			cfg: untab(`
+				positions_directory: /tmp
+				configs:
+				- name: config-a
+				  docker_scrape_configs:
+				  - host: tcp://localhost:2375
+		  `),
+		},
 		{
 			name: "generated positions file path without positions_directory",
 			err:  fmt.Errorf("cannot generate Loki positions file path for config-b because positions_directory is not configured"),
@@ -125,6 +202,63 @@ func TestConfig_ApplyDefaults_Defaults(t *testing.T) {
 	require.Equal(t, filepath.Join("/tmp", "config-b.yml"), pathB)
 }
 
+func TestConfig_MultipleTenants(t *testing.T) {
+	cfgText := untab(`
+		positions_directory: /tmp
+		configs:
+		- name: team-a
+			clients:
+			- url: http://loki:3100/loki/api/v1/push
+				tenant_id: team-a
+				backoff_config:
+					min_period: 1s
+					max_period: 1m
+					max_retries: 20
+		- name: team-b
+			clients:
+			- url: http://loki:3100/loki/api/v1/push
+				tenant_id: team-b
+			positions:
+				filename: /data/team-b/positions.yml
+	`)
+	var cfg Config
+	err := yaml.UnmarshalStrict([]byte(cfgText), &cfg)
+	require.NoError(t, err)
+
+	a, b := cfg.Configs[0], cfg.Configs[1]
+	require.Equal(t, "team-a", a.ClientConfigs[0].TenantID)
+	require.Equal(t, time.Second, a.ClientConfigs[0].BackoffConfig.MinBackoff)
+	require.Equal(t, time.Minute, a.ClientConfigs[0].BackoffConfig.MaxBackoff)
+	require.Equal(t, 20, a.ClientConfigs[0].BackoffConfig.MaxRetries)
+	require.Equal(t, filepath.Join("/tmp", "team-a.yml"), a.PositionsConfig.PositionsFile)
+
+	require.Equal(t, "team-b", b.ClientConfigs[0].TenantID)
+	require.Equal(t, "/data/team-b/positions.yml", b.PositionsConfig.PositionsFile)
+}
+
+func TestConfig_ApplyDefaults_WindowsEventsBookmark(t *testing.T) {
+	cfgText := untab(`
+		positions_directory: /tmp
+		configs:
+		- name: config-a
+			scrape_configs:
+			- job_name: application
+				windows_events:
+					eventlog_name: Application
+			- job_name: system
+				windows_events:
+					eventlog_name: System
+					bookmark_path: /var/lib/agent/system.xml
+	`)
+	var cfg Config
+	err := yaml.UnmarshalStrict([]byte(cfgText), &cfg)
+	require.NoError(t, err)
+
+	scrapeConfigs := cfg.Configs[0].ScrapeConfig
+	require.Equal(t, filepath.Join("/tmp", "config-a-application.xml"), scrapeConfigs[0].WindowsConfig.BoorkmarkPath)
+	require.Equal(t, "/var/lib/agent/system.xml", scrapeConfigs[1].WindowsConfig.BoorkmarkPath)
+}
+
 // untab is a utility function to make it easier to write YAML tests, where some editors
 // will insert tabs into strings by default.
 func untab(s string) string {
@@ -0,0 +1,54 @@
+package docker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/loki/pkg/logentry/stages"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/relabel"
+)
+
+// Default settings for Docker scrape configs.
+const (
+	DefaultHost            = "unix:///var/run/docker.sock"
+	DefaultRefreshInterval = 5 * time.Second
+)
+
+// Config discovers the containers of a Docker daemon and tails their logs.
+type Config struct {
+	// JobName identifies the config. It's used for the metrics of pipeline
+	// stages and to store the positions of containers.
+	JobName string `yaml:"job_name"`
+
+	// Host is the address of the Docker daemon.
+	Host string `yaml:"host,omitempty"`
+
+	// RefreshInterval is how often the list of containers is refreshed.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+
+	// Labels are added to every log line.
+	Labels model.LabelSet `yaml:"labels,omitempty"`
+
+	PipelineStages stages.PipelineStages `yaml:"pipeline_stages,omitempty"`
+	RelabelConfigs []*relabel.Config     `yaml:"relabel_configs,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.Host = DefaultHost
+	c.RefreshInterval = DefaultRefreshInterval
+
+	type config Config
+	if err := unmarshal((*config)(c)); err != nil {
+		return err
+	}
+
+	if c.JobName == "" {
+		return fmt.Errorf("docker scrape configs must have a job_name")
+	}
+	if c.RefreshInterval <= 0 {
+		return fmt.Errorf("refresh_interval of docker scrape config %s must be greater than 0", c.JobName)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/loki/pkg/promtail/positions"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultSyncPeriod is how often positions are written when no sync period
+// is configured.
+const defaultSyncPeriod = 10 * time.Second
+
+// positionsStore keeps the timestamp of the last log line read from every
+// container, keyed by <job_name>/<container ID>, and periodically writes them
+// to a file using the format of Promtail positions files.
+//
+// Promtail positions can't be used since they remove every position that
+// isn't a file on disk.
+type positionsStore struct {
+	log  log.Logger
+	path string
+
+	mut       sync.Mutex
+	positions map[string]string
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newPositionsStore(l log.Logger, path string, syncPeriod time.Duration) (*positionsStore, error) {
+	var file positions.File
+
+	buf, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(buf, &file); err != nil {
+		// Losing the positions only means reading some lines again, so an
+		// invalid file is replaced instead of preventing logs from being read.
+		level.Warn(l).Log("msg", "ignoring invalid docker positions file", "path", path, "err", err)
+		file.Positions = nil
+	}
+	if file.Positions == nil {
+		file.Positions = map[string]string{}
+	}
+
+	s := &positionsStore{
+		log:       l,
+		path:      path,
+		positions: file.Positions,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if syncPeriod <= 0 {
+		syncPeriod = defaultSyncPeriod
+	}
+	go s.run(syncPeriod)
+	return s, nil
+}
+
+func (s *positionsStore) run(syncPeriod time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(syncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			s.save()
+			return
+		case <-ticker.C:
+			s.save()
+		}
+	}
+}
+
+func (s *positionsStore) save() {
+	s.mut.Lock()
+	buf, err := yaml.Marshal(positions.File{Positions: s.positions})
+	s.mut.Unlock()
+	if err != nil {
+		level.Error(s.log).Log("msg", "failed to encode docker positions", "err", err)
+		return
+	}
+
+	// Write to a temporary file first so a crash never leaves a partially
+	// written file behind.
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		level.Error(s.log).Log("msg", "failed to write docker positions file", "path", s.path, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		level.Error(s.log).Log("msg", "failed to write docker positions file", "path", s.path, "err", err)
+	}
+}
+
+// Get returns the time of the last line read for key. Returns the zero time
+// if nothing was read yet.
+func (s *positionsStore) Get(key string) time.Time {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	ns, err := strconv.ParseInt(s.positions[key], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Put records ts as the time of the last line read for key.
+func (s *positionsStore) Put(key string, ts time.Time) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.positions[key] = strconv.FormatInt(ts.UnixNano(), 10)
+}
+
+// RemoveUnknown removes the positions of keys starting with prefix that
+// aren't in keep.
+func (s *positionsStore) RemoveUnknown(prefix string, keep map[string]bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for key := range s.positions {
+		if strings.HasPrefix(key, prefix) && !keep[key] {
+			delete(s.positions, key)
+		}
+	}
+}
+
+// Stop saves the positions and stops writing them.
+func (s *positionsStore) Stop() {
+	close(s.quit)
+	<-s.done
+}
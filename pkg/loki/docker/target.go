@@ -0,0 +1,373 @@
+// Package docker implements Loki scrape configs that discover the containers
+// of a Docker daemon through its API and tail their logs, for hosts running
+// containers outside of Kubernetes.
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/loki/pkg/logentry/stages"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/util/strutil"
+)
+
+// Labels of discovered containers, available to relabel_configs.
+const (
+	containerIDLabel     = "__docker_container_id"
+	containerNameLabel   = "__docker_container_name"
+	containerImageLabel  = "__docker_container_image"
+	containerLabelPrefix = "__docker_container_label_"
+	composeProjectLabel  = "__docker_compose_project"
+	composeServiceLabel  = "__docker_compose_service"
+)
+
+// StreamLabel is the label holding the stream a log line was written to,
+// either stdout or stderr.
+const StreamLabel = "stream"
+
+// Manager runs Docker scrape configs, sending the log lines of containers to
+// an entry handler.
+type Manager struct {
+	positions   *positionsStore
+	discoverers []*discoverer
+}
+
+// NewManager creates and starts a Manager. The time of the last line read
+// from every container is stored in positionsFile, so lines aren't read again
+// after a restart.
+func NewManager(l log.Logger, reg prometheus.Registerer, positionsFile string, syncPeriod time.Duration, next api.EntryHandler, configs []Config) (*Manager, error) {
+	l = log.With(l, "component", "docker")
+
+	ps, err := newPositionsStore(l, positionsFile, syncPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker positions file: %w", err)
+	}
+
+	m := &Manager{positions: ps}
+	start := time.Now()
+
+	for _, cfg := range configs {
+		d, err := newDiscoverer(log.With(l, "job", cfg.JobName), reg, cfg, ps, next, start)
+		if err != nil {
+			m.Stop()
+			return nil, fmt.Errorf("failed to create docker scrape config %s: %w", cfg.JobName, err)
+		}
+		m.discoverers = append(m.discoverers, d)
+	}
+	for _, d := range m.discoverers {
+		d.wg.Add(1)
+		go d.run()
+	}
+	return m, nil
+}
+
+// Stop stops tailing containers and saves their positions.
+func (m *Manager) Stop() {
+	for _, d := range m.discoverers {
+		d.stop()
+	}
+	m.positions.Stop()
+}
+
+// discoverer periodically lists the containers of a Docker daemon and tails
+// the logs of every running container kept by relabeling.
+type discoverer struct {
+	cfg       Config
+	log       log.Logger
+	client    *client.Client
+	handler   api.EntryHandler
+	positions *positionsStore
+
+	// start is when the Manager was created. Containers without a stored
+	// position are read from there so restarting the Agent with an empty
+	// positions file doesn't send the whole history of every container.
+	start time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mut     sync.Mutex
+	tailing map[string]bool
+}
+
+func newDiscoverer(l log.Logger, reg prometheus.Registerer, cfg Config, ps *positionsStore, next api.EntryHandler, start time.Time) (*discoverer, error) {
+	cli, err := client.NewClientWithOpts(client.WithHost(cfg.Host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+
+	jobName := cfg.JobName
+	pipeline, err := stages.NewPipeline(l, cfg.PipelineStages, &jobName, reg)
+	if err != nil {
+		cli.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &discoverer{
+		cfg:       cfg,
+		log:       l,
+		client:    cli,
+		handler:   pipeline.Wrap(next),
+		positions: ps,
+		start:     start,
+		ctx:       ctx,
+		cancel:    cancel,
+		tailing:   make(map[string]bool),
+	}, nil
+}
+
+func (d *discoverer) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.refresh(); err != nil && d.ctx.Err() == nil {
+			level.Warn(d.log).Log("msg", "failed to refresh docker containers", "err", err)
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh starts tailing new running containers and removes the positions
+// of containers that don't exist anymore.
+func (d *discoverer) refresh() error {
+	containers, err := d.client.ContainerList(d.ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	known := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		known[d.positionsKey(c.ID)] = true
+		if c.State != "running" {
+			continue
+		}
+
+		d.mut.Lock()
+		tailing := d.tailing[c.ID]
+		d.mut.Unlock()
+		if tailing {
+			continue
+		}
+
+		lset := d.containerLabels(c)
+		if lset == nil {
+			continue
+		}
+
+		d.mut.Lock()
+		d.tailing[c.ID] = true
+		d.mut.Unlock()
+
+		d.wg.Add(1)
+		go d.tail(c.ID, lset)
+	}
+
+	d.positions.RemoveUnknown(d.cfg.JobName+"/", known)
+	return nil
+}
+
+// containerLabels returns the labels of the log lines of c. Returns nil if
+// relabeling dropped the container.
+func (d *discoverer) containerLabels(c types.Container) model.LabelSet {
+	lb := labels.NewBuilder(nil)
+	for name, value := range d.cfg.Labels {
+		lb.Set(string(name), string(value))
+	}
+
+	lb.Set(containerIDLabel, c.ID)
+	if len(c.Names) > 0 {
+		lb.Set(containerNameLabel, strings.TrimPrefix(c.Names[0], "/"))
+	}
+	lb.Set(containerImageLabel, c.Image)
+	for name, value := range c.Labels {
+		lb.Set(containerLabelPrefix+strutil.SanitizeLabelName(name), value)
+	}
+	lb.Set(composeProjectLabel, c.Labels["com.docker.compose.project"])
+	lb.Set(composeServiceLabel, c.Labels["com.docker.compose.service"])
+
+	processed := relabel.Process(lb.Labels(), d.cfg.RelabelConfigs...)
+	if processed == nil {
+		return nil
+	}
+
+	res := make(model.LabelSet, len(processed))
+	for _, l := range processed {
+		if strings.HasPrefix(l.Name, "__") {
+			continue
+		}
+		res[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return res
+}
+
+func (d *discoverer) positionsKey(id string) string {
+	return d.cfg.JobName + "/" + id
+}
+
+// tail sends the log lines of a container until it stops or the discoverer
+// is stopped.
+func (d *discoverer) tail(id string, lset model.LabelSet) {
+	defer d.wg.Done()
+	defer func() {
+		d.mut.Lock()
+		delete(d.tailing, id)
+		d.mut.Unlock()
+	}()
+
+	level.Debug(d.log).Log("msg", "tailing container", "container", id)
+	if err := d.readLogs(id, lset); err != nil && d.ctx.Err() == nil {
+		level.Warn(d.log).Log("msg", "failed to read container logs", "container", id, "err", err)
+	}
+}
+
+func (d *discoverer) readLogs(id string, lset model.LabelSet) error {
+	info, err := d.client.ContainerInspect(d.ctx, id)
+	if err != nil {
+		return err
+	}
+
+	key := d.positionsKey(id)
+	last := d.positions.Get(key)
+	since := last
+	if since.IsZero() {
+		since = d.start
+	}
+
+	rc, err := d.client.ContainerLogs(d.ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+		Since:      fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()),
+	})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	handle := func(stream, line string) error {
+		// Lines are prefixed with their timestamp since Timestamps is set.
+		line = strings.TrimSuffix(line, "\n")
+		sep := strings.IndexByte(line, ' ')
+		if sep < 0 {
+			return nil
+		}
+		ts, err := time.Parse(time.RFC3339Nano, line[:sep])
+		if err != nil {
+			level.Debug(d.log).Log("msg", "skipping container log line without a timestamp", "container", id, "err", err)
+			return nil
+		}
+		// Since is inclusive, so the last line read before is sent again.
+		if !ts.After(last) {
+			return nil
+		}
+
+		entryLabels := lset.Clone()
+		entryLabels[StreamLabel] = model.LabelValue(stream)
+
+		select {
+		case d.handler.Chan() <- api.Entry{
+			Labels: entryLabels,
+			Entry:  logproto.Entry{Timestamp: ts, Line: line[sep+1:]},
+		}:
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		}
+
+		last = ts
+		d.positions.Put(key, ts)
+		return nil
+	}
+
+	if info.Config != nil && info.Config.Tty {
+		return readLines(rc, handle)
+	}
+	return readFrames(rc, handle)
+}
+
+// readLines reads the logs of a container with a TTY, where stdout and
+// stderr are merged.
+func readLines(r io.Reader, handle func(stream, line string) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if err := handle("stdout", line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// readFrames reads the logs of a container without a TTY. Docker multiplexes
+// stdout and stderr into frames, each holding a log line and prefixed with an
+// 8 byte header holding the stream in its first byte and the size of the
+// frame in its last 4 bytes.
+func readFrames(r io.Reader, handle func(stream, line string) error) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return err
+		}
+
+		var stream string
+		switch header[0] {
+		case 1:
+			stream = "stdout"
+		case 2:
+			stream = "stderr"
+		case 3:
+			return fmt.Errorf("docker error: %s", frame)
+		default:
+			continue
+		}
+		if err := handle(stream, string(frame)); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *discoverer) stop() {
+	d.cancel()
+	d.wg.Wait()
+	d.handler.Stop()
+	d.client.Close()
+}
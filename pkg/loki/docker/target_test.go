@@ -0,0 +1,194 @@
+package docker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestManager(t *testing.T) {
+	ts := time.Now().Add(time.Minute).UTC()
+	line := func(offset time.Duration, msg string) string {
+		return ts.Add(offset).Format(time.RFC3339Nano) + " " + msg + "\n"
+	}
+
+	daemon := &fakeDaemon{
+		containers: []types.Container{
+			{
+				ID:     "web",
+				Names:  []string{"/shop_web_1"},
+				Image:  "nginx:1.21",
+				State:  "running",
+				Labels: map[string]string{"com.docker.compose.project": "shop", "com.docker.compose.service": "web"},
+			},
+			{ID: "db", Names: []string{"/db"}, Image: "postgres:13", State: "running"},
+			{ID: "cache", Names: []string{"/cache"}, Image: "redis:6", State: "running"},
+			{ID: "migrate", Names: []string{"/migrate"}, Image: "shop-migrate", State: "exited"},
+		},
+		tty: map[string]bool{"db": true},
+		logs: map[string][]frame{
+			"web": {
+				{stream: 1, data: line(0, "GET /")},
+				{stream: 2, data: line(time.Millisecond, "upstream timed out")},
+			},
+			"db":    {{data: line(0, "ready to accept connections") + line(time.Millisecond, "checkpoint complete")}},
+			"cache": {{stream: 1, data: line(0, "Ready to accept connections")}},
+		},
+	}
+	srv := httptest.NewServer(daemon)
+	defer srv.Close()
+
+	var cfg Config
+	err := yaml.UnmarshalStrict([]byte(fmt.Sprintf(`
+job_name: containers
+host: tcp://%s
+refresh_interval: 10ms
+labels:
+  host: node-1
+relabel_configs:
+  - source_labels: [__docker_container_image]
+    regex: redis.*
+    action: drop
+  - source_labels: [__docker_container_name]
+    target_label: container
+  - source_labels: [__docker_compose_service]
+    target_label: service
+`, strings.TrimPrefix(srv.URL, "http://"))), &cfg)
+	require.NoError(t, err)
+
+	positionsFile := filepath.Join(t.TempDir(), "positions.yml")
+	sink := newEntrySink()
+	m, err := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), positionsFile, time.Hour, sink, []Config{cfg})
+	require.NoError(t, err)
+
+	expect := []string{
+		`{container="db", host="node-1", stream="stdout"} checkpoint complete`,
+		`{container="db", host="node-1", stream="stdout"} ready to accept connections`,
+		`{container="shop_web_1", host="node-1", service="web", stream="stderr"} upstream timed out`,
+		`{container="shop_web_1", host="node-1", service="web", stream="stdout"} GET /`,
+	}
+	require.Eventually(t, func() bool {
+		return len(sink.received()) >= len(expect)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Logs of running containers are read again by every refresh after the
+	// fake daemon closed the stream, so lines must not be sent twice.
+	time.Sleep(100 * time.Millisecond)
+	m.Stop()
+	require.Equal(t, expect, sink.received())
+
+	buf, err := ioutil.ReadFile(positionsFile)
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "containers/web: \""+fmt.Sprint(ts.Add(time.Millisecond).UnixNano()))
+	require.Contains(t, string(buf), "containers/db: \""+fmt.Sprint(ts.Add(time.Millisecond).UnixNano()))
+}
+
+func TestConfig_Defaults(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte("job_name: containers"), &cfg))
+	require.Equal(t, DefaultHost, cfg.Host)
+	require.Equal(t, DefaultRefreshInterval, cfg.RefreshInterval)
+}
+
+type frame struct {
+	// stream of the frame. Frames of containers with a TTY have no stream.
+	stream byte
+	data   string
+}
+
+// fakeDaemon implements the parts of the Docker API used to discover and
+// tail containers.
+type fakeDaemon struct {
+	containers []types.Container
+	tty        map[string]bool
+	logs       map[string][]frame
+}
+
+var containerPath = regexp.MustCompile(`^/v[0-9.]+/containers/([^/]+)/(json|logs)$`)
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/_ping" {
+		w.Header().Set("API-Version", "1.41")
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/containers/json") {
+		_ = json.NewEncoder(w).Encode(d.containers)
+		return
+	}
+
+	m := containerPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id := m[1]
+
+	switch m[2] {
+	case "json":
+		_ = json.NewEncoder(w).Encode(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: id},
+			Config:            &container.Config{Tty: d.tty[id]},
+		})
+	case "logs":
+		for _, f := range d.logs[id] {
+			if f.stream != 0 {
+				header := make([]byte, 8)
+				header[0] = f.stream
+				binary.BigEndian.PutUint32(header[4:], uint32(len(f.data)))
+				_, _ = w.Write(header)
+			}
+			_, _ = w.Write([]byte(f.data))
+		}
+	}
+}
+
+type entrySink struct {
+	ch chan api.Entry
+
+	mut     sync.Mutex
+	entries []string
+}
+
+func newEntrySink() *entrySink {
+	s := &entrySink{ch: make(chan api.Entry)}
+	go func() {
+		for e := range s.ch {
+			s.mut.Lock()
+			s.entries = append(s.entries, e.Labels.String()+" "+e.Line)
+			s.mut.Unlock()
+		}
+	}()
+	return s
+}
+
+func (s *entrySink) Chan() chan<- api.Entry { return s.ch }
+
+func (s *entrySink) Stop() {}
+
+// received returns the sorted entries received so far.
+func (s *entrySink) received() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	res := append([]string(nil), s.entries...)
+	sort.Strings(res)
+	return res
+}
@@ -5,11 +5,18 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/loki/compressed"
+	"github.com/grafana/agent/pkg/loki/docker"
+	"github.com/grafana/agent/pkg/scrub"
 	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/pkg/logentry/stages"
 	"github.com/grafana/loki/pkg/promtail"
+	"github.com/grafana/loki/pkg/promtail/api"
 	"github.com/grafana/loki/pkg/promtail/client"
 	"github.com/grafana/loki/pkg/promtail/config"
 	"github.com/grafana/loki/pkg/promtail/server"
@@ -29,6 +36,7 @@ type Loki struct {
 	reg       prometheus.Registerer
 	l         log.Logger
 	instances map[string]*Instance
+	cleaner   *positionsCleaner
 }
 
 // New creates and starts Loki log collection.
@@ -89,9 +97,35 @@ func (l *Loki) ApplyConfig(c Config) error {
 	}
 	l.instances = newInstances
 
+	if l.cleaner != nil {
+		l.cleaner.Stop()
+		l.cleaner = nil
+	}
+	if c.PositionsDirectory != "" {
+		inUse := make(map[string]bool, len(c.Configs))
+		for _, ic := range c.Configs {
+			inUse[ic.PositionsConfig.PositionsFile] = true
+			if len(ic.DockerScrapeConfigs) > 0 {
+				inUse[ic.dockerPositionsFile()] = true
+			}
+			if len(ic.CompressedScrapeConfigs) > 0 {
+				inUse[ic.compressedPositionsFile()] = true
+			}
+		}
+		l.cleaner = newPositionsCleaner(l.l, c.PositionsDirectory, inUse, c.PositionsCleanupAge, c.PositionsCleanupPeriod, c.PositionsCleanupDryRun)
+	}
+
 	return nil
 }
 
+// Instance returns the running instance with the given name. Returns nil if
+// no such instance exists.
+func (l *Loki) Instance(name string) *Instance {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.instances[name]
+}
+
 // Stop stops the log collector.
 func (l *Loki) Stop() {
 	l.mut.Lock()
@@ -100,6 +134,10 @@ func (l *Loki) Stop() {
 	for _, i := range l.instances {
 		i.Stop()
 	}
+	if l.cleaner != nil {
+		l.cleaner.Stop()
+		l.cleaner = nil
+	}
 }
 
 // Instance is an individual Loki instance.
@@ -110,7 +148,17 @@ type Instance struct {
 	log log.Logger
 	reg *util.Unregisterer
 
-	promtail *promtail.Promtail
+	promtail   *promtail.Promtail
+	docker     *docker.Manager
+	compressed *compressed.Manager
+	scrubber   *scrub.Scrubber
+
+	// stopSending is closed before the client of promtail is stopped so
+	// SendEntries calls waiting to send return. senders tracks the
+	// SendEntries calls sending to the client, which must all be done before
+	// the client is stopped.
+	stopSending chan struct{}
+	senders     sync.WaitGroup
 }
 
 // NewInstance creates and starts a Loki instance.
@@ -141,10 +189,7 @@ func (i *Instance) ApplyConfig(c *InstanceConfig) error {
 	}
 	i.cfg = c
 
-	if i.promtail != nil {
-		i.promtail.Shutdown()
-		i.promtail = nil
-	}
+	i.stop()
 
 	// Unregister all existing metrics before trying to create a new instance.
 	if !i.reg.UnregisterAll() {
@@ -153,32 +198,169 @@ func (i *Instance) ApplyConfig(c *InstanceConfig) error {
 		return fmt.Errorf("failed to unregister all metrics from previous promtail. THIS IS A BUG")
 	}
 
+	scrubber, err := scrub.New(c.Scrubbing)
+	if err != nil {
+		return fmt.Errorf("invalid scrubbing config: %w", err)
+	}
+	i.scrubber = scrubber
+
 	if len(c.ClientConfigs) == 0 {
 		level.Debug(i.log).Log("msg", "skipping creation of a promtail because no client_configs are present")
 		return nil
 	}
 
+	scrapeConfigs := c.ScrapeConfig
+	if c.ExtractTraceIDs {
+		scrapeConfigs = withTraceIDStage(scrapeConfigs)
+	}
+	scrapeConfigs = withScrubbingStages(scrapeConfigs, c.Scrubbing)
+	scrapeConfigs = withPushRegisterer(scrapeConfigs, i.reg)
+
+	// Push servers of loki_push_api scrape configs replace the global Cortex
+	// logger used by the Agent with one using their own log settings, so it's
+	// restored once Promtail is created.
+	globalLogger := util_log.Logger
 	p, err := promtail.New(config.Config{
 		ServerConfig:    server.Config{Disable: true},
 		ClientConfigs:   c.ClientConfigs,
 		PositionsConfig: c.PositionsConfig,
-		ScrapeConfig:    c.ScrapeConfig,
+		ScrapeConfig:    scrapeConfigs,
 		TargetConfig:    c.TargetConfig,
 	}, false, promtail.WithLogger(i.log), promtail.WithRegisterer(i.reg))
+	util_log.Logger = globalLogger
 	if err != nil {
 		return fmt.Errorf("unable to create Loki logging instance: %w", err)
 	}
 
+	if len(c.DockerScrapeConfigs) > 0 {
+		dm, err := docker.NewManager(i.log, i.reg, c.dockerPositionsFile(), c.PositionsConfig.SyncPeriod, p.Client(), dockerScrapeConfigs(c))
+		if err != nil {
+			p.Shutdown()
+			return fmt.Errorf("unable to create docker scrape configs: %w", err)
+		}
+		i.docker = dm
+	}
+
+	if len(c.CompressedScrapeConfigs) > 0 {
+		cm, err := compressed.NewManager(i.log, i.reg, c.compressedPositionsFile(), c.PositionsConfig.SyncPeriod, p.Client(), compressedScrapeConfigs(c))
+		if err != nil {
+			if i.docker != nil {
+				i.docker.Stop()
+				i.docker = nil
+			}
+			p.Shutdown()
+			return fmt.Errorf("unable to create compressed scrape configs: %w", err)
+		}
+		i.compressed = cm
+	}
+
 	i.promtail = p
+	i.stopSending = make(chan struct{})
 	return nil
 }
 
+// dockerScrapeConfigs returns copies of the docker scrape configs of c with
+// the same extra pipeline stages as its Promtail scrape configs.
+func dockerScrapeConfigs(c *InstanceConfig) []docker.Config {
+	res := make([]docker.Config, 0, len(c.DockerScrapeConfigs))
+	for _, dc := range c.DockerScrapeConfigs {
+		dc.PipelineStages = withExtraStages(c, dc.PipelineStages)
+		res = append(res, dc)
+	}
+	return res
+}
+
+// compressedScrapeConfigs returns copies of the compressed scrape configs of
+// c with the same extra pipeline stages as its Promtail scrape configs.
+func compressedScrapeConfigs(c *InstanceConfig) []compressed.Config {
+	res := make([]compressed.Config, 0, len(c.CompressedScrapeConfigs))
+	for _, cc := range c.CompressedScrapeConfigs {
+		cc.PipelineStages = withExtraStages(c, cc.PipelineStages)
+		res = append(res, cc)
+	}
+	return res
+}
+
+// withExtraStages returns pipeline surrounded by the stages c adds to every
+// scrape config: trace ID extraction first and scrubbing last.
+func withExtraStages(c *InstanceConfig, pipeline stages.PipelineStages) stages.PipelineStages {
+	scrubStages := scrubbingStages(c.Scrubbing)
+
+	res := make(stages.PipelineStages, 0, len(pipeline)+len(scrubStages)+1)
+	if c.ExtractTraceIDs {
+		res = append(res, traceIDStage())
+	}
+	res = append(res, pipeline...)
+	return append(res, scrubStages...)
+}
+
+// SendEntries passes entries to the Promtail client of the instance, waiting
+// at most timeout in total for the client to accept them. Returns the number
+// of entries sent, which is less than len(entries) if they could not be sent
+// in time or the instance has no clients configured.
+func (i *Instance) SendEntries(entries []api.Entry, timeout time.Duration) int {
+	i.mut.Lock()
+	if i.promtail == nil {
+		i.mut.Unlock()
+		return 0
+	}
+	var (
+		ch       = i.promtail.Client().Chan()
+		stop     = i.stopSending
+		scrubber = i.scrubber
+	)
+	i.senders.Add(1)
+	defer i.senders.Done()
+
+	// The mutex isn't held while waiting for the client so a stalled client
+	// doesn't block applying configs or stopping the instance.
+	i.mut.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for n, entry := range entries {
+		// Entries sent directly don't go through the pipeline stages, so
+		// they're scrubbed here.
+		entry.Labels = scrubber.LabelSet(entry.Labels)
+
+		select {
+		case ch <- entry:
+		case <-stop:
+			return n
+		case <-timer.C:
+			return n
+		}
+	}
+	return len(entries)
+}
+
 // Stop stops the Promtail instance.
 func (i *Instance) Stop() {
 	i.mut.Lock()
 	defer i.mut.Unlock()
+	i.stop()
+}
 
+// stop stops the docker and compressed scrape configs and Promtail. i.mut
+// must be held.
+func (i *Instance) stop() {
+	// Docker and compressed scrape configs send to the client of Promtail,
+	// so they're stopped first.
+	if i.docker != nil {
+		i.docker.Stop()
+		i.docker = nil
+	}
+	if i.compressed != nil {
+		i.compressed.Stop()
+		i.compressed = nil
+	}
 	if i.promtail != nil {
+		// The client closes its channel when stopped, so pending SendEntries
+		// calls must return first.
+		close(i.stopSending)
+		i.senders.Wait()
+
 		i.promtail.Shutdown()
 		i.promtail = nil
 	}
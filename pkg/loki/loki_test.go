@@ -16,7 +16,9 @@ import (
 	"github.com/grafana/agent/pkg/util"
 	"github.com/grafana/loki/pkg/distributor"
 	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/promtail/api"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
 )
@@ -133,3 +135,247 @@ configs:
 		require.Equal(t, "Hello again!", req.Streams[0].Entries[0].Line)
 	}
 }
+
+func TestLoki_PipelineStages(t *testing.T) {
+	positionsDir := t.TempDir()
+
+	tmpFile, err := ioutil.TempFile(t.TempDir(), "*.log")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = tmpFile.Close()
+	})
+
+	pushes := make(chan *logproto.PushRequest)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, lis.Close())
+	})
+	go func() {
+		_ = http.Serve(lis, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			req, err := distributor.ParseRequest(r)
+			require.NoError(t, err)
+
+			pushes <- req
+			_, _ = rw.Write(nil)
+		}))
+	}()
+
+	cfgText := util.Untab(fmt.Sprintf(`
+positions_directory: %s
+configs:
+- name: default
+  clients:
+  - url: http://%s/loki/api/v1/push
+		batchwait: 50ms
+		batchsize: 1
+  scrape_configs:
+  - job_name: app
+    static_configs:
+    - targets: [localhost]
+      labels:
+        job: app
+        __path__: %s
+    pipeline_stages:
+    - regex:
+        expression: 'ts=(?P<ts>\S+) level=(?P<level>\w+)'
+    - drop:
+        source: level
+        value: debug
+    - timestamp:
+        source: ts
+        format: RFC3339
+    - labels:
+        level:
+    - metrics:
+        lines_total:
+          type: Counter
+          description: Total number of lines
+          config:
+            match_all: true
+            action: inc
+	`, positionsDir, lis.Addr().String(), tmpFile.Name()))
+
+	var cfg Config
+	dec := yaml.NewDecoder(strings.NewReader(cfgText))
+	dec.SetStrict(true)
+	require.NoError(t, dec.Decode(&cfg))
+
+	reg := prometheus.NewRegistry()
+	l, err := New(reg, cfg, log.NewSyncLogger(log.NewNopLogger()))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	fmt.Fprintf(tmpFile, "ts=2021-06-01T10:00:00Z level=debug msg=\"cache hit\"\n")
+	fmt.Fprintf(tmpFile, "ts=2021-06-01T10:00:01Z level=info msg=\"GET /\"\n")
+	select {
+	case <-time.After(time.Second * 30):
+		require.FailNow(t, "timed out waiting for data to be pushed")
+	case req := <-pushes:
+		require.Len(t, req.Streams, 1)
+		require.Equal(t, fmt.Sprintf(`{filename=%q, job="app", level="info"}`, tmpFile.Name()), req.Streams[0].Labels)
+		require.Len(t, req.Streams[0].Entries, 1)
+
+		entry := req.Streams[0].Entries[0]
+		require.Equal(t, `ts=2021-06-01T10:00:01Z level=info msg="GET /"`, entry.Line)
+		require.Equal(t, time.Date(2021, 6, 1, 10, 0, 1, 0, time.UTC), entry.Timestamp.UTC())
+	}
+
+	// Metrics of the metrics stage are exposed with the loki_config label.
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	var found bool
+	for _, mf := range mfs {
+		if mf.GetName() != "promtail_custom_lines_total" {
+			continue
+		}
+		found = true
+		require.Equal(t, 1.0, mf.GetMetric()[0].GetCounter().GetValue())
+		require.Contains(t, mf.GetMetric()[0].String(), `name:"loki_config" value:"default"`)
+	}
+	require.True(t, found, "expected metric of the metrics stage to be registered")
+}
+
+func TestLoki_PushAPI(t *testing.T) {
+	pushes := make(chan *logproto.PushRequest)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, lis.Close())
+	})
+	go func() {
+		_ = http.Serve(lis, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			req, err := distributor.ParseRequest(r)
+			require.NoError(t, err)
+
+			pushes <- req
+			_, _ = rw.Write(nil)
+		}))
+	}()
+
+	// Find a free port for the push server.
+	pushLis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pushPort := pushLis.Addr().(*net.TCPAddr).Port
+	require.NoError(t, pushLis.Close())
+
+	configText := func(label string) string {
+		return util.Untab(fmt.Sprintf(`
+positions_directory: %s
+configs:
+- name: default
+  clients:
+  - url: http://%s/loki/api/v1/push
+		batchwait: 50ms
+		batchsize: 1
+  scrape_configs:
+  - job_name: push
+    loki_push_api:
+      server:
+        http_listen_address: 127.0.0.1
+        http_listen_port: %d
+      labels:
+        relay: %s
+	`, t.TempDir(), lis.Addr().String(), pushPort, label))
+	}
+
+	var cfg Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(configText("hub-1")), &cfg))
+
+	l, err := New(prometheus.NewRegistry(), cfg, log.NewSyncLogger(log.NewNopLogger()))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	push := func(line string) {
+		body := fmt.Sprintf(`{"streams":[{"stream":{"app":"web"},"values":[["%d","%s"]]}]}`, time.Now().UnixNano(), line)
+
+		// The push server is started in the background.
+		require.Eventually(t, func() bool {
+			resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/loki/api/v1/push", pushPort), "application/json", strings.NewReader(body))
+			if err != nil {
+				return false
+			}
+			_ = resp.Body.Close()
+			return resp.StatusCode == http.StatusNoContent
+		}, 10*time.Second, 50*time.Millisecond)
+	}
+
+	push("hello from a spoke")
+	select {
+	case <-time.After(time.Second * 30):
+		require.FailNow(t, "timed out waiting for data to be pushed")
+	case req := <-pushes:
+		require.Equal(t, `{app="web", relay="hub-1"}`, req.Streams[0].Labels)
+		require.Equal(t, "hello from a spoke", req.Streams[0].Entries[0].Line)
+	}
+
+	// Changing the config recreates Promtail and its push server, which must
+	// not register its metrics twice.
+	var newCfg Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(configText("hub-2")), &newCfg))
+	require.NoError(t, l.ApplyConfig(newCfg))
+
+	push("hello again")
+	select {
+	case <-time.After(time.Second * 30):
+		require.FailNow(t, "timed out waiting for data to be pushed")
+	case req := <-pushes:
+		require.Equal(t, `{app="web", relay="hub-2"}`, req.Streams[0].Labels)
+		require.Equal(t, "hello again", req.Streams[0].Entries[0].Line)
+	}
+}
+
+func TestInstance_SendEntries_StalledClient(t *testing.T) {
+	//
+	// Accept push requests but don't respond until the test is done, so the
+	// client stops reading new entries.
+	//
+	release := make(chan struct{})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, lis.Close())
+	})
+	go func() {
+		_ = http.Serve(lis, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			<-release
+			_, _ = rw.Write(nil)
+		}))
+	}()
+
+	cfgText := util.Untab(fmt.Sprintf(`
+name: default
+clients:
+- url: http://%s/loki/api/v1/push
+	batchwait: 10ms
+	batchsize: 1
+positions:
+	filename: %s/positions.yml
+	`, lis.Addr().String(), t.TempDir()))
+
+	var cfg InstanceConfig
+	require.NoError(t, yaml.UnmarshalStrict([]byte(cfgText), &cfg))
+
+	inst, err := NewInstance(prometheus.NewRegistry(), &cfg, log.NewNopLogger())
+	require.NoError(t, err)
+	defer inst.Stop()
+	defer close(release)
+
+	entries := make([]api.Entry, 100)
+	for i := range entries {
+		entries[i] = api.Entry{
+			Labels: model.LabelSet{"job": "test"},
+			Entry:  logproto.Entry{Timestamp: time.Now(), Line: "Hello, world!"},
+		}
+	}
+
+	// All entries share a single deadline, so sending fails fast instead of
+	// waiting for the timeout once per entry.
+	start := time.Now()
+	sent := inst.SendEntries(entries, 50*time.Millisecond)
+	require.Less(t, sent, len(entries))
+	require.Less(t, time.Since(start).Milliseconds(), int64(time.Second/time.Millisecond))
+}
@@ -0,0 +1,52 @@
+package loki
+
+import (
+	"bytes"
+
+	lokiflag "github.com/grafana/loki/pkg/util/flagext"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// MarshalConfig marshals a Config so that it can be loaded again. If
+// scrubSecrets is true, secrets are replaced with <secret>.
+func MarshalConfig(c *Config, scrubSecrets bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+
+	enc.SetHook(func(in interface{}) (ok bool, out interface{}, err error) {
+		switch v := in.(type) {
+		case config_util.Secret:
+			if scrubSecrets {
+				return false, nil, nil
+			}
+			return true, string(v), nil
+		case lokiflag.LabelSet:
+			// LabelSet marshals itself as a YAML string, which can't be
+			// unmarshaled back into a LabelSet. Marshal the labels as a map
+			// instead.
+			return true, labelSetMarshaler(v.LabelSet), nil
+		default:
+			return false, nil, nil
+		}
+	})
+
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	err := enc.Close()
+	return buf.Bytes(), err
+}
+
+// labelSetMarshaler marshals a LabelSet as a map.
+type labelSetMarshaler model.LabelSet
+
+// MarshalYAML implements yaml.Marshaler.
+func (ls labelSetMarshaler) MarshalYAML() (interface{}, error) {
+	out := make(map[string]string, len(ls))
+	for k, v := range ls {
+		out[string(k)] = string(v)
+	}
+	return out, nil
+}
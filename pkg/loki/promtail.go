@@ -0,0 +1,58 @@
+package loki
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/grafana/loki/pkg/promtail/config"
+	lokiflag "github.com/grafana/loki/pkg/util/flagext"
+	"gopkg.in/yaml.v2"
+)
+
+// ConvertPromtailConfig converts the contents of a Promtail config file into a
+// Config with a single instance called name.
+//
+// The server block of the Promtail config is ignored since the Agent runs its
+// own server. The deprecated client block is converted into an entry in
+// clients, and its external labels are applied to every client just like
+// Promtail does.
+func ConvertPromtailConfig(in []byte, name string) (*Config, error) {
+	var pc config.Config
+
+	// Defaults for Promtail are hidden behind flags. Register flags to a fake
+	// flagset just to set the defaults in the config.
+	fs := flag.NewFlagSet("temp", flag.PanicOnError)
+	pc.RegisterFlags(fs)
+
+	if err := yaml.UnmarshalStrict(in, &pc); err != nil {
+		return nil, fmt.Errorf("failed to parse Promtail config: %w", err)
+	}
+
+	clients := pc.ClientConfigs
+	if pc.ClientConfig.URL.URL != nil {
+		clients = append(clients, pc.ClientConfig)
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("Promtail config must have at least one client")
+	}
+
+	// Promtail applies the external labels of the deprecated client block to
+	// all clients, with labels from each client taking precedence.
+	if len(pc.ClientConfig.ExternalLabels.LabelSet) > 0 {
+		for i := range clients {
+			merged := pc.ClientConfig.ExternalLabels.Merge(clients[i].ExternalLabels.LabelSet)
+			clients[i].ExternalLabels = lokiflag.LabelSet{LabelSet: merged}
+		}
+	}
+
+	c := &Config{
+		Configs: []*InstanceConfig{{
+			Name:            name,
+			ClientConfigs:   clients,
+			PositionsConfig: pc.PositionsConfig,
+			ScrapeConfig:    pc.ScrapeConfig,
+			TargetConfig:    pc.TargetConfig,
+		}},
+	}
+	return c, c.ApplyDefaults()
+}
@@ -0,0 +1,63 @@
+package loki
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConvertPromtailConfig(t *testing.T) {
+	in := untab(`
+		server:
+		  http_listen_port: 9080
+		positions:
+		  filename: /tmp/positions.yaml
+		client:
+		  url: http://loki-a:3100/loki/api/v1/push
+		  external_labels:
+		    cluster: a
+		    env: prod
+		clients:
+		- url: http://loki-b:3100/loki/api/v1/push
+		  external_labels:
+		    cluster: b
+		scrape_configs:
+		- job_name: system
+		  static_configs:
+		  - targets: [localhost]
+		    labels:
+		      __path__: /var/log/*log
+	`)
+
+	cfg, err := ConvertPromtailConfig([]byte(in), "promtail")
+	require.NoError(t, err)
+	require.Len(t, cfg.Configs, 1)
+
+	ic := cfg.Configs[0]
+	require.Equal(t, "promtail", ic.Name)
+	require.Equal(t, "/tmp/positions.yaml", ic.PositionsConfig.PositionsFile)
+	require.Len(t, ic.ScrapeConfig, 1)
+
+	require.Len(t, ic.ClientConfigs, 2)
+	require.Equal(t, "http://loki-b:3100/loki/api/v1/push", ic.ClientConfigs[0].URL.String())
+	require.Equal(t, model.LabelSet{"cluster": "b", "env": "prod"}, ic.ClientConfigs[0].ExternalLabels.LabelSet)
+	require.Equal(t, "http://loki-a:3100/loki/api/v1/push", ic.ClientConfigs[1].URL.String())
+	require.Equal(t, model.LabelSet{"cluster": "a", "env": "prod"}, ic.ClientConfigs[1].ExternalLabels.LabelSet)
+
+	// The converted config should be loadable as an Agent config.
+	bb, err := MarshalConfig(cfg, false)
+	require.NoError(t, err)
+
+	var loaded Config
+	require.NoError(t, yaml.UnmarshalStrict(bb, &loaded))
+	require.Len(t, loaded.Configs, 1)
+	require.Len(t, loaded.Configs[0].ClientConfigs, 2)
+	require.Equal(t, model.LabelSet{"cluster": "b", "env": "prod"}, loaded.Configs[0].ClientConfigs[0].ExternalLabels.LabelSet)
+}
+
+func TestConvertPromtailConfig_NoClients(t *testing.T) {
+	_, err := ConvertPromtailConfig([]byte("positions:\n  filename: /tmp/positions.yaml\n"), "default")
+	require.EqualError(t, err, "Promtail config must have at least one client")
+}
@@ -0,0 +1,25 @@
+package loki
+
+import (
+	"github.com/grafana/loki/pkg/promtail/scrapeconfig"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// withPushRegisterer returns copies of scrapeConfigs where the servers of
+// loki_push_api scrape configs register their metrics to reg.
+//
+// Push servers otherwise register to the default registry and never
+// unregister, so recreating Promtail after a config change would panic on
+// duplicate metrics.
+func withPushRegisterer(scrapeConfigs []scrapeconfig.Config, reg prometheus.Registerer) []scrapeconfig.Config {
+	res := make([]scrapeconfig.Config, 0, len(scrapeConfigs))
+	for _, sc := range scrapeConfigs {
+		if sc.PushConfig != nil {
+			pc := *sc.PushConfig
+			pc.Server.Registerer = reg
+			sc.PushConfig = &pc
+		}
+		res = append(res, sc)
+	}
+	return res
+}
@@ -0,0 +1,111 @@
+package loki
+
+import (
+	"fmt"
+
+	"github.com/grafana/agent/pkg/scrub"
+	"github.com/grafana/loki/pkg/logentry/stages"
+	"github.com/grafana/loki/pkg/promtail/scrapeconfig"
+	"github.com/prometheus/common/model"
+)
+
+// scrubbedMarkerPrefix prefixes the temporary labels marking labels already
+// hashed by a scrubbing rule, so later rules don't apply to them.
+const scrubbedMarkerPrefix = "__scrubbed_"
+
+// withScrubbingStages returns copies of scrapeConfigs that scrub labels
+// after every other pipeline stage ran. Promtail can't be extended with new
+// stages, so rules are implemented with match, template, labels and
+// labeldrop stages. Rules for names that aren't valid label names, such as
+// span attributes, are ignored.
+func withScrubbingStages(scrapeConfigs []scrapeconfig.Config, c *scrub.Config) []scrapeconfig.Config {
+	scrubStages := scrubbingStages(c)
+	if len(scrubStages) == 0 {
+		return scrapeConfigs
+	}
+
+	res := make([]scrapeconfig.Config, 0, len(scrapeConfigs))
+	for _, sc := range scrapeConfigs {
+		pipeline := make(stages.PipelineStages, 0, len(sc.PipelineStages)+len(scrubStages))
+		pipeline = append(pipeline, sc.PipelineStages...)
+		pipeline = append(pipeline, scrubStages...)
+
+		sc.PipelineStages = pipeline
+		res = append(res, sc)
+	}
+	return res
+}
+
+func scrubbingStages(c *scrub.Config) stages.PipelineStages {
+	if c == nil {
+		return nil
+	}
+
+	var (
+		res     stages.PipelineStages
+		markers []interface{}
+		marked  = map[string]bool{}
+	)
+	for _, r := range c.Rules {
+		for _, name := range r.Labels {
+			if !model.LabelName(name).IsValid() {
+				continue
+			}
+			marker := scrubbedMarkerPrefix + name
+
+			// Match stages run their stages with the extracted map set from
+			// the current labels, so the template stage hashes the value of
+			// the label. Labels hashed by an earlier rule are skipped.
+			selector := fmt.Sprintf(`{%s=~%q, %s!="", %s=""}`, name, r.Regex, name, marker)
+
+			var matchStages stages.PipelineStages
+			switch r.Action {
+			case scrub.ActionDrop:
+				matchStages = stages.PipelineStages{
+					map[interface{}]interface{}{
+						stages.StageTypeLabelDrop: []interface{}{name},
+					},
+				}
+			case scrub.ActionHash:
+				matchStages = stages.PipelineStages{
+					map[interface{}]interface{}{
+						stages.StageTypeTemplate: map[interface{}]interface{}{
+							"source":   name,
+							"template": fmt.Sprintf(`{{ Sha2Hash %q .Value }}`, string(c.Salt)),
+						},
+					},
+					map[interface{}]interface{}{
+						stages.StageTypeTemplate: map[interface{}]interface{}{
+							"source":   marker,
+							"template": "true",
+						},
+					},
+					map[interface{}]interface{}{
+						stages.StageTypeLabel: map[interface{}]interface{}{
+							name:   "",
+							marker: "",
+						},
+					},
+				}
+				if !marked[marker] {
+					marked[marker] = true
+					markers = append(markers, marker)
+				}
+			}
+
+			res = append(res, map[interface{}]interface{}{
+				stages.StageTypeMatch: map[interface{}]interface{}{
+					"selector": selector,
+					"stages":   matchStages,
+				},
+			})
+		}
+	}
+
+	if len(markers) > 0 {
+		res = append(res, map[interface{}]interface{}{
+			stages.StageTypeLabelDrop: markers,
+		})
+	}
+	return res
+}
@@ -0,0 +1,110 @@
+package loki
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/scrub"
+	"github.com/grafana/loki/pkg/logentry/stages"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/promtail/scrapeconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithScrubbingStages(t *testing.T) {
+	c := &scrub.Config{
+		Salt: `"salt"`,
+		Rules: []scrub.Rule{
+			{Labels: []string{"client_ip"}, Regex: `10\..*`, Action: scrub.ActionDrop},
+			{Labels: []string{"user_id", "client_ip", "enduser.id"}, Regex: ".*", Action: scrub.ActionHash},
+			// Never applied, as the first rule matching a value wins.
+			{Labels: []string{"user_id"}, Regex: ".*", Action: scrub.ActionDrop},
+		},
+	}
+
+	in := []scrapeconfig.Config{{
+		JobName: "test",
+		PipelineStages: stages.PipelineStages{
+			map[interface{}]interface{}{
+				stages.StageTypeRegex: map[interface{}]interface{}{"expression": `user=(?P<user_id>\S+)`},
+			},
+			map[interface{}]interface{}{
+				stages.StageTypeLabel: map[interface{}]interface{}{"user_id": nil},
+			},
+		},
+	}}
+	out := withScrubbingStages(in, c)
+	require.Len(t, in[0].PipelineStages, 2, "original scrape config must not be modified")
+
+	p, err := stages.NewPipeline(log.NewNopLogger(), out[0].PipelineStages, &out[0].JobName, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	tt := []struct {
+		name   string
+		labels model.LabelSet
+		expect model.LabelSet
+	}{
+		{
+			name:   "hashed",
+			labels: model.LabelSet{"job": "app", "client_ip": "192.168.0.1"},
+			expect: model.LabelSet{
+				"job":       "app",
+				"user_id":   model.LabelValue(scrub.Hash(`"salt"`, "42")),
+				"client_ip": model.LabelValue(scrub.Hash(`"salt"`, "192.168.0.1")),
+			},
+		},
+		{
+			name:   "dropped",
+			labels: model.LabelSet{"job": "app", "client_ip": "10.0.0.1"},
+			expect: model.LabelSet{
+				"job":     "app",
+				"user_id": model.LabelValue(scrub.Hash(`"salt"`, "42")),
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			e := processWithLabels(p, tc.labels, "level=info user=42 msg=login")
+			require.Equal(t, tc.expect, e.Labels)
+		})
+	}
+
+	require.Equal(t, in, withScrubbingStages(in, nil))
+}
+
+func TestInstance_SendEntries_Scrubbing(t *testing.T) {
+	// Instances without clients don't send entries, but the labels are
+	// scrubbed before that.
+	inst, err := NewInstance(prometheus.NewRegistry(), &InstanceConfig{
+		Name: "test",
+		Scrubbing: &scrub.Config{
+			Rules: []scrub.Rule{{Labels: []string{"user_id"}, Regex: ".*", Action: scrub.ActionDrop}},
+		},
+	}, log.NewNopLogger())
+	require.NoError(t, err)
+	defer inst.Stop()
+
+	require.NotNil(t, inst.scrubber)
+	require.Equal(t, model.LabelSet{"job": "app"}, inst.scrubber.LabelSet(model.LabelSet{"job": "app", "user_id": "42"}))
+	require.Equal(t, 0, inst.SendEntries([]api.Entry{{Labels: model.LabelSet{"user_id": "42"}}}, time.Millisecond))
+}
+
+func processWithLabels(p *stages.Pipeline, labels model.LabelSet, line string) stages.Entry {
+	in := make(chan stages.Entry)
+	out := p.Run(in)
+	go func() {
+		defer close(in)
+		in <- stages.Entry{
+			Extracted: map[string]interface{}{},
+			Entry: api.Entry{
+				Labels: labels,
+				Entry:  logproto.Entry{Timestamp: time.Now(), Line: line},
+			},
+		}
+	}()
+	return <-out
+}
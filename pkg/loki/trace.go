@@ -0,0 +1,40 @@
+package loki
+
+import (
+	"github.com/grafana/loki/pkg/logentry/stages"
+	"github.com/grafana/loki/pkg/promtail/scrapeconfig"
+)
+
+// TraceIDField is the name of the extracted field that holds trace IDs found
+// in log lines when extract_trace_ids is enabled.
+const TraceIDField = "trace_id"
+
+// traceIDExpression matches trace IDs in logfmt (trace_id=<id>, traceID=<id>)
+// and JSON ("traceId":"<id>") log lines.
+const traceIDExpression = `(?i)\btrace_?id"?\s*[=:]\s*"?(?P<` + TraceIDField + `>[0-9a-f]{16,32})\b`
+
+// withTraceIDStage returns copies of scrapeConfigs that extract trace IDs
+// from log lines into the trace_id field before running any other pipeline
+// stage, so the configured stages can use it.
+func withTraceIDStage(scrapeConfigs []scrapeconfig.Config) []scrapeconfig.Config {
+	res := make([]scrapeconfig.Config, 0, len(scrapeConfigs))
+	for _, sc := range scrapeConfigs {
+		pipeline := make(stages.PipelineStages, 0, len(sc.PipelineStages)+1)
+		pipeline = append(pipeline, traceIDStage())
+		pipeline = append(pipeline, sc.PipelineStages...)
+
+		sc.PipelineStages = pipeline
+		res = append(res, sc)
+	}
+	return res
+}
+
+// traceIDStage returns a regex stage extracting trace IDs into the trace_id
+// field.
+func traceIDStage() stages.PipelineStage {
+	return map[interface{}]interface{}{
+		stages.StageTypeRegex: map[interface{}]interface{}{
+			"expression": traceIDExpression,
+		},
+	}
+}
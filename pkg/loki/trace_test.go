@@ -0,0 +1,71 @@
+package loki
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/loki/pkg/logentry/stages"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/promtail/scrapeconfig"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTraceIDStage(t *testing.T) {
+	tt := []struct {
+		name   string
+		line   string
+		expect interface{}
+	}{
+		{name: "logfmt", line: `level=info trace_id=4bf92f3577b34da6a3ce929d0e0e4736 msg=done`, expect: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "logfmt camel case", line: `level=info traceID=a3ce929d0e0e4736 msg=done`, expect: "a3ce929d0e0e4736"},
+		{name: "json", line: `{"level":"info","traceId":"4bf92f3577b34da6a3ce929d0e0e4736"}`, expect: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "no trace id", line: `level=info msg=done`, expect: nil},
+		{name: "too short", line: `level=info trace_id=abc`, expect: nil},
+	}
+
+	in := []scrapeconfig.Config{{
+		JobName: "test",
+		PipelineStages: stages.PipelineStages{
+			map[interface{}]interface{}{
+				stages.StageTypeLabel: map[interface{}]interface{}{TraceIDField: nil},
+			},
+		},
+	}}
+	out := withTraceIDStage(in)
+	require.Len(t, in[0].PipelineStages, 1, "original scrape config must not be modified")
+	require.Len(t, out[0].PipelineStages, 2)
+
+	p, err := stages.NewPipeline(log.NewNopLogger(), out[0].PipelineStages, &out[0].JobName, nil)
+	require.NoError(t, err)
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			e := process(p, tc.line)
+			require.Equal(t, tc.expect, e.Extracted[TraceIDField])
+
+			// The labels stage runs after the trace ID is extracted.
+			if tc.expect != nil {
+				require.Equal(t, model.LabelValue(tc.expect.(string)), e.Labels[TraceIDField])
+			}
+		})
+	}
+}
+
+func process(p *stages.Pipeline, line string) stages.Entry {
+	in := make(chan stages.Entry)
+	out := p.Run(in)
+	go func() {
+		defer close(in)
+		in <- stages.Entry{
+			Extracted: map[string]interface{}{},
+			Entry: api.Entry{
+				Labels: model.LabelSet{},
+				Entry:  logproto.Entry{Timestamp: time.Now(), Line: line},
+			},
+		}
+	}()
+	return <-out
+}
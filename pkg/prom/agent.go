@@ -4,6 +4,7 @@
 package prom
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -14,44 +15,100 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/grafana/agent/pkg/prom/cluster"
 	"github.com/grafana/agent/pkg/prom/cluster/client"
+	"github.com/grafana/agent/pkg/prom/ha"
+	"github.com/grafana/agent/pkg/prom/ingest"
 	"github.com/grafana/agent/pkg/prom/instance"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
 	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
 )
 
 // DefaultConfig is the default settings for the Prometheus-lite client.
 var DefaultConfig = Config{
-	Global:                 instance.DefaultGlobalConfig,
-	InstanceRestartBackoff: instance.DefaultBasicManagerConfig.InstanceRestartBackoff,
-	WALCleanupAge:          DefaultCleanupAge,
-	WALCleanupPeriod:       DefaultCleanupPeriod,
-	ServiceConfig:          cluster.DefaultConfig,
-	ServiceClientConfig:    client.DefaultConfig,
-	InstanceMode:           instance.DefaultMode,
+	Global:                    instance.DefaultGlobalConfig,
+	InstanceRestartBackoff:    instance.DefaultBasicManagerConfig.InstanceRestartBackoff,
+	InstanceRestartMaxBackoff: instance.DefaultBasicManagerConfig.InstanceRestartMaxBackoff,
+	WALCleanupAge:             DefaultCleanupAge,
+	WALCleanupPeriod:          DefaultCleanupPeriod,
+	WALCleanupStrategy:        CleanupDelete,
+	WALArchiveRetention:       DefaultArchiveRetention,
+	ServiceConfig:             cluster.DefaultConfig,
+	ServiceClientConfig:       client.DefaultConfig,
+	InstanceMode:              instance.DefaultMode,
+	HAPair:                    ha.DefaultConfig,
 }
 
 // Config defines the configuration for the entire set of Prometheus client
 // instances, along with a global configuration.
 type Config struct {
-	Global                 instance.GlobalConfig `yaml:"global,omitempty"`
-	WALDir                 string                `yaml:"wal_directory,omitempty"`
-	WALCleanupAge          time.Duration         `yaml:"wal_cleanup_age,omitempty"`
-	WALCleanupPeriod       time.Duration         `yaml:"wal_cleanup_period,omitempty"`
-	ServiceConfig          cluster.Config        `yaml:"scraping_service,omitempty"`
-	ServiceClientConfig    client.Config         `yaml:"scraping_service_client,omitempty"`
-	Configs                []instance.Config     `yaml:"configs,omitempty,omitempty"`
-	InstanceRestartBackoff time.Duration         `yaml:"instance_restart_backoff,omitempty"`
-	InstanceMode           instance.Mode         `yaml:"instance_mode,omitempty"`
+	Global                    instance.GlobalConfig `yaml:"global,omitempty"`
+	WALDir                    string                `yaml:"wal_directory,omitempty"`
+	WALCleanupAge             time.Duration         `yaml:"wal_cleanup_age,omitempty"`
+	WALCleanupPeriod          time.Duration         `yaml:"wal_cleanup_period,omitempty"`
+	WALCleanupStrategy        CleanupStrategy       `yaml:"wal_cleanup_strategy,omitempty"`
+	WALArchiveDir             string                `yaml:"wal_archive_directory,omitempty"`
+	WALArchiveRetention       time.Duration         `yaml:"wal_archive_retention,omitempty"`
+	WALReplayMemoryLimit      int64                 `yaml:"wal_replay_memory_limit,omitempty"`
+	ServiceConfig             cluster.Config        `yaml:"scraping_service,omitempty"`
+	ServiceClientConfig       client.Config         `yaml:"scraping_service_client,omitempty"`
+	Configs                   []instance.Config     `yaml:"configs,omitempty,omitempty"`
+	InstanceRestartBackoff    time.Duration         `yaml:"instance_restart_backoff,omitempty"`
+	InstanceRestartMaxBackoff time.Duration         `yaml:"instance_restart_max_backoff,omitempty"`
+	InstanceMode              instance.Mode         `yaml:"instance_mode,omitempty"`
+	RuntimeConfigsDir         string                `yaml:"runtime_configs_directory,omitempty"`
+
+	// RemoteWriteReceiverInstance is the name of the instance that receives
+	// samples sent to /api/v1/push. The receiver is disabled when empty.
+	RemoteWriteReceiverInstance string `yaml:"remote_write_receiver_instance,omitempty"`
+
+	// MaxConcurrentScrapes is the maximum number of scrapes that may be in
+	// flight across all instances. 0 means no limit.
+	MaxConcurrentScrapes int `yaml:"max_concurrent_scrapes,omitempty"`
+
+	// RemoteWriteBytesPerSecond is the maximum number of bytes per second sent
+	// to remote_write endpoints across all instances. 0 means no limit.
+	RemoteWriteBytesPerSecond int64 `yaml:"remote_write_bytes_per_second,omitempty"`
+
+	// ReplicaExternalLabel and ClusterExternalLabel are added to the global
+	// external_labels as the __replica__ and cluster labels when set, so
+	// remote_write endpoints can deduplicate samples from HA pairs of agents.
+	// Labels set in external_labels take precedence.
+	ReplicaExternalLabel string `yaml:"replica_external_label,omitempty"`
+	ClusterExternalLabel string `yaml:"cluster_external_label,omitempty"`
+
+	// HAPair makes the agent one of an HA pair of agents, where only the
+	// leader of the pair sends samples to remote_write.
+	HAPair ha.Config `yaml:"ha_pair,omitempty"`
 }
 
-// UnmarshalYAML implements yaml.Unmarshaler.
+// UnmarshalYAML implements yaml.Unmarshaler. Instance configs that extend
+// an instance template are merged with the template.
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultConfig
 
+	var doc yaml.MapSlice
+	if err := unmarshal(&doc); err != nil {
+		return err
+	}
+
 	type plain Config
-	err := unmarshal((*plain)(c))
-	if err != nil {
+	if hasInstanceTemplates(doc) {
+		expanded, err := expandInstanceTemplates(doc)
+		if err != nil {
+			return err
+		}
+		bb, err := yaml.Marshal(expanded)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(bb, (*plain)(c)); err != nil {
+			return err
+		}
+	} else if err := unmarshal((*plain)(c)); err != nil {
 		return err
 	}
 
@@ -61,7 +118,7 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // ApplyDefaults applies default values to the Config and validates it.
 func (c *Config) ApplyDefaults() error {
-	needWAL := len(c.Configs) > 0 || c.ServiceConfig.Enabled
+	needWAL := len(c.Configs) > 0 || c.ServiceConfig.Enabled || c.RuntimeConfigsDir != ""
 	if needWAL && c.WALDir == "" {
 		return errors.New("no wal_directory configured")
 	}
@@ -70,6 +127,32 @@ func (c *Config) ApplyDefaults() error {
 		return errors.New("cannot use configs when scraping_service mode is enabled")
 	}
 
+	if c.MaxConcurrentScrapes < 0 {
+		return errors.New("max_concurrent_scrapes must not be negative")
+	}
+
+	if c.RemoteWriteBytesPerSecond < 0 {
+		return errors.New("remote_write_bytes_per_second must not be negative")
+	}
+
+	if err := c.archiveOptions().Validate(c.WALDir); err != nil {
+		return fmt.Errorf("invalid WAL cleanup settings: %w", err)
+	}
+
+	if err := c.HAPair.Validate(); err != nil {
+		return fmt.Errorf("invalid ha_pair: %w", err)
+	}
+
+	if err := c.ServiceConfig.ConfigAPI.Validate(); err != nil {
+		return fmt.Errorf("invalid scraping_service.config_api: %w", err)
+	}
+
+	if c.ServiceConfig.Enabled && c.RuntimeConfigsDir != "" {
+		return errors.New("cannot use runtime_configs_directory when scraping_service mode is enabled")
+	}
+
+	c.Global.Prometheus.ExternalLabels = withHALabels(c.Global.Prometheus.ExternalLabels, c.ReplicaExternalLabel, c.ClusterExternalLabel)
+
 	usedNames := map[string]struct{}{}
 
 	for i := range c.Configs {
@@ -95,15 +178,58 @@ func (c *Config) ApplyDefaults() error {
 	return nil
 }
 
+// archiveOptions returns the ArchiveOptions of the WAL cleaner.
+func (c *Config) archiveOptions() ArchiveOptions {
+	return ArchiveOptions{
+		Strategy:  c.WALCleanupStrategy,
+		Directory: c.WALArchiveDir,
+		Retention: c.WALArchiveRetention,
+	}
+}
+
+// Names of the external labels set by ReplicaExternalLabel and
+// ClusterExternalLabel.
+const (
+	ReplicaLabel = "__replica__"
+	ClusterLabel = "cluster"
+)
+
+// withHALabels adds the replica and cluster labels to ls unless ls already
+// sets them or their values are empty.
+func withHALabels(ls labels.Labels, replica, cluster string) labels.Labels {
+	if replica == "" && cluster == "" {
+		return ls
+	}
+
+	b := labels.NewBuilder(ls)
+	if replica != "" && ls.Get(ReplicaLabel) == "" {
+		b.Set(ReplicaLabel, replica)
+	}
+	if cluster != "" && ls.Get(ClusterLabel) == "" {
+		b.Set(ClusterLabel, cluster)
+	}
+	return b.Labels()
+}
+
 // RegisterFlags defines flags corresponding to the Config.
 func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&c.WALDir, "prometheus.wal-directory", "", "base directory to store the WAL in")
 	f.DurationVar(&c.WALCleanupAge, "prometheus.wal-cleanup-age", DefaultConfig.WALCleanupAge, "remove abandoned (unused) WALs older than this")
 	f.DurationVar(&c.WALCleanupPeriod, "prometheus.wal-cleanup-period", DefaultConfig.WALCleanupPeriod, "how often to check for abandoned WALs")
+	f.StringVar((*string)(&c.WALCleanupStrategy), "prometheus.wal-cleanup-strategy", string(DefaultConfig.WALCleanupStrategy), "what to do with abandoned WALs: delete, archive (move to the archive directory) or compress (write a tarball to the archive directory)")
+	f.StringVar(&c.WALArchiveDir, "prometheus.wal-archive-directory", "", "directory abandoned WALs are archived in when the cleanup strategy is archive or compress")
+	f.DurationVar(&c.WALArchiveRetention, "prometheus.wal-archive-retention", DefaultConfig.WALArchiveRetention, "how long archived WALs are kept before being deleted. 0 keeps them forever")
+	f.Int64Var(&c.WALReplayMemoryLimit, "prometheus.wal-replay-memory-limit", 0, "maximum size in bytes of WAL segments replayed at once when an instance starts. 0 to only limit by the number of CPUs")
+	f.IntVar(&c.MaxConcurrentScrapes, "prometheus.max-concurrent-scrapes", 0, "maximum number of scrapes in flight across all instances. 0 for no limit")
+	f.Int64Var(&c.RemoteWriteBytesPerSecond, "prometheus.remote-write-bytes-per-second", 0, "maximum number of bytes per second sent to remote_write endpoints across all instances. 0 for no limit")
 	f.DurationVar(&c.InstanceRestartBackoff, "prometheus.instance-restart-backoff", DefaultConfig.InstanceRestartBackoff, "how long to wait before restarting a failed Prometheus instance")
+	f.DurationVar(&c.InstanceRestartMaxBackoff, "prometheus.instance-restart-max-backoff", DefaultConfig.InstanceRestartMaxBackoff, "maximum time to wait before restarting a Prometheus instance that keeps failing")
+	f.StringVar(&c.ReplicaExternalLabel, "prometheus.replica-external-label", "", "value of the "+ReplicaLabel+" external label added to all instances. Not added when empty")
+	f.StringVar(&c.ClusterExternalLabel, "prometheus.cluster-external-label", "", "value of the "+ClusterLabel+" external label added to all instances. Not added when empty")
 
 	c.ServiceConfig.RegisterFlagsWithPrefix("prometheus.service.", f)
 	c.ServiceClientConfig.RegisterFlags(f)
+	c.HAPair.RegisterFlagsWithPrefix("prometheus.ha-pair.", f)
 }
 
 // Agent is an agent for collecting Prometheus metrics. It acts as a
@@ -116,6 +242,10 @@ type Agent struct {
 	logger log.Logger
 	reg    prometheus.Registerer
 
+	// gatherer gathers the metrics registered to reg. Instances read their
+	// remote_write progress from it.
+	gatherer prometheus.Gatherer
+
 	// Store both the basic manager and the modal manager so we can update their
 	// settings indepedently. Only the ModalManager should be used for mutating
 	// configs.
@@ -125,28 +255,69 @@ type Agent struct {
 
 	instanceFactory instanceFactory
 
+	// scrapeLimiter is shared by all instances to enforce
+	// MaxConcurrentScrapes.
+	scrapeLimiter *instance.ScrapeLimiter
+
+	// egressLimiter is shared by all instances to enforce
+	// RemoteWriteBytesPerSecond.
+	egressLimiter *instance.EgressLimiter
+
+	// remoteWriteGate is shared by all instances and is only open while the
+	// agent is the leader of its HA pair.
+	remoteWriteGate *instance.RemoteWriteGate
+	elector         *ha.Elector
+
+	// ingest writes samples streamed through the gRPC ingest API.
+	ingest *ingest.Server
+
 	cluster *cluster.Cluster
 
+	// runtimeConfigs is the set of config names that were added through the
+	// instances API rather than the config file.
+	runtimeMut     sync.Mutex
+	runtimeConfigs map[string]struct{}
+
 	stopped  bool
 	stopOnce sync.Once
 	actor    chan func()
 }
 
-// New creates and starts a new Agent.
-func New(reg prometheus.Registerer, cfg Config, logger log.Logger) (*Agent, error) {
-	return newAgent(reg, cfg, logger, defaultInstanceFactory)
+// New creates and starts a new Agent. Metrics are registered to reg, and
+// gatherer must gather the metrics registered to it.
+func New(reg prometheus.Registerer, gatherer prometheus.Gatherer, cfg Config, logger log.Logger) (*Agent, error) {
+	return newAgent(reg, gatherer, cfg, logger, defaultInstanceFactory)
 }
 
-func newAgent(reg prometheus.Registerer, cfg Config, logger log.Logger, fact instanceFactory) (*Agent, error) {
+func newAgent(reg prometheus.Registerer, gatherer prometheus.Gatherer, cfg Config, logger log.Logger, fact instanceFactory) (*Agent, error) {
 	a := &Agent{
 		logger:          log.With(logger, "agent", "prometheus"),
 		instanceFactory: fact,
 		reg:             reg,
+		gatherer:        gatherer,
 		actor:           make(chan func(), 1),
+		runtimeConfigs:  make(map[string]struct{}),
+		scrapeLimiter:   instance.NewScrapeLimiter(cfg.MaxConcurrentScrapes),
+		egressLimiter:   instance.NewEgressLimiter(cfg.RemoteWriteBytesPerSecond),
+		remoteWriteGate: instance.NewRemoteWriteGate(true),
 	}
+	a.elector = ha.New(a.logger, reg, a.remoteWriteGate)
+	a.ingest = ingest.NewServer(a.logger, reg, a.remoteWriteReceiverInstance, func(name string) (storage.Appendable, error) {
+		return a.mm.GetInstance(name)
+	})
+
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "agent_prometheus_scrapes_in_flight",
+		Help: "Number of scrapes currently running across all instances.",
+	}, func() float64 { return float64(a.scrapeLimiter.InFlight()) })
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "agent_prometheus_scrapes_queued",
+		Help: "Number of scrapes waiting for the global max_concurrent_scrapes.",
+	}, func() float64 { return float64(a.scrapeLimiter.Queued()) })
 
 	a.bm = instance.NewBasicManager(instance.BasicManagerConfig{
-		InstanceRestartBackoff: cfg.InstanceRestartBackoff,
+		InstanceRestartBackoff:    cfg.InstanceRestartBackoff,
+		InstanceRestartMaxBackoff: cfg.InstanceRestartMaxBackoff,
 	}, a.logger, a.newInstance)
 
 	var err error
@@ -182,7 +353,7 @@ func (a *Agent) newInstance(c instance.Config) (instance.ManagedInstance, error)
 		instanceLabel: c.Name,
 	}, a.reg)
 
-	return a.instanceFactory(reg, a.cfg.Global, c, a.cfg.WALDir, a.logger)
+	return a.instanceFactory(reg, a.gatherer, a.cfg.Global, c, a.cfg.WALDir, a.cfg.WALReplayMemoryLimit, a.scrapeLimiter, a.egressLimiter, a.remoteWriteGate, a.logger)
 }
 
 // Validate will validate the incoming Config and mutate it to apply defaults.
@@ -222,16 +393,42 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 	if a.cleaner != nil {
 		a.cleaner.Stop()
 	}
+
+	// In scraping service mode, WALs may belong to configs that are about to be
+	// assigned to this Agent, so the cleaner must also check the configstore.
+	var inUse InUseStorageFunc
+	if cfg.ServiceConfig.Enabled {
+		timeout := cfg.ServiceConfig.ReshardTimeout
+		inUse = func(ctx context.Context) (map[string]bool, error) {
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			return a.clusterStorage(ctx)
+		}
+	}
+
 	a.cleaner = NewWALCleaner(
 		a.logger,
 		a.mm,
+		inUse,
 		cfg.WALDir,
 		cfg.WALCleanupAge,
 		cfg.WALCleanupPeriod,
+		cfg.archiveOptions(),
 	)
 
+	a.scrapeLimiter.SetLimit(cfg.MaxConcurrentScrapes)
+	a.egressLimiter.SetLimit(cfg.RemoteWriteBytesPerSecond)
+
+	if err := a.elector.ApplyConfig(cfg.HAPair); err != nil {
+		return fmt.Errorf("failed to apply ha_pair config: %w", err)
+	}
+
 	a.bm.UpdateManagerConfig(instance.BasicManagerConfig{
-		InstanceRestartBackoff: cfg.InstanceRestartBackoff,
+		InstanceRestartBackoff:    cfg.InstanceRestartBackoff,
+		InstanceRestartMaxBackoff: cfg.InstanceRestartMaxBackoff,
 	})
 
 	if err := a.mm.SetMode(cfg.InstanceMode); err != nil {
@@ -246,14 +443,58 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 	// because creating both this function and newInstance grab the mutex.
 	oldConfig := a.cfg
 
+	// Persisted runtime configs only need to be loaded when the directory
+	// they're read from changes.
+	loadRuntime := cfg.RuntimeConfigsDir != "" && cfg.RuntimeConfigsDir != oldConfig.RuntimeConfigsDir
+
 	a.actor <- func() {
 		a.syncInstances(oldConfig, cfg)
+		if loadRuntime {
+			a.syncRuntimeConfigs(cfg.RuntimeConfigsDir, cfg.Configs)
+		}
 	}
 
 	a.cfg = cfg
 	return nil
 }
 
+// clusterStorage returns the names of storage directories that may be used by
+// any config in the scraping service's configstore. Both the config name and
+// the name of its instance group are included, since WALs are named after one
+// or the other depending on the instance mode.
+func (a *Agent) clusterStorage(ctx context.Context) (map[string]bool, error) {
+	keys, err := a.cluster.ListConfigs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list configs: %w", err)
+	}
+
+	names := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		names[key] = true
+	}
+
+	configs, err := a.cluster.AllConfigs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configs: %w", err)
+	}
+	for cfg := range configs {
+		// Configs that fail validation will never be run by any Agent, so there's
+		// no group to protect for them.
+		if err := a.Validate(&cfg); err != nil {
+			level.Debug(a.logger).Log("msg", "ignoring invalid config when checking for WALs in use", "name", cfg.Name, "err", err)
+			continue
+		}
+
+		group, err := instance.GroupName(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get group name for %s: %w", cfg.Name, err)
+		}
+		names[group] = true
+	}
+
+	return names, nil
+}
+
 // syncInstances syncs the state of the instance manager to newConfig by
 // applying all configs from newConfig and deleting any configs from oldConfig
 // that are not in newConfig.
@@ -294,6 +535,13 @@ func (a *Agent) run() {
 // WireGRPC wires gRPC services into the provided server.
 func (a *Agent) WireGRPC(s *grpc.Server) {
 	a.cluster.WireGRPC(s)
+	ingest.RegisterMetricsIngestServer(s, a.ingest)
+}
+
+func (a *Agent) remoteWriteReceiverInstance() string {
+	a.mut.RLock()
+	defer a.mut.RUnlock()
+	return a.cfg.RemoteWriteReceiverInstance
 }
 
 // Config returns the configuration of this Agent.
@@ -320,11 +568,15 @@ func (a *Agent) Stop() {
 	// BasicManager.
 	a.mm.Stop()
 
+	// The lease is released after instances stop so the other agent of the
+	// HA pair takes over once they've flushed their samples.
+	a.elector.Stop()
+
 	a.stopped = true
 }
 
-type instanceFactory = func(reg prometheus.Registerer, global instance.GlobalConfig, cfg instance.Config, walDir string, logger log.Logger) (instance.ManagedInstance, error)
+type instanceFactory = func(reg prometheus.Registerer, gatherer prometheus.Gatherer, global instance.GlobalConfig, cfg instance.Config, walDir string, walReplayMemoryLimit int64, scrapeLimiter *instance.ScrapeLimiter, egressLimiter *instance.EgressLimiter, remoteWriteGate *instance.RemoteWriteGate, logger log.Logger) (instance.ManagedInstance, error)
 
-func defaultInstanceFactory(reg prometheus.Registerer, global instance.GlobalConfig, cfg instance.Config, walDir string, logger log.Logger) (instance.ManagedInstance, error) {
-	return instance.New(reg, global, cfg, walDir, logger)
+func defaultInstanceFactory(reg prometheus.Registerer, gatherer prometheus.Gatherer, global instance.GlobalConfig, cfg instance.Config, walDir string, walReplayMemoryLimit int64, scrapeLimiter *instance.ScrapeLimiter, egressLimiter *instance.EgressLimiter, remoteWriteGate *instance.RemoteWriteGate, logger log.Logger) (instance.ManagedInstance, error) {
+	return instance.New(reg, gatherer, global, cfg, walDir, walReplayMemoryLimit, scrapeLimiter, egressLimiter, remoteWriteGate, logger)
 }
@@ -12,7 +12,9 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/grafana/agent/pkg/prom/instance"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
 	"gopkg.in/yaml.v2"
@@ -57,6 +59,15 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			expect: errors.New("prometheus instance names must be unique. found multiple instances with name instance"),
 		},
+		{
+			name: "runtime configs with scraping service",
+			mutator: func(c *Config) {
+				c.Configs = nil
+				c.ServiceConfig.Enabled = true
+				c.RuntimeConfigsDir = "/tmp/runtime"
+			},
+			expect: errors.New("cannot use runtime_configs_directory when scraping_service mode is enabled"),
+		},
 	}
 
 	for _, tc := range tt {
@@ -86,6 +97,26 @@ func copyConfig(t *testing.T, c Config) Config {
 	return cp
 }
 
+func TestConfig_HALabels(t *testing.T) {
+	cfgText := `
+global:
+  external_labels:
+    cluster: from-config
+configs:
+  - name: testconfig`
+
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(cfgText), &cfg))
+	cfg.WALDir = "/tmp/data"
+	cfg.ReplicaExternalLabel = "agent-1"
+	cfg.ClusterExternalLabel = "from-flag"
+	require.NoError(t, cfg.ApplyDefaults())
+
+	// Labels in external_labels take precedence over the flags.
+	expect := labels.FromStrings(ClusterLabel, "from-config", ReplicaLabel, "agent-1")
+	require.Equal(t, expect, cfg.Global.Prometheus.ExternalLabels)
+}
+
 func TestConfigNonzeroDefaultScrapeInterval(t *testing.T) {
 	cfgText := `
 wal_directory: ./wal
@@ -125,7 +156,7 @@ func TestAgent(t *testing.T) {
 
 	fact := newFakeInstanceFactory()
 
-	a, err := newAgent(prometheus.NewRegistry(), cfg, log.NewNopLogger(), fact.factory)
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), cfg, log.NewNopLogger(), fact.factory)
 	require.NoError(t, err)
 
 	test.Poll(t, time.Second*30, true, func() interface{} {
@@ -186,7 +217,7 @@ func TestAgent_NormalInstanceExits(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			fact := newFakeInstanceFactory()
 
-			a, err := newAgent(prometheus.NewRegistry(), cfg, log.NewNopLogger(), fact.factory)
+			a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), cfg, log.NewNopLogger(), fact.factory)
 			require.NoError(t, err)
 
 			test.Poll(t, time.Second*30, true, func() interface{} {
@@ -230,7 +261,7 @@ func TestAgent_Stop(t *testing.T) {
 
 	fact := newFakeInstanceFactory()
 
-	a, err := newAgent(prometheus.NewRegistry(), cfg, log.NewNopLogger(), fact.factory)
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), cfg, log.NewNopLogger(), fact.factory)
 	require.NoError(t, err)
 
 	test.Poll(t, time.Second*30, true, func() interface{} {
@@ -280,10 +311,18 @@ func (i *fakeInstance) TargetsActive() map[string][]*scrape.Target {
 	return nil
 }
 
+func (i *fakeInstance) TargetsDropped() map[string][]*scrape.Target {
+	return nil
+}
+
 func (i *fakeInstance) StorageDirectory() string {
 	return ""
 }
 
+func (i *fakeInstance) Appender(ctx context.Context) storage.Appender {
+	return nil
+}
+
 type fakeInstanceFactory struct {
 	mut   sync.Mutex
 	mocks []*fakeInstance
@@ -301,7 +340,7 @@ func (f *fakeInstanceFactory) Mocks() []*fakeInstance {
 	return f.mocks
 }
 
-func (f *fakeInstanceFactory) factory(_ prometheus.Registerer, _ instance.GlobalConfig, cfg instance.Config, _ string, _ log.Logger) (instance.ManagedInstance, error) {
+func (f *fakeInstanceFactory) factory(_ prometheus.Registerer, _ prometheus.Gatherer, _ instance.GlobalConfig, cfg instance.Config, _ string, _ int64, _ *instance.ScrapeLimiter, _ *instance.EgressLimiter, _ *instance.RemoteWriteGate, _ log.Logger) (instance.ManagedInstance, error) {
 	f.created.Add(1)
 
 	f.mut.Lock()
@@ -1,9 +1,11 @@
 package prom
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -24,7 +26,7 @@ const (
 var (
 	discoveryError = promauto.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "agent_prometheus_cleaner_storage_error_total",
+			Name: "agent_wal_cleaner_storage_error_total",
 			Help: "Errors encountered discovering local storage paths",
 		},
 		[]string{"storage"},
@@ -32,7 +34,7 @@ var (
 
 	segmentError = promauto.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "agent_prometheus_cleaner_segment_error_total",
+			Name: "agent_wal_cleaner_segment_error_total",
 			Help: "Errors encountered finding most recent WAL segments",
 		},
 		[]string{"storage"},
@@ -40,40 +42,53 @@ var (
 
 	managedStorage = promauto.NewGauge(
 		prometheus.GaugeOpts{
-			Name: "agent_prometheus_cleaner_managed_storage",
+			Name: "agent_wal_cleaner_managed_storage",
 			Help: "Number of storage directories associated with managed instances",
 		},
 	)
 
 	abandonedStorage = promauto.NewGauge(
 		prometheus.GaugeOpts{
-			Name: "agent_prometheus_cleaner_abandoned_storage",
+			Name: "agent_wal_cleaner_abandoned_storage",
 			Help: "Number of storage directories not associated with any managed instance",
 		},
 	)
 
-	cleanupRunsSuccess = promauto.NewCounter(
+	abandonedTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
-			Name: "agent_prometheus_cleaner_success_total",
-			Help: "Number of successfully removed abandoned WALs",
+			Name: "agent_wal_cleaner_abandoned_total",
+			Help: "Total number of abandoned WALs found that were eligible for deletion",
 		},
 	)
 
-	cleanupRunsErrors = promauto.NewCounter(
+	cleanedTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
-			Name: "agent_prometheus_cleaner_errors_total",
-			Help: "Number of errors removing abandoned WALs",
+			Name: "agent_wal_cleaner_cleaned_total",
+			Help: "Total number of successfully removed abandoned WALs",
+		},
+	)
+
+	cleanupErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_wal_cleaner_errors_total",
+			Help: "Total number of errors removing abandoned WALs",
 		},
 	)
 
 	cleanupTimes = promauto.NewHistogram(
 		prometheus.HistogramOpts{
-			Name: "agent_prometheus_cleaner_cleanup_seconds",
+			Name: "agent_wal_cleaner_cleanup_seconds",
 			Help: "Time spent performing each periodic WAL cleanup",
 		},
 	)
 )
 
+// InUseStorageFunc returns the names of storage directories that are
+// referenced by configs outside of the local instance.Manager, such as configs
+// owned by other Agents in scraping service mode. Storage directories with
+// these names will not be deleted.
+type InUseStorageFunc func(ctx context.Context) (map[string]bool, error)
+
 // lastModifiedFunc gets the last modified time of the most recent segment of a WAL
 type lastModifiedFunc func(path string) (time.Time, error)
 
@@ -107,31 +122,45 @@ func lastModified(path string) (time.Time, error) {
 
 // WALCleaner periodically checks for Write Ahead Logs (WALs) that are not associated
 // with any active instance.ManagedInstance and have not been written to in some configured
-// amount of time and deletes them.
+// amount of time and deletes or archives them.
 type WALCleaner struct {
+	// cleanupMut prevents periodic and on-demand cleanups from running at the
+	// same time.
+	cleanupMut sync.Mutex
+
 	logger          log.Logger
 	instanceManager instance.Manager
+	inUseStorage    InUseStorageFunc
 	walDirectory    string
 	walLastModified lastModifiedFunc
 	minAge          time.Duration
 	period          time.Duration
+	archive         ArchiveOptions
 	done            chan bool
 }
 
 // NewWALCleaner creates a new cleaner that looks for abandoned WALs in the given
 // directory and removes them if they haven't been modified in over minAge. Starts
-// a goroutine to periodically run the cleanup method in a loop
-func NewWALCleaner(logger log.Logger, manager instance.Manager, walDirectory string, minAge time.Duration, period time.Duration) *WALCleaner {
+// a goroutine to periodically run the cleanup method in a loop. inUse may be
+// nil if only local instances should be consulted. archive configures whether
+// abandoned WALs are archived before being deleted.
+func NewWALCleaner(logger log.Logger, manager instance.Manager, inUse InUseStorageFunc, walDirectory string, minAge time.Duration, period time.Duration, archive ArchiveOptions) *WALCleaner {
 	c := &WALCleaner{
 		logger:          log.With(logger, "component", "cleaner"),
 		instanceManager: manager,
+		inUseStorage:    inUse,
 		walDirectory:    filepath.Clean(walDirectory),
 		walLastModified: lastModified,
 		minAge:          DefaultCleanupAge,
 		period:          DefaultCleanupPeriod,
+		archive:         archive,
 		done:            make(chan bool),
 	}
 
+	if c.archive.Strategy == "" {
+		c.archive.Strategy = CleanupDelete
+	}
+
 	if minAge > 0 {
 		c.minAge = minAge
 	}
@@ -174,7 +203,12 @@ func (c *WALCleaner) getAllStorage() []string {
 			discoveryError.WithLabelValues(p).Inc()
 			level.Warn(c.logger).Log("msg", "unable to traverse WAL storage path", "path", p, "err", err)
 		} else if info.IsDir() && filepath.Dir(p) == c.walDirectory {
-			// Single level below the root are instance storage directories (including WALs)
+			// Single level below the root are instance storage directories
+			// (including WALs) and the rules heads of instances, which are
+			// removed along with their storage.
+			if instance.IsRulesHeadDirectory(p) {
+				return filepath.SkipDir
+			}
 			out = append(out, p)
 		}
 
@@ -188,6 +222,12 @@ func (c *WALCleaner) getAllStorage() []string {
 // an active instance  and haven't been written to within a configured duration (usually several
 // hours or more).
 func (c *WALCleaner) getAbandonedStorage(all []string, managed map[string]bool, now time.Time) []string {
+	return c.getAbandonedStorageWithAge(all, managed, now, c.minAge)
+}
+
+// getAbandonedStorageWithAge is like getAbandonedStorage but uses minAge as the
+// cutoff instead of the cleaner's configured age.
+func (c *WALCleaner) getAbandonedStorageWithAge(all []string, managed map[string]bool, now time.Time, minAge time.Duration) []string {
 	var out []string
 
 	for _, dir := range all {
@@ -205,7 +245,7 @@ func (c *WALCleaner) getAbandonedStorage(all []string, managed map[string]bool,
 		}
 
 		diff := now.Sub(mtime)
-		if diff > c.minAge {
+		if diff > minAge {
 			// The last segment for this WAL was modified more then $minAge (positive number of hours)
 			// in the past. This makes it a candidate for deletion since it's also not associated with
 			// any Instances this agent knows about.
@@ -243,26 +283,74 @@ func (c *WALCleaner) run() {
 // necessary to call this method explicitly in most cases since it will be run periodically
 // in a goroutine (started when WALCleaner is created).
 func (c *WALCleaner) cleanup() {
+	_ = c.CleanupStorage(c.minAge)
+}
+
+// CleanupStorage immediately removes abandoned WAL directories that haven't
+// been written to in over minAge, ignoring the cleaner's configured age. The
+// paths of the directories that were successfully removed, or moved to the
+// archive directory, are returned. Archived WALs past their retention are
+// deleted as well.
+func (c *WALCleaner) CleanupStorage(minAge time.Duration) []string {
+	c.cleanupMut.Lock()
+	defer c.cleanupMut.Unlock()
+
 	start := time.Now()
 	all := c.getAllStorage()
 	managed := c.getManagedStorage(c.instanceManager.ListInstances())
-	abandoned := c.getAbandonedStorage(all, managed, time.Now())
+
+	if c.inUseStorage != nil {
+		inUse, err := c.inUseStorage(context.Background())
+		if err != nil {
+			// We can't know whether any of the storage is still needed elsewhere,
+			// so it's not safe to delete anything.
+			level.Warn(c.logger).Log("msg", "unable to determine storage in use by remote configs, skipping cleanup", "err", err)
+			cleanupErrors.Inc()
+			return nil
+		}
+		for _, dir := range all {
+			if inUse[filepath.Base(dir)] {
+				managed[dir] = true
+			}
+		}
+	}
+
+	now := time.Now()
+	abandoned := c.getAbandonedStorageWithAge(all, managed, now, minAge)
 
 	managedStorage.Set(float64(len(managed)))
 	abandonedStorage.Set(float64(len(abandoned)))
+	abandonedTotal.Add(float64(len(abandoned)))
 
+	deleted := make([]string, 0, len(abandoned))
 	for _, a := range abandoned {
-		level.Info(c.logger).Log("msg", "deleting abandoned WAL", "name", a)
-		err := os.RemoveAll(a)
+		level.Info(c.logger).Log("msg", "deleting abandoned WAL", "name", a, "strategy", c.archive.Strategy)
+		err := c.removeStorage(a, now)
 		if err != nil {
-			level.Error(c.logger).Log("msg", "failed to delete abandoned WAL", "name", a, "err", err)
-			cleanupRunsErrors.Inc()
+			level.Error(c.logger).Log("msg", "failed to delete abandoned WAL", "name", a, "strategy", c.archive.Strategy, "err", err)
+			cleanupErrors.Inc()
 		} else {
-			cleanupRunsSuccess.Inc()
+			cleanedTotal.Inc()
+			deleted = append(deleted, a)
+		}
+
+		// A rules head is only left behind if its instance didn't stop
+		// cleanly, and can't be used by another instance.
+		if err := os.RemoveAll(instance.RulesHeadDirectory(a)); err != nil {
+			level.Warn(c.logger).Log("msg", "failed to delete rules head of abandoned WAL", "name", a, "err", err)
 		}
 	}
 
+	c.pruneArchive(now)
+
 	cleanupTimes.Observe(time.Since(start).Seconds())
+	return deleted
+}
+
+// MinAge returns the configured minimum age of abandoned WALs before they
+// are removed.
+func (c *WALCleaner) MinAge() time.Duration {
+	return c.minAge
 }
 
 // Stop the cleaner and any background tasks running
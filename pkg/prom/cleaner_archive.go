@@ -0,0 +1,258 @@
+package prom
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultArchiveRetention is the default time abandoned WALs are kept in the
+// archive directory before being deleted.
+const DefaultArchiveRetention = 7 * 24 * time.Hour
+
+// compressedArchiveExt is the extension of compressed archived WALs.
+const compressedArchiveExt = ".tar.gz"
+
+var (
+	archivedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_wal_cleaner_archived_total",
+			Help: "Total number of abandoned WALs moved to the archive directory instead of being deleted",
+		},
+	)
+
+	archivePrunedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_wal_cleaner_archive_pruned_total",
+			Help: "Total number of archived WALs deleted after their retention period",
+		},
+	)
+)
+
+// CleanupStrategy is what the WAL cleaner does with abandoned WALs.
+type CleanupStrategy string
+
+// Supported cleanup strategies.
+const (
+	// CleanupDelete deletes abandoned WALs.
+	CleanupDelete CleanupStrategy = "delete"
+	// CleanupArchive moves abandoned WALs to the archive directory. The
+	// archive directory should be on the same filesystem as the WALs.
+	CleanupArchive CleanupStrategy = "archive"
+	// CleanupCompress writes abandoned WALs as a gzipped tarball to the
+	// archive directory before deleting them.
+	CleanupCompress CleanupStrategy = "compress"
+)
+
+// ArchiveOptions configures the WAL cleaner to keep abandoned WALs in an
+// archive directory for a while instead of deleting them right away.
+type ArchiveOptions struct {
+	// Strategy for abandoned WALs. Defaults to CleanupDelete.
+	Strategy CleanupStrategy
+
+	// Directory archived WALs are stored in. Required unless Strategy is
+	// CleanupDelete. Archived WALs are pruned from Directory even when
+	// Strategy is CleanupDelete, so WALs archived before switching strategies
+	// are still removed.
+	Directory string
+
+	// Retention is how long archived WALs are kept before being deleted. 0
+	// keeps them forever.
+	Retention time.Duration
+}
+
+// Validate returns an error if the options are invalid. walDirectory is the
+// directory WALs are stored in.
+func (o ArchiveOptions) Validate(walDirectory string) error {
+	switch o.Strategy {
+	case "", CleanupDelete:
+		return nil
+	case CleanupArchive, CleanupCompress:
+	default:
+		return fmt.Errorf("unsupported strategy %q, expected %q, %q or %q", o.Strategy, CleanupDelete, CleanupArchive, CleanupCompress)
+	}
+
+	if o.Directory == "" {
+		return fmt.Errorf("an archive directory is required with the %s strategy", o.Strategy)
+	}
+	if o.Retention < 0 {
+		return errors.New("archive retention must not be negative")
+	}
+
+	// Anything in the WAL directory is treated as an instance's storage, so
+	// archived WALs would be cleaned up again.
+	if walDirectory != "" {
+		rel, err := filepath.Rel(filepath.Clean(walDirectory), filepath.Clean(o.Directory))
+		if err == nil && (rel == "." || !strings.HasPrefix(rel, "..")) {
+			return errors.New("archive directory must not be inside the WAL directory")
+		}
+	}
+	return nil
+}
+
+// removeStorage removes the abandoned storage directory dir, archiving it
+// first according to the configured strategy.
+func (c *WALCleaner) removeStorage(dir string, now time.Time) error {
+	switch c.archive.Strategy {
+	case CleanupArchive:
+		if err := os.MkdirAll(c.archive.Directory, 0755); err != nil {
+			return fmt.Errorf("failed to create archive directory: %w", err)
+		}
+		if err := os.Rename(dir, archivePath(c.archive.Directory, dir, now, "")); err != nil {
+			return fmt.Errorf("failed to move WAL to archive directory: %w", err)
+		}
+		archivedTotal.Inc()
+		return nil
+
+	case CleanupCompress:
+		if err := os.MkdirAll(c.archive.Directory, 0755); err != nil {
+			return fmt.Errorf("failed to create archive directory: %w", err)
+		}
+		if err := compressDir(dir, archivePath(c.archive.Directory, dir, now, compressedArchiveExt)); err != nil {
+			return fmt.Errorf("failed to compress WAL: %w", err)
+		}
+		archivedTotal.Inc()
+		return os.RemoveAll(dir)
+
+	default:
+		return os.RemoveAll(dir)
+	}
+}
+
+// archivePath returns the path in archiveDir for the storage directory dir
+// archived at now. The time is kept in the name since renaming a directory
+// doesn't change its modification time.
+func archivePath(archiveDir, dir string, now time.Time, ext string) string {
+	return filepath.Join(archiveDir, fmt.Sprintf("%s.%d%s", filepath.Base(dir), now.Unix(), ext))
+}
+
+// archivedAt returns when the entry with the given name was archived.
+func archivedAt(name string) (time.Time, bool) {
+	name = strings.TrimSuffix(name, compressedArchiveExt)
+
+	idx := strings.LastIndexByte(name, '.')
+	if idx == -1 {
+		return time.Time{}, false
+	}
+	ts, err := strconv.ParseInt(name[idx+1:], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(ts, 0), true
+}
+
+// pruneArchive deletes archived WALs older than the archive retention.
+func (c *WALCleaner) pruneArchive(now time.Time) {
+	if c.archive.Directory == "" || c.archive.Retention == 0 {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(c.archive.Directory)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		level.Warn(c.logger).Log("msg", "unable to read WAL archive directory", "path", c.archive.Directory, "err", err)
+		cleanupErrors.Inc()
+		return
+	}
+
+	for _, e := range entries {
+		// Ignore anything that wasn't archived by the cleaner.
+		archived, ok := archivedAt(e.Name())
+		if !ok || now.Sub(archived) <= c.archive.Retention {
+			continue
+		}
+
+		path := filepath.Join(c.archive.Directory, e.Name())
+		level.Info(c.logger).Log("msg", "deleting archived WAL", "name", path)
+		if err := os.RemoveAll(path); err != nil {
+			level.Error(c.logger).Log("msg", "failed to delete archived WAL", "name", path, "err", err)
+			cleanupErrors.Inc()
+			continue
+		}
+		archivePrunedTotal.Inc()
+	}
+}
+
+// compressDir writes the regular files in dir to a gzipped tarball at path.
+// The tarball is written to a temporary file first so an interrupted
+// compression never leaves a partial archive behind.
+func compressDir(dir, path string) (err error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	root := filepath.Dir(dir)
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,14 +1,22 @@
 package prom
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 )
 
@@ -18,9 +26,11 @@ func TestWALCleaner_getAllStorageNoRoot(t *testing.T) {
 	cleaner := NewWALCleaner(
 		logger,
 		&instance.MockManager{},
+		nil,
 		walRoot,
 		DefaultCleanupAge,
 		DefaultCleanupPeriod,
+		ArchiveOptions{},
 	)
 
 	// Bogus WAL root that doesn't exist. Method should return no results
@@ -38,13 +48,19 @@ func TestWALCleaner_getAllStorageSuccess(t *testing.T) {
 	err = os.MkdirAll(walDir, 0755)
 	require.NoError(t, err)
 
+	// Rules heads are stored next to WALs but aren't storage directories.
+	err = os.MkdirAll(instance.RulesHeadDirectory(walDir), 0755)
+	require.NoError(t, err)
+
 	logger := log.NewLogfmtLogger(os.Stderr)
 	cleaner := NewWALCleaner(
 		logger,
 		&instance.MockManager{},
+		nil,
 		walRoot,
 		DefaultCleanupAge,
 		DefaultCleanupPeriod,
+		ArchiveOptions{},
 	)
 	wals := cleaner.getAllStorage()
 
@@ -68,9 +84,11 @@ func TestWALCleaner_getAbandonedStorageBeforeCutoff(t *testing.T) {
 	cleaner := NewWALCleaner(
 		logger,
 		&instance.MockManager{},
+		nil,
 		walRoot,
 		5*time.Minute,
 		DefaultCleanupPeriod,
+		ArchiveOptions{},
 	)
 
 	cleaner.walLastModified = func(path string) (time.Time, error) {
@@ -101,9 +119,11 @@ func TestWALCleaner_getAbandonedStorageAfterCutoff(t *testing.T) {
 	cleaner := NewWALCleaner(
 		logger,
 		&instance.MockManager{},
+		nil,
 		walRoot,
 		5*time.Minute,
 		DefaultCleanupPeriod,
+		ArchiveOptions{},
 	)
 
 	cleaner.walLastModified = func(path string) (time.Time, error) {
@@ -136,9 +156,11 @@ func TestWALCleaner_cleanup(t *testing.T) {
 	cleaner := NewWALCleaner(
 		logger,
 		manager,
+		nil,
 		walRoot,
 		5*time.Minute,
 		DefaultCleanupPeriod,
+		ArchiveOptions{},
 	)
 
 	cleaner.walLastModified = func(path string) (time.Time, error) {
@@ -148,8 +170,200 @@ func TestWALCleaner_cleanup(t *testing.T) {
 	// Last modification time on our WAL directory is 30 minutes in the past
 	// compared to "now" and we've set the cutoff for our cleaner to be 5
 	// minutes: our WAL directory should be removed since it's abandoned
+	var (
+		abandonedBefore = counterValue(t, abandonedTotal)
+		cleanedBefore   = counterValue(t, cleanedTotal)
+		errorsBefore    = counterValue(t, cleanupErrors)
+	)
+
 	cleaner.cleanup()
 	_, err = os.Stat(walDir)
 	require.Error(t, err)
 	require.True(t, os.IsNotExist(err))
+
+	require.Equal(t, abandonedBefore+1, counterValue(t, abandonedTotal))
+	require.Equal(t, cleanedBefore+1, counterValue(t, cleanedTotal))
+	require.Equal(t, errorsBefore, counterValue(t, cleanupErrors))
+}
+
+func TestWALCleaner_cleanupInUse(t *testing.T) {
+	walRoot, err := ioutil.TempDir(os.TempDir(), "cleanup")
+	require.NoError(t, err)
+	defer os.RemoveAll(walRoot)
+
+	var (
+		remoteDir    = filepath.Join(walRoot, "remote-1")
+		abandonedDir = filepath.Join(walRoot, "instance-1")
+	)
+	require.NoError(t, os.MkdirAll(remoteDir, 0755))
+	require.NoError(t, os.MkdirAll(abandonedDir, 0755))
+
+	now := time.Now()
+	logger := log.NewLogfmtLogger(os.Stderr)
+	manager := &instance.MockManager{}
+	manager.ListInstancesFunc = func() map[string]instance.ManagedInstance {
+		return make(map[string]instance.ManagedInstance)
+	}
+
+	var inUseErr error
+	inUse := func(ctx context.Context) (map[string]bool, error) {
+		if inUseErr != nil {
+			return nil, inUseErr
+		}
+		return map[string]bool{"remote-1": true}, nil
+	}
+
+	cleaner := NewWALCleaner(
+		logger,
+		manager,
+		inUse,
+		walRoot,
+		5*time.Minute,
+		DefaultCleanupPeriod,
+		ArchiveOptions{},
+	)
+	cleaner.walLastModified = func(path string) (time.Time, error) {
+		return now.Add(-30 * time.Minute), nil
+	}
+
+	// If the storage in use can't be determined, nothing should be deleted.
+	inUseErr = fmt.Errorf("configstore unavailable")
+	require.Empty(t, cleaner.CleanupStorage(cleaner.MinAge()))
+	require.DirExists(t, remoteDir)
+	require.DirExists(t, abandonedDir)
+
+	// Otherwise, only the directory not used by a remote config is deleted.
+	inUseErr = nil
+	require.Equal(t, []string{abandonedDir}, cleaner.CleanupStorage(cleaner.MinAge()))
+	require.DirExists(t, remoteDir)
+	require.NoDirExists(t, abandonedDir)
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	require.NoError(t, c.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestWALCleaner_cleanupArchive(t *testing.T) {
+	for _, strategy := range []CleanupStrategy{CleanupArchive, CleanupCompress} {
+		t.Run(string(strategy), func(t *testing.T) {
+			root, err := ioutil.TempDir(os.TempDir(), "cleanupArchive")
+			require.NoError(t, err)
+			defer os.RemoveAll(root)
+
+			var (
+				walRoot    = filepath.Join(root, "wal")
+				archiveDir = filepath.Join(root, "archive")
+				walDir     = filepath.Join(walRoot, "instance-1")
+			)
+			require.NoError(t, os.MkdirAll(filepath.Join(walDir, "wal"), 0755))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(walDir, "wal", "00000000"), []byte("segment"), 0644))
+
+			manager := &instance.MockManager{}
+			manager.ListInstancesFunc = func() map[string]instance.ManagedInstance {
+				return make(map[string]instance.ManagedInstance)
+			}
+
+			now := time.Now()
+			cleaner := NewWALCleaner(
+				log.NewNopLogger(),
+				manager,
+				nil,
+				walRoot,
+				5*time.Minute,
+				DefaultCleanupPeriod,
+				ArchiveOptions{Strategy: strategy, Directory: archiveDir, Retention: time.Hour},
+			)
+			cleaner.walLastModified = func(path string) (time.Time, error) {
+				return now.Add(-30 * time.Minute), nil
+			}
+
+			// An archived WAL past its retention should be pruned, while
+			// anything not archived by the cleaner is left alone.
+			expiredDir := filepath.Join(archiveDir, fmt.Sprintf("instance-0.%d", now.Add(-2*time.Hour).Unix()))
+			otherDir := filepath.Join(archiveDir, "other")
+			require.NoError(t, os.MkdirAll(expiredDir, 0755))
+			require.NoError(t, os.MkdirAll(otherDir, 0755))
+
+			require.Equal(t, []string{walDir}, cleaner.CleanupStorage(cleaner.MinAge()))
+			require.NoDirExists(t, walDir)
+			require.NoDirExists(t, expiredDir)
+			require.DirExists(t, otherDir)
+
+			archived, err := filepath.Glob(filepath.Join(archiveDir, "instance-1.*"))
+			require.NoError(t, err)
+			require.Len(t, archived, 1)
+
+			if strategy == CleanupArchive {
+				require.FileExists(t, filepath.Join(archived[0], "wal", "00000000"))
+			} else {
+				require.True(t, strings.HasSuffix(archived[0], compressedArchiveExt))
+				require.Equal(t, []string{"instance-1/", "instance-1/wal/", "instance-1/wal/00000000"}, tarballNames(t, archived[0]))
+			}
+		})
+	}
+}
+
+func TestArchiveOptions_Validate(t *testing.T) {
+	tt := []struct {
+		name   string
+		opts   ArchiveOptions
+		expect string
+	}{
+		{name: "default"},
+		{name: "delete", opts: ArchiveOptions{Strategy: CleanupDelete}},
+		{name: "archive", opts: ArchiveOptions{Strategy: CleanupArchive, Directory: "/var/lib/agent/wal-archive"}},
+		{
+			name:   "unknown strategy",
+			opts:   ArchiveOptions{Strategy: "shred"},
+			expect: `unsupported strategy "shred", expected "delete", "archive" or "compress"`,
+		},
+		{
+			name:   "missing directory",
+			opts:   ArchiveOptions{Strategy: CleanupCompress},
+			expect: "an archive directory is required with the compress strategy",
+		},
+		{
+			name:   "inside WAL directory",
+			opts:   ArchiveOptions{Strategy: CleanupArchive, Directory: "/var/lib/agent/wal/archive"},
+			expect: "archive directory must not be inside the WAL directory",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.opts.Validate("/var/lib/agent/wal")
+			if tc.expect == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expect)
+			}
+		})
+	}
+}
+
+func tarballNames(t *testing.T, path string) []string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	return names
 }
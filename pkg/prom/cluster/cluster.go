@@ -70,14 +70,15 @@ func New(
 		return nil, fmt.Errorf("failed to initialize node membership: %w", err)
 	}
 
-	c.store, err = configstore.NewRemote(l, reg, cfg.KVStore, cfg.Enabled)
+	c.store, err = configstore.NewRemote(l, reg, storeConfig(cfg), cfg.Enabled)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize configstore: %w", err)
 	}
 	c.storeAPI = configstore.NewAPI(l, c.store, validate)
+	c.storeAPI.SetAuthorizer(configstore.NewAuthorizer(cfg.ConfigAPI))
 	reg.MustRegister(c.storeAPI)
 
-	c.watcher, err = newConfigWatcher(l, cfg, c.store, im, c.node.Owns, validate)
+	c.watcher, err = newConfigWatcher(l, cfg, c.store, im, c.node.Owns, c.node.Standby, validate)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize configwatcher: %w", err)
 	}
@@ -112,7 +113,7 @@ func (c *Cluster) ApplyConfig(
 		return fmt.Errorf("failed to apply config to node membership: %w", err)
 	}
 
-	if err := c.store.ApplyConfig(cfg.Lifecycler.RingConfig.KVStore, cfg.Enabled); err != nil {
+	if err := c.store.ApplyConfig(storeConfig(cfg), cfg.Enabled); err != nil {
 		return fmt.Errorf("failed to apply config to config store: %w", err)
 	}
 
@@ -120,6 +121,8 @@ func (c *Cluster) ApplyConfig(
 		return fmt.Errorf("failed to apply config to watcher: %w", err)
 	}
 
+	c.storeAPI.SetAuthorizer(configstore.NewAuthorizer(cfg.ConfigAPI))
+
 	c.cfg = cfg
 
 	// Force a refresh so all the configs get updated with new defaults.
@@ -131,7 +134,7 @@ func (c *Cluster) ApplyConfig(
 			ctx, cancel = context.WithTimeout(ctx, c.cfg.ReshardTimeout)
 			defer cancel()
 		}
-		err := c.watcher.Refresh(ctx)
+		err := c.watcher.RefreshAll(ctx)
 		if err != nil {
 			level.Error(c.log).Log("msg", "failed to perform local reshard", "err", err)
 		}
@@ -140,6 +143,18 @@ func (c *Cluster) ApplyConfig(
 	return nil
 }
 
+// ListConfigs returns the names of all configs in the configstore, including
+// configs that are owned by other Agents in the cluster.
+func (c *Cluster) ListConfigs(ctx context.Context) ([]string, error) {
+	return c.store.List(ctx)
+}
+
+// AllConfigs returns all configs in the configstore, including configs that
+// are owned by other Agents in the cluster.
+func (c *Cluster) AllConfigs(ctx context.Context) (<-chan instance.Config, error) {
+	return c.store.All(ctx, nil)
+}
+
 // WireAPI injects routes into the provided mux router for the config
 // management API.
 func (c *Cluster) WireAPI(r *mux.Router) {
@@ -7,6 +7,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/ring"
 	"github.com/cortexproject/cortex/pkg/ring/kv"
 	"github.com/grafana/agent/pkg/prom/cluster/client"
+	"github.com/grafana/agent/pkg/prom/instance/configstore"
 	flagutil "github.com/grafana/agent/pkg/util"
 )
 
@@ -18,9 +19,17 @@ type Config struct {
 	Enabled         bool                  `yaml:"enabled"`
 	ReshardInterval time.Duration         `yaml:"reshard_interval"`
 	ReshardTimeout  time.Duration         `yaml:"reshard_timeout"`
+	DeadNodeTimeout time.Duration         `yaml:"dead_node_timeout"`
+	StandbyPreload  bool                  `yaml:"standby_preload"`
 	KVStore         kv.Config             `yaml:"kvstore"`
 	Lifecycler      ring.LifecyclerConfig `yaml:"lifecycler"`
 
+	// Used when KVStore.Store is configstore.FilesystemStore.
+	KVStoreFilesystem configstore.FilesystemConfig `yaml:"kvstore_filesystem"`
+
+	// Controls who may read and change configs through the config API.
+	ConfigAPI configstore.AuthorizationConfig `yaml:"config_api"`
+
 	// TODO(rfratto): deprecate scraping_service_client in Agent and replace with this.
 	Client client.Config `yaml:"-"`
 }
@@ -50,7 +59,19 @@ func (c *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.BoolVar(&c.Enabled, prefix+"enabled", false, "enables the scraping service mode")
 	f.DurationVar(&c.ReshardInterval, prefix+"reshard-interval", time.Minute*1, "how often to manually reshard")
 	f.DurationVar(&c.ReshardTimeout, prefix+"reshard-timeout", time.Second*30, "timeout for cluster-wide reshards and local reshards. Timeout of 0s disables timeout.")
+	f.DurationVar(&c.DeadNodeTimeout, prefix+"dead-node-timeout", time.Minute*10, "how long an agent may go without heartbeating before it is removed from the ring. 0s disables removing agents.")
+	f.BoolVar(&c.StandbyPreload, prefix+"standby-preload", false, "preload configs this agent is next in line to own so they start immediately when their owner leaves the ring")
 	c.KVStore.RegisterFlagsWithPrefix(prefix+"config-store.", "configurations/", f)
+	c.KVStoreFilesystem.RegisterFlagsWithPrefix(prefix+"config-store.", f)
+	c.ConfigAPI.RegisterFlagsWithPrefix(prefix+"config-api.", f)
 	c.Lifecycler.RegisterFlagsWithPrefix(prefix, f)
 	c.Client.GRPCClientConfig.RegisterFlagsWithPrefix(prefix, f)
 }
+
+// storeConfig returns the config of the configstore.
+func storeConfig(cfg Config) configstore.RemoteConfig {
+	return configstore.RemoteConfig{
+		KVStore:    cfg.KVStore,
+		Filesystem: cfg.KVStoreFilesystem,
+	}
+}
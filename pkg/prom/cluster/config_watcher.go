@@ -13,6 +13,7 @@ import (
 	"github.com/grafana/agent/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
 )
 
 var (
@@ -20,6 +21,31 @@ var (
 		Name: "agent_prometheus_scraping_service_reshard_duration",
 		Help: "How long it took for resharding to run.",
 	}, []string{"success"})
+
+	unownedConfigs = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_prometheus_scraping_service_unowned_configs",
+		Help: "Number of configs that couldn't be assigned to an agent during the most recent reshard.",
+	})
+
+	reassignedConfigs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_prometheus_scraping_service_reassigned_configs_total",
+		Help: "Total number of configs this agent started (gained) or stopped (lost) running during a reshard because their owner changed.",
+	}, []string{"change"})
+
+	rebalanceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "agent_prometheus_scraping_service_rebalance_duration_seconds",
+		Help: "How long it took for a reshard that reassigned configs to start and stop them. Reshards that didn't reassign any configs aren't observed.",
+	})
+
+	standbyConfigs = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_prometheus_scraping_service_standby_configs",
+		Help: "Number of configs preloaded because this agent is next in line to own them.",
+	})
+
+	standbyActivations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_prometheus_scraping_service_standby_activations_total",
+		Help: "Total number of preloaded configs started as soon as this agent became their owner.",
+	})
 )
 
 // configWatcher connects to a configstore and will apply configs to an
@@ -35,11 +61,18 @@ type configWatcher struct {
 	store    configstore.Store
 	im       instance.Manager
 	owns     OwnershipFunc
+	standby  OwnershipFunc
 	validate ValidationFunc
 
 	refreshMut  sync.Mutex
 	instanceMut sync.Mutex
-	instances   map[string]struct{}
+	instances   map[string]instance.Config
+
+	// Configs this agent is next in line to own, preloaded when
+	// standby_preload is enabled. If im implements instance.Preloader, the
+	// instances of standbys and their WALs are created ahead of time so
+	// activating them only starts them. Guarded by instanceMut.
+	standbys map[string]instance.Config
 }
 
 // OwnershipFunc should determine if a given keep is owned by the caller.
@@ -49,8 +82,10 @@ type OwnershipFunc = func(key string) (bool, error)
 type ValidationFunc = func(*instance.Config) error
 
 // newConfigWatcher watches store for changes and checks for each config against
-// owns. It will also poll the configstore at a configurable interval.
-func newConfigWatcher(log log.Logger, cfg Config, store configstore.Store, im instance.Manager, owns OwnershipFunc, validate ValidationFunc) (*configWatcher, error) {
+// owns. It will also poll the configstore at a configurable interval. When
+// standby_preload is enabled, configs that standby reports this agent is next
+// in line for are preloaded; standby may be nil if preloading isn't supported.
+func newConfigWatcher(log log.Logger, cfg Config, store configstore.Store, im instance.Manager, owns, standby OwnershipFunc, validate ValidationFunc) (*configWatcher, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	w := &configWatcher{
@@ -61,9 +96,11 @@ func newConfigWatcher(log log.Logger, cfg Config, store configstore.Store, im in
 		store:    store,
 		im:       im,
 		owns:     owns,
+		standby:  standby,
 		validate: validate,
 
-		instances: make(map[string]struct{}),
+		instances: make(map[string]instance.Config),
+		standbys:  make(map[string]instance.Config),
 	}
 	if err := w.ApplyConfig(cfg); err != nil {
 		return nil, err
@@ -109,10 +146,23 @@ func (w *configWatcher) run(ctx context.Context) {
 }
 
 // Refresh reloads all configs from the configstore. Deleted configs will be
-// removed.
-func (w *configWatcher) Refresh(ctx context.Context) (err error) {
+// removed. Configs that are already running unchanged are left alone, so
+// only configs that changed owners since the last refresh are started or
+// stopped.
+func (w *configWatcher) Refresh(ctx context.Context) error {
+	return w.refresh(ctx, false)
+}
+
+// RefreshAll is like Refresh, but reapplies every owned config even if it's
+// already running unchanged.
+func (w *configWatcher) RefreshAll(ctx context.Context) error {
+	return w.refresh(ctx, true)
+}
+
+func (w *configWatcher) refresh(ctx context.Context, reapply bool) (err error) {
 	w.mut.Lock()
 	enabled := w.cfg.Enabled
+	preload := w.cfg.StandbyPreload && w.standby != nil
 	w.mut.Unlock()
 	if !enabled {
 		level.Debug(w.log).Log("msg", "refresh skipped because clustering is disabled")
@@ -131,13 +181,44 @@ func (w *configWatcher) Refresh(ctx context.Context) (err error) {
 		reshardDuration.WithLabelValues(success).Observe(time.Since(start).Seconds())
 	}()
 
+	// Snapshot the running configs to find the ones that changed owners.
+	w.instanceMut.Lock()
+	previous := make(map[string]instance.Config, len(w.instances))
+	for key, cfg := range w.instances {
+		previous[key] = cfg
+	}
+	w.instanceMut.Unlock()
+
+	// Preloaded configs can be started right away instead of after they're
+	// fetched from the store below.
+	activated := w.activateStandbys()
+
+	// The keep function is called concurrently for each key.
+	var (
+		unowned     atomic.Int64
+		standbyMut  sync.Mutex
+		standbyKeys = make(map[string]struct{})
+	)
 	configs, err := w.store.All(ctx, func(key string) bool {
 		owns, err := w.owns(key)
 		if err != nil {
+			unowned.Inc()
 			level.Error(w.log).Log("msg", "failed to check for ownership, instance will be deleted if it is running", "key", key, "err", err)
+		}
+		if owns || !preload {
+			return owns
+		}
+
+		standby, err := w.standby(key)
+		if err != nil {
+			level.Debug(w.log).Log("msg", "failed to check if config should be preloaded", "key", key, "err", err)
 			return false
+		} else if standby {
+			standbyMut.Lock()
+			standbyKeys[key] = struct{}{}
+			standbyMut.Unlock()
 		}
-		return owns
+		return standby
 	})
 	if err != nil {
 		return fmt.Errorf("failed to get configs from store: %w", err)
@@ -145,6 +226,8 @@ func (w *configWatcher) Refresh(ctx context.Context) (err error) {
 
 	var (
 		keys       = make(map[string]struct{})
+		standbys   = make(map[string]instance.Config)
+		validated  = make(map[string]instance.Config)
 		firstError error
 	)
 
@@ -159,17 +242,53 @@ Outer:
 				break Outer
 			}
 
+			if _, standby := standbyKeys[cfg.Name]; standby {
+				// Like running configs, standbys are kept as they were read from
+				// the store so they can be compared against it once activated.
+				standbyCfg, err := instance.CopyConfig(cfg)
+				if err == nil {
+					err = w.validate(&standbyCfg)
+				}
+				if err != nil {
+					level.Debug(w.log).Log("msg", "not preloading invalid config", "key", cfg.Name, "err", err)
+					continue
+				}
+				standbys[cfg.Name] = cfg
+				validated[cfg.Name] = standbyCfg
+				continue
+			}
+
+			keys[cfg.Name] = struct{}{}
+
+			// Reapplying an unchanged config is a no-op at best and restarts the
+			// instance at worst, so only new or changed configs are applied.
+			prev, running := previous[cfg.Name]
+			if !running {
+				prev, running = activated[cfg.Name]
+			}
+			if !reapply && running && util.CompareYAML(prev, cfg) {
+				continue
+			}
+
 			if err := w.handleEvent(configstore.WatchEvent{Key: cfg.Name, Config: &cfg}); err != nil {
 				level.Error(w.log).Log("msg", "failed to process changed config", "key", cfg.Name, "err", err)
 				if firstError == nil {
 					firstError = err
 				}
 			}
-
-			keys[cfg.Name] = struct{}{}
 		}
 	}
 
+	// All configs have been read, so every call to the keep function is done.
+	unownedConfigs.Set(float64(unowned.Load()))
+
+	w.instanceMut.Lock()
+	previousStandbys := w.standbys
+	w.standbys = standbys
+	w.instanceMut.Unlock()
+	standbyConfigs.Set(float64(len(standbys)))
+	w.preloadStandbys(previousStandbys, standbys, validated)
+
 	// Any config we used to be running that disappeared from this most recent
 	// iteration should be deleted. We hold the lock just for the duration of
 	// populating deleted because handleEvent also grabs a hold on the lock.
@@ -184,15 +303,95 @@ Outer:
 	w.instanceMut.Unlock()
 
 	// Send a deleted event for any key that has gone away.
+	var lost int
 	for _, key := range deleted {
+		// Keys can go away because they moved to another agent or because they
+		// were deleted from the store; only the former is a reassignment.
+		if owned, err := w.owns(key); err == nil && !owned {
+			lost++
+		}
+
 		if err := w.handleEvent(configstore.WatchEvent{Key: key, Config: nil}); err != nil {
 			level.Error(w.log).Log("msg", "failed to process changed config", "key", key, "err", err)
 		}
 	}
 
+	var gained int
+	w.instanceMut.Lock()
+	for key := range w.instances {
+		if _, ok := previous[key]; !ok {
+			gained++
+		}
+	}
+	w.instanceMut.Unlock()
+
+	reassignedConfigs.WithLabelValues("gained").Add(float64(gained))
+	reassignedConfigs.WithLabelValues("lost").Add(float64(lost))
+	if gained > 0 || lost > 0 {
+		rebalanceDuration.Observe(time.Since(start).Seconds())
+		level.Info(w.log).Log("msg", "rebalanced configs", "gained", gained, "lost", lost, "duration", time.Since(start))
+	}
+
 	return firstError
 }
 
+// preloadStandbys creates the instances of new or changed standby configs
+// and discards the instances of configs that aren't standbys anymore.
+// validated holds the standby configs with defaults applied, as they're
+// applied once activated.
+func (w *configWatcher) preloadStandbys(previous, current, validated map[string]instance.Config) {
+	p, ok := w.im.(instance.Preloader)
+	if !ok {
+		return
+	}
+
+	for key := range previous {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		// Configs that started running were already taken by the manager.
+		if err := p.UnloadConfig(key); err != nil {
+			level.Debug(w.log).Log("msg", "failed to unload preloaded config", "key", key, "err", err)
+		}
+	}
+
+	for key, cfg := range current {
+		if prev, ok := previous[key]; ok && util.CompareYAML(prev, cfg) {
+			continue
+		}
+		if err := p.PreloadConfig(validated[key]); err != nil {
+			level.Warn(w.log).Log("msg", "failed to preload config", "key", key, "err", err)
+		}
+	}
+}
+
+// activateStandbys applies the preloaded configs this agent now owns and
+// returns them.
+func (w *configWatcher) activateStandbys() map[string]instance.Config {
+	w.instanceMut.Lock()
+	var owned []instance.Config
+	for key, cfg := range w.standbys {
+		if ok, err := w.owns(key); err == nil && ok {
+			owned = append(owned, cfg)
+			delete(w.standbys, key)
+		}
+	}
+	w.instanceMut.Unlock()
+
+	activated := make(map[string]instance.Config, len(owned))
+	for _, cfg := range owned {
+		cfg := cfg
+		if err := w.handleEvent(configstore.WatchEvent{Key: cfg.Name, Config: &cfg}); err != nil {
+			level.Error(w.log).Log("msg", "failed to activate preloaded config", "key", cfg.Name, "err", err)
+			continue
+		}
+		level.Info(w.log).Log("msg", "activated preloaded config", "key", cfg.Name)
+		activated[cfg.Name] = cfg
+	}
+	standbyActivations.Add(float64(len(activated)))
+	return activated
+}
+
 func (w *configWatcher) handleEvent(ev configstore.WatchEvent) error {
 	w.mut.Lock()
 	defer w.mut.Unlock()
@@ -232,7 +431,14 @@ func (w *configWatcher) handleEvent(ev configstore.WatchEvent) error {
 		}
 
 	case !isDeleted && owned:
-		if err := w.validate(ev.Config); err != nil {
+		// Validation applies defaults to the config it's given. A copy is
+		// validated so the tracked config stays as it was read from the store,
+		// which is what refresh compares against to find changed configs.
+		cfg, err := instance.CopyConfig(*ev.Config)
+		if err != nil {
+			return fmt.Errorf("failed to copy config: %w", err)
+		}
+		if err := w.validate(&cfg); err != nil {
 			return fmt.Errorf(
 				"failed to validate config. %[1]s cannot run until the global settings are adjusted or the config is adjusted to operate within the global constraints. error: %[2]w",
 				ev.Key, err,
@@ -243,10 +449,10 @@ func (w *configWatcher) handleEvent(ev configstore.WatchEvent) error {
 			level.Info(w.log).Log("msg", "tracking new config", "key", ev.Key)
 		}
 
-		if err := w.im.ApplyConfig(*ev.Config); err != nil {
+		if err := w.im.ApplyConfig(cfg); err != nil {
 			return fmt.Errorf("failed to apply config: %w", err)
 		}
-		w.instances[ev.Key] = struct{}{}
+		w.instances[ev.Key] = *ev.Config
 	}
 
 	return nil
@@ -274,7 +480,16 @@ func (w *configWatcher) Stop() error {
 			level.Warn(w.log).Log("msg", "failed deleting config on shutdown", "key", key, "err", err)
 		}
 	}
-	w.instances = make(map[string]struct{})
+	w.instances = make(map[string]instance.Config)
+
+	if p, ok := w.im.(instance.Preloader); ok {
+		for key := range w.standbys {
+			if err := p.UnloadConfig(key); err != nil {
+				level.Warn(w.log).Log("msg", "failed unloading preloaded config on shutdown", "key", key, "err", err)
+			}
+		}
+	}
+	w.standbys = make(map[string]instance.Config)
 
 	return nil
 }
@@ -2,12 +2,18 @@ package cluster
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/grafana/agent/pkg/prom/instance"
 	"github.com/grafana/agent/pkg/prom/instance/configstore"
 	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -31,7 +37,7 @@ func Test_configWatcher_Refresh(t *testing.T) {
 	cfg.Enabled = true
 	cfg.ReshardInterval = time.Hour
 
-	w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate)
+	w, err := newConfigWatcher(log, cfg, &store, &im, owned, nil, validate)
 	require.NoError(t, err)
 	t.Cleanup(func() { _ = w.Stop() })
 
@@ -66,11 +72,263 @@ func Test_configWatcher_Refresh(t *testing.T) {
 
 	// "hello" and "new" should've been applied, and "hello" should've been deleted
 	// from the second refresh.
-	im.AssertCalled(t, "ApplyConfig", instance.Config{Name: "hello"})
-	im.AssertCalled(t, "ApplyConfig", instance.Config{Name: "new"})
+	im.AssertCalled(t, "ApplyConfig", configNamed("hello"))
+	im.AssertCalled(t, "ApplyConfig", configNamed("new"))
 	im.AssertCalled(t, "DeleteConfig", "hello")
 }
 
+func Test_configWatcher_Refresh_Incremental(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+			AllFunc: func(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+				var cfgs []instance.Config
+				for _, name := range []string{"a", "b", "c"} {
+					cfgs = append(cfgs, testStoreConfig(t, name))
+				}
+
+				ch := make(chan instance.Config)
+				go func() {
+					defer close(ch)
+					for _, cfg := range cfgs {
+						if keep(cfg.Name) {
+							ch <- cfg
+						}
+					}
+				}()
+				return ch, nil
+			},
+		}
+
+		im mockConfigManager
+
+		// Validate like the Agent does, which applies defaults to the config.
+		global   = instance.DefaultGlobalConfig
+		validate = func(c *instance.Config) error { return c.ApplyDefaults(&global) }
+
+		ownedKeys = map[string]bool{"a": true, "b": true}
+		owns      = func(key string) (bool, error) { return ownedKeys[key], nil }
+	)
+	cfg.Enabled = true
+	cfg.ReshardInterval = time.Hour
+
+	w, err := newConfigWatcher(log, cfg, &store, &im, owns, nil, validate)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	im.On("ApplyConfig", mock.Anything).Return(nil)
+	im.On("DeleteConfig", mock.Anything).Return(nil)
+
+	require.NoError(t, w.Refresh(context.Background()))
+	im.AssertNumberOfCalls(t, "ApplyConfig", 2)
+
+	// Move "b" to another agent and "c" to this agent. Only "c" should be
+	// started and only "b" should be stopped.
+	ownedKeys = map[string]bool{"a": true, "c": true}
+	require.NoError(t, w.Refresh(context.Background()))
+
+	im.AssertNumberOfCalls(t, "ApplyConfig", 3)
+	im.AssertCalled(t, "ApplyConfig", configNamed("c"))
+	im.AssertNumberOfCalls(t, "DeleteConfig", 1)
+	im.AssertCalled(t, "DeleteConfig", "b")
+
+	// RefreshAll reapplies every owned config.
+	require.NoError(t, w.RefreshAll(context.Background()))
+	im.AssertNumberOfCalls(t, "ApplyConfig", 5)
+}
+
+// configNamed matches a config applied to an instance.Manager by name, since
+// the applied config is a copy that has been validated.
+func configNamed(name string) interface{} {
+	return mock.MatchedBy(func(c instance.Config) bool { return c.Name == name })
+}
+
+// testStoreConfig returns a config the way it's read from a configstore,
+// before any defaults are applied.
+func testStoreConfig(t *testing.T, name string) instance.Config {
+	t.Helper()
+
+	cfg, err := instance.UnmarshalConfig(strings.NewReader(fmt.Sprintf(`
+name: %[1]s
+scrape_configs:
+- job_name: %[1]s
+  static_configs:
+  - targets: ['127.0.0.1:12345']
+remote_write:
+- url: http://localhost:9009/api/prom/push
+`, name)))
+	require.NoError(t, err)
+	return *cfg
+}
+
+func Test_configWatcher_Refresh_StandbyPreload(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+		}
+
+		im mockConfigManager
+
+		global   = instance.DefaultGlobalConfig
+		validate = func(c *instance.Config) error { return c.ApplyDefaults(&global) }
+
+		ownedKeys   = map[string]bool{"a": true}
+		standbyKeys = map[string]bool{"b": true}
+		owns        = func(key string) (bool, error) { return ownedKeys[key], nil }
+		standby     = func(key string) (bool, error) { return standbyKeys[key], nil }
+
+		// Keys fetched from the store during the last refresh.
+		fetched []string
+	)
+	store.AllFunc = func(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+		var cfgs []instance.Config
+		for _, name := range []string{"a", "b", "c"} {
+			cfgs = append(cfgs, testStoreConfig(t, name))
+		}
+
+		fetched = nil
+		ch := make(chan instance.Config)
+		go func() {
+			defer close(ch)
+			for _, cfg := range cfgs {
+				if keep(cfg.Name) {
+					fetched = append(fetched, cfg.Name)
+					ch <- cfg
+				}
+			}
+		}()
+		return ch, nil
+	}
+	cfg.Enabled = true
+	cfg.ReshardInterval = time.Hour
+	cfg.StandbyPreload = true
+
+	w, err := newConfigWatcher(log, cfg, &store, &im, owns, standby, validate)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	im.On("ApplyConfig", mock.Anything).Return(nil)
+	im.On("DeleteConfig", mock.Anything).Return(nil)
+
+	// "b" is fetched and preloaded, but only "a" is started.
+	require.NoError(t, w.Refresh(context.Background()))
+	require.Equal(t, []string{"a", "b"}, fetched)
+	im.AssertNumberOfCalls(t, "ApplyConfig", 1)
+	im.AssertCalled(t, "ApplyConfig", configNamed("a"))
+
+	// Once "b" is owned, it's started from its preloaded config before the
+	// store is read and isn't applied again after.
+	ownedKeys = map[string]bool{"a": true, "b": true}
+	standbyKeys = map[string]bool{}
+	store.AllFunc = func(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+		im.AssertCalled(t, "ApplyConfig", configNamed("b"))
+
+		ch := make(chan instance.Config, 2)
+		ch <- testStoreConfig(t, "a")
+		ch <- testStoreConfig(t, "b")
+		close(ch)
+		return ch, nil
+	}
+	require.NoError(t, w.Refresh(context.Background()))
+	im.AssertNumberOfCalls(t, "ApplyConfig", 2)
+	im.AssertNotCalled(t, "DeleteConfig", mock.Anything)
+}
+
+// Test_configWatcher_Refresh_StandbyPreload_Storage ensures that the storage
+// of standby configs is created when they're preloaded, so activating them
+// doesn't create a new WAL.
+func Test_configWatcher_Refresh_StandbyPreload_Storage(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+			AllFunc: func(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+				var cfgs []instance.Config
+				for _, name := range []string{"a", "b"} {
+					cfgs = append(cfgs, testStoreConfig(t, name))
+				}
+
+				ch := make(chan instance.Config)
+				go func() {
+					defer close(ch)
+					for _, cfg := range cfgs {
+						if keep(cfg.Name) {
+							ch <- cfg
+						}
+					}
+				}()
+				return ch, nil
+			},
+		}
+
+		global   = instance.DefaultGlobalConfig
+		validate = func(c *instance.Config) error { return c.ApplyDefaults(&global) }
+
+		ownedKeys   = map[string]bool{"a": true}
+		standbyKeys = map[string]bool{"b": true}
+		owns        = func(key string) (bool, error) { return ownedKeys[key], nil }
+		standby     = func(key string) (bool, error) { return standbyKeys[key], nil }
+
+		instancesMut sync.Mutex
+		instances    = make(map[string][]*storageInstance)
+	)
+	cfg.Enabled = true
+	cfg.ReshardInterval = time.Hour
+	cfg.StandbyPreload = true
+
+	im := instance.NewBasicManager(instance.DefaultBasicManagerConfig, log, func(c instance.Config) (instance.ManagedInstance, error) {
+		instancesMut.Lock()
+		defer instancesMut.Unlock()
+
+		inst := &storageInstance{}
+		instances[c.Name] = append(instances[c.Name], inst)
+		return inst, nil
+	})
+	t.Cleanup(im.Stop)
+
+	w, err := newConfigWatcher(log, cfg, &store, im, owns, standby, validate)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	getInstances := func(name string) []*storageInstance {
+		instancesMut.Lock()
+		defer instancesMut.Unlock()
+		return instances[name]
+	}
+
+	// "b" is preloaded: its instance and storage exist but it isn't running.
+	require.NoError(t, w.Refresh(context.Background()))
+	require.Len(t, getInstances("b"), 1)
+	require.Equal(t, 1, getInstances("b")[0].StorageCreated())
+	require.NotContains(t, im.ListInstances(), "b")
+
+	// Activating "b" runs the preloaded instance with its existing storage.
+	ownedKeys = map[string]bool{"a": true, "b": true}
+	standbyKeys = map[string]bool{}
+	require.NoError(t, w.Refresh(context.Background()))
+	require.Contains(t, im.ListInstances(), "b")
+
+	test.Poll(t, 5*time.Second, true, func() interface{} {
+		return getInstances("b")[0].Running()
+	})
+	require.Len(t, getInstances("b"), 1)
+	require.Equal(t, 1, getInstances("b")[0].StorageCreated())
+}
+
 func Test_configWatcher_handleEvent(t *testing.T) {
 	var (
 		cfg   = DefaultConfig
@@ -93,7 +351,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			im  mockConfigManager
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, owned, nil, validate)
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -112,7 +370,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			im  mockConfigManager
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, owned, nil, validate)
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -135,7 +393,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			im  mockConfigManager
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, unowned, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, unowned, nil, validate)
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -159,7 +417,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			owns    = func(key string) (bool, error) { return isOwned, nil }
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, owns, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, owns, nil, validate)
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -187,7 +445,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			im mockConfigManager
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, owned, nil, validate)
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -215,6 +473,12 @@ func (m *mockConfigManager) ListInstances() map[string]instance.ManagedInstance
 	return args.Get(0).(map[string]instance.ManagedInstance)
 }
 
+// GetInstance implements Manager.
+func (m *mockConfigManager) GetInstance(name string) (instance.ManagedInstance, error) {
+	args := m.Mock.Called(name)
+	return args.Get(0).(instance.ManagedInstance), args.Error(1)
+}
+
 // ListConfigs implements Manager.
 func (m *mockConfigManager) ListConfigs() map[string]instance.Config {
 	args := m.Mock.Called()
@@ -237,3 +501,60 @@ func (m *mockConfigManager) DeleteConfig(name string) error {
 func (m *mockConfigManager) Stop() {
 	m.Mock.Called()
 }
+
+// storageInstance is an instance.ManagedInstance that records how many times
+// it created its storage, either when it was preloaded or when it was run.
+type storageInstance struct {
+	mut       sync.Mutex
+	preloaded bool
+	running   bool
+	created   int
+}
+
+func (i *storageInstance) Preload() error {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	if !i.preloaded {
+		i.preloaded = true
+		i.created++
+	}
+	return nil
+}
+
+func (i *storageInstance) Unload() error {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.preloaded = false
+	return nil
+}
+
+func (i *storageInstance) Run(ctx context.Context) error {
+	i.mut.Lock()
+	if !i.preloaded {
+		i.created++
+	}
+	i.preloaded = false
+	i.running = true
+	i.mut.Unlock()
+
+	<-ctx.Done()
+	return nil
+}
+
+func (i *storageInstance) StorageCreated() int {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.created
+}
+
+func (i *storageInstance) Running() bool {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.running
+}
+
+func (i *storageInstance) Update(c instance.Config) error              { return nil }
+func (i *storageInstance) TargetsActive() map[string][]*scrape.Target  { return nil }
+func (i *storageInstance) TargetsDropped() map[string][]*scrape.Target { return nil }
+func (i *storageInstance) StorageDirectory() string                    { return "" }
+func (i *storageInstance) Appender(_ context.Context) storage.Appender { return nil }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"hash/fnv"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 	"github.com/grafana/agent/pkg/prom/cluster/client"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/weaveworks/common/user"
 )
 
@@ -27,6 +29,11 @@ const (
 	agentKey = "agent"
 )
 
+var forgottenNodes = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "agent_prometheus_scraping_service_forgotten_nodes_total",
+	Help: "Total number of agents removed from the ring after not heartbeating within the dead node timeout.",
+})
+
 var backoffConfig = cortex_util.BackoffConfig{
 	MinBackoff: time.Second,
 	MaxBackoff: 2 * time.Minute,
@@ -48,6 +55,7 @@ type node struct {
 
 	exited bool
 	reload chan struct{}
+	done   chan struct{}
 }
 
 // newNode creates a new node and registers it to the ring.
@@ -58,11 +66,13 @@ func newNode(reg prometheus.Registerer, log log.Logger, cfg Config, s pb.Scrapin
 		log: log,
 
 		reload: make(chan struct{}, 1),
+		done:   make(chan struct{}),
 	}
 	if err := n.ApplyConfig(cfg); err != nil {
 		return nil, err
 	}
 	go n.run()
+	go n.runForget()
 	return n, nil
 }
 
@@ -170,6 +180,72 @@ func (n *node) run() {
 	level.Info(n.log).Log("msg", "node run loop exiting")
 }
 
+// runForget periodically removes dead agents from the ring until the node is
+// stopped.
+func (n *node) runForget() {
+	for {
+		n.mut.RLock()
+		interval := n.cfg.ReshardInterval
+		n.mut.RUnlock()
+
+		select {
+		case <-n.done:
+			return
+		case <-time.After(interval):
+			if err := n.forgetDeadNodes(context.Background()); err != nil {
+				level.Warn(n.log).Log("msg", "failed to remove dead agents from the ring", "err", err)
+			}
+		}
+	}
+}
+
+// forgetDeadNodes removes agents from the ring that haven't heartbeated
+// within the dead node timeout. Configs owned by a dead agent can't be
+// assigned to any other agent until it is removed, so a cluster reshard is
+// performed after removing any agents.
+func (n *node) forgetDeadNodes(ctx context.Context) error {
+	n.mut.RLock()
+	defer n.mut.RUnlock()
+
+	if n.ring == nil || n.lc == nil || n.cfg.DeadNodeTimeout <= 0 {
+		return nil
+	}
+
+	var forgotten []string
+	err := n.ring.KVClient.CAS(ctx, agentKey, func(in interface{}) (out interface{}, retry bool, err error) {
+		forgotten = nil
+		if in == nil {
+			return nil, false, nil
+		}
+
+		desc := in.(*ring.Desc)
+		for id, ing := range desc.Ingesters {
+			// Never remove ourselves; our own heartbeat will fix our entry.
+			if id == n.lc.ID {
+				continue
+			}
+			if time.Since(time.Unix(ing.Timestamp, 0)) > n.cfg.DeadNodeTimeout {
+				desc.RemoveIngester(id)
+				forgotten = append(forgotten, id)
+			}
+		}
+		if len(forgotten) == 0 {
+			return nil, false, nil
+		}
+		return desc, true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(forgotten) == 0 {
+		return nil
+	}
+
+	level.Warn(n.log).Log("msg", "removed dead agents from the ring", "agents", strings.Join(forgotten, ","))
+	forgottenNodes.Add(float64(len(forgotten)))
+	return n.performClusterReshard(ctx, true)
+}
+
 // performClusterReshard informs the cluster to immediately trigger a reshard
 // of their workloads. if joining is true, the server provided to newNode will
 // also be informed.
@@ -341,6 +417,7 @@ func (n *node) Stop() error {
 	}
 
 	close(n.reload)
+	close(n.done)
 	level.Info(n.log).Log("msg", "node shut down")
 	return firstError
 }
@@ -373,6 +450,52 @@ func (n *node) Owns(key string) (bool, error) {
 	return false, nil
 }
 
+// Standby checks to see if this node is next in line to own a key: the
+// node that would own the key if its current owner left the ring. Returns
+// false if this node already owns the key.
+func (n *node) Standby(key string) (bool, error) {
+	n.mut.RLock()
+	defer n.mut.RUnlock()
+
+	healthy, err := n.ring.GetAllHealthy(ring.Read)
+	if err != nil {
+		return false, err
+	}
+
+	// The owner may be unhealthy, in which case the key is unowned until the
+	// owner is removed from the ring and the next healthy node in line takes
+	// over.
+	var owner string
+	if rs, err := n.ring.Get(keyHash(key), ring.Write, nil, nil, nil); err == nil && len(rs.Ingesters) > 0 {
+		owner = rs.Ingesters[0].Addr
+	}
+
+	return nextInLine(healthy.Ingesters, keyHash(key), owner) == n.lc.Addr, nil
+}
+
+// nextInLine returns the address of the first instance clockwise from hash
+// in the ring that isn't exclude. Like the ring, a token owns the hashes
+// lower than it.
+func nextInLine(instances []ring.InstanceDesc, hash uint32, exclude string) string {
+	var (
+		next     string
+		nextDist uint32
+	)
+	for _, inst := range instances {
+		if inst.Addr == exclude {
+			continue
+		}
+		for _, token := range inst.Tokens {
+			// Unsigned arithmetic wraps around the ring.
+			dist := token - hash - 1
+			if next == "" || dist < nextDist {
+				next, nextDist = inst.Addr, dist
+			}
+		}
+	}
+	return next
+}
+
 func keyHash(key string) uint32 {
 	h := fnv.New32()
 	_, _ = h.Write([]byte(key))
@@ -150,6 +150,55 @@ func Test_node_ApplyConfig(t *testing.T) {
 	waitAll(t, localReshard)
 }
 
+func Test_node_forgetDeadNodes(t *testing.T) {
+	var (
+		reg    = prometheus.NewRegistry()
+		logger = util.TestLogger(t)
+
+		localReshard = make(chan struct{}, 10)
+	)
+
+	local := &agentproto.FuncScrapingServiceServer{
+		ReshardFunc: func(c context.Context, rr *agentproto.ReshardRequest) (*empty.Empty, error) {
+			localReshard <- struct{}{}
+			return &empty.Empty{}, nil
+		},
+	}
+
+	nodeConfig := DefaultConfig
+	nodeConfig.Enabled = true
+	nodeConfig.DeadNodeTimeout = time.Minute
+	nodeConfig.Lifecycler = testLifecyclerConfig(t)
+
+	n, err := newNode(reg, logger, nodeConfig, local)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = n.Stop() })
+	require.NoError(t, n.WaitJoined(context.Background()))
+	waitAll(t, localReshard)
+
+	// Add an agent that stopped heartbeating long ago.
+	err = n.ring.KVClient.CAS(context.Background(), agentKey, func(in interface{}) (interface{}, bool, error) {
+		desc := in.(*ring.Desc)
+		desc.AddIngester("dead", "x.x.x.x:-1", "", []uint32{1}, ring.ACTIVE, time.Now())
+		ing := desc.Ingesters["dead"]
+		ing.Timestamp = time.Now().Add(-time.Hour).Unix()
+		desc.Ingesters["dead"] = ing
+		return desc, true, nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, n.forgetDeadNodes(context.Background()))
+
+	v, err := n.ring.KVClient.Get(context.Background(), agentKey)
+	require.NoError(t, err)
+	desc := v.(*ring.Desc)
+	require.NotContains(t, desc.Ingesters, "dead")
+	require.Contains(t, desc.Ingesters, n.lc.ID)
+
+	// Removing the dead agent should cause a reshard.
+	waitAll(t, localReshard)
+}
+
 // startNode launches srv as a gRPC server and registers it to the ring.
 func startNode(t *testing.T, srv agentproto.ScrapingServiceServer) {
 	t.Helper()
@@ -220,3 +269,28 @@ min_ready_duration: 0s
 
 	return lc
 }
+
+func Test_nextInLine(t *testing.T) {
+	instances := []ring.InstanceDesc{
+		{Addr: "a", Tokens: []uint32{100, 400}},
+		{Addr: "b", Tokens: []uint32{200}},
+		{Addr: "c", Tokens: []uint32{300}},
+	}
+
+	tt := []struct {
+		hash    uint32
+		exclude string
+		expect  string
+	}{
+		{hash: 50, expect: "a"},
+		{hash: 50, exclude: "a", expect: "b"},
+		{hash: 100, expect: "b"},
+		{hash: 250, exclude: "c", expect: "a"},
+		// Hashes past the last token wrap around to the first token.
+		{hash: 450, expect: "a"},
+		{hash: 450, exclude: "a", expect: "b"},
+	}
+	for _, tc := range tt {
+		require.Equal(t, tc.expect, nextInLine(instances, tc.hash, tc.exclude), "hash %d, exclude %q", tc.hash, tc.exclude)
+	}
+}
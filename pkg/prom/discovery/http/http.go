@@ -0,0 +1,187 @@
+// Package http implements HTTP service discovery, which periodically polls a
+// URL for a list of target groups. Importing the package registers
+// http_sd_configs for use in scrape_configs.
+//
+// Target groups use the same JSON format as file_sd_configs:
+//
+//	[{"targets": ["host:port"], "labels": {"name": "value"}}]
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/build"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/refresh"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// urlLabel is the meta label holding the URL target groups were read from.
+const urlLabel = model.MetaLabelPrefix + "url"
+
+var (
+	// DefaultSDConfig is the default HTTP SD configuration.
+	DefaultSDConfig = SDConfig{
+		RefreshInterval:  model.Duration(60 * time.Second),
+		HTTPClientConfig: config_util.DefaultHTTPClientConfig,
+	}
+
+	matchContentType = regexp.MustCompile(`^(?i:application\/json(;\s*charset=("utf-8"|utf-8))?)$`)
+)
+
+func init() {
+	discovery.RegisterConfig(&SDConfig{})
+}
+
+// SDConfig is the configuration for HTTP service discovery.
+type SDConfig struct {
+	HTTPClientConfig config_util.HTTPClientConfig `yaml:",inline"`
+	RefreshInterval  model.Duration               `yaml:"refresh_interval,omitempty"`
+	URL              string                       `yaml:"url"`
+}
+
+// Name returns the name of the Config.
+func (*SDConfig) Name() string { return "http" }
+
+// NewDiscoverer returns a Discoverer for the Config.
+func (c *SDConfig) NewDiscoverer(opts discovery.DiscovererOptions) (discovery.Discoverer, error) {
+	return NewDiscovery(c, opts.Logger)
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (c *SDConfig) SetDirectory(dir string) {
+	c.HTTPClientConfig.SetDirectory(dir)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultSDConfig
+
+	type plain SDConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if c.URL == "" {
+		return errors.New("http_sd_config url is missing")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid http_sd_config url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("http_sd_config url scheme must be http or https")
+	}
+	if u.Host == "" {
+		return errors.New("http_sd_config url is missing a host")
+	}
+	if c.RefreshInterval <= 0 {
+		return errors.New("http_sd_config refresh_interval must be greater than 0s")
+	}
+	return c.HTTPClientConfig.Validate()
+}
+
+// Discovery periodically requests target groups from a URL.
+type Discovery struct {
+	*refresh.Discovery
+
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+
+	// lastLength is the number of groups returned by the last refresh, used
+	// to clear groups that disappeared.
+	lastLength int
+}
+
+// NewDiscovery creates a new Discovery.
+func NewDiscovery(cfg *SDConfig, logger log.Logger) (*Discovery, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	client, err := config_util.NewClientFromConfig(cfg.HTTPClientConfig, "http_sd", false, false)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = time.Duration(cfg.RefreshInterval)
+
+	d := &Discovery{
+		url:             cfg.URL,
+		client:          client,
+		refreshInterval: time.Duration(cfg.RefreshInterval),
+	}
+	d.Discovery = refresh.NewDiscovery(logger, "http", time.Duration(cfg.RefreshInterval), d.refresh)
+	return d, nil
+}
+
+func (d *Discovery) refresh(ctx context.Context) ([]*targetgroup.Group, error) {
+	req, err := http.NewRequest(http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", fmt.Sprintf("GrafanaAgent/%s", build.Version))
+	req.Header.Set("Accept", "application/json")
+	// Same header as Prometheus so existing HTTP SD servers can use it.
+	req.Header.Set("X-Prometheus-Refresh-Interval-Seconds", fmt.Sprintf("%f", d.refreshInterval.Seconds()))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+	if ct := strings.TrimSpace(resp.Header.Get("Content-Type")); !matchContentType.MatchString(ct) {
+		return nil, fmt.Errorf("unsupported content type %q", ct)
+	}
+
+	var tgs []*targetgroup.Group
+	if err := json.NewDecoder(resp.Body).Decode(&tgs); err != nil {
+		return nil, fmt.Errorf("failed to decode target groups: %w", err)
+	}
+
+	for i, tg := range tgs {
+		if tg == nil {
+			return nil, errors.New("response contains a null target group")
+		}
+		tg.Source = source(d.url, i)
+		if tg.Labels == nil {
+			tg.Labels = model.LabelSet{}
+		}
+		tg.Labels[urlLabel] = model.LabelValue(d.url)
+	}
+
+	// Send empty groups for groups that were previously returned so their
+	// targets are removed.
+	n := len(tgs)
+	for i := n; i < d.lastLength; i++ {
+		tgs = append(tgs, &targetgroup.Group{Source: source(d.url, i)})
+	}
+	d.lastLength = n
+
+	return tgs, nil
+}
+
+func source(url string, i int) string {
+	return fmt.Sprintf("%s:%d", url, i)
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSDConfig_Unmarshal(t *testing.T) {
+	tt := []struct {
+		name string
+		cfg  string
+		err  string
+	}{
+		{name: "valid", cfg: "url: http://example.com/targets"},
+		{name: "missing url", cfg: "refresh_interval: 1m", err: "http_sd_config url is missing"},
+		{name: "invalid scheme", cfg: "url: ftp://example.com", err: "http_sd_config url scheme must be http or https"},
+		{name: "missing host", cfg: "url: http:///targets", err: "http_sd_config url is missing a host"},
+		{name: "invalid refresh", cfg: "url: http://example.com\nrefresh_interval: 0s", err: "http_sd_config refresh_interval must be greater than 0s"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg SDConfig
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &cfg)
+			if tc.err == "" {
+				require.NoError(t, err)
+				require.Equal(t, DefaultSDConfig.RefreshInterval, cfg.RefreshInterval)
+				return
+			}
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	var resp string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "60.000000", r.Header.Get("X-Prometheus-Refresh-Interval-Seconds"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, resp)
+	}))
+	defer srv.Close()
+
+	cfg := DefaultSDConfig
+	cfg.URL = srv.URL
+	d, err := NewDiscovery(&cfg, log.NewNopLogger())
+	require.NoError(t, err)
+
+	resp = `[
+		{"targets": ["a:80", "b:80"], "labels": {"env": "prod"}},
+		{"targets": ["c:80"]}
+	]`
+	tgs, err := d.refresh(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []*targetgroup.Group{
+		{
+			Source:  srv.URL + ":0",
+			Targets: []model.LabelSet{{model.AddressLabel: "a:80"}, {model.AddressLabel: "b:80"}},
+			Labels:  model.LabelSet{"env": "prod", urlLabel: model.LabelValue(srv.URL)},
+		},
+		{
+			Source:  srv.URL + ":1",
+			Targets: []model.LabelSet{{model.AddressLabel: "c:80"}},
+			Labels:  model.LabelSet{urlLabel: model.LabelValue(srv.URL)},
+		},
+	}, tgs)
+
+	// Groups that are no longer returned are cleared.
+	resp = `[{"targets": ["a:80"]}]`
+	tgs, err = d.refresh(context.Background())
+	require.NoError(t, err)
+	require.Len(t, tgs, 2)
+	require.Equal(t, &targetgroup.Group{Source: srv.URL + ":1"}, tgs[1])
+}
+
+func TestDiscovery_Errors(t *testing.T) {
+	tt := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		err         string
+	}{
+		{name: "bad status", status: http.StatusInternalServerError, contentType: "application/json", err: "server returned HTTP status 500 Internal Server Error"},
+		{name: "bad content type", status: http.StatusOK, contentType: "text/plain", err: `unsupported content type "text/plain"`},
+		{name: "null group", status: http.StatusOK, contentType: "application/json", body: "[null]", err: "response contains a null target group"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			}))
+			defer srv.Close()
+
+			cfg := DefaultSDConfig
+			cfg.URL = srv.URL
+			cfg.RefreshInterval = model.Duration(time.Second)
+			d, err := NewDiscovery(&cfg, nil)
+			require.NoError(t, err)
+
+			_, err = d.refresh(context.Background())
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
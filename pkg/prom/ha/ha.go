@@ -0,0 +1,348 @@
+// Package ha implements HA pairs of agents. Agents in a pair scrape the same
+// targets, but only the leader of the pair sends samples to remote_write.
+// The leader is elected with a lease held in a KV store.
+package ha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultConfig holds default settings for HA pairs.
+var DefaultConfig = *util.DefaultConfigFromFlags(&Config{}).(*Config)
+
+// Config configures the HA pair an agent is part of.
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+
+	// ReplicaID identifies the agent within the pair. Defaults to the
+	// hostname of the agent.
+	ReplicaID string `yaml:"replica_id"`
+
+	// Key is the key of the lease in the KV store. Each pair must use a
+	// different key.
+	Key string `yaml:"key"`
+
+	// HeartbeatPeriod is how often the lease is renewed or checked.
+	// FailoverTimeout is how long a lease must go unrenewed before another
+	// agent takes over.
+	HeartbeatPeriod time.Duration `yaml:"heartbeat_period"`
+	FailoverTimeout time.Duration `yaml:"failover_timeout"`
+
+	KVStore kv.Config `yaml:"kvstore"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given
+// FlagSet with a specified prefix.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&c.Enabled, prefix+"enabled", false, "only send samples to remote_write while this agent is the leader of its HA pair")
+	f.StringVar(&c.ReplicaID, prefix+"replica-id", "", "ID of this agent within its HA pair. Defaults to the hostname")
+	f.StringVar(&c.Key, prefix+"key", "leader", "key of the HA pair's lease in the KV store. Each pair must use a different key")
+	f.DurationVar(&c.HeartbeatPeriod, prefix+"heartbeat-period", 5*time.Second, "how often the HA pair's lease is renewed or checked")
+	f.DurationVar(&c.FailoverTimeout, prefix+"failover-timeout", 30*time.Second, "how long the HA pair's lease may go unrenewed before another agent takes over")
+	c.KVStore.RegisterFlagsWithPrefix(prefix, "ha/", f)
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", f)
+}
+
+// Validate validates the config.
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	switch {
+	case c.Key == "":
+		return errors.New("key must not be empty")
+	case c.HeartbeatPeriod <= 0:
+		return errors.New("heartbeat_period must be greater than 0s")
+	case c.FailoverTimeout <= c.HeartbeatPeriod:
+		return errors.New("failover_timeout must be greater than heartbeat_period")
+	}
+	return nil
+}
+
+// lease is the value stored in the KV store.
+type lease struct {
+	Leader  string    `json:"leader"`
+	Renewed time.Time `json:"renewed"`
+}
+
+// Elector elects the leader of an HA pair and opens a RemoteWriteGate while
+// this agent is the leader.
+type Elector struct {
+	log  log.Logger
+	reg  *util.Unregisterer
+	gate *instance.RemoteWriteGate
+
+	leader  prometheus.Gauge
+	changes prometheus.Counter
+
+	// applyMut serializes ApplyConfig and Stop. It's held while waiting for
+	// the election to stop, so the election itself only uses mut.
+	applyMut sync.Mutex
+	cfg      Config
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mut      sync.Mutex
+	isLeader bool
+}
+
+// New creates a new Elector. The Elector doesn't run until ApplyConfig is
+// called with an enabled config; until then, gate is left open.
+func New(l log.Logger, reg prometheus.Registerer, gate *instance.RemoteWriteGate) *Elector {
+	return &Elector{
+		log:  log.With(l, "component", "ha"),
+		reg:  util.WrapWithUnregisterer(reg),
+		gate: gate,
+
+		leader: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "agent_prometheus_ha_leader",
+			Help: "Whether this agent is the leader of its HA pair and sends samples to remote_write.",
+		}),
+		changes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_ha_leader_changes_total",
+			Help: "Number of times this agent became or stopped being the leader of its HA pair.",
+		}),
+
+		isLeader: true,
+	}
+}
+
+// ApplyConfig applies a new config, restarting the election if it changed.
+// When cfg isn't enabled, the gate is opened.
+func (e *Elector) ApplyConfig(cfg Config) error {
+	e.applyMut.Lock()
+	defer e.applyMut.Unlock()
+
+	if e.cancel != nil && util.CompareYAML(e.cfg, cfg) {
+		return nil
+	}
+	e.stopLocked()
+	e.cfg = cfg
+
+	// Unregister all metrics that the previous kv may have registered.
+	e.reg.UnregisterAll()
+
+	if !cfg.Enabled {
+		e.setLeader(false, true)
+		return nil
+	}
+
+	id := cfg.ReplicaID
+	if id == "" {
+		var err error
+		id, err = instance.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname for replica_id: %w", err)
+		}
+	}
+
+	client, err := kv.NewClient(cfg.KVStore, codec.String{}, kv.RegistererWithKVName(e.reg, "agent_ha"))
+	if err != nil {
+		return fmt.Errorf("failed to create kv client: %w", err)
+	}
+
+	// Samples aren't sent until the lease is acquired, so a restarted agent
+	// doesn't duplicate samples of the current leader.
+	e.setLeader(true, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	go e.run(ctx, e.done, client, id, cfg)
+	return nil
+}
+
+// Stop stops the election. The lease is released if this agent holds it, so
+// the other agent of the pair can take over without waiting for
+// failover_timeout.
+func (e *Elector) Stop() {
+	e.applyMut.Lock()
+	defer e.applyMut.Unlock()
+	e.stopLocked()
+}
+
+func (e *Elector) stopLocked() {
+	if e.cancel == nil {
+		return
+	}
+	e.cancel()
+	<-e.done
+	e.cancel, e.done = nil, nil
+}
+
+// IsLeader returns true if this agent is the leader of its HA pair. Always
+// true when HA pairs aren't enabled.
+func (e *Elector) IsLeader() bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.isLeader
+}
+
+// setLeader records whether this agent is the leader and opens or closes the
+// gate accordingly. enabled is whether HA pairs are enabled.
+func (e *Elector) setLeader(enabled, leader bool) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if leader {
+		e.leader.Set(1)
+	} else {
+		e.leader.Set(0)
+	}
+	e.gate.SetOpen(leader)
+
+	if e.isLeader == leader {
+		return
+	}
+	e.isLeader = leader
+	if enabled {
+		e.changes.Inc()
+		if leader {
+			level.Info(e.log).Log("msg", "became leader of HA pair, sending samples to remote_write")
+		} else {
+			level.Info(e.log).Log("msg", "not the leader of HA pair, stopped sending samples to remote_write")
+		}
+	}
+}
+
+func (e *Elector) run(ctx context.Context, done chan struct{}, client kv.Client, id string, cfg Config) {
+	defer close(done)
+
+	c := &campaign{client: client, id: id, cfg: cfg}
+
+	ticker := time.NewTicker(cfg.HeartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		leader, err := c.heartbeat(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			level.Warn(e.log).Log("msg", "failed to check HA pair lease", "err", err)
+		}
+		if ctx.Err() == nil {
+			e.setLeader(true, leader)
+		}
+
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), cfg.HeartbeatPeriod)
+			if err := c.release(releaseCtx); err != nil {
+				level.Warn(e.log).Log("msg", "failed to release HA pair lease", "err", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign holds the state of a single agent competing for the lease.
+type campaign struct {
+	client kv.Client
+	id     string
+	cfg    Config
+
+	// Leases are considered expired once they haven't changed for
+	// failover_timeout according to the local clock, so clock skew between
+	// the agents of a pair doesn't affect failover.
+	lastSeen   string
+	lastSeenAt time.Time
+
+	// renewedAt is when this agent last renewed its lease.
+	renewedAt time.Time
+}
+
+// heartbeat renews or acquires the lease if possible and returns true if
+// this agent holds it.
+func (c *campaign) heartbeat(ctx context.Context, now time.Time) (bool, error) {
+	var acquired bool
+	err := c.client.CAS(ctx, c.cfg.Key, func(in interface{}) (out interface{}, retry bool, err error) {
+		acquired = false
+
+		raw, _ := in.(string)
+		if raw != c.lastSeen || c.lastSeenAt.IsZero() {
+			c.lastSeen, c.lastSeenAt = raw, now
+		}
+
+		var cur lease
+		if raw != "" {
+			if err := json.Unmarshal([]byte(raw), &cur); err != nil {
+				// Overwrite leases that can't be read rather than never
+				// electing a leader.
+				cur = lease{}
+			}
+		}
+
+		expired := now.Sub(c.lastSeenAt) >= c.cfg.FailoverTimeout
+		if cur.Leader != "" && cur.Leader != c.id && !expired {
+			return nil, false, nil
+		}
+
+		bb, err := json.Marshal(lease{Leader: c.id, Renewed: now})
+		if err != nil {
+			return nil, false, err
+		}
+		acquired = true
+		return string(bb), true, nil
+	})
+	if err != nil {
+		// Keep leading until the lease would expire for the other agent,
+		// since the KV store may be unavailable to it too.
+		held := !c.renewedAt.IsZero() && now.Sub(c.renewedAt) < c.cfg.FailoverTimeout
+		return held, err
+	}
+
+	if acquired {
+		c.renewedAt = now
+	} else {
+		c.renewedAt = time.Time{}
+	}
+	return acquired, nil
+}
+
+// release gives up the lease if this agent holds it.
+func (c *campaign) release(ctx context.Context) error {
+	if c.renewedAt.IsZero() {
+		return nil
+	}
+
+	return c.client.CAS(ctx, c.cfg.Key, func(in interface{}) (out interface{}, retry bool, err error) {
+		raw, _ := in.(string)
+		var cur lease
+		if err := json.Unmarshal([]byte(raw), &cur); err != nil || cur.Leader != c.id {
+			return nil, false, nil
+		}
+		bb, err := json.Marshal(lease{})
+		if err != nil {
+			return nil, false, err
+		}
+		return string(bb), true, nil
+	})
+}
@@ -0,0 +1,136 @@
+package ha
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/util/test"
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() Config {
+	cfg := DefaultConfig
+	cfg.Enabled = true
+	cfg.HeartbeatPeriod = 10 * time.Millisecond
+	cfg.FailoverTimeout = 100 * time.Millisecond
+	cfg.KVStore.Mock = consul.NewInMemoryClient(codec.String{})
+	return cfg
+}
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := testConfig()
+	require.NoError(t, cfg.Validate())
+
+	cfg.FailoverTimeout = cfg.HeartbeatPeriod
+	require.EqualError(t, cfg.Validate(), "failover_timeout must be greater than heartbeat_period")
+
+	cfg.Enabled = false
+	require.NoError(t, cfg.Validate(), "disabled configs should not be validated")
+}
+
+func TestCampaign(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		cfg    = testConfig()
+		client = cfg.KVStore.Mock
+		now    = time.Now()
+
+		a = &campaign{client: client, id: "a", cfg: cfg}
+		b = &campaign{client: client, id: "b", cfg: cfg}
+	)
+
+	leader, err := a.heartbeat(ctx, now)
+	require.NoError(t, err)
+	require.True(t, leader, "first agent should acquire the lease")
+
+	leader, err = b.heartbeat(ctx, now)
+	require.NoError(t, err)
+	require.False(t, leader)
+
+	// a keeps renewing its lease, so b never takes over.
+	for i := 1; i <= 5; i++ {
+		now = now.Add(cfg.FailoverTimeout / 2)
+		leader, err = a.heartbeat(ctx, now)
+		require.NoError(t, err)
+		require.True(t, leader)
+
+		leader, err = b.heartbeat(ctx, now)
+		require.NoError(t, err)
+		require.False(t, leader)
+	}
+
+	// b takes over once a stops renewing for failover_timeout.
+	now = now.Add(cfg.FailoverTimeout)
+	leader, err = b.heartbeat(ctx, now)
+	require.NoError(t, err)
+	require.True(t, leader)
+
+	leader, err = a.heartbeat(ctx, now)
+	require.NoError(t, err)
+	require.False(t, leader, "a should notice it lost the lease")
+
+	// Releasing the lease lets a take over right away.
+	require.NoError(t, b.release(ctx))
+	leader, err = a.heartbeat(ctx, now)
+	require.NoError(t, err)
+	require.True(t, leader)
+}
+
+func TestElector(t *testing.T) {
+	cfg := testConfig()
+	cfg.ReplicaID = "a"
+
+	gate := instance.NewRemoteWriteGate(true)
+	e := New(log.NewNopLogger(), prometheus.NewRegistry(), gate)
+
+	require.NoError(t, e.ApplyConfig(cfg))
+	test.Poll(t, time.Second, true, func() interface{} {
+		return gate.Open() && e.IsLeader()
+	})
+
+	// Stopping releases the lease.
+	e.Stop()
+	val, err := cfg.KVStore.Mock.Get(context.Background(), cfg.Key)
+	require.NoError(t, err)
+	var l lease
+	require.NoError(t, json.Unmarshal([]byte(val.(string)), &l))
+	require.Equal(t, "", l.Leader)
+
+	// Disabling HA pairs opens the gate.
+	gate.SetOpen(false)
+	cfg.Enabled = false
+	require.NoError(t, e.ApplyConfig(cfg))
+	require.True(t, gate.Open())
+}
+
+func TestElector_Follower(t *testing.T) {
+	cfg := testConfig()
+
+	leaderCfg := cfg
+	leaderCfg.ReplicaID = "a"
+	leader := New(log.NewNopLogger(), prometheus.NewRegistry(), instance.NewRemoteWriteGate(true))
+	require.NoError(t, leader.ApplyConfig(leaderCfg))
+	test.Poll(t, time.Second, true, func() interface{} { return leader.IsLeader() })
+
+	followerCfg := cfg
+	followerCfg.ReplicaID = "b"
+	followerGate := instance.NewRemoteWriteGate(true)
+	follower := New(log.NewNopLogger(), prometheus.NewRegistry(), followerGate)
+	require.NoError(t, follower.ApplyConfig(followerCfg))
+	defer follower.Stop()
+
+	require.False(t, followerGate.Open(), "follower should not send samples")
+	time.Sleep(2 * cfg.FailoverTimeout)
+	require.False(t, follower.IsLeader(), "follower should not take over while the leader renews its lease")
+
+	// The follower takes over once the leader stops.
+	leader.Stop()
+	test.Poll(t, time.Second, true, func() interface{} { return followerGate.Open() })
+}
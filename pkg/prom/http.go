@@ -1,15 +1,20 @@
 package prom
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
 	"sort"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/gorilla/mux"
 	"github.com/grafana/agent/pkg/prom/cluster/configapi"
+	"github.com/grafana/agent/pkg/prom/instance"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage/remote"
 )
 
 // WireAPI adds API routes to the provided mux router.
@@ -17,7 +22,17 @@ func (a *Agent) WireAPI(r *mux.Router) {
 	a.cluster.WireAPI(r)
 
 	r.HandleFunc("/agent/api/v1/instances", a.ListInstancesHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}", a.GetInstanceHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}", a.PutInstanceHandler).Methods("PUT", "POST")
+	r.HandleFunc("/agent/api/v1/instances/{instance}", a.DeleteInstanceHandler).Methods("DELETE")
 	r.HandleFunc("/agent/api/v1/targets", a.ListTargetsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/targets", a.ListInstanceTargetsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/jobs", a.ListInstanceJobsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/status", a.GetInstanceStatusHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/metrics/instance/{instance}/write", a.PushMetricsHandler).Methods("POST")
+	r.HandleFunc("/agent/api/v1/metrics/instance/{instance}/read", a.RemoteReadProxyHandler).Methods("POST")
+	r.HandleFunc("/agent/api/v1/wal/cleanup", a.CleanupWALHandler).Methods("POST")
+	r.HandleFunc("/api/v1/push", a.RemoteWriteReceiverHandler).Methods("POST")
 }
 
 // ListInstancesHandler writes the set of currently running instances to the http.ResponseWriter.
@@ -35,23 +50,558 @@ func (a *Agent) ListInstancesHandler(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+// GetInstanceHandler writes the config of a running instance to the
+// http.ResponseWriter. Secrets in the config are scrubbed.
+func (a *Agent) GetInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cfg, ok := a.mm.ListConfigs()[instanceName]
+	if !ok {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("instance %s does not exist", instanceName))
+		return
+	}
+
+	bb, err := instance.MarshalConfig(&cfg, true)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, fmt.Errorf("could not marshal config for response: %w", err))
+		return
+	}
+	a.writeResponse(w, http.StatusOK, &configapi.GetConfigurationResponse{
+		Value: string(bb),
+	})
+}
+
+// PutInstanceHandler creates or updates an instance from the YAML config in
+// the request body. Only instances that aren't defined in the config file may
+// be changed, and the API is unavailable in scraping service mode. If
+// runtime_configs_directory is set, the config is persisted so it is loaded
+// again after a restart.
+func (a *Agent) PutInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cfg, err := instance.UnmarshalConfig(r.Body)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("could not unmarshal config: %w", err))
+		return
+	}
+	cfg.Name = instanceName
+
+	a.mut.RLock()
+	agentCfg := a.cfg
+	a.mut.RUnlock()
+
+	if err := checkRuntimeConfigAllowed(agentCfg, instanceName); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if agentCfg.WALDir == "" {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("no wal_directory configured"))
+		return
+	}
+
+	if err := a.Validate(cfg); err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("failed to validate config: %w", err))
+		return
+	}
+
+	a.runtimeMut.Lock()
+	defer a.runtimeMut.Unlock()
+
+	if err := a.mm.ApplyConfig(*cfg); err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("failed to apply config: %w", err))
+		return
+	}
+
+	if agentCfg.RuntimeConfigsDir != "" {
+		if err := saveRuntimeConfig(agentCfg.RuntimeConfigsDir, cfg); err != nil {
+			a.writeError(w, http.StatusInternalServerError, fmt.Errorf("config applied but could not be persisted: %w", err))
+			return
+		}
+	}
+
+	_, existed := a.runtimeConfigs[instanceName]
+	a.runtimeConfigs[instanceName] = struct{}{}
+
+	if existed {
+		a.writeResponse(w, http.StatusOK, nil)
+	} else {
+		a.writeResponse(w, http.StatusCreated, nil)
+	}
+}
+
+// DeleteInstanceHandler stops and removes an instance that was previously
+// created through the PutInstanceHandler.
+func (a *Agent) DeleteInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.mut.RLock()
+	agentCfg := a.cfg
+	a.mut.RUnlock()
+
+	if err := checkRuntimeConfigAllowed(agentCfg, instanceName); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.runtimeMut.Lock()
+	defer a.runtimeMut.Unlock()
+
+	if _, ok := a.runtimeConfigs[instanceName]; !ok {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("instance %s does not exist", instanceName))
+		return
+	}
+
+	if err := a.mm.DeleteConfig(instanceName); err != nil {
+		a.writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete config: %w", err))
+		return
+	}
+	delete(a.runtimeConfigs, instanceName)
+
+	if agentCfg.RuntimeConfigsDir != "" {
+		if err := deleteRuntimeConfig(agentCfg.RuntimeConfigsDir, instanceName); err != nil {
+			a.writeError(w, http.StatusInternalServerError, fmt.Errorf("instance deleted but persisted config could not be removed: %w", err))
+			return
+		}
+	}
+
+	a.writeResponse(w, http.StatusOK, nil)
+}
+
+// checkRuntimeConfigAllowed returns an error if the instance with the given
+// name can't be changed through the instances API.
+func checkRuntimeConfigAllowed(cfg Config, name string) error {
+	switch {
+	case cfg.ServiceConfig.Enabled:
+		return fmt.Errorf("instances can't be changed through this API when scraping_service mode is enabled")
+	case hasConfig(cfg.Configs, name):
+		return fmt.Errorf("instance %s is defined in the config file and can't be changed through this API", name)
+	default:
+		return nil
+	}
+}
+
+// PushMetricsHandler accepts a Prometheus remote_write request and appends
+// its samples into the WAL of the instance named in the URL. Samples are then
+// forwarded through that instance's remote_write configs. This allows an
+// Agent to act as a hub that other Agents remote_write to.
+func (a *Agent) PushMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.pushMetrics(w, r, instanceName)
+}
+
+// RemoteWriteReceiverHandler accepts a Prometheus remote_write request on the
+// standard /api/v1/push path and appends its samples into the WAL of the
+// instance set by remote_write_receiver_instance. Unlike PushMetricsHandler,
+// senders don't need to know the name of the instance.
+func (a *Agent) RemoteWriteReceiverHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName := a.remoteWriteReceiverInstance()
+	if instanceName == "" {
+		http.Error(w, "remote_write receiver is not enabled", http.StatusNotFound)
+		return
+	}
+	a.pushMetrics(w, r, instanceName)
+}
+
+func (a *Agent) pushMetrics(w http.ResponseWriter, r *http.Request, instanceName string) {
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	handler := remote.NewWriteHandler(a.logger, inst)
+	handler.ServeHTTP(w, r)
+}
+
+// remoteReadProxier is implemented by instances that can forward remote_read
+// requests to their remote_read_proxy.
+type remoteReadProxier interface {
+	RemoteReadProxy() *instance.RemoteReadProxy
+}
+
+// RemoteReadProxyHandler accepts a Prometheus remote_read request and
+// forwards it to the remote_read_proxy of the instance named in the URL,
+// restricting its queries to the external labels of that instance.
+func (a *Agent) RemoteReadProxyHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var proxy *instance.RemoteReadProxy
+	if p, ok := inst.(remoteReadProxier); ok {
+		proxy = p.RemoteReadProxy()
+	}
+	if proxy == nil {
+		http.Error(w, fmt.Sprintf("instance %s has no remote_read_proxy configured", instanceName), http.StatusNotFound)
+		return
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// CleanupWALHandler immediately removes abandoned WALs and writes the list of
+// deleted WAL directories to the http.ResponseWriter. The min_age query
+// parameter may be provided to override the configured wal_cleanup_age for
+// this run.
+func (a *Agent) CleanupWALHandler(w http.ResponseWriter, r *http.Request) {
+	a.mut.RLock()
+	cleaner := a.cleaner
+	a.mut.RUnlock()
+
+	minAge := cleaner.MinAge()
+	if v := r.URL.Query().Get("min_age"); v != "" {
+		d, err := model.ParseDuration(v)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid min_age: %w", err))
+			return
+		}
+		minAge = time.Duration(d)
+	}
+
+	deleted := cleaner.CleanupStorage(minAge)
+	err := configapi.WriteResponse(w, http.StatusOK, CleanupWALResponse{Deleted: deleted})
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// CleanupWALResponse is returned by the CleanupWALHandler.
+type CleanupWALResponse struct {
+	// Deleted is the list of WAL directories that were removed.
+	Deleted []string `json:"deleted"`
+}
+
+func (a *Agent) writeError(w http.ResponseWriter, statusCode int, writeErr error) {
+	err := configapi.WriteError(w, statusCode, writeErr)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+func (a *Agent) writeResponse(w http.ResponseWriter, statusCode int, v interface{}) {
+	err := configapi.WriteResponse(w, statusCode, v)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// getInstanceName uses gorilla/mux's route variables to extract the
+// "instance" variable.
+func getInstanceName(r *http.Request) (string, error) {
+	vars := mux.Vars(r)
+	name := vars["instance"]
+	name, err := url.PathUnescape(name)
+	if err != nil {
+		return "", fmt.Errorf("could not decode instance name: %w", err)
+	}
+	return name, nil
+}
+
 // ListTargetsHandler retrieves the full set of targets across all instances and shows
-// information on them.
-func (a *Agent) ListTargetsHandler(w http.ResponseWriter, _ *http.Request) {
-	instances := a.mm.ListInstances()
+// information on them. The state query parameter may be set to active (the
+// default), dropped, or any to choose which targets are listed.
+func (a *Agent) ListTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := getTargetState(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	resp := ListTargetsResponse{}
+	for instName, inst := range a.mm.ListInstances() {
+		resp = append(resp, targetInfos(instName, inst, state, nil)...)
+	}
+	sortTargets(resp)
 
-	for instName, inst := range instances {
-		tps := inst.TargetsActive()
+	err = configapi.WriteResponse(w, http.StatusOK, resp)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// ListInstanceTargetsHandler is like ListTargetsHandler but only lists the
+// targets of a single instance config. In shared instance mode, targets from
+// other configs in the same shared instance are left out.
+func (a *Agent) ListInstanceTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	state, err := getTargetState(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cfg, ok := a.mm.ListConfigs()[instanceName]
+	if !ok {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("instance %s does not exist", instanceName))
+		return
+	}
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	jobs := make(map[string]struct{}, len(cfg.ScrapeConfigs))
+	for _, sc := range cfg.ScrapeConfigs {
+		jobs[sc.JobName] = struct{}{}
+	}
+
+	resp := ListTargetsResponse(targetInfos(instanceName, inst, state, jobs))
+	if resp == nil {
+		resp = ListTargetsResponse{}
+	}
+	sortTargets(resp)
+
+	err = configapi.WriteResponse(w, http.StatusOK, resp)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// GetInstanceStatusHandler writes whether the instance running an instance
+// config is healthy to the http.ResponseWriter. Instances that keep exiting
+// shortly after being started are unhealthy.
+func (a *Agent) GetInstanceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := a.mm.ListConfigs()[instanceName]; !ok {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("instance %s does not exist", instanceName))
+		return
+	}
+	status, err := a.mm.InstanceStatus(instanceName)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	resp := InstanceStatusResponse{
+		InstanceName:  instanceName,
+		Healthy:       status.Healthy,
+		StartFailures: status.StartFailures,
+		LastError:     status.LastError,
+	}
+	if !status.LastFailure.IsZero() {
+		resp.LastFailure = &status.LastFailure
+	}
+	if !status.NextRestart.IsZero() {
+		resp.NextRestart = &status.NextRestart
+	}
+	a.writeResponse(w, http.StatusOK, resp)
+}
+
+// InstanceStatusResponse is returned by the GetInstanceStatusHandler.
+type InstanceStatusResponse struct {
+	InstanceName  string     `json:"instance"`
+	Healthy       bool       `json:"healthy"`
+	StartFailures int        `json:"start_failures"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastFailure   *time.Time `json:"last_failure,omitempty"`
+	NextRestart   *time.Time `json:"next_restart,omitempty"`
+}
+
+// maxJobScrapeErrors is the maximum number of scrape errors reported per job
+// by the ListInstanceJobsHandler.
+const maxJobScrapeErrors = 5
+
+// appendStatsReporter is implemented by instances that track how samples
+// scraped from their targets were appended to the WAL.
+type appendStatsReporter interface {
+	AppendStats() map[instance.TargetKey]instance.AppendStats
+}
+
+// ListInstanceJobsHandler writes the health of each scrape job of an instance
+// config to the http.ResponseWriter: how many of its targets are up, a sample
+// of their most recent scrape errors, and how their samples were appended to
+// the WAL. Samples that were scraped and appended but never arrive at the
+// remote_write endpoint point to a remote_write problem rather than a scrape
+// problem.
+func (a *Agent) ListInstanceJobsHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cfg, ok := a.mm.ListConfigs()[instanceName]
+	if !ok {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("instance %s does not exist", instanceName))
+		return
+	}
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var stats map[instance.TargetKey]instance.AppendStats
+	if reporter, ok := inst.(appendStatsReporter); ok {
+		stats = reporter.AppendStats()
+	}
+	active := inst.TargetsActive()
+
+	resp := make(ListJobsResponse, 0, len(cfg.ScrapeConfigs))
+	for _, sc := range cfg.ScrapeConfigs {
+		resp = append(resp, jobHealth(instanceName, sc.JobName, active[sc.JobName], stats))
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Job < resp[j].Job })
+
+	a.writeResponse(w, http.StatusOK, resp)
+}
+
+// jobHealth summarizes the health of the targets of a job.
+func jobHealth(instName, job string, targets []*scrape.Target, stats map[instance.TargetKey]instance.AppendStats) JobHealth {
+	h := JobHealth{
+		InstanceName: instName,
+		Job:          job,
+		ScrapeErrors: []JobScrapeError{},
+	}
+
+	for _, tgt := range targets {
+		switch tgt.Health() {
+		case scrape.HealthGood:
+			h.TargetsUp++
+		case scrape.HealthBad:
+			h.TargetsDown++
+		default:
+			h.TargetsUnknown++
+		}
+
+		if scrapeErr := tgt.LastError(); scrapeErr != nil {
+			h.ScrapeErrors = append(h.ScrapeErrors, JobScrapeError{
+				Endpoint:   tgt.URL().String(),
+				Error:      scrapeErr.Error(),
+				LastScrape: tgt.LastScrape(),
+			})
+		}
+
+		s, ok := stats[instance.TargetKeyFromLabels(tgt.Labels())]
+		if !ok {
+			continue
+		}
+		h.SamplesAppended += s.SamplesAppended
+		h.SamplesRejected += s.SamplesRejected
+		h.FailedCommits += s.FailedCommits
+		if s.LastErrorTime.After(h.LastAppendErrorTime) {
+			h.LastAppendError = s.LastError
+			h.LastAppendErrorTime = s.LastErrorTime
+		}
+	}
+
+	// Keep the most recent errors.
+	sort.Slice(h.ScrapeErrors, func(i, j int) bool {
+		return h.ScrapeErrors[i].LastScrape.After(h.ScrapeErrors[j].LastScrape)
+	})
+	if len(h.ScrapeErrors) > maxJobScrapeErrors {
+		h.ScrapeErrors = h.ScrapeErrors[:maxJobScrapeErrors]
+	}
+	return h
+}
+
+// ListJobsResponse is returned by the ListInstanceJobsHandler.
+type ListJobsResponse []JobHealth
+
+// JobHealth describes the health of the targets of a scrape job.
+type JobHealth struct {
+	InstanceName string `json:"instance"`
+	Job          string `json:"job"`
+
+	TargetsUp      int `json:"targets_up"`
+	TargetsDown    int `json:"targets_down"`
+	TargetsUnknown int `json:"targets_unknown"`
+
+	// The most recent scrape errors of the job's targets, newest first.
+	ScrapeErrors []JobScrapeError `json:"scrape_errors"`
+
+	// How samples scraped from the job's targets were appended to the WAL
+	// since the instance started. Samples are rejected by the WAL or by
+	// scrape_limits, and commits fail when the WAL can't be written to.
+	SamplesAppended     uint64    `json:"samples_appended"`
+	SamplesRejected     uint64    `json:"samples_rejected"`
+	FailedCommits       uint64    `json:"failed_commits"`
+	LastAppendError     string    `json:"last_append_error"`
+	LastAppendErrorTime time.Time `json:"last_append_error_time"`
+}
+
+// JobScrapeError is the most recent scrape error of a target.
+type JobScrapeError struct {
+	Endpoint   string    `json:"endpoint"`
+	Error      string    `json:"error"`
+	LastScrape time.Time `json:"last_scrape"`
+}
+
+const (
+	targetStateActive  = "active"
+	targetStateDropped = "dropped"
+	targetStateAny     = "any"
+)
+
+func getTargetState(r *http.Request) (string, error) {
+	switch state := r.URL.Query().Get("state"); state {
+	case "":
+		return targetStateActive, nil
+	case targetStateActive, targetStateDropped, targetStateAny:
+		return state, nil
+	default:
+		return "", fmt.Errorf("invalid state %q: must be one of active, dropped, or any", state)
+	}
+}
 
-		for key, targets := range tps {
+// targetInfos returns information on the targets of inst in the given state.
+// If jobs is non-nil, only targets from target groups in jobs are returned.
+func targetInfos(instName string, inst instance.ManagedInstance, state string, jobs map[string]struct{}) []TargetInfo {
+	var infos []TargetInfo
+
+	include := func(key string) bool {
+		if jobs == nil {
+			return true
+		}
+		_, ok := jobs[key]
+		return ok
+	}
+
+	if state == targetStateActive || state == targetStateAny {
+		for key, targets := range inst.TargetsActive() {
+			if !include(key) {
+				continue
+			}
 			for _, tgt := range targets {
 				var lastError string
 				if scrapeError := tgt.LastError(); scrapeError != nil {
 					lastError = scrapeError.Error()
 				}
 
-				resp = append(resp, TargetInfo{
+				infos = append(infos, TargetInfo{
 					InstanceName: instName,
 					TargetGroup:  key,
 
@@ -67,8 +617,32 @@ func (a *Agent) ListTargetsHandler(w http.ResponseWriter, _ *http.Request) {
 		}
 	}
 
+	if state == targetStateDropped || state == targetStateAny {
+		for key, targets := range inst.TargetsDropped() {
+			if !include(key) {
+				continue
+			}
+			for _, tgt := range targets {
+				// Dropped targets were removed by relabeling, so they only have
+				// their discovered labels.
+				infos = append(infos, TargetInfo{
+					InstanceName: instName,
+					TargetGroup:  key,
+
+					State:            targetStateDropped,
+					DiscoveredLabels: tgt.DiscoveredLabels(),
+				})
+			}
+		}
+	}
+
+	return infos
+}
+
+func sortTargets(resp ListTargetsResponse) {
 	sort.Slice(resp, func(i, j int) bool {
-		// sort by instance, then target group, then job label, then instance label
+		// sort by instance, then target group, then job label, then instance
+		// label, then discovered labels
 		var (
 			iInstance      = resp[i].InstanceName
 			iTargetGroup   = resp[i].TargetGroup
@@ -88,15 +662,12 @@ func (a *Agent) ListTargetsHandler(w http.ResponseWriter, _ *http.Request) {
 			return iTargetGroup < jTargetGroup
 		case iJobLabel != jJobLabel:
 			return iJobLabel < jJobLabel
-		default:
+		case iInstanceLabel != jInstanceLabel:
 			return iInstanceLabel < jInstanceLabel
+		default:
+			return labels.Compare(resp[i].DiscoveredLabels, resp[j].DiscoveredLabels) < 0
 		}
 	})
-
-	err := configapi.WriteResponse(w, http.StatusOK, resp)
-	if err != nil {
-		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
-	}
 }
 
 // ListTargetsResponse is returned by the ListTargetsHandler.
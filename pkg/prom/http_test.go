@@ -1,26 +1,38 @@
 package prom
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/gorilla/mux"
 	"github.com/grafana/agent/pkg/prom/instance"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/exemplar"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
 	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
 )
 
 func TestAgent_ListInstancesHandler(t *testing.T) {
 	fact := newFakeInstanceFactory()
-	a, err := newAgent(prometheus.NewRegistry(), Config{
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), Config{
 		WALDir: "/tmp/agent",
 	}, log.NewNopLogger(), fact.factory)
 	require.NoError(t, err)
@@ -48,9 +60,33 @@ func TestAgent_ListInstancesHandler(t *testing.T) {
 	})
 }
 
+func TestAgent_GetInstanceStatusHandler(t *testing.T) {
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	router := mux.NewRouter()
+	a.WireAPI(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/instances/foo/status", nil))
+	require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+
+	require.NoError(t, a.mm.ApplyConfig(makeInstanceConfig("foo")))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/instances/foo/status", nil))
+	require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	expect := `{"status":"success","data":{"instance":"foo","healthy":true,"start_failures":0}}`
+	require.Equal(t, expect, rr.Body.String())
+}
+
 func TestAgent_ListTargetsHandler(t *testing.T) {
 	fact := newFakeInstanceFactory()
-	a, err := newAgent(prometheus.NewRegistry(), Config{
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), Config{
 		WALDir: "/tmp/agent",
 	}, log.NewNopLogger(), fact.factory)
 	require.NoError(t, err)
@@ -131,10 +167,448 @@ func TestAgent_ListTargetsHandler(t *testing.T) {
 		require.JSONEq(t, expect, rr.Body.String())
 		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
 	})
+
+	t.Run("dropped targets", func(t *testing.T) {
+		dropped := scrape.NewTarget(nil, labels.FromMap(map[string]string{
+			model.AddressLabel: "localhost:9999",
+		}), nil)
+
+		mockManager.ListInstancesFunc = func() map[string]instance.ManagedInstance {
+			return map[string]instance.ManagedInstance{
+				"test_instance": &mockInstanceScrape{
+					dropped: map[string][]*scrape.Target{
+						"group_a": {dropped},
+					},
+				},
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		a.ListTargetsHandler(rr, httptest.NewRequest("GET", "/agent/api/v1/targets", nil))
+		require.JSONEq(t, `{"status": "success", "data": []}`, rr.Body.String())
+
+		rr = httptest.NewRecorder()
+		a.ListTargetsHandler(rr, httptest.NewRequest("GET", "/agent/api/v1/targets?state=dropped", nil))
+		expect := `{
+			"status": "success",
+			"data": [{
+				"instance": "test_instance",
+				"target_group": "group_a",
+				"endpoint": "",
+				"state": "dropped",
+				"labels": {},
+				"discovered_labels": {
+					"__address__": "localhost:9999"
+				},
+				"last_scrape": "0001-01-01T00:00:00Z",
+				"scrape_duration_ms": 0,
+				"scrape_error": ""
+			}]
+		}`
+		require.JSONEq(t, expect, rr.Body.String())
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	})
+
+	t.Run("invalid state", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		a.ListTargetsHandler(rr, httptest.NewRequest("GET", "/agent/api/v1/targets?state=unknown", nil))
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+	})
+}
+
+func TestAgent_ListInstanceTargetsHandler(t *testing.T) {
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+
+	newTarget := func(job string) *scrape.Target {
+		return scrape.NewTarget(labels.FromMap(map[string]string{
+			model.JobLabel:         job,
+			model.InstanceLabel:    "instance",
+			model.SchemeLabel:      "http",
+			model.AddressLabel:     "localhost:12345",
+			model.MetricsPathLabel: "/metrics",
+		}), nil, nil)
+	}
+
+	// Both configs share an instance, but only job_a belongs to config_a.
+	shared := &mockInstanceScrape{
+		tgts: map[string][]*scrape.Target{
+			"job_a": {newTarget("job_a")},
+			"job_b": {newTarget("job_b")},
+		},
+	}
+	mockManager := &instance.MockManager{
+		ListInstancesFunc: func() map[string]instance.ManagedInstance { return nil },
+		ListConfigsFunc: func() map[string]instance.Config {
+			return map[string]instance.Config{
+				"config_a": {Name: "config_a", ScrapeConfigs: []*config.ScrapeConfig{{JobName: "job_a"}}},
+				"config_b": {Name: "config_b", ScrapeConfigs: []*config.ScrapeConfig{{JobName: "job_b"}}},
+			}
+		},
+		ApplyConfigFunc:  func(_ instance.Config) error { return nil },
+		DeleteConfigFunc: func(name string) error { return nil },
+		StopFunc:         func() {},
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			return shared, nil
+		},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	a.WireAPI(router)
+
+	t.Run("unknown instance", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/instances/missing/targets", nil))
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	})
+
+	t.Run("only targets of the config", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/instances/config_a/targets", nil))
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+		var resp struct {
+			Data ListTargetsResponse `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Len(t, resp.Data, 1)
+		require.Equal(t, "config_a", resp.Data[0].InstanceName)
+		require.Equal(t, "job_a", resp.Data[0].TargetGroup)
+	})
+}
+
+func TestAgent_ListInstanceJobsHandler(t *testing.T) {
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+
+	newTarget := func(job, instance string) *scrape.Target {
+		return scrape.NewTarget(labels.FromMap(map[string]string{
+			model.JobLabel:         job,
+			model.InstanceLabel:    instance,
+			model.SchemeLabel:      "http",
+			model.AddressLabel:     instance,
+			model.MetricsPathLabel: "/metrics",
+		}), nil, nil)
+	}
+
+	now := time.Now()
+	inst := &mockInstanceScrape{
+		tgts: map[string][]*scrape.Target{
+			"job_a": {newTarget("job_a", "a:80"), newTarget("job_a", "b:80")},
+			"job_b": {newTarget("job_b", "c:80")},
+		},
+		stats: map[instance.TargetKey]instance.AppendStats{
+			{Job: "job_a", Instance: "a:80"}: {SamplesAppended: 10, SamplesRejected: 1, LastError: "out of order sample", LastErrorTime: now.Add(-time.Minute)},
+			{Job: "job_a", Instance: "b:80"}: {SamplesAppended: 5, FailedCommits: 2, LastError: "disk full", LastErrorTime: now},
+		},
+	}
+	mockManager := &instance.MockManager{
+		ListInstancesFunc: func() map[string]instance.ManagedInstance { return nil },
+		ListConfigsFunc: func() map[string]instance.Config {
+			return map[string]instance.Config{
+				"config": {Name: "config", ScrapeConfigs: []*config.ScrapeConfig{{JobName: "job_a"}, {JobName: "job_b"}}},
+			}
+		},
+		ApplyConfigFunc:  func(_ instance.Config) error { return nil },
+		DeleteConfigFunc: func(name string) error { return nil },
+		StopFunc:         func() {},
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			return inst, nil
+		},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	a.WireAPI(router)
+
+	t.Run("unknown instance", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/instances/missing/jobs", nil))
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	})
+
+	t.Run("job health", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/instances/config/jobs", nil))
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+		var resp struct {
+			Data ListJobsResponse `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Len(t, resp.Data, 2)
+
+		jobA := resp.Data[0]
+		require.Equal(t, "job_a", jobA.Job)
+		require.Equal(t, 2, jobA.TargetsUnknown, "targets that were never scraped have unknown health")
+		require.Equal(t, uint64(15), jobA.SamplesAppended)
+		require.Equal(t, uint64(1), jobA.SamplesRejected)
+		require.Equal(t, uint64(2), jobA.FailedCommits)
+		require.Equal(t, "disk full", jobA.LastAppendError)
+
+		jobB := resp.Data[1]
+		require.Equal(t, "job_b", jobB.Job)
+		require.Equal(t, 1, jobB.TargetsUnknown)
+		require.Zero(t, jobB.SamplesAppended)
+		require.Empty(t, jobB.ScrapeErrors)
+	})
 }
 
+func TestAgent_PushMetricsHandler(t *testing.T) {
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+
+	app := &mockAppender{}
+	mockManager := &instance.MockManager{
+		ListInstancesFunc: func() map[string]instance.ManagedInstance { return nil },
+		ListConfigsFunc:   func() map[string]instance.Config { return nil },
+		ApplyConfigFunc:   func(_ instance.Config) error { return nil },
+		DeleteConfigFunc:  func(name string) error { return nil },
+		StopFunc:          func() {},
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != "test_instance" {
+				return nil, fmt.Errorf("instance %s does not exist", name)
+			}
+			return &mockInstanceScrape{app: app}, nil
+		},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	a.WireAPI(router)
+
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "test_metric"},
+				{Name: "job", Value: "spoke"},
+			},
+			Samples: []prompb.Sample{{Timestamp: 1000, Value: 42}},
+		}},
+	}
+	bb, err := proto.Marshal(req)
+	require.NoError(t, err)
+	body := snappy.Encode(nil, bb)
+
+	t.Run("unknown instance", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/agent/api/v1/metrics/instance/missing/write", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	})
+
+	t.Run("known instance", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/agent/api/v1/metrics/instance/test_instance/write", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		require.Equal(t, http.StatusNoContent, rr.Result().StatusCode)
+
+		require.True(t, app.committed)
+		require.Len(t, app.samples, 1)
+		require.Equal(t, "test_metric", app.samples[0].labels.Get("__name__"))
+		require.Equal(t, int64(1000), app.samples[0].ts)
+		require.Equal(t, float64(42), app.samples[0].value)
+	})
+
+	t.Run("receiver disabled", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/api/v1/push", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	})
+
+	t.Run("receiver enabled", func(t *testing.T) {
+		a.cfg.RemoteWriteReceiverInstance = "test_instance"
+		defer func() { a.cfg.RemoteWriteReceiverInstance = "" }()
+
+		r := httptest.NewRequest("POST", "/api/v1/push", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		require.Equal(t, http.StatusNoContent, rr.Result().StatusCode)
+
+		require.Len(t, app.samples, 2)
+		require.Equal(t, "test_metric", app.samples[1].labels.Get("__name__"))
+	})
+}
+
+func TestAgent_CleanupWALHandler(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "cleanup-handler")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	abandoned := filepath.Join(walDir, "abandoned")
+	require.NoError(t, os.MkdirAll(abandoned, 0755))
+
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), Config{
+		WALDir:           walDir,
+		WALCleanupPeriod: 0,
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	a.cleaner.walLastModified = func(path string) (time.Time, error) {
+		return time.Now().Add(-time.Hour), nil
+	}
+
+	t.Run("invalid min_age", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/agent/api/v1/wal/cleanup?min_age=never", nil)
+		rr := httptest.NewRecorder()
+		a.CleanupWALHandler(rr, r)
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+	})
+
+	t.Run("min_age not reached", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/agent/api/v1/wal/cleanup?min_age=2h", nil)
+		rr := httptest.NewRecorder()
+		a.CleanupWALHandler(rr, r)
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+		require.JSONEq(t, `{"status":"success","data":{"deleted":[]}}`, rr.Body.String())
+		require.DirExists(t, abandoned)
+	})
+
+	t.Run("min_age override", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/agent/api/v1/wal/cleanup?min_age=30m", nil)
+		rr := httptest.NewRecorder()
+		a.CleanupWALHandler(rr, r)
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+		expect := fmt.Sprintf(`{"status":"success","data":{"deleted":[%q]}}`, abandoned)
+		require.JSONEq(t, expect, rr.Body.String())
+		require.NoDirExists(t, abandoned)
+	})
+}
+
+func TestAgent_InstanceHandlers(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "instance-handlers-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	runtimeDir, err := ioutil.TempDir(os.TempDir(), "instance-handlers-runtime")
+	require.NoError(t, err)
+	defer os.RemoveAll(runtimeDir)
+
+	cfg := Config{
+		WALDir:            walDir,
+		RuntimeConfigsDir: runtimeDir,
+		Configs:           []instance.Config{makeInstanceConfig("file")},
+	}
+
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), cfg, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	a.WireAPI(router)
+
+	do := func(r *mux.Router, method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewReader([]byte(body))))
+		return rr
+	}
+
+	t.Run("config file instances can't be changed", func(t *testing.T) {
+		rr := do(router, "PUT", "/agent/api/v1/instances/file", "host_filter: false\n")
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+
+		rr = do(router, "DELETE", "/agent/api/v1/instances/file", "")
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+	})
+
+	t.Run("create and update", func(t *testing.T) {
+		rr := do(router, "PUT", "/agent/api/v1/instances/runtime", "host_filter: false\n")
+		require.Equal(t, http.StatusCreated, rr.Result().StatusCode)
+		require.FileExists(t, filepath.Join(runtimeDir, "runtime.yml"))
+
+		rr = do(router, "PUT", "/agent/api/v1/instances/runtime", "host_filter: true\n")
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+		rr = do(router, "GET", "/agent/api/v1/instances/runtime", "")
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+		require.Contains(t, rr.Body.String(), "host_filter: true")
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		rr := do(router, "PUT", "/agent/api/v1/instances/invalid", "wal_truncate_frequency: 0s\n")
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+		require.NoFileExists(t, filepath.Join(runtimeDir, "invalid.yml"))
+	})
+
+	// Persisted configs should be loaded by a new Agent using the same
+	// directory.
+	a.Stop()
+	a, err = newAgent(prometheus.NewRegistry(), prometheus.NewRegistry(), cfg, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	router = mux.NewRouter()
+	a.WireAPI(router)
+
+	t.Run("reloaded from disk", func(t *testing.T) {
+		test.Poll(t, time.Second, true, func() interface{} {
+			_, ok := a.mm.ListConfigs()["runtime"]
+			return ok
+		})
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rr := do(router, "DELETE", "/agent/api/v1/instances/runtime", "")
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+		require.NoFileExists(t, filepath.Join(runtimeDir, "runtime.yml"))
+
+		rr = do(router, "DELETE", "/agent/api/v1/instances/runtime", "")
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+
+		rr = do(router, "GET", "/agent/api/v1/instances/runtime", "")
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	})
+}
+
+type mockSample struct {
+	labels labels.Labels
+	ts     int64
+	value  float64
+}
+
+type mockAppender struct {
+	samples   []mockSample
+	committed bool
+}
+
+func (a *mockAppender) Append(_ uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	a.samples = append(a.samples, mockSample{labels: l, ts: t, value: v})
+	return 0, nil
+}
+
+func (a *mockAppender) AppendExemplar(_ uint64, _ labels.Labels, _ exemplar.Exemplar) (uint64, error) {
+	return 0, nil
+}
+
+func (a *mockAppender) Commit() error {
+	a.committed = true
+	return nil
+}
+
+func (a *mockAppender) Rollback() error { return nil }
+
 type mockInstanceScrape struct {
-	tgts map[string][]*scrape.Target
+	tgts    map[string][]*scrape.Target
+	dropped map[string][]*scrape.Target
+	app     storage.Appender
+	stats   map[instance.TargetKey]instance.AppendStats
 }
 
 func (i *mockInstanceScrape) Run(ctx context.Context) error {
@@ -150,6 +624,18 @@ func (i *mockInstanceScrape) TargetsActive() map[string][]*scrape.Target {
 	return i.tgts
 }
 
+func (i *mockInstanceScrape) TargetsDropped() map[string][]*scrape.Target {
+	return i.dropped
+}
+
+func (i *mockInstanceScrape) AppendStats() map[instance.TargetKey]instance.AppendStats {
+	return i.stats
+}
+
 func (i *mockInstanceScrape) StorageDirectory() string {
 	return ""
 }
+
+func (i *mockInstanceScrape) Appender(ctx context.Context) storage.Appender {
+	return i.app
+}
@@ -0,0 +1,132 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AppendableFunc returns the storage of the instance with the given name.
+type AppendableFunc func(name string) (storage.Appendable, error)
+
+// Server implements MetricsIngestServer, writing the samples of streams to
+// instances.
+type Server struct {
+	logger          log.Logger
+	defaultInstance func() string
+	getAppendable   AppendableFunc
+
+	samples       *prometheus.CounterVec
+	failedBatches *prometheus.CounterVec
+}
+
+// NewServer creates a new Server writing to the instances returned by
+// getAppendable. Streams that don't set InstanceHeader write to the instance
+// named by defaultInstance, if any.
+func NewServer(logger log.Logger, reg prometheus.Registerer, defaultInstance func() string, getAppendable AppendableFunc) *Server {
+	return &Server{
+		logger:          logger,
+		defaultInstance: defaultInstance,
+		getAppendable:   getAppendable,
+
+		samples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_ingest_samples_total",
+			Help: "Total number of samples written to an instance through the gRPC ingest API.",
+		}, []string{"instance_name"}),
+		failedBatches: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_ingest_failed_batches_total",
+			Help: "Total number of batches sent to an instance through the gRPC ingest API that couldn't be written.",
+		}, []string{"instance_name"}),
+	}
+}
+
+// Push implements MetricsIngestServer.
+func (s *Server) Push(stream MetricsIngest_PushServer) error {
+	var name string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if vals := md.Get(InstanceHeader); len(vals) > 0 {
+			name = vals[0]
+		}
+	}
+	if name == "" {
+		name = s.defaultInstance()
+	}
+	if name == "" {
+		return status.Errorf(codes.InvalidArgument, "%s metadata must be set", InstanceHeader)
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// The instance is looked up for every batch, as it may be replaced
+		// while the stream is open.
+		app, err := s.getAppendable(name)
+		if err != nil {
+			return status.Error(codes.NotFound, err.Error())
+		}
+
+		n, err := write(stream.Context(), app, req)
+		if err != nil {
+			s.failedBatches.WithLabelValues(name).Inc()
+			level.Error(s.logger).Log("msg", "failed to write batch from ingest stream", "instance", name, "err", err)
+
+			code := codes.Internal
+			if errors.Is(err, storage.ErrOutOfOrderSample) || errors.Is(err, storage.ErrOutOfBounds) || errors.Is(err, storage.ErrDuplicateSampleForTimestamp) {
+				// Retrying the batch wouldn't succeed.
+				code = codes.InvalidArgument
+			}
+			return status.Error(code, err.Error())
+		}
+		s.samples.WithLabelValues(name).Add(float64(n))
+
+		if err := stream.Send(&empty.Empty{}); err != nil {
+			return err
+		}
+	}
+}
+
+// write appends the samples of req to app in a single commit, returning the
+// number of samples written.
+func write(ctx context.Context, app storage.Appendable, req *prompb.WriteRequest) (n int, err error) {
+	a := app.Appender(ctx)
+	defer func() {
+		if err != nil {
+			_ = a.Rollback()
+			return
+		}
+		err = a.Commit()
+	}()
+
+	for _, ts := range req.Timeseries {
+		lset := make(labels.Labels, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+		}
+		lset = labels.New(lset...)
+
+		for _, sample := range ts.Samples {
+			if _, err := a.Append(0, lset, sample.Timestamp, sample.Value); err != nil {
+				return 0, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
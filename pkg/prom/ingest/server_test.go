@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer_Push(t *testing.T) {
+	apps := map[string]*recordingAppendable{
+		"default": {},
+		"edge":    {},
+	}
+	client := newTestClient(t, "default", func(name string) (storage.Appendable, error) {
+		app, ok := apps[name]
+		if !ok {
+			return nil, errors.New("instance not found")
+		}
+		return app, nil
+	})
+
+	// Streams without the instance header write to the default instance.
+	stream, err := client.Push(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(writeRequest(1000, 1, 2)))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), InstanceHeader, "edge")
+	stream, err = client.Push(ctx)
+	require.NoError(t, err)
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, stream.Send(writeRequest(i*1000, float64(i))))
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CloseSend())
+
+	require.Equal(t, []sample{
+		{labels: labels.FromStrings("__name__", "edge_temperature", "device", "a"), t: 1000, v: 1},
+		{labels: labels.FromStrings("__name__", "edge_temperature", "device", "a"), t: 1000, v: 2},
+	}, apps["default"].samples())
+	require.Len(t, apps["edge"].samples(), 3)
+
+	// Batches that fail to be written close the stream and aren't written.
+	apps["edge"].err = storage.ErrOutOfOrderSample
+	stream, err = client.Push(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(writeRequest(0, 1)))
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Len(t, apps["edge"].samples(), 3)
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), InstanceHeader, "missing")
+	stream, err = client.Push(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(writeRequest(0, 1)))
+	_, err = stream.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_Push_NoInstance(t *testing.T) {
+	client := newTestClient(t, "", func(name string) (storage.Appendable, error) {
+		t.Fatal("no instance should be used")
+		return nil, nil
+	})
+
+	stream, err := client.Push(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func newTestClient(t *testing.T, defaultInstance string, getAppendable AppendableFunc) MetricsIngestClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	RegisterMetricsIngestServer(srv, NewServer(log.NewNopLogger(), prometheus.NewRegistry(), func() string { return defaultInstance }, getAppendable))
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { cc.Close() })
+	return NewMetricsIngestClient(cc)
+}
+
+func writeRequest(ts int64, values ...float64) *prompb.WriteRequest {
+	series := prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "device", Value: "a"},
+			{Name: "__name__", Value: "edge_temperature"},
+		},
+	}
+	for _, v := range values {
+		series.Samples = append(series.Samples, prompb.Sample{Timestamp: ts, Value: v})
+	}
+	return &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series}}
+}
+
+type sample struct {
+	labels labels.Labels
+	t      int64
+	v      float64
+}
+
+// recordingAppendable records committed samples. Appends fail with err when
+// it's set.
+type recordingAppendable struct {
+	mut       sync.Mutex
+	committed []sample
+	err       error
+}
+
+func (r *recordingAppendable) Appender(context.Context) storage.Appender {
+	return &recordingAppender{parent: r}
+}
+
+func (r *recordingAppendable) samples() []sample {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.committed
+}
+
+type recordingAppender struct {
+	parent  *recordingAppendable
+	pending []sample
+}
+
+func (a *recordingAppender) Append(_ uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	if a.parent.err != nil {
+		return 0, a.parent.err
+	}
+	a.pending = append(a.pending, sample{labels: l, t: t, v: v})
+	return 0, nil
+}
+
+func (a *recordingAppender) AppendExemplar(ref uint64, _ labels.Labels, _ exemplar.Exemplar) (uint64, error) {
+	return ref, nil
+}
+
+func (a *recordingAppender) Commit() error {
+	a.parent.mut.Lock()
+	defer a.parent.mut.Unlock()
+	a.parent.committed = append(a.parent.committed, a.pending...)
+	return nil
+}
+
+func (a *recordingAppender) Rollback() error {
+	a.pending = nil
+	return nil
+}
@@ -0,0 +1,13 @@
+// Package ingest implements an experimental gRPC API that streams batches of
+// samples into the WAL of a Prometheus instance. It targets senders pushing
+// samples at a high frequency, such as other agents or lightweight SDKs on
+// edge devices, which would otherwise pay for an HTTP request per batch with
+// remote_write.
+//
+// The MetricsIngest service is defined in ingest.proto. Batches are
+// remote_write WriteRequests from the Prometheus prompb package.
+package ingest
+
+// InstanceHeader is the gRPC metadata key holding the name of the instance
+// that samples of a stream are written to.
+const InstanceHeader = "x-agent-instance"
@@ -0,0 +1,183 @@
+package instance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// appendStatsTTL is how long the append stats of a target are kept after its
+// last scrape.
+var appendStatsTTL = 15 * time.Minute
+
+// AppendStats describes how samples scraped from a target were appended to
+// the WAL.
+type AppendStats struct {
+	// Samples written to the WAL.
+	SamplesAppended uint64
+	// Samples rejected by the WAL or scrape_limits, such as out-of-order
+	// samples.
+	SamplesRejected uint64
+	// Scrapes whose samples couldn't be committed to the WAL.
+	FailedCommits uint64
+
+	// The most recent error returned when appending or committing samples.
+	LastError     string
+	LastErrorTime time.Time
+
+	lastScrape time.Time
+}
+
+// TargetKey identifies a target by its job and instance labels.
+type TargetKey struct {
+	Job      string
+	Instance string
+}
+
+// TargetKeyFromLabels returns the TargetKey of a target or of a series
+// scraped from it.
+func TargetKeyFromLabels(l labels.Labels) TargetKey {
+	return TargetKey{Job: l.Get(model.JobLabel), Instance: l.Get(model.InstanceLabel)}
+}
+
+// appendStatsAppendable is a storage.Appendable that tracks the AppendStats
+// of every target. Every Appender is expected to be used for a single scrape
+// of a single target, as the scrape manager does.
+type appendStatsAppendable struct {
+	storage.Appendable
+
+	mut       sync.Mutex
+	stats     map[TargetKey]*AppendStats
+	lastPrune time.Time
+
+	// Totals of all commits, including those of targets that went away.
+	commits, failedCommits uint64
+}
+
+func newAppendStatsAppendable(app storage.Appendable) *appendStatsAppendable {
+	return &appendStatsAppendable{
+		Appendable: app,
+		stats:      make(map[TargetKey]*AppendStats),
+		lastPrune:  time.Now(),
+	}
+}
+
+// Stats returns a copy of the AppendStats of every target scraped within
+// appendStatsTTL.
+func (a *appendStatsAppendable) Stats() map[TargetKey]AppendStats {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	res := make(map[TargetKey]AppendStats, len(a.stats))
+	for key, s := range a.stats {
+		res[key] = *s
+	}
+	return res
+}
+
+// Commits returns the total number of commits and how many of them failed.
+func (a *appendStatsAppendable) Commits() (total, failed uint64) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return a.commits, a.failedCommits
+}
+
+func (a *appendStatsAppendable) countCommit(failed bool) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.commits++
+	if failed {
+		a.failedCommits++
+	}
+}
+
+func (a *appendStatsAppendable) Appender(ctx context.Context) storage.Appender {
+	return &appendStatsAppender{Appender: a.Appendable.Appender(ctx), parent: a}
+}
+
+// record adds the result of a scrape to the stats of its target.
+func (a *appendStatsAppendable) record(key TargetKey, appended, rejected uint64, commitFailed bool, lastErr error) {
+	now := time.Now()
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	s, ok := a.stats[key]
+	if !ok {
+		s = &AppendStats{}
+		a.stats[key] = s
+	}
+	s.SamplesAppended += appended
+	s.SamplesRejected += rejected
+	if commitFailed {
+		s.FailedCommits++
+	}
+	if lastErr != nil {
+		s.LastError = lastErr.Error()
+		s.LastErrorTime = now
+	}
+	s.lastScrape = now
+
+	// Targets that went away would otherwise be kept forever.
+	if now.Sub(a.lastPrune) >= time.Minute {
+		for key, s := range a.stats {
+			if now.Sub(s.lastScrape) > appendStatsTTL {
+				delete(a.stats, key)
+			}
+		}
+		a.lastPrune = now
+	}
+}
+
+type appendStatsAppender struct {
+	storage.Appender
+	parent *appendStatsAppendable
+
+	key                TargetKey
+	keySet             bool
+	appended, rejected uint64
+	lastErr            error
+}
+
+func (a *appendStatsAppender) Append(ref uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	if !a.keySet {
+		a.key, a.keySet = TargetKeyFromLabels(l), true
+	}
+
+	ref, err := a.Appender.Append(ref, l, t, v)
+	if err != nil {
+		a.rejected++
+		a.lastErr = err
+	} else {
+		a.appended++
+	}
+	return ref, err
+}
+
+func (a *appendStatsAppender) Commit() error {
+	err := a.Appender.Commit()
+	a.parent.countCommit(err != nil)
+	if !a.keySet {
+		return err
+	}
+
+	if err != nil {
+		a.parent.record(a.key, 0, a.rejected, true, err)
+	} else {
+		a.parent.record(a.key, a.appended, a.rejected, false, a.lastErr)
+	}
+	return err
+}
+
+func (a *appendStatsAppender) Rollback() error {
+	// The scrape loop rolls back scrapes that failed, including scrapes with
+	// samples that were rejected, so rejections are still recorded.
+	if a.keySet && a.rejected > 0 {
+		a.parent.record(a.key, 0, a.rejected, false, a.lastErr)
+	}
+	return a.Appender.Rollback()
+}
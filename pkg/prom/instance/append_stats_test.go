@@ -0,0 +1,75 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendStatsAppendable(t *testing.T) {
+	var (
+		appendErr error
+		commitErr error
+	)
+	app := newAppendStatsAppendable(appendableFunc(func(_ context.Context) storage.Appender {
+		return &funcAppender{
+			appendFunc: func() error { return appendErr },
+			commitFunc: func() error { return commitErr },
+		}
+	}))
+
+	series := labels.FromStrings("__name__", "metric", "job", "job", "instance", "target:80")
+	key := TargetKey{Job: "job", Instance: "target:80"}
+
+	scrape := func(samples int) {
+		a := app.Appender(context.Background())
+		for i := 0; i < samples; i++ {
+			_, _ = a.Append(0, series, 0, 0)
+		}
+		_ = a.Commit()
+	}
+
+	scrape(3)
+	require.Equal(t, AppendStats{SamplesAppended: 3}, withoutLastScrape(app.Stats()[key]))
+
+	appendErr = errors.New("out of order sample")
+	scrape(2)
+	stats := app.Stats()[key]
+	require.Equal(t, uint64(3), stats.SamplesAppended)
+	require.Equal(t, uint64(2), stats.SamplesRejected)
+	require.Equal(t, "out of order sample", stats.LastError)
+
+	appendErr, commitErr = nil, errors.New("disk full")
+	scrape(1)
+	stats = app.Stats()[key]
+	require.Equal(t, uint64(3), stats.SamplesAppended, "samples of failed commits should not be counted as appended")
+	require.Equal(t, uint64(1), stats.FailedCommits)
+	require.Equal(t, "disk full", stats.LastError)
+}
+
+func withoutLastScrape(s AppendStats) AppendStats {
+	s.lastScrape = time.Time{}
+	return s
+}
+
+type appendableFunc func(ctx context.Context) storage.Appender
+
+func (f appendableFunc) Appender(ctx context.Context) storage.Appender { return f(ctx) }
+
+type funcAppender struct {
+	storage.Appender
+	appendFunc func() error
+	commitFunc func() error
+}
+
+func (a *funcAppender) Append(uint64, labels.Labels, int64, float64) (uint64, error) {
+	return 0, a.appendFunc()
+}
+
+func (a *funcAppender) Commit() error   { return a.commitFunc() }
+func (a *funcAppender) Rollback() error { return nil }
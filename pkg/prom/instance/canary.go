@@ -0,0 +1,408 @@
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// canaryMetricName is the name of the series written by the canary. Its value
+// is the time the sample was written, in seconds since the epoch.
+const canaryMetricName = "agent_canary_write_timestamp_seconds"
+
+// canaryInstanceLabel is the label of the canary series set to the instance
+// name.
+const canaryInstanceLabel = "agent_instance"
+
+// Defaults for CanaryConfig.
+const (
+	defaultCanaryTimeout      = 5 * time.Minute
+	defaultCanaryPollInterval = time.Second
+)
+
+// CanaryConfig configures a canary series that the instance writes and then
+// queries from the remote endpoint, measuring how long it takes for samples
+// to become queryable.
+type CanaryConfig struct {
+	// How often to write a canary sample. 0 disables the canary. A new sample
+	// isn't written until the previous one was found or timed out.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// Base URL of the Prometheus query API of the remote endpoint, without
+	// the /api/v1/query suffix.
+	QueryURL *config_util.URL `yaml:"query_url,omitempty"`
+
+	// How long to wait for a canary sample to become queryable. Defaults to
+	// 5m.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// How often to query for the canary sample while waiting for it. Defaults
+	// to 1s. The measured latency is rounded up to a multiple of it.
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+
+	// Labels added to the canary series. Labels that distinguish the agent
+	// from others writing to the same endpoint with the same external labels
+	// should be set here.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// Headers sent with every query. The tenant header is set when the
+	// instance has a tenant_id.
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	HTTPClientConfig config_util.HTTPClientConfig `yaml:",inline"`
+}
+
+// Validate returns an error if the config is invalid.
+func (c *CanaryConfig) Validate() error {
+	switch {
+	case c.Interval < 0:
+		return errors.New("canary.interval must not be negative")
+	case c.Interval > 0 && c.QueryURL == nil:
+		return errors.New("canary.query_url must be set")
+	case c.Timeout < 0:
+		return errors.New("canary.timeout must not be negative")
+	case c.PollInterval < 0:
+		return errors.New("canary.poll_interval must not be negative")
+	}
+	for name, value := range c.Labels {
+		if !model.LabelName(name).IsValid() || name == model.MetricNameLabel || name == canaryInstanceLabel {
+			return fmt.Errorf("%q is not a valid canary label name", name)
+		}
+		if !model.LabelValue(value).IsValid() {
+			return fmt.Errorf("%q is not a valid value for canary label %q", value, name)
+		}
+	}
+	return c.HTTPClientConfig.Validate()
+}
+
+type canaryMetrics struct {
+	latency       prometheus.Histogram
+	lastLatency   prometheus.Gauge
+	writes        prometheus.Counter
+	writeFailures prometheus.Counter
+	timeouts      prometheus.Counter
+	queryFailures prometheus.Counter
+}
+
+func newCanaryMetrics(reg prometheus.Registerer) *canaryMetrics {
+	return &canaryMetrics{
+		latency: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "agent_prometheus_canary_latency_seconds",
+			Help:    "Time between writing a canary sample and it becoming queryable from the remote endpoint.",
+			Buckets: []float64{1, 2.5, 5, 10, 15, 30, 60, 120, 300, 600},
+		}),
+		lastLatency: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "agent_prometheus_canary_last_latency_seconds",
+			Help: "Time it took for the most recently found canary sample to become queryable from the remote endpoint.",
+		}),
+		writes: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_canary_writes_total",
+			Help: "Total number of canary samples written.",
+		}),
+		writeFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_canary_write_failures_total",
+			Help: "Total number of canary samples that couldn't be written to the WAL.",
+		}),
+		timeouts: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_canary_timeouts_total",
+			Help: "Total number of canary samples that didn't become queryable within canary.timeout.",
+		}),
+		queryFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_canary_query_failures_total",
+			Help: "Total number of failed queries for canary samples.",
+		}),
+	}
+}
+
+// canary periodically writes a sample whose value is the time it was written
+// and polls the remote endpoint until the sample can be queried. This
+// measures the whole pipeline, including the WAL, remote_write and ingestion
+// by the endpoint, instead of inferring it from queue metrics.
+type canary struct {
+	logger  log.Logger
+	metrics *canaryMetrics
+	app     storage.Appendable
+	now     func() time.Time
+	updated chan struct{}
+
+	mut    sync.Mutex
+	cfg    CanaryConfig
+	series labels.Labels
+	query  string
+	client *http.Client
+}
+
+func newCanary(logger log.Logger, reg prometheus.Registerer, app storage.Appendable) *canary {
+	return &canary{
+		logger:  logger,
+		metrics: newCanaryMetrics(reg),
+		app:     app,
+		now:     time.Now,
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// SetConfig changes the settings of the canary. cfg may be nil to disable
+// the canary. externalLabels are the labels remote_write adds to the canary
+// series, which are used to find it again.
+func (c *canary) SetConfig(cfg *CanaryConfig, instanceName string, externalLabels labels.Labels) error {
+	var (
+		newCfg CanaryConfig
+		series labels.Labels
+		query  string
+		client *http.Client
+	)
+	if cfg != nil && cfg.Interval > 0 {
+		newCfg = *cfg
+		if newCfg.Timeout == 0 {
+			newCfg.Timeout = defaultCanaryTimeout
+		}
+		if newCfg.PollInterval == 0 {
+			newCfg.PollInterval = defaultCanaryPollInterval
+		}
+
+		var err error
+		client, err = config_util.NewClientFromConfig(cfg.HTTPClientConfig, "canary", false, false)
+		if err != nil {
+			return err
+		}
+
+		lb := labels.NewBuilder(nil)
+		for name, value := range cfg.Labels {
+			lb.Set(name, value)
+		}
+		lb.Set(model.MetricNameLabel, canaryMetricName)
+		lb.Set(canaryInstanceLabel, instanceName)
+		series = lb.Labels()
+		query = canarySelector(series, externalLabels)
+	}
+
+	c.mut.Lock()
+	c.cfg = newCfg
+	c.series = series
+	c.query = query
+	c.client = client
+	c.mut.Unlock()
+
+	select {
+	case c.updated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// canarySelector returns a selector matching the canary series after
+// remote_write added the external labels to it.
+func canarySelector(series, externalLabels labels.Labels) string {
+	matchers := make(map[string]string, len(series)+len(externalLabels))
+	for _, l := range externalLabels {
+		matchers[l.Name] = l.Value
+	}
+	// Labels of the series take precedence over external labels, just like
+	// they do in remote_write.
+	for _, l := range series {
+		if l.Name != model.MetricNameLabel {
+			matchers[l.Name] = l.Value
+		}
+	}
+
+	names := make([]string, 0, len(matchers))
+	for name := range matchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(canaryMetricName)
+	sb.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(name)
+		sb.WriteString("=")
+		sb.WriteString(strconv.Quote(matchers[name]))
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// Run writes and waits for canary samples every interval until ctx is
+// canceled.
+func (c *canary) Run(ctx context.Context) {
+	for {
+		c.mut.Lock()
+		interval := c.cfg.Interval
+		c.mut.Unlock()
+
+		var next <-chan time.Time
+		if interval > 0 {
+			next = time.After(interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.updated:
+		case <-next:
+			c.probe(ctx)
+		}
+	}
+}
+
+// probe writes a canary sample and waits for it to become queryable.
+func (c *canary) probe(ctx context.Context) {
+	c.mut.Lock()
+	var (
+		cfg    = c.cfg
+		series = c.series
+		query  = c.query
+		client = c.client
+	)
+	c.mut.Unlock()
+
+	if cfg.Interval == 0 {
+		return
+	}
+
+	written, err := c.write(ctx, series)
+	if err != nil {
+		c.metrics.writeFailures.Inc()
+		level.Warn(c.logger).Log("msg", "failed to write canary sample", "err", err)
+		return
+	}
+	c.metrics.writes.Inc()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				c.metrics.timeouts.Inc()
+				level.Warn(c.logger).Log("msg", "canary sample didn't become queryable in time", "timeout", cfg.Timeout)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		found, err := c.check(ctx, client, cfg, query, written)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.metrics.queryFailures.Inc()
+				level.Debug(c.logger).Log("msg", "failed to query canary sample", "err", err)
+			}
+			continue
+		}
+		if found {
+			latency := c.now().Sub(written)
+			c.metrics.latency.Observe(latency.Seconds())
+			c.metrics.lastLatency.Set(latency.Seconds())
+			return
+		}
+	}
+}
+
+// write appends a canary sample and returns the time it was written.
+func (c *canary) write(ctx context.Context, series labels.Labels) (time.Time, error) {
+	now := c.now()
+	ts := timestamp.FromTime(now)
+
+	app := c.app.Appender(ctx)
+	if _, err := app.Append(0, series, ts, float64(ts)/1000); err != nil {
+		_ = app.Rollback()
+		return time.Time{}, err
+	}
+	if err := app.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	return timestamp.Time(ts), nil
+}
+
+// canaryQueryResponse is the part of a Prometheus instant query response the
+// canary reads.
+type canaryQueryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Value [2]interface{} `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// check queries the remote endpoint for the canary series and reports
+// whether the sample written at written, or a newer one, was returned.
+func (c *canary) check(ctx context.Context, client *http.Client, cfg CanaryConfig, query string, written time.Time) (bool, error) {
+	u := *cfg.QueryURL.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1/query"
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatFloat(float64(timestamp.FromTime(c.now()))/1000, 'f', -1, 64))
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var qr canaryQueryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+		return false, fmt.Errorf("unexpected response with status %s: %w", resp.Status, err)
+	}
+	if qr.Status != "success" {
+		return false, fmt.Errorf("query failed with status %s: %s", resp.Status, qr.Error)
+	}
+	if qr.Data.ResultType != "vector" {
+		return false, fmt.Errorf("unexpected result type %q", qr.Data.ResultType)
+	}
+
+	want := float64(timestamp.FromTime(written)) / 1000
+	for _, r := range qr.Data.Result {
+		s, ok := r.Value[1].(string)
+		if !ok {
+			return false, errors.New("unexpected sample value")
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return false, err
+		}
+		if v >= want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,140 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanary(t *testing.T) {
+	var (
+		mut     sync.Mutex
+		written []float64
+		queries int
+	)
+
+	app := captureAppendable(&mut, &written)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/prometheus/api/v1/query", r.URL.Path)
+		require.Equal(t, `agent_canary_write_timestamp_seconds{agent_instance="test",cluster="dev",host="a"}`, r.URL.Query().Get("query"))
+		require.Equal(t, "tenant", r.Header.Get("X-Scope-OrgID"))
+
+		mut.Lock()
+		defer mut.Unlock()
+		queries++
+
+		// The sample only becomes queryable on the second query.
+		result := "[]"
+		if queries > 1 && len(written) > 0 {
+			result = fmt.Sprintf(`[{"metric":{},"value":[0,"%v"]}]`, written[len(written)-1])
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL + "/prometheus")
+	require.NoError(t, err)
+
+	start := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	c := newCanary(log.NewNopLogger(), prometheus.NewRegistry(), app)
+	c.now = func() time.Time {
+		mut.Lock()
+		defer mut.Unlock()
+		res := now
+		now = now.Add(time.Second)
+		return res
+	}
+
+	cfg := &CanaryConfig{
+		Interval:     time.Minute,
+		QueryURL:     &config_util.URL{URL: u},
+		PollInterval: 10 * time.Millisecond,
+		Labels:       map[string]string{"host": "a"},
+		Headers:      map[string]string{"X-Scope-OrgID": "tenant"},
+	}
+	require.NoError(t, c.SetConfig(cfg, "test", labels.FromStrings("cluster", "dev")))
+	c.probe(context.Background())
+
+	require.Equal(t, []float64{float64(start.Unix())}, written)
+	require.Equal(t, 2, queries)
+	require.Equal(t, 1.0, counterValue(t, c.metrics.writes))
+	require.Equal(t, 0.0, counterValue(t, c.metrics.timeouts))
+	// The clock advances by a second every time it's read: once for the
+	// write and once per query.
+	require.Equal(t, 3.0, gaugeValue(t, c.metrics.lastLatency))
+}
+
+func TestCanary_Timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	var mut sync.Mutex
+	app := captureAppendable(&mut, new([]float64))
+
+	c := newCanary(log.NewNopLogger(), prometheus.NewRegistry(), app)
+
+	// Nothing is written when the canary is disabled.
+	require.NoError(t, c.SetConfig(nil, "test", nil))
+	c.probe(context.Background())
+	require.Equal(t, 0.0, counterValue(t, c.metrics.writes))
+
+	cfg := &CanaryConfig{
+		Interval:     time.Minute,
+		QueryURL:     &config_util.URL{URL: u},
+		Timeout:      50 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+	}
+	require.NoError(t, c.SetConfig(cfg, "test", nil))
+	c.probe(context.Background())
+	require.Equal(t, 1.0, counterValue(t, c.metrics.writes))
+	require.Equal(t, 1.0, counterValue(t, c.metrics.timeouts))
+}
+
+// captureAppendable returns an Appendable that stores the values of
+// committed samples in written.
+func captureAppendable(mut *sync.Mutex, written *[]float64) storage.Appendable {
+	return appendableFunc(func(ctx context.Context) storage.Appender {
+		return &captureAppender{mut: mut, written: written}
+	})
+}
+
+type captureAppender struct {
+	storage.Appender
+	mut     *sync.Mutex
+	written *[]float64
+	pending []float64
+}
+
+func (a *captureAppender) Append(_ uint64, _ labels.Labels, _ int64, v float64) (uint64, error) {
+	a.pending = append(a.pending, v)
+	return 0, nil
+}
+
+func (a *captureAppender) Commit() error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	*a.written = append(*a.written, a.pending...)
+	return nil
+}
+
+func (a *captureAppender) Rollback() error { return nil }
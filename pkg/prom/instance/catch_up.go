@@ -0,0 +1,419 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/config"
+)
+
+// Defaults for CatchUpConfig.
+const (
+	defaultCatchUpEnterLag      = 5 * time.Minute
+	defaultCatchUpExitLag       = time.Minute
+	defaultCatchUpCheckInterval = 15 * time.Second
+)
+
+// CatchUpConfig configures the catch-up mode of remote_write. Once a
+// remote_write falls behind the WAL by more than EnterLag, for example after
+// its endpoint recovered from an outage, every remote_write of the instance
+// may send up to MaxShards concurrent requests at up to BytesPerSecond until
+// all of them are less than ExitLag behind. Outside of catch-up mode, a
+// remote_write sends at most queue_config.max_shards concurrent requests.
+type CatchUpConfig struct {
+	// Maximum number of concurrent requests of each remote_write in catch-up
+	// mode.
+	MaxShards int `yaml:"max_shards"`
+
+	// Bytes per second sent by all remote_writes of the instance in catch-up
+	// mode, replacing remote_write_bytes_per_second. 0 keeps
+	// remote_write_bytes_per_second.
+	BytesPerSecond int64 `yaml:"bytes_per_second,omitempty"`
+
+	// Lag above which catch-up mode starts. Defaults to 5m.
+	EnterLag time.Duration `yaml:"enter_lag,omitempty"`
+
+	// Lag below which catch-up mode stops. Defaults to 1m.
+	ExitLag time.Duration `yaml:"exit_lag,omitempty"`
+
+	// How often to check the lag of remote_writes. Defaults to 15s.
+	CheckInterval time.Duration `yaml:"check_interval,omitempty"`
+}
+
+// Validate returns an error if the config is invalid.
+func (c *CatchUpConfig) Validate() error {
+	switch {
+	case c.MaxShards <= 0:
+		return errors.New("remote_write_catch_up.max_shards must be greater than 0")
+	case c.BytesPerSecond < 0:
+		return errors.New("remote_write_catch_up.bytes_per_second must not be negative")
+	case c.EnterLag < 0:
+		return errors.New("remote_write_catch_up.enter_lag must not be negative")
+	case c.ExitLag < 0:
+		return errors.New("remote_write_catch_up.exit_lag must not be negative")
+	case c.CheckInterval < 0:
+		return errors.New("remote_write_catch_up.check_interval must not be negative")
+	case c.exitLag() > c.enterLag():
+		return errors.New("remote_write_catch_up.exit_lag must not be greater than enter_lag")
+	}
+	return nil
+}
+
+func (c *CatchUpConfig) enterLag() time.Duration {
+	if c.EnterLag == 0 {
+		return defaultCatchUpEnterLag
+	}
+	return c.EnterLag
+}
+
+func (c *CatchUpConfig) exitLag() time.Duration {
+	if c.ExitLag == 0 {
+		return defaultCatchUpExitLag
+	}
+	return c.ExitLag
+}
+
+func (c *CatchUpConfig) checkInterval() time.Duration {
+	if c.CheckInterval == 0 {
+		return defaultCatchUpCheckInterval
+	}
+	return c.CheckInterval
+}
+
+// lagSource returns the highest lag, in seconds, of the remote_writes named
+// remoteNames. ok is false if none of them sent samples yet.
+type lagSource func(remoteNames []string) (lag float64, ok bool, err error)
+
+// newInstanceLagSource returns a lagSource comparing the highest timestamp
+// written to the WAL with the highest timestamp sent by each remote_write,
+// from the remote_write metrics registered by the remote storage and
+// gathered from g.
+func newInstanceLagSource(g prometheus.Gatherer) lagSource {
+	return func(remoteNames []string) (float64, bool, error) {
+		families, err := g.Gather()
+		if err != nil {
+			return 0, false, err
+		}
+
+		names := make(map[string]bool, len(remoteNames))
+		for _, name := range remoteNames {
+			names[name] = true
+		}
+
+		var highest, sent []*dto.Metric
+		for _, family := range families {
+			switch family.GetName() {
+			case "prometheus_remote_storage_highest_timestamp_in_seconds":
+				highest = append(highest, family.GetMetric()...)
+			case "prometheus_remote_storage_queue_highest_sent_timestamp_seconds":
+				for _, m := range family.GetMetric() {
+					// Queues that didn't send samples yet have nothing to
+					// catch up on.
+					if names[labelValue(m, "remote_name")] && m.GetGauge().GetValue() > 0 {
+						sent = append(sent, m)
+					}
+				}
+			}
+		}
+
+		var lag float64
+		for _, s := range sent {
+			// The gatherer may hold the metrics of other instances, which
+			// are told apart by the labels the registerer of each instance
+			// adds to all of its metrics.
+			for _, h := range highest {
+				if !hasLabels(s, h.GetLabel()) {
+					continue
+				}
+				if l := h.GetGauge().GetValue() - s.GetGauge().GetValue(); l > lag {
+					lag = l
+				}
+			}
+		}
+		return lag, len(sent) > 0, nil
+	}
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// hasLabels returns true if m has all of labels.
+func hasLabels(m *dto.Metric, labels []*dto.LabelPair) bool {
+	for _, l := range labels {
+		if labelValue(m, l.GetName()) != l.GetValue() {
+			return false
+		}
+	}
+	return true
+}
+
+type catchUpMetrics struct {
+	catchingUp  prometheus.Gauge
+	transitions prometheus.Counter
+}
+
+func newCatchUpMetrics(reg prometheus.Registerer) *catchUpMetrics {
+	return &catchUpMetrics{
+		catchingUp: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "agent_prometheus_remote_write_catching_up",
+			Help: "Whether the remote_writes of the instance are in catch-up mode.",
+		}),
+		transitions: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_remote_write_catch_up_starts_total",
+			Help: "Total number of times the remote_writes of the instance entered catch-up mode.",
+		}),
+	}
+}
+
+// catchUpController switches the remote_writes of an instance in and out of
+// catch-up mode depending on how far they are behind the WAL.
+type catchUpController struct {
+	logger  log.Logger
+	metrics *catchUpMetrics
+	source  lagSource
+	apply   func(catchingUp bool)
+	updated chan struct{}
+
+	mut         sync.Mutex
+	cfg         *CatchUpConfig
+	remoteNames []string
+}
+
+// newCatchUpController creates a catchUpController. apply is called with
+// whether the remote_writes are catching up every time that changes.
+func newCatchUpController(logger log.Logger, reg prometheus.Registerer, source lagSource, apply func(catchingUp bool)) *catchUpController {
+	return &catchUpController{
+		logger:  logger,
+		metrics: newCatchUpMetrics(reg),
+		source:  source,
+		apply:   apply,
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// SetConfig changes the settings of the controller and the remote_writes to
+// check. A nil cfg disables catch-up mode.
+func (c *catchUpController) SetConfig(cfg *CatchUpConfig, remoteWrites []*config.RemoteWriteConfig) {
+	names := make([]string, 0, len(remoteWrites))
+	for _, rw := range remoteWrites {
+		names = append(names, rw.Name)
+	}
+
+	c.mut.Lock()
+	c.cfg = cfg
+	c.remoteNames = names
+	c.mut.Unlock()
+
+	select {
+	case c.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks the lag of the remote_writes every check interval until ctx is
+// canceled. Changing the config keeps the current mode.
+func (c *catchUpController) Run(ctx context.Context) {
+	var catchingUp bool
+
+	for {
+		c.mut.Lock()
+		var (
+			cfg         = c.cfg
+			remoteNames = c.remoteNames
+		)
+		c.mut.Unlock()
+
+		var next <-chan time.Time
+		if cfg != nil {
+			next = time.After(cfg.checkInterval())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.updated:
+			continue
+		case <-next:
+		}
+
+		lag, ok, err := c.source(remoteNames)
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to check remote_write lag", "err", err)
+			continue
+		} else if !ok {
+			continue
+		}
+
+		lagDuration := time.Duration(lag * float64(time.Second))
+		switch {
+		case !catchingUp && lagDuration > cfg.enterLag():
+			level.Info(c.logger).Log("msg", "remote_write is behind, entering catch-up mode", "lag", lagDuration, "max_shards", cfg.MaxShards, "bytes_per_second", cfg.BytesPerSecond)
+			catchingUp = true
+			c.metrics.transitions.Inc()
+			c.setCatchingUp(true)
+		case catchingUp && lagDuration < cfg.exitLag():
+			level.Info(c.logger).Log("msg", "remote_write caught up, leaving catch-up mode", "lag", lagDuration)
+			catchingUp = false
+			c.setCatchingUp(false)
+		}
+	}
+}
+
+func (c *catchUpController) setCatchingUp(catchingUp bool) {
+	c.apply(catchingUp)
+	c.metrics.catchingUp.Set(boolToFloat(catchingUp))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// withCatchUpShards returns copies of remoteWrites whose queues may run up to
+// the max_shards of cfg, so they can reach the catch-up ceiling. The steady
+// ceiling is enforced by the egress proxy instead, so remoteWrites using
+// their own proxy_url are returned unchanged. remoteWrites is returned
+// unchanged if cfg is nil.
+func withCatchUpShards(remoteWrites []*config.RemoteWriteConfig, cfg *CatchUpConfig) []*config.RemoteWriteConfig {
+	if cfg == nil {
+		return remoteWrites
+	}
+
+	res := make([]*config.RemoteWriteConfig, 0, len(remoteWrites))
+	for _, rw := range remoteWrites {
+		if rw.HTTPClientConfig.ProxyURL.URL != nil || cfg.MaxShards <= rw.QueueConfig.MaxShards {
+			res = append(res, rw)
+			continue
+		}
+
+		rwCopy := *rw
+		rwCopy.QueueConfig.MaxShards = cfg.MaxShards
+		res = append(res, &rwCopy)
+	}
+	return res
+}
+
+// shardCeilings returns the maximum number of concurrent requests to each
+// host of remoteWrites, keyed by host:port. Hosts of several remote_writes
+// get the sum of their ceilings. The ceiling of a remote_write is its
+// queue_config.max_shards, raised to the max_shards of catchUp if it's set.
+// remoteWrites using their own proxy_url don't go through the egress proxy
+// and are skipped.
+func shardCeilings(remoteWrites []*config.RemoteWriteConfig, catchUp *CatchUpConfig) map[string]int {
+	res := make(map[string]int, len(remoteWrites))
+	for _, rw := range remoteWrites {
+		if rw.URL == nil || rw.HTTPClientConfig.ProxyURL.URL != nil {
+			continue
+		}
+		ceiling := rw.QueueConfig.MaxShards
+		if catchUp != nil && catchUp.MaxShards > ceiling {
+			ceiling = catchUp.MaxShards
+		}
+		res[hostPort(rw.URL.URL)] += ceiling
+	}
+	return res
+}
+
+// hostPort returns the host of u with its port, using the default port of
+// its scheme if it has none.
+func hostPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// connGate limits the number of concurrent requests to each host.
+type connGate struct {
+	mut     sync.Mutex
+	limits  map[string]int
+	active  map[string]int
+	changed chan struct{}
+}
+
+func newConnGate() *connGate {
+	return &connGate{
+		active:  make(map[string]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// SetLimits changes the limits of every host, keyed by host:port. Hosts
+// without a limit aren't limited. Requests already running over a lowered
+// limit aren't interrupted.
+func (g *connGate) SetLimits(limits map[string]int) {
+	if g == nil {
+		return
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.limits = limits
+	g.broadcast()
+}
+
+// Acquire blocks until a request to host may run or ctx is canceled. Acquire
+// on a nil connGate returns immediately.
+func (g *connGate) Acquire(ctx context.Context, host string) error {
+	if g == nil {
+		return nil
+	}
+
+	for {
+		g.mut.Lock()
+		limit, ok := g.limits[host]
+		if !ok || g.active[host] < limit {
+			g.active[host]++
+			g.mut.Unlock()
+			return nil
+		}
+		changed := g.changed
+		g.mut.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Release marks a request to host acquired before as done.
+func (g *connGate) Release(host string) {
+	if g == nil {
+		return
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	if g.active[host]--; g.active[host] <= 0 {
+		delete(g.active, host)
+	}
+	g.broadcast()
+}
+
+// broadcast wakes up every Acquire waiting. g.mut must be held.
+func (g *connGate) broadcast() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
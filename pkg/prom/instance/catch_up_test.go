@@ -0,0 +1,178 @@
+package instance
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestCatchUpController(t *testing.T) {
+	var (
+		lag     = atomic.NewFloat64(0)
+		applied = make(chan bool, 10)
+	)
+	source := func(remoteNames []string) (float64, bool, error) {
+		require.Equal(t, []string{"write"}, remoteNames)
+		return lag.Load(), true, nil
+	}
+
+	c := newCatchUpController(log.NewNopLogger(), prometheus.NewRegistry(), source, func(catchingUp bool) {
+		applied <- catchingUp
+	})
+	c.SetConfig(&CatchUpConfig{
+		MaxShards:     10,
+		EnterLag:      time.Minute,
+		ExitLag:       10 * time.Second,
+		CheckInterval: 10 * time.Millisecond,
+	}, []*config.RemoteWriteConfig{{Name: "write"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	// Lag between exit_lag and enter_lag doesn't change the mode.
+	lag.Store(30)
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, applied)
+
+	lag.Store(120)
+	require.True(t, <-applied)
+	require.Equal(t, 1.0, gaugeValue(t, c.metrics.catchingUp))
+
+	lag.Store(30)
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, applied)
+
+	lag.Store(5)
+	require.False(t, <-applied)
+	require.Equal(t, 0.0, gaugeValue(t, c.metrics.catchingUp))
+	require.Equal(t, 1.0, counterValue(t, c.metrics.transitions))
+}
+
+func TestInstanceLagSource(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for _, inst := range []struct {
+		name            string
+		highest, sentTo float64
+	}{
+		{name: "a", highest: 1000, sentTo: 400},
+		{name: "b", highest: 5000, sentTo: 4990},
+	} {
+		instReg := prometheus.WrapRegistererWith(prometheus.Labels{"instance_name": inst.name}, reg)
+		promauto.With(instReg).NewGauge(prometheus.GaugeOpts{
+			Name: "prometheus_remote_storage_highest_timestamp_in_seconds",
+		}).Set(inst.highest)
+
+		sent := promauto.With(instReg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "prometheus_remote_storage_queue_highest_sent_timestamp_seconds",
+		}, []string{"remote_name", "url"})
+		sent.WithLabelValues(inst.name+"-write", "http://example.com").Set(inst.sentTo)
+		// Queues that didn't send yet are ignored.
+		sent.WithLabelValues(inst.name+"-new", "http://example.com").Set(0)
+	}
+
+	source := newInstanceLagSource(reg)
+
+	lag, ok, err := source([]string{"b-write", "b-new"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 10.0, lag)
+
+	lag, ok, err = source([]string{"a-write"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 600.0, lag)
+
+	_, ok, err = source([]string{"a-new"})
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestShardCeilings(t *testing.T) {
+	newRemoteWrite := func(rawURL string, maxShards int) *config.RemoteWriteConfig {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		rw := config.DefaultRemoteWriteConfig
+		rw.URL = &config_util.URL{URL: u}
+		rw.QueueConfig.MaxShards = maxShards
+		return &rw
+	}
+
+	proxied := newRemoteWrite("http://proxied.example.com/push", 5)
+	proxyURL, err := url.Parse("http://proxy.example.com:3128")
+	require.NoError(t, err)
+	proxied.HTTPClientConfig.ProxyURL = config_util.URL{URL: proxyURL}
+
+	remoteWrites := []*config.RemoteWriteConfig{
+		newRemoteWrite("https://a.example.com/push", 10),
+		newRemoteWrite("https://a.example.com/other", 2),
+		newRemoteWrite("http://b.example.com:9009/push", 50),
+		proxied,
+	}
+
+	require.Equal(t, map[string]int{
+		"a.example.com:443":  12,
+		"b.example.com:9009": 50,
+	}, shardCeilings(remoteWrites, nil))
+
+	// Catch-up mode doesn't lower ceilings above its max_shards.
+	require.Equal(t, map[string]int{
+		"a.example.com:443":  40,
+		"b.example.com:9009": 50,
+	}, shardCeilings(remoteWrites, &CatchUpConfig{MaxShards: 20}))
+
+	require.Equal(t, remoteWrites, withCatchUpShards(remoteWrites, nil))
+	res := withCatchUpShards(remoteWrites, &CatchUpConfig{MaxShards: 20})
+	require.Equal(t, 20, res[0].QueueConfig.MaxShards)
+	require.Equal(t, 20, res[1].QueueConfig.MaxShards)
+	require.Equal(t, 50, res[2].QueueConfig.MaxShards)
+	require.Same(t, proxied, res[3])
+	require.Equal(t, 10, remoteWrites[0].QueueConfig.MaxShards, "original config should not be modified")
+}
+
+func TestConnGate(t *testing.T) {
+	var nilGate *connGate
+	require.NoError(t, nilGate.Acquire(context.Background(), "a:80"))
+	nilGate.Release("a:80")
+
+	g := newConnGate()
+	g.SetLimits(map[string]int{"a:80": 1})
+
+	// Hosts without a limit aren't limited.
+	require.NoError(t, g.Acquire(context.Background(), "b:80"))
+	require.NoError(t, g.Acquire(context.Background(), "b:80"))
+
+	require.NoError(t, g.Acquire(context.Background(), "a:80"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, g.Acquire(ctx, "a:80"))
+
+	// Raising the limit lets waiting requests run.
+	acquired := make(chan error)
+	go func() { acquired <- g.Acquire(context.Background(), "a:80") }()
+	time.Sleep(10 * time.Millisecond)
+	g.SetLimits(map[string]int{"a:80": 2})
+	require.NoError(t, <-acquired)
+
+	// So does releasing a request.
+	go func() { acquired <- g.Acquire(context.Background(), "a:80") }()
+	time.Sleep(10 * time.Millisecond)
+	g.Release("a:80")
+	require.NoError(t, <-acquired)
+}
+
+func TestCatchUpConfig_Validate(t *testing.T) {
+	require.NoError(t, (&CatchUpConfig{MaxShards: 10}).Validate())
+	require.EqualError(t, (&CatchUpConfig{}).Validate(), "remote_write_catch_up.max_shards must be greater than 0")
+	require.EqualError(t, (&CatchUpConfig{MaxShards: 10, ExitLag: 10 * time.Minute}).Validate(), "remote_write_catch_up.exit_lag must not be greater than enter_lag")
+}
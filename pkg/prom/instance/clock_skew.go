@@ -0,0 +1,220 @@
+package instance
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+)
+
+// defaultClockSkewWarnThreshold is used when ClockSkewConfig.WarnThreshold
+// isn't set.
+const defaultClockSkewWarnThreshold = 30 * time.Second
+
+// ClockSkewConfig configures checking the clock of the agent against the
+// clocks of its remote_write endpoints.
+type ClockSkewConfig struct {
+	// How often to check the clock skew. 0 disables checking.
+	CheckInterval time.Duration `yaml:"check_interval,omitempty"`
+
+	// Skew above which a warning is logged. Defaults to 30s.
+	WarnThreshold time.Duration `yaml:"warn_threshold,omitempty"`
+}
+
+type clockSkewMetrics struct {
+	skew          *prometheus.GaugeVec
+	exceeded      *prometheus.GaugeVec
+	checks        *prometheus.CounterVec
+	checkFailures *prometheus.CounterVec
+}
+
+func newClockSkewMetrics(reg prometheus.Registerer) *clockSkewMetrics {
+	labels := []string{"remote_name", "url"}
+	return &clockSkewMetrics{
+		skew: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_prometheus_remote_write_clock_skew_seconds",
+			Help: "Difference between the clock of a remote_write endpoint and the clock of the agent as of the last check. Positive values mean the agent's clock is behind.",
+		}, labels),
+		exceeded: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_prometheus_remote_write_clock_skew_exceeded",
+			Help: "Whether the clock skew with a remote_write endpoint exceeded clock_skew.warn_threshold as of the last check.",
+		}, labels),
+		checks: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_remote_write_clock_skew_checks_total",
+			Help: "Total number of clock skew checks against a remote_write endpoint.",
+		}, labels),
+		checkFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_remote_write_clock_skew_check_failures_total",
+			Help: "Total number of clock skew checks against a remote_write endpoint that failed.",
+		}, labels),
+	}
+}
+
+// clockSkewChecker periodically estimates the clock skew between the agent
+// and its remote_write endpoints using the Date header of their responses.
+// Skewed clocks make endpoints reject samples as too old or too far in the
+// future, or store them at the wrong time.
+//
+// The Date header has a resolution of one second, so skew below a second
+// can't be detected.
+type clockSkewChecker struct {
+	logger  log.Logger
+	metrics *clockSkewMetrics
+	now     func() time.Time
+	updated chan struct{}
+
+	mut     sync.Mutex
+	cfg     ClockSkewConfig
+	targets []clockSkewTarget
+}
+
+type clockSkewTarget struct {
+	name    string
+	url     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+func newClockSkewChecker(logger log.Logger, reg prometheus.Registerer) *clockSkewChecker {
+	return &clockSkewChecker{
+		logger:  logger,
+		metrics: newClockSkewMetrics(reg),
+		now:     time.Now,
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// SetConfig changes the settings of the checker and the remote_write
+// endpoints to check.
+func (c *clockSkewChecker) SetConfig(cfg ClockSkewConfig, remoteWrites []*config.RemoteWriteConfig) error {
+	var targets []clockSkewTarget
+	if cfg.CheckInterval > 0 {
+		for _, rw := range remoteWrites {
+			if rw.URL == nil {
+				continue
+			}
+			client, err := config_util.NewClientFromConfig(rw.HTTPClientConfig, "clock_skew", false, false)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, clockSkewTarget{
+				name:    rw.Name,
+				url:     rw.URL.String(),
+				timeout: time.Duration(rw.RemoteTimeout),
+				client:  client,
+			})
+		}
+	}
+
+	c.mut.Lock()
+	c.cfg = cfg
+	c.targets = targets
+	c.mut.Unlock()
+
+	// Endpoints may have been removed, so drop the old results.
+	c.metrics.skew.Reset()
+	c.metrics.exceeded.Reset()
+
+	select {
+	case c.updated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run checks the clock skew every check interval until ctx is canceled.
+func (c *clockSkewChecker) Run(ctx context.Context) {
+	for {
+		c.mut.Lock()
+		interval := c.cfg.CheckInterval
+		c.mut.Unlock()
+
+		var next <-chan time.Time
+		if interval > 0 {
+			next = time.After(interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.updated:
+		case <-next:
+			c.checkAll(ctx)
+		}
+	}
+}
+
+func (c *clockSkewChecker) checkAll(ctx context.Context) {
+	c.mut.Lock()
+	var (
+		targets   = c.targets
+		threshold = c.cfg.WarnThreshold
+	)
+	c.mut.Unlock()
+
+	if threshold == 0 {
+		threshold = defaultClockSkewWarnThreshold
+	}
+
+	for _, t := range targets {
+		skew, err := c.check(ctx, t)
+		c.metrics.checks.WithLabelValues(t.name, t.url).Inc()
+		if err != nil {
+			c.metrics.checkFailures.WithLabelValues(t.name, t.url).Inc()
+			level.Debug(c.logger).Log("msg", "failed to check clock skew", "remote_name", t.name, "err", err)
+			continue
+		}
+
+		c.metrics.skew.WithLabelValues(t.name, t.url).Set(skew.Seconds())
+
+		exceeded := skew > threshold || skew < -threshold
+		if exceeded {
+			c.metrics.exceeded.WithLabelValues(t.name, t.url).Set(1)
+			level.Warn(c.logger).Log("msg", "clock skew with remote_write endpoint exceeds warn_threshold, samples may be rejected or stored with the wrong timestamp; check that the agent's clock is synchronized", "remote_name", t.name, "skew", skew, "warn_threshold", threshold)
+		} else {
+			c.metrics.exceeded.WithLabelValues(t.name, t.url).Set(0)
+		}
+	}
+}
+
+// check estimates the clock skew with a remote_write endpoint. Any response
+// with a Date header can be used, even if the request itself failed.
+func (c *clockSkewChecker) check(ctx context.Context, t clockSkewTarget) (time.Duration, error) {
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(http.MethodHead, t.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	start := c.now()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	end := c.now()
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, err
+	}
+
+	// Assume the endpoint read its clock halfway through the request and
+	// halfway through the second the Date header was truncated to.
+	local := start.Add(end.Sub(start) / 2)
+	remote := date.Add(500 * time.Millisecond)
+	return remote.Sub(local), nil
+}
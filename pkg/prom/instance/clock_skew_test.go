@@ -0,0 +1,56 @@
+package instance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockSkewChecker(t *testing.T) {
+	var (
+		local  = time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+		remote = local.Add(time.Minute)
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodHead, r.Method)
+		w.Header().Set("Date", remote.Format(http.TimeFormat))
+		// Errors are fine as long as the Date header is set.
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	rw := config.DefaultRemoteWriteConfig
+	rw.Name = "write"
+	rw.URL = &config_util.URL{URL: u}
+
+	c := newClockSkewChecker(log.NewNopLogger(), prometheus.NewRegistry())
+	c.now = func() time.Time { return local }
+
+	// Nothing is checked when check_interval isn't set.
+	require.NoError(t, c.SetConfig(ClockSkewConfig{}, []*config.RemoteWriteConfig{&rw}))
+	c.checkAll(context.Background())
+	require.Equal(t, 0.0, counterValue(t, c.metrics.checks.WithLabelValues("write", srv.URL)))
+
+	require.NoError(t, c.SetConfig(ClockSkewConfig{CheckInterval: time.Minute}, []*config.RemoteWriteConfig{&rw}))
+	c.checkAll(context.Background())
+	require.Equal(t, 1.0, counterValue(t, c.metrics.checks.WithLabelValues("write", srv.URL)))
+	require.Equal(t, 60.5, gaugeValue(t, c.metrics.skew.WithLabelValues("write", srv.URL)))
+	require.Equal(t, 1.0, gaugeValue(t, c.metrics.exceeded.WithLabelValues("write", srv.URL)))
+
+	// Skew under the threshold isn't reported as exceeded.
+	require.NoError(t, c.SetConfig(ClockSkewConfig{CheckInterval: time.Minute, WarnThreshold: 2 * time.Minute}, []*config.RemoteWriteConfig{&rw}))
+	c.checkAll(context.Background())
+	require.Equal(t, 0.0, gaugeValue(t, c.metrics.exceeded.WithLabelValues("write", srv.URL)))
+}
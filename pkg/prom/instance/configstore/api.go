@@ -24,9 +24,14 @@ type API struct {
 	store     Store
 	validator Validator
 
+	// authorizer decides which requests are allowed. Every request is allowed
+	// when it's nil.
+	authorizer Authorizer
+
 	totalCreatedConfigs prometheus.Counter
 	totalUpdatedConfigs prometheus.Counter
 	totalDeletedConfigs prometheus.Counter
+	totalDeniedRequests *prometheus.CounterVec
 }
 
 // Validator valides a config before putting it into the store.
@@ -52,9 +57,21 @@ func NewAPI(l log.Logger, store Store, v Validator) *API {
 			Name: "agent_prometheus_ha_configs_deleted_total",
 			Help: "Total number of deleted scraping service configs",
 		}),
+		totalDeniedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_ha_config_api_denied_requests_total",
+			Help: "Total number of requests to the scraping service config API that were not authorized",
+		}, []string{"action"}),
 	}
 }
 
+// SetAuthorizer sets the Authorizer that decides which requests are allowed.
+// A nil Authorizer allows every request.
+func (api *API) SetAuthorizer(a Authorizer) {
+	api.storeMut.Lock()
+	defer api.storeMut.Unlock()
+	api.authorizer = a
+}
+
 // WireAPI injects routes into the provided mux router for the config
 // store API.
 func (api *API) WireAPI(r *mux.Router) {
@@ -73,6 +90,7 @@ func (api *API) Describe(ch chan<- *prometheus.Desc) {
 	ch <- api.totalCreatedConfigs.Desc()
 	ch <- api.totalUpdatedConfigs.Desc()
 	ch <- api.totalDeletedConfigs.Desc()
+	api.totalDeniedRequests.Describe(ch)
 }
 
 // Collect implements prometheus.Collector.
@@ -80,12 +98,16 @@ func (api *API) Collect(mm chan<- prometheus.Metric) {
 	mm <- api.totalCreatedConfigs
 	mm <- api.totalUpdatedConfigs
 	mm <- api.totalDeletedConfigs
+	api.totalDeniedRequests.Collect(mm)
 }
 
 // ListConfigurations returns a list of configurations.
 func (api *API) ListConfigurations(rw http.ResponseWriter, r *http.Request) {
 	api.storeMut.Lock()
 	defer api.storeMut.Unlock()
+	if !api.authorize(rw, r, ActionRead) {
+		return
+	}
 	if api.store == nil {
 		api.writeError(rw, http.StatusNotFound, fmt.Errorf("no config store running"))
 		return
@@ -106,6 +128,9 @@ func (api *API) ListConfigurations(rw http.ResponseWriter, r *http.Request) {
 func (api *API) GetConfiguration(rw http.ResponseWriter, r *http.Request) {
 	api.storeMut.Lock()
 	defer api.storeMut.Unlock()
+	if !api.authorize(rw, r, ActionRead) {
+		return
+	}
 	if api.store == nil {
 		api.writeError(rw, http.StatusNotFound, fmt.Errorf("no config store running"))
 		return
@@ -141,6 +166,9 @@ func (api *API) GetConfiguration(rw http.ResponseWriter, r *http.Request) {
 func (api *API) PutConfiguration(rw http.ResponseWriter, r *http.Request) {
 	api.storeMut.Lock()
 	defer api.storeMut.Unlock()
+	if !api.authorize(rw, r, ActionWrite) {
+		return
+	}
 	if api.store == nil {
 		api.writeError(rw, http.StatusNotFound, fmt.Errorf("no config store running"))
 		return
@@ -202,6 +230,9 @@ func (api *API) PutConfiguration(rw http.ResponseWriter, r *http.Request) {
 func (api *API) DeleteConfiguration(rw http.ResponseWriter, r *http.Request) {
 	api.storeMut.Lock()
 	defer api.storeMut.Unlock()
+	if !api.authorize(rw, r, ActionWrite) {
+		return
+	}
 	if api.store == nil {
 		api.writeError(rw, http.StatusNotFound, fmt.Errorf("no config store running"))
 		return
@@ -227,6 +258,20 @@ func (api *API) DeleteConfiguration(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// authorize checks whether r may perform action, writing an error response
+// if it may not. storeMut must be held when calling authorize.
+func (api *API) authorize(rw http.ResponseWriter, r *http.Request, action Action) bool {
+	if api.authorizer == nil {
+		return true
+	}
+	if err := api.authorizer.Authorize(r, action); err != nil {
+		api.totalDeniedRequests.WithLabelValues(string(action)).Inc()
+		api.writeError(rw, http.StatusForbidden, err)
+		return false
+	}
+	return true
+}
+
 func (api *API) writeError(rw http.ResponseWriter, statusCode int, writeErr error) {
 	err := configapi.WriteError(rw, statusCode, writeErr)
 	if err != nil {
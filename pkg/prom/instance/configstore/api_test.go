@@ -91,6 +91,7 @@ func TestAPI_GetConfiguration(t *testing.T) {
 			return instance.Config{
 				Name:                key,
 				HostFilter:          true,
+				WALCompression:      true,
 				RemoteFlushDeadline: 10 * time.Minute,
 			}, nil
 		},
@@ -106,7 +107,7 @@ func TestAPI_GetConfiguration(t *testing.T) {
 	expect := `{
 		"status": "success",
 		"data": {
-			"value": "name: exists\nhost_filter: true\nremote_flush_deadline: 10m0s\n"
+			"value": "name: exists\nhost_filter: true\nwal_compression: true\nremote_flush_deadline: 10m0s\n"
 		}
 	}`
 	body, err := ioutil.ReadAll(resp.Body)
@@ -0,0 +1,143 @@
+package configstore
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+// Action is an operation performed through the config API.
+type Action string
+
+// Actions that may be performed through the config API.
+const (
+	// ActionRead lists and gets configs.
+	ActionRead Action = "read"
+	// ActionWrite creates, updates and deletes configs.
+	ActionWrite Action = "write"
+)
+
+// ErrReadOnly is returned when a write is attempted through a read-only
+// config API.
+var ErrReadOnly = errors.New("config API is read-only")
+
+// Authorizer decides whether a request to the config API may perform an
+// action. A nil error allows the request.
+type Authorizer interface {
+	Authorize(r *http.Request, action Action) error
+}
+
+// AuthorizerFunc implements Authorizer.
+type AuthorizerFunc func(r *http.Request, action Action) error
+
+// Authorize implements Authorizer.
+func (f AuthorizerFunc) Authorize(r *http.Request, action Action) error {
+	return f(r, action)
+}
+
+// AuthorizationConfig configures access to the config API.
+type AuthorizationConfig struct {
+	// Reject every request that would change configs. Configs can still be
+	// listed and read.
+	ReadOnly bool `yaml:"read_only"`
+
+	// Header holding the role of the caller. Roles aren't checked when empty.
+	RoleHeader string `yaml:"role_header"`
+
+	// Roles allowed to read and change configs. Any caller may read configs
+	// when ReadRoles is empty. Roles in WriteRoles may always read configs.
+	ReadRoles  []string `yaml:"read_roles,omitempty"`
+	WriteRoles []string `yaml:"write_roles,omitempty"`
+}
+
+// RegisterFlagsWithPrefix adds the flags required to configure access to the
+// config API to the given FlagSet.
+func (c *AuthorizationConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&c.ReadOnly, prefix+"read-only", false, "reject requests to the config API that would change configs")
+	f.StringVar(&c.RoleHeader, prefix+"role-header", "", "header holding the role of callers of the config API. Roles aren't checked when empty.")
+}
+
+// Validate returns an error if the config is invalid.
+func (c *AuthorizationConfig) Validate() error {
+	if c.RoleHeader == "" {
+		if len(c.ReadRoles) > 0 || len(c.WriteRoles) > 0 {
+			return errors.New("role_header must be set to use read_roles or write_roles")
+		}
+		return nil
+	}
+	if !validHeaderName(c.RoleHeader) {
+		return fmt.Errorf("invalid role_header %q", c.RoleHeader)
+	}
+	if len(c.WriteRoles) == 0 && !c.ReadOnly {
+		return errors.New("write_roles must not be empty when role_header is set, unless read_only is enabled")
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '_' || r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewAuthorizer creates an Authorizer from the config. NewAuthorizer returns
+// nil if the config allows every request.
+func NewAuthorizer(c AuthorizationConfig) Authorizer {
+	if !c.ReadOnly && c.RoleHeader == "" {
+		return nil
+	}
+
+	readRoles := roleSet(c.ReadRoles)
+	writeRoles := roleSet(c.WriteRoles)
+
+	return AuthorizerFunc(func(r *http.Request, action Action) error {
+		if action == ActionWrite && c.ReadOnly {
+			return ErrReadOnly
+		}
+		if c.RoleHeader == "" {
+			return nil
+		}
+
+		role := r.Header.Get(c.RoleHeader)
+		switch action {
+		case ActionRead:
+			if len(readRoles) == 0 {
+				return nil
+			}
+			if _, ok := readRoles[role]; ok {
+				return nil
+			}
+			if _, ok := writeRoles[role]; ok {
+				return nil
+			}
+		case ActionWrite:
+			if _, ok := writeRoles[role]; ok {
+				return nil
+			}
+		}
+
+		if role == "" {
+			return fmt.Errorf("%s header is required to %s configs", c.RoleHeader, action)
+		}
+		return fmt.Errorf("role %q may not %s configs", role, action)
+	})
+}
+
+func roleSet(roles []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(roles))
+	for _, r := range roles {
+		set[r] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,151 @@
+package configstore
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/client"
+	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizationConfig_Validate(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    AuthorizationConfig
+		expect string
+	}{
+		{name: "empty"},
+		{name: "read only", cfg: AuthorizationConfig{ReadOnly: true}},
+		{name: "roles", cfg: AuthorizationConfig{RoleHeader: "X-Agent-Role", WriteRoles: []string{"controller"}}},
+		{
+			name:   "roles without header",
+			cfg:    AuthorizationConfig{WriteRoles: []string{"controller"}},
+			expect: "role_header must be set to use read_roles or write_roles",
+		},
+		{
+			name:   "invalid header",
+			cfg:    AuthorizationConfig{RoleHeader: "X Agent Role", WriteRoles: []string{"controller"}},
+			expect: `invalid role_header "X Agent Role"`,
+		},
+		{
+			name:   "no writers",
+			cfg:    AuthorizationConfig{RoleHeader: "X-Agent-Role", ReadRoles: []string{"viewer"}},
+			expect: "write_roles must not be empty when role_header is set, unless read_only is enabled",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.expect == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expect)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizer(t *testing.T) {
+	require.Nil(t, NewAuthorizer(AuthorizationConfig{}), "authorizer should be nil when every request is allowed")
+
+	a := NewAuthorizer(AuthorizationConfig{
+		RoleHeader: "X-Agent-Role",
+		ReadRoles:  []string{"viewer"},
+		WriteRoles: []string{"controller"},
+	})
+
+	tt := []struct {
+		role   string
+		action Action
+		expect string
+	}{
+		{role: "viewer", action: ActionRead},
+		{role: "controller", action: ActionRead},
+		{role: "controller", action: ActionWrite},
+		{role: "viewer", action: ActionWrite, expect: `role "viewer" may not write configs`},
+		{role: "other", action: ActionRead, expect: `role "other" may not read configs`},
+		{role: "", action: ActionWrite, expect: "X-Agent-Role header is required to write configs"},
+	}
+	for _, tc := range tt {
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		if tc.role != "" {
+			r.Header.Set("X-Agent-Role", tc.role)
+		}
+
+		err = a.Authorize(r, tc.action)
+		if tc.expect == "" {
+			require.NoError(t, err, "role %q, action %s", tc.role, tc.action)
+		} else {
+			require.EqualError(t, err, tc.expect, "role %q, action %s", tc.role, tc.action)
+		}
+	}
+}
+
+func TestAPI_ReadOnly(t *testing.T) {
+	s := &Mock{
+		ListFunc: func(ctx context.Context) ([]string, error) {
+			return []string{"a"}, nil
+		},
+		PutFunc: func(ctx context.Context, c instance.Config) (created bool, err error) {
+			t.Fatal("put should not be called in read-only mode")
+			return false, nil
+		},
+		DeleteFunc: func(ctx context.Context, key string) error {
+			t.Fatal("delete should not be called in read-only mode")
+			return nil
+		},
+	}
+
+	api := NewAPI(log.NewNopLogger(), s, nil)
+	api.SetAuthorizer(NewAuthorizer(AuthorizationConfig{ReadOnly: true}))
+	env := newAPITestEnvironment(t, api)
+	cli := client.New(env.srv.URL)
+
+	configs, err := cli.ListConfigs(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, configs.Configs)
+
+	err = cli.PutConfiguration(context.Background(), "a", &instance.Config{Name: "a"})
+	require.EqualError(t, err, "config API is read-only")
+
+	err = cli.DeleteConfiguration(context.Background(), "a")
+	require.EqualError(t, err, "config API is read-only")
+}
+
+func TestAPI_RoleHeader(t *testing.T) {
+	var deleted []string
+	s := &Mock{
+		GetFunc: func(ctx context.Context, key string) (instance.Config, error) {
+			return instance.Config{Name: key}, nil
+		},
+		DeleteFunc: func(ctx context.Context, key string) error {
+			deleted = append(deleted, key)
+			return nil
+		},
+	}
+
+	api := NewAPI(log.NewNopLogger(), s, nil)
+	api.SetAuthorizer(NewAuthorizer(AuthorizationConfig{
+		RoleHeader: "X-Agent-Role",
+		WriteRoles: []string{"controller"},
+	}))
+	env := newAPITestEnvironment(t, api)
+
+	resp, err := http.Get(env.srv.URL + "/agent/api/v1/configs/a")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "anyone should be able to read configs without read_roles")
+	_ = resp.Body.Close()
+
+	cli := client.NewWithHeader(env.srv.URL, http.Header{"X-Agent-Role": []string{"viewer"}})
+	err = cli.DeleteConfiguration(context.Background(), "a")
+	require.EqualError(t, err, `role "viewer" may not write configs`)
+
+	cli = client.NewWithHeader(env.srv.URL, http.Header{"X-Agent-Role": []string{"controller"}})
+	require.NoError(t, cli.DeleteConfiguration(context.Background(), "a"))
+	require.Equal(t, []string{"a"}, deleted)
+}
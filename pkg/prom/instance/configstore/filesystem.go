@@ -0,0 +1,249 @@
+package configstore
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+)
+
+// FilesystemStore is the name of the kvstore store type that keeps configs
+// in a local directory.
+const FilesystemStore = "filesystem"
+
+// FilesystemConfig configures the filesystem KV store. The filesystem KV store
+// is only suitable for a single Agent, since it provides no coordination
+// between processes.
+type FilesystemConfig struct {
+	// Directory to store configs in.
+	Directory string `yaml:"directory"`
+
+	// How often to check the directory for changes when watching.
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// RegisterFlagsWithPrefix adds the flags required to configure the
+// filesystem KV store to the given FlagSet.
+func (c *FilesystemConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.StringVar(&c.Directory, prefix+"filesystem.directory", "", "directory to store configs in when the store is filesystem")
+	f.DurationVar(&c.PollInterval, prefix+"filesystem.poll-interval", 5*time.Second, "how often to check the directory for config changes when the store is filesystem")
+}
+
+// NewFilesystemClient returns a kv.Client that stores each key as a file in a
+// directory. Values are encoded with the provided codec. Changes are detected
+// for WatchKey and WatchPrefix by polling the directory.
+func NewFilesystemClient(cfg FilesystemConfig, codec codec.Codec) (kv.Client, error) {
+	if cfg.Directory == "" {
+		return nil, fmt.Errorf("directory must be set to use the filesystem store")
+	}
+	if cfg.PollInterval <= 0 {
+		return nil, fmt.Errorf("poll_interval must be greater than 0s")
+	}
+	if err := os.MkdirAll(cfg.Directory, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory for filesystem store: %w", err)
+	}
+
+	return &filesystemClient{
+		dir:   cfg.Directory,
+		poll:  cfg.PollInterval,
+		codec: codec,
+	}, nil
+}
+
+type filesystemClient struct {
+	// mut serializes writes so CAS is atomic within the process.
+	mut sync.Mutex
+
+	dir   string
+	poll  time.Duration
+	codec codec.Codec
+}
+
+// path returns the file used to store key. Keys are escaped so that they
+// can't refer to files outside of the directory.
+func (c *filesystemClient) path(key string) string {
+	return filepath.Join(c.dir, url.PathEscape(key))
+}
+
+// List implements kv.Client.
+func (c *filesystemClient) List(_ context.Context, prefix string) ([]string, error) {
+	raw, err := c.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Get implements kv.Client.
+func (c *filesystemClient) Get(_ context.Context, key string) (interface{}, error) {
+	bb, err := ioutil.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return c.codec.Decode(bb)
+}
+
+// Delete implements kv.Client.
+func (c *filesystemClient) Delete(_ context.Context, key string) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	err := os.Remove(c.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// CAS implements kv.Client.
+func (c *filesystemClient) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	in, err := c.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	// Writes are serialized by mut, so there's never a conflicting update to
+	// retry for.
+	out, _, err := f(in)
+	if err != nil || out == nil {
+		return err
+	}
+
+	bb, err := c.codec.Encode(out)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial value.
+	// Temporary files are kept in a subdirectory so they're never listed.
+	tmpDir := filepath.Join(c.dir, ".tmp")
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(tmpDir, url.PathEscape(key))
+	if err := ioutil.WriteFile(tmp, bb, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(key))
+}
+
+// WatchKey implements kv.Client.
+func (c *filesystemClient) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
+	c.WatchPrefix(ctx, key, func(k string, v interface{}) bool {
+		if k != key {
+			return true
+		}
+		return f(v)
+	})
+}
+
+// WatchPrefix implements kv.Client. f is called with the current value of
+// every key when the watch starts, and then for every key that changes. Deleted
+// keys are passed to f with a nil value.
+func (c *filesystemClient) WatchPrefix(ctx context.Context, prefix string, f func(string, interface{}) bool) {
+	ticker := time.NewTicker(c.poll)
+	defer ticker.Stop()
+
+	var prev map[string][]byte
+	for {
+		curr, err := c.readAll()
+		if err == nil {
+			if !c.notifyChanges(prefix, prev, curr, f) {
+				return
+			}
+			prev = curr
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// notifyChanges calls f for every key with the given prefix that differs
+// between prev and curr. It returns false if f asked to stop watching.
+func (c *filesystemClient) notifyChanges(prefix string, prev, curr map[string][]byte, f func(string, interface{}) bool) bool {
+	for key, bb := range curr {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if old, ok := prev[key]; ok && bytes.Equal(old, bb) {
+			continue
+		}
+
+		v, err := c.codec.Decode(bb)
+		if err != nil {
+			continue
+		}
+		if !f(key, v) {
+			return false
+		}
+	}
+
+	for key := range prev {
+		if _, ok := curr[key]; ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if !f(key, nil) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readAll reads the raw value of every key in the directory.
+func (c *filesystemClient) readAll() (map[string][]byte, error) {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(infos))
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		key, err := url.PathUnescape(info.Name())
+		if err != nil {
+			continue
+		}
+
+		bb, err := ioutil.ReadFile(filepath.Join(c.dir, info.Name()))
+		if os.IsNotExist(err) {
+			// Deleted since we read the directory.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		out[key] = bb
+	}
+	return out, nil
+}
@@ -0,0 +1,142 @@
+package configstore
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func newFilesystemRemote(t *testing.T) *Remote {
+	t.Helper()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "filesystem-kv")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	remote, err := NewRemote(log.NewNopLogger(), prometheus.NewRegistry(), RemoteConfig{
+		KVStore: kv.Config{
+			Store:  FilesystemStore,
+			Prefix: "configurations/",
+		},
+		Filesystem: FilesystemConfig{
+			Directory:    dir,
+			PollInterval: 10 * time.Millisecond,
+		},
+	}, true)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		err := remote.Close()
+		require.NoError(t, err)
+	})
+	return remote
+}
+
+func TestFilesystemClient(t *testing.T) {
+	remote := newFilesystemRemote(t)
+	ctx := context.Background()
+
+	created, err := remote.Put(ctx, instance.Config{Name: "hello/world"})
+	require.NoError(t, err)
+	require.True(t, created)
+
+	created, err = remote.Put(ctx, instance.Config{Name: "hello/world", HostFilter: true})
+	require.NoError(t, err)
+	require.False(t, created)
+
+	list, err := remote.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello/world"}, list)
+
+	cfg, err := remote.Get(ctx, "hello/world")
+	require.NoError(t, err)
+	require.True(t, cfg.HostFilter)
+
+	require.NoError(t, remote.Delete(ctx, "hello/world"))
+	require.Error(t, remote.Delete(ctx, "hello/world"))
+
+	list, err = remote.List(ctx)
+	require.NoError(t, err)
+	require.Empty(t, list)
+}
+
+func TestFilesystemClient_Watch(t *testing.T) {
+	remote := newFilesystemRemote(t)
+
+	_, err := remote.Put(context.Background(), instance.Config{Name: "watch"})
+	require.NoError(t, err)
+
+	select {
+	case cfg := <-remote.Watch():
+		require.Equal(t, "watch", cfg.Key)
+		require.NotNil(t, cfg.Config)
+		require.Equal(t, "watch", cfg.Config.Name)
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "failed to watch for config")
+	}
+
+	// Deletions should be sent with a nil config.
+	require.NoError(t, remote.Delete(context.Background(), "watch"))
+
+	select {
+	case cfg := <-remote.Watch():
+		require.Equal(t, "watch", cfg.Key)
+		require.Nil(t, cfg.Config)
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "failed to watch for deleted config")
+	}
+}
+
+func TestNewFilesystemClient_Validation(t *testing.T) {
+	_, err := NewFilesystemClient(FilesystemConfig{PollInterval: time.Second}, GetCodec())
+	require.EqualError(t, err, "directory must be set to use the filesystem store")
+
+	_, err = NewFilesystemClient(FilesystemConfig{Directory: os.TempDir()}, GetCodec())
+	require.EqualError(t, err, "poll_interval must be greater than 0s")
+}
+
+func TestNewKVClient_Filesystem(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "filesystem-kv")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	reg := prometheus.NewRegistry()
+	cli, err := NewKVClient(RemoteConfig{
+		KVStore: kv.Config{
+			Store:  FilesystemStore,
+			Prefix: "configurations/",
+		},
+		Filesystem: FilesystemConfig{
+			Directory:    dir,
+			PollInterval: 10 * time.Millisecond,
+		},
+	}, GetCodec(), reg)
+	require.NoError(t, err)
+
+	err = cli.CAS(context.Background(), "test", func(in interface{}) (out interface{}, retry bool, err error) {
+		return "name: test", false, nil
+	})
+	require.NoError(t, err)
+
+	// Keys are stored with the prefix of the KV config.
+	require.FileExists(t, filepath.Join(dir, url.PathEscape("configurations/test")))
+
+	list, err := cli.List(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"test"}, list)
+
+	// Requests are tracked like they are for other stores.
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, mfs, 1)
+	require.Equal(t, "cortex_kv_request_duration_seconds", mfs[0].GetName())
+}
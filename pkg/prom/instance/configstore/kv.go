@@ -0,0 +1,111 @@
+package configstore
+
+import (
+	"context"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/instrument"
+)
+
+// RemoteConfig configures the KV store used by a Remote store.
+type RemoteConfig struct {
+	KVStore kv.Config
+
+	// Used when KVStore.Store is FilesystemStore.
+	Filesystem FilesystemConfig
+}
+
+// NewKVClient creates a kv.Client for cfg. Unlike kv.NewClient, it supports
+// FilesystemStore. Like the stores of the KV library, keys of the filesystem
+// store are prefixed with cfg.KVStore.Prefix and its requests are tracked in
+// reg.
+func NewKVClient(cfg RemoteConfig, codec codec.Codec, reg prometheus.Registerer) (kv.Client, error) {
+	if cfg.KVStore.Store != FilesystemStore {
+		return kv.NewClient(cfg.KVStore, codec, reg)
+	}
+
+	client, err := NewFilesystemClient(cfg.Filesystem, codec)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KVStore.Prefix != "" {
+		client = kv.PrefixClient(client, cfg.KVStore.Prefix)
+	}
+	if reg == nil {
+		return client, nil
+	}
+	return newMetricsClient(FilesystemStore, client, reg), nil
+}
+
+// metricsClient tracks the duration of requests to a kv.Client with the same
+// metric as the stores of the KV library.
+type metricsClient struct {
+	c               kv.Client
+	requestDuration *instrument.HistogramCollector
+}
+
+func newMetricsClient(backend string, c kv.Client, reg prometheus.Registerer) kv.Client {
+	return &metricsClient{
+		c: c,
+		requestDuration: instrument.NewHistogramCollector(
+			promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: "cortex",
+				Name:      "kv_request_duration_seconds",
+				Help:      "Time spent on kv store requests.",
+				Buckets:   prometheus.DefBuckets,
+				ConstLabels: prometheus.Labels{
+					"type": backend,
+				},
+			}, []string{"operation", "status_code"}),
+		),
+	}
+}
+
+func (m *metricsClient) List(ctx context.Context, prefix string) ([]string, error) {
+	var result []string
+	err := instrument.CollectedRequest(ctx, "List", m.requestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		var err error
+		result, err = m.c.List(ctx, prefix)
+		return err
+	})
+	return result, err
+}
+
+func (m *metricsClient) Get(ctx context.Context, key string) (interface{}, error) {
+	var result interface{}
+	err := instrument.CollectedRequest(ctx, "GET", m.requestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		var err error
+		result, err = m.c.Get(ctx, key)
+		return err
+	})
+	return result, err
+}
+
+func (m *metricsClient) Delete(ctx context.Context, key string) error {
+	return instrument.CollectedRequest(ctx, "Delete", m.requestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		return m.c.Delete(ctx, key)
+	})
+}
+
+func (m *metricsClient) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	return instrument.CollectedRequest(ctx, "CAS", m.requestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		return m.c.CAS(ctx, key, f)
+	})
+}
+
+func (m *metricsClient) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
+	_ = instrument.CollectedRequest(ctx, "WatchKey", m.requestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		m.c.WatchKey(ctx, key, f)
+		return nil
+	})
+}
+
+func (m *metricsClient) WatchPrefix(ctx context.Context, prefix string, f func(string, interface{}) bool) {
+	_ = instrument.CollectedRequest(ctx, "WatchPrefix", m.requestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		m.c.WatchPrefix(ctx, prefix, f)
+		return nil
+	})
+}
@@ -35,7 +35,7 @@ type Remote struct {
 // and retrieve configs. If enable is true, the store will be immediately
 // connected to. Otherwise, it can be lazily loaded by enabling later through
 // a call to Remote.ApplyConfig.
-func NewRemote(l log.Logger, reg prometheus.Registerer, cfg kv.Config, enable bool) (*Remote, error) {
+func NewRemote(l log.Logger, reg prometheus.Registerer, cfg RemoteConfig, enable bool) (*Remote, error) {
 	cancelCtx, cancelFunc := context.WithCancel(context.Background())
 
 	r := &Remote{
@@ -58,7 +58,7 @@ func NewRemote(l log.Logger, reg prometheus.Registerer, cfg kv.Config, enable bo
 }
 
 // ApplyConfig applies the config for a kv client.
-func (r *Remote) ApplyConfig(cfg kv.Config, enable bool) error {
+func (r *Remote) ApplyConfig(cfg RemoteConfig, enable bool) error {
 	r.kvMut.Lock()
 	defer r.kvMut.Unlock()
 
@@ -74,7 +74,7 @@ func (r *Remote) ApplyConfig(cfg kv.Config, enable bool) error {
 		return nil
 	}
 
-	cli, err := kv.NewClient(cfg, GetCodec(), kv.RegistererWithKVName(r.reg, "agent_configs"))
+	cli, err := NewKVClient(cfg, GetCodec(), kv.RegistererWithKVName(r.reg, "agent_configs"))
 	if err != nil {
 		return fmt.Errorf("failed to create kv client: %w", err)
 	}
@@ -0,0 +1,203 @@
+package instance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/dns"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// DNSSDConfig controls how dns_sd_configs in the scrape_configs of an
+// instance are handled.
+type DNSSDConfig struct {
+	// How long the last discovered targets of a name are kept after lookups
+	// for it start returning no records. 0 removes the targets right away.
+	NegativeCacheDuration time.Duration `yaml:"negative_cache_duration,omitempty"`
+}
+
+type dnsSDMetrics struct {
+	lookups         *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+	negativeResults *prometheus.CounterVec
+	cacheHits       *prometheus.CounterVec
+	lastSuccess     *prometheus.GaugeVec
+	targets         *prometheus.GaugeVec
+}
+
+func newDNSSDMetrics(reg prometheus.Registerer) *dnsSDMetrics {
+	f := promauto.With(reg)
+	return &dnsSDMetrics{
+		lookups: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_sd_dns_lookups_total",
+			Help: "Total number of DNS-SD lookups for a name.",
+		}, []string{"name"}),
+		failures: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_sd_dns_lookup_failures_total",
+			Help: "Total number of DNS-SD lookups for a name that failed. Targets are kept when a lookup fails.",
+		}, []string{"name"}),
+		negativeResults: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_sd_dns_negative_results_total",
+			Help: "Total number of DNS-SD lookups for a name that returned no records.",
+		}, []string{"name"}),
+		cacheHits: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_sd_dns_negative_cache_hits_total",
+			Help: "Total number of DNS-SD lookups for a name that returned no records where the previous targets were kept.",
+		}, []string{"name"}),
+		lastSuccess: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_prometheus_sd_dns_last_success_timestamp_seconds",
+			Help: "Timestamp of the last successful DNS-SD lookup for a name.",
+		}, []string{"name"}),
+		targets: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_prometheus_sd_dns_targets",
+			Help: "Number of targets currently discovered through DNS-SD for a name.",
+		}, []string{"name"}),
+	}
+}
+
+// wrapDNSSDConfigs replaces the dns_sd_configs in cfgs with configs that
+// record per-name metrics and apply the negative cache of cfg.
+func wrapDNSSDConfigs(cfgs discovery.Configs, cfg DNSSDConfig, metrics *dnsSDMetrics) discovery.Configs {
+	res := make(discovery.Configs, 0, len(cfgs))
+	for _, c := range cfgs {
+		if dnsCfg, ok := c.(*dns.SDConfig); ok {
+			c = &dnsSDConfig{
+				SDConfig:              *dnsCfg,
+				negativeCacheDuration: cfg.NegativeCacheDuration,
+				metrics:               metrics,
+			}
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// dnsSDConfig wraps a dns.SDConfig to look up each name separately, so
+// failures and empty results can be tracked per name.
+type dnsSDConfig struct {
+	dns.SDConfig
+
+	negativeCacheDuration time.Duration
+	metrics               *dnsSDMetrics
+}
+
+func (c *dnsSDConfig) NewDiscoverer(opts discovery.DiscovererOptions) (discovery.Discoverer, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	d := &dnsDiscovery{}
+	for _, name := range c.Names {
+		cfg := c.SDConfig
+		cfg.Names = []string{name}
+
+		d.names = append(d.names, &dnsName{
+			name:                  name,
+			logger:                logger,
+			discoverer:            dns.NewDiscovery(cfg, logger),
+			negativeCacheDuration: c.negativeCacheDuration,
+			metrics:               c.metrics,
+		})
+	}
+	return d, nil
+}
+
+// dnsDiscovery runs a DNS-SD discoverer for each of its names.
+type dnsDiscovery struct {
+	names []*dnsName
+}
+
+func (d *dnsDiscovery) Run(ctx context.Context, up chan<- []*targetgroup.Group) {
+	var wg sync.WaitGroup
+	for _, n := range d.names {
+		wg.Add(1)
+		go func(n *dnsName) {
+			defer wg.Done()
+			n.run(ctx, up)
+		}(n)
+	}
+	wg.Wait()
+}
+
+// dnsName tracks the lookups for a single name.
+type dnsName struct {
+	name                  string
+	logger                log.Logger
+	discoverer            discovery.Discoverer
+	negativeCacheDuration time.Duration
+	metrics               *dnsSDMetrics
+
+	// last is the last non-empty target group sent for the name, and
+	// emptySince the time lookups started returning no records.
+	last       *targetgroup.Group
+	emptySince time.Time
+}
+
+func (n *dnsName) run(ctx context.Context, up chan<- []*targetgroup.Group) {
+	ch := make(chan []*targetgroup.Group)
+	go n.discoverer.Run(ctx, ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tgs := <-ch:
+			tg, ok := n.update(tgs, time.Now())
+			if !ok {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case up <- []*targetgroup.Group{tg}:
+			}
+		}
+	}
+}
+
+// update processes the result of a lookup at now. It returns the group to
+// send and true if the targets of the name should be updated.
+func (n *dnsName) update(tgs []*targetgroup.Group, now time.Time) (*targetgroup.Group, bool) {
+	n.metrics.lookups.WithLabelValues(n.name).Inc()
+
+	// The DNS discoverer doesn't send a group for a name when its lookup
+	// fails, leaving the previous targets in place.
+	if len(tgs) == 0 || tgs[0] == nil {
+		n.metrics.failures.WithLabelValues(n.name).Inc()
+		return nil, false
+	}
+	n.metrics.lastSuccess.WithLabelValues(n.name).Set(float64(now.Unix()))
+
+	tg := tgs[0]
+	if len(tg.Targets) > 0 {
+		n.last, n.emptySince = tg, time.Time{}
+		n.metrics.targets.WithLabelValues(n.name).Set(float64(len(tg.Targets)))
+		return tg, true
+	}
+
+	n.metrics.negativeResults.WithLabelValues(n.name).Inc()
+	if n.last != nil && n.negativeCacheDuration > 0 {
+		if n.emptySince.IsZero() {
+			n.emptySince = now
+		}
+		if now.Sub(n.emptySince) < n.negativeCacheDuration {
+			n.metrics.cacheHits.WithLabelValues(n.name).Inc()
+			return nil, false
+		}
+	}
+
+	if n.last != nil {
+		level.Debug(n.logger).Log("msg", "DNS-SD name returned no records, removing its targets", "name", n.name)
+	}
+	n.last, n.emptySince = nil, time.Time{}
+	n.metrics.targets.WithLabelValues(n.name).Set(0)
+	return tg, true
+}
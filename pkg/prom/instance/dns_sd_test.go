@@ -0,0 +1,111 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/dns"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapDNSSDConfigs(t *testing.T) {
+	metrics := newDNSSDMetrics(prometheus.NewRegistry())
+	cfgs := discovery.Configs{
+		discovery.StaticConfig{},
+		&dns.SDConfig{Names: []string{"a.example.com", "b.example.com"}, Type: "SRV"},
+	}
+
+	wrapped := wrapDNSSDConfigs(cfgs, DNSSDConfig{NegativeCacheDuration: time.Minute}, metrics)
+	require.Len(t, wrapped, 2)
+	require.Equal(t, cfgs[0], wrapped[0])
+
+	dnsCfg, ok := wrapped[1].(*dnsSDConfig)
+	require.True(t, ok)
+	require.Equal(t, "dns", dnsCfg.Name())
+	require.Equal(t, time.Minute, dnsCfg.negativeCacheDuration)
+
+	d, err := dnsCfg.NewDiscoverer(discovery.DiscovererOptions{})
+	require.NoError(t, err)
+	require.Len(t, d.(*dnsDiscovery).names, 2)
+}
+
+func TestDNSName_Update(t *testing.T) {
+	metrics := newDNSSDMetrics(prometheus.NewRegistry())
+	n := &dnsName{
+		name:                  "a.example.com",
+		logger:                log.NewNopLogger(),
+		negativeCacheDuration: time.Minute,
+		metrics:               metrics,
+	}
+
+	var (
+		now   = time.Unix(1000, 0)
+		full  = &targetgroup.Group{Source: n.name, Targets: []model.LabelSet{{model.AddressLabel: "a:80"}}}
+		empty = &targetgroup.Group{Source: n.name}
+	)
+
+	tg, ok := n.update([]*targetgroup.Group{full}, now)
+	require.True(t, ok)
+	require.Equal(t, full, tg)
+
+	// Failed lookups keep the previous targets.
+	_, ok = n.update(nil, now.Add(10*time.Second))
+	require.False(t, ok)
+	require.Equal(t, 1.0, counterValue(t, metrics.failures.WithLabelValues(n.name)))
+
+	// Empty results are cached for the negative cache duration...
+	_, ok = n.update([]*targetgroup.Group{empty}, now.Add(20*time.Second))
+	require.False(t, ok)
+	_, ok = n.update([]*targetgroup.Group{empty}, now.Add(70*time.Second))
+	require.False(t, ok)
+	require.Equal(t, 2.0, counterValue(t, metrics.cacheHits.WithLabelValues(n.name)))
+
+	// ...after which the targets are removed.
+	tg, ok = n.update([]*targetgroup.Group{empty}, now.Add(80*time.Second))
+	require.True(t, ok)
+	require.Equal(t, empty, tg)
+	require.Equal(t, 0.0, gaugeValue(t, metrics.targets.WithLabelValues(n.name)))
+
+	require.Equal(t, 5.0, counterValue(t, metrics.lookups.WithLabelValues(n.name)))
+	require.Equal(t, 3.0, counterValue(t, metrics.negativeResults.WithLabelValues(n.name)))
+}
+
+func TestDNSName_Update_NoCache(t *testing.T) {
+	n := &dnsName{
+		name:    "a.example.com",
+		logger:  log.NewNopLogger(),
+		metrics: newDNSSDMetrics(prometheus.NewRegistry()),
+	}
+
+	full := &targetgroup.Group{Source: n.name, Targets: []model.LabelSet{{model.AddressLabel: "a:80"}}}
+	_, ok := n.update([]*targetgroup.Group{full}, time.Unix(1000, 0))
+	require.True(t, ok)
+
+	// Without a negative cache, empty results remove targets right away.
+	empty := &targetgroup.Group{Source: n.name}
+	tg, ok := n.update([]*targetgroup.Group{empty}, time.Unix(1010, 0))
+	require.True(t, ok)
+	require.Equal(t, empty, tg)
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	require.NoError(t, c.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	require.NoError(t, g.Write(&m))
+	return m.GetGauge().GetValue()
+}
@@ -0,0 +1,80 @@
+package instance
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// EgressLimiter limits the rate bytes are sent to remote_write endpoints.
+// Bytes over the limit are delayed rather than dropped, so remote_write
+// queues back up and keep reading from the WAL at the limited rate.
+//
+// An EgressLimiter may be shared between instances to enforce a limit across
+// all of them.
+type EgressLimiter struct {
+	limiter *rate.Limiter
+}
+
+// NewEgressLimiter creates a new EgressLimiter. A limit of 0 or less allows
+// any rate.
+func NewEgressLimiter(bytesPerSecond int64) *EgressLimiter {
+	l := &EgressLimiter{limiter: rate.NewLimiter(rate.Inf, 0)}
+	l.SetLimit(bytesPerSecond)
+	return l
+}
+
+// SetLimit changes the limit. Bytes waiting to be sent are delayed according
+// to the new limit.
+func (l *EgressLimiter) SetLimit(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		l.limiter.SetLimit(rate.Inf)
+		return
+	}
+	// Allow up to a second's worth of bytes to be sent at once.
+	l.limiter.SetBurst(int(bytesPerSecond))
+	l.limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// Limited returns true if l is non-nil and has a limit.
+func (l *EgressLimiter) Limited() bool {
+	return l != nil && l.limiter.Limit() != rate.Inf
+}
+
+// WaitN blocks until n bytes may be sent or ctx is canceled. WaitN on a nil
+// EgressLimiter returns immediately.
+func (l *EgressLimiter) WaitN(ctx context.Context, n int) error {
+	if !l.Limited() {
+		return nil
+	}
+	for n > 0 {
+		chunk := n
+		if burst := l.limiter.Burst(); chunk > burst {
+			chunk = burst
+		}
+		if err := l.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// limitedReader is an io.Reader that waits on each of its limiters for the
+// bytes read before returning them.
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*EgressLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		if waitErr := l.WaitN(r.ctx, n); waitErr != nil {
+			return 0, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,227 @@
+package instance
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+)
+
+// egressProxy is an HTTP proxy listening on localhost which limits the rate
+// requests are sent through it. Prometheus doesn't allow changing the
+// transport of remote_write clients, so remote_writes are rate limited by
+// setting their proxy_url to the egressProxy.
+//
+// HTTPS requests are tunneled with CONNECT, so TLS is still negotiated
+// between the remote_write client and its endpoint. Only bytes sent to the
+// endpoint are limited; responses are passed through as-is.
+//
+// The egressProxy also limits the number of concurrent requests to each
+// endpoint, which is how shard ceilings of remote_write catch-up mode are
+// enforced. remote_write clients don't use HTTP/2, so every concurrent
+// request needs its own connection, and HTTPS requests are limited by
+// limiting the number of open tunnels. Idle connections kept by the clients
+// count against the limit until they're closed.
+//
+// The egressProxy is also used to inject faults into remote_write requests.
+// Faults can only be injected into plain HTTP requests.
+type egressProxy struct {
+	log      log.Logger
+	limiters []*EgressLimiter
+	gate     *connGate
+	faults   *remoteWriteFaults
+	url      *url.URL
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	srv       *http.Server
+	fwd       *httputil.ReverseProxy
+	transport *http.Transport
+	dialer    net.Dialer
+
+	mut     sync.Mutex
+	tunnels map[net.Conn]struct{}
+}
+
+// newEgressProxy starts an egressProxy. Requests sent through it wait on each
+// of limiters and on gate. Nil limiters are ignored. gate and faults may be
+// nil.
+func newEgressProxy(l log.Logger, limiters []*EgressLimiter, gate *connGate, faults *remoteWriteFaults) (*egressProxy, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &egressProxy{
+		log:      l,
+		limiters: limiters,
+		gate:     gate,
+		faults:   faults,
+		url:      &url.URL{Scheme: "http", Host: lis.Addr().String()},
+
+		ctx:     ctx,
+		cancel:  cancel,
+		dialer:  net.Dialer{Timeout: 30 * time.Second},
+		tunnels: make(map[net.Conn]struct{}),
+	}
+	p.transport = &http.Transport{
+		DialContext:         p.dialer.DialContext,
+		MaxIdleConnsPerHost: 1000,
+		IdleConnTimeout:     5 * time.Minute,
+		DisableCompression:  true,
+	}
+	p.fwd = &httputil.ReverseProxy{
+		// Requests to proxies use absolute URLs, so the request already points
+		// to the endpoint.
+		Director: func(r *http.Request) {
+			if r.Body != nil {
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{&limitedReader{ctx: ctx, r: r.Body, limiters: limiters}, r.Body}
+			}
+		},
+		Transport: p.transport,
+	}
+	p.srv = &http.Server{Handler: p}
+
+	go func() {
+		if err := p.srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			level.Error(l).Log("msg", "remote_write egress proxy stopped", "err", err)
+		}
+	}()
+	return p, nil
+}
+
+// URL returns the URL of the proxy.
+func (p *egressProxy) URL() *url.URL { return p.url }
+
+// ServeHTTP implements http.Handler.
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		if p.faults.intercept(w, r) {
+			return
+		}
+
+		host := hostPort(r.URL)
+		if err := p.gate.Acquire(r.Context(), host); err != nil {
+			return
+		}
+		defer p.gate.Release(host)
+
+		p.fwd.ServeHTTP(w, r)
+		return
+	}
+
+	// The request is canceled if the client gives up waiting for the tunnel.
+	if err := p.gate.Acquire(r.Context(), r.Host); err != nil {
+		return
+	}
+	defer p.gate.Release(r.Host)
+
+	target, err := p.dialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		target.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		target.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !p.track(client, target) {
+		return
+	}
+	defer p.untrack(client, target)
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+
+	// Closing either side stops copying in both directions.
+	go func() {
+		_, _ = io.Copy(client, target)
+		client.Close()
+		target.Close()
+	}()
+	// buf may hold bytes the client sent after the CONNECT request.
+	_, _ = io.Copy(target, &limitedReader{ctx: p.ctx, r: buf, limiters: p.limiters})
+}
+
+// track records tunneled connections so they can be closed when the proxy
+// stops. Returns false and closes the connections if the proxy is stopped.
+func (p *egressProxy) track(conns ...net.Conn) bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if p.ctx.Err() != nil {
+		for _, c := range conns {
+			c.Close()
+		}
+		return false
+	}
+	for _, c := range conns {
+		p.tunnels[c] = struct{}{}
+	}
+	return true
+}
+
+func (p *egressProxy) untrack(conns ...net.Conn) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+		delete(p.tunnels, c)
+	}
+}
+
+// Close stops the proxy and closes all connections going through it.
+func (p *egressProxy) Close() error {
+	p.mut.Lock()
+	p.cancel()
+	for c := range p.tunnels {
+		c.Close()
+	}
+	p.mut.Unlock()
+
+	err := p.srv.Close()
+	p.transport.CloseIdleConnections()
+	return err
+}
+
+// remoteWrites returns copies of remoteWrites that send requests through the
+// proxy. remoteWrites that already use a proxy_url are returned unchanged and
+// won't be rate limited.
+func (p *egressProxy) remoteWrites(remoteWrites []*config.RemoteWriteConfig) []*config.RemoteWriteConfig {
+	res := make([]*config.RemoteWriteConfig, 0, len(remoteWrites))
+	for _, rw := range remoteWrites {
+		if rw.HTTPClientConfig.ProxyURL.URL != nil {
+			level.Warn(p.log).Log("msg", "remote_write uses a proxy_url and won't be rate limited", "remote_write", rw.Name)
+			res = append(res, rw)
+			continue
+		}
+
+		rwCopy := *rw
+		rwCopy.HTTPClientConfig.ProxyURL = config_util.URL{URL: p.url}
+		res = append(res, &rwCopy)
+	}
+	return res
+}
@@ -0,0 +1,145 @@
+package instance
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestEgressLimiter(t *testing.T) {
+	var nilLimiter *EgressLimiter
+	require.False(t, nilLimiter.Limited())
+	require.NoError(t, nilLimiter.WaitN(context.Background(), 1<<30))
+
+	l := NewEgressLimiter(0)
+	require.False(t, l.Limited())
+	require.NoError(t, l.WaitN(context.Background(), 1<<30))
+
+	// Sending 1.5 seconds' worth of bytes must be delayed.
+	l.SetLimit(1 << 20)
+	require.True(t, l.Limited())
+	start := time.Now()
+	require.NoError(t, l.WaitN(context.Background(), 3<<19))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(400*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, l.WaitN(ctx, 1<<20))
+}
+
+func TestEgressProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(strconv.Itoa(len(body))))
+	})
+	plainSrv := httptest.NewServer(handler)
+	defer plainSrv.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	p, err := newEgressProxy(log.NewNopLogger(), []*EgressLimiter{NewEgressLimiter(1 << 20), nil}, nil, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	for _, srv := range []*httptest.Server{plainSrv, tlsSrv} {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(p.URL())
+		client := &http.Client{Transport: transport}
+
+		resp, err := client.Post(srv.URL, "text/plain", strings.NewReader(strings.Repeat("a", 1000)))
+		require.NoError(t, err, srv.URL)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "1000", string(body))
+
+		transport.CloseIdleConnections()
+	}
+}
+
+func TestEgressProxy_RemoteWrites(t *testing.T) {
+	p, err := newEgressProxy(log.NewNopLogger(), nil, nil, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	userProxy, err := url.Parse("http://proxy.example.com:3128")
+	require.NoError(t, err)
+
+	direct := &config.RemoteWriteConfig{Name: "direct"}
+	proxied := &config.RemoteWriteConfig{Name: "proxied"}
+	proxied.HTTPClientConfig.ProxyURL = config_util.URL{URL: userProxy}
+
+	res := p.remoteWrites([]*config.RemoteWriteConfig{direct, proxied})
+	require.Len(t, res, 2)
+	require.Equal(t, p.URL(), res[0].HTTPClientConfig.ProxyURL.URL)
+	require.Nil(t, direct.HTTPClientConfig.ProxyURL.URL, "original config should not be modified")
+	require.Same(t, proxied, res[1])
+}
+
+func TestEgressProxy_Gate(t *testing.T) {
+	var (
+		active    atomic.Int32
+		maxActive atomic.Int32
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Inc()
+		defer active.Dec()
+		for {
+			prev := maxActive.Load()
+			if n <= prev || maxActive.CAS(prev, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	})
+	plainSrv := httptest.NewServer(handler)
+	defer plainSrv.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	for _, srv := range []*httptest.Server{plainSrv, tlsSrv} {
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+
+		gate := newConnGate()
+		gate.SetLimits(map[string]int{hostPort(u): 1})
+		p, err := newEgressProxy(log.NewNopLogger(), nil, gate, nil)
+		require.NoError(t, err)
+
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(p.URL())
+		client := &http.Client{Transport: transport}
+
+		maxActive.Store(0)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Get(srv.URL)
+				require.NoError(t, err, srv.URL)
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), maxActive.Load(), srv.URL)
+
+		transport.CloseIdleConnections()
+		require.NoError(t, p.Close())
+	}
+}
@@ -42,3 +42,18 @@ type errImmutableField struct{ Field string }
 func (e errImmutableField) Error() string {
 	return fmt.Sprintf("%s cannot be changed dynamically", e.Field)
 }
+
+// ErrUnhealthy is returned by Run when the instance failed too many
+// self_healing checks in a row. The instance should be recreated.
+type ErrUnhealthy struct {
+	// Reason of the last failed check.
+	Reason string
+
+	// Recovered is true if the instance passed a check before failing.
+	Recovered bool
+}
+
+// Error implements the error interface.
+func (e ErrUnhealthy) Error() string {
+	return fmt.Sprintf("instance is unhealthy: %s", e.Reason)
+}
@@ -0,0 +1,191 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// FaultInjectionConfig configures faults injected into an instance, so its
+// retry, truncation and backpressure behavior can be tested. It's only
+// accepted by agents built with the faultinjection build tag.
+//
+// Faults are injected on a fixed schedule rather than randomly so test
+// scenarios are deterministic.
+type FaultInjectionConfig struct {
+	RemoteWrite *RemoteWriteFaultsConfig `yaml:"remote_write,omitempty"`
+	WAL         *WALFaultsConfig         `yaml:"wal,omitempty"`
+}
+
+// RemoteWriteFaultsConfig configures faults injected into requests to
+// remote_write endpoints.
+type RemoteWriteFaultsConfig struct {
+	// FailEvery fails every nth request with StatusCode instead of sending it.
+	// 0 disables failures.
+	FailEvery  int `yaml:"fail_every,omitempty"`
+	StatusCode int `yaml:"status_code,omitempty"`
+
+	// Latency is added to every request before it's sent.
+	Latency time.Duration `yaml:"latency,omitempty"`
+}
+
+// DefaultRemoteWriteFaultsConfig holds the default settings for
+// RemoteWriteFaultsConfig.
+var DefaultRemoteWriteFaultsConfig = RemoteWriteFaultsConfig{
+	StatusCode: http.StatusServiceUnavailable,
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *RemoteWriteFaultsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRemoteWriteFaultsConfig
+
+	type plain RemoteWriteFaultsConfig
+	return unmarshal((*plain)(c))
+}
+
+// WALFaultsConfig configures faults injected into writes to the WAL.
+type WALFaultsConfig struct {
+	// FailEvery fails every nth commit of appended samples. Failed commits
+	// are rolled back. 0 disables failures.
+	FailEvery int `yaml:"fail_every,omitempty"`
+
+	// CommitLatency is added to every commit to simulate a slow disk.
+	CommitLatency time.Duration `yaml:"commit_latency,omitempty"`
+}
+
+// ErrInjectedFault is returned by operations failed by fault injection.
+var ErrInjectedFault = errors.New("injected fault")
+
+// validate validates c against the remote_writes of the instance.
+func (c *FaultInjectionConfig) validate(remoteWrites []*config.RemoteWriteConfig) error {
+	if !faultInjectionEnabled {
+		return errors.New("fault_injection requires an agent built with the faultinjection build tag")
+	}
+
+	if rw := c.RemoteWrite; rw != nil {
+		switch {
+		case rw.FailEvery < 0:
+			return errors.New("fault_injection.remote_write.fail_every must not be negative")
+		case rw.StatusCode < 100 || rw.StatusCode > 599:
+			return fmt.Errorf("fault_injection.remote_write.status_code %d is not a valid HTTP status code", rw.StatusCode)
+		case rw.Latency < 0:
+			return errors.New("fault_injection.remote_write.latency must not be negative")
+		}
+
+		// Requests to https endpoints are tunneled through the egress proxy,
+		// so responses can't be injected into them.
+		for _, cfg := range remoteWrites {
+			if cfg.URL != nil && !strings.EqualFold(cfg.URL.Scheme, "http") {
+				return fmt.Errorf("fault_injection.remote_write requires http remote_write endpoints, but %q uses %s", cfg.Name, cfg.URL.Scheme)
+			}
+		}
+	}
+
+	if wal := c.WAL; wal != nil {
+		switch {
+		case wal.FailEvery < 0:
+			return errors.New("fault_injection.wal.fail_every must not be negative")
+		case wal.CommitLatency < 0:
+			return errors.New("fault_injection.wal.commit_latency must not be negative")
+		}
+	}
+	return nil
+}
+
+// faultSchedule decides which of a series of operations fail. It's safe for
+// concurrent use.
+type faultSchedule struct {
+	every int
+
+	mut   sync.Mutex
+	count int
+}
+
+// fail returns true if the next operation should fail.
+func (s *faultSchedule) fail() bool {
+	if s.every <= 0 {
+		return false
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.count++
+	return s.count%s.every == 0
+}
+
+// remoteWriteFaults injects faults into requests sent through an egressProxy.
+type remoteWriteFaults struct {
+	cfg      RemoteWriteFaultsConfig
+	schedule *faultSchedule
+}
+
+func newRemoteWriteFaults(cfg RemoteWriteFaultsConfig) *remoteWriteFaults {
+	return &remoteWriteFaults{
+		cfg:      cfg,
+		schedule: &faultSchedule{every: cfg.FailEvery},
+	}
+}
+
+// intercept injects faults into r. Returns true if a response was written to
+// w and r must not be sent. intercept on a nil remoteWriteFaults does
+// nothing.
+func (f *remoteWriteFaults) intercept(w http.ResponseWriter, r *http.Request) bool {
+	if f == nil {
+		return false
+	}
+
+	if f.cfg.Latency > 0 {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(f.cfg.Latency):
+		}
+	}
+	if f.schedule.fail() {
+		http.Error(w, ErrInjectedFault.Error(), f.cfg.StatusCode)
+		return true
+	}
+	return false
+}
+
+// faultyWAL is a walStorage which injects faults into commits of appended
+// samples.
+type faultyWAL struct {
+	walStorage
+	cfg      WALFaultsConfig
+	schedule *faultSchedule
+}
+
+func newFaultyWAL(wal walStorage, cfg WALFaultsConfig) *faultyWAL {
+	return &faultyWAL{
+		walStorage: wal,
+		cfg:        cfg,
+		schedule:   &faultSchedule{every: cfg.FailEvery},
+	}
+}
+
+func (w *faultyWAL) Appender(ctx context.Context) storage.Appender {
+	return &faultyAppender{Appender: w.walStorage.Appender(ctx), wal: w}
+}
+
+type faultyAppender struct {
+	storage.Appender
+	wal *faultyWAL
+}
+
+func (a *faultyAppender) Commit() error {
+	if a.wal.cfg.CommitLatency > 0 {
+		time.Sleep(a.wal.cfg.CommitLatency)
+	}
+	if a.wal.schedule.fail() {
+		_ = a.Appender.Rollback()
+		return ErrInjectedFault
+	}
+	return a.Appender.Commit()
+}
@@ -0,0 +1,7 @@
+// +build !faultinjection
+
+package instance
+
+// faultInjectionEnabled allows instances to use fault_injection. Production
+// builds reject it so faults can't be enabled by accident.
+const faultInjectionEnabled = false
@@ -0,0 +1,6 @@
+// +build faultinjection
+
+package instance
+
+// faultInjectionEnabled allows instances to use fault_injection.
+const faultInjectionEnabled = true
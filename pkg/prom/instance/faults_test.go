@@ -0,0 +1,119 @@
+package instance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestFaultInjectionConfig_RequiresBuildTag(t *testing.T) {
+	if faultInjectionEnabled {
+		t.Skip("agent built with the faultinjection build tag")
+	}
+
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.FaultInjection = &FaultInjectionConfig{WAL: &WALFaultsConfig{FailEvery: 2}}
+
+	global := DefaultGlobalConfig
+	err := cfg.ApplyDefaults(&global)
+	require.EqualError(t, err, "fault_injection requires an agent built with the faultinjection build tag")
+}
+
+func TestFaultInjectionConfig_Validate(t *testing.T) {
+	if !faultInjectionEnabled {
+		t.Skip("requires the faultinjection build tag")
+	}
+
+	remoteWrites := []*config.RemoteWriteConfig{{Name: "secure", URL: mustParseURL(t, "https://example.com/push")}}
+
+	c := FaultInjectionConfig{WAL: &WALFaultsConfig{FailEvery: 2}}
+	require.NoError(t, c.validate(remoteWrites))
+
+	c = FaultInjectionConfig{RemoteWrite: &RemoteWriteFaultsConfig{StatusCode: 42}}
+	require.EqualError(t, c.validate(nil), "fault_injection.remote_write.status_code 42 is not a valid HTTP status code")
+
+	c = FaultInjectionConfig{RemoteWrite: &DefaultRemoteWriteFaultsConfig}
+	require.EqualError(t, c.validate(remoteWrites), `fault_injection.remote_write requires http remote_write endpoints, but "secure" uses https`)
+}
+
+func mustParseURL(t *testing.T, u string) *config_util.URL {
+	t.Helper()
+	parsed, err := url.Parse(u)
+	require.NoError(t, err)
+	return &config_util.URL{URL: parsed}
+}
+
+func TestFaultInjectionConfig_UnmarshalYAML(t *testing.T) {
+	in := `
+remote_write:
+  fail_every: 3
+wal:
+  commit_latency: 1s
+`
+	var c FaultInjectionConfig
+	require.NoError(t, yaml.UnmarshalStrict([]byte(in), &c))
+	require.Equal(t, 3, c.RemoteWrite.FailEvery)
+	require.Equal(t, http.StatusServiceUnavailable, c.RemoteWrite.StatusCode)
+	require.Equal(t, "1s", c.WAL.CommitLatency.String())
+}
+
+func TestFaultSchedule(t *testing.T) {
+	s := &faultSchedule{every: 3}
+	var fails []bool
+	for i := 0; i < 6; i++ {
+		fails = append(fails, s.fail())
+	}
+	require.Equal(t, []bool{false, false, true, false, false, true}, fails)
+
+	disabled := &faultSchedule{}
+	require.False(t, disabled.fail())
+}
+
+func TestRemoteWriteFaults(t *testing.T) {
+	var received int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer srv.Close()
+
+	faults := newRemoteWriteFaults(RemoteWriteFaultsConfig{FailEvery: 2, StatusCode: http.StatusTooManyRequests})
+	p, err := newEgressProxy(log.NewNopLogger(), nil, nil, faults)
+	require.NoError(t, err)
+	defer p.Close()
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(p.URL())}}
+	var codes []int
+	for i := 0; i < 4; i++ {
+		resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("data"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		codes = append(codes, resp.StatusCode)
+	}
+	require.Equal(t, []int{200, 429, 200, 429}, codes)
+	require.Equal(t, 2, received, "failed requests should not be sent")
+}
+
+func TestFaultyWAL(t *testing.T) {
+	mock := &mockWalStorage{series: make(map[uint64]int)}
+	w := newFaultyWAL(mock, WALFaultsConfig{FailEvery: 2})
+
+	var errs []error
+	for i := 0; i < 4; i++ {
+		app := w.Appender(context.Background())
+		_, err := app.Append(0, labels.FromStrings("__name__", "test"), 0, 0)
+		require.NoError(t, err)
+		errs = append(errs, app.Commit())
+	}
+	require.Equal(t, []error{nil, ErrInjectedFault, nil, ErrInjectedFault}, errs)
+}
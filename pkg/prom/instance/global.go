@@ -1,6 +1,10 @@
 package instance
 
-import "github.com/prometheus/prometheus/config"
+import (
+	"github.com/grafana/agent/pkg/scrub"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
 
 // DefaultGlobalConfig holds default global settings to be used across all instances.
 var DefaultGlobalConfig = GlobalConfig{
@@ -11,6 +15,13 @@ var DefaultGlobalConfig = GlobalConfig{
 type GlobalConfig struct {
 	Prometheus  config.GlobalConfig         `yaml:",inline"`
 	RemoteWrite []*config.RemoteWriteConfig `yaml:"remote_write,omitempty"`
+
+	// Scrubbing rules inherited by instances. Set from the top-level
+	// scrubbing config of the Agent.
+	Scrubbing *scrub.Config `yaml:"-"`
+
+	// Timestamp alignment inherited by instances that don't set their own.
+	TimestampAlignment *TimestampAlignmentConfig `yaml:"timestamp_alignment,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -20,3 +31,17 @@ func (c *GlobalConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain GlobalConfig
 	return unmarshal((*plain)(c))
 }
+
+// mergeExternalLabels returns the external labels of an instance. Labels in
+// instance take precedence over labels in global with the same name.
+func mergeExternalLabels(global, instance labels.Labels) labels.Labels {
+	if len(instance) == 0 {
+		return global
+	}
+
+	b := labels.NewBuilder(global)
+	for _, l := range instance {
+		b.Set(l.Name, l.Value)
+	}
+	return b.Labels()
+}
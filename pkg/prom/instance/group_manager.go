@@ -36,6 +36,10 @@ type GroupManager struct {
 
 	// groupLookup is a map of config name to group name.
 	groupLookup map[string]string
+
+	// preloaded is a map of group name to the name of the config the group
+	// was preloaded for.
+	preloaded map[string]string
 }
 
 // groupedConfigs holds a set of grouped configs, keyed by the config name.
@@ -59,6 +63,7 @@ func NewGroupManager(inner Manager) *GroupManager {
 		inner:       inner,
 		groups:      make(map[string]groupedConfigs),
 		groupLookup: make(map[string]string),
+		preloaded:   make(map[string]string),
 	}
 }
 
@@ -68,6 +73,46 @@ func (m *GroupManager) ListInstances() map[string]ManagedInstance {
 	return m.inner.ListInstances()
 }
 
+// GetInstance gets the underlying grouped instance for a given name.
+func (m *GroupManager) GetInstance(name string) (ManagedInstance, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	group, ok := m.groupLookup[name]
+	if !ok {
+		return nil, fmt.Errorf("instance %s does not exist", name)
+	}
+
+	inst, err := m.inner.GetInstance(group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance for %s: %w", name, err)
+	}
+	return inst, nil
+}
+
+// InstanceStatus implements StatusManager, returning the status of the
+// grouped instance for a given name. An error is returned if the inner
+// Manager doesn't implement StatusManager.
+func (m *GroupManager) InstanceStatus(name string) (InstanceStatus, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	group, ok := m.groupLookup[name]
+	if !ok {
+		return InstanceStatus{}, fmt.Errorf("instance %s does not exist", name)
+	}
+
+	sm, ok := m.inner.(StatusManager)
+	if !ok {
+		return InstanceStatus{}, fmt.Errorf("instance status is not supported")
+	}
+	status, err := sm.InstanceStatus(group)
+	if err != nil {
+		return InstanceStatus{}, fmt.Errorf("failed to get instance status for %s: %w", name, err)
+	}
+	return status, nil
+}
+
 // ListConfigs returns the UNGROUPED instance configs with their original
 // settings. To see the grouped instances, call ListInstances instead.
 func (m *GroupManager) ListConfigs() map[string]Config {
@@ -168,11 +213,70 @@ func (m *GroupManager) applyConfig(c Config) (err error) {
 	}
 
 	// If the inner apply succeeded, we can update our group and the lookup.
+	// The inner Manager has used or discarded the preloaded group.
 	m.groups[groupName] = grouped
 	m.groupLookup[c.Name] = groupName
+	delete(m.preloaded, groupName)
 	return
 }
 
+// PreloadConfig implements Preloader. Only configs that would start a new
+// group are preloaded; configs joining a running group update its instance
+// once applied. A group is preloaded for a single config, so applying
+// another config of the group first creates the group's instance from
+// scratch. An error is returned if the inner Manager doesn't implement
+// Preloader.
+func (m *GroupManager) PreloadConfig(c Config) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	p, ok := m.inner.(Preloader)
+	if !ok {
+		return fmt.Errorf("preloading configs is not supported")
+	}
+
+	groupName, err := hashConfig(c)
+	if err != nil {
+		return fmt.Errorf("failed to get group name for config %s: %w", c.Name, err)
+	}
+	if _, running := m.groups[groupName]; running {
+		return nil
+	}
+	if name, ok := m.preloaded[groupName]; ok && name != c.Name {
+		return nil
+	}
+
+	mergedConfig, err := groupConfigs(groupName, groupedConfigs{c.Name: c})
+	if err != nil {
+		return fmt.Errorf("failed to group config %s: %w", c.Name, err)
+	}
+	if err := p.PreloadConfig(mergedConfig); err != nil {
+		return fmt.Errorf("failed to preload grouped config for config %s: %w", c.Name, err)
+	}
+	m.preloaded[groupName] = c.Name
+	return nil
+}
+
+// UnloadConfig implements Preloader.
+func (m *GroupManager) UnloadConfig(name string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	p, ok := m.inner.(Preloader)
+	if !ok {
+		return fmt.Errorf("preloading configs is not supported")
+	}
+
+	for groupName, preloadedName := range m.preloaded {
+		if preloadedName != name {
+			continue
+		}
+		delete(m.preloaded, groupName)
+		return p.UnloadConfig(groupName)
+	}
+	return fmt.Errorf("config is not preloaded")
+}
+
 // DeleteConfig will remove a Config from its associated group. If there are
 // no more Configs within that group after this Config is deleted, the managed
 // instance will be stopped. Otherwise, the managed instance will be updated
@@ -234,6 +338,13 @@ func (m *GroupManager) Stop() {
 	m.inner.Stop()
 	m.groupLookup = make(map[string]string)
 	m.groups = make(map[string]groupedConfigs)
+	m.preloaded = make(map[string]string)
+}
+
+// GroupName returns the name of the group that c would be placed in by a
+// GroupManager. c should already have defaults applied.
+func GroupName(c Config) (string, error) {
+	return hashConfig(c)
 }
 
 // hashConfig determines the hash of a Config used for grouping. It ignores
@@ -242,14 +353,16 @@ func (m *GroupManager) Stop() {
 func hashConfig(c Config) (string, error) {
 	// We need a deep copy since we're going to mutate the remote_write
 	// pointers.
-	groupable, err := copyConfig(c)
+	groupable, err := CopyConfig(c)
 	if err != nil {
 		return "", err
 	}
 
-	// Ignore name and scrape configs when hashing
+	// Ignore name and scrape configs when hashing. The scrape HTTP client
+	// defaults have already been applied to the scrape configs.
 	groupable.Name = ""
 	groupable.ScrapeConfigs = nil
+	groupable.ScrapeHTTPClientConfig = nil
 
 	// Assign names to remote_write configs if they're not present already.
 	// This is also done in AssignDefaults but is duplicated here for the sake
@@ -289,9 +402,9 @@ func hashConfig(c Config) (string, error) {
 	return hex.EncodeToString(hash[:]), nil
 }
 
-// copyConfig provides a deep copy of a Config that can be mutated
+// CopyConfig provides a deep copy of a Config that can be mutated
 // independently of c.
-func copyConfig(c Config) (Config, error) {
+func CopyConfig(c Config) (Config, error) {
 	bb, err := MarshalConfig(&c, false)
 	if err != nil {
 		return Config{}, err
@@ -328,13 +441,18 @@ func groupConfigs(groupName string, grouped groupedConfigs) (Config, error) {
 	}
 	sort.Slice(cfgs, func(i, j int) bool { return cfgs[i].Name < cfgs[j].Name })
 
-	combined, err := copyConfig(cfgs[0])
+	combined, err := CopyConfig(cfgs[0])
 	if err != nil {
 		return Config{}, err
 	}
 	combined.Name = groupName
 	combined.ScrapeConfigs = []*config.ScrapeConfig{}
 
+	// Defaults for scrape HTTP clients are specific to each config and have
+	// already been applied to their scrape configs. Clear them so the defaults
+	// of the first config aren't applied to the others.
+	combined.ScrapeHTTPClientConfig = nil
+
 	// Assign all remote_write configs in the group a consistent set of remote_names.
 	// If the grouped configs are coming from the scraping service, defaults will have
 	// been applied and the remote names will be prefixed with the old instance config name.
@@ -351,14 +469,24 @@ func groupConfigs(groupName string, grouped groupedConfigs) (Config, error) {
 		rwc.Name = groupName[:6] + "-" + hash[:6]
 	}
 
-	// Combine all the scrape configs. It's possible that two different ungrouped
-	// configs had a matching job name, but this will be detected and rejected
-	// (as it should be) when the underlying Manager eventually validates the
-	// combined config.
+	// Combine all the scrape configs. Scrape configs are keyed by job name in
+	// the shared instance, so two different ungrouped configs can't use the
+	// same job name. Reject those here so the error can point at both configs
+	// instead of just the combined one.
 	//
 	// TODO(rfratto): should we prepend job names with the name of the original
 	// config? (e.g., job_name = "config_name/job_name").
+	jobOwners := make(map[string]string)
 	for _, cfg := range cfgs {
+		for _, sc := range cfg.ScrapeConfigs {
+			if sc == nil {
+				continue
+			}
+			if owner, ok := jobOwners[sc.JobName]; ok && owner != cfg.Name {
+				return Config{}, fmt.Errorf("job name %q is used by both %s and %s, which share an instance; job names must be unique across configs in shared instance_mode", sc.JobName, owner, cfg.Name)
+			}
+			jobOwners[sc.JobName] = cfg.Name
+		}
 		combined.ScrapeConfigs = append(combined.ScrapeConfigs, cfg.ScrapeConfigs...)
 	}
 
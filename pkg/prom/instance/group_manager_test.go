@@ -196,6 +196,36 @@ remote_write:
 	require.NotEqual(t, "rw-cfg-a", cfg.RemoteWrite[0].Name)
 }
 
+func TestGroupManager_ApplyConfig_DuplicateJobName(t *testing.T) {
+	inner := newFakeManager()
+	gm := NewGroupManager(inner)
+	err := gm.ApplyConfig(testUnmarshalConfig(t, `
+name: configA
+scrape_configs:
+- job_name: test_job
+  static_configs:
+    - targets: [127.0.0.1:12345]
+remote_write: []
+`))
+	require.NoError(t, err)
+
+	err = gm.ApplyConfig(testUnmarshalConfig(t, `
+name: configB
+scrape_configs:
+- job_name: test_job
+  static_configs:
+    - targets: [127.0.0.1:54321]
+remote_write: []
+`))
+	require.EqualError(t, err, `failed to group configs for configB: job name "test_job" is used by both configA and configB, which share an instance; job names must be unique across configs in shared instance_mode`)
+
+	// The rejected config must not affect the config already in the group.
+	require.Equal(t, 1, len(gm.groupLookup))
+	innerConfigs := inner.ListConfigs()
+	require.Equal(t, 1, len(innerConfigs))
+	require.Len(t, innerConfigs[gm.groupLookup["configA"]].ScrapeConfigs, 1)
+}
+
 func TestGroupManager_DeleteConfig(t *testing.T) {
 	t.Run("partial delete", func(t *testing.T) {
 		inner := newFakeManager()
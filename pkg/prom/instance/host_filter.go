@@ -61,6 +61,8 @@ func NewHostFilter(host string, relabels []*relabel.Config) *HostFilter {
 		host: host,
 
 		outputCh: make(chan map[string][]*targetgroup.Group),
+
+		relabels: relabels,
 	}
 	return f
 }
@@ -96,7 +98,9 @@ func (f *HostFilter) SyncCh() GroupChannel {
 // FilterGroups takes a set of DiscoveredGroups as input and filters out
 // any Target that is not running on the host machine provided by host.
 //
-// This is done by looking at HostFilterLabelMatchers and __address__.
+// This is done by looking at HostFilterLabelMatchers and __address__ after
+// applying configs to the target. Targets dropped by configs are filtered
+// out.
 //
 // If the discovered address is localhost or 127.0.0.1, the group is never
 // filtered out.
@@ -117,7 +121,8 @@ func FilterGroups(in DiscoveredGroups, host string, configs []*relabel.Config) D
 				allLabels := mergeSets(target, group.Labels)
 				processedLabels := relabel.Process(toLabelSlice(allLabels), configs...)
 
-				if !shouldFilterTarget(processedLabels, host) {
+				// A nil label set means the target was dropped by relabeling.
+				if processedLabels != nil && !shouldFilterTarget(processedLabels, host) {
 					newGroup.Targets = append(newGroup.Targets, target)
 				}
 			}
@@ -156,3 +156,57 @@ func TestFilterGroups_Relabel(t *testing.T) {
 		})
 	}
 }
+
+func TestFilterGroups_RelabelDrop(t *testing.T) {
+	// Keep only targets running on a specific EC2 instance.
+	relabelConfig := []*relabel.Config{
+		{
+			SourceLabels: model.LabelNames{"__meta_ec2_instance_id"},
+			Action:       relabel.Keep,
+			Separator:    ";",
+			Regex:        relabel.MustNewRegexp("i-1234"),
+		},
+		{
+			Action:      relabel.Replace,
+			Separator:   ";",
+			Regex:       relabel.MustNewRegexp("(.*)"),
+			Replacement: "myhost",
+			TargetLabel: "__host__",
+		},
+	}
+
+	groups := DiscoveredGroups{"test": []*targetgroup.Group{makeGroup([]model.LabelSet{
+		{model.AddressLabel: "10.0.0.1:80", "__meta_ec2_instance_id": "i-1234"},
+		{model.AddressLabel: "10.0.0.2:80", "__meta_ec2_instance_id": "i-5678"},
+	})}}
+
+	result := FilterGroups(groups, "myhost", relabelConfig)
+	require.Equal(t, []model.LabelSet{
+		{model.AddressLabel: "10.0.0.1:80", "__meta_ec2_instance_id": "i-1234"},
+	}, result["test"][0].Targets)
+}
+
+func TestHostFilter_Relabel(t *testing.T) {
+	relabelConfig := []*relabel.Config{{
+		SourceLabels: model.LabelNames{"__internal_label"},
+		Action:       relabel.Replace,
+		Separator:    ";",
+		Regex:        relabel.MustNewRegexp("(.*)"),
+		Replacement:  "$1",
+		TargetLabel:  "__host__",
+	}}
+
+	inputCh := make(chan DiscoveredGroups)
+	f := NewHostFilter("myhost", relabelConfig)
+	go f.Run(inputCh)
+	defer f.Stop()
+
+	inputCh <- DiscoveredGroups{"test": []*targetgroup.Group{makeGroup([]model.LabelSet{
+		{model.AddressLabel: "fake_target", "__internal_label": "myhost"},
+		{model.AddressLabel: "fake_target", "__internal_label": "notmyhost"},
+	})}}
+
+	result := <-f.SyncCh()
+	require.Len(t, result["test"][0].Targets, 1)
+	require.Equal(t, model.LabelValue("myhost"), result["test"][0].Targets[0]["__internal_label"])
+}
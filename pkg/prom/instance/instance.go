@@ -18,12 +18,20 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/grafana/agent/pkg/build"
+	"github.com/grafana/agent/pkg/prom/kafka"
+	"github.com/grafana/agent/pkg/prom/rules"
 	"github.com/grafana/agent/pkg/prom/wal"
+	"github.com/grafana/agent/pkg/scrub"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/scrape"
@@ -36,6 +44,10 @@ func init() {
 	remote.UserAgent = fmt.Sprintf("GrafanaAgent/%s", build.Version)
 }
 
+// walSizeCheckFrequency is how often the size of the WAL is checked when a
+// size limit is configured.
+var walSizeCheckFrequency = time.Minute
+
 var (
 	remoteWriteMetricName = "queue_highest_sent_timestamp_seconds"
 	managerMtx            sync.Mutex
@@ -50,6 +62,7 @@ var (
 		MaxWALTime:           4 * time.Hour,
 		RemoteFlushDeadline:  1 * time.Minute,
 		WriteStaleOnShutdown: false,
+		WALCompression:       true,
 	}
 )
 
@@ -62,6 +75,78 @@ type Config struct {
 	ScrapeConfigs            []*config.ScrapeConfig      `yaml:"scrape_configs,omitempty"`
 	RemoteWrite              []*config.RemoteWriteConfig `yaml:"remote_write,omitempty"`
 
+	// Labels added to every series and alert sent by the instance. Labels set
+	// here take precedence over the global external_labels.
+	ExternalLabels labels.Labels `yaml:"external_labels,omitempty"`
+
+	// Kafka topics to publish samples to. Can be used instead of or in
+	// addition to remote_write.
+	KafkaWrite []*kafka.Config `yaml:"kafka_write,omitempty"`
+
+	// Tenant to send samples to. When set, every remote_write sends TenantID
+	// in the TenantHeader header, which defaults to DefaultTenantHeader.
+	TenantID     string `yaml:"tenant_id,omitempty"`
+	TenantHeader string `yaml:"tenant_header,omitempty"`
+
+	// Endpoint that remote_read requests sent to the instance's read API are
+	// proxied to. Queries are restricted to the external labels of the
+	// instance and sent with its tenant_id.
+	RemoteReadProxy *config.RemoteReadConfig `yaml:"remote_read_proxy,omitempty"`
+
+	// Checks of the clock skew between the agent and remote_write endpoints.
+	ClockSkew ClockSkewConfig `yaml:"clock_skew,omitempty"`
+
+	// Maximum number of metric metadata entries sent per remote_write
+	// request. When 0, all metadata is sent in a single request.
+	MetadataMaxPerSend int `yaml:"metadata_max_per_send,omitempty"`
+
+	// Canary series written and then queried from the remote endpoint to
+	// measure how long samples take to become queryable.
+	Canary *CanaryConfig `yaml:"canary,omitempty"`
+
+	// Health checks that recreate the instance when it stops making
+	// progress. Disabled when unset.
+	SelfHealing *SelfHealingConfig `yaml:"self_healing,omitempty"`
+
+	// Default HTTP client settings for scrape_configs. Settings are only
+	// applied to scrape configs that don't set them.
+	ScrapeHTTPClientConfig *config_util.HTTPClientConfig `yaml:"scrape_http_client_config,omitempty"`
+
+	// Limits applied to samples scraped from every target.
+	ScrapeLimits ScrapeLimitsConfig `yaml:"scrape_limits,omitempty"`
+
+	// Labels hashed or removed from scraped samples before they're written
+	// to the WAL. Inherited from the global scrubbing config when unset.
+	Scrubbing *scrub.Config `yaml:"scrubbing,omitempty"`
+
+	// Aligns the timestamps of scraped samples to multiples of the scrape
+	// interval of their job. Inherited from the global config when unset.
+	TimestampAlignment *TimestampAlignmentConfig `yaml:"timestamp_alignment,omitempty"`
+
+	// How long to keep scraping targets after they disappear from service
+	// discovery. Targets that reappear within the window aren't restarted.
+	// 0 removes targets right away.
+	TargetDebounceWindow time.Duration `yaml:"target_debounce_window,omitempty"`
+
+	// Settings for dns_sd_configs in scrape_configs.
+	DNSSD DNSSDConfig `yaml:"dns_sd,omitempty"`
+
+	// Recording rules evaluated against recently scraped samples. Scraped
+	// samples are only kept in memory for rules when this is set.
+	Rules *rules.Config `yaml:"rules,omitempty"`
+
+	// Maximum number of scrapes that may be in flight at once. Scrapes over
+	// the limit wait for earlier scrapes to finish. 0 means no limit.
+	MaxConcurrentScrapes int `yaml:"max_concurrent_scrapes,omitempty"`
+
+	// Maximum number of bytes per second sent to all remote_write endpoints
+	// of the instance. Requests over the limit are delayed. 0 means no limit.
+	RemoteWriteBytesPerSecond int64 `yaml:"remote_write_bytes_per_second,omitempty"`
+
+	// Temporarily raises the shard ceiling and changes the byte limit of
+	// remote_write while it catches up on a backlog. Disabled when unset.
+	RemoteWriteCatchUp *CatchUpConfig `yaml:"remote_write_catch_up,omitempty"`
+
 	// How frequently the WAL should be truncated.
 	WALTruncateFrequency time.Duration `yaml:"wal_truncate_frequency,omitempty"`
 
@@ -69,8 +154,30 @@ type Config struct {
 	MinWALTime time.Duration `yaml:"min_wal_time,omitempty"`
 	MaxWALTime time.Duration `yaml:"max_wal_time,omitempty"`
 
+	// Size limits for the WAL on disk, in bytes. Exceeding MaxWALSize forces
+	// an early truncation. Exceeding HardMaxWALSize truncates the WAL even if
+	// the data hasn't been sent yet. 0 disables either limit.
+	MaxWALSize     int64 `yaml:"max_wal_size_bytes,omitempty"`
+	HardMaxWALSize int64 `yaml:"hard_max_wal_size_bytes,omitempty"`
+
+	// Compress records written to the WAL with snappy. Not omitempty since
+	// it defaults to true.
+	WALCompression bool `yaml:"wal_compression"`
+
+	// Samples older than the newest sample of their series by more than
+	// OutOfOrderTolerance are rejected. 0 accepts out-of-order samples of any
+	// age.
+	OutOfOrderTolerance time.Duration `yaml:"out_of_order_tolerance,omitempty"`
+
+	// Drop the staleness markers written when targets or series disappear.
+	DropStaleMarkers bool `yaml:"drop_stale_markers,omitempty"`
+
 	RemoteFlushDeadline  time.Duration `yaml:"remote_flush_deadline,omitempty"`
 	WriteStaleOnShutdown bool          `yaml:"write_stale_on_shutdown,omitempty"`
+
+	// Faults to inject for testing. Intentionally undocumented; only
+	// accepted when built with the faultinjection build tag.
+	FaultInjection *FaultInjectionConfig `yaml:"fault_injection,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -100,6 +207,14 @@ func (c Config) MarshalYAML() (interface{}, error) {
 	return m, nil
 }
 
+// walAppendOptions returns the options for appending samples to the WAL.
+func (c *Config) walAppendOptions() wal.AppendOptions {
+	return wal.AppendOptions{
+		OutOfOrderTolerance: c.OutOfOrderTolerance,
+		DropStaleMarkers:    c.DropStaleMarkers,
+	}
+}
+
 // ApplyDefaults applies default configurations to the configuration to all
 // values that have not been changed to their non-zero value. ApplyDefaults
 // also validates the config.
@@ -113,6 +228,45 @@ func (c *Config) ApplyDefaults(global *GlobalConfig) error {
 		return errors.New("remote_flush_deadline must be greater than 0s")
 	case c.MinWALTime > c.MaxWALTime:
 		return errors.New("min_wal_time must be less than max_wal_time")
+	case c.MaxWALSize < 0:
+		return errors.New("max_wal_size_bytes must not be negative")
+	case c.HardMaxWALSize < 0:
+		return errors.New("hard_max_wal_size_bytes must not be negative")
+	case c.MaxWALSize > 0 && c.HardMaxWALSize > 0 && c.HardMaxWALSize < c.MaxWALSize:
+		return errors.New("hard_max_wal_size_bytes must not be less than max_wal_size_bytes")
+	case c.MaxConcurrentScrapes < 0:
+		return errors.New("max_concurrent_scrapes must not be negative")
+	case c.RemoteWriteBytesPerSecond < 0:
+		return errors.New("remote_write_bytes_per_second must not be negative")
+	case c.TargetDebounceWindow < 0:
+		return errors.New("target_debounce_window must not be negative")
+	case c.OutOfOrderTolerance < 0:
+		return errors.New("out_of_order_tolerance must not be negative")
+	case c.DNSSD.NegativeCacheDuration < 0:
+		return errors.New("dns_sd.negative_cache_duration must not be negative")
+	case c.RemoteReadProxy != nil && c.RemoteReadProxy.URL == nil:
+		return errors.New("remote_read_proxy.url must be set")
+	case c.ClockSkew.CheckInterval < 0:
+		return errors.New("clock_skew.check_interval must not be negative")
+	case c.ClockSkew.WarnThreshold < 0:
+		return errors.New("clock_skew.warn_threshold must not be negative")
+	case c.MetadataMaxPerSend < 0:
+		return errors.New("metadata_max_per_send must not be negative")
+	}
+
+	if c.Canary != nil {
+		if err := c.Canary.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range c.ExternalLabels {
+		if !model.LabelName(l.Name).IsValid() {
+			return fmt.Errorf("%q is not a valid external label name", l.Name)
+		}
+		if !model.LabelValue(l.Value).IsValid() {
+			return fmt.Errorf("%q is not a valid value for external label %q", l.Value, l.Name)
+		}
 	}
 
 	jobNames := map[string]struct{}{}
@@ -121,6 +275,17 @@ func (c *Config) ApplyDefaults(global *GlobalConfig) error {
 			return fmt.Errorf("empty or null scrape config section")
 		}
 
+		if c.ScrapeHTTPClientConfig != nil {
+			inheritHTTPClientConfig(&sc.HTTPClientConfig, c.ScrapeHTTPClientConfig)
+			if err := sc.HTTPClientConfig.Validate(); err != nil {
+				return fmt.Errorf("invalid http client settings for scrape config with job name %q: %w", sc.JobName, err)
+			}
+		}
+
+		if sc.SampleLimit == 0 {
+			sc.SampleLimit = c.ScrapeLimits.SampleLimit
+		}
+
 		// First set the correct scrape interval, then check that the timeout
 		// (inferred or explicit) is not greater than that.
 		if sc.ScrapeInterval == 0 {
@@ -146,12 +311,75 @@ func (c *Config) ApplyDefaults(global *GlobalConfig) error {
 		jobNames[sc.JobName] = struct{}{}
 	}
 
+	if c.SelfHealing != nil {
+		if err := c.SelfHealing.Validate(c.ScrapeConfigs); err != nil {
+			return err
+		}
+	}
+
+	if c.RemoteWriteCatchUp != nil {
+		if err := c.RemoteWriteCatchUp.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Rules != nil {
+		if err := c.Rules.ApplyDefaults(time.Duration(global.Prometheus.EvaluationInterval)); err != nil {
+			return fmt.Errorf("invalid rules: %w", err)
+		}
+	}
+
 	rwNames := map[string]struct{}{}
 
-	// If the instance remote write is not filled in, then apply the prometheus write config
-	if len(c.RemoteWrite) == 0 {
+	// If the instance remote write is not filled in, then apply the prometheus
+	// write config. Instances that only publish to Kafka don't inherit it.
+	if len(c.RemoteWrite) == 0 && len(c.KafkaWrite) == 0 {
 		c.RemoteWrite = global.RemoteWrite
 	}
+	if c.Scrubbing == nil {
+		c.Scrubbing = global.Scrubbing
+	}
+	if c.TimestampAlignment == nil {
+		c.TimestampAlignment = global.TimestampAlignment
+	}
+	if c.TimestampAlignment != nil {
+		if err := c.TimestampAlignment.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.TenantID != "" {
+		header := c.TenantHeader
+		if header == "" {
+			header = DefaultTenantHeader
+		}
+		remoteWrites, err := withTenantHeader(c.RemoteWrite, header, c.TenantID)
+		if err != nil {
+			return err
+		}
+		c.RemoteWrite = remoteWrites
+
+		if c.RemoteReadProxy != nil {
+			headers, err := tenantHeaders(c.RemoteReadProxy.Headers, header, c.TenantID)
+			if err != nil {
+				return fmt.Errorf("remote_read_proxy %w", err)
+			}
+			proxyCopy := *c.RemoteReadProxy
+			proxyCopy.Headers = headers
+			c.RemoteReadProxy = &proxyCopy
+		}
+
+		if c.Canary != nil {
+			headers, err := tenantHeaders(c.Canary.Headers, header, c.TenantID)
+			if err != nil {
+				return fmt.Errorf("canary %w", err)
+			}
+			canaryCopy := *c.Canary
+			canaryCopy.Headers = headers
+			c.Canary = &canaryCopy
+		}
+	} else if c.TenantHeader != "" {
+		return errors.New("tenant_header requires tenant_id to be set")
+	}
 	for _, cfg := range c.RemoteWrite {
 		if cfg == nil {
 			return fmt.Errorf("empty or null remote write config section")
@@ -181,11 +409,87 @@ func (c *Config) ApplyDefaults(global *GlobalConfig) error {
 			return fmt.Errorf("found duplicate remote write configs with name %q", cfg.Name)
 		}
 		rwNames[cfg.Name] = struct{}{}
+
+		if err := validateQueueConfig(cfg.QueueConfig); err != nil {
+			return fmt.Errorf("invalid queue_config for remote_write %q: %w", cfg.Name, err)
+		}
+	}
+
+	kafkaNames := map[string]struct{}{}
+	for _, cfg := range c.KafkaWrite {
+		if cfg == nil {
+			return fmt.Errorf("empty or null kafka_write config section")
+		}
+
+		// Names are used to identify the metrics of each kafka_write, so a
+		// unique name is generated when one isn't set.
+		var generatedName bool
+		if cfg.Name == "" {
+			hash, err := getHash(cfg)
+			if err != nil {
+				return err
+			}
+			cfg.Name = c.Name + "-" + hash[:6]
+			generatedName = true
+		}
+
+		if _, exists := kafkaNames[cfg.Name]; exists {
+			if generatedName {
+				return fmt.Errorf("found two identical kafka_write configs")
+			}
+			return fmt.Errorf("found duplicate kafka_write configs with name %q", cfg.Name)
+		}
+		kafkaNames[cfg.Name] = struct{}{}
+	}
+
+	if c.FaultInjection != nil {
+		if err := c.FaultInjection.validate(c.RemoteWrite); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// inheritHTTPClientConfig copies settings from def into cfg that cfg doesn't
+// set. Authentication is only inherited when cfg doesn't configure any
+// authentication of its own, and the client certificate and key are
+// inherited together.
+func inheritHTTPClientConfig(cfg, def *config_util.HTTPClientConfig) {
+	hasAuth := cfg.BasicAuth != nil || cfg.Authorization != nil || cfg.BearerToken != "" || cfg.BearerTokenFile != ""
+	if !hasAuth {
+		if def.BasicAuth != nil {
+			basicAuth := *def.BasicAuth
+			cfg.BasicAuth = &basicAuth
+		}
+		if def.Authorization != nil {
+			authorization := *def.Authorization
+			cfg.Authorization = &authorization
+		}
+		cfg.BearerToken = def.BearerToken
+		cfg.BearerTokenFile = def.BearerTokenFile
+	}
+
+	if cfg.ProxyURL.URL == nil {
+		cfg.ProxyURL = def.ProxyURL
+	}
+
+	tls := &cfg.TLSConfig
+	if tls.CAFile == "" {
+		tls.CAFile = def.TLSConfig.CAFile
+	}
+	if tls.CertFile == "" && tls.KeyFile == "" {
+		tls.CertFile = def.TLSConfig.CertFile
+		tls.KeyFile = def.TLSConfig.KeyFile
+	}
+	if tls.ServerName == "" {
+		tls.ServerName = def.TLSConfig.ServerName
+	}
+	if def.TLSConfig.InsecureSkipVerify {
+		tls.InsecureSkipVerify = true
+	}
+}
+
 type walStorageFactory func(reg prometheus.Registerer) (walStorage, error)
 
 // Instance is an individual metrics collector and remote_writer.
@@ -199,9 +503,27 @@ type Instance struct {
 	cfg                Config
 	wal                walStorage
 	discovery          *discoveryService
+	dnsSDMetrics       *dnsSDMetrics
 	readyScrapeManager *readyScrapeManager
 	remoteStore        *remote.Storage
+	egressProxy        *egressProxy
+	connGate           *connGate
+	remoteReadProxy    *RemoteReadProxy
+	remoteWriteQueues  *remoteWriteQueueCollector
+	clockSkew          *clockSkewChecker
+	metadata           *metadataSender
+	catchUp            *catchUpController
+	canary             *canary
+	healthChecker      *healthChecker
+	kafkaWriters       []*kafka.Writer
+	rules              *rules.Manager
+	labelLimits        *labelLimitsAppendable
+	alignment          *timestampAlignmentAppendable
+	appendStats        *appendStatsAppendable
 	storage            storage.Storage
+	// scrubbed is storage with the scrubbing rules of the instance applied.
+	// Every sample written to the instance must go through it.
+	scrubbed storage.Appendable
 
 	globalCfg GlobalConfig
 	logger    log.Logger
@@ -209,25 +531,67 @@ type Instance struct {
 	reg    prometheus.Registerer
 	newWal walStorageFactory
 
+	// preloaded is WAL storage created by Preload for the next call to Run.
+	// Its metrics are registered to preloadedReg. Guarded by mut.
+	preloaded    walStorage
+	preloadedReg *util.Unregisterer
+
+	// gatherer gathers the metrics registered to reg. It's used to read the
+	// remote_write metrics of the instance.
+	gatherer prometheus.Gatherer
+
+	// scrapeLimiter limits the scrapes of this instance. globalScrapeLimiter,
+	// if set, is shared with other instances.
+	scrapeLimiter       *ScrapeLimiter
+	globalScrapeLimiter *ScrapeLimiter
+
+	// egressLimiter limits the bytes sent by remote_write of this instance.
+	// globalEgressLimiter, if set, is shared with other instances.
+	egressLimiter       *EgressLimiter
+	globalEgressLimiter *EgressLimiter
+
+	// catchingUp is true while remote_write is in catch-up mode.
+	catchingUp bool
+
+	// remoteWriteGate, if set, decides whether samples are sent to
+	// remote_write.
+	remoteWriteGate *RemoteWriteGate
+
 	vc *MetricValueCollector
 }
 
-// New creates a new Instance with a directory for storing the WAL. The instance
-// will not start until Run is called on the instance.
-func New(reg prometheus.Registerer, globalCfg GlobalConfig, cfg Config, walDir string, logger log.Logger) (*Instance, error) {
+// New creates a new Instance with a directory for storing the WAL. Metrics
+// of the instance are registered to reg, and gatherer must gather them.
+// Replaying an existing WAL will use at most walReplayMemoryLimit bytes, where
+// 0 means no limit. Scrapes of the instance also count towards globalScrapeLimiter
+// and bytes sent by remote_write towards globalEgressLimiter if they're not
+// nil. Samples are only sent to remote_write while remoteWriteGate is open.
+// The instance will not start until Run is called on the instance.
+func New(reg prometheus.Registerer, gatherer prometheus.Gatherer, globalCfg GlobalConfig, cfg Config, walDir string, walReplayMemoryLimit int64, globalScrapeLimiter *ScrapeLimiter, globalEgressLimiter *EgressLimiter, remoteWriteGate *RemoteWriteGate, logger log.Logger) (*Instance, error) {
 	logger = log.With(logger, "instance", cfg.Name)
 
 	instWALDir := filepath.Join(walDir, cfg.Name)
 
 	newWal := func(reg prometheus.Registerer) (walStorage, error) {
-		return wal.NewStorage(logger, reg, instWALDir)
+		return wal.NewStorage(logger, reg, instWALDir, walReplayMemoryLimit, cfg.WALCompression)
 	}
 
-	return newInstance(globalCfg, cfg, reg, logger, newWal)
+	inst, err := newInstance(globalCfg, cfg, reg, gatherer, logger, newWal)
+	if err != nil {
+		return nil, err
+	}
+	inst.globalScrapeLimiter = globalScrapeLimiter
+	inst.globalEgressLimiter = globalEgressLimiter
+	inst.remoteWriteGate = remoteWriteGate
+	return inst, nil
 }
 
-func newInstance(globalCfg GlobalConfig, cfg Config, reg prometheus.Registerer, logger log.Logger, newWal walStorageFactory) (*Instance, error) {
-	vc := NewMetricValueCollector(prometheus.DefaultGatherer, remoteWriteMetricName)
+func newInstance(globalCfg GlobalConfig, cfg Config, reg prometheus.Registerer, gatherer prometheus.Gatherer, logger log.Logger, newWal walStorageFactory) (*Instance, error) {
+	vc := NewMetricValueCollector(gatherer, remoteWriteMetricName)
+
+	// Every component of the instance reads external labels from the global
+	// config, so the instance's own labels are merged into it.
+	globalCfg.Prometheus.ExternalLabels = mergeExternalLabels(globalCfg.Prometheus.ExternalLabels, cfg.ExternalLabels)
 
 	i := &Instance{
 		cfg:       cfg,
@@ -235,10 +599,21 @@ func newInstance(globalCfg GlobalConfig, cfg Config, reg prometheus.Registerer,
 		logger:    logger,
 		vc:        vc,
 
-		reg:    reg,
-		newWal: newWal,
+		reg:      reg,
+		newWal:   newWal,
+		gatherer: gatherer,
 
 		readyScrapeManager: &readyScrapeManager{},
+		scrapeLimiter:      NewScrapeLimiter(cfg.MaxConcurrentScrapes),
+		egressLimiter:      NewEgressLimiter(cfg.RemoteWriteBytesPerSecond),
+	}
+
+	if cfg.RemoteReadProxy != nil {
+		proxy, err := NewRemoteReadProxy(cfg, globalCfg)
+		if err != nil {
+			return nil, fmt.Errorf("error creating remote_read_proxy: %w", err)
+		}
+		i.remoteReadProxy = proxy
 	}
 
 	return i, nil
@@ -257,17 +632,31 @@ func (i *Instance) Run(ctx context.Context) error {
 	// now.
 	i.mut.Lock()
 	cfg := i.cfg
+	preloaded, trackingReg := i.preloaded, i.preloadedReg
+	i.preloaded, i.preloadedReg = nil, nil
 	i.mut.Unlock()
 
 	level.Debug(i.logger).Log("msg", "initializing instance", "name", cfg.Name)
 
 	// trackingReg wraps the register for the instance to make sure that if Run
 	// exits, any metrics Prometheus registers are removed and can be
-	// re-registered if Run is called again.
-	trackingReg := util.WrapWithUnregisterer(i.reg)
+	// re-registered if Run is called again. Preloaded storage already
+	// registered its metrics to a trackingReg, which is reused.
+	if trackingReg == nil {
+		trackingReg = util.WrapWithUnregisterer(i.reg)
+	}
 	defer trackingReg.UnregisterAll()
 
-	if err := i.initialize(ctx, trackingReg, &cfg); err != nil {
+	// The egress proxy is closed once the run group exits, after remote_write
+	// has been flushed.
+	defer i.closeEgressProxy()
+
+	// Subscribe to the remote_write gate before the remote storage is created
+	// so changes made while the instance initializes aren't missed.
+	gateCh, unsubscribeGate := i.remoteWriteGate.Subscribe()
+	defer unsubscribeGate()
+
+	if err := i.initialize(ctx, trackingReg, &cfg, preloaded); err != nil {
 		level.Error(i.logger).Log("msg", "failed to initialize instance", "err", err)
 		return fmt.Errorf("failed to initialize instance: %w", err)
 	}
@@ -275,9 +664,11 @@ func (i *Instance) Run(ctx context.Context) error {
 	// The actors defined here are defined in the order we want them to shut down.
 	// Primarily, we want to ensure that the following shutdown order is
 	// maintained:
-	//		1. The scrape manager stops
-	//    2. WAL storage is closed
-	//    3. Remote write storage is closed
+	//		1. The rule manager stops, if rules are enabled
+	//    2. The scrape manager stops
+	//    3. WAL storage is closed
+	//    4. Remote write storage is closed
+	//    5. Kafka writers are stopped
 	// This is done to allow the instance to write stale markers for all active
 	// series.
 	rg := runGroupWithContext(ctx)
@@ -302,6 +693,113 @@ func (i *Instance) Run(ctx context.Context) error {
 			},
 		)
 	}
+	{
+		// remote_write gate
+		ctx, contextCancel := context.WithCancel(context.Background())
+		defer contextCancel()
+		rg.Add(
+			func() error {
+				for {
+					select {
+					case <-ctx.Done():
+						return nil
+					case <-gateCh:
+						i.applyRemoteWriteGate()
+					}
+				}
+			},
+			func(err error) {
+				contextCancel()
+			},
+		)
+	}
+	{
+		// Clock skew checks
+		ctx, contextCancel := context.WithCancel(context.Background())
+		defer contextCancel()
+		rg.Add(
+			func() error {
+				i.clockSkew.Run(ctx)
+				return nil
+			},
+			func(err error) {
+				contextCancel()
+			},
+		)
+	}
+	{
+		// remote_write catch-up mode
+		ctx, contextCancel := context.WithCancel(context.Background())
+		defer contextCancel()
+		rg.Add(
+			func() error {
+				i.catchUp.Run(ctx)
+				return nil
+			},
+			func(err error) {
+				contextCancel()
+			},
+		)
+	}
+	{
+		// Metadata sends
+		ctx, contextCancel := context.WithCancel(context.Background())
+		defer contextCancel()
+		rg.Add(
+			func() error {
+				i.metadata.Run(ctx)
+				return nil
+			},
+			func(err error) {
+				contextCancel()
+			},
+		)
+	}
+	{
+		// Canary. Added before the scrape manager so it's stopped before the
+		// storage it writes to is closed.
+		ctx, contextCancel := context.WithCancel(context.Background())
+		defer contextCancel()
+		rg.Add(
+			func() error {
+				i.canary.Run(ctx)
+				return nil
+			},
+			func(err error) {
+				contextCancel()
+			},
+		)
+	}
+	{
+		// Self-healing health checks. An ErrUnhealthy stops the instance so
+		// it's recreated.
+		ctx, contextCancel := context.WithCancel(context.Background())
+		defer contextCancel()
+		rg.Add(
+			func() error {
+				return i.healthChecker.Run(ctx)
+			},
+			func(err error) {
+				contextCancel()
+			},
+		)
+	}
+	if i.rules != nil {
+		// Rule evaluation. Stopping the rule manager waits for running
+		// evaluations, so it must be stopped before the storage is closed.
+		rm := i.rules
+		rg.Add(
+			func() error {
+				rm.Run()
+				level.Info(i.logger).Log("msg", "rule manager stopped")
+				return nil
+			},
+			func(err error) {
+				level.Info(i.logger).Log("msg", "stopping rule manager...")
+				rm.Stop()
+			},
+		)
+	}
 	{
 		sm, err := i.readyScrapeManager.Get()
 		if err != nil {
@@ -341,6 +839,12 @@ func (i *Instance) Run(ctx context.Context) error {
 			},
 		)
 	}
+	for _, kw := range i.kafkaWriters {
+		// Kafka writers read from the WAL files, so they're stopped after the
+		// storage is closed to publish any staleness markers.
+		kw := kw
+		rg.Add(kw.Run, func(err error) { kw.Stop() })
+	}
 
 	level.Debug(i.logger).Log("msg", "running instance", "name", cfg.Name)
 	err := rg.Run()
@@ -353,19 +857,27 @@ func (i *Instance) Run(ctx context.Context) error {
 // initialize sets up the various Prometheus components with their initial
 // settings. initialize will be called each time the Instance is run. Prometheus
 // components cannot be reused after they are stopped so we need to recreate them
-// each run.
-func (i *Instance) initialize(ctx context.Context, reg prometheus.Registerer, cfg *Config) error {
+// each run. The WAL storage is only created if preloaded is nil.
+func (i *Instance) initialize(ctx context.Context, reg prometheus.Registerer, cfg *Config, preloaded walStorage) error {
 	i.mut.Lock()
 	defer i.mut.Unlock()
 
 	var err error
 
-	i.wal, err = i.newWal(reg)
-	if err != nil {
-		return fmt.Errorf("error creating WAL: %w", err)
+	i.wal = preloaded
+	if i.wal == nil {
+		i.wal, err = i.newWal(reg)
+		if err != nil {
+			return fmt.Errorf("error creating WAL: %w", err)
+		}
+	}
+	if cfg.FaultInjection != nil && cfg.FaultInjection.WAL != nil {
+		i.wal = newFaultyWAL(i.wal, *cfg.FaultInjection.WAL)
 	}
+	i.wal.SetAppendOptions(cfg.walAppendOptions())
 
-	i.discovery, err = i.newDiscoveryManager(ctx, cfg)
+	i.dnsSDMetrics = newDNSSDMetrics(reg)
+	i.discovery, err = i.newDiscoveryManager(ctx, reg, cfg)
 	if err != nil {
 		return fmt.Errorf("error creating discovery manager: %w", err)
 	}
@@ -374,18 +886,122 @@ func (i *Instance) initialize(ctx context.Context, reg prometheus.Registerer, cf
 
 	// Setup the remote storage
 	remoteLogger := log.With(i.logger, "component", "remote")
+	i.egressLimiter.SetLimit(cfg.RemoteWriteBytesPerSecond)
+	var faults *remoteWriteFaults
+	if cfg.FaultInjection != nil && cfg.FaultInjection.RemoteWrite != nil {
+		faults = newRemoteWriteFaults(*cfg.FaultInjection.RemoteWrite)
+	}
+	i.connGate, i.catchingUp = nil, false
+	if cfg.RemoteWriteCatchUp != nil {
+		i.connGate = newConnGate()
+		i.applyCatchUpMode(*cfg)
+	}
+	if i.egressLimiter.Limited() || i.globalEgressLimiter.Limited() || i.connGate != nil || faults != nil {
+		i.egressProxy, err = newEgressProxy(remoteLogger, []*EgressLimiter{i.egressLimiter, i.globalEgressLimiter}, i.connGate, faults)
+		if err != nil {
+			return fmt.Errorf("error creating remote_write egress proxy: %w", err)
+		}
+	}
 	i.remoteStore = remote.NewStorage(remoteLogger, reg, i.wal.StartTime, i.wal.Directory(), cfg.RemoteFlushDeadline, i.readyScrapeManager)
 	err = i.remoteStore.ApplyConfig(&config.Config{
 		GlobalConfig:       i.globalCfg.Prometheus,
-		RemoteWriteConfigs: cfg.RemoteWrite,
+		RemoteWriteConfigs: i.remoteStoreWrites(*cfg),
 	})
 	if err != nil {
 		return fmt.Errorf("failed applying config to remote storage: %w", err)
 	}
+	i.remoteWriteQueues = newRemoteWriteQueueCollector()
+	i.remoteWriteQueues.SetConfigs(cfg.RemoteWrite)
+	if err := reg.Register(i.remoteWriteQueues); err != nil {
+		return fmt.Errorf("failed registering remote_write queue metrics: %w", err)
+	}
+
+	i.clockSkew = newClockSkewChecker(log.With(i.logger, "component", "clock_skew"), reg)
+	if err := i.clockSkew.SetConfig(cfg.ClockSkew, cfg.RemoteWrite); err != nil {
+		return fmt.Errorf("failed applying config to clock skew checker: %w", err)
+	}
+
+	i.metadata = newMetadataSender(log.With(i.logger, "component", "metadata"), reg, func() []scrape.MetricMetadata {
+		return collectTargetMetadata(i.readyScrapeManager)
+	})
+	if err := i.metadata.SetConfig(cfg.MetadataMaxPerSend, i.activeRemoteWrites(cfg.RemoteWrite)); err != nil {
+		return fmt.Errorf("failed applying config to metadata sender: %w", err)
+	}
+
+	i.catchUp = newCatchUpController(log.With(i.logger, "component", "catch_up"), reg, newInstanceLagSource(i.gatherer), i.setCatchingUp)
+	i.catchUp.SetConfig(cfg.RemoteWriteCatchUp, cfg.RemoteWrite)
+
+	i.kafkaWriters = nil
+	if len(cfg.KafkaWrite) > 0 {
+		kafkaLogger := log.With(i.logger, "component", "kafka")
+		kafkaMetrics := kafka.NewMetrics(reg)
+		for _, kc := range cfg.KafkaWrite {
+			w, err := kafka.NewWriter(kafkaLogger, kafkaMetrics, *kc, i.wal.Directory(), i.globalCfg.Prometheus.ExternalLabels)
+			if err != nil {
+				return fmt.Errorf("failed creating kafka_write %q: %w", kc.Name, err)
+			}
+			i.kafkaWriters = append(i.kafkaWriters, w)
+		}
+	}
 
-	i.storage = storage.NewFanout(i.logger, i.wal, i.remoteStore)
+	i.rules = nil
+	var rulesHead *rules.Head
+	if cfg.Rules == nil {
+		i.storage = storage.NewFanout(i.logger, i.wal, i.remoteStore)
+	} else {
+		// Scraped samples and rule results are written to the rules head as well
+		// so rules can query them.
+		rulesLogger := log.With(i.logger, "component", "rules")
+		head, err := rules.NewHead(reg, rulesLogger, RulesHeadDirectory(i.wal.Directory()), cfg.Rules.HeadRetention)
+		if err != nil {
+			return fmt.Errorf("error creating rules head: %w", err)
+		}
+		i.storage = storage.NewFanout(i.logger, i.wal, i.remoteStore, head)
+		rulesHead = head
 
-	scrapeManager := newScrapeManager(log.With(i.logger, "component", "scrape manager"), i.storage)
+		i.rules = rules.NewManager(reg, rulesLogger, head, i.storage, i.globalCfg.Prometheus.ExternalLabels)
+		if err := i.rules.ApplyConfig(*cfg.Rules); err != nil {
+			return fmt.Errorf("failed applying config to rule manager: %w", err)
+		}
+	}
+
+	i.canary = newCanary(log.With(i.logger, "component", "canary"), reg, i.storage)
+	if err := i.canary.SetConfig(cfg.Canary, cfg.Name, i.globalCfg.Prometheus.ExternalLabels); err != nil {
+		return fmt.Errorf("failed applying config to canary: %w", err)
+	}
+
+	resources := newResourceCollector(i.logger, i.wal, rulesHead, i.readyScrapeManager)
+	if err := reg.Register(resources); err != nil {
+		return fmt.Errorf("failed registering resource metrics: %w", err)
+	}
+
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "agent_prometheus_instance_scrapes_in_flight",
+		Help: "Number of scrapes of the instance currently running.",
+	}, func() float64 { return float64(i.scrapeLimiter.InFlight()) })
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "agent_prometheus_instance_scrapes_queued",
+		Help: "Number of scrapes of the instance waiting for max_concurrent_scrapes.",
+	}, func() float64 { return float64(i.scrapeLimiter.Queued()) })
+
+	i.scrubbed, err = newScrubbingAppendable(i.storage, cfg.Scrubbing)
+	if err != nil {
+		return fmt.Errorf("invalid scrubbing config: %w", err)
+	}
+	// Samples are aligned before scrubbing, which may change the job label.
+	i.alignment = newTimestampAlignmentAppendable(i.scrubbed, reg)
+	i.alignment.SetConfig(cfg.TimestampAlignment, cfg.ScrapeConfigs)
+	i.labelLimits = &labelLimitsAppendable{
+		Appendable: i.alignment,
+		metrics:    newLabelLimitsMetrics(reg),
+		limits:     cfg.ScrapeLimits,
+	}
+	i.appendStats = newAppendStatsAppendable(i.labelLimits)
+	scrapeApp := &limitedAppendable{
+		Appendable: i.appendStats,
+		limiters:   []*ScrapeLimiter{i.scrapeLimiter, i.globalScrapeLimiter},
+	}
+	scrapeManager := newScrapeManager(log.With(i.logger, "component", "scrape manager"), scrapeApp)
 	err = scrapeManager.ApplyConfig(&config.Config{
 		GlobalConfig:  i.globalCfg.Prometheus,
 		ScrapeConfigs: cfg.ScrapeConfigs,
@@ -396,9 +1012,97 @@ func (i *Instance) initialize(ctx context.Context, reg prometheus.Registerer, cf
 
 	i.readyScrapeManager.Set(scrapeManager)
 
+	i.healthChecker = newHealthChecker(log.With(i.logger, "component", "self_healing"), reg, newInstanceHealthSource(i.gatherer, i.appendStats, i.readyScrapeManager))
+	i.healthChecker.SetConfig(cfg.SelfHealing, cfg.RemoteWrite)
+
 	return nil
 }
 
+// activeRemoteWrites returns the remote_writes to apply to the remote
+// storage: none if the remote_write gate is closed, or remoteWrites changed
+// to send requests through the egress proxy if it's running. i.mut must be
+// held.
+func (i *Instance) activeRemoteWrites(remoteWrites []*config.RemoteWriteConfig) []*config.RemoteWriteConfig {
+	switch {
+	case !i.remoteWriteGate.Open():
+		return nil
+	case i.egressProxy == nil:
+		return remoteWrites
+	default:
+		return i.egressProxy.remoteWrites(remoteWrites)
+	}
+}
+
+// remoteStoreWrites returns the remote_writes of c to apply to the remote
+// storage. i.mut must be held.
+func (i *Instance) remoteStoreWrites(c Config) []*config.RemoteWriteConfig {
+	remoteWrites := i.activeRemoteWrites(withCatchUpShards(c.RemoteWrite, c.RemoteWriteCatchUp))
+	return withoutMetadata(remoteWrites, c.MetadataMaxPerSend)
+}
+
+// setCatchingUp switches remote_write in or out of catch-up mode.
+func (i *Instance) setCatchingUp(catchingUp bool) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	i.catchingUp = catchingUp
+	i.applyCatchUpMode(i.cfg)
+}
+
+// applyCatchUpMode applies the byte limit and shard ceilings of c for the
+// current remote_write mode. i.mut must be held.
+func (i *Instance) applyCatchUpMode(c Config) {
+	catchUp := c.RemoteWriteCatchUp
+	if !i.catchingUp {
+		catchUp = nil
+	}
+
+	bytesPerSecond := c.RemoteWriteBytesPerSecond
+	if catchUp != nil && catchUp.BytesPerSecond > 0 {
+		bytesPerSecond = catchUp.BytesPerSecond
+	}
+	i.egressLimiter.SetLimit(bytesPerSecond)
+	i.connGate.SetLimits(shardCeilings(c.RemoteWrite, catchUp))
+}
+
+// applyRemoteWriteGate starts or stops sending samples to remote_write after
+// the remote_write gate opened or closed.
+func (i *Instance) applyRemoteWriteGate() {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.remoteStore == nil {
+		return
+	}
+
+	open := i.remoteWriteGate.Open()
+	level.Info(i.logger).Log("msg", "remote_write gate changed", "sending_samples", open)
+	err := i.remoteStore.ApplyConfig(&config.Config{
+		GlobalConfig:       i.globalCfg.Prometheus,
+		RemoteWriteConfigs: i.remoteStoreWrites(i.cfg),
+	})
+	if err != nil {
+		level.Error(i.logger).Log("msg", "failed to apply remote_write gate", "err", err)
+	}
+	if i.metadata != nil {
+		if err := i.metadata.SetConfig(i.cfg.MetadataMaxPerSend, i.activeRemoteWrites(i.cfg.RemoteWrite)); err != nil {
+			level.Error(i.logger).Log("msg", "failed to apply remote_write gate to metadata sender", "err", err)
+		}
+	}
+}
+
+func (i *Instance) closeEgressProxy() {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.egressProxy != nil {
+		if err := i.egressProxy.Close(); err != nil {
+			level.Warn(i.logger).Log("msg", "error closing remote_write egress proxy", "err", err)
+		}
+		i.egressProxy = nil
+	}
+}
+
 // Update accepts a new Config for the Instance and will dynamically update any
 // running Prometheus components with the new values from Config. Update will
 // return an ErrInvalidUpdate if the Update could not be applied.
@@ -415,19 +1119,49 @@ func (i *Instance) Update(c Config) (err error) {
 		err = errImmutableField{Field: "name"}
 	case i.cfg.HostFilter != c.HostFilter:
 		err = errImmutableField{Field: "host_filter"}
+	case !util.CompareYAML(i.cfg.HostFilterRelabelConfigs, c.HostFilterRelabelConfigs):
+		err = errImmutableField{Field: "host_filter_relabel_configs"}
+	case !labels.Equal(i.cfg.ExternalLabels, c.ExternalLabels):
+		err = errImmutableField{Field: "external_labels"}
+	case i.cfg.TargetDebounceWindow != c.TargetDebounceWindow:
+		err = errImmutableField{Field: "target_debounce_window"}
 	case i.cfg.WALTruncateFrequency != c.WALTruncateFrequency:
 		err = errImmutableField{Field: "wal_truncate_frequency"}
+	case i.cfg.MaxWALSize != c.MaxWALSize:
+		err = errImmutableField{Field: "max_wal_size_bytes"}
+	case i.cfg.HardMaxWALSize != c.HardMaxWALSize:
+		err = errImmutableField{Field: "hard_max_wal_size_bytes"}
+	case i.cfg.WALCompression != c.WALCompression:
+		err = errImmutableField{Field: "wal_compression"}
+	case !util.CompareYAML(i.cfg.KafkaWrite, c.KafkaWrite):
+		err = errImmutableField{Field: "kafka_write"}
+	case (i.cfg.RemoteWriteBytesPerSecond > 0) != (c.RemoteWriteBytesPerSecond > 0):
+		// Only changes to an existing limit can be applied without recreating
+		// the remote_write clients.
+		err = errImmutableField{Field: "remote_write_bytes_per_second"}
+	case (i.cfg.RemoteWriteCatchUp == nil) != (c.RemoteWriteCatchUp == nil):
+		err = errImmutableField{Field: "remote_write_catch_up"}
 	case i.cfg.RemoteFlushDeadline != c.RemoteFlushDeadline:
 		err = errImmutableField{Field: "remote_flush_deadline"}
 	case i.cfg.WriteStaleOnShutdown != c.WriteStaleOnShutdown:
 		err = errImmutableField{Field: "write_stale_on_shutdown"}
+	case !util.CompareYAML(i.cfg.FaultInjection, c.FaultInjection):
+		err = errImmutableField{Field: "fault_injection"}
+	case !util.CompareYAML(i.cfg.Scrubbing, c.Scrubbing):
+		// Scrapes cache references to scrubbed series, so scrubbing can't
+		// change without recreating the scrape manager.
+		err = errImmutableField{Field: "scrubbing"}
+	case (i.cfg.Rules == nil) != (c.Rules == nil):
+		err = errImmutableField{Field: "rules"}
+	case i.cfg.Rules != nil && i.cfg.Rules.HeadRetention != c.Rules.HeadRetention:
+		err = errImmutableField{Field: "rules.head_retention"}
 	}
 	if err != nil {
 		return ErrInvalidUpdate{Inner: err}
 	}
 
 	// Check to see if the components exist yet.
-	if i.discovery == nil || i.remoteStore == nil || i.readyScrapeManager == nil {
+	if i.discovery == nil || i.remoteStore == nil || i.remoteWriteQueues == nil || i.clockSkew == nil || i.metadata == nil || i.catchUp == nil || i.canary == nil || i.healthChecker == nil || i.readyScrapeManager == nil || i.labelLimits == nil || i.alignment == nil {
 		return ErrInvalidUpdate{
 			Inner: fmt.Errorf("cannot dynamically update because instance is not running"),
 		}
@@ -440,24 +1174,61 @@ func (i *Instance) Update(c Config) (err error) {
 	//
 	// 1. Local config
 	// 2. Remote Store
-	// 3. Scrape Manager
-	// 4. Discovery Manager
+	// 3. Rule Manager
+	// 4. Scrape Manager
+	// 5. Discovery Manager
+
+	// The remote_read_proxy client is only recreated when its config changed
+	// so its connections are reused across requests. Idle connections of a
+	// replaced client are closed by its transport after they time out.
+	readProxy := i.remoteReadProxy
+	if !util.CompareYAML(i.cfg.RemoteReadProxy, c.RemoteReadProxy) {
+		readProxy = nil
+		if c.RemoteReadProxy != nil {
+			readProxy, err = NewRemoteReadProxy(c, i.globalCfg)
+			if err != nil {
+				return fmt.Errorf("error applying new remote_read_proxy config: %w", err)
+			}
+		}
+	}
 
 	originalConfig := i.cfg
 	defer func() {
 		if err != nil {
 			i.cfg = originalConfig
+		} else {
+			i.remoteReadProxy = readProxy
 		}
 	}()
 	i.cfg = c
 
+	i.applyCatchUpMode(c)
 	err = i.remoteStore.ApplyConfig(&config.Config{
 		GlobalConfig:       i.globalCfg.Prometheus,
-		RemoteWriteConfigs: c.RemoteWrite,
+		RemoteWriteConfigs: i.remoteStoreWrites(c),
 	})
 	if err != nil {
 		return fmt.Errorf("error applying new remote_write configs: %w", err)
 	}
+	i.remoteWriteQueues.SetConfigs(c.RemoteWrite)
+	if err := i.clockSkew.SetConfig(c.ClockSkew, c.RemoteWrite); err != nil {
+		return fmt.Errorf("error applying new clock_skew config: %w", err)
+	}
+	if err := i.metadata.SetConfig(c.MetadataMaxPerSend, i.activeRemoteWrites(c.RemoteWrite)); err != nil {
+		return fmt.Errorf("error applying new metadata_max_per_send config: %w", err)
+	}
+	i.catchUp.SetConfig(c.RemoteWriteCatchUp, c.RemoteWrite)
+	if err := i.canary.SetConfig(c.Canary, c.Name, i.globalCfg.Prometheus.ExternalLabels); err != nil {
+		return fmt.Errorf("error applying new canary config: %w", err)
+	}
+	i.healthChecker.SetConfig(c.SelfHealing, c.RemoteWrite)
+
+	if i.rules != nil {
+		err = i.rules.ApplyConfig(*c.Rules)
+		if err != nil {
+			return fmt.Errorf("error applying new rules: %w", err)
+		}
+	}
 
 	sm, err := i.readyScrapeManager.Get()
 	if err != nil {
@@ -473,16 +1244,65 @@ func (i *Instance) Update(c Config) (err error) {
 
 	sdConfigs := map[string]discovery.Configs{}
 	for _, v := range c.ScrapeConfigs {
-		sdConfigs[v.JobName] = v.ServiceDiscoveryConfigs
+		sdConfigs[v.JobName] = wrapDNSSDConfigs(v.ServiceDiscoveryConfigs, c.DNSSD, i.dnsSDMetrics)
 	}
 	err = i.discovery.Manager.ApplyConfig(sdConfigs)
 	if err != nil {
 		return fmt.Errorf("failed applying configs to discovery manager: %w", err)
 	}
 
+	i.scrapeLimiter.SetLimit(c.MaxConcurrentScrapes)
+	i.labelLimits.SetLimits(c.ScrapeLimits)
+	i.alignment.SetConfig(c.TimestampAlignment, c.ScrapeConfigs)
+	i.wal.SetAppendOptions(c.walAppendOptions())
 	return nil
 }
 
+// Preload creates the WAL storage of the instance without running it, so the
+// next call to Run starts without creating or replaying the WAL. Storage
+// that's never run must be released with Unload.
+func (i *Instance) Preload() error {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.preloaded != nil {
+		return nil
+	}
+
+	reg := util.WrapWithUnregisterer(i.reg)
+	w, err := i.newWal(reg)
+	if err != nil {
+		reg.UnregisterAll()
+		return fmt.Errorf("error creating WAL: %w", err)
+	}
+	i.preloaded, i.preloadedReg = w, reg
+	return nil
+}
+
+// Unload closes the storage created by Preload if Run hasn't used it.
+func (i *Instance) Unload() error {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.preloaded == nil {
+		return nil
+	}
+
+	err := i.preloaded.Close()
+	i.preloadedReg.UnregisterAll()
+	i.preloaded, i.preloadedReg = nil, nil
+	return err
+}
+
+// RemoteReadProxy returns the proxy forwarding remote_read requests to the
+// remote_read_proxy of the instance. Returns nil if remote_read_proxy isn't
+// configured.
+func (i *Instance) RemoteReadProxy() *RemoteReadProxy {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.remoteReadProxy
+}
+
 // TargetsActive returns the set of active targets from the scrape manager. Returns nil
 // if the scrape manager is not ready yet.
 func (i *Instance) TargetsActive() map[string][]*scrape.Target {
@@ -503,12 +1323,78 @@ func (i *Instance) TargetsActive() map[string][]*scrape.Target {
 	return mgr.TargetsActive()
 }
 
+// TargetsDropped returns the set of targets dropped by relabeling from the
+// scrape manager. Returns nil if the scrape manager is not ready yet.
+func (i *Instance) TargetsDropped() map[string][]*scrape.Target {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.readyScrapeManager == nil {
+		return nil
+	}
+
+	mgr, err := i.readyScrapeManager.Get()
+	if err == ErrNotReady {
+		return nil
+	} else if err != nil {
+		level.Error(i.logger).Log("msg", "failed to get scrape manager when collecting dropped targets", "err", err)
+		return nil
+	}
+	return mgr.TargetsDropped()
+}
+
+// AppendStats returns how samples scraped from each target were appended to
+// the WAL, for targets scraped recently. Returns nil if the instance is not
+// running.
+func (i *Instance) AppendStats() map[TargetKey]AppendStats {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.appendStats == nil {
+		return nil
+	}
+	return i.appendStats.Stats()
+}
+
 // StorageDirectory returns the directory where this Instance is writing series
 // and samples to for the WAL.
 func (i *Instance) StorageDirectory() string {
 	return i.wal.Directory()
 }
 
+// Appender returns a storage.Appender from the instance's storage. Samples
+// appended through it are scrubbed like scraped samples, written to the WAL
+// and then sent through the instance's remote_write configs. Returns an
+// appender that always fails if the instance is not running.
+func (i *Instance) Appender(ctx context.Context) storage.Appender {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.scrubbed == nil {
+		return notRunningAppender{}
+	}
+	return i.scrubbed.Appender(ctx)
+}
+
+// ErrNotRunning is returned when appending to an instance that has not
+// initialized its storage.
+var ErrNotRunning = errors.New("instance not running")
+
+// notRunningAppender is a storage.Appender that rejects all samples with
+// ErrNotRunning.
+type notRunningAppender struct{}
+
+func (notRunningAppender) Append(uint64, labels.Labels, int64, float64) (uint64, error) {
+	return 0, ErrNotRunning
+}
+
+func (notRunningAppender) AppendExemplar(uint64, labels.Labels, exemplar.Exemplar) (uint64, error) {
+	return 0, ErrNotRunning
+}
+
+func (notRunningAppender) Commit() error   { return ErrNotRunning }
+func (notRunningAppender) Rollback() error { return nil }
+
 type discoveryService struct {
 	Manager *discovery.Manager
 
@@ -524,8 +1410,9 @@ func (s *discoveryService) SyncCh() GroupChannel { return s.SyncChFunc() }
 // newDiscoveryManager returns an implementation of a runnable service
 // that outputs discovered targets to a channel. The implementation
 // uses the Prometheus Discovery Manager. Targets will be filtered
-// if the instance is configured to perform host filtering.
-func (i *Instance) newDiscoveryManager(ctx context.Context, cfg *Config) (*discoveryService, error) {
+// if the instance is configured to perform host filtering, and their removal
+// delayed if target_debounce_window is set.
+func (i *Instance) newDiscoveryManager(ctx context.Context, reg prometheus.Registerer, cfg *Config) (*discoveryService, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
 	logger := log.With(i.logger, "component", "discovery manager")
@@ -535,7 +1422,7 @@ func (i *Instance) newDiscoveryManager(ctx context.Context, cfg *Config) (*disco
 	// TODO(rfratto): ensure job name name is unique
 	c := map[string]discovery.Configs{}
 	for _, v := range cfg.ScrapeConfigs {
-		c[v.JobName] = v.ServiceDiscoveryConfigs
+		c[v.JobName] = wrapDNSSDConfigs(v.ServiceDiscoveryConfigs, cfg.DNSSD, i.dnsSDMetrics)
 	}
 	err := manager.ApplyConfig(c)
 	if err != nil {
@@ -582,6 +1469,28 @@ func (i *Instance) newDiscoveryManager(ctx context.Context, cfg *Config) (*disco
 		syncChFunc = filterer.SyncCh
 	}
 
+	// If target debouncing is enabled, run it on top of the host filterer so
+	// targets filtered out by it are removed right away.
+	if cfg.TargetDebounceWindow > 0 {
+		debouncer := NewTargetDebouncer(cfg.TargetDebounceWindow)
+		promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "agent_prometheus_debounced_targets",
+			Help: "Number of targets no longer discovered that are kept for target_debounce_window.",
+		}, func() float64 { return float64(debouncer.Held()) })
+
+		inputCh := syncChFunc()
+		rg.Add(func() error {
+			debouncer.Run(inputCh)
+			level.Info(i.logger).Log("msg", "target debouncer stopped")
+			return nil
+		}, func(_ error) {
+			level.Info(i.logger).Log("msg", "stopping target debouncer...")
+			debouncer.Stop()
+		})
+
+		syncChFunc = debouncer.SyncCh
+	}
+
 	return &discoveryService{
 		Manager: manager,
 
@@ -596,86 +1505,161 @@ func (i *Instance) truncateLoop(ctx context.Context, wal walStorage, cfg *Config
 	// deleted until at least some new data has been sent.
 	var lastTs int64 = math.MinInt64
 
+	truncateTicker := time.NewTicker(cfg.WALTruncateFrequency)
+	defer truncateTicker.Stop()
+
+	// The size of the WAL is only checked when a limit is configured.
+	var sizeCheck <-chan time.Time
+	if cfg.MaxWALSize > 0 || cfg.HardMaxWALSize > 0 {
+		sizeTicker := time.NewTicker(walSizeCheckFrequency)
+		defer sizeTicker.Stop()
+		sizeCheck = sizeTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(cfg.WALTruncateFrequency):
-			// The timestamp ts is used to determine which series are not receiving
-			// samples and may be deleted from the WAL. Their most recent append
-			// timestamp is compared to ts, and if that timestamp is older then ts,
-			// they are considered inactive and may be deleted.
-			//
-			// Subtracting a duration from ts will delay when it will be considered
-			// inactive and scheduled for deletion.
-			ts := i.getRemoteWriteTimestamp() - i.cfg.MinWALTime.Milliseconds()
-			if ts < 0 {
-				ts = 0
+		case <-sizeCheck:
+			size, err := wal.Size()
+			if err != nil {
+				level.Warn(i.logger).Log("msg", "could not get WAL size", "err", err)
+				continue
 			}
 
-			// Network issues can prevent the result of getRemoteWriteTimestamp from
-			// changing. We don't want data in the WAL to grow forever, so we set a cap
-			// on the maximum age data can be. If our ts is older than this cutoff point,
-			// we'll shift it forward to start deleting very stale data.
-			if maxTS := timestamp.FromTime(time.Now().Add(-i.cfg.MaxWALTime)); ts < maxTS {
-				ts = maxTS
-			}
+			switch {
+			case cfg.HardMaxWALSize > 0 && size > cfg.HardMaxWALSize:
+				// The oldest segments are dropped even if remote_write hasn't sent
+				// them yet. Series are kept, so scrapes can keep appending to them.
+				level.Warn(i.logger).Log("msg", "WAL exceeds hard_max_wal_size_bytes, dropping oldest segments", "size", size, "limit", cfg.HardMaxWALSize)
+				if err := wal.DropOldestSegments(); err != nil {
+					level.Warn(i.logger).Log("msg", "could not drop oldest WAL segments", "err", err)
+				}
+			case cfg.MaxWALSize > 0 && size > cfg.MaxWALSize:
+				ts := i.truncateTimestamp()
+				if ts == lastTs {
+					level.Warn(i.logger).Log("msg", "WAL exceeds max_wal_size_bytes but remote_write timestamp is unchanged, not truncating", "size", size, "limit", cfg.MaxWALSize)
+					continue
+				}
+				lastTs = ts
 
+				level.Info(i.logger).Log("msg", "WAL exceeds max_wal_size_bytes, truncating early", "size", size, "limit", cfg.MaxWALSize)
+				i.truncate(wal, ts)
+			}
+		case <-truncateTicker.C:
+			ts := i.truncateTimestamp()
 			if ts == lastTs {
 				level.Debug(i.logger).Log("msg", "not truncating the WAL, remote_write timestamp is unchanged", "ts", ts)
 				continue
 			}
 			lastTs = ts
-
-			level.Debug(i.logger).Log("msg", "truncating the WAL", "ts", ts)
-			err := wal.Truncate(ts)
-			if err != nil {
-				// The only issue here is larger disk usage and a greater replay time,
-				// so we'll only log this as a warning.
-				level.Warn(i.logger).Log("msg", "could not truncate WAL", "err", err)
-			}
+			i.truncate(wal, ts)
 		}
 	}
 }
 
+// truncateTimestamp returns the timestamp that the WAL should be truncated
+// to based on remote_write progress and the configured WAL times.
+func (i *Instance) truncateTimestamp() int64 {
+	// The timestamp ts is used to determine which series are not receiving
+	// samples and may be deleted from the WAL. Their most recent append
+	// timestamp is compared to ts, and if that timestamp is older then ts,
+	// they are considered inactive and may be deleted.
+	//
+	// Subtracting a duration from ts will delay when it will be considered
+	// inactive and scheduled for deletion.
+	ts := i.getRemoteWriteTimestamp() - i.cfg.MinWALTime.Milliseconds()
+	if ts < 0 {
+		ts = 0
+	}
+
+	// Network issues can prevent the result of getRemoteWriteTimestamp from
+	// changing. We don't want data in the WAL to grow forever, so we set a cap
+	// on the maximum age data can be. If our ts is older than this cutoff point,
+	// we'll shift it forward to start deleting very stale data.
+	if maxTS := timestamp.FromTime(time.Now().Add(-i.cfg.MaxWALTime)); ts < maxTS {
+		ts = maxTS
+	}
+
+	return ts
+}
+
+func (i *Instance) truncate(wal walStorage, ts int64) {
+	level.Debug(i.logger).Log("msg", "truncating the WAL", "ts", ts)
+	err := wal.Truncate(ts)
+	if err != nil {
+		// The only issue here is larger disk usage and a greater replay time,
+		// so we'll only log this as a warning.
+		level.Warn(i.logger).Log("msg", "could not truncate WAL", "err", err)
+	}
+}
+
 // getRemoteWriteTimestamp looks up the last successful remote write timestamp.
-// This is passed to wal.Storage for its truncation. If no remote write sections
-// are configured, getRemoteWriteTimestamp returns the current time.
+// This is passed to wal.Storage for its truncation. If no remote write or
+// kafka write sections are configured, getRemoteWriteTimestamp returns the
+// current time.
 func (i *Instance) getRemoteWriteTimestamp() int64 {
 	i.mut.Lock()
 	defer i.mut.Unlock()
 
-	if len(i.cfg.RemoteWrite) == 0 {
+	if len(i.cfg.RemoteWrite) == 0 && len(i.kafkaWriters) == 0 {
 		return timestamp.FromTime(time.Now())
 	}
 
-	lbls := make([]string, len(i.cfg.RemoteWrite))
-	for idx := 0; idx < len(lbls); idx++ {
-		lbls[idx] = i.cfg.RemoteWrite[idx].Name
-	}
+	// We use the lowest value since we don't want to delete any segments from
+	// the WAL until they've been written by all of the remote_write and
+	// kafka_write configurations.
+	ts := int64(math.MaxInt64)
 
-	vals, err := i.vc.GetValues("remote_name", lbls...)
-	if err != nil {
-		level.Error(i.logger).Log("msg", "could not get remote write timestamps", "err", err)
-		return 0
-	}
-	if len(vals) == 0 {
-		return 0
+	if len(i.cfg.RemoteWrite) > 0 {
+		lbls := make([]string, len(i.cfg.RemoteWrite))
+		for idx := 0; idx < len(lbls); idx++ {
+			lbls[idx] = i.cfg.RemoteWrite[idx].Name
+		}
+
+		vals, err := i.vc.GetValues("remote_name", lbls...)
+		if err != nil {
+			level.Error(i.logger).Log("msg", "could not get remote write timestamps", "err", err)
+			return 0
+		}
+		if len(vals) == 0 {
+			return 0
+		}
+
+		for _, val := range vals {
+			// Convert to the millisecond precision which is used by the WAL
+			ival := int64(val) * 1000
+			if ival < ts {
+				ts = ival
+			}
+		}
 	}
 
-	// We use the lowest value from the metric since we don't want to delete any
-	// segments from the WAL until they've been written by all of the remote_write
-	// configurations.
-	ts := int64(math.MaxInt64)
-	for _, val := range vals {
-		ival := int64(val)
-		if ival < ts {
-			ts = ival
+	for _, kw := range i.kafkaWriters {
+		if sent := kw.HighestSentTimestamp(); sent < ts {
+			ts = sent
 		}
 	}
 
-	// Convert to the millisecond precision which is used by the WAL
-	return ts * 1000
+	return ts
+}
+
+// rulesHeadSuffix is appended to the storage directory of an instance to
+// name the directory of its rules head.
+const rulesHeadSuffix = ".rules_head"
+
+// RulesHeadDirectory returns the directory holding the rules head of the
+// instance storing its WAL in storageDir. It's a sibling of storageDir so
+// the rules head doesn't count towards the size of the WAL and isn't
+// archived with it.
+func RulesHeadDirectory(storageDir string) string {
+	return storageDir + rulesHeadSuffix
+}
+
+// IsRulesHeadDirectory returns true if dir is the directory of the rules head
+// of an instance.
+func IsRulesHeadDirectory(dir string) bool {
+	return strings.HasSuffix(dir, rulesHeadSuffix)
 }
 
 // walStorage is an interface satisfied by wal.Storage, and created for testing.
@@ -688,8 +1672,12 @@ type walStorage interface {
 
 	StartTime() (int64, error)
 	WriteStalenessMarkers(remoteTsFunc func() int64) error
+	SetAppendOptions(opts wal.AppendOptions)
 	Appender(context.Context) storage.Appender
 	Truncate(mint int64) error
+	DropOldestSegments() error
+	Size() (int64, error)
+	SeriesStats() wal.SeriesStats
 
 	Close() error
 }
@@ -1,21 +1,33 @@
 package instance
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
 	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/prom/kafka"
+	"github.com/grafana/agent/pkg/prom/rules"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
 )
@@ -65,7 +77,8 @@ name: integration_test
 scrape_configs: []
 remote_write: []
 `)
-	inst, err := New(prometheus.NewRegistry(), DefaultGlobalConfig, initialConfig, walDir, logger)
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, DefaultGlobalConfig, initialConfig, walDir, 0, nil, nil, nil, logger)
 	require.NoError(t, err)
 
 	instCtx, cancel := context.WithCancel(context.Background())
@@ -131,7 +144,8 @@ name: integration_test
 scrape_configs: []
 remote_write: []
 `)
-	inst, err := New(prometheus.NewRegistry(), DefaultGlobalConfig, initialConfig, walDir, logger)
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, DefaultGlobalConfig, initialConfig, walDir, 0, nil, nil, nil, logger)
 	require.NoError(t, err)
 
 	instCtx, cancel := context.WithCancel(context.Background())
@@ -168,6 +182,354 @@ remote_write:
 	require.Equal(t, newConfig, inst.cfg, "config did not roll back")
 }
 
+// TestInstance_RemoteReadProxy ensures that the remote_read_proxy of an
+// instance reuses its connections across requests and is only recreated when
+// its config changes.
+func TestInstance_RemoteReadProxy(t *testing.T) {
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(walDir) })
+
+	newConns := atomic.NewInt64(0)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, remote.EncodeReadResponse(&prompb.ReadResponse{
+			Results: []*prompb.QueryResult{{}},
+		}, w))
+	}))
+	backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Inc()
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	cfgText := `
+name: integration_test
+scrape_configs: []
+remote_write: []
+remote_read_proxy:
+  url: %s
+  remote_timeout: %s
+`
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, DefaultGlobalConfig, loadConfig(t, fmt.Sprintf(cfgText, backend.URL, "1m")), walDir, 0, nil, nil, nil, logger)
+	require.NoError(t, err)
+
+	req := &prompb.ReadRequest{Queries: []*prompb.Query{{EndTimestampMs: 1000}}}
+	data, err := req.Marshal()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		inst.RemoteReadProxy().ServeHTTP(rec, httptest.NewRequest("POST", "/", bytes.NewReader(snappy.Encode(nil, data))))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	require.Equal(t, int64(1), newConns.Load())
+
+	instCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		err := inst.Run(instCtx)
+		require.NoError(t, err)
+	}()
+
+	// An update that doesn't change remote_read_proxy keeps the proxy.
+	proxy := inst.RemoteReadProxy()
+	test.Poll(t, time.Second*15, nil, func() interface{} {
+		return inst.Update(loadConfig(t, fmt.Sprintf(cfgText, backend.URL, "1m")))
+	})
+	require.Same(t, proxy, inst.RemoteReadProxy())
+
+	// Changing remote_read_proxy recreates it.
+	require.NoError(t, inst.Update(loadConfig(t, fmt.Sprintf(cfgText, backend.URL, "30s"))))
+	require.NotNil(t, inst.RemoteReadProxy())
+	require.NotSame(t, proxy, inst.RemoteReadProxy())
+
+	// Removing remote_read_proxy removes it.
+	require.NoError(t, inst.Update(loadConfig(t, `
+name: integration_test
+scrape_configs: []
+remote_write: []
+`)))
+	require.Nil(t, inst.RemoteReadProxy())
+}
+
+// TestInstance_Metadata ensures that metric metadata from scrape targets is
+// forwarded to remote_write endpoints.
+func TestInstance_Metadata(t *testing.T) {
+	t.Run("single request", func(t *testing.T) {
+		testInstanceMetadata(t, 0)
+	})
+	t.Run("metadata_max_per_send", func(t *testing.T) {
+		testInstanceMetadata(t, 2)
+	})
+}
+
+// testInstanceMetadata runs an instance that sends metric metadata and
+// validates that no request had more than maxPerSend entries if it is set.
+func testInstanceMetadata(t *testing.T, maxPerSend int) {
+	t.Helper()
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(walDir) })
+
+	var (
+		mut      sync.Mutex
+		metadata = map[string]prompb.MetricMetadata{}
+		maxBatch int
+	)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		promhttp.Handler().ServeHTTP(w, r)
+	})
+	r.HandleFunc("/push", func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bb, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req prompb.WriteRequest
+		if err := req.Unmarshal(bb); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mut.Lock()
+		defer mut.Unlock()
+		if len(req.Metadata) > maxBatch {
+			maxBatch = len(req.Metadata)
+		}
+		for _, md := range req.Metadata {
+			metadata[md.MetricFamilyName] = md
+		}
+	})
+
+	// Start a server for exposing the router.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		_ = http.Serve(l, r)
+	}()
+
+	cfg := loadConfig(t, fmt.Sprintf(`
+name: integration_test
+scrape_configs:
+  - job_name: test_scrape
+    scrape_interval: 1s
+    static_configs:
+      - targets: ['%[1]s']
+remote_write:
+  - url: http://%[1]s/push
+    metadata_config:
+      send: true
+      send_interval: 1s
+metadata_max_per_send: %[2]d
+`, l.Addr(), maxPerSend))
+
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, DefaultGlobalConfig, cfg, walDir, 0, nil, nil, nil, logger)
+	require.NoError(t, err)
+
+	instCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		err := inst.Run(instCtx)
+		require.NoError(t, err)
+	}()
+
+	test.Poll(t, time.Second*15, true, func() interface{} {
+		mut.Lock()
+		defer mut.Unlock()
+		md, ok := metadata["go_goroutines"]
+		return ok && md.Type == prompb.MetricMetadata_GAUGE && md.Help != ""
+	})
+
+	if maxPerSend > 0 {
+		mut.Lock()
+		defer mut.Unlock()
+		require.LessOrEqual(t, maxBatch, maxPerSend)
+	}
+}
+
+// TestInstance_RemoteWriteCatchUp runs an instance with remote_write
+// catch-up mode enabled and validates that samples are sent through the
+// egress proxy enforcing its shard ceilings.
+func TestInstance_RemoteWriteCatchUp(t *testing.T) {
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(walDir) })
+
+	var (
+		pushed  = atomic.NewBool(false)
+		proxied = atomic.NewBool(false)
+	)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		promhttp.Handler().ServeHTTP(w, r)
+	})
+	r.HandleFunc("/push", func(w http.ResponseWriter, r *http.Request) {
+		pushed.Store(true)
+		// Requests forwarded by the egress proxy are sent with the
+		// X-Forwarded-For header.
+		if r.Header.Get("X-Forwarded-For") != "" {
+			proxied.Store(true)
+		}
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		_ = http.Serve(l, r)
+	}()
+
+	cfg := loadConfig(t, fmt.Sprintf(`
+name: integration_test
+scrape_configs:
+  - job_name: test_scrape
+    scrape_interval: 1s
+    static_configs:
+      - targets: ['%[1]s']
+remote_write:
+  - url: http://%[1]s/push
+    queue_config:
+      max_shards: 2
+remote_write_catch_up:
+  max_shards: 10
+  check_interval: 100ms
+`, l.Addr()))
+
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, DefaultGlobalConfig, cfg, walDir, 0, nil, nil, nil, logger)
+	require.NoError(t, err)
+
+	instCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		err := inst.Run(instCtx)
+		require.NoError(t, err)
+	}()
+
+	test.Poll(t, time.Second*15, true, func() interface{} {
+		return pushed.Load() && proxied.Load()
+	})
+
+	inst.mut.Lock()
+	defer inst.mut.Unlock()
+	require.False(t, inst.catchingUp)
+	require.Equal(t, map[string]int{l.Addr().String(): 2}, inst.connGate.limits)
+}
+
+// TestInstance_Rules runs an instance with a recording rule and validates
+// that series recorded from scraped samples are sent through remote_write.
+func TestInstance_Rules(t *testing.T) {
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(walDir) })
+
+	var (
+		mut      sync.Mutex
+		recorded = map[string]struct{}{}
+	)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		promhttp.Handler().ServeHTTP(w, r)
+	})
+	r.HandleFunc("/push", func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bb, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req prompb.WriteRequest
+		if err := req.Unmarshal(bb); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mut.Lock()
+		defer mut.Unlock()
+		for _, ts := range req.Timeseries {
+			for _, l := range ts.Labels {
+				if l.Name == model.MetricNameLabel {
+					recorded[l.Value] = struct{}{}
+				}
+			}
+		}
+	})
+
+	// Start a server for exposing the router.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		_ = http.Serve(l, r)
+	}()
+
+	cfg := loadConfig(t, fmt.Sprintf(`
+name: integration_test
+scrape_configs:
+  - job_name: test_scrape
+    scrape_interval: 1s
+    static_configs:
+      - targets: ['%[1]s']
+remote_write:
+  - url: http://%[1]s/push
+rules:
+  groups:
+    - name: test
+      interval: 1s
+      rules:
+        - record: job:go_goroutines:sum
+          expr: sum by (job) (go_goroutines)
+`, l.Addr()))
+
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, DefaultGlobalConfig, cfg, walDir, 0, nil, nil, nil, logger)
+	require.NoError(t, err)
+
+	instCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		err := inst.Run(instCtx)
+		require.NoError(t, err)
+	}()
+
+	test.Poll(t, time.Second*15, true, func() interface{} {
+		mut.Lock()
+		defer mut.Unlock()
+		_, ok := recorded["job:go_goroutines:sum"]
+		return ok
+	})
+
+	// The rules head is kept next to the WAL rather than inside it.
+	require.DirExists(t, filepath.Join(walDir, "integration_test.rules_head"))
+	require.NoDirExists(t, filepath.Join(walDir, "integration_test", "rules_head"))
+}
+
 // TestInstance_Update_InvalidChanges runs an instance with a blank initial
 // config and performs various unacceptable updates that should return an
 // error.
@@ -184,7 +546,8 @@ name: integration_test
 scrape_configs: []
 remote_write: []
 `)
-	inst, err := New(prometheus.NewRegistry(), DefaultGlobalConfig, initialConfig, walDir, logger)
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, DefaultGlobalConfig, initialConfig, walDir, 0, nil, nil, nil, logger)
 	require.NoError(t, err)
 
 	instCtx, cancel := context.WithCancel(context.Background())
@@ -218,6 +581,20 @@ remote_write: []
 			mut:    func(c *Config) { c.HostFilter = true },
 			expect: "host_filter cannot be changed dynamically",
 		},
+		{
+			name: "host_filter_relabel_configs changed",
+			mut: func(c *Config) {
+				c.HostFilterRelabelConfigs = []*relabel.Config{{
+					SourceLabels: model.LabelNames{"__meta_ec2_instance_id"},
+					Action:       relabel.Replace,
+					Separator:    ";",
+					Regex:        relabel.MustNewRegexp("(.*)"),
+					Replacement:  "$1",
+					TargetLabel:  "__host__",
+				}}
+			},
+			expect: "host_filter_relabel_configs cannot be changed dynamically",
+		},
 		{
 			name:   "wal_truncate_frequency changed",
 			mut:    func(c *Config) { c.WALTruncateFrequency *= 2 },
@@ -228,11 +605,48 @@ remote_write: []
 			mut:    func(c *Config) { c.RemoteFlushDeadline *= 2 },
 			expect: "remote_flush_deadline cannot be changed dynamically",
 		},
+		{
+			name: "kafka_write changed",
+			mut: func(c *Config) {
+				c.KafkaWrite = []*kafka.Config{{Name: "kafka", Brokers: []string{"localhost:9092"}, Topic: "metrics"}}
+			},
+			expect: "kafka_write cannot be changed dynamically",
+		},
+		{
+			name:   "external_labels changed",
+			mut:    func(c *Config) { c.ExternalLabels = labels.FromStrings("cluster", "dev") },
+			expect: "external_labels cannot be changed dynamically",
+		},
+		{
+			name:   "remote_write_catch_up enabled",
+			mut:    func(c *Config) { c.RemoteWriteCatchUp = &CatchUpConfig{MaxShards: 10} },
+			expect: "remote_write_catch_up cannot be changed dynamically",
+		},
+		{
+			name:   "wal_compression changed",
+			mut:    func(c *Config) { c.WALCompression = !c.WALCompression },
+			expect: "wal_compression cannot be changed dynamically",
+		},
 		{
 			name:   "write_stale_on_shutdown changed",
 			mut:    func(c *Config) { c.WriteStaleOnShutdown = true },
 			expect: "write_stale_on_shutdown cannot be changed dynamically",
 		},
+		{
+			name: "rules enabled",
+			mut: func(c *Config) {
+				rulesCfg := rules.DefaultConfig
+				c.Rules = &rulesCfg
+			},
+			expect: "rules cannot be changed dynamically",
+		},
+		{
+			name: "target_debounce_window",
+			mut: func(c *Config) {
+				c.TargetDebounceWindow = time.Minute
+			},
+			expect: "target_debounce_window cannot be changed dynamically",
+		},
 	}
 
 	for _, tc := range tt {
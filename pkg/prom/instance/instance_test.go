@@ -14,6 +14,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/prom/wal"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
@@ -21,8 +22,10 @@ import (
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/pkg/exemplar"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 )
 
 func TestConfig_Unmarshal_Defaults(t *testing.T) {
@@ -69,7 +72,7 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 			}},
 		},
 	}}
-	cfg.RemoteWrite = []*config.RemoteWriteConfig{{Name: "write"}}
+	cfg.RemoteWrite = []*config.RemoteWriteConfig{{Name: "write", QueueConfig: config.DefaultQueueConfig}}
 
 	tt := []struct {
 		name     string
@@ -101,6 +104,19 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 			func(c *Config) { c.RemoteFlushDeadline = 0 },
 			fmt.Errorf("remote_flush_deadline must be greater than 0s"),
 		},
+		{
+			"hard max wal size less than max wal size",
+			func(c *Config) {
+				c.MaxWALSize = 2048
+				c.HardMaxWALSize = 1024
+			},
+			fmt.Errorf("hard_max_wal_size_bytes must not be less than max_wal_size_bytes"),
+		},
+		{
+			"negative out of order tolerance",
+			func(c *Config) { c.OutOfOrderTolerance = -time.Second },
+			fmt.Errorf("out_of_order_tolerance must not be negative"),
+		},
 		{
 			"scrape timeout too high",
 			func(c *Config) { c.ScrapeConfigs[0].ScrapeTimeout = global.Prometheus.ScrapeInterval + 1 },
@@ -129,12 +145,32 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 			"multiple remote writes with same name",
 			func(c *Config) {
 				c.RemoteWrite = []*config.RemoteWriteConfig{
-					{Name: "foo"},
-					{Name: "foo"},
+					{Name: "foo", QueueConfig: config.DefaultQueueConfig},
+					{Name: "foo", QueueConfig: config.DefaultQueueConfig},
 				}
 			},
 			fmt.Errorf("found duplicate remote write configs with name \"foo\""),
 		},
+		{
+			"remote write max shards less than min shards",
+			func(c *Config) {
+				c.RemoteWrite[0].QueueConfig.MinShards = 10
+				c.RemoteWrite[0].QueueConfig.MaxShards = 5
+			},
+			fmt.Errorf("invalid queue_config for remote_write \"write\": max_shards must not be less than min_shards"),
+		},
+		{
+			"remote write max backoff less than min backoff",
+			func(c *Config) {
+				c.RemoteWrite[0].QueueConfig.MaxBackoff = c.RemoteWrite[0].QueueConfig.MinBackoff / 2
+			},
+			fmt.Errorf("invalid queue_config for remote_write \"write\": max_backoff must not be less than min_backoff"),
+		},
+		{
+			"canary without query url",
+			func(c *Config) { c.Canary = &CanaryConfig{Interval: time.Minute} },
+			fmt.Errorf("canary.query_url must be set"),
+		},
 	}
 
 	for _, tc := range tt {
@@ -171,6 +207,51 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 	}
 }
 
+func TestConfig_ApplyDefaults_ScrapeHTTPClientConfig(t *testing.T) {
+	global := DefaultGlobalConfig
+	cfgText := `name: test
+scrape_http_client_config:
+  bearer_token_file: /var/run/secrets/token
+  proxy_url: http://proxy:3128
+  tls_config:
+    ca_file: /var/run/secrets/ca.crt
+    insecure_skip_verify: false
+scrape_configs:
+  - job_name: inherits
+    static_configs:
+      - targets: ['127.0.0.1:12345']
+  - job_name: overrides
+    basic_auth:
+      username: user
+      password: pass
+    tls_config:
+      ca_file: /etc/other/ca.crt
+    static_configs:
+      - targets: ['127.0.0.1:12345']`
+
+	cfg, err := UnmarshalConfig(strings.NewReader(cfgText))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ApplyDefaults(&global))
+
+	inherits := cfg.ScrapeConfigs[0].HTTPClientConfig
+	require.NotNil(t, inherits.Authorization)
+	require.Equal(t, "/var/run/secrets/token", inherits.Authorization.CredentialsFile)
+	require.Equal(t, "http://proxy:3128", inherits.ProxyURL.String())
+	require.Equal(t, "/var/run/secrets/ca.crt", inherits.TLSConfig.CAFile)
+
+	overrides := cfg.ScrapeConfigs[1].HTTPClientConfig
+	require.Nil(t, overrides.Authorization)
+	require.NotNil(t, overrides.BasicAuth)
+	require.Equal(t, "user", overrides.BasicAuth.Username)
+	require.Equal(t, "http://proxy:3128", overrides.ProxyURL.String())
+	require.Equal(t, "/etc/other/ca.crt", overrides.TLSConfig.CAFile)
+
+	// Applying defaults again must not change the result.
+	require.NoError(t, cfg.ApplyDefaults(&global))
+	require.Equal(t, inherits, cfg.ScrapeConfigs[0].HTTPClientConfig)
+	require.Equal(t, overrides, cfg.ScrapeConfigs[1].HTTPClientConfig)
+}
+
 func TestConfig_ApplyDefaults_HashedName(t *testing.T) {
 	global := DefaultGlobalConfig
 
@@ -187,6 +268,80 @@ remote_write:
 	require.NotEmpty(t, cfg.RemoteWrite[0].Name)
 }
 
+func TestConfig_ApplyDefaults_KafkaWrite(t *testing.T) {
+	global := DefaultGlobalConfig
+	global.RemoteWrite = []*config.RemoteWriteConfig{{Name: "global"}}
+
+	cfgText := `
+name: default
+kafka_write:
+- brokers: [localhost:9092]
+  topic: metrics
+- name: named
+  brokers: [localhost:9092]
+  topic: other`
+
+	cfg, err := UnmarshalConfig(strings.NewReader(cfgText))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ApplyDefaults(&global))
+
+	require.True(t, strings.HasPrefix(cfg.KafkaWrite[0].Name, "default-"))
+	require.Equal(t, "named", cfg.KafkaWrite[1].Name)
+
+	// Instances using kafka_write don't inherit the global remote_write.
+	require.Empty(t, cfg.RemoteWrite)
+
+	cfg.KafkaWrite[0].Name = "named"
+	require.EqualError(t, cfg.ApplyDefaults(&global), `found duplicate kafka_write configs with name "named"`)
+}
+
+func TestConfig_ExternalLabels(t *testing.T) {
+	global := DefaultGlobalConfig
+	global.Prometheus.ExternalLabels = labels.FromStrings("cluster", "prod", "region", "us-east-1")
+
+	cfgText := `
+name: default
+external_labels:
+  cluster: dev
+  team: observability`
+
+	cfg, err := UnmarshalConfig(strings.NewReader(cfgText))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ApplyDefaults(&global))
+
+	inst, err := newInstance(global, *cfg, nil, prometheus.NewRegistry(), log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	// Instance labels override global labels with the same name.
+	expect := labels.FromStrings("cluster", "dev", "region", "us-east-1", "team", "observability")
+	require.Equal(t, expect, inst.globalCfg.Prometheus.ExternalLabels)
+
+	// The global config must not be modified.
+	require.Equal(t, "prod", global.Prometheus.ExternalLabels.Get("cluster"))
+
+	cfg.ExternalLabels = labels.FromStrings("not-valid", "value")
+	require.EqualError(t, cfg.ApplyDefaults(&global), `"not-valid" is not a valid external label name`)
+}
+
+// TestInstance_RemoteWriteTimestamp ensures remote_write progress is read
+// from the gatherer passed to the instance rather than the global one.
+func TestInstance_RemoteWriteTimestamp(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sent := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "agent_prom_remote_storage_queue_highest_sent_timestamp_seconds",
+	}, []string{"remote_name"})
+	reg.MustRegister(sent)
+	sent.WithLabelValues("default-rw").Set(100)
+
+	cfg := DefaultConfig
+	cfg.Name = "default"
+	cfg.RemoteWrite = []*config.RemoteWriteConfig{{Name: "default-rw"}}
+
+	inst, err := newInstance(DefaultGlobalConfig, cfg, reg, reg, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(100000), inst.getRemoteWriteTimestamp())
+}
+
 func TestInstance_Path(t *testing.T) {
 	scrapeAddr, closeSrv := getTestServer(t)
 	defer closeSrv()
@@ -202,7 +357,8 @@ func TestInstance_Path(t *testing.T) {
 	cfg.RemoteFlushDeadline = time.Hour
 
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	inst, err := New(prometheus.NewRegistry(), globalConfig, cfg, walDir, logger)
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, globalConfig, cfg, walDir, 0, nil, nil, nil, logger)
 	require.NoError(t, err)
 	runInstance(t, inst)
 
@@ -237,7 +393,7 @@ func TestInstance(t *testing.T) {
 	newWal := func(_ prometheus.Registerer) (walStorage, error) { return &mockStorage, nil }
 
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	inst, err := newInstance(globalConfig, cfg, nil, logger, newWal)
+	inst, err := newInstance(globalConfig, cfg, nil, prometheus.NewRegistry(), logger, newWal)
 	require.NoError(t, err)
 	runInstance(t, inst)
 
@@ -267,7 +423,8 @@ func TestInstance_Recreate(t *testing.T) {
 	cfg.RemoteFlushDeadline = time.Hour
 
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	inst, err := New(prometheus.NewRegistry(), globalConfig, cfg, walDir, logger)
+	reg := prometheus.NewRegistry()
+	inst, err := New(reg, reg, globalConfig, cfg, walDir, 0, nil, nil, nil, logger)
 	require.NoError(t, err)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -287,7 +444,8 @@ func TestInstance_Recreate(t *testing.T) {
 
 	// Recreate the instance, no panic should happen.
 	require.NotPanics(t, func() {
-		inst, err := New(prometheus.NewRegistry(), globalConfig, cfg, walDir, logger)
+		reg := prometheus.NewRegistry()
+		inst, err := New(reg, reg, globalConfig, cfg, walDir, 0, nil, nil, nil, logger)
 		require.NoError(t, err)
 		runInstance(t, inst)
 
@@ -295,6 +453,48 @@ func TestInstance_Recreate(t *testing.T) {
 	})
 }
 
+// TestInstance_Preload ensures that running a preloaded instance uses the WAL
+// created by Preload instead of creating a new one.
+func TestInstance_Preload(t *testing.T) {
+	scrapeAddr, closeSrv := getTestServer(t)
+	defer closeSrv()
+
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	globalConfig := getTestGlobalConfig(t)
+
+	cfg := getTestConfig(t, &globalConfig, scrapeAddr)
+	cfg.WALTruncateFrequency = time.Hour
+	cfg.RemoteFlushDeadline = time.Hour
+
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	created := atomic.NewInt64(0)
+	newWal := func(reg prometheus.Registerer) (walStorage, error) {
+		created.Inc()
+		return wal.NewStorage(logger, reg, walDir, 0, false)
+	}
+
+	reg := prometheus.NewRegistry()
+	inst, err := newInstance(globalConfig, cfg, reg, reg, logger, newWal)
+	require.NoError(t, err)
+
+	// Unloading releases the WAL and its metrics so it can be preloaded again.
+	require.NoError(t, inst.Preload())
+	require.NoError(t, inst.Unload())
+	require.NoError(t, inst.Preload())
+	require.Equal(t, int64(2), created.Load())
+	require.DirExists(t, wal.SubDirectory(walDir))
+
+	runInstance(t, inst)
+	test.Poll(t, 30*time.Second, true, func() interface{} {
+		return len(inst.TargetsActive()) > 0
+	})
+	require.Equal(t, int64(2), created.Load())
+}
+
 func TestMetricValueCollector(t *testing.T) {
 	r := prometheus.NewRegistry()
 	vc := NewMetricValueCollector(r, "this_should_be_tracked")
@@ -404,6 +604,114 @@ func getTestConfig(t *testing.T, global *GlobalConfig, scrapeAddr string) Config
 	return cfg
 }
 
+func TestInstance_truncateLoopSizeLimits(t *testing.T) {
+	oldFrequency := walSizeCheckFrequency
+	walSizeCheckFrequency = 10 * time.Millisecond
+	defer func() { walSizeCheckFrequency = oldFrequency }()
+
+	tt := []struct {
+		name        string
+		size        int64
+		soft        int64
+		hard        int64
+		expectMin   func() int64
+		expectDrops bool
+	}{
+		{
+			name:      "under limits",
+			size:      100,
+			soft:      1000,
+			hard:      2000,
+			expectMin: nil,
+		},
+		{
+			name: "soft limit",
+			size: 1500,
+			soft: 1000,
+			hard: 2000,
+			expectMin: func() int64 {
+				return timestamp.FromTime(time.Now().Add(-DefaultConfig.MinWALTime - time.Minute))
+			},
+		},
+		{
+			name:        "hard limit",
+			size:        2500,
+			soft:        1000,
+			hard:        2000,
+			expectDrops: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig
+			cfg.Name = "test"
+			cfg.MaxWALSize = tc.soft
+			cfg.HardMaxWALSize = tc.hard
+
+			inst := &Instance{cfg: cfg, logger: log.NewNopLogger()}
+			wal := &sizedWalStorage{size: tc.size}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			// Don't run the periodic truncation; only size checks should cause
+			// truncations here.
+			loopCfg := cfg
+			loopCfg.WALTruncateFrequency = time.Hour
+			inst.truncateLoop(ctx, wal, &loopCfg)
+
+			truncations, drops := wal.Truncations()
+			if tc.expectDrops {
+				// Dropping segments must not garbage collect series, which
+				// truncating would do.
+				require.Empty(t, truncations)
+				require.NotZero(t, drops)
+				return
+			}
+			require.Zero(t, drops)
+			if tc.expectMin == nil {
+				require.Empty(t, truncations)
+				return
+			}
+			require.NotEmpty(t, truncations)
+			require.Greater(t, truncations[0], tc.expectMin())
+		})
+	}
+}
+
+// sizedWalStorage is a mockWalStorage that reports a fixed size and records
+// truncations and dropped segments.
+type sizedWalStorage struct {
+	mockWalStorage
+
+	size        int64
+	truncations []int64
+	drops       int
+}
+
+func (s *sizedWalStorage) Size() (int64, error) { return s.size, nil }
+
+func (s *sizedWalStorage) Truncate(mint int64) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.truncations = append(s.truncations, mint)
+	return nil
+}
+
+func (s *sizedWalStorage) DropOldestSegments() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.drops++
+	return nil
+}
+
+func (s *sizedWalStorage) Truncations() (truncations []int64, drops int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]int64(nil), s.truncations...), s.drops
+}
+
 type mockWalStorage struct {
 	storage.Queryable
 	storage.ChunkQueryable
@@ -416,8 +724,16 @@ type mockWalStorage struct {
 func (s *mockWalStorage) Directory() string                          { return s.directory }
 func (s *mockWalStorage) StartTime() (int64, error)                  { return 0, nil }
 func (s *mockWalStorage) WriteStalenessMarkers(f func() int64) error { return nil }
+func (s *mockWalStorage) SetAppendOptions(opts wal.AppendOptions)    {}
 func (s *mockWalStorage) Close() error                               { return nil }
 func (s *mockWalStorage) Truncate(mint int64) error                  { return nil }
+func (s *mockWalStorage) DropOldestSegments() error                  { return nil }
+func (s *mockWalStorage) Size() (int64, error)                       { return 0, nil }
+func (s *mockWalStorage) SeriesStats() wal.SeriesStats {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return wal.SeriesStats{Series: len(s.series)}
+}
 
 func (s *mockWalStorage) Appender(context.Context) storage.Appender {
 	return &mockAppender{s: s}
@@ -9,9 +9,12 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/agent/pkg/util/usage"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage"
 )
 
 var (
@@ -20,6 +23,16 @@ var (
 		Help: "Total number of times a Prometheus instance exited unexpectedly, causing it to be restarted.",
 	}, []string{"instance_name"})
 
+	instanceStartFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_instance_start_failures_total",
+		Help: "Total number of times a Prometheus instance exited unexpectedly shortly after being started.",
+	}, []string{"instance_name"})
+
+	instanceSelfHealingRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_prometheus_instance_self_healing_restarts_total",
+		Help: "Total number of times a Prometheus instance was recreated after failing too many self_healing checks.",
+	}, []string{"instance_name", "reason"})
+
 	currentActiveInstances = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "agent_prometheus_active_instances",
 		Help: "Current number of active instances being used by the agent.",
@@ -27,10 +40,17 @@ var (
 
 	// DefaultBasicManagerConfig is the default config for the BasicManager.
 	DefaultBasicManagerConfig = BasicManagerConfig{
-		InstanceRestartBackoff: 5 * time.Second,
+		InstanceRestartBackoff:    5 * time.Second,
+		InstanceRestartMaxBackoff: 5 * time.Minute,
 	}
 )
 
+// defaultCrashLoopWindow is how long an instance must run before exiting for
+// the exit to not count as a start failure. Instances that keep failing within
+// the window are considered unhealthy and are restarted with an increasing
+// backoff.
+const defaultCrashLoopWindow = time.Minute
+
 // Manager represents a set of methods for manipulating running instances at
 // runtime.
 type Manager interface {
@@ -38,6 +58,10 @@ type Manager interface {
 	// within the Manager. The key will be the instance name from their config.
 	ListInstances() map[string]ManagedInstance
 
+	// GetInstance retrieves the ManagedInstance running a Config by its
+	// Config.Name. An error is returned if no such instance exists.
+	GetInstance(name string) (ManagedInstance, error)
+
 	// ListConfigs returns the config objects associated with a managed
 	// instance. The key will be the Name field from Config.
 	ListConfigs() map[string]Config
@@ -59,12 +83,83 @@ type ManagedInstance interface {
 	Run(ctx context.Context) error
 	Update(c Config) error
 	TargetsActive() map[string][]*scrape.Target
+	TargetsDropped() map[string][]*scrape.Target
 	StorageDirectory() string
+	Appender(ctx context.Context) storage.Appender
 }
 
 // BasicManagerConfig controls the operations of a BasicManager.
 type BasicManagerConfig struct {
+	// InstanceRestartBackoff is how long to wait before restarting an
+	// instance that exited unexpectedly. The backoff doubles every time the
+	// instance fails again shortly after being restarted.
 	InstanceRestartBackoff time.Duration
+
+	// InstanceRestartMaxBackoff caps the backoff of instances that keep
+	// failing. The backoff doesn't grow if it's less than
+	// InstanceRestartBackoff.
+	InstanceRestartMaxBackoff time.Duration
+}
+
+// restartBackoff returns how long to wait before restarting an instance that
+// failed failures times in a row.
+func (c BasicManagerConfig) restartBackoff(failures int) time.Duration {
+	backoff := c.InstanceRestartBackoff
+	for i := 1; i < failures && backoff < c.InstanceRestartMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.InstanceRestartMaxBackoff && c.InstanceRestartMaxBackoff > c.InstanceRestartBackoff {
+		backoff = c.InstanceRestartMaxBackoff
+	}
+	return backoff
+}
+
+// StatusManager is implemented by Managers that track whether their
+// instances are healthy.
+type StatusManager interface {
+	// InstanceStatus returns the status of the instance running a Config by
+	// its Config.Name. An error is returned if no such instance exists.
+	InstanceStatus(name string) (InstanceStatus, error)
+}
+
+// Preloader is implemented by Managers that can prepare the instance of a
+// Config before it's applied, so applying it later starts the instance
+// without creating its storage.
+type Preloader interface {
+	// PreloadConfig creates the instance of c and its storage without
+	// running it. The preloaded instance is run once c is applied. Configs
+	// that are already running aren't preloaded.
+	PreloadConfig(c Config) error
+
+	// UnloadConfig discards the preloaded instance of a Config by its
+	// Config.Name, releasing its storage.
+	UnloadConfig(name string) error
+}
+
+// preloadable is implemented by ManagedInstances that can create their
+// storage before being run.
+type preloadable interface {
+	Preload() error
+	Unload() error
+}
+
+// InstanceStatus describes whether a managed instance is running or failing
+// to start.
+type InstanceStatus struct {
+	// Healthy is false while the instance keeps exiting shortly after being
+	// started.
+	Healthy bool
+
+	// Number of times in a row the instance exited unexpectedly shortly
+	// after being started.
+	StartFailures int
+
+	// The error of the most recent unexpected exit and when it happened.
+	LastError   string
+	LastFailure time.Time
+
+	// When the instance will be restarted. Zero if it's running.
+	NextRestart time.Time
 }
 
 // BasicManager creates a new BasicManager, implementing the Manager interface.
@@ -80,8 +175,10 @@ type BasicManager struct {
 	// Stop on a process, you will deadlock.
 	mut       sync.Mutex
 	processes map[string]*managedProcess
+	preloaded map[string]*preloadedInstance
 
-	launch Factory
+	launch          Factory
+	crashLoopWindow time.Duration
 }
 
 // managedProcess represents a goroutine running a ManagedInstance. cancel
@@ -92,6 +189,106 @@ type managedProcess struct {
 	inst   ManagedInstance
 	cancel context.CancelFunc
 	done   chan bool
+	status *processStatus
+}
+
+// processStatus tracks the restarts of a managedProcess.
+type processStatus struct {
+	window time.Duration
+
+	mut         sync.Mutex
+	failures    int
+	lastStart   time.Time
+	lastErr     error
+	lastFailure time.Time
+	nextRestart time.Time
+
+	// Number of times in a row the instance was recreated for being
+	// unhealthy without passing a health check in between.
+	unhealthyRestarts int
+}
+
+func (s *processStatus) started(now time.Time) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.lastStart = now
+	s.nextRestart = time.Time{}
+}
+
+// failed records an unexpected exit. It returns the number of consecutive
+// failures and whether the exit counts as a start failure. Instances that
+// keep being recreated for failing health checks count as failing as well,
+// even though they ran for longer than the crash loop window.
+func (s *processStatus) failed(err error, now time.Time) (failures int, startFailure bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	// An instance that ran for long enough isn't crash looping anymore.
+	startFailure = now.Sub(s.lastStart) < s.window
+	if !startFailure {
+		s.failures = 0
+	}
+	s.failures++
+	s.lastErr = err
+	s.lastFailure = now
+
+	var unhealthy ErrUnhealthy
+	switch {
+	case !errors.As(err, &unhealthy):
+		s.unhealthyRestarts = 0
+	case unhealthy.Recovered:
+		s.unhealthyRestarts = 1
+	default:
+		s.unhealthyRestarts++
+	}
+
+	failures = s.failures
+	if s.unhealthyRestarts > failures {
+		failures = s.unhealthyRestarts
+	}
+	return failures, startFailure
+}
+
+func (s *processStatus) restartAt(t time.Time) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.nextRestart = t
+}
+
+func (s *processStatus) get(now time.Time) InstanceStatus {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	status := InstanceStatus{
+		StartFailures: s.failures,
+		LastFailure:   s.lastFailure,
+		NextRestart:   s.nextRestart,
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+
+	running := s.nextRestart.IsZero()
+	status.Healthy = s.failures == 0 || (running && now.Sub(s.lastStart) >= s.window)
+	if status.Healthy {
+		status.StartFailures = 0
+	}
+	return status
+}
+
+// preloadedInstance is an instance created by PreloadConfig that hasn't been
+// run yet.
+type preloadedInstance struct {
+	cfg  Config
+	inst ManagedInstance
+}
+
+// unload releases the storage of the instance if it was preloaded.
+func (p *preloadedInstance) unload() error {
+	if pi, ok := p.inst.(preloadable); ok {
+		return pi.Unload()
+	}
+	return nil
 }
 
 func (p managedProcess) Stop() {
@@ -111,10 +308,12 @@ type Factory func(c Config) (ManagedInstance, error)
 // deleted.
 func NewBasicManager(cfg BasicManagerConfig, logger log.Logger, launch Factory) *BasicManager {
 	return &BasicManager{
-		cfg:       cfg,
-		logger:    logger,
-		processes: make(map[string]*managedProcess),
-		launch:    launch,
+		cfg:             cfg,
+		logger:          logger,
+		processes:       make(map[string]*managedProcess),
+		preloaded:       make(map[string]*preloadedInstance),
+		launch:          launch,
+		crashLoopWindow: defaultCrashLoopWindow,
 	}
 }
 
@@ -137,6 +336,30 @@ func (m *BasicManager) ListInstances() map[string]ManagedInstance {
 	return res
 }
 
+// GetInstance returns the given instance by name.
+func (m *BasicManager) GetInstance(name string) (ManagedInstance, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	process, ok := m.processes[name]
+	if !ok {
+		return nil, fmt.Errorf("instance %s does not exist", name)
+	}
+	return process.inst, nil
+}
+
+// InstanceStatus returns the status of the given instance by name.
+func (m *BasicManager) InstanceStatus(name string) (InstanceStatus, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	process, ok := m.processes[name]
+	if !ok {
+		return InstanceStatus{}, fmt.Errorf("instance %s does not exist", name)
+	}
+	return process.status.get(time.Now()), nil
+}
+
 // ListConfigs lists the current active configs managed by BasicManager.
 func (m *BasicManager) ListConfigs() map[string]Config {
 	m.mut.Lock()
@@ -192,7 +415,7 @@ func (m *BasicManager) ApplyConfig(c Config) error {
 }
 
 func (m *BasicManager) spawnProcess(c Config) error {
-	inst, err := m.launch(c)
+	inst, err := m.takePreloaded(c)
 	if err != nil {
 		return err
 	}
@@ -205,11 +428,14 @@ func (m *BasicManager) spawnProcess(c Config) error {
 		done:   done,
 		cfg:    c,
 		inst:   inst,
+		status: &processStatus{window: m.crashLoopWindow},
 	}
 	m.processes[c.Name] = proc
 
 	go func() {
-		m.runProcess(ctx, c.Name, inst)
+		usage.Do(ctx, usage.ComponentInstance, c.Name, func(ctx context.Context) {
+			m.runProcess(ctx, c.Name, inst, proc.status)
+		})
 		close(done)
 
 		// Now that the process has stopped, we can remove it from our managed
@@ -235,28 +461,110 @@ func (m *BasicManager) spawnProcess(c Config) error {
 	return nil
 }
 
+// takePreloaded returns the instance preloaded for c, or launches a new one
+// if there's none or it was preloaded with a different config. m.mut must be
+// held when calling takePreloaded.
+func (m *BasicManager) takePreloaded(c Config) (ManagedInstance, error) {
+	p, ok := m.preloaded[c.Name]
+	if ok {
+		delete(m.preloaded, c.Name)
+		if util.CompareYAML(p.cfg, c) {
+			return p.inst, nil
+		}
+		if err := p.unload(); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to unload outdated preloaded instance", "instance", c.Name, "err", err)
+		}
+	}
+	return m.launch(c)
+}
+
+// PreloadConfig implements Preloader. Instances that support it create their
+// storage right away.
+func (m *BasicManager) PreloadConfig(c Config) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if _, running := m.processes[c.Name]; running {
+		return nil
+	}
+	if p, ok := m.preloaded[c.Name]; ok {
+		if util.CompareYAML(p.cfg, c) {
+			return nil
+		}
+		delete(m.preloaded, c.Name)
+		if err := p.unload(); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to unload outdated preloaded instance", "instance", c.Name, "err", err)
+		}
+	}
+
+	inst, err := m.launch(c)
+	if err != nil {
+		return err
+	}
+	if pi, ok := inst.(preloadable); ok {
+		if err := pi.Preload(); err != nil {
+			return fmt.Errorf("failed to preload instance %s: %w", c.Name, err)
+		}
+	}
+	m.preloaded[c.Name] = &preloadedInstance{cfg: c, inst: inst}
+	return nil
+}
+
+// UnloadConfig implements Preloader.
+func (m *BasicManager) UnloadConfig(name string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	p, ok := m.preloaded[name]
+	if !ok {
+		return errors.New("config is not preloaded")
+	}
+	delete(m.preloaded, name)
+	return p.unload()
+}
+
 // runProcess runs and instance and keeps it alive until it is explicitly stopped
-// by cancelling the context.
-func (m *BasicManager) runProcess(ctx context.Context, name string, inst ManagedInstance) {
+// by cancelling the context. Instances that keep failing shortly after being
+// started are restarted with an exponential backoff.
+func (m *BasicManager) runProcess(ctx context.Context, name string, inst ManagedInstance, status *processStatus) {
 	for {
-		err := inst.Run(ctx)
-		if err != nil && err != context.Canceled {
-			backoff := m.instanceRestartBackoff()
+		status.started(time.Now())
 
-			instanceAbnormalExits.WithLabelValues(name).Inc()
-			level.Error(m.logger).Log("msg", "instance stopped abnormally, restarting after backoff period", "err", err, "backoff", backoff, "instance", name)
-			time.Sleep(backoff)
-		} else {
+		err := inst.Run(ctx)
+		if err == nil || err == context.Canceled {
 			level.Info(m.logger).Log("msg", "stopped instance", "instance", name)
 			break
 		}
+
+		now := time.Now()
+		failures, startFailure := status.failed(err, now)
+		if startFailure {
+			instanceStartFailures.WithLabelValues(name).Inc()
+		}
+		instanceAbnormalExits.WithLabelValues(name).Inc()
+		var unhealthy ErrUnhealthy
+		if errors.As(err, &unhealthy) {
+			instanceSelfHealingRestarts.WithLabelValues(name, unhealthy.Reason).Inc()
+		}
+
+		backoff := m.managerConfig().restartBackoff(failures)
+		status.restartAt(now.Add(backoff))
+
+		level.Error(m.logger).Log("msg", "instance stopped abnormally, restarting after backoff period", "err", err, "backoff", backoff, "failures", failures, "instance", name)
+
+		select {
+		case <-ctx.Done():
+			level.Info(m.logger).Log("msg", "stopped instance", "instance", name)
+			return
+		case <-time.After(backoff):
+		}
 	}
 }
 
-func (m *BasicManager) instanceRestartBackoff() time.Duration {
+func (m *BasicManager) managerConfig() BasicManagerConfig {
 	m.cfgMut.Lock()
 	defer m.cfgMut.Unlock()
-	return m.cfg.InstanceRestartBackoff
+	return m.cfg
 }
 
 // DeleteConfig removes a managed instance by its config name. Returns an error
@@ -283,6 +591,13 @@ func (m *BasicManager) Stop() {
 	// We don't need to change m.processes here; processes remove themselves
 	// from the map (in spawnProcess).
 	m.mut.Lock()
+	for name, p := range m.preloaded {
+		if err := p.unload(); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to unload preloaded instance", "instance", name, "err", err)
+		}
+	}
+	m.preloaded = make(map[string]*preloadedInstance)
+
 	wg.Add(len(m.processes))
 	for _, proc := range m.processes {
 		go func(proc *managedProcess) {
@@ -299,6 +614,7 @@ func (m *BasicManager) Stop() {
 // Useful for tests.
 type MockManager struct {
 	ListInstancesFunc func() map[string]ManagedInstance
+	GetInstanceFunc   func(name string) (ManagedInstance, error)
 	ListConfigsFunc   func() map[string]Config
 	ApplyConfigFunc   func(Config) error
 	DeleteConfigFunc  func(name string) error
@@ -313,6 +629,14 @@ func (m MockManager) ListInstances() map[string]ManagedInstance {
 	panic("ListInstancesFunc not implemented")
 }
 
+// GetInstance implements Manager.
+func (m MockManager) GetInstance(name string) (ManagedInstance, error) {
+	if m.GetInstanceFunc != nil {
+		return m.GetInstanceFunc(name)
+	}
+	panic("GetInstanceFunc not implemented")
+}
+
 // ListConfigs implements Manager.
 func (m MockManager) ListConfigs() map[string]Config {
 	if m.ListConfigsFunc != nil {
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
 )
 
@@ -96,11 +99,173 @@ func TestBasicManager_ApplyConfig(t *testing.T) {
 	})
 }
 
+func TestBasicManager_PreloadConfig(t *testing.T) {
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	var spawned, preloads, unloads int
+	spawner := func(c Config) (ManagedInstance, error) {
+		spawned++
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			PreloadFunc: func() error {
+				preloads++
+				return nil
+			},
+			UnloadFunc: func() error {
+				unloads++
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, logger, spawner)
+	defer cm.Stop()
+
+	// Preloading an unchanged config keeps its preloaded instance.
+	require.NoError(t, cm.PreloadConfig(Config{Name: "a"}))
+	require.NoError(t, cm.PreloadConfig(Config{Name: "a"}))
+	require.Equal(t, 1, spawned)
+	require.Equal(t, 1, preloads)
+
+	// Applying the config runs the preloaded instance.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.Equal(t, 1, spawned)
+	require.Equal(t, 0, unloads)
+
+	// Running configs aren't preloaded.
+	require.NoError(t, cm.PreloadConfig(Config{Name: "a"}))
+	require.Equal(t, 1, spawned)
+
+	// A config that changed since it was preloaded gets a new instance.
+	require.NoError(t, cm.PreloadConfig(Config{Name: "b"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "b", HostFilter: true}))
+	require.Equal(t, 3, spawned)
+	require.Equal(t, 1, unloads)
+
+	require.NoError(t, cm.PreloadConfig(Config{Name: "c"}))
+	require.NoError(t, cm.UnloadConfig("c"))
+	require.Equal(t, 2, unloads)
+	require.Error(t, cm.UnloadConfig("c"))
+}
+
+func TestBasicManagerConfig_restartBackoff(t *testing.T) {
+	cfg := BasicManagerConfig{
+		InstanceRestartBackoff:    time.Second,
+		InstanceRestartMaxBackoff: 10 * time.Second,
+	}
+
+	var backoffs []time.Duration
+	for failures := 1; failures <= 6; failures++ {
+		backoffs = append(backoffs, cfg.restartBackoff(failures))
+	}
+	require.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	}, backoffs)
+
+	// The backoff shouldn't grow when the maximum is lower than the base.
+	cfg.InstanceRestartMaxBackoff = 0
+	require.Equal(t, time.Second, cfg.restartBackoff(5))
+
+	// A backoff of 0 restarts instances immediately.
+	cfg.InstanceRestartBackoff = 0
+	require.Equal(t, time.Duration(0), cfg.restartBackoff(5))
+}
+
+func TestBasicManager_CrashLoop(t *testing.T) {
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	var (
+		mut  sync.Mutex
+		runs int
+		fail = true
+	)
+	inst := &mockInstance{
+		RunFunc: func(ctx context.Context) error {
+			mut.Lock()
+			runs++
+			shouldFail := fail
+			mut.Unlock()
+
+			if shouldFail {
+				return fmt.Errorf("bad remote_write TLS config")
+			}
+			<-ctx.Done()
+			return nil
+		},
+	}
+
+	cm := NewBasicManager(BasicManagerConfig{
+		InstanceRestartBackoff:    10 * time.Millisecond,
+		InstanceRestartMaxBackoff: 40 * time.Millisecond,
+	}, logger, func(c Config) (ManagedInstance, error) {
+		return inst, nil
+	})
+	cm.crashLoopWindow = 100 * time.Millisecond
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+
+	require.Eventually(t, func() bool {
+		status, err := cm.InstanceStatus("test")
+		require.NoError(t, err)
+		return status.StartFailures >= 3
+	}, 5*time.Second, time.Millisecond)
+
+	status, err := cm.InstanceStatus("test")
+	require.NoError(t, err)
+	require.False(t, status.Healthy)
+	require.Equal(t, "bad remote_write TLS config", status.LastError)
+
+	// Once the instance starts successfully and stays up for long enough, it
+	// should be considered healthy again.
+	mut.Lock()
+	fail = false
+	mut.Unlock()
+
+	require.Eventually(t, func() bool {
+		status, err := cm.InstanceStatus("test")
+		require.NoError(t, err)
+		return status.Healthy
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = cm.InstanceStatus("missing")
+	require.Error(t, err)
+}
+
+func TestProcessStatus_Unhealthy(t *testing.T) {
+	s := &processStatus{window: time.Minute}
+	now := time.Now()
+
+	// Instances recreated for failing health checks ran for longer than the
+	// crash loop window, but should still back off further each time.
+	var failures []int
+	for i := 0; i < 3; i++ {
+		now = now.Add(10 * time.Minute)
+		s.started(now.Add(-5 * time.Minute))
+		n, startFailure := s.failed(ErrUnhealthy{Reason: unhealthyAppendErrors}, now)
+		require.False(t, startFailure)
+		failures = append(failures, n)
+	}
+	require.Equal(t, []int{1, 2, 3}, failures)
+
+	// Passing a check in between resets the backoff.
+	s.started(now)
+	n, _ := s.failed(ErrUnhealthy{Reason: unhealthyAppendErrors, Recovered: true}, now.Add(10*time.Minute))
+	require.Equal(t, 1, n)
+}
+
 type mockInstance struct {
 	RunFunc              func(ctx context.Context) error
 	UpdateFunc           func(c Config) error
 	TargetsActiveFunc    func() map[string][]*scrape.Target
+	TargetsDroppedFunc   func() map[string][]*scrape.Target
 	StorageDirectoryFunc func() string
+	AppenderFunc         func() storage.Appender
+	PreloadFunc          func() error
+	UnloadFunc           func() error
 }
 
 func (m mockInstance) Run(ctx context.Context) error {
@@ -124,9 +289,37 @@ func (m mockInstance) TargetsActive() map[string][]*scrape.Target {
 	panic("TargetsActiveFunc not provided")
 }
 
+func (m mockInstance) TargetsDropped() map[string][]*scrape.Target {
+	if m.TargetsDroppedFunc != nil {
+		return m.TargetsDroppedFunc()
+	}
+	panic("TargetsDroppedFunc not provided")
+}
+
 func (m mockInstance) StorageDirectory() string {
 	if m.StorageDirectoryFunc != nil {
 		return m.StorageDirectoryFunc()
 	}
 	panic("StorageDirectoryFunc not provided")
 }
+
+func (m mockInstance) Appender(_ context.Context) storage.Appender {
+	if m.AppenderFunc != nil {
+		return m.AppenderFunc()
+	}
+	panic("AppenderFunc not provided")
+}
+
+func (m mockInstance) Preload() error {
+	if m.PreloadFunc != nil {
+		return m.PreloadFunc()
+	}
+	panic("PreloadFunc not provided")
+}
+
+func (m mockInstance) Unload() error {
+	if m.UnloadFunc != nil {
+		return m.UnloadFunc()
+	}
+	panic("UnloadFunc not provided")
+}
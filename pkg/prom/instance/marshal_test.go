@@ -65,6 +65,7 @@ remote_write:
 wal_truncate_frequency: 1m0s
 min_wal_time: 5m0s
 max_wal_time: 4h0m0s
+wal_compression: true
 remote_flush_deadline: 1m0s
 `
 
@@ -136,6 +137,7 @@ remote_write:
 wal_truncate_frequency: 1m0s
 min_wal_time: 5m0s
 max_wal_time: 4h0m0s
+wal_compression: true
 remote_flush_deadline: 1m0s
 `
 
@@ -0,0 +1,251 @@
+package instance
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+type metadataMetrics struct {
+	sent   *prometheus.CounterVec
+	failed *prometheus.CounterVec
+}
+
+func newMetadataMetrics(reg prometheus.Registerer) *metadataMetrics {
+	labels := []string{"remote_name", "url"}
+	return &metadataMetrics{
+		sent: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_remote_write_metadata_sent_total",
+			Help: "Total number of metric metadata entries sent to a remote_write endpoint in batches of at most metadata_max_per_send.",
+		}, labels),
+		failed: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_remote_write_metadata_failed_total",
+			Help: "Total number of metric metadata entries that failed to be sent to a remote_write endpoint in batches of at most metadata_max_per_send.",
+		}, labels),
+	}
+}
+
+// metadataSender sends the metric metadata of scraped targets to
+// remote_write endpoints in batches of a limited size. Prometheus sends all
+// of the metadata in a single request, which endpoints may reject once
+// targets expose enough metric families.
+//
+// The metadata is sent to every remote_write with metadata_config.send set,
+// every metadata_config.send_interval. Failed batches aren't retried since
+// all of the metadata is sent again on the next interval.
+type metadataSender struct {
+	logger  log.Logger
+	metrics *metadataMetrics
+	collect func() []scrape.MetricMetadata
+	updated chan struct{}
+
+	mut        sync.Mutex
+	maxPerSend int
+	targets    []metadataTarget
+}
+
+type metadataTarget struct {
+	name     string
+	url      string
+	interval time.Duration
+	client   remote.WriteClient
+}
+
+// newMetadataSender creates a metadataSender that sends the metadata
+// returned by collect.
+func newMetadataSender(logger log.Logger, reg prometheus.Registerer, collect func() []scrape.MetricMetadata) *metadataSender {
+	return &metadataSender{
+		logger:  logger,
+		metrics: newMetadataMetrics(reg),
+		collect: collect,
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// SetConfig changes the batch size and the remote_write endpoints to send
+// metadata to. Nothing is sent if maxPerSend is 0.
+func (s *metadataSender) SetConfig(maxPerSend int, remoteWrites []*config.RemoteWriteConfig) error {
+	var targets []metadataTarget
+	if maxPerSend > 0 {
+		for _, rw := range remoteWrites {
+			if rw.URL == nil || !rw.MetadataConfig.Send || rw.MetadataConfig.SendInterval <= 0 {
+				continue
+			}
+			client, err := remote.NewWriteClient(rw.Name+"/metadata", &remote.ClientConfig{
+				URL:              rw.URL,
+				Timeout:          rw.RemoteTimeout,
+				HTTPClientConfig: rw.HTTPClientConfig,
+				SigV4Config:      rw.SigV4Config,
+				Headers:          rw.Headers,
+				RetryOnRateLimit: rw.QueueConfig.RetryOnRateLimit,
+			})
+			if err != nil {
+				return err
+			}
+			targets = append(targets, metadataTarget{
+				name:     rw.Name,
+				url:      rw.URL.String(),
+				interval: time.Duration(rw.MetadataConfig.SendInterval),
+				client:   client,
+			})
+		}
+	}
+
+	s.mut.Lock()
+	s.maxPerSend = maxPerSend
+	s.targets = targets
+	s.mut.Unlock()
+
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends metadata to each remote_write endpoint on its send interval
+// until ctx is canceled.
+func (s *metadataSender) Run(ctx context.Context) {
+	for {
+		s.mut.Lock()
+		targets := s.targets
+		s.mut.Unlock()
+
+		var (
+			wg                sync.WaitGroup
+			runCtx, cancelRun = context.WithCancel(ctx)
+		)
+		for _, t := range targets {
+			wg.Add(1)
+			go func(t metadataTarget) {
+				defer wg.Done()
+				s.runTarget(runCtx, t)
+			}(t)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-s.updated:
+		}
+		cancelRun()
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *metadataSender) runTarget(ctx context.Context, t metadataTarget) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.send(ctx, t)
+		}
+	}
+}
+
+// send sends all of the collected metadata to t in batches of at most
+// maxPerSend entries. Sending stops at the first batch that fails.
+func (s *metadataSender) send(ctx context.Context, t metadataTarget) {
+	s.mut.Lock()
+	maxPerSend := s.maxPerSend
+	s.mut.Unlock()
+
+	metadata := metadataToProto(s.collect())
+	for len(metadata) > 0 {
+		n := maxPerSend
+		if n > len(metadata) {
+			n = len(metadata)
+		}
+
+		if err := sendMetadata(ctx, t.client, metadata[:n]); err != nil {
+			s.metrics.failed.WithLabelValues(t.name, t.url).Add(float64(len(metadata)))
+			level.Error(s.logger).Log("msg", "failed to send metadata", "remote_name", t.name, "count", len(metadata), "err", err)
+			return
+		}
+		s.metrics.sent.WithLabelValues(t.name, t.url).Add(float64(n))
+		metadata = metadata[n:]
+	}
+}
+
+func sendMetadata(ctx context.Context, client remote.WriteClient, metadata []prompb.MetricMetadata) error {
+	req := prompb.WriteRequest{Metadata: metadata}
+	data, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	return client.Store(ctx, snappy.Encode(nil, data))
+}
+
+// metadataToProto converts metadata to the format sent over remote_write.
+func metadataToProto(metadata []scrape.MetricMetadata) []prompb.MetricMetadata {
+	res := make([]prompb.MetricMetadata, 0, len(metadata))
+	for _, md := range metadata {
+		res = append(res, prompb.MetricMetadata{
+			MetricFamilyName: md.Metric,
+			Help:             md.Help,
+			Type:             prompb.MetricMetadata_MetricType(prompb.MetricMetadata_MetricType_value[strings.ToUpper(string(md.Type))]),
+			Unit:             md.Unit,
+		})
+	}
+	return res
+}
+
+// collectTargetMetadata returns the unique metric metadata of the active
+// targets of the scrape manager, like Prometheus' metadata watcher does.
+func collectTargetMetadata(sm *readyScrapeManager) []scrape.MetricMetadata {
+	mgr, err := sm.Get()
+	if err != nil {
+		return nil
+	}
+
+	var (
+		seen     = map[scrape.MetricMetadata]struct{}{}
+		metadata []scrape.MetricMetadata
+	)
+	for _, targets := range mgr.TargetsActive() {
+		for _, target := range targets {
+			for _, entry := range target.MetadataList() {
+				if _, ok := seen[entry]; ok {
+					continue
+				}
+				seen[entry] = struct{}{}
+				metadata = append(metadata, entry)
+			}
+		}
+	}
+	return metadata
+}
+
+// withoutMetadata returns copies of remoteWrites that don't send metadata
+// if metadataMaxPerSend is set, since the metadataSender sends it instead.
+func withoutMetadata(remoteWrites []*config.RemoteWriteConfig, metadataMaxPerSend int) []*config.RemoteWriteConfig {
+	if metadataMaxPerSend <= 0 {
+		return remoteWrites
+	}
+
+	res := make([]*config.RemoteWriteConfig, 0, len(remoteWrites))
+	for _, rw := range remoteWrites {
+		rwCopy := *rw
+		rwCopy.MetadataConfig.Send = false
+		res = append(res, &rwCopy)
+	}
+	return res
+}
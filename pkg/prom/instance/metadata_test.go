@@ -0,0 +1,122 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/textparse"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataSender(t *testing.T) {
+	var (
+		mut     sync.Mutex
+		batches [][]prompb.MetricMetadata
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+
+		var req prompb.WriteRequest
+		require.NoError(t, req.Unmarshal(data))
+
+		mut.Lock()
+		batches = append(batches, req.Metadata)
+		mut.Unlock()
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	rw := config.DefaultRemoteWriteConfig
+	rw.Name = "write"
+	rw.URL = &config_util.URL{URL: u}
+
+	var metadata []scrape.MetricMetadata
+	for i := 0; i < 5; i++ {
+		metadata = append(metadata, scrape.MetricMetadata{
+			Metric: fmt.Sprintf("metric_%d", i),
+			Type:   textparse.MetricTypeCounter,
+			Help:   "help",
+		})
+	}
+
+	s := newMetadataSender(log.NewNopLogger(), prometheus.NewRegistry(), func() []scrape.MetricMetadata {
+		return metadata
+	})
+
+	// Nothing is sent when metadata_max_per_send isn't set.
+	require.NoError(t, s.SetConfig(0, []*config.RemoteWriteConfig{&rw}))
+	require.Empty(t, s.targets)
+
+	require.NoError(t, s.SetConfig(2, []*config.RemoteWriteConfig{&rw}))
+	require.Len(t, s.targets, 1)
+	s.send(context.Background(), s.targets[0])
+
+	mut.Lock()
+	defer mut.Unlock()
+	require.Len(t, batches, 3)
+	require.Len(t, batches[0], 2)
+	require.Len(t, batches[1], 2)
+	require.Len(t, batches[2], 1)
+	require.Equal(t, prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_COUNTER,
+		MetricFamilyName: "metric_4",
+		Help:             "help",
+	}, batches[2][0])
+	require.Equal(t, 5.0, counterValue(t, s.metrics.sent.WithLabelValues("write", srv.URL)))
+}
+
+func TestMetadataSender_Failed(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "too many entries", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	rw := config.DefaultRemoteWriteConfig
+	rw.Name = "write"
+	rw.URL = &config_util.URL{URL: u}
+
+	s := newMetadataSender(log.NewNopLogger(), prometheus.NewRegistry(), func() []scrape.MetricMetadata {
+		return []scrape.MetricMetadata{{Metric: "a"}, {Metric: "b"}, {Metric: "c"}}
+	})
+	require.NoError(t, s.SetConfig(1, []*config.RemoteWriteConfig{&rw}))
+	s.send(context.Background(), s.targets[0])
+
+	// Sending stops at the first failed batch.
+	require.Equal(t, 1, requests)
+	require.Equal(t, 3.0, counterValue(t, s.metrics.failed.WithLabelValues("write", srv.URL)))
+	require.Equal(t, 0.0, counterValue(t, s.metrics.sent.WithLabelValues("write", srv.URL)))
+}
+
+func TestWithoutMetadata(t *testing.T) {
+	rw := config.DefaultRemoteWriteConfig
+	require.True(t, rw.MetadataConfig.Send)
+
+	remoteWrites := []*config.RemoteWriteConfig{&rw}
+	require.Equal(t, remoteWrites, withoutMetadata(remoteWrites, 0))
+
+	res := withoutMetadata(remoteWrites, 10)
+	require.Len(t, res, 1)
+	require.False(t, res[0].MetadataConfig.Send)
+	require.True(t, rw.MetadataConfig.Send, "original config must not be changed")
+}
@@ -146,6 +146,52 @@ func (m *ModalManager) ListInstances() map[string]ManagedInstance {
 	return m.active.ListInstances()
 }
 
+// GetInstance implements Manager.
+func (m *ModalManager) GetInstance(name string) (ManagedInstance, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.active.GetInstance(name)
+}
+
+// InstanceStatus implements StatusManager. An error is returned if the
+// active Manager doesn't implement StatusManager.
+func (m *ModalManager) InstanceStatus(name string) (InstanceStatus, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	sm, ok := m.active.(StatusManager)
+	if !ok {
+		return InstanceStatus{}, fmt.Errorf("instance status is not supported")
+	}
+	return sm.InstanceStatus(name)
+}
+
+// PreloadConfig implements Preloader. An error is returned if the active
+// Manager doesn't implement Preloader.
+func (m *ModalManager) PreloadConfig(c Config) error {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	p, ok := m.active.(Preloader)
+	if !ok {
+		return fmt.Errorf("preloading configs is not supported")
+	}
+	return p.PreloadConfig(c)
+}
+
+// UnloadConfig implements Preloader. An error is returned if the active
+// Manager doesn't implement Preloader.
+func (m *ModalManager) UnloadConfig(name string) error {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	p, ok := m.active.(Preloader)
+	if !ok {
+		return fmt.Errorf("preloading configs is not supported")
+	}
+	return p.UnloadConfig(name)
+}
+
 // ListConfigs implements Manager.
 func (m *ModalManager) ListConfigs() map[string]Config {
 	m.mut.RLock()
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage"
 )
 
 // NoOpInstance implements the Instance interface in pkg/prom
@@ -26,7 +27,18 @@ func (NoOpInstance) TargetsActive() map[string][]*scrape.Target {
 	return nil
 }
 
+// TargetsDropped implements Instance.
+func (NoOpInstance) TargetsDropped() map[string][]*scrape.Target {
+	return nil
+}
+
 // StorageDirectory implements Instance.
 func (NoOpInstance) StorageDirectory() string {
 	return ""
 }
+
+// Appender implements Instance. The returned appender rejects all samples
+// with ErrNotRunning.
+func (NoOpInstance) Appender(_ context.Context) storage.Appender {
+	return notRunningAppender{}
+}
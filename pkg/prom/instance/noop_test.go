@@ -0,0 +1,19 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoOpInstance_Appender(t *testing.T) {
+	app := NoOpInstance{}.Appender(context.Background())
+	require.NotNil(t, app)
+
+	_, err := app.Append(0, labels.FromStrings("__name__", "test"), 0, 1)
+	require.ErrorIs(t, err, ErrNotRunning)
+	require.ErrorIs(t, app.Commit(), ErrNotRunning)
+	require.NoError(t, app.Rollback())
+}
@@ -0,0 +1,90 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// RemoteReadProxy forwards Prometheus remote_read requests to the
+// remote_read_proxy of an instance. Queries are restricted to the series
+// written by the instance by requiring its external labels, so clients can
+// read the instance's data without knowing how it's stored in the backend.
+type RemoteReadProxy struct {
+	client         remote.ReadClient
+	externalLabels labels.Labels
+}
+
+// NewRemoteReadProxy creates a RemoteReadProxy for the instance with config
+// cfg. cfg must have had ApplyDefaults called on it so the instance's tenant
+// is set in the remote_read_proxy headers.
+func NewRemoteReadProxy(cfg Config, global GlobalConfig) (*RemoteReadProxy, error) {
+	rc := cfg.RemoteReadProxy
+	if rc == nil {
+		return nil, errors.New("remote_read_proxy is not configured")
+	}
+
+	client, err := remote.NewReadClient(fmt.Sprintf("%s/remote_read_proxy", cfg.Name), &remote.ClientConfig{
+		URL:              rc.URL,
+		Timeout:          rc.RemoteTimeout,
+		HTTPClientConfig: rc.HTTPClientConfig,
+		Headers:          rc.Headers,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RemoteReadProxy{
+		client:         client,
+		externalLabels: mergeExternalLabels(global.Prometheus.ExternalLabels, cfg.ExternalLabels),
+	}, nil
+}
+
+// ServeHTTP implements http.Handler. Only the SAMPLES response type is
+// supported; requests accepting streamed chunks get samples instead.
+func (p *RemoteReadProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := remote.DecodeReadRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := prompb.ReadResponse{
+		Results: make([]*prompb.QueryResult, len(req.Queries)),
+	}
+	for i, q := range req.Queries {
+		q.Matchers = withExternalLabelMatchers(q.Matchers, p.externalLabels)
+
+		res, err := p.client.Read(r.Context(), q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		resp.Results[i] = res
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	if err := remote.EncodeReadResponse(&resp, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// withExternalLabelMatchers returns matchers with an equality matcher added
+// for each external label.
+func withExternalLabelMatchers(matchers []*prompb.LabelMatcher, externalLabels labels.Labels) []*prompb.LabelMatcher {
+	res := make([]*prompb.LabelMatcher, 0, len(matchers)+len(externalLabels))
+	res = append(res, matchers...)
+	for _, l := range externalLabels {
+		res = append(res, &prompb.LabelMatcher{
+			Type:  prompb.LabelMatcher_EQ,
+			Name:  l.Name,
+			Value: l.Value,
+		})
+	}
+	return res
+}
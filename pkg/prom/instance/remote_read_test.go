@@ -0,0 +1,118 @@
+package instance
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/golang/snappy"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteReadProxy(t *testing.T) {
+	var (
+		gotMatchers []*prompb.LabelMatcher
+		gotTenant   string
+	)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := remote.DecodeReadRequest(r)
+		require.NoError(t, err)
+		require.Len(t, req.Queries, 1)
+		gotMatchers = req.Queries[0].Matchers
+		gotTenant = r.Header.Get(DefaultTenantHeader)
+
+		resp := &prompb.ReadResponse{Results: []*prompb.QueryResult{{
+			Timeseries: []*prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "a"}},
+				Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}},
+			}},
+		}}}
+		require.NoError(t, remote.EncodeReadResponse(resp, w))
+	}))
+	defer backend.Close()
+
+	u, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	rc := config.DefaultRemoteReadConfig
+	rc.URL = &config_util.URL{URL: u}
+
+	global := DefaultGlobalConfig
+	global.Prometheus.ExternalLabels = labels.FromStrings("cluster", "a")
+
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.TenantID = "tenant"
+	cfg.ExternalLabels = labels.FromStrings("agent", "agent-1")
+	cfg.RemoteReadProxy = &rc
+	require.NoError(t, cfg.ApplyDefaults(&global))
+
+	proxy, err := NewRemoteReadProxy(cfg, global)
+	require.NoError(t, err)
+
+	req := &prompb.ReadRequest{Queries: []*prompb.Query{{
+		StartTimestampMs: 0,
+		EndTimestampMs:   2000,
+		Matchers:         []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+	}}}
+	data, err := req.Marshal()
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("POST", "/", bytes.NewReader(snappy.Encode(nil, data))))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.Equal(t, "tenant", gotTenant)
+	require.Equal(t, []*prompb.LabelMatcher{
+		{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: prompb.LabelMatcher_EQ, Name: "agent", Value: "agent-1"},
+		{Type: prompb.LabelMatcher_EQ, Name: "cluster", Value: "a"},
+	}, gotMatchers)
+
+	compressed, err := ioutil.ReadAll(rec.Body)
+	require.NoError(t, err)
+	uncompressed, err := snappy.Decode(nil, compressed)
+	require.NoError(t, err)
+
+	var resp prompb.ReadResponse
+	require.NoError(t, resp.Unmarshal(uncompressed))
+	require.Len(t, resp.Results, 1)
+	require.Len(t, resp.Results[0].Timeseries, 1)
+	require.Equal(t, []prompb.Sample{{Timestamp: 1000, Value: 1}}, resp.Results[0].Timeseries[0].Samples)
+}
+
+func TestRemoteReadProxy_BackendError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	u, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	rc := config.DefaultRemoteReadConfig
+	rc.URL = &config_util.URL{URL: u}
+
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.RemoteReadProxy = &rc
+
+	proxy, err := NewRemoteReadProxy(cfg, DefaultGlobalConfig)
+	require.NoError(t, err)
+
+	req := &prompb.ReadRequest{Queries: []*prompb.Query{{EndTimestampMs: 1000}}}
+	data, err := req.Marshal()
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("POST", "/", bytes.NewReader(snappy.Encode(nil, data))))
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+}
@@ -0,0 +1,174 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/config"
+)
+
+// validateQueueConfig validates the queue_config of a remote_write.
+// Prometheus accepts any queue_config, but some values stop the queue from
+// sending samples at all.
+func validateQueueConfig(qc config.QueueConfig) error {
+	switch {
+	case qc.Capacity <= 0:
+		return errors.New("capacity must be greater than 0")
+	case qc.MinShards <= 0:
+		return errors.New("min_shards must be greater than 0")
+	case qc.MaxShards < qc.MinShards:
+		return errors.New("max_shards must not be less than min_shards")
+	case qc.MaxSamplesPerSend <= 0:
+		return errors.New("max_samples_per_send must be greater than 0")
+	case qc.BatchSendDeadline <= 0:
+		return errors.New("batch_send_deadline must be greater than 0s")
+	case qc.MinBackoff <= 0:
+		return errors.New("min_backoff must be greater than 0s")
+	case qc.MaxBackoff < qc.MinBackoff:
+		return errors.New("max_backoff must not be less than min_backoff")
+	}
+	return nil
+}
+
+// DefaultTenantHeader is the header used to send tenant_id to remote_write
+// endpoints when tenant_header isn't set.
+const DefaultTenantHeader = "X-Scope-OrgID"
+
+// reservedHeaders can't be used as tenant_header since they're set by
+// remote_write itself.
+var reservedHeaders = []string{
+	"Authorization",
+	"Content-Encoding",
+	"Content-Type",
+	"Host",
+	"User-Agent",
+	"X-Prometheus-Remote-Write-Version",
+}
+
+// withTenantHeader returns copies of remoteWrites that set header to tenant.
+// The configs are copied since they may be shared with other instances.
+// Returns an error if a remote_write already sets header to another value.
+func withTenantHeader(remoteWrites []*config.RemoteWriteConfig, header, tenant string) ([]*config.RemoteWriteConfig, error) {
+	for _, reserved := range reservedHeaders {
+		if strings.EqualFold(header, reserved) {
+			return nil, fmt.Errorf("tenant_header %q is reserved by remote_write", header)
+		}
+	}
+
+	res := make([]*config.RemoteWriteConfig, 0, len(remoteWrites))
+	for _, rw := range remoteWrites {
+		if rw == nil {
+			res = append(res, nil)
+			continue
+		}
+
+		headers, err := tenantHeaders(rw.Headers, header, tenant)
+		if err != nil {
+			return nil, fmt.Errorf("remote_write %w", err)
+		}
+
+		rwCopy := *rw
+		rwCopy.Headers = headers
+		res = append(res, &rwCopy)
+	}
+	return res, nil
+}
+
+// tenantHeaders returns a copy of headers that sets header to tenant.
+// Returns an error if headers already sets header to another value.
+func tenantHeaders(headers map[string]string, header, tenant string) (map[string]string, error) {
+	res := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		if strings.EqualFold(name, header) {
+			if value != tenant {
+				return nil, fmt.Errorf("header %q is set to %q, which conflicts with tenant_id %q", name, value, tenant)
+			}
+			continue
+		}
+		res[name] = value
+	}
+	res[header] = tenant
+	return res, nil
+}
+
+// remoteWriteQueueCollector exposes the queue_config settings of each
+// remote_write that Prometheus doesn't already expose through its
+// prometheus_remote_storage metrics.
+type remoteWriteQueueCollector struct {
+	mut     sync.Mutex
+	configs []*config.RemoteWriteConfig
+
+	batchSendDeadline *prometheus.Desc
+	minBackoff        *prometheus.Desc
+	maxBackoff        *prometheus.Desc
+	retryOnRateLimit  *prometheus.Desc
+}
+
+func newRemoteWriteQueueCollector() *remoteWriteQueueCollector {
+	labels := []string{"remote_name", "url"}
+	return &remoteWriteQueueCollector{
+		batchSendDeadline: prometheus.NewDesc(
+			"agent_prometheus_remote_write_batch_send_deadline_seconds",
+			"Configured maximum time a sample waits to be sent to a remote_write endpoint.",
+			labels, nil,
+		),
+		minBackoff: prometheus.NewDesc(
+			"agent_prometheus_remote_write_min_backoff_seconds",
+			"Configured initial delay before retrying a failed send to a remote_write endpoint.",
+			labels, nil,
+		),
+		maxBackoff: prometheus.NewDesc(
+			"agent_prometheus_remote_write_max_backoff_seconds",
+			"Configured maximum delay before retrying a failed send to a remote_write endpoint.",
+			labels, nil,
+		),
+		retryOnRateLimit: prometheus.NewDesc(
+			"agent_prometheus_remote_write_retry_on_rate_limit",
+			"Whether sends rejected by a remote_write endpoint with HTTP 429 are retried (1) or dropped (0).",
+			labels, nil,
+		),
+	}
+}
+
+// SetConfigs changes the remote_write configs exposed by the collector.
+func (c *remoteWriteQueueCollector) SetConfigs(configs []*config.RemoteWriteConfig) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.configs = configs
+}
+
+// Describe implements prometheus.Collector.
+func (c *remoteWriteQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.batchSendDeadline
+	ch <- c.minBackoff
+	ch <- c.maxBackoff
+	ch <- c.retryOnRateLimit
+}
+
+// Collect implements prometheus.Collector.
+func (c *remoteWriteQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for _, rw := range c.configs {
+		var url string
+		if rw.URL != nil {
+			url = rw.URL.String()
+		}
+		qc := rw.QueueConfig
+
+		ch <- prometheus.MustNewConstMetric(c.batchSendDeadline, prometheus.GaugeValue, time.Duration(qc.BatchSendDeadline).Seconds(), rw.Name, url)
+		ch <- prometheus.MustNewConstMetric(c.minBackoff, prometheus.GaugeValue, time.Duration(qc.MinBackoff).Seconds(), rw.Name, url)
+		ch <- prometheus.MustNewConstMetric(c.maxBackoff, prometheus.GaugeValue, time.Duration(qc.MaxBackoff).Seconds(), rw.Name, url)
+
+		var retry float64
+		if qc.RetryOnRateLimit {
+			retry = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.retryOnRateLimit, prometheus.GaugeValue, retry, rw.Name, url)
+	}
+}
@@ -0,0 +1,75 @@
+package instance
+
+import "sync"
+
+// RemoteWriteGate decides whether instances send samples to their
+// remote_write endpoints. Instances keep scraping and writing to the WAL while
+// the gate is closed, and start sending samples newer than the time the gate
+// opened once it opens again.
+//
+// A RemoteWriteGate may be shared between instances, such as to only send
+// samples from the leader of an HA pair of agents. A nil RemoteWriteGate is
+// always open.
+type RemoteWriteGate struct {
+	mut         sync.Mutex
+	open        bool
+	subscribers map[chan struct{}]struct{}
+}
+
+// NewRemoteWriteGate creates a new RemoteWriteGate.
+func NewRemoteWriteGate(open bool) *RemoteWriteGate {
+	return &RemoteWriteGate{
+		open:        open,
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Open returns true if samples may be sent.
+func (g *RemoteWriteGate) Open() bool {
+	if g == nil {
+		return true
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.open
+}
+
+// SetOpen opens or closes the gate and notifies subscribers if it changed.
+func (g *RemoteWriteGate) SetOpen(open bool) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if g.open == open {
+		return
+	}
+	g.open = open
+	for ch := range g.subscribers {
+		// Subscribers read the current state when notified, so pending
+		// notifications don't need to be queued.
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel which is written to when the gate opens or
+// closes. The returned function must be called to unsubscribe. Subscribing
+// to a nil RemoteWriteGate returns a channel that's never written to.
+func (g *RemoteWriteGate) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	if g == nil {
+		return ch, func() {}
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		g.mut.Lock()
+		defer g.mut.Unlock()
+		delete(g.subscribers, ch)
+	}
+}
@@ -0,0 +1,39 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWriteGate(t *testing.T) {
+	g := NewRemoteWriteGate(true)
+	require.True(t, g.Open())
+
+	ch, unsubscribe := g.Subscribe()
+
+	// Setting the gate to its current state doesn't notify subscribers.
+	g.SetOpen(true)
+	require.Len(t, ch, 0)
+
+	// Notifications are coalesced.
+	g.SetOpen(false)
+	g.SetOpen(true)
+	g.SetOpen(false)
+	require.Len(t, ch, 1)
+	<-ch
+	require.False(t, g.Open())
+
+	unsubscribe()
+	g.SetOpen(true)
+	require.Len(t, ch, 0)
+}
+
+func TestRemoteWriteGate_Nil(t *testing.T) {
+	var g *RemoteWriteGate
+	require.True(t, g.Open())
+
+	ch, unsubscribe := g.Subscribe()
+	defer unsubscribe()
+	require.Len(t, ch, 0)
+}
@@ -0,0 +1,99 @@
+package instance
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWriteQueueCollector(t *testing.T) {
+	u, err := url.Parse("http://localhost:9009/api/prom/push")
+	require.NoError(t, err)
+
+	rw := config.DefaultRemoteWriteConfig
+	rw.Name = "write"
+	rw.URL = &config_util.URL{URL: u}
+	rw.QueueConfig.MaxBackoff = model.Duration(5 * time.Second)
+	rw.QueueConfig.RetryOnRateLimit = true
+
+	c := newRemoteWriteQueueCollector()
+	c.SetConfigs([]*config.RemoteWriteConfig{&rw})
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(c))
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		require.Len(t, mf.GetMetric(), 1)
+		m := mf.GetMetric()[0]
+		require.Equal(t, "write", m.GetLabel()[0].GetValue())
+		require.Equal(t, u.String(), m.GetLabel()[1].GetValue())
+		values[mf.GetName()] = m.GetGauge().GetValue()
+	}
+	require.Equal(t, map[string]float64{
+		"agent_prometheus_remote_write_batch_send_deadline_seconds": 5,
+		"agent_prometheus_remote_write_min_backoff_seconds":         0.03,
+		"agent_prometheus_remote_write_max_backoff_seconds":         5,
+		"agent_prometheus_remote_write_retry_on_rate_limit":         1,
+	}, values)
+
+	// Removed remote_writes are no longer exposed.
+	c.SetConfigs(nil)
+	mfs, err = reg.Gather()
+	require.NoError(t, err)
+	require.Empty(t, mfs)
+}
+
+func TestConfig_ApplyDefaults_TenantID(t *testing.T) {
+	u, err := url.Parse("http://localhost:9009/api/prom/push")
+	require.NoError(t, err)
+
+	rw := config.DefaultRemoteWriteConfig
+	rw.URL = &config_util.URL{URL: u}
+	rw.Headers = map[string]string{"X-Custom": "value"}
+
+	global := DefaultGlobalConfig
+	global.RemoteWrite = []*config.RemoteWriteConfig{&rw}
+
+	newConfig := func(name, tenant string) Config {
+		cfg := DefaultConfig
+		cfg.Name = name
+		cfg.TenantID = tenant
+		return cfg
+	}
+
+	a, b := newConfig("a", "tenant-a"), newConfig("b", "tenant-b")
+	require.NoError(t, a.ApplyDefaults(&global))
+	require.NoError(t, b.ApplyDefaults(&global))
+
+	require.Equal(t, map[string]string{"X-Custom": "value", "X-Scope-OrgID": "tenant-a"}, a.RemoteWrite[0].Headers)
+	require.Equal(t, map[string]string{"X-Custom": "value", "X-Scope-OrgID": "tenant-b"}, b.RemoteWrite[0].Headers)
+	require.NotEqual(t, a.RemoteWrite[0].Name, b.RemoteWrite[0].Name)
+
+	// The global remote_write isn't modified.
+	require.Equal(t, map[string]string{"X-Custom": "value"}, rw.Headers)
+
+	// Applying defaults again doesn't conflict with the injected header.
+	require.NoError(t, a.ApplyDefaults(&global))
+
+	custom := newConfig("custom", "tenant")
+	custom.TenantHeader = "X-Custom"
+	require.EqualError(t, custom.ApplyDefaults(&global), `remote_write header "X-Custom" is set to "value", which conflicts with tenant_id "tenant"`)
+
+	reserved := newConfig("reserved", "tenant")
+	reserved.TenantHeader = "content-type"
+	require.EqualError(t, reserved.ApplyDefaults(&global), `tenant_header "content-type" is reserved by remote_write`)
+
+	noTenant := newConfig("no-tenant", "")
+	noTenant.TenantHeader = "X-Tenant"
+	require.EqualError(t, noTenant.ApplyDefaults(&global), "tenant_header requires tenant_id to be set")
+}
@@ -0,0 +1,95 @@
+package instance
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/prom/rules"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rulesHeadSeriesBytes estimates the memory used by a series of a rules
+// head: its labels, the tsdb memSeries and an open chunk.
+const rulesHeadSeriesBytes = 1024
+
+// resourceCollector exposes the resources used by an instance, so the
+// instances responsible for the memory and disk usage of the Agent can be
+// found.
+type resourceCollector struct {
+	logger log.Logger
+
+	wal           walStorage
+	rulesHead     *rules.Head // nil if rules aren't configured
+	scrapeManager *readyScrapeManager
+
+	activeSeries  *prometheus.Desc
+	walDiskBytes  *prometheus.Desc
+	headMemory    *prometheus.Desc
+	scrapeTargets *prometheus.Desc
+}
+
+func newResourceCollector(logger log.Logger, wal walStorage, rulesHead *rules.Head, scrapeManager *readyScrapeManager) *resourceCollector {
+	return &resourceCollector{
+		logger: logger,
+
+		wal:           wal,
+		rulesHead:     rulesHead,
+		scrapeManager: scrapeManager,
+
+		activeSeries: prometheus.NewDesc(
+			"agent_instance_active_series",
+			"Number of series held in memory by the WAL of the instance.",
+			nil, nil,
+		),
+		walDiskBytes: prometheus.NewDesc(
+			"agent_instance_wal_disk_bytes",
+			"Size of the WAL of the instance on disk, including checkpoints.",
+			nil, nil,
+		),
+		headMemory: prometheus.NewDesc(
+			"agent_instance_head_memory_bytes",
+			"Estimated memory used by the series held in memory by the WAL and rules head of the instance.",
+			nil, nil,
+		),
+		scrapeTargets: prometheus.NewDesc(
+			"agent_instance_scrape_targets",
+			"Number of targets of the instance by scrape job and state (active or dropped).",
+			[]string{"scrape_job", "state"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *resourceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeSeries
+	ch <- c.walDiskBytes
+	ch <- c.headMemory
+	ch <- c.scrapeTargets
+}
+
+// Collect implements prometheus.Collector.
+func (c *resourceCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.wal.SeriesStats()
+	memory := stats.MemoryBytes
+	if c.rulesHead != nil {
+		memory += int64(c.rulesHead.NumSeries()) * rulesHeadSeriesBytes
+	}
+	ch <- prometheus.MustNewConstMetric(c.activeSeries, prometheus.GaugeValue, float64(stats.Series))
+	ch <- prometheus.MustNewConstMetric(c.headMemory, prometheus.GaugeValue, float64(memory))
+
+	if size, err := c.wal.Size(); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to get WAL size", "err", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.walDiskBytes, prometheus.GaugeValue, float64(size))
+	}
+
+	sm, err := c.scrapeManager.Get()
+	if err != nil {
+		return
+	}
+	for job, targets := range sm.TargetsActive() {
+		ch <- prometheus.MustNewConstMetric(c.scrapeTargets, prometheus.GaugeValue, float64(len(targets)), job, "active")
+	}
+	for job, targets := range sm.TargetsDropped() {
+		ch <- prometheus.MustNewConstMetric(c.scrapeTargets, prometheus.GaugeValue, float64(len(targets)), job, "dropped")
+	}
+}
@@ -0,0 +1,34 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceCollector(t *testing.T) {
+	wal := &sizedWalStorage{
+		mockWalStorage: mockWalStorage{series: map[uint64]int{1: 1, 2: 1}},
+		size:           1234,
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newResourceCollector(log.NewNopLogger(), wal, nil, &readyScrapeManager{}))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			values[mf.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	require.Equal(t, map[string]float64{
+		"agent_instance_active_series":     2,
+		"agent_instance_wal_disk_bytes":    1234,
+		"agent_instance_head_memory_bytes": 0,
+	}, values, "scrape targets should be omitted until the scrape manager is ready")
+}
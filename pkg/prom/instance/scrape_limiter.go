@@ -0,0 +1,172 @@
+package instance
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+// ScrapeLimiter limits the number of scrapes that may be in flight at once.
+// Scrapes that exceed the limit are queued and run in the order they were
+// queued once earlier scrapes finish.
+//
+// A ScrapeLimiter may be shared between instances to enforce a limit across
+// all of them.
+type ScrapeLimiter struct {
+	mut      sync.Mutex
+	limit    int
+	inFlight int
+	queue    []chan struct{}
+}
+
+// NewScrapeLimiter creates a new ScrapeLimiter. A limit of 0 or less allows
+// any number of scrapes.
+func NewScrapeLimiter(limit int) *ScrapeLimiter {
+	return &ScrapeLimiter{limit: limit}
+}
+
+// SetLimit changes the limit. Queued scrapes are started if the new limit
+// allows it.
+func (l *ScrapeLimiter) SetLimit(limit int) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.limit = limit
+	for len(l.queue) > 0 && l.hasCapacity() {
+		l.inFlight++
+		l.dequeue()
+	}
+}
+
+// InFlight returns the number of scrapes currently running.
+func (l *ScrapeLimiter) InFlight() int {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.inFlight
+}
+
+// Queued returns the number of scrapes waiting to run.
+func (l *ScrapeLimiter) Queued() int {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return len(l.queue)
+}
+
+// Acquire blocks until a scrape may run or ctx is canceled. Release must be
+// called once the scrape finishes if Acquire doesn't return an error.
+func (l *ScrapeLimiter) Acquire(ctx context.Context) error {
+	l.mut.Lock()
+	if l.hasCapacity() {
+		l.inFlight++
+		l.mut.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	l.queue = append(l.queue, ch)
+	l.mut.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	for i, queued := range l.queue {
+		if queued == ch {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return ctx.Err()
+		}
+	}
+
+	// ch was removed from the queue, so the scrape was given a slot at the
+	// same time ctx was canceled. Give the slot to the next scrape instead.
+	l.releaseLocked()
+	return ctx.Err()
+}
+
+// Release marks a scrape started by Acquire as finished.
+func (l *ScrapeLimiter) Release() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.releaseLocked()
+}
+
+func (l *ScrapeLimiter) releaseLocked() {
+	// The slot is handed off to the next queued scrape, if the limit wasn't
+	// lowered below the number of running scrapes.
+	if len(l.queue) > 0 && (l.limit <= 0 || l.inFlight <= l.limit) {
+		l.dequeue()
+		return
+	}
+	l.inFlight--
+}
+
+func (l *ScrapeLimiter) hasCapacity() bool {
+	return l.limit <= 0 || l.inFlight < l.limit
+}
+
+func (l *ScrapeLimiter) dequeue() {
+	close(l.queue[0])
+	l.queue = l.queue[1:]
+}
+
+// limitedAppendable is a storage.Appendable that holds a slot from each of
+// its limiters for the lifetime of every Appender it returns.
+//
+// The scrape manager creates an Appender right before it scrapes a target
+// and commits it once the scraped samples are appended, so the limiters
+// bound the number of in-flight scrapes.
+type limitedAppendable struct {
+	storage.Appendable
+	limiters []*ScrapeLimiter
+}
+
+func (a *limitedAppendable) Appender(ctx context.Context) storage.Appender {
+	acquired := make([]*ScrapeLimiter, 0, len(a.limiters))
+	for _, l := range a.limiters {
+		if l == nil {
+			continue
+		}
+		if err := l.Acquire(ctx); err != nil {
+			// The scrape is being stopped and will fail on its own. Don't hold
+			// on to any slots while it does so.
+			for _, l := range acquired {
+				l.Release()
+			}
+			return a.Appendable.Appender(ctx)
+		}
+		acquired = append(acquired, l)
+	}
+
+	return &limitedAppender{
+		Appender: a.Appendable.Appender(ctx),
+		limiters: acquired,
+	}
+}
+
+type limitedAppender struct {
+	storage.Appender
+	limiters []*ScrapeLimiter
+	once     sync.Once
+}
+
+func (a *limitedAppender) Commit() error {
+	defer a.release()
+	return a.Appender.Commit()
+}
+
+func (a *limitedAppender) Rollback() error {
+	defer a.release()
+	return a.Appender.Rollback()
+}
+
+func (a *limitedAppender) release() {
+	a.once.Do(func() {
+		for _, l := range a.limiters {
+			l.Release()
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeLimiter(t *testing.T) {
+	l := NewScrapeLimiter(1)
+	require.NoError(t, l.Acquire(context.Background()))
+
+	acquired := make(chan struct{})
+	go func() {
+		require.NoError(t, l.Acquire(context.Background()))
+		close(acquired)
+	}()
+
+	require.Eventually(t, func() bool { return l.Queued() == 1 }, time.Second, 10*time.Millisecond)
+	select {
+	case <-acquired:
+		require.FailNow(t, "scrape should be queued")
+	default:
+	}
+
+	l.Release()
+	<-acquired
+	require.Equal(t, 1, l.InFlight())
+	require.Equal(t, 0, l.Queued())
+
+	l.Release()
+	require.Equal(t, 0, l.InFlight())
+}
+
+func TestScrapeLimiter_Canceled(t *testing.T) {
+	l := NewScrapeLimiter(1)
+	require.NoError(t, l.Acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, l.Acquire(ctx))
+	require.Equal(t, 0, l.Queued())
+	require.Equal(t, 1, l.InFlight())
+}
+
+func TestScrapeLimiter_SetLimit(t *testing.T) {
+	l := NewScrapeLimiter(1)
+	require.NoError(t, l.Acquire(context.Background()))
+
+	acquired := make(chan struct{})
+	go func() {
+		require.NoError(t, l.Acquire(context.Background()))
+		close(acquired)
+	}()
+	require.Eventually(t, func() bool { return l.Queued() == 1 }, time.Second, 10*time.Millisecond)
+
+	// Raising the limit starts queued scrapes.
+	l.SetLimit(2)
+	<-acquired
+	require.Equal(t, 2, l.InFlight())
+
+	// Lowering the limit waits for running scrapes to finish.
+	l.SetLimit(1)
+	go func() {
+		_ = l.Acquire(context.Background())
+	}()
+	require.Eventually(t, func() bool { return l.Queued() == 1 }, time.Second, 10*time.Millisecond)
+
+	l.Release()
+	require.Equal(t, 1, l.InFlight())
+	require.Equal(t, 1, l.Queued())
+
+	l.Release()
+	require.Equal(t, 1, l.InFlight())
+	require.Equal(t, 0, l.Queued())
+}
+
+func TestLimitedAppendable(t *testing.T) {
+	var (
+		instanceLimiter = NewScrapeLimiter(2)
+		globalLimiter   = NewScrapeLimiter(1)
+	)
+	app := &limitedAppendable{
+		Appendable: &mockWalStorage{series: make(map[uint64]int)},
+		limiters:   []*ScrapeLimiter{instanceLimiter, globalLimiter, nil},
+	}
+
+	a := app.Appender(context.Background())
+	_, err := a.Append(0, labels.FromStrings("__name__", "test"), 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, instanceLimiter.InFlight())
+	require.Equal(t, 1, globalLimiter.InFlight())
+
+	// The global limit is reached, so Appender blocks until ctx is canceled.
+	// No slots should be held after that.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	b := app.Appender(ctx)
+	require.Equal(t, 1, instanceLimiter.InFlight())
+	require.NoError(t, b.Rollback())
+
+	require.NoError(t, a.Commit())
+	require.NoError(t, a.Rollback())
+	require.Equal(t, 0, instanceLimiter.InFlight())
+	require.Equal(t, 0, globalLimiter.InFlight())
+}
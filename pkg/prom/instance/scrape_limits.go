@@ -0,0 +1,126 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// ScrapeLimitsConfig holds limits applied to the samples scraped from every
+// target of an instance.
+type ScrapeLimitsConfig struct {
+	// Default sample_limit for scrape_configs that don't set one.
+	SampleLimit uint `yaml:"sample_limit,omitempty"`
+
+	// Maximum number of labels of a scraped sample, including the metric
+	// name and target labels.
+	LabelLimit uint `yaml:"label_limit,omitempty"`
+
+	// Maximum length of any label value of a scraped sample.
+	LabelValueLengthLimit uint `yaml:"label_value_length_limit,omitempty"`
+}
+
+// reportMetricNames are the names of the series written by the scrape loop
+// to report on a scrape. They aren't subject to label limits so a failed
+// scrape is still reported.
+var reportMetricNames = map[string]struct{}{
+	"up":                                    {},
+	"scrape_duration_seconds":               {},
+	"scrape_samples_scraped":                {},
+	"scrape_samples_post_metric_relabeling": {},
+	"scrape_series_added":                   {},
+}
+
+type labelLimitsMetrics struct {
+	rejections *prometheus.CounterVec
+}
+
+func newLabelLimitsMetrics(reg prometheus.Registerer) *labelLimitsMetrics {
+	return &labelLimitsMetrics{
+		rejections: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_scrape_limit_rejections_total",
+			Help: "Total number of scrapes of a target rejected for exceeding a limit from scrape_limits.",
+		}, []string{"job", "target", "limit"}),
+	}
+}
+
+// labelLimitsAppendable is a storage.Appendable that fails appends of
+// samples exceeding the label limits of a ScrapeLimitsConfig. Failing an
+// append fails the whole scrape.
+type labelLimitsAppendable struct {
+	storage.Appendable
+	metrics *labelLimitsMetrics
+
+	mut    sync.RWMutex
+	limits ScrapeLimitsConfig
+}
+
+// SetLimits changes the limits used by new Appenders.
+func (a *labelLimitsAppendable) SetLimits(limits ScrapeLimitsConfig) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.limits = limits
+}
+
+func (a *labelLimitsAppendable) Appender(ctx context.Context) storage.Appender {
+	a.mut.RLock()
+	limits := a.limits
+	a.mut.RUnlock()
+
+	app := a.Appendable.Appender(ctx)
+	if limits.LabelLimit == 0 && limits.LabelValueLengthLimit == 0 {
+		return app
+	}
+	return &labelLimitsAppender{Appender: app, limits: limits, metrics: a.metrics}
+}
+
+type labelLimitsAppender struct {
+	storage.Appender
+	limits  ScrapeLimitsConfig
+	metrics *labelLimitsMetrics
+}
+
+func (a *labelLimitsAppender) Append(ref uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	if err := a.verify(l, v); err != nil {
+		return 0, err
+	}
+	return a.Appender.Append(ref, l, t, v)
+}
+
+func (a *labelLimitsAppender) verify(l labels.Labels, v float64) error {
+	// Stale markers are written for series that were previously accepted.
+	if value.IsStaleNaN(v) {
+		return nil
+	}
+
+	name := l.Get(labels.MetricName)
+	if _, ok := reportMetricNames[name]; ok {
+		return nil
+	}
+
+	if limit := a.limits.LabelLimit; limit > 0 && uint(len(l)) > limit {
+		a.reject(l, "label_limit")
+		return fmt.Errorf("label_limit exceeded (metric: %.50s, number of labels: %d, limit: %d)", name, len(l), limit)
+	}
+
+	if limit := a.limits.LabelValueLengthLimit; limit > 0 {
+		for _, lbl := range l {
+			if uint(len(lbl.Value)) > limit {
+				a.reject(l, "label_value_length_limit")
+				return fmt.Errorf("label_value_length_limit exceeded (metric: %.50s, label: %s, value length: %d, limit: %d)", name, lbl.Name, len(lbl.Value), limit)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *labelLimitsAppender) reject(l labels.Labels, limit string) {
+	a.metrics.rejections.WithLabelValues(l.Get(model.JobLabel), l.Get(model.InstanceLabel), limit).Inc()
+}
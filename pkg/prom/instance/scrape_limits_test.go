@@ -0,0 +1,103 @@
+package instance
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ApplyDefaults_ScrapeLimits(t *testing.T) {
+	global := DefaultGlobalConfig
+	cfgText := `name: test
+scrape_limits:
+  sample_limit: 1000
+  label_limit: 30
+scrape_configs:
+  - job_name: inherits
+    static_configs:
+      - targets: ['127.0.0.1:12345']
+  - job_name: overrides
+    sample_limit: 50
+    static_configs:
+      - targets: ['127.0.0.1:12345']`
+
+	cfg, err := UnmarshalConfig(strings.NewReader(cfgText))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ApplyDefaults(&global))
+
+	require.Equal(t, uint(1000), cfg.ScrapeConfigs[0].SampleLimit)
+	require.Equal(t, uint(50), cfg.ScrapeConfigs[1].SampleLimit)
+	require.Equal(t, uint(30), cfg.ScrapeLimits.LabelLimit)
+}
+
+func TestLabelLimitsAppendable(t *testing.T) {
+	storage := &mockWalStorage{series: make(map[uint64]int)}
+	app := &labelLimitsAppendable{
+		Appendable: storage,
+		metrics:    newLabelLimitsMetrics(prometheus.NewRegistry()),
+		limits: ScrapeLimitsConfig{
+			LabelLimit:            4,
+			LabelValueLengthLimit: 10,
+		},
+	}
+
+	tt := []struct {
+		name   string
+		lset   labels.Labels
+		value  float64
+		err    string
+		reason string
+	}{
+		{
+			name: "within limits",
+			lset: labels.FromStrings("__name__", "metric", "job", "job", "instance", "target", "a", "b"),
+		},
+		{
+			name:   "too many labels",
+			lset:   labels.FromStrings("__name__", "metric", "job", "job", "instance", "target", "a", "b", "c", "d"),
+			err:    "label_limit exceeded (metric: metric, number of labels: 5, limit: 4)",
+			reason: "label_limit",
+		},
+		{
+			name:   "label value too long",
+			lset:   labels.FromStrings("__name__", "metric", "job", "job", "instance", "target", "a", "very long value"),
+			err:    "label_value_length_limit exceeded (metric: metric, label: a, value length: 15, limit: 10)",
+			reason: "label_value_length_limit",
+		},
+		{
+			name:  "stale marker",
+			lset:  labels.FromStrings("__name__", "metric", "job", "job", "instance", "target", "a", "b", "c", "d"),
+			value: math.Float64frombits(value.StaleNaN),
+		},
+		{
+			name: "report series",
+			lset: labels.FromStrings("__name__", "up", "job", "job", "instance", "target", "a", "very long value"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			a := app.Appender(context.Background())
+			_, err := a.Append(0, tc.lset, 0, tc.value)
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.EqualError(t, err, tc.err)
+			rejections := app.metrics.rejections.WithLabelValues("job", "target", tc.reason)
+			require.Equal(t, 1.0, counterValue(t, rejections))
+		})
+	}
+
+	// Removing the limits applies to new appenders.
+	app.SetLimits(ScrapeLimitsConfig{})
+	_, err := app.Appender(context.Background()).Append(0, tt[1].lset, 0, 0)
+	require.NoError(t, err)
+}
@@ -0,0 +1,46 @@
+package instance
+
+import (
+	"context"
+
+	"github.com/grafana/agent/pkg/scrub"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// scrubbingAppendable is a storage.Appendable that scrubs the labels of
+// samples and exemplars before they're written, so scrubbed values never
+// reach the WAL or any remote endpoint.
+type scrubbingAppendable struct {
+	storage.Appendable
+	scrubber *scrub.Scrubber
+}
+
+// newScrubbingAppendable wraps app with the scrubbing rules of c. Returns app
+// if c has no rules.
+func newScrubbingAppendable(app storage.Appendable, c *scrub.Config) (storage.Appendable, error) {
+	s, err := scrub.New(c)
+	if err != nil || s == nil {
+		return app, err
+	}
+	return &scrubbingAppendable{Appendable: app, scrubber: s}, nil
+}
+
+func (a *scrubbingAppendable) Appender(ctx context.Context) storage.Appender {
+	return &scrubbingAppender{Appender: a.Appendable.Appender(ctx), scrubber: a.scrubber}
+}
+
+type scrubbingAppender struct {
+	storage.Appender
+	scrubber *scrub.Scrubber
+}
+
+func (a *scrubbingAppender) Append(ref uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	return a.Appender.Append(ref, a.scrubber.Labels(l), t, v)
+}
+
+func (a *scrubbingAppender) AppendExemplar(ref uint64, l labels.Labels, e exemplar.Exemplar) (uint64, error) {
+	e.Labels = a.scrubber.Labels(e.Labels)
+	return a.Appender.AppendExemplar(ref, a.scrubber.Labels(l), e)
+}
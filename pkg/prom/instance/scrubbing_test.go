@@ -0,0 +1,118 @@
+package instance
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util/test"
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/scrub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubbingAppendable(t *testing.T) {
+	var (
+		series    []labels.Labels
+		exemplars []labels.Labels
+	)
+	inner := appendableFunc(func(_ context.Context) storage.Appender {
+		return &recordingAppender{series: &series, exemplars: &exemplars}
+	})
+
+	// Without rules the appendable isn't wrapped.
+	app, err := newScrubbingAppendable(inner, &scrub.Config{Salt: "salt"})
+	require.NoError(t, err)
+	require.IsType(t, inner, app)
+
+	app, err = newScrubbingAppendable(inner, &scrub.Config{
+		Salt: "salt",
+		Rules: []scrub.Rule{
+			{Labels: []string{"user_id"}, Regex: ".*", Action: scrub.ActionHash},
+			{Labels: []string{"client_ip"}, Regex: ".*", Action: scrub.ActionDrop},
+		},
+	})
+	require.NoError(t, err)
+
+	a := app.Appender(context.Background())
+	_, err = a.Append(0, labels.FromStrings("__name__", "requests_total", "client_ip", "10.0.0.1", "user_id", "42"), 0, 1)
+	require.NoError(t, err)
+	_, err = a.AppendExemplar(0, labels.FromStrings("__name__", "requests_total", "user_id", "42"), exemplar.Exemplar{
+		Labels: labels.FromStrings("trace_id", "abc", "user_id", "42"),
+	})
+	require.NoError(t, err)
+
+	hashed := scrub.Hash("salt", "42")
+	require.Equal(t, []labels.Labels{
+		labels.FromStrings("__name__", "requests_total", "user_id", hashed),
+		labels.FromStrings("__name__", "requests_total", "user_id", hashed),
+	}, series)
+	require.Equal(t, []labels.Labels{labels.FromStrings("trace_id", "abc", "user_id", hashed)}, exemplars)
+}
+
+type recordingAppender struct {
+	storage.Appender
+	series    *[]labels.Labels
+	exemplars *[]labels.Labels
+}
+
+func (a *recordingAppender) Append(_ uint64, l labels.Labels, _ int64, _ float64) (uint64, error) {
+	*a.series = append(*a.series, l)
+	return 0, nil
+}
+
+func (a *recordingAppender) AppendExemplar(_ uint64, l labels.Labels, e exemplar.Exemplar) (uint64, error) {
+	*a.series = append(*a.series, l)
+	*a.exemplars = append(*a.exemplars, e.Labels)
+	return 0, nil
+}
+
+// TestInstance_Appender_Scrubbing ensures samples pushed to an instance, such
+// as through the remote_write receiver, are scrubbed like scraped samples.
+func TestInstance_Appender_Scrubbing(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	globalConfig := getTestGlobalConfig(t)
+
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.WALTruncateFrequency = time.Hour
+	cfg.RemoteFlushDeadline = time.Hour
+	cfg.Scrubbing = &scrub.Config{
+		Rules: []scrub.Rule{{Labels: []string{"user_id"}, Regex: ".*", Action: scrub.ActionDrop}},
+	}
+
+	mockStorage := mockWalStorage{
+		series:    make(map[uint64]int),
+		directory: walDir,
+	}
+	newWal := func(_ prometheus.Registerer) (walStorage, error) { return &mockStorage, nil }
+
+	inst, err := newInstance(globalConfig, cfg, nil, prometheus.NewRegistry(), log.NewNopLogger(), newWal)
+	require.NoError(t, err)
+	runInstance(t, inst)
+
+	// Wait for the instance to be ready to accept samples.
+	test.Poll(t, 5*time.Second, nil, func() interface{} {
+		app := inst.Appender(context.Background())
+		_, err := app.Append(0, labels.FromStrings("__name__", "pushed_total", "user_id", "42"), 0, 1)
+		if err != nil {
+			_ = app.Rollback()
+			return err
+		}
+		return app.Commit()
+	})
+
+	mockStorage.mut.Lock()
+	defer mockStorage.mut.Unlock()
+	require.Contains(t, mockStorage.series, labels.FromStrings("__name__", "pushed_total").Hash())
+	require.NotContains(t, mockStorage.series, labels.FromStrings("__name__", "pushed_total", "user_id", "42").Hash())
+}
@@ -0,0 +1,294 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/config"
+)
+
+// Defaults for SelfHealingConfig.
+const (
+	defaultSelfHealingCheckInterval    = time.Minute
+	defaultSelfHealingFailureThreshold = 5
+)
+
+// Reasons for a failed health check.
+const (
+	unhealthyAppendErrors        = "append_errors"
+	unhealthyScrapeWedged        = "scrape_wedged"
+	unhealthyRemoteWriteFailures = "remote_write_failures"
+)
+
+// SelfHealingConfig configures health checks of a running instance. An
+// instance that fails too many checks in a row is stopped and recreated by
+// the instance manager.
+type SelfHealingConfig struct {
+	// How often to check the health of the instance. Must be greater than
+	// every scrape interval of the instance. Defaults to 1m.
+	CheckInterval time.Duration `yaml:"check_interval,omitempty"`
+
+	// Number of failed checks in a row after which the instance is
+	// recreated. Defaults to 5.
+	FailureThreshold int `yaml:"failure_threshold,omitempty"`
+}
+
+// Validate returns an error if the config is invalid for an instance running
+// scrapeConfigs. The scrape intervals of scrapeConfigs must already be set.
+func (c *SelfHealingConfig) Validate(scrapeConfigs []*config.ScrapeConfig) error {
+	switch {
+	case c.CheckInterval < 0:
+		return errors.New("self_healing.check_interval must not be negative")
+	case c.FailureThreshold < 0:
+		return errors.New("self_healing.failure_threshold must not be negative")
+	}
+
+	interval := c.checkInterval()
+	for _, sc := range scrapeConfigs {
+		if time.Duration(sc.ScrapeInterval) >= interval {
+			return fmt.Errorf("self_healing.check_interval must be greater than the scrape interval of scrape config with job name %q", sc.JobName)
+		}
+	}
+	return nil
+}
+
+func (c *SelfHealingConfig) checkInterval() time.Duration {
+	if c.CheckInterval == 0 {
+		return defaultSelfHealingCheckInterval
+	}
+	return c.CheckInterval
+}
+
+func (c *SelfHealingConfig) failureThreshold() int {
+	if c.FailureThreshold == 0 {
+		return defaultSelfHealingFailureThreshold
+	}
+	return c.FailureThreshold
+}
+
+// healthSnapshot holds the totals compared between two health checks.
+type healthSnapshot struct {
+	// Scrapes committed to the storage and how many of them failed.
+	commits, failedCommits uint64
+
+	// Targets currently being scraped.
+	activeTargets int
+
+	// Samples sent to remote_write and how many of them failed with errors
+	// that aren't retried.
+	remoteSamples, remoteFailedSamples float64
+}
+
+// healthSource takes snapshots of the instance for health checks.
+// remoteNames are the names of the remote_writes to include.
+type healthSource func(remoteNames []string) (healthSnapshot, error)
+
+type healthCheckerMetrics struct {
+	failedChecks        *prometheus.CounterVec
+	consecutiveFailures prometheus.Gauge
+}
+
+func newHealthCheckerMetrics(reg prometheus.Registerer) *healthCheckerMetrics {
+	return &healthCheckerMetrics{
+		failedChecks: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_instance_failed_health_checks_total",
+			Help: "Total number of self_healing checks of the instance that failed, by reason.",
+		}, []string{"reason"}),
+		consecutiveFailures: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "agent_prometheus_instance_consecutive_failed_health_checks",
+			Help: "Number of self_healing checks of the instance that failed in a row. The instance is recreated when it reaches self_healing.failure_threshold.",
+		}),
+	}
+}
+
+// healthChecker periodically checks whether a running instance still makes
+// progress. Instances can get stuck in states they never recover from, like
+// a WAL that rejects every append or remote_write endpoints that reject
+// every request, which otherwise go unnoticed until an operator looks at
+// the instance.
+type healthChecker struct {
+	logger  log.Logger
+	metrics *healthCheckerMetrics
+	source  healthSource
+	updated chan struct{}
+
+	mut         sync.Mutex
+	cfg         *SelfHealingConfig
+	remoteNames []string
+}
+
+func newHealthChecker(logger log.Logger, reg prometheus.Registerer, source healthSource) *healthChecker {
+	return &healthChecker{
+		logger:  logger,
+		metrics: newHealthCheckerMetrics(reg),
+		source:  source,
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// SetConfig changes the settings of the checker and the remote_writes to
+// check. A nil cfg disables checking. Changing the config restarts counting
+// failed checks.
+func (c *healthChecker) SetConfig(cfg *SelfHealingConfig, remoteWrites []*config.RemoteWriteConfig) {
+	names := make([]string, 0, len(remoteWrites))
+	for _, rw := range remoteWrites {
+		names = append(names, rw.Name)
+	}
+
+	c.mut.Lock()
+	c.cfg = cfg
+	c.remoteNames = names
+	c.mut.Unlock()
+
+	select {
+	case c.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks the health of the instance every check interval until ctx is
+// canceled. Run returns an ErrUnhealthy once failure_threshold checks in a
+// row failed.
+func (c *healthChecker) Run(ctx context.Context) error {
+	var (
+		prev      *healthSnapshot
+		failures  int
+		recovered bool
+	)
+
+	for {
+		c.mut.Lock()
+		var (
+			cfg         = c.cfg
+			remoteNames = c.remoteNames
+		)
+		c.mut.Unlock()
+
+		var next <-chan time.Time
+		if cfg != nil {
+			next = time.After(cfg.checkInterval())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.updated:
+			prev, failures = nil, 0
+			c.metrics.consecutiveFailures.Set(0)
+			continue
+		case <-next:
+		}
+
+		snapshot, err := c.source(remoteNames)
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to check instance health", "err", err)
+			continue
+		}
+
+		// The first snapshot is only used as the baseline of the next check.
+		if prev == nil {
+			prev = &snapshot
+			continue
+		}
+		reasons := failedHealthConditions(*prev, snapshot)
+		prev = &snapshot
+
+		if len(reasons) == 0 {
+			if failures > 0 {
+				level.Info(c.logger).Log("msg", "instance is healthy again", "failed_checks", failures)
+			}
+			failures, recovered = 0, true
+			c.metrics.consecutiveFailures.Set(0)
+			continue
+		}
+
+		failures++
+		c.metrics.consecutiveFailures.Set(float64(failures))
+		for _, reason := range reasons {
+			c.metrics.failedChecks.WithLabelValues(reason).Inc()
+		}
+
+		threshold := cfg.failureThreshold()
+		if failures >= threshold {
+			return ErrUnhealthy{Reason: reasons[0], Recovered: recovered}
+		}
+		level.Warn(c.logger).Log("msg", "instance health check failed", "reasons", fmt.Sprint(reasons), "failed_checks", failures, "failure_threshold", threshold)
+	}
+}
+
+// failedHealthConditions returns the reasons the instance is unhealthy
+// between two snapshots.
+func failedHealthConditions(prev, cur healthSnapshot) []string {
+	var (
+		commits             = cur.commits - prev.commits
+		failedCommits       = cur.failedCommits - prev.failedCommits
+		remoteSamples       = cur.remoteSamples - prev.remoteSamples
+		remoteFailedSamples = cur.remoteFailedSamples - prev.remoteFailedSamples
+	)
+
+	var reasons []string
+	if failedCommits > 0 && failedCommits == commits {
+		reasons = append(reasons, unhealthyAppendErrors)
+	}
+	// Scrapes of targets that are down are committed as well, so running
+	// targets always commit at least once per scrape interval.
+	if cur.activeTargets > 0 && commits == 0 {
+		reasons = append(reasons, unhealthyScrapeWedged)
+	}
+	if remoteFailedSamples > 0 && remoteFailedSamples >= remoteSamples {
+		reasons = append(reasons, unhealthyRemoteWriteFailures)
+	}
+	return reasons
+}
+
+// newInstanceHealthSource returns a healthSource reading the commits of
+// appendStats, the active targets of the scrape manager and the
+// remote_write metrics registered by the remote storage, gathered from g.
+func newInstanceHealthSource(g prometheus.Gatherer, appendStats *appendStatsAppendable, sm *readyScrapeManager) healthSource {
+	var (
+		samples       = NewMetricValueCollector(g, "remote_storage_samples_total")
+		failedSamples = NewMetricValueCollector(g, "remote_storage_samples_failed_total")
+	)
+
+	return func(remoteNames []string) (healthSnapshot, error) {
+		var s healthSnapshot
+		s.commits, s.failedCommits = appendStats.Commits()
+
+		mgr, err := sm.Get()
+		if err != nil {
+			return s, err
+		}
+		for _, targets := range mgr.TargetsActive() {
+			s.activeTargets += len(targets)
+		}
+
+		if len(remoteNames) > 0 {
+			if s.remoteSamples, err = sumValues(samples, remoteNames); err != nil {
+				return s, err
+			}
+			if s.remoteFailedSamples, err = sumValues(failedSamples, remoteNames); err != nil {
+				return s, err
+			}
+		}
+		return s, nil
+	}
+}
+
+func sumValues(vc *MetricValueCollector, remoteNames []string) (float64, error) {
+	vals, err := vc.GetValues("remote_name", remoteNames...)
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	return sum, nil
+}
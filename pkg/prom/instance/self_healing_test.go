@@ -0,0 +1,109 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailedHealthConditions(t *testing.T) {
+	prev := healthSnapshot{commits: 10, failedCommits: 2, activeTargets: 2, remoteSamples: 100, remoteFailedSamples: 5}
+
+	tt := []struct {
+		name   string
+		cur    healthSnapshot
+		expect []string
+	}{
+		{
+			name: "healthy",
+			cur:  healthSnapshot{commits: 20, failedCommits: 4, activeTargets: 2, remoteSamples: 200, remoteFailedSamples: 10},
+		},
+		{
+			name:   "every commit failed",
+			cur:    healthSnapshot{commits: 20, failedCommits: 12, activeTargets: 2, remoteSamples: 200, remoteFailedSamples: 5},
+			expect: []string{unhealthyAppendErrors},
+		},
+		{
+			name:   "no commits",
+			cur:    healthSnapshot{commits: 10, failedCommits: 2, activeTargets: 2, remoteSamples: 200, remoteFailedSamples: 5},
+			expect: []string{unhealthyScrapeWedged},
+		},
+		{
+			name: "no commits without targets",
+			cur:  healthSnapshot{commits: 10, failedCommits: 2, remoteSamples: 100, remoteFailedSamples: 5},
+		},
+		{
+			name:   "every sample failed",
+			cur:    healthSnapshot{commits: 20, failedCommits: 2, activeTargets: 2, remoteSamples: 150, remoteFailedSamples: 55},
+			expect: []string{unhealthyRemoteWriteFailures},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, failedHealthConditions(prev, tc.cur))
+		})
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	snapshots := []healthSnapshot{
+		{commits: 10, activeTargets: 1},
+		{commits: 20, activeTargets: 1},
+		// Scraping stops.
+		{commits: 20, activeTargets: 1},
+	}
+
+	var remoteNames []string
+	c := newHealthChecker(log.NewNopLogger(), prometheus.NewRegistry(), func(names []string) (healthSnapshot, error) {
+		remoteNames = names
+		s := snapshots[0]
+		if len(snapshots) > 1 {
+			snapshots = snapshots[1:]
+		}
+		return s, nil
+	})
+
+	rw := config.DefaultRemoteWriteConfig
+	rw.Name = "write"
+	c.SetConfig(&SelfHealingConfig{CheckInterval: time.Millisecond, FailureThreshold: 3}, []*config.RemoteWriteConfig{&rw})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.Run(ctx)
+	require.Equal(t, ErrUnhealthy{Reason: unhealthyScrapeWedged, Recovered: true}, err)
+	require.Equal(t, []string{"write"}, remoteNames)
+	require.Equal(t, 3.0, counterValue(t, c.metrics.failedChecks.WithLabelValues(unhealthyScrapeWedged)))
+	require.Equal(t, 3.0, gaugeValue(t, c.metrics.consecutiveFailures))
+}
+
+func TestHealthChecker_Disabled(t *testing.T) {
+	c := newHealthChecker(log.NewNopLogger(), prometheus.NewRegistry(), func([]string) (healthSnapshot, error) {
+		t.Fatal("health checked while disabled")
+		return healthSnapshot{}, nil
+	})
+	c.SetConfig(nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.NoError(t, c.Run(ctx))
+}
+
+func TestSelfHealingConfig_Validate(t *testing.T) {
+	scrapeConfigs := []*config.ScrapeConfig{
+		{JobName: "fast", ScrapeInterval: model.Duration(15 * time.Second)},
+		{JobName: "slow", ScrapeInterval: model.Duration(time.Minute)},
+	}
+
+	require.EqualError(t, (&SelfHealingConfig{}).Validate(scrapeConfigs),
+		`self_healing.check_interval must be greater than the scrape interval of scrape config with job name "slow"`)
+	require.NoError(t, (&SelfHealingConfig{CheckInterval: 2 * time.Minute}).Validate(scrapeConfigs))
+	require.EqualError(t, (&SelfHealingConfig{FailureThreshold: -1}).Validate(nil),
+		"self_healing.failure_threshold must not be negative")
+}
@@ -0,0 +1,263 @@
+package instance
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// TargetDebouncer sits between service discovery and the scrape manager,
+// delaying the removal of targets. Targets that disappear from discovery and
+// reappear within the debounce window keep being scraped as if they never
+// disappeared, avoiding the cost of stopping and starting their scrape loops.
+// Targets that don't reappear within the window are removed, and staleness
+// markers are written for them as usual.
+type TargetDebouncer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	window   time.Duration
+	outputCh chan DiscoveredGroups
+
+	mut sync.Mutex
+	// latest holds the last groups received from discovery, and seen the
+	// targets in latest by job.
+	latest DiscoveredGroups
+	seen   map[string]map[model.Fingerprint]*debouncedTarget
+	// held holds targets that disappeared from discovery within the window,
+	// by job.
+	held map[string]map[model.Fingerprint]*debouncedTarget
+}
+
+type debouncedTarget struct {
+	fp      model.Fingerprint
+	source  string
+	target  model.LabelSet
+	labels  model.LabelSet
+	removed time.Time
+}
+
+// NewTargetDebouncer creates a new TargetDebouncer that delays the removal
+// of targets by window.
+func NewTargetDebouncer(window time.Duration) *TargetDebouncer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TargetDebouncer{
+		ctx:    ctx,
+		cancel: cancel,
+
+		window:   window,
+		outputCh: make(chan DiscoveredGroups),
+
+		held: make(map[string]map[model.Fingerprint]*debouncedTarget),
+	}
+}
+
+// Run starts the TargetDebouncer. It only exits when the TargetDebouncer is
+// stopped. Run continually reads from syncCh and sends the discovered groups,
+// along with targets that were removed within the debounce window, to
+// SyncCh.
+func (d *TargetDebouncer) Run(syncCh GroupChannel) {
+	for {
+		var out DiscoveredGroups
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case in := <-syncCh:
+			out = d.update(in, time.Now())
+		case <-d.nextExpiry():
+			if !d.expire(time.Now()) {
+				continue
+			}
+			out = d.output()
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case d.outputCh <- out:
+		}
+	}
+}
+
+// Stop stops the TargetDebouncer from processing more target updates.
+func (d *TargetDebouncer) Stop() {
+	d.cancel()
+}
+
+// SyncCh returns a read only channel used by all the clients to receive
+// target updates.
+func (d *TargetDebouncer) SyncCh() GroupChannel {
+	return d.outputCh
+}
+
+// Held returns the number of targets that are no longer discovered but are
+// kept for the debounce window.
+func (d *TargetDebouncer) Held() int {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	var n int
+	for _, targets := range d.held {
+		n += len(targets)
+	}
+	return n
+}
+
+// update processes new groups from discovery received at now and returns
+// the groups to send to the scrape manager.
+func (d *TargetDebouncer) update(in DiscoveredGroups, now time.Time) DiscoveredGroups {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	seen := make(map[string]map[model.Fingerprint]*debouncedTarget, len(in))
+	for job, groups := range in {
+		targets := make(map[model.Fingerprint]*debouncedTarget)
+		for _, group := range groups {
+			if group == nil {
+				continue
+			}
+			for _, target := range group.Targets {
+				fp := mergeSets(target, group.Labels).Fingerprint()
+				targets[fp] = &debouncedTarget{
+					fp:     fp,
+					source: group.Source,
+					target: target,
+					labels: group.Labels,
+				}
+			}
+		}
+		seen[job] = targets
+	}
+
+	// Hold targets that just disappeared. Targets of jobs that were removed
+	// aren't held since their scrape pool is stopped.
+	for job, targets := range d.seen {
+		current, ok := seen[job]
+		if !ok {
+			continue
+		}
+		for fp, t := range targets {
+			if _, ok := current[fp]; ok {
+				continue
+			}
+			if d.held[job] == nil {
+				d.held[job] = make(map[model.Fingerprint]*debouncedTarget)
+			}
+			t.removed = now
+			d.held[job][fp] = t
+		}
+	}
+
+	// Stop holding targets that reappeared or whose job was removed.
+	for job, targets := range d.held {
+		current, ok := seen[job]
+		if !ok {
+			delete(d.held, job)
+			continue
+		}
+		for fp := range targets {
+			if _, ok := current[fp]; ok {
+				delete(targets, fp)
+			}
+		}
+	}
+
+	d.latest = in
+	d.seen = seen
+	d.expireLocked(now)
+	return d.outputLocked()
+}
+
+// expire removes held targets whose debounce window ended before now.
+// Returns true if any target was removed.
+func (d *TargetDebouncer) expire(now time.Time) bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return d.expireLocked(now)
+}
+
+func (d *TargetDebouncer) expireLocked(now time.Time) bool {
+	var expired bool
+	for job, targets := range d.held {
+		for fp, t := range targets {
+			if now.Sub(t.removed) >= d.window {
+				delete(targets, fp)
+				expired = true
+			}
+		}
+		if len(targets) == 0 {
+			delete(d.held, job)
+		}
+	}
+	return expired
+}
+
+// nextExpiry returns a channel that fires when the next held target should
+// be removed. Returns nil if no targets are held.
+func (d *TargetDebouncer) nextExpiry() <-chan time.Time {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	var next time.Time
+	for _, targets := range d.held {
+		for _, t := range targets {
+			if next.IsZero() || t.removed.Before(next) {
+				next = t.removed
+			}
+		}
+	}
+	if next.IsZero() {
+		return nil
+	}
+	return time.After(time.Until(next.Add(d.window)))
+}
+
+func (d *TargetDebouncer) output() DiscoveredGroups {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return d.outputLocked()
+}
+
+// outputLocked returns the latest groups from discovery along with groups
+// for held targets.
+func (d *TargetDebouncer) outputLocked() DiscoveredGroups {
+	out := make(DiscoveredGroups, len(d.latest))
+	for job, groups := range d.latest {
+		held := d.held[job]
+		if len(held) == 0 {
+			out[job] = groups
+			continue
+		}
+
+		// Copy the groups from discovery so they're not modified.
+		jobGroups := make([]*targetgroup.Group, len(groups), len(groups)+len(held))
+		copy(jobGroups, groups)
+
+		targets := make([]*debouncedTarget, 0, len(held))
+		for _, t := range held {
+			targets = append(targets, t)
+		}
+		sort.Slice(targets, func(i, j int) bool { return targets[i].fp < targets[j].fp })
+
+		// Held targets are grouped by their source and group labels so they
+		// keep the labels from their original group.
+		heldGroups := make(map[string]*targetgroup.Group)
+		for _, t := range targets {
+			key := t.source + "/" + t.labels.Fingerprint().String()
+			group, ok := heldGroups[key]
+			if !ok {
+				group = &targetgroup.Group{Source: t.source, Labels: t.labels}
+				heldGroups[key] = group
+				jobGroups = append(jobGroups, group)
+			}
+			group.Targets = append(group.Targets, t.target)
+		}
+		out[job] = jobGroups
+	}
+	return out
+}
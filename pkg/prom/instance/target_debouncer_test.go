@@ -0,0 +1,93 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetDebouncer_Update(t *testing.T) {
+	d := NewTargetDebouncer(time.Minute)
+
+	var (
+		now = time.Unix(1000, 0)
+
+		groupLabels = model.LabelSet{"env": "prod"}
+		targetA     = model.LabelSet{model.AddressLabel: "a:80"}
+		targetB     = model.LabelSet{model.AddressLabel: "b:80"}
+	)
+	discovered := func(targets ...model.LabelSet) DiscoveredGroups {
+		return DiscoveredGroups{
+			"job": {{Source: "sd", Labels: groupLabels, Targets: targets}},
+		}
+	}
+
+	out := d.update(discovered(targetA, targetB), now)
+	require.Equal(t, discovered(targetA, targetB), out)
+
+	// b disappears but is kept for the window.
+	out = d.update(discovered(targetA), now.Add(10*time.Second))
+	require.Equal(t, DiscoveredGroups{
+		"job": {
+			{Source: "sd", Labels: groupLabels, Targets: []model.LabelSet{targetA}},
+			{Source: "sd", Labels: groupLabels, Targets: []model.LabelSet{targetB}},
+		},
+	}, out)
+	require.Equal(t, 1, d.Held())
+
+	// b reappears and is no longer held.
+	out = d.update(discovered(targetA, targetB), now.Add(20*time.Second))
+	require.Equal(t, discovered(targetA, targetB), out)
+	require.Equal(t, 0, d.Held())
+
+	// b disappears again and is removed once the window passes.
+	d.update(discovered(targetA), now.Add(30*time.Second))
+	require.False(t, d.expire(now.Add(80*time.Second)))
+	require.True(t, d.expire(now.Add(90*time.Second)))
+	require.Equal(t, discovered(targetA), d.output())
+}
+
+func TestTargetDebouncer_Update_RemovedJob(t *testing.T) {
+	d := NewTargetDebouncer(time.Minute)
+	now := time.Unix(1000, 0)
+
+	d.update(DiscoveredGroups{
+		"a": {{Source: "sd", Targets: []model.LabelSet{{model.AddressLabel: "a:80"}}}},
+		"b": {{Source: "sd", Targets: []model.LabelSet{{model.AddressLabel: "b:80"}}}},
+	}, now)
+
+	// Targets of removed jobs aren't held.
+	out := d.update(DiscoveredGroups{
+		"a": {{Source: "sd", Targets: []model.LabelSet{{model.AddressLabel: "a:80"}}}},
+	}, now.Add(time.Second))
+	require.Len(t, out, 1)
+	require.Equal(t, 0, d.Held())
+}
+
+func TestTargetDebouncer_Run(t *testing.T) {
+	d := NewTargetDebouncer(100 * time.Millisecond)
+	defer d.Stop()
+
+	input := make(chan DiscoveredGroups)
+	go d.Run(input)
+
+	target := model.LabelSet{model.AddressLabel: "a:80"}
+	input <- DiscoveredGroups{"job": {{Source: "sd", Targets: []model.LabelSet{target}}}}
+	require.Len(t, (<-d.SyncCh())["job"][0].Targets, 1)
+
+	input <- DiscoveredGroups{"job": {{Source: "sd"}}}
+	out := <-d.SyncCh()
+	require.Len(t, out["job"], 2)
+
+	// Once the window passes, an update is sent without the target.
+	select {
+	case out = <-d.SyncCh():
+		require.Equal(t, DiscoveredGroups{"job": {{Source: "sd"}}}, out)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected update after debounce window")
+	}
+	require.Equal(t, []*targetgroup.Group{{Source: "sd"}}, out["job"])
+}
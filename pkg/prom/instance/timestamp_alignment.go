@@ -0,0 +1,214 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// alignmentTTL is how long the last timestamp of a series is kept after it
+// was last written.
+var alignmentTTL = 15 * time.Minute
+
+// TimestampAlignmentConfig configures aligning the timestamps of scraped
+// samples to multiples of the scrape interval of their job.
+type TimestampAlignmentConfig struct {
+	// Jobs whose timestamps are aligned, matched against the job label of
+	// scraped samples. Every job is aligned when empty.
+	Jobs []string `yaml:"jobs,omitempty"`
+}
+
+// Validate returns an error if the config is invalid.
+func (c *TimestampAlignmentConfig) Validate() error {
+	for _, job := range c.Jobs {
+		if job == "" {
+			return errors.New("timestamp_alignment.jobs must not contain empty job names")
+		}
+	}
+	return nil
+}
+
+// alignmentIntervals returns the scrape interval in milliseconds of every job
+// of scrapeConfigs to align. Returns nil if c is nil.
+func alignmentIntervals(c *TimestampAlignmentConfig, scrapeConfigs []*config.ScrapeConfig) map[string]int64 {
+	if c == nil {
+		return nil
+	}
+
+	jobs := make(map[string]struct{}, len(c.Jobs))
+	for _, job := range c.Jobs {
+		jobs[job] = struct{}{}
+	}
+
+	intervals := make(map[string]int64, len(scrapeConfigs))
+	for _, sc := range scrapeConfigs {
+		if _, ok := jobs[sc.JobName]; !ok && len(jobs) > 0 {
+			continue
+		}
+		if interval := time.Duration(sc.ScrapeInterval).Milliseconds(); interval > 0 {
+			intervals[sc.JobName] = interval
+		}
+	}
+	return intervals
+}
+
+type timestampAlignmentMetrics struct {
+	dropped prometheus.Counter
+}
+
+func newTimestampAlignmentMetrics(reg prometheus.Registerer) *timestampAlignmentMetrics {
+	return &timestampAlignmentMetrics{
+		dropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_timestamp_alignment_dropped_samples_total",
+			Help: "Total number of scraped samples dropped because an earlier sample of their series was already aligned to the same or a later timestamp.",
+		}),
+	}
+}
+
+// timestampAlignmentAppendable is a storage.Appendable that truncates the
+// timestamps of samples to a multiple of the scrape interval of their job.
+//
+// Two scrapes of a target can fall within the same interval, such as after
+// a restart or a late scrape, and stale markers are written at the time of
+// the scrape that found the series gone. To never write a sample at or
+// before the previous sample of its series, the last timestamp of every
+// series is tracked:
+//
+//   - Samples aligned to or before the last timestamp of their series are
+//     dropped, keeping the first sample of each interval.
+//   - Stale markers aren't aligned, so they're always written after the
+//     last sample of their series.
+type timestampAlignmentAppendable struct {
+	storage.Appendable
+	metrics *timestampAlignmentMetrics
+
+	mut       sync.Mutex
+	intervals map[string]int64
+	last      map[uint64]int64
+	lastPrune time.Time
+}
+
+func newTimestampAlignmentAppendable(app storage.Appendable, reg prometheus.Registerer) *timestampAlignmentAppendable {
+	return &timestampAlignmentAppendable{
+		Appendable: app,
+		metrics:    newTimestampAlignmentMetrics(reg),
+		last:       make(map[uint64]int64),
+		lastPrune:  time.Now(),
+	}
+}
+
+// SetConfig changes the jobs to align and their scrape intervals for new
+// Appenders.
+func (a *timestampAlignmentAppendable) SetConfig(c *TimestampAlignmentConfig, scrapeConfigs []*config.ScrapeConfig) {
+	intervals := alignmentIntervals(c, scrapeConfigs)
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.intervals = intervals
+	if len(intervals) == 0 {
+		a.last = make(map[uint64]int64)
+	}
+}
+
+func (a *timestampAlignmentAppendable) Appender(ctx context.Context) storage.Appender {
+	a.mut.Lock()
+	intervals := a.intervals
+	a.mut.Unlock()
+
+	app := a.Appendable.Appender(ctx)
+	if len(intervals) == 0 {
+		return app
+	}
+	return &timestampAlignmentAppender{
+		Appender:  app,
+		parent:    a,
+		intervals: intervals,
+		pending:   make(map[uint64]int64),
+	}
+}
+
+// lastTimestamp returns the last timestamp written for the series with the
+// given hash.
+func (a *timestampAlignmentAppendable) lastTimestamp(hash uint64) (int64, bool) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	t, ok := a.last[hash]
+	return t, ok
+}
+
+// commit records the timestamps written by a committed Appender.
+func (a *timestampAlignmentAppendable) commit(written map[uint64]int64) {
+	now := time.Now()
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	for hash, t := range written {
+		a.last[hash] = t
+	}
+
+	// Series that went away would otherwise be kept forever.
+	if now.Sub(a.lastPrune) >= time.Minute {
+		oldest := timestamp.FromTime(now.Add(-alignmentTTL))
+		for hash, t := range a.last {
+			if t < oldest {
+				delete(a.last, hash)
+			}
+		}
+		a.lastPrune = now
+	}
+}
+
+type timestampAlignmentAppender struct {
+	storage.Appender
+	parent    *timestampAlignmentAppendable
+	intervals map[string]int64
+
+	// Timestamps of series written by this Appender, recorded on Commit.
+	pending map[uint64]int64
+}
+
+func (a *timestampAlignmentAppender) Append(ref uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	interval, ok := a.intervals[l.Get(model.JobLabel)]
+	if !ok {
+		return a.Appender.Append(ref, l, t, v)
+	}
+
+	hash := l.Hash()
+	last, seen := a.pending[hash]
+	if !seen {
+		last, seen = a.parent.lastTimestamp(hash)
+	}
+
+	if !value.IsStaleNaN(v) {
+		t -= t % interval
+	}
+	if seen && t <= last {
+		a.parent.metrics.dropped.Inc()
+		return ref, nil
+	}
+
+	ref, err := a.Appender.Append(ref, l, t, v)
+	if err == nil {
+		a.pending[hash] = t
+	}
+	return ref, err
+}
+
+func (a *timestampAlignmentAppender) Commit() error {
+	if err := a.Appender.Commit(); err != nil {
+		return err
+	}
+	a.parent.commit(a.pending)
+	return nil
+}
@@ -0,0 +1,99 @@
+package instance
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampAlignmentAppendable(t *testing.T) {
+	var written []int64
+	app := newTimestampAlignmentAppendable(appendableFunc(func(context.Context) storage.Appender {
+		return &timestampAppender{written: &written}
+	}), prometheus.NewRegistry())
+
+	app.SetConfig(&TimestampAlignmentConfig{Jobs: []string{"aligned"}}, []*config.ScrapeConfig{
+		{JobName: "aligned", ScrapeInterval: model.Duration(15 * time.Second)},
+		{JobName: "other", ScrapeInterval: model.Duration(15 * time.Second)},
+	})
+
+	aligned := labels.FromStrings("__name__", "up", "job", "aligned")
+	other := labels.FromStrings("__name__", "up", "job", "other")
+
+	appendSample := func(l labels.Labels, ts int64, v float64) {
+		a := app.Appender(context.Background())
+		_, err := a.Append(0, l, ts, v)
+		require.NoError(t, err)
+		require.NoError(t, a.Commit())
+	}
+
+	appendSample(aligned, 16_000, 1)
+	// Falls within the same interval as the previous sample.
+	appendSample(aligned, 29_999, 1)
+	appendSample(aligned, 31_000, 1)
+	// Stale markers aren't aligned.
+	appendSample(aligned, 32_000, math.Float64frombits(value.StaleNaN))
+	// Jobs that aren't configured aren't aligned.
+	appendSample(other, 16_000, 1)
+
+	require.Equal(t, []int64{15_000, 30_000, 32_000, 16_000}, written)
+	require.Equal(t, 1.0, counterValue(t, app.metrics.dropped))
+}
+
+func TestTimestampAlignmentAppendable_AllJobs(t *testing.T) {
+	var written []int64
+	app := newTimestampAlignmentAppendable(appendableFunc(func(context.Context) storage.Appender {
+		return &timestampAppender{written: &written}
+	}), prometheus.NewRegistry())
+
+	app.SetConfig(&TimestampAlignmentConfig{}, []*config.ScrapeConfig{
+		{JobName: "a", ScrapeInterval: model.Duration(time.Minute)},
+		{JobName: "b", ScrapeInterval: model.Duration(10 * time.Second)},
+	})
+
+	a := app.Appender(context.Background())
+	_, err := a.Append(0, labels.FromStrings("job", "a"), 61_234, 1)
+	require.NoError(t, err)
+	_, err = a.Append(0, labels.FromStrings("job", "b"), 61_234, 1)
+	require.NoError(t, err)
+	require.NoError(t, a.Commit())
+
+	require.Equal(t, []int64{60_000, 60_000}, written)
+
+	// Removing the config stops aligning timestamps.
+	written = nil
+	app.SetConfig(nil, nil)
+	a = app.Appender(context.Background())
+	_, err = a.Append(0, labels.FromStrings("job", "a"), 61_234, 1)
+	require.NoError(t, err)
+	require.NoError(t, a.Commit())
+	require.Equal(t, []int64{61_234}, written)
+}
+
+// timestampAppender stores the timestamps of committed samples in written.
+type timestampAppender struct {
+	storage.Appender
+	written *[]int64
+	pending []int64
+}
+
+func (a *timestampAppender) Append(_ uint64, _ labels.Labels, t int64, _ float64) (uint64, error) {
+	a.pending = append(a.pending, t)
+	return 0, nil
+}
+
+func (a *timestampAppender) Commit() error {
+	*a.written = append(*a.written, a.pending...)
+	return nil
+}
+
+func (a *timestampAppender) Rollback() error { return nil }
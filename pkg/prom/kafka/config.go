@@ -0,0 +1,162 @@
+// Package kafka implements publishing samples from the WAL to a Kafka topic
+// as an alternative to remote_write.
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/pkg/relabel"
+)
+
+// Format is the encoding of messages published to Kafka.
+type Format string
+
+// Supported message formats.
+const (
+	// FormatRemoteWrite encodes messages as snappy-compressed remote_write
+	// protobuf, the same as the body of a remote_write HTTP request.
+	FormatRemoteWrite Format = "remote_write"
+
+	// FormatOpenMetrics encodes messages with the OpenMetrics text format.
+	FormatOpenMetrics Format = "openmetrics"
+)
+
+// DefaultConfig holds defaults for Config.
+var DefaultConfig = Config{
+	Format:               FormatRemoteWrite,
+	ClientID:             "grafana-agent",
+	Version:              sarama.DefaultVersion.String(),
+	Compression:          sarama.CompressionNone.String(),
+	MaxSamplesPerMessage: 500,
+	BatchSendDeadline:    5 * time.Second,
+	MinBackoff:           30 * time.Millisecond,
+	MaxBackoff:           5 * time.Second,
+}
+
+// Config configures publishing samples to a Kafka topic.
+type Config struct {
+	Name    string   `yaml:"name,omitempty"`
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	Format  Format   `yaml:"format,omitempty"`
+
+	ClientID    string `yaml:"client_id,omitempty"`
+	Version     string `yaml:"version,omitempty"`
+	Compression string `yaml:"compression,omitempty"`
+
+	// Samples are batched into messages of at most MaxSamplesPerMessage
+	// samples. Incomplete batches are sent after BatchSendDeadline.
+	MaxSamplesPerMessage int           `yaml:"max_samples_per_message,omitempty"`
+	BatchSendDeadline    time.Duration `yaml:"batch_send_deadline,omitempty"`
+
+	// Backoff between retries of failed sends.
+	MinBackoff time.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+
+	// TLS is used to connect to brokers when TLSConfig is set.
+	TLSConfig *config_util.TLSConfig `yaml:"tls_config,omitempty"`
+	SASL      *SASLConfig            `yaml:"sasl,omitempty"`
+
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs,omitempty"`
+}
+
+// SASLConfig configures SASL/PLAIN authentication to brokers.
+type SASLConfig struct {
+	Username string             `yaml:"username"`
+	Password config_util.Secret `yaml:"password"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	switch {
+	case len(c.Brokers) == 0:
+		return errors.New("kafka_write brokers must not be empty")
+	case c.Topic == "":
+		return errors.New("kafka_write topic is missing")
+	case c.Format != FormatRemoteWrite && c.Format != FormatOpenMetrics:
+		return fmt.Errorf("unsupported kafka_write format %q", c.Format)
+	case c.MaxSamplesPerMessage <= 0:
+		return errors.New("kafka_write max_samples_per_message must be greater than 0")
+	case c.BatchSendDeadline <= 0:
+		return errors.New("kafka_write batch_send_deadline must be greater than 0s")
+	case c.MinBackoff <= 0:
+		return errors.New("kafka_write min_backoff must be greater than 0s")
+	case c.MaxBackoff < c.MinBackoff:
+		return errors.New("kafka_write max_backoff must not be less than min_backoff")
+	}
+
+	if _, err := sarama.ParseKafkaVersion(c.Version); err != nil {
+		return fmt.Errorf("invalid kafka_write version: %w", err)
+	}
+	if _, err := parseCompression(c.Compression); err != nil {
+		return err
+	}
+	for _, rlcfg := range c.WriteRelabelConfigs {
+		if rlcfg == nil {
+			return errors.New("empty or null kafka_write relabeling rule")
+		}
+	}
+	return nil
+}
+
+// saramaConfig returns the producer config for c.
+func (c *Config) saramaConfig() (*sarama.Config, error) {
+	sc := sarama.NewConfig()
+	sc.ClientID = c.ClientID
+
+	var err error
+	if sc.Version, err = sarama.ParseKafkaVersion(c.Version); err != nil {
+		return nil, err
+	}
+	if sc.Producer.Compression, err = parseCompression(c.Compression); err != nil {
+		return nil, err
+	}
+
+	// Required by the sync producer.
+	sc.Producer.Return.Successes = true
+	sc.Producer.RequiredAcks = sarama.WaitForAll
+
+	if c.TLSConfig != nil {
+		tlsConfig, err := config_util.NewTLSConfig(c.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		sc.Net.TLS.Enable = true
+		sc.Net.TLS.Config = tlsConfig
+	}
+	if c.SASL != nil {
+		sc.Net.SASL.Enable = true
+		sc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		sc.Net.SASL.User = c.SASL.Username
+		sc.Net.SASL.Password = string(c.SASL.Password)
+	}
+
+	return sc, sc.Validate()
+}
+
+func parseCompression(s string) (sarama.CompressionCodec, error) {
+	codecs := []sarama.CompressionCodec{
+		sarama.CompressionNone,
+		sarama.CompressionGZIP,
+		sarama.CompressionSnappy,
+		sarama.CompressionLZ4,
+		sarama.CompressionZSTD,
+	}
+	for _, codec := range codecs {
+		if codec.String() == s {
+			return codec, nil
+		}
+	}
+	return sarama.CompressionNone, fmt.Errorf("unsupported kafka_write compression %q", s)
+}
@@ -0,0 +1,37 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_Unmarshal(t *testing.T) {
+	tt := []struct {
+		name string
+		cfg  string
+		err  string
+	}{
+		{name: "valid", cfg: "brokers: [localhost:9092]\ntopic: metrics"},
+		{name: "missing brokers", cfg: "topic: metrics", err: "kafka_write brokers must not be empty"},
+		{name: "missing topic", cfg: "brokers: [localhost:9092]", err: "kafka_write topic is missing"},
+		{name: "invalid format", cfg: "brokers: [localhost:9092]\ntopic: metrics\nformat: json", err: `unsupported kafka_write format "json"`},
+		{name: "invalid compression", cfg: "brokers: [localhost:9092]\ntopic: metrics\ncompression: brotli", err: `unsupported kafka_write compression "brotli"`},
+		{name: "invalid version", cfg: "brokers: [localhost:9092]\ntopic: metrics\nversion: latest", err: "invalid kafka_write version: invalid version `latest`"},
+		{name: "invalid backoff", cfg: "brokers: [localhost:9092]\ntopic: metrics\nmin_backoff: 1s\nmax_backoff: 10ms", err: "kafka_write max_backoff must not be less than min_backoff"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &cfg)
+			if tc.err == "" {
+				require.NoError(t, err)
+				require.Equal(t, FormatRemoteWrite, cfg.Format)
+				return
+			}
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package kafka
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type sample struct {
+	labels labels.Labels
+	t      int64
+	v      float64
+}
+
+// encode encodes samples into a message with the given format.
+func encode(format Format, samples []sample) ([]byte, error) {
+	switch format {
+	case FormatOpenMetrics:
+		return encodeOpenMetrics(samples), nil
+	default:
+		return encodeRemoteWrite(samples)
+	}
+}
+
+// encodeRemoteWrite encodes samples as a snappy-compressed remote_write
+// request.
+func encodeRemoteWrite(samples []sample) ([]byte, error) {
+	var (
+		req    prompb.WriteRequest
+		series = make(map[uint64]int)
+	)
+	for _, s := range samples {
+		hash := s.labels.Hash()
+		idx, ok := series[hash]
+		if !ok {
+			ts := prompb.TimeSeries{Labels: make([]prompb.Label, 0, len(s.labels))}
+			for _, l := range s.labels {
+				ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+			}
+			idx = len(req.Timeseries)
+			series[hash] = idx
+			req.Timeseries = append(req.Timeseries, ts)
+		}
+		req.Timeseries[idx].Samples = append(req.Timeseries[idx].Samples, prompb.Sample{
+			Value:     s.v,
+			Timestamp: s.t,
+		})
+	}
+
+	data, err := req.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// encodeOpenMetrics encodes samples with the OpenMetrics text format.
+// Samples are sorted so samples of the same metric are next to each other.
+func encodeOpenMetrics(samples []sample) []byte {
+	sorted := make([]sample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if c := labels.Compare(sorted[i].labels, sorted[j].labels); c != 0 {
+			ni, nj := sorted[i].labels.Get(labels.MetricName), sorted[j].labels.Get(labels.MetricName)
+			if ni != nj {
+				return ni < nj
+			}
+			return c < 0
+		}
+		return sorted[i].t < sorted[j].t
+	})
+
+	var buf bytes.Buffer
+	for _, s := range sorted {
+		buf.WriteString(s.labels.Get(labels.MetricName))
+
+		first := true
+		for _, l := range s.labels {
+			if l.Name == labels.MetricName {
+				continue
+			}
+			if first {
+				buf.WriteByte('{')
+				first = false
+			} else {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(l.Name)
+			buf.WriteString(`="`)
+			buf.WriteString(labelValueReplacer.Replace(l.Value))
+			buf.WriteByte('"')
+		}
+		if !first {
+			buf.WriteByte('}')
+		}
+
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(s.v, 'g', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(float64(s.t)/1000, 'f', -1, 64))
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("# EOF\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,26 @@
+package kafka
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeOpenMetrics(t *testing.T) {
+	samples := []sample{
+		{labels: labels.FromStrings("__name__", "b", "path", "/"), t: 2000, v: 2},
+		{labels: labels.FromStrings("__name__", "a", "msg", "say \"hi\"\n"), t: 1500, v: math.Inf(1)},
+		{labels: labels.FromStrings("__name__", "b", "path", "/"), t: 1000, v: 1},
+		{labels: labels.FromStrings("__name__", "c"), t: 1000, v: 0.5},
+	}
+
+	expect := `a{msg="say \"hi\"\n"} +Inf 1.5
+b{path="/"} 1 1
+b{path="/"} 2 2
+c 0.5 1
+# EOF
+`
+	require.Equal(t, expect, string(encodeOpenMetrics(samples)))
+}
@@ -0,0 +1,355 @@
+package kafka
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"go.uber.org/atomic"
+)
+
+// Metrics holds metrics for Writers. Metrics may be shared by multiple
+// Writers, which are distinguished by the kafka_name label.
+type Metrics struct {
+	samplesSent          *prometheus.CounterVec
+	samplesDropped       *prometheus.CounterVec
+	messagesSent         *prometheus.CounterVec
+	bytesSent            *prometheus.CounterVec
+	sendFailures         *prometheus.CounterVec
+	pendingSamples       *prometheus.GaugeVec
+	highestSentTimestamp *prometheus.GaugeVec
+}
+
+// NewMetrics creates and registers Metrics.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	return &Metrics{
+		samplesSent: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_kafka_write_samples_sent_total",
+			Help: "Total number of samples published to Kafka.",
+		}, []string{"kafka_name"}),
+		samplesDropped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_kafka_write_samples_dropped_total",
+			Help: "Total number of samples read from the WAL that weren't published because their series was unknown or dropped by relabeling.",
+		}, []string{"kafka_name"}),
+		messagesSent: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_kafka_write_messages_sent_total",
+			Help: "Total number of messages published to Kafka.",
+		}, []string{"kafka_name"}),
+		bytesSent: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_kafka_write_sent_bytes_total",
+			Help: "Total size of messages published to Kafka.",
+		}, []string{"kafka_name"}),
+		sendFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_kafka_write_send_failures_total",
+			Help: "Total number of failed attempts to publish a message to Kafka.",
+		}, []string{"kafka_name"}),
+		pendingSamples: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_kafka_write_pending_samples",
+			Help: "Number of samples waiting to be published to Kafka.",
+		}, []string{"kafka_name"}),
+		highestSentTimestamp: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_kafka_write_highest_sent_timestamp_seconds",
+			Help: "Timestamp of the newest sample published to Kafka.",
+		}, []string{"kafka_name"}),
+	}
+}
+
+// Writer tails a WAL and publishes the samples written to it to a Kafka
+// topic. Writer is the Kafka equivalent of a remote_write queue.
+type Writer struct {
+	cfg            Config
+	logger         log.Logger
+	metrics        *Metrics
+	externalLabels labels.Labels
+	watcher        *wal.Watcher
+
+	// newProducer creates the Kafka producer. The producer is created lazily
+	// so the Writer can start while brokers are unavailable.
+	newProducer func() (sarama.SyncProducer, error)
+
+	stopOnce sync.Once
+	quit     chan struct{}
+	done     chan struct{}
+
+	seriesMtx            sync.Mutex
+	seriesLabels         map[uint64]labels.Labels
+	seriesSegmentIndexes map[uint64]int
+
+	// sendMtx protects pending and producer.
+	sendMtx  sync.Mutex
+	pending  []sample
+	producer sarama.SyncProducer
+
+	highestSent atomic.Int64
+}
+
+// NewWriter creates a new Writer that reads the WAL in walDir. External
+// labels are added to every series before relabeling. Call Run to start
+// publishing samples.
+func NewWriter(logger log.Logger, metrics *Metrics, cfg Config, walDir string, externalLabels labels.Labels) (*Writer, error) {
+	sc, err := cfg.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		cfg:            cfg,
+		logger:         log.With(logger, "kafka_name", cfg.Name),
+		metrics:        metrics,
+		externalLabels: externalLabels,
+
+		newProducer: func() (sarama.SyncProducer, error) {
+			return sarama.NewSyncProducer(cfg.Brokers, sc)
+		},
+
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+
+		seriesLabels:         make(map[uint64]labels.Labels),
+		seriesSegmentIndexes: make(map[uint64]int),
+	}
+
+	// The remote_write queues already register the watcher metrics, so the
+	// watcher uses unregistered ones.
+	w.watcher = wal.NewWatcher(wal.NewWatcherMetrics(nil), wal.NewLiveReaderMetrics(nil), w.logger, cfg.Name, w, walDir)
+	return w, nil
+}
+
+// Run reads the WAL and publishes samples until Stop is called.
+func (w *Writer) Run() error {
+	defer close(w.done)
+
+	w.watcher.Start()
+
+	ticker := time.NewTicker(w.cfg.BatchSendDeadline)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.quit:
+			w.watcher.Stop()
+			w.shutdown()
+			return nil
+		case <-ticker.C:
+			w.sendMtx.Lock()
+			w.flushLocked()
+			w.sendMtx.Unlock()
+		}
+	}
+}
+
+// shutdown makes one last attempt to publish pending samples and closes the
+// producer.
+func (w *Writer) shutdown() {
+	w.sendMtx.Lock()
+	defer w.sendMtx.Unlock()
+
+	for len(w.pending) > 0 {
+		n := w.batchSize()
+		if err := w.send(w.pending[:n]); err != nil {
+			level.Warn(w.logger).Log("msg", "failed to publish pending samples on shutdown", "samples", len(w.pending), "err", err)
+			break
+		}
+		w.pending = w.pending[n:]
+	}
+	w.pending = nil
+	w.metrics.pendingSamples.DeleteLabelValues(w.cfg.Name)
+
+	if w.producer != nil {
+		if err := w.producer.Close(); err != nil {
+			level.Warn(w.logger).Log("msg", "failed to close kafka producer", "err", err)
+		}
+		w.producer = nil
+	}
+}
+
+// Stop stops the Writer and waits for Run to exit.
+func (w *Writer) Stop() {
+	w.stopOnce.Do(func() { close(w.quit) })
+	<-w.done
+}
+
+// HighestSentTimestamp returns the timestamp in milliseconds of the newest
+// sample published to Kafka. Returns 0 if no samples have been published.
+func (w *Writer) HighestSentTimestamp() int64 {
+	return w.highestSent.Load()
+}
+
+// Append implements wal.WriteTo. It queues samples to be published and
+// blocks while a full batch can't be published, or until the Writer is
+// stopped.
+func (w *Writer) Append(samples []record.RefSample) bool {
+	select {
+	case <-w.quit:
+		return false
+	default:
+	}
+
+	w.sendMtx.Lock()
+	defer w.sendMtx.Unlock()
+
+	for _, s := range samples {
+		w.seriesMtx.Lock()
+		lbls, ok := w.seriesLabels[s.Ref]
+		w.seriesMtx.Unlock()
+		if !ok {
+			w.metrics.samplesDropped.WithLabelValues(w.cfg.Name).Inc()
+			continue
+		}
+
+		w.pending = append(w.pending, sample{labels: lbls, t: s.T, v: s.V})
+		if len(w.pending) >= w.cfg.MaxSamplesPerMessage && !w.flushLocked() {
+			return false
+		}
+	}
+
+	w.metrics.pendingSamples.WithLabelValues(w.cfg.Name).Set(float64(len(w.pending)))
+	return true
+}
+
+// StoreSeries implements wal.WriteTo. It keeps track of series labels to
+// look up when samples are appended.
+func (w *Writer) StoreSeries(series []record.RefSeries, index int) {
+	w.seriesMtx.Lock()
+	defer w.seriesMtx.Unlock()
+
+	for _, s := range series {
+		w.seriesSegmentIndexes[s.Ref] = index
+
+		lbls := relabel.Process(w.withExternalLabels(s.Labels), w.cfg.WriteRelabelConfigs...)
+		if len(lbls) == 0 {
+			delete(w.seriesLabels, s.Ref)
+			continue
+		}
+		w.seriesLabels[s.Ref] = lbls
+	}
+}
+
+// SeriesReset implements wal.WriteTo. It removes series that were last seen
+// in a segment older than index.
+func (w *Writer) SeriesReset(index int) {
+	w.seriesMtx.Lock()
+	defer w.seriesMtx.Unlock()
+
+	for ref, segment := range w.seriesSegmentIndexes {
+		if segment < index {
+			delete(w.seriesSegmentIndexes, ref)
+			delete(w.seriesLabels, ref)
+		}
+	}
+}
+
+// withExternalLabels adds external labels to ls. Labels already in ls take
+// precedence.
+func (w *Writer) withExternalLabels(ls labels.Labels) labels.Labels {
+	if len(w.externalLabels) == 0 {
+		return ls
+	}
+	b := labels.NewBuilder(ls)
+	for _, l := range w.externalLabels {
+		if ls.Get(l.Name) == "" {
+			b.Set(l.Name, l.Value)
+		}
+	}
+	return b.Labels()
+}
+
+// flushLocked publishes all pending samples, retrying failed sends until
+// they succeed. Returns false if the Writer was stopped before all samples
+// were published. sendMtx must be held.
+func (w *Writer) flushLocked() bool {
+	defer func() {
+		w.metrics.pendingSamples.WithLabelValues(w.cfg.Name).Set(float64(len(w.pending)))
+	}()
+
+	for len(w.pending) > 0 {
+		n := w.batchSize()
+		if !w.sendWithRetry(w.pending[:n]) {
+			return false
+		}
+		w.pending = w.pending[n:]
+	}
+	w.pending = w.pending[:0]
+	return true
+}
+
+func (w *Writer) batchSize() int {
+	if len(w.pending) < w.cfg.MaxSamplesPerMessage {
+		return len(w.pending)
+	}
+	return w.cfg.MaxSamplesPerMessage
+}
+
+// sendWithRetry publishes a batch of samples, retrying with backoff until
+// it succeeds. Returns false if the Writer was stopped first.
+func (w *Writer) sendWithRetry(batch []sample) bool {
+	backoff := w.cfg.MinBackoff
+	for {
+		err := w.send(batch)
+		if err == nil {
+			return true
+		}
+
+		w.metrics.sendFailures.WithLabelValues(w.cfg.Name).Inc()
+		level.Warn(w.logger).Log("msg", "failed to publish samples, retrying", "samples", len(batch), "backoff", backoff, "err", err)
+
+		select {
+		case <-w.quit:
+			return false
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > w.cfg.MaxBackoff {
+			backoff = w.cfg.MaxBackoff
+		}
+	}
+}
+
+// send publishes a batch of samples as a single message. sendMtx must be
+// held.
+func (w *Writer) send(batch []sample) error {
+	if w.producer == nil {
+		producer, err := w.newProducer()
+		if err != nil {
+			return err
+		}
+		w.producer = producer
+	}
+
+	msg, err := encode(w.cfg.Format, batch)
+	if err != nil {
+		return err
+	}
+	_, _, err = w.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: w.cfg.Topic,
+		Value: sarama.ByteEncoder(msg),
+	})
+	if err != nil {
+		return err
+	}
+
+	var highest int64
+	for _, s := range batch {
+		if s.t > highest {
+			highest = s.t
+		}
+	}
+	if highest > w.highestSent.Load() {
+		w.highestSent.Store(highest)
+		w.metrics.highestSentTimestamp.WithLabelValues(w.cfg.Name).Set(float64(highest) / 1000)
+	}
+
+	w.metrics.samplesSent.WithLabelValues(w.cfg.Name).Add(float64(len(batch)))
+	w.metrics.messagesSent.WithLabelValues(w.cfg.Name).Inc()
+	w.metrics.bytesSent.WithLabelValues(w.cfg.Name).Add(float64(len(msg)))
+	return nil
+}
@@ -0,0 +1,200 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/agent/pkg/prom/wal"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_Append(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.Brokers = []string{"localhost:9092"}
+	cfg.Topic = "metrics"
+	cfg.MaxSamplesPerMessage = 2
+	cfg.WriteRelabelConfigs = []*relabel.Config{{
+		SourceLabels: model.LabelNames{"__name__"},
+		Regex:        relabel.MustNewRegexp("dropped"),
+		Action:       relabel.Drop,
+	}}
+
+	w, producer := newTestWriter(t, cfg, "", labels.FromStrings("cluster", "prod"))
+	w.StoreSeries([]record.RefSeries{
+		{Ref: 1, Labels: labels.FromStrings("__name__", "kept")},
+		{Ref: 2, Labels: labels.FromStrings("__name__", "dropped")},
+		{Ref: 3, Labels: labels.FromStrings("__name__", "own", "cluster", "dev")},
+	}, 0)
+
+	require.True(t, w.Append([]record.RefSample{
+		{Ref: 1, T: 1000, V: 1},
+		{Ref: 2, T: 1000, V: 2},
+		{Ref: 4, T: 1000, V: 4},
+	}))
+	require.Len(t, producer.Messages(), 0, "incomplete batch shouldn't be sent")
+
+	// Filling the batch sends it.
+	require.True(t, w.Append([]record.RefSample{{Ref: 3, T: 2000, V: 3}}))
+	msgs := producer.Messages()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "metrics", msgs[0].Topic)
+	require.Equal(t, []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "kept"}, {Name: "cluster", Value: "prod"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "own"}, {Name: "cluster", Value: "dev"}},
+			Samples: []prompb.Sample{{Value: 3, Timestamp: 2000}},
+		},
+	}, decodeRemoteWrite(t, msgs[0]).Timeseries)
+	require.Equal(t, int64(2000), w.HighestSentTimestamp())
+
+	// Series removed by SeriesReset are no longer known.
+	w.SeriesReset(1)
+	require.True(t, w.Append([]record.RefSample{{Ref: 1, T: 3000, V: 1}}))
+	require.True(t, w.flushLocked())
+	require.Len(t, producer.Messages(), 1)
+}
+
+func TestWriter_Retry(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.Brokers = []string{"localhost:9092"}
+	cfg.Topic = "metrics"
+	cfg.MaxSamplesPerMessage = 1
+	cfg.MinBackoff = time.Millisecond
+	cfg.MaxBackoff = time.Millisecond
+
+	w, producer := newTestWriter(t, cfg, "", nil)
+	producer.failures = 3
+
+	w.StoreSeries([]record.RefSeries{{Ref: 1, Labels: labels.FromStrings("__name__", "metric")}}, 0)
+	require.True(t, w.Append([]record.RefSample{{Ref: 1, T: 1000, V: 1}}))
+	require.Len(t, producer.Messages(), 1)
+
+	// Appending stops once the writer is stopped.
+	producer.failures = -1
+	done := make(chan bool)
+	go func() { done <- w.Append([]record.RefSample{{Ref: 1, T: 2000, V: 1}}) }()
+	close(w.quit)
+	select {
+	case ok := <-done:
+		require.False(t, ok)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Append didn't stop")
+	}
+}
+
+func TestWriter_Run(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "kafka")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := wal.NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
+	require.NoError(t, err)
+	defer s.Close()
+
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.Brokers = []string{"localhost:9092"}
+	cfg.Topic = "metrics"
+	cfg.Format = FormatOpenMetrics
+	cfg.BatchSendDeadline = 10 * time.Millisecond
+
+	w, producer := newTestWriter(t, cfg, walDir, nil)
+	go w.Run()
+	defer w.Stop()
+
+	// The watcher only sends samples newer than when it started.
+	ts := timestamp.FromTime(time.Now().Add(time.Minute))
+	app := s.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "metric", "job", "test"), ts, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.Eventually(t, func() bool {
+		return len(producer.Messages()) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, `metric{job="test"} 1 `+strconv.FormatFloat(float64(ts)/1000, 'f', -1, 64)+"\n# EOF\n", string(producer.Messages()[0].Value.(sarama.ByteEncoder)))
+	require.Equal(t, ts, w.HighestSentTimestamp())
+}
+
+func newTestWriter(t *testing.T, cfg Config, walDir string, externalLabels labels.Labels) (*Writer, *fakeProducer) {
+	t.Helper()
+
+	w, err := NewWriter(log.NewNopLogger(), NewMetrics(prometheus.NewRegistry()), cfg, walDir, externalLabels)
+	require.NoError(t, err)
+
+	producer := &fakeProducer{}
+	w.newProducer = func() (sarama.SyncProducer, error) { return producer, nil }
+	return w, producer
+}
+
+func decodeRemoteWrite(t *testing.T, msg *sarama.ProducerMessage) prompb.WriteRequest {
+	t.Helper()
+
+	data, err := snappy.Decode(nil, msg.Value.(sarama.ByteEncoder))
+	require.NoError(t, err)
+
+	var req prompb.WriteRequest
+	require.NoError(t, proto.Unmarshal(data, &req))
+	return req
+}
+
+// fakeProducer records sent messages. The first failures sends fail, or
+// all sends fail if failures is negative.
+type fakeProducer struct {
+	mut      sync.Mutex
+	msgs     []*sarama.ProducerMessage
+	failures int
+}
+
+func (p *fakeProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if p.failures != 0 {
+		if p.failures > 0 {
+			p.failures--
+		}
+		return 0, 0, errors.New("send failed")
+	}
+	p.msgs = append(p.msgs, msg)
+	return 0, int64(len(p.msgs)), nil
+}
+
+func (p *fakeProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *fakeProducer) Close() error { return nil }
+
+func (p *fakeProducer) Messages() []*sarama.ProducerMessage {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return append([]*sarama.ProducerMessage(nil), p.msgs...)
+}
@@ -0,0 +1,169 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/template"
+)
+
+// DefaultConfig holds default settings for evaluating rules.
+var DefaultConfig = Config{
+	HeadRetention: 10 * time.Minute,
+}
+
+// Config configures the rules evaluated by an instance.
+type Config struct {
+	// How long scraped samples are kept in memory for rules to query. Range
+	// selectors in rule expressions can't look back further than this.
+	HeadRetention time.Duration `yaml:"head_retention,omitempty"`
+
+	Groups []GroupConfig `yaml:"groups,omitempty"`
+
+	// Alertmanagers to send alerts from alerting rules to.
+	Alerting config.AlertingConfig `yaml:"alerting,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// ApplyDefaults validates the config and sets the interval of groups that
+// don't define one to evaluationInterval.
+func (c *Config) ApplyDefaults(evaluationInterval time.Duration) error {
+	if c.HeadRetention <= 0 {
+		return errors.New("head_retention must be greater than 0s")
+	}
+
+	groupNames := make(map[string]struct{}, len(c.Groups))
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if g.Name == "" {
+			return errors.New("rule group name must not be empty")
+		}
+		if _, exists := groupNames[g.Name]; exists {
+			return fmt.Errorf("found multiple rule groups with name %q", g.Name)
+		}
+		groupNames[g.Name] = struct{}{}
+
+		if g.Interval == 0 {
+			g.Interval = evaluationInterval
+		}
+		if g.Interval <= 0 {
+			return fmt.Errorf("interval for rule group %q must be greater than 0s", g.Name)
+		}
+
+		for j, r := range g.Rules {
+			if err := r.validate(); err != nil {
+				return fmt.Errorf("invalid rule %d in group %q: %w", j, g.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GroupConfig is a set of rules that are evaluated sequentially at the same
+// interval.
+type GroupConfig struct {
+	Name     string        `yaml:"name"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Rules    []RuleConfig  `yaml:"rules"`
+}
+
+// RuleConfig is either a recording rule or an alerting rule. Exactly one of
+// Record and Alert must be set.
+type RuleConfig struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         time.Duration     `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Name returns the name of the recorded series or of the alert.
+func (r RuleConfig) Name() string {
+	if r.Record != "" {
+		return r.Record
+	}
+	return r.Alert
+}
+
+func (r RuleConfig) validate() error {
+	switch {
+	case r.Record == "" && r.Alert == "":
+		return errors.New("one of record or alert must be set")
+	case r.Record != "" && r.Alert != "":
+		return errors.New("only one of record or alert may be set")
+	case r.Record != "" && !model.IsValidMetricName(model.LabelValue(r.Record)):
+		return fmt.Errorf("invalid recording rule name %q", r.Record)
+	case r.Record != "" && len(r.Annotations) > 0:
+		return errors.New("recording rules may not have annotations")
+	case r.Record != "" && r.For != 0:
+		return errors.New("recording rules may not have a for duration")
+	case r.For < 0:
+		return errors.New("for must not be negative")
+	}
+
+	if r.Expr == "" {
+		return errors.New("expr must not be empty")
+	}
+	if _, err := parser.ParseExpr(r.Expr); err != nil {
+		return fmt.Errorf("could not parse expression: %w", err)
+	}
+	for name := range r.Labels {
+		if name == model.MetricNameLabel {
+			return fmt.Errorf("label %q may not be overridden", name)
+		}
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	for name := range r.Annotations {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid annotation name %q", name)
+		}
+	}
+
+	if r.Alert != "" {
+		return r.validateTemplates()
+	}
+	return nil
+}
+
+// alertTemplateDefs are the variables Prometheus makes available to label
+// and annotation templates of alerting rules.
+const alertTemplateDefs = "{{$labels := .Labels}}{{$externalLabels := .ExternalLabels}}{{$value := .Value}}"
+
+// validateTemplates checks that the labels and annotations of an alerting
+// rule are valid templates.
+func (r RuleConfig) validateTemplates() error {
+	parse := func(kind, name, text string) error {
+		tmpl := template.NewTemplateExpander(context.Background(), alertTemplateDefs+text, "__alert_"+r.Alert, nil, 0, nil, nil)
+		if err := tmpl.ParseTest(); err != nil {
+			return fmt.Errorf("invalid template in %s %q: %w", kind, name, err)
+		}
+		return nil
+	}
+
+	for name, text := range r.Labels {
+		if err := parse("label", name, text); err != nil {
+			return err
+		}
+	}
+	for name, text := range r.Annotations {
+		if err := parse("annotation", name, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_Unmarshal_Defaults(t *testing.T) {
+	cfgText := `
+groups:
+  - name: test
+    rules:
+      - record: job:up:sum
+        expr: sum by (job) (up)
+`
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(cfgText), &cfg))
+	require.NoError(t, cfg.ApplyDefaults(time.Minute))
+
+	require.Equal(t, DefaultConfig.HeadRetention, cfg.HeadRetention)
+	require.Equal(t, time.Minute, cfg.Groups[0].Interval)
+}
+
+func TestConfig_ApplyDefaults_Validations(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name:   "no head retention",
+			cfg:    `head_retention: 0s`,
+			expect: "head_retention must be greater than 0s",
+		},
+		{
+			name: "missing group name",
+			cfg: `
+groups:
+  - rules: []`,
+			expect: "rule group name must not be empty",
+		},
+		{
+			name: "duplicate group names",
+			cfg: `
+groups:
+  - name: test
+  - name: test`,
+			expect: `found multiple rule groups with name "test"`,
+		},
+		{
+			name: "invalid record",
+			cfg: `
+groups:
+  - name: test
+    rules:
+      - record: "not a metric"
+        expr: up`,
+			expect: `invalid rule 0 in group "test": invalid recording rule name "not a metric"`,
+		},
+		{
+			name: "invalid expression",
+			cfg: `
+groups:
+  - name: test
+    rules:
+      - record: job:up:sum
+        expr: sum(`,
+			expect: `invalid rule 0 in group "test": could not parse expression: 1:5: parse error: unclosed left parenthesis`,
+		},
+		{
+			name: "overridden metric name",
+			cfg: `
+groups:
+  - name: test
+    rules:
+      - record: job:up:sum
+        expr: sum(up)
+        labels:
+          __name__: foo`,
+			expect: `invalid rule 0 in group "test": label "__name__" may not be overridden`,
+		},
+		{
+			name: "record and alert",
+			cfg: `
+groups:
+  - name: test
+    rules:
+      - record: job:up:sum
+        alert: Down
+        expr: up == 0`,
+			expect: `invalid rule 0 in group "test": only one of record or alert may be set`,
+		},
+		{
+			name: "recording rule with annotations",
+			cfg: `
+groups:
+  - name: test
+    rules:
+      - record: job:up:sum
+        expr: sum(up)
+        annotations:
+          summary: test`,
+			expect: `invalid rule 0 in group "test": recording rules may not have annotations`,
+		},
+		{
+			name: "invalid template",
+			cfg: `
+groups:
+  - name: test
+    rules:
+      - alert: Down
+        expr: up == 0
+        annotations:
+          summary: "{{ $labels.job "`,
+			expect: `invalid rule 0 in group "test": invalid template in annotation "summary": template: __alert_Down:1: unclosed action`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			require.NoError(t, yaml.Unmarshal([]byte(tc.cfg), &cfg))
+			require.EqualError(t, cfg.ApplyDefaults(time.Minute), tc.expect)
+		})
+	}
+}
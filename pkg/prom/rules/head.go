@@ -0,0 +1,123 @@
+package rules
+
+import (
+	"context"
+	"math"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// Head holds recently appended samples in memory so they can be queried by
+// rules. Samples older than the retention are removed on Truncate.
+//
+// Head implements storage.Storage so it can be used as a secondary storage
+// of a fanout. Appending to a Head never fails; samples the Head can't store,
+// like samples older than the retention, are silently dropped.
+type Head struct {
+	head      *tsdb.Head
+	dir       string
+	retention time.Duration
+}
+
+// NewHead creates a new Head. Full chunks are memory mapped from files in
+// dir, which is cleared when the Head is created and closed.
+func NewHead(reg prometheus.Registerer, logger log.Logger, dir string, retention time.Duration) (*Head, error) {
+	// Chunks left behind from a previous run can't be used without a WAL.
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+
+	opts := tsdb.DefaultHeadOptions()
+	opts.ChunkRange = retention.Milliseconds()
+	opts.ChunkDirRoot = dir
+
+	h, err := tsdb.NewHead(reg, logger, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Init(math.MinInt64); err != nil {
+		return nil, err
+	}
+
+	return &Head{head: h, dir: dir, retention: retention}, nil
+}
+
+// Truncate removes samples older than the retention relative to now.
+func (h *Head) Truncate(now time.Time) error {
+	// Truncating an empty head initializes its time range, which would cause
+	// all future samples to be rejected.
+	if h.head.MinTime() == math.MaxInt64 {
+		return nil
+	}
+	return h.head.Truncate(timestamp.FromTime(now.Add(-h.retention)))
+}
+
+// NumSeries returns the number of series in the Head.
+func (h *Head) NumSeries() uint64 {
+	return h.head.NumSeries()
+}
+
+// Querier implements storage.Queryable.
+func (h *Head) Querier(_ context.Context, mint, maxt int64) (storage.Querier, error) {
+	return tsdb.NewBlockQuerier(tsdb.NewRangeHead(h.head, mint, maxt), mint, maxt)
+}
+
+// ChunkQuerier implements storage.ChunkQueryable.
+func (h *Head) ChunkQuerier(_ context.Context, mint, maxt int64) (storage.ChunkQuerier, error) {
+	return tsdb.NewBlockChunkQuerier(tsdb.NewRangeHead(h.head, mint, maxt), mint, maxt)
+}
+
+// StartTime implements storage.Storage.
+func (h *Head) StartTime() (int64, error) {
+	return h.head.MinTime(), nil
+}
+
+// Appender implements storage.Appendable.
+func (h *Head) Appender(ctx context.Context) storage.Appender {
+	return &headAppender{app: h.head.Appender(ctx)}
+}
+
+// Close closes the Head and removes its chunks from disk.
+func (h *Head) Close() error {
+	if err := h.head.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(h.dir)
+}
+
+// headAppender drops samples that can't be appended to the head so a fanout
+// writing to the WAL never fails because of the Head. Dropped samples are
+// reported by the head's out of bounds and out of order metrics.
+type headAppender struct {
+	app storage.Appender
+}
+
+// Append appends a sample. The ref is ignored since it belongs to the
+// primary storage of the fanout rather than the head.
+func (a *headAppender) Append(_ uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	_, _ = a.app.Append(0, l, t, v)
+	return 0, nil
+}
+
+func (a *headAppender) AppendExemplar(uint64, labels.Labels, exemplar.Exemplar) (uint64, error) {
+	// Exemplars can't be used by rules.
+	return 0, nil
+}
+
+func (a *headAppender) Commit() error {
+	_ = a.app.Commit()
+	return nil
+}
+
+func (a *headAppender) Rollback() error {
+	_ = a.app.Rollback()
+	return nil
+}
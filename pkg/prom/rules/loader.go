@@ -0,0 +1,64 @@
+package rules
+
+import (
+	"sync"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// groupsIdentifier is the identifier groups are loaded from. The Prometheus
+// rule manager normally loads groups from files; groupLoader ignores the
+// identifier and returns the groups from the config.
+const groupsIdentifier = "rules"
+
+// groupLoader implements rules.GroupLoader by converting the groups from a
+// Config into the format of Prometheus rule files.
+type groupLoader struct {
+	mut    sync.Mutex
+	groups []GroupConfig
+}
+
+// SetGroups sets the groups returned by Load and returns the previous ones.
+func (l *groupLoader) SetGroups(groups []GroupConfig) []GroupConfig {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	prev := l.groups
+	l.groups = groups
+	return prev
+}
+
+// Load implements rules.GroupLoader.
+func (l *groupLoader) Load(_ string) (*rulefmt.RuleGroups, []error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	rgs := &rulefmt.RuleGroups{Groups: make([]rulefmt.RuleGroup, 0, len(l.groups))}
+	for _, g := range l.groups {
+		rg := rulefmt.RuleGroup{
+			Name:     g.Name,
+			Interval: model.Duration(g.Interval),
+			Rules:    make([]rulefmt.RuleNode, 0, len(g.Rules)),
+		}
+		for _, r := range g.Rules {
+			rg.Rules = append(rg.Rules, rulefmt.RuleNode{
+				Record:      yaml.Node{Kind: yaml.ScalarNode, Value: r.Record},
+				Alert:       yaml.Node{Kind: yaml.ScalarNode, Value: r.Alert},
+				Expr:        yaml.Node{Kind: yaml.ScalarNode, Value: r.Expr},
+				For:         model.Duration(r.For),
+				Labels:      r.Labels,
+				Annotations: r.Annotations,
+			})
+		}
+		rgs.Groups = append(rgs.Groups, rg)
+	}
+	return rgs, nil
+}
+
+// Parse implements rules.GroupLoader.
+func (l *groupLoader) Parse(query string) (parser.Expr, error) {
+	return parser.ParseExpr(query)
+}
@@ -0,0 +1,234 @@
+// Package rules evaluates Prometheus recording and alerting rules against
+// samples held in memory by a Head.
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/notifier"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	promrules "github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// headTruncateFrequency is how often old samples are removed from the Head.
+var headTruncateFrequency = time.Minute
+
+// ErrStopped is returned by ApplyConfig when the Manager has been stopped.
+var ErrStopped = errors.New("rule manager stopped")
+
+// Settings for evaluating alerting rules and sending alerts. These are the
+// defaults used by Prometheus.
+const (
+	outageTolerance       = time.Hour
+	forGracePeriod        = 10 * time.Minute
+	notificationQueueSize = 10000
+)
+
+// resendDelay is the minimum amount of time to wait before resending an
+// alert to Alertmanagers.
+var resendDelay = time.Minute
+
+// Manager evaluates groups of rules against a Head using the Prometheus rule
+// manager and appends the results to an Appendable. Alerts from alerting
+// rules are sent to the configured Alertmanagers.
+type Manager struct {
+	logger         log.Logger
+	head           *Head
+	loader         *groupLoader
+	rules          *promrules.Manager
+	externalLabels labels.Labels
+
+	notifier  *notifier.Manager
+	discovery *discovery.Manager
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mut     sync.Mutex
+	stopped bool
+}
+
+// NewManager creates a new Manager. Rule results are appended to appendable,
+// which should also append to head so rules can query the results of other
+// rules. externalLabels are added to alerts sent to Alertmanagers.
+func NewManager(reg prometheus.Registerer, logger log.Logger, head *Head, appendable storage.Appendable, externalLabels labels.Labels) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m := &Manager{
+		logger:         logger,
+		head:           head,
+		loader:         &groupLoader{},
+		externalLabels: externalLabels,
+
+		notifier: notifier.NewManager(&notifier.Options{
+			QueueCapacity: notificationQueueSize,
+			Registerer:    reg,
+		}, log.With(logger, "component", "notifier")),
+		discovery: discovery.NewManager(ctx, log.With(logger, "component", "notifier discovery manager"), discovery.Name("notify")),
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		Logger:     log.With(logger, "component", "query engine"),
+		Reg:        reg,
+		MaxSamples: 50000000,
+		Timeout:    2 * time.Minute,
+	})
+	m.rules = promrules.NewManager(&promrules.ManagerOptions{
+		// There's no UI to link to, but templates expect a non-nil URL.
+		ExternalURL:     &url.URL{},
+		QueryFunc:       promrules.EngineQueryFunc(engine, head),
+		NotifyFunc:      m.sendAlerts,
+		Context:         ctx,
+		Appendable:      appendable,
+		Queryable:       head,
+		Logger:          logger,
+		Registerer:      reg,
+		OutageTolerance: outageTolerance,
+		ForGracePeriod:  forGracePeriod,
+		ResendDelay:     resendDelay,
+		GroupLoader:     m.loader,
+	})
+
+	// The Head is ready as soon as it's created, so groups may start being
+	// evaluated as soon as they're applied. Run returns once the rule manager
+	// is stopped.
+	go m.rules.Run()
+	return m
+}
+
+// ApplyConfig starts evaluating the groups from cfg and sends alerts to
+// its Alertmanagers. Groups that didn't change keep running. Changed groups
+// are restarted, keeping the state of their alerts. Removed groups are
+// stopped and their series are marked as stale.
+func (m *Manager) ApplyConfig(cfg Config) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.stopped {
+		return ErrStopped
+	}
+
+	// The notifier takes the external labels and Alertmanagers from a
+	// Prometheus config.
+	err := m.notifier.ApplyConfig(&config.Config{
+		GlobalConfig:   config.GlobalConfig{ExternalLabels: m.externalLabels},
+		AlertingConfig: cfg.Alerting,
+	})
+	if err != nil {
+		return fmt.Errorf("failed applying config to notifier: %w", err)
+	}
+	amCfgs := cfg.Alerting.AlertmanagerConfigs.ToMap()
+	sdCfgs := make(map[string]discovery.Configs, len(amCfgs))
+	for name, amCfg := range amCfgs {
+		sdCfgs[name] = amCfg.ServiceDiscoveryConfigs
+	}
+	if err := m.discovery.ApplyConfig(sdCfgs); err != nil {
+		return fmt.Errorf("failed applying config to notifier discovery manager: %w", err)
+	}
+
+	prev := m.loader.SetGroups(cfg.Groups)
+	err = m.rules.Update(time.Duration(config.DefaultGlobalConfig.EvaluationInterval), []string{groupsIdentifier}, m.externalLabels)
+	if err != nil {
+		// The rule manager keeps running the previous groups.
+		m.loader.SetGroups(prev)
+		return fmt.Errorf("failed to update rule groups: %w", err)
+	}
+	return nil
+}
+
+// Run discovers Alertmanagers, sends alerts and truncates the Head until the
+// Manager is stopped.
+func (m *Manager) Run() {
+	m.mut.Lock()
+	if m.stopped {
+		m.mut.Unlock()
+		return
+	}
+	m.wg.Add(2)
+	m.mut.Unlock()
+
+	go func() {
+		defer m.wg.Done()
+		if err := m.discovery.Run(); err != nil {
+			level.Error(m.logger).Log("msg", "notifier discovery manager stopped with error", "err", err)
+		}
+	}()
+	go func() {
+		defer m.wg.Done()
+		m.notifier.Run(m.discovery.SyncCh())
+	}()
+
+	ticker := time.NewTicker(headTruncateFrequency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := m.head.Truncate(now); err != nil {
+				level.Error(m.logger).Log("msg", "failed to truncate rules head", "err", err)
+			}
+		}
+	}
+}
+
+// Stop stops all groups and waits for running evaluations to finish. The
+// Manager can't be used after it's stopped.
+func (m *Manager) Stop() {
+	m.mut.Lock()
+	if m.stopped {
+		m.mut.Unlock()
+		return
+	}
+	m.stopped = true
+	m.mut.Unlock()
+
+	// Groups are stopped first so they don't send alerts after the notifier
+	// stops.
+	m.rules.Stop()
+	m.notifier.Stop()
+	m.cancel()
+	m.wg.Wait()
+}
+
+// sendAlerts queues alerts to be sent to Alertmanagers. It follows how
+// Prometheus converts alerts from rules into notifications, except that
+// alerts have no generator URL since there's no UI to link to.
+func (m *Manager) sendAlerts(_ context.Context, _ string, alerts ...*promrules.Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	res := make([]*notifier.Alert, 0, len(alerts))
+	for _, a := range alerts {
+		sent := &notifier.Alert{
+			StartsAt:    a.FiredAt,
+			Labels:      a.Labels,
+			Annotations: a.Annotations,
+		}
+		if !a.ResolvedAt.IsZero() {
+			sent.EndsAt = a.ResolvedAt
+		} else {
+			sent.EndsAt = a.ValidUntil
+		}
+		res = append(res, sent)
+	}
+	m.notifier.Send(res...)
+}
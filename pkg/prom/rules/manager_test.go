@@ -0,0 +1,314 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestManager_RecordingRules(t *testing.T) {
+	head := newTestHead(t)
+	app := &collectingAppendable{}
+	m := NewManager(prometheus.NewRegistry(), log.NewNopLogger(), head, storage.NewFanout(log.NewNopLogger(), app, head), nil)
+	defer m.Stop()
+
+	appendSamples(t, head, time.Now(), map[string]float64{
+		`test_metric{job="a", instance="1"}`: 1,
+		`test_metric{job="a", instance="2"}`: 2,
+		`test_metric{job="b", instance="1"}`: 5,
+	})
+
+	err := m.ApplyConfig(Config{Groups: []GroupConfig{{
+		Name:     "test",
+		Interval: 50 * time.Millisecond,
+		Rules: []RuleConfig{
+			{
+				Record: "job:test_metric:sum",
+				Expr:   "sum by (job) (test_metric)",
+				Labels: map[string]string{"source": "rule"},
+			},
+			// Rules can query the results of rules before them.
+			{Record: "job:test_metric:double", Expr: "job:test_metric:sum * 2"},
+		},
+	}}})
+	require.NoError(t, err)
+
+	expect := map[string]float64{
+		`{__name__="job:test_metric:sum", job="a", source="rule"}`:    3,
+		`{__name__="job:test_metric:sum", job="b", source="rule"}`:    5,
+		`{__name__="job:test_metric:double", job="a", source="rule"}`: 6,
+		`{__name__="job:test_metric:double", job="b", source="rule"}`: 10,
+	}
+	require.Eventually(t, func() bool {
+		return reflect.DeepEqual(expect, app.Latest())
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestManager_AlertingRules(t *testing.T) {
+	head := newTestHead(t)
+	app := &collectingAppendable{}
+	m := NewManager(prometheus.NewRegistry(), log.NewNopLogger(), head, storage.NewFanout(log.NewNopLogger(), app, head), nil)
+	defer m.Stop()
+
+	err := m.ApplyConfig(Config{Groups: []GroupConfig{{
+		Name:     "test",
+		Interval: 50 * time.Millisecond,
+		Rules: []RuleConfig{{
+			Alert:  "AlwaysFiring",
+			Expr:   "vector(1)",
+			Labels: map[string]string{"severity": "page"},
+		}},
+	}}})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, ok := app.Latest()[`{__name__="ALERTS", alertname="AlwaysFiring", alertstate="firing", severity="page"}`]
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestManager_ApplyConfig_RemovedGroupStale(t *testing.T) {
+	head := newTestHead(t)
+	app := &collectingAppendable{}
+	m := NewManager(prometheus.NewRegistry(), log.NewNopLogger(), head, app, nil)
+	defer m.Stop()
+
+	cfg := GroupConfig{
+		Name:     "test",
+		Interval: 50 * time.Millisecond,
+		Rules:    []RuleConfig{{Record: "test:scalar", Expr: "vector(1)"}},
+	}
+	require.NoError(t, m.ApplyConfig(Config{Groups: []GroupConfig{cfg}}))
+	require.Eventually(t, func() bool {
+		return app.Latest()[`{__name__="test:scalar"}`] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Series of removed groups are marked as stale after two intervals.
+	require.NoError(t, m.ApplyConfig(Config{}))
+	require.Eventually(t, func() bool {
+		return value.IsStaleNaN(app.Latest()[`{__name__="test:scalar"}`])
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func newTestHead(t *testing.T) *Head {
+	t.Helper()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "rules_head")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	head, err := NewHead(prometheus.NewRegistry(), log.NewNopLogger(), dir, time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { head.Close() })
+	return head
+}
+
+// appendSamples appends a sample for each series to app at ts.
+func appendSamples(t *testing.T, app storage.Appendable, ts time.Time, samples map[string]float64) {
+	t.Helper()
+
+	a := app.Appender(context.Background())
+	for series, v := range samples {
+		lset, err := parser.ParseMetric(series)
+		require.NoError(t, err)
+		_, err = a.Append(0, lset, timestamp.FromTime(ts), v)
+		require.NoError(t, err)
+	}
+	require.NoError(t, a.Commit())
+}
+
+// collectingAppendable is a storage.Storage that keeps the latest committed
+// value of every series.
+type collectingAppendable struct {
+	mut    sync.Mutex
+	latest map[string]float64
+}
+
+func (c *collectingAppendable) Latest() map[string]float64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	res := make(map[string]float64, len(c.latest))
+	for k, v := range c.latest {
+		res[k] = v
+	}
+	return res
+}
+
+func (c *collectingAppendable) Appender(context.Context) storage.Appender {
+	return &collectingAppender{parent: c, pending: map[string]float64{}}
+}
+
+func (c *collectingAppendable) Querier(context.Context, int64, int64) (storage.Querier, error) {
+	return storage.NoopQuerier(), nil
+}
+
+func (c *collectingAppendable) ChunkQuerier(context.Context, int64, int64) (storage.ChunkQuerier, error) {
+	return storage.NoopChunkedQuerier(), nil
+}
+
+func (c *collectingAppendable) StartTime() (int64, error) { return 0, nil }
+func (c *collectingAppendable) Close() error              { return nil }
+
+type collectingAppender struct {
+	parent  *collectingAppendable
+	pending map[string]float64
+}
+
+func (a *collectingAppender) Append(_ uint64, l labels.Labels, _ int64, v float64) (uint64, error) {
+	a.pending[l.String()] = v
+	return 0, nil
+}
+
+func (a *collectingAppender) AppendExemplar(uint64, labels.Labels, exemplar.Exemplar) (uint64, error) {
+	return 0, nil
+}
+
+func (a *collectingAppender) Commit() error {
+	a.parent.mut.Lock()
+	defer a.parent.mut.Unlock()
+
+	if a.parent.latest == nil {
+		a.parent.latest = make(map[string]float64)
+	}
+	for k, v := range a.pending {
+		a.parent.latest[k] = v
+	}
+	return nil
+}
+
+func (a *collectingAppender) Rollback() error { return nil }
+
+func TestManager_ApplyConfig_KeepsAlertState(t *testing.T) {
+	head := newTestHead(t)
+	app := &collectingAppendable{}
+	m := NewManager(prometheus.NewRegistry(), log.NewNopLogger(), head, app, nil)
+	defer m.Stop()
+
+	// The for duration is below the grace period, so the state of the alert
+	// isn't restored from the head when the group first starts.
+	cfg := GroupConfig{
+		Name:     "test",
+		Interval: 50 * time.Millisecond,
+		Rules: []RuleConfig{
+			{Alert: "AlwaysPending", Expr: "vector(1)", For: 5 * time.Minute},
+		},
+	}
+	require.NoError(t, m.ApplyConfig(Config{Groups: []GroupConfig{cfg}}))
+
+	const forStateSeries = `{__name__="ALERTS_FOR_STATE", alertname="AlwaysPending"}`
+	require.Eventually(t, func() bool {
+		_, ok := app.Latest()[forStateSeries]
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	activeAt := app.Latest()[forStateSeries]
+
+	// Changing the group restarts it, but the pending alert must keep the time
+	// it became active.
+	cfg.Rules = append(cfg.Rules, RuleConfig{Record: "test:scalar", Expr: "vector(1)"})
+	require.NoError(t, m.ApplyConfig(Config{Groups: []GroupConfig{cfg}}))
+
+	require.Eventually(t, func() bool {
+		_, ok := app.Latest()[`{__name__="test:scalar"}`]
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, activeAt, app.Latest()[forStateSeries])
+}
+
+func TestManager_SendsAlerts(t *testing.T) {
+	var (
+		mut      sync.Mutex
+		received []map[string]interface{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prefix/api/v2/alerts" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var alerts []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mut.Lock()
+		defer mut.Unlock()
+		received = append(received, alerts...)
+	}))
+	defer srv.Close()
+
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	var cfg Config
+	err = yaml.Unmarshal([]byte(`
+groups:
+  - name: test
+    interval: 50ms
+    rules:
+      - alert: AlwaysFiring
+        expr: vector(1)
+        labels:
+          drop_me: "true"
+        annotations:
+          summary: "{{ $value }} in {{ $externalLabels.cluster }}"
+alerting:
+  alert_relabel_configs:
+    - regex: drop_me
+      action: labeldrop
+  alertmanagers:
+    - path_prefix: /prefix
+      static_configs:
+        - targets: ['`+srvURL.Host+`']
+`), &cfg)
+	require.NoError(t, err)
+
+	// Alerts are dropped until the Alertmanager is discovered, so they must be
+	// resent quickly.
+	prevResendDelay := resendDelay
+	resendDelay = 50 * time.Millisecond
+	t.Cleanup(func() { resendDelay = prevResendDelay })
+
+	head := newTestHead(t)
+	m := NewManager(prometheus.NewRegistry(), log.NewNopLogger(), head, head, labels.FromStrings("cluster", "test"))
+	defer m.Stop()
+	go m.Run()
+
+	require.NoError(t, m.ApplyConfig(cfg))
+
+	require.Eventually(t, func() bool {
+		mut.Lock()
+		defer mut.Unlock()
+		return len(received) > 0
+	}, 15*time.Second, 10*time.Millisecond)
+
+	mut.Lock()
+	defer mut.Unlock()
+	require.Equal(t, map[string]interface{}{
+		"alertname": "AlwaysFiring",
+		"cluster":   "test",
+	}, received[0]["labels"])
+	require.Equal(t, map[string]interface{}{
+		"summary": "1 in test",
+	}, received[0]["annotations"])
+}
@@ -0,0 +1,128 @@
+package prom
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/prom/instance"
+)
+
+// runtimeConfigFilename returns the path in dir where the runtime config
+// with the given name is persisted. Names are escaped so they can't refer to
+// files outside of dir.
+func runtimeConfigFilename(dir, name string) string {
+	return filepath.Join(dir, url.PathEscape(name)+".yml")
+}
+
+// saveRuntimeConfig writes c to dir so it can be reloaded when the Agent
+// restarts.
+func saveRuntimeConfig(dir string, c *instance.Config) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create runtime configs directory: %w", err)
+	}
+
+	bb, err := instance.MarshalConfig(c, false)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	// Write to a temporary file first so a failed write doesn't leave behind a
+	// partial config that would fail to load on the next start.
+	path := runtimeConfigFilename(dir, c.Name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bb, 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// deleteRuntimeConfig removes the persisted config with the given name from
+// dir. It is not an error for the config to not exist.
+func deleteRuntimeConfig(dir, name string) error {
+	err := os.Remove(runtimeConfigFilename(dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// loadRuntimeConfigs reads all configs persisted in dir. Files that can't be
+// read or parsed are logged and skipped so they don't prevent the remaining
+// configs from loading.
+func loadRuntimeConfigs(l log.Logger, dir string) ([]instance.Config, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var configs []instance.Config
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), ".yml") {
+			continue
+		}
+
+		path := filepath.Join(dir, info.Name())
+		cfg, err := loadRuntimeConfig(path)
+		if err != nil {
+			level.Error(l).Log("msg", "skipping runtime config that failed to load", "path", path, "err", err)
+			continue
+		}
+		configs = append(configs, *cfg)
+	}
+	return configs, nil
+}
+
+func loadRuntimeConfig(path string) (*instance.Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return instance.UnmarshalConfig(f)
+}
+
+// syncRuntimeConfigs applies all configs persisted in dir. Configs with the
+// same name as a config from the config file are ignored.
+func (a *Agent) syncRuntimeConfigs(dir string, fileConfigs []instance.Config) {
+	configs, err := loadRuntimeConfigs(a.logger, dir)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to load runtime configs", "dir", dir, "err", err)
+		return
+	}
+
+	for _, c := range configs {
+		if hasConfig(fileConfigs, c.Name) {
+			level.Warn(a.logger).Log("msg", "ignoring runtime config with the same name as a config from the config file", "name", c.Name)
+			continue
+		}
+		if err := a.Validate(&c); err != nil {
+			level.Error(a.logger).Log("msg", "failed to validate runtime config", "name", c.Name, "err", err)
+			continue
+		}
+		if err := a.mm.ApplyConfig(c); err != nil {
+			level.Error(a.logger).Log("msg", "failed to apply runtime config", "name", c.Name, "err", err)
+			continue
+		}
+
+		a.runtimeMut.Lock()
+		a.runtimeConfigs[c.Name] = struct{}{}
+		a.runtimeMut.Unlock()
+	}
+}
+
+func hasConfig(configs []instance.Config, name string) bool {
+	for _, c := range configs {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
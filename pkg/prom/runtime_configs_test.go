@@ -0,0 +1,26 @@
+package prom
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRuntimeConfigs_SkipsInvalidFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "runtime-configs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "good.yml"), []byte("name: good\nhost_filter: true\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.yml"), []byte("name: [bad\n"), 0600))
+
+	configs, err := loadRuntimeConfigs(log.NewNopLogger(), dir)
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	require.Equal(t, "good", configs[0].Name)
+	require.True(t, configs[0].HostFilter)
+}
@@ -0,0 +1,223 @@
+package prom
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Keys used to define and extend instance templates.
+const (
+	instanceTemplatesKey = "instance_templates"
+	configsKey           = "configs"
+	extendsKey           = "extends"
+	nameKey              = "name"
+)
+
+// hasInstanceTemplates reports whether doc defines instance templates or has
+// instance configs that extend one.
+func hasInstanceTemplates(doc yaml.MapSlice) bool {
+	if _, ok := lookupKey(doc, instanceTemplatesKey); ok {
+		return true
+	}
+	configs, _ := lookupKey(doc, configsKey)
+	list, _ := configs.([]interface{})
+	for _, cfg := range list {
+		m, _ := cfg.(yaml.MapSlice)
+		if _, ok := lookupKey(m, extendsKey); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// expandInstanceTemplates returns a copy of doc, the YAML of a Config, where
+// every instance config that extends a template has been merged with that
+// template and instance_templates has been removed.
+//
+// Maps are merged recursively, with settings of the instance config taking
+// precedence. Any other value, including lists such as remote_write, is
+// replaced as a whole.
+func expandInstanceTemplates(doc yaml.MapSlice) (yaml.MapSlice, error) {
+	templates := make(map[string]yaml.MapSlice)
+
+	rawTemplates, _ := lookupKey(doc, instanceTemplatesKey)
+	if rawTemplates != nil {
+		list, ok := rawTemplates.([]interface{})
+		if !ok {
+			return nil, errors.New("instance_templates must be a list")
+		}
+		for i, t := range list {
+			m, ok := t.(yaml.MapSlice)
+			if !ok {
+				return nil, fmt.Errorf("instance template at index %d must be a map", i)
+			}
+			name, _ := lookupKey(m, nameKey)
+			nameStr, _ := name.(string)
+			if nameStr == "" {
+				return nil, fmt.Errorf("instance template at index %d is missing a name", i)
+			}
+			if _, exist := templates[nameStr]; exist {
+				return nil, fmt.Errorf("found multiple instance templates with name %s", nameStr)
+			}
+			templates[nameStr] = m
+		}
+	}
+
+	r := templateResolver{
+		templates: templates,
+		resolved:  make(map[string]yaml.MapSlice, len(templates)),
+		resolving: make(map[string]bool),
+	}
+
+	// Resolve every template, even unused ones, so mistakes in them are
+	// reported.
+	for name := range templates {
+		if _, err := r.resolve(name); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(yaml.MapSlice, 0, len(doc))
+	for _, item := range doc {
+		switch item.Key {
+		case instanceTemplatesKey:
+			continue
+		case configsKey:
+			configs, err := r.expandConfigs(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			item.Value = configs
+		}
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+type templateResolver struct {
+	templates map[string]yaml.MapSlice
+	resolved  map[string]yaml.MapSlice
+	resolving map[string]bool
+}
+
+// resolve returns the template with the given name merged with the
+// templates it extends. The name of the template is removed.
+func (r *templateResolver) resolve(name string) (yaml.MapSlice, error) {
+	if res, ok := r.resolved[name]; ok {
+		return res, nil
+	}
+	tmpl, ok := r.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("instance template %s does not exist", name)
+	}
+	if r.resolving[name] {
+		return nil, fmt.Errorf("instance template %s extends itself", name)
+	}
+	r.resolving[name] = true
+	defer delete(r.resolving, name)
+
+	res, err := r.extend(withoutKey(tmpl, nameKey))
+	if err != nil {
+		return nil, fmt.Errorf("instance template %s: %w", name, err)
+	}
+	r.resolved[name] = res
+	return res, nil
+}
+
+// extend merges m with the template it extends, if any. The extends key is
+// removed.
+func (r *templateResolver) extend(m yaml.MapSlice) (yaml.MapSlice, error) {
+	base, ok := lookupKey(m, extendsKey)
+	if !ok {
+		return m, nil
+	}
+	baseName, ok := base.(string)
+	if !ok || baseName == "" {
+		return nil, errors.New("extends must be the name of an instance template")
+	}
+
+	tmpl, err := r.resolve(baseName)
+	if err != nil {
+		return nil, err
+	}
+	return mergeMaps(tmpl, withoutKey(m, extendsKey)), nil
+}
+
+func (r *templateResolver) expandConfigs(v interface{}) (interface{}, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		// Let the regular unmarshaling report the error.
+		return v, nil
+	}
+
+	out := make([]interface{}, 0, len(list))
+	for i, cfg := range list {
+		m, ok := cfg.(yaml.MapSlice)
+		if !ok {
+			out = append(out, cfg)
+			continue
+		}
+
+		expanded, err := r.extend(m)
+		if err != nil {
+			name, _ := lookupKey(m, nameKey)
+			if nameStr, _ := name.(string); nameStr != "" {
+				return nil, fmt.Errorf("instance %s: %w", nameStr, err)
+			}
+			return nil, fmt.Errorf("instance at index %d: %w", i, err)
+		}
+		out = append(out, expanded)
+	}
+	return out, nil
+}
+
+// mergeMaps returns a copy of base with the keys of override set. Maps present
+// in both are merged recursively.
+func mergeMaps(base, override yaml.MapSlice) yaml.MapSlice {
+	out := make(yaml.MapSlice, 0, len(base)+len(override))
+	out = append(out, base...)
+
+	for _, item := range override {
+		idx := -1
+		for i := range out {
+			if out[i].Key == item.Key {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			out = append(out, item)
+			continue
+		}
+
+		baseMap, baseIsMap := out[idx].Value.(yaml.MapSlice)
+		overrideMap, overrideIsMap := item.Value.(yaml.MapSlice)
+		if baseIsMap && overrideIsMap {
+			out[idx] = yaml.MapItem{Key: item.Key, Value: mergeMaps(baseMap, overrideMap)}
+		} else {
+			out[idx] = item
+		}
+	}
+	return out
+}
+
+func lookupKey(m yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+func withoutKey(m yaml.MapSlice, key string) yaml.MapSlice {
+	out := make(yaml.MapSlice, 0, len(m))
+	for _, item := range m {
+		if item.Key != key {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package prom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_InstanceTemplates(t *testing.T) {
+	cfgText := `
+wal_directory: /tmp/wal
+instance_templates:
+  - name: base
+    host_filter: true
+    wal_truncate_frequency: 30m
+    remote_write:
+      - url: http://cortex:9009/api/prom/push
+  - name: fast
+    extends: base
+    wal_truncate_frequency: 1m
+configs:
+  - name: a
+    extends: base
+    scrape_configs:
+      - job_name: a
+        static_configs:
+          - targets: ['a:9100']
+  - name: b
+    extends: fast
+    host_filter: false
+    remote_write:
+      - url: http://other:9009/api/prom/push
+  - name: c
+`
+
+	var cfg Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(cfgText), &cfg))
+	require.NoError(t, cfg.ApplyDefaults())
+	require.Len(t, cfg.Configs, 3)
+
+	a := cfg.Configs[0]
+	require.Equal(t, "a", a.Name)
+	require.True(t, a.HostFilter)
+	require.Equal(t, 30*time.Minute, a.WALTruncateFrequency)
+	require.Len(t, a.RemoteWrite, 1)
+	require.Equal(t, "http://cortex:9009/api/prom/push", a.RemoteWrite[0].URL.String())
+	require.Len(t, a.ScrapeConfigs, 1)
+
+	// Settings of the instance take precedence and lists are replaced.
+	b := cfg.Configs[1]
+	require.Equal(t, "b", b.Name)
+	require.False(t, b.HostFilter)
+	require.Equal(t, time.Minute, b.WALTruncateFrequency)
+	require.Len(t, b.RemoteWrite, 1)
+	require.Equal(t, "http://other:9009/api/prom/push", b.RemoteWrite[0].URL.String())
+
+	c := cfg.Configs[2]
+	require.False(t, c.HostFilter)
+	require.Empty(t, c.RemoteWrite)
+}
+
+func TestConfig_InstanceTemplatesInvalid(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name: "unknown template",
+			cfg: `
+configs:
+  - name: a
+    extends: missing`,
+			expect: "instance a: instance template missing does not exist",
+		},
+		{
+			name: "cycle",
+			cfg: `
+instance_templates:
+  - name: one
+    extends: two
+  - name: two
+    extends: one
+configs:
+  - name: a`,
+			expect: "extends itself",
+		},
+		{
+			name: "missing name",
+			cfg: `
+instance_templates:
+  - host_filter: true`,
+			expect: "instance template at index 0 is missing a name",
+		},
+		{
+			name: "unknown field in template",
+			cfg: `
+instance_templates:
+  - name: base
+    not_a_field: true
+configs:
+  - name: a
+    extends: base`,
+			expect: "not_a_field",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &cfg)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expect)
+		})
+	}
+}
+
+func TestMergeMaps(t *testing.T) {
+	var base, override yaml.MapSlice
+	require.NoError(t, yaml.Unmarshal([]byte(`
+a: 1
+nested:
+  first: 1
+  second: 1
+list: [1, 2]
+`), &base))
+	require.NoError(t, yaml.Unmarshal([]byte(`
+nested:
+  second: 2
+list: [3]
+b: 2
+`), &override))
+
+	out, err := yaml.Marshal(mergeMaps(base, override))
+	require.NoError(t, err)
+	require.YAMLEq(t, `
+a: 1
+nested:
+  first: 1
+  second: 2
+list: [3]
+b: 2
+`, string(out))
+}
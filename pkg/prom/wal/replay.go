@@ -0,0 +1,443 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// replaySegment is a single segment from either the last checkpoint or the
+// WAL that needs to be replayed.
+type replaySegment struct {
+	dir        string
+	index      int
+	size       int64
+	checkpoint bool
+}
+
+// segmentData is the decoded contents of a replaySegment, split into
+// partitions so it can be applied to the storage in parallel. Series are
+// partitioned by the hash of their labels and timestamps by series ref.
+type segmentData struct {
+	series [][]record.RefSeries
+	// lastTs holds the timestamp of the newest sample found for each series
+	// ref in the segment. Only the newest timestamp of a series is tracked by
+	// the storage, so there's no need to hold on to every sample.
+	lastTs []map[uint64]int64
+	err    error
+}
+
+// replayWAL loads the last checkpoint and all WAL segments after it.
+//
+// Segments never share records, so they're decoded concurrently. Decoded
+// segments are applied to the storage in order, since a series must be
+// created before samples referencing it in later segments can be applied.
+func (w *Storage) replayWAL() error {
+	w.walMtx.RLock()
+	defer w.walMtx.RUnlock()
+
+	if w.walClosed {
+		return ErrWALClosed
+	}
+
+	start := time.Now()
+	defer func() {
+		w.metrics.replayDuration.Set(time.Since(start).Seconds())
+	}()
+
+	level.Info(w.logger).Log("msg", "replaying WAL, this may take a while", "dir", w.wal.Dir())
+	segs, err := w.listReplaySegments()
+	if err != nil {
+		return err
+	}
+
+	if err := w.replaySegments(segs); err != nil {
+		return err
+	}
+	level.Info(w.logger).Log("msg", "WAL replay completed", "duration", time.Since(start))
+	return nil
+}
+
+// listReplaySegments returns the segments of the last checkpoint followed by
+// the WAL segments after the checkpoint.
+func (w *Storage) listReplaySegments() ([]replaySegment, error) {
+	var segs []replaySegment
+
+	dir, startFrom, err := wal.LastCheckpoint(w.wal.Dir())
+	if err != nil && err != record.ErrNotFound {
+		return nil, errors.Wrap(err, "find last checkpoint")
+	}
+
+	if err == nil {
+		first, last, err := wal.Segments(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "open checkpoint")
+		}
+		checkpointSegs, err := segmentRange(dir, first, last, true)
+		if err != nil {
+			return nil, errors.Wrap(err, "open checkpoint")
+		}
+		segs = append(segs, checkpointSegs...)
+		startFrom++
+	}
+
+	// Find the last segment.
+	_, last, err := wal.Segments(w.wal.Dir())
+	if err != nil {
+		return nil, errors.Wrap(err, "finding WAL segments")
+	}
+
+	walSegs, err := segmentRange(w.wal.Dir(), startFrom, last, false)
+	if err != nil {
+		return nil, err
+	}
+	return append(segs, walSegs...), nil
+}
+
+func segmentRange(dir string, first, last int, checkpoint bool) ([]replaySegment, error) {
+	var segs []replaySegment
+	for i := first; i >= 0 && i <= last; i++ {
+		fi, err := os.Stat(wal.SegmentName(dir, i))
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("open WAL segment: %d", i))
+		}
+		segs = append(segs, replaySegment{
+			dir:        dir,
+			index:      i,
+			size:       fi.Size(),
+			checkpoint: checkpoint,
+		})
+	}
+	return segs, nil
+}
+
+// replaySegments decodes segs concurrently and applies them to the storage in
+// order. The first error found stops the replay; segments before the one that
+// failed will have been applied.
+func (w *Storage) replaySegments(segs []replaySegment) error {
+	if len(segs) == 0 {
+		return nil
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(segs) {
+		workers = len(segs)
+	}
+
+	var (
+		limiter = newReplayLimiter(w.replayMemoryLimit, workers)
+		jobs    = make(chan int)
+		done    = make(chan struct{})
+		wg      sync.WaitGroup
+
+		// results holds one buffered channel per segment so workers never block
+		// waiting for earlier segments to be applied.
+		results = make([]chan segmentData, len(segs))
+	)
+	for i := range results {
+		results[i] = make(chan segmentData, 1)
+	}
+
+	// Stop the dispatcher and workers and wait for them to exit so the caller
+	// can safely repair the WAL if replaying fails.
+	defer func() {
+		close(done)
+		limiter.Close()
+		wg.Wait()
+	}()
+
+	// Segments are dispatched in order and must be admitted by the limiter
+	// first. The oldest segment that hasn't been applied yet always holds a
+	// reservation, so the replay can't deadlock on the limiter.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+
+		for i, seg := range segs {
+			if !limiter.Acquire(limiter.Weight(seg)) {
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				select {
+				case <-done:
+					continue
+				default:
+				}
+				results[i] <- w.decodeSegment(segs[i], workers)
+			}
+		}()
+	}
+
+	for i, seg := range segs {
+		data := <-results[i]
+		if data.err != nil {
+			if seg.checkpoint {
+				// A corrupted checkpoint is a hard error for now and requires user
+				// intervention. There's likely little data that can be recovered anyway.
+				return errors.Wrap(data.err, "backfill checkpoint")
+			}
+			return data.err
+		}
+
+		w.applySegment(data)
+		limiter.Release(limiter.Weight(seg))
+
+		switch {
+		case !seg.checkpoint:
+			level.Info(w.logger).Log("msg", "WAL segment loaded", "segment", seg.index, "maxSegment", segs[len(segs)-1].index)
+		case i == len(segs)-1 || !segs[i+1].checkpoint:
+			level.Info(w.logger).Log("msg", "WAL checkpoint loaded")
+		}
+	}
+
+	return nil
+}
+
+// decodeSegment reads all records from seg, splitting them into the given
+// number of partitions.
+func (w *Storage) decodeSegment(seg replaySegment, partitions int) segmentData {
+	s, err := wal.OpenReadSegment(wal.SegmentName(seg.dir, seg.index))
+	if err != nil {
+		return segmentData{err: errors.Wrap(err, fmt.Sprintf("open WAL segment: %d", seg.index))}
+	}
+
+	sr := wal.NewSegmentBufReader(s)
+	defer func() {
+		if err := sr.Close(); err != nil {
+			level.Warn(w.logger).Log("msg", "error while closing the wal segments reader", "err", err)
+		}
+	}()
+
+	data := segmentData{
+		series: make([][]record.RefSeries, partitions),
+		lastTs: make([]map[uint64]int64, partitions),
+	}
+	for i := range data.lastTs {
+		data.lastTs[i] = make(map[uint64]int64)
+	}
+
+	var (
+		dec     record.Decoder
+		series  []record.RefSeries
+		samples []record.RefSample
+
+		r = wal.NewReader(sr)
+	)
+
+	for r.Next() {
+		rec := r.Record()
+		switch dec.Type(rec) {
+		case record.Series:
+			series, err = dec.Series(rec, series[:0])
+			if err != nil {
+				data.err = &wal.CorruptionErr{
+					Err:     errors.Wrap(err, "decode series"),
+					Segment: r.Segment(),
+					Offset:  r.Offset(),
+				}
+				return data
+			}
+			for _, s := range series {
+				p := s.Labels.Hash() % uint64(partitions)
+				data.series[p] = append(data.series[p], s)
+			}
+		case record.Samples:
+			samples, err = dec.Samples(rec, samples[:0])
+			if err != nil {
+				data.err = &wal.CorruptionErr{
+					Err:     errors.Wrap(err, "decode samples"),
+					Segment: r.Segment(),
+					Offset:  r.Offset(),
+				}
+				return data
+			}
+			for _, s := range samples {
+				lastTs := data.lastTs[s.Ref%uint64(partitions)]
+				if ts, ok := lastTs[s.Ref]; !ok || s.T > ts {
+					lastTs[s.Ref] = s.T
+				}
+			}
+		case record.Tombstones:
+			// We don't care about tombstones
+			continue
+		default:
+			data.err = &wal.CorruptionErr{
+				Err:     errors.Errorf("invalid record type %v", dec.Type(rec)),
+				Segment: r.Segment(),
+				Offset:  r.Offset(),
+			}
+			return data
+		}
+	}
+
+	if r.Err() != nil {
+		data.err = errors.Wrap(r.Err(), "read records")
+	}
+	return data
+}
+
+// applySegment creates the series from data and then updates the timestamps
+// of series, with one goroutine per partition.
+func (w *Storage) applySegment(data segmentData) {
+	var (
+		wg      sync.WaitGroup
+		mtx     sync.Mutex
+		nextRef uint64
+		missing int64
+	)
+
+	for _, series := range data.series {
+		wg.Add(1)
+		go func(series []record.RefSeries) {
+			defer wg.Done()
+
+			var maxRef uint64
+			for _, s := range series {
+				// If this is a new series, create it in memory without a timestamp.
+				// If we read in a sample for it, we'll use the timestamp of the latest
+				// sample. Otherwise, the series is stale and will be deleted once
+				// the truncation is performed.
+				if w.series.getByID(s.Ref) != nil {
+					continue
+				}
+				w.series.set(s.Labels.Hash(), &memSeries{ref: s.Ref, lset: s.Labels, lastTs: 0})
+
+				w.metrics.numActiveSeries.Inc()
+				w.metrics.totalCreatedSeries.Inc()
+
+				if s.Ref > maxRef {
+					maxRef = s.Ref
+				}
+			}
+
+			mtx.Lock()
+			if maxRef+1 > nextRef {
+				nextRef = maxRef + 1
+			}
+			mtx.Unlock()
+		}(series)
+	}
+	wg.Wait()
+
+	w.mtx.Lock()
+	if w.nextRef < nextRef {
+		w.nextRef = nextRef
+	}
+	w.mtx.Unlock()
+
+	// Series from this segment and all segments before it have been created,
+	// so all samples in this segment now have a series to update.
+	for _, lastTs := range data.lastTs {
+		wg.Add(1)
+		go func(lastTs map[uint64]int64) {
+			defer wg.Done()
+
+			for ref, ts := range lastTs {
+				series := w.series.getByID(ref)
+				if series == nil {
+					atomic.AddInt64(&missing, 1)
+					continue
+				}
+
+				series.Lock()
+				if ts > series.lastTs {
+					series.lastTs = ts
+				}
+				series.Unlock()
+			}
+		}(lastTs)
+	}
+	wg.Wait()
+
+	if missing > 0 {
+		level.Warn(w.logger).Log("msg", "found samples referencing non-existing series, skipping", "series", missing)
+	}
+}
+
+// replayLimiter limits the segments that are being decoded or waiting to be
+// applied. With a memory limit, segments are weighted by their size on disk.
+// Otherwise, each worker may have up to two segments admitted.
+type replayLimiter struct {
+	mtx    sync.Mutex
+	cond   *sync.Cond
+	bySize bool
+	limit  int64
+	used   int64
+	closed bool
+}
+
+func newReplayLimiter(memoryLimit int64, workers int) *replayLimiter {
+	l := &replayLimiter{bySize: memoryLimit > 0, limit: memoryLimit}
+	if !l.bySize {
+		l.limit = int64(2 * workers)
+	}
+	l.cond = sync.NewCond(&l.mtx)
+	return l
+}
+
+// Weight returns how much of the limit seg uses. A segment larger than the
+// limit uses the entire limit so it can still be replayed on its own.
+func (l *replayLimiter) Weight(seg replaySegment) int64 {
+	if !l.bySize {
+		return 1
+	}
+	if seg.size > l.limit {
+		return l.limit
+	}
+	return seg.size
+}
+
+// Acquire blocks until n is available. Returns false if the limiter was
+// closed while waiting.
+func (l *replayLimiter) Acquire(n int64) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for !l.closed && l.used+n > l.limit {
+		l.cond.Wait()
+	}
+	if l.closed {
+		return false
+	}
+	l.used += n
+	return true
+}
+
+// Release returns n to the limiter.
+func (l *replayLimiter) Release(n int64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.used -= n
+	l.cond.Broadcast()
+}
+
+// Close wakes up and fails all pending and future calls to Acquire.
+func (l *replayLimiter) Close() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.closed = true
+	l.cond.Broadcast()
+}
@@ -33,7 +33,11 @@ type memSeries struct {
 }
 
 func (s *memSeries) updateTs(ts int64) {
-	s.lastTs = ts
+	// Out-of-order samples must not make the series look older than it is,
+	// or it could be garbage collected while it's still active.
+	if ts > s.lastTs {
+		s.lastTs = ts
+	}
 	s.willDelete = false
 	s.pendingCommit = true
 }
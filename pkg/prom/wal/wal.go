@@ -6,9 +6,11 @@ import (
 	"math"
 	"sync"
 	"time"
+	"unsafe"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/golang/snappy"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/pkg/exemplar"
@@ -33,6 +35,15 @@ type storageMetrics struct {
 	totalCreatedSeries   prometheus.Counter
 	totalRemovedSeries   prometheus.Counter
 	totalAppendedSamples prometheus.Counter
+	sizeBytes            prometheus.Gauge
+	totalTruncations     prometheus.Counter
+	droppedSegments      prometheus.Counter
+	replayDuration       prometheus.Gauge
+	recordBytes          prometheus.Counter
+	recordStoredBytes    prometheus.Counter
+	outOfOrderSamples    prometheus.Counter
+	rejectedSamples      prometheus.Counter
+	droppedStaleMarkers  prometheus.Counter
 }
 
 func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
@@ -62,6 +73,51 @@ func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
 		Help: "Total number of samples appended to the WAL",
 	})
 
+	m.sizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_wal_storage_size_bytes",
+		Help: "Size of the WAL on disk, including checkpoints, as of the last size check",
+	})
+
+	m.totalTruncations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_storage_truncations_total",
+		Help: "Total number of WAL truncations performed",
+	})
+
+	m.droppedSegments = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_storage_dropped_segments_total",
+		Help: "Total number of WAL segments dropped to keep the WAL under hard_max_wal_size_bytes",
+	})
+
+	m.replayDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_wal_replay_duration_seconds",
+		Help: "Time taken to replay the WAL when the storage was created",
+	})
+
+	m.recordBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_records_bytes_total",
+		Help: "Total size of records written to the WAL before compression",
+	})
+
+	m.recordStoredBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_records_compressed_bytes_total",
+		Help: "Total size of records written to the WAL after compression. Equal to agent_wal_records_bytes_total when compression is disabled",
+	})
+
+	m.outOfOrderSamples = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_out_of_order_samples_total",
+		Help: "Total number of samples appended to the WAL with a timestamp older than the newest sample of their series",
+	})
+
+	m.rejectedSamples = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_out_of_order_samples_rejected_total",
+		Help: "Total number of out-of-order samples rejected for being older than the out-of-order tolerance",
+	})
+
+	m.droppedStaleMarkers = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_stale_markers_dropped_total",
+		Help: "Total number of staleness markers dropped instead of being appended to the WAL",
+	})
+
 	if r != nil {
 		r.MustRegister(
 			m.numActiveSeries,
@@ -69,6 +125,15 @@ func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
 			m.totalCreatedSeries,
 			m.totalRemovedSeries,
 			m.totalAppendedSamples,
+			m.sizeBytes,
+			m.totalTruncations,
+			m.droppedSegments,
+			m.replayDuration,
+			m.recordBytes,
+			m.recordStoredBytes,
+			m.outOfOrderSamples,
+			m.rejectedSamples,
+			m.droppedStaleMarkers,
 		)
 	}
 
@@ -84,6 +149,15 @@ func (m *storageMetrics) Unregister() {
 		m.numDeletedSeries,
 		m.totalCreatedSeries,
 		m.totalRemovedSeries,
+		m.sizeBytes,
+		m.totalTruncations,
+		m.droppedSegments,
+		m.replayDuration,
+		m.recordBytes,
+		m.recordStoredBytes,
+		m.outOfOrderSamples,
+		m.rejectedSamples,
+		m.droppedStaleMarkers,
 	}
 	for _, c := range cs {
 		m.r.Unregister(c)
@@ -107,6 +181,8 @@ type Storage struct {
 	wal    *wal.WAL
 	logger log.Logger
 
+	replayMemoryLimit int64
+
 	appenderPool sync.Pool
 	bufPool      sync.Pool
 
@@ -118,11 +194,33 @@ type Storage struct {
 	deleted    map[uint64]int // Deleted series, and what WAL segment they must be kept until.
 
 	metrics *storageMetrics
+
+	appendOptsMtx sync.RWMutex
+	appendOpts    AppendOptions
+}
+
+// AppendOptions changes how samples appended to a Storage are handled.
+type AppendOptions struct {
+	// Samples older than the newest sample of their series by more than
+	// OutOfOrderTolerance are rejected with storage.ErrOutOfOrderSample. 0
+	// accepts out-of-order samples of any age.
+	OutOfOrderTolerance time.Duration
+
+	// DropStaleMarkers drops staleness markers appended when targets or
+	// series disappear. Markers written by WriteStalenessMarkers are kept.
+	DropStaleMarkers bool
 }
 
-// NewStorage makes a new Storage.
-func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string) (*Storage, error) {
-	w, err := wal.NewSize(logger, registerer, SubDirectory(path), wal.DefaultSegmentSize, true)
+// NewStorage makes a new Storage. Existing data in the WAL is replayed
+// concurrently, with the segments being replayed at any given time holding at
+// most replayMemoryLimit bytes. A replayMemoryLimit of 0 only limits replay by
+// the number of available CPUs.
+//
+// When compress is true, records written to the WAL and its checkpoints are
+// compressed with snappy. Existing segments can be read regardless of
+// compress, so it can be changed between restarts.
+func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string, replayMemoryLimit int64, compress bool) (*Storage, error) {
+	w, err := wal.NewSize(logger, registerer, SubDirectory(path), wal.DefaultSegmentSize, compress)
 	if err != nil {
 		return nil, err
 	}
@@ -135,6 +233,8 @@ func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string
 		series:  newStripeSeries(),
 		metrics: newStorageMetrics(registerer),
 
+		replayMemoryLimit: replayMemoryLimit,
+
 		// The first ref ID must be non-zero, as the scraping code treats 0 as a
 		// non-existent ID and won't cache it.
 		nextRef: 1,
@@ -163,204 +263,111 @@ func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string
 	return storage, nil
 }
 
-func (w *Storage) replayWAL() error {
-	w.walMtx.RLock()
-	defer w.walMtx.RUnlock()
-
-	if w.walClosed {
-		return ErrWALClosed
-	}
-
-	level.Info(w.logger).Log("msg", "replaying WAL, this may take a while", "dir", w.wal.Dir())
-	dir, startFrom, err := wal.LastCheckpoint(w.wal.Dir())
-	if err != nil && err != record.ErrNotFound {
-		return errors.Wrap(err, "find last checkpoint")
-	}
-
-	if err == nil {
-		sr, err := wal.NewSegmentsReader(dir)
-		if err != nil {
-			return errors.Wrap(err, "open checkpoint")
-		}
-		defer func() {
-			if err := sr.Close(); err != nil {
-				level.Warn(w.logger).Log("msg", "error while closing the wal segments reader", "err", err)
-			}
-		}()
-
-		// A corrupted checkpoint is a hard error for now and requires user
-		// intervention. There's likely little data that can be recovered anyway.
-		if err := w.loadWAL(wal.NewReader(sr)); err != nil {
-			return errors.Wrap(err, "backfill checkpoint")
-		}
-		startFrom++
-		level.Info(w.logger).Log("msg", "WAL checkpoint loaded")
-	}
+// Directory returns the path where the WAL storage is held.
+func (w *Storage) Directory() string {
+	return w.path
+}
 
-	// Find the last segment.
-	_, last, err := wal.Segments(w.wal.Dir())
-	if err != nil {
-		return errors.Wrap(err, "finding WAL segments")
+// log writes rec to the WAL and tracks its size before and after
+// compression. The caller must hold walMtx.
+func (w *Storage) log(rec []byte) error {
+	if err := w.wal.Log(rec); err != nil {
+		return err
 	}
 
-	// Backfill segments from the most recent checkpoint onwards.
-	for i := startFrom; i <= last; i++ {
-		s, err := wal.OpenReadSegment(wal.SegmentName(w.wal.Dir(), i))
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("open WAL segment: %d", i))
-		}
-
-		sr := wal.NewSegmentBufReader(s)
-		err = w.loadWAL(wal.NewReader(sr))
-		if err := sr.Close(); err != nil {
-			level.Warn(w.logger).Log("msg", "error while closing the wal segments reader", "err", err)
+	stored := len(rec)
+	if w.wal.CompressionEnabled() {
+		// The WAL doesn't expose the size of the records it writes, so encode
+		// the record again to find it. Like the WAL, records that don't get
+		// smaller are stored uncompressed.
+		buf := w.bufPool.Get().([]byte)
+		buf = snappy.Encode(buf[:cap(buf)], rec)
+		if len(buf) < stored {
+			stored = len(buf)
 		}
-		if err != nil {
-			return err
-		}
-		level.Info(w.logger).Log("msg", "WAL segment loaded", "segment", i, "maxSegment", last)
+		//nolint:staticcheck
+		w.bufPool.Put(buf[:0])
 	}
 
+	w.metrics.recordBytes.Add(float64(len(rec)))
+	w.metrics.recordStoredBytes.Add(float64(stored))
 	return nil
 }
 
-func (w *Storage) loadWAL(r *wal.Reader) (err error) {
-	var (
-		dec record.Decoder
-	)
-
-	var (
-		decoded    = make(chan interface{}, 10)
-		errCh      = make(chan error, 1)
-		seriesPool = sync.Pool{
-			New: func() interface{} {
-				return []record.RefSeries{}
-			},
-		}
-		samplesPool = sync.Pool{
-			New: func() interface{} {
-				return []record.RefSample{}
-			},
-		}
-	)
-
-	go func() {
-		defer close(decoded)
-		for r.Next() {
-			rec := r.Record()
-			switch dec.Type(rec) {
-			case record.Series:
-				series := seriesPool.Get().([]record.RefSeries)[:0]
-				series, err = dec.Series(rec, series)
-				if err != nil {
-					errCh <- &wal.CorruptionErr{
-						Err:     errors.Wrap(err, "decode series"),
-						Segment: r.Segment(),
-						Offset:  r.Offset(),
-					}
-					return
-				}
-				decoded <- series
-			case record.Samples:
-				samples := samplesPool.Get().([]record.RefSample)[:0]
-				samples, err = dec.Samples(rec, samples)
-				if err != nil {
-					errCh <- &wal.CorruptionErr{
-						Err:     errors.Wrap(err, "decode samples"),
-						Segment: r.Segment(),
-						Offset:  r.Offset(),
-					}
-				}
-				decoded <- samples
-			case record.Tombstones:
-				// We don't care about tombstones
-				continue
-			default:
-				errCh <- &wal.CorruptionErr{
-					Err:     errors.Errorf("invalid record type %v", dec.Type(rec)),
-					Segment: r.Segment(),
-					Offset:  r.Offset(),
-				}
-				return
-			}
-		}
-	}()
-
-	for d := range decoded {
-		switch v := d.(type) {
-		case []record.RefSeries:
-			for _, s := range v {
-				// If this is a new series, create it in memory without a timestamp.
-				// If we read in a sample for it, we'll use the timestamp of the latest
-				// sample. Otherwise, the series is stale and will be deleted once
-				// the truncation is performed.
-				if w.series.getByID(s.Ref) == nil {
-					series := &memSeries{ref: s.Ref, lset: s.Labels, lastTs: 0}
-					w.series.set(s.Labels.Hash(), series)
-
-					w.metrics.numActiveSeries.Inc()
-					w.metrics.totalCreatedSeries.Inc()
-
-					w.mtx.Lock()
-					if w.nextRef <= s.Ref {
-						w.nextRef = s.Ref + 1
-					}
-					w.mtx.Unlock()
-				}
-			}
+// SetAppendOptions changes the AppendOptions of the storage. Appenders that
+// were already created keep using the previous options.
+func (w *Storage) SetAppendOptions(opts AppendOptions) {
+	w.appendOptsMtx.Lock()
+	defer w.appendOptsMtx.Unlock()
+	w.appendOpts = opts
+}
 
-			//nolint:staticcheck
-			seriesPool.Put(v)
-		case []record.RefSample:
-			for _, s := range v {
-				// Update the lastTs for the series based
-				series := w.series.getByID(s.Ref)
-				if series == nil {
-					level.Warn(w.logger).Log("msg", "found sample referencing non-existing series, skipping")
-					continue
-				}
+// Appender returns a new appender against the storage.
+func (w *Storage) Appender(_ context.Context) storage.Appender {
+	w.appendOptsMtx.RLock()
+	defer w.appendOptsMtx.RUnlock()
 
-				series.Lock()
-				if s.T > series.lastTs {
-					series.lastTs = s.T
-				}
-				series.Unlock()
-			}
+	a := w.appenderPool.Get().(*appender)
+	a.opts = w.appendOpts
+	return a
+}
 
-			//nolint:staticcheck
-			samplesPool.Put(v)
-		default:
-			panic(fmt.Errorf("unexpected decoded type: %T", d))
-		}
-	}
+// StartTime always returns 0, nil. It is implemented for compatibility with
+// Prometheus, but is unused in the agent.
+func (*Storage) StartTime() (int64, error) {
+	return 0, nil
+}
 
-	select {
-	case err := <-errCh:
-		return err
-	default:
-	}
+// Size returns the size of the WAL on disk in bytes, including checkpoints.
+func (w *Storage) Size() (int64, error) {
+	w.walMtx.RLock()
+	defer w.walMtx.RUnlock()
 
-	if r.Err() != nil {
-		return errors.Wrap(r.Err(), "read records")
+	if w.walClosed {
+		return 0, ErrWALClosed
 	}
 
-	return nil
+	size, err := w.wal.Size()
+	if err != nil {
+		return 0, err
+	}
+	w.metrics.sizeBytes.Set(float64(size))
+	return size, nil
 }
 
-// Directory returns the path where the WAL storage is held.
-func (w *Storage) Directory() string {
-	return w.path
-}
+// SeriesStats describes the series a Storage holds in memory.
+type SeriesStats struct {
+	// Series is the number of series held in memory.
+	Series int
 
-// Appender returns a new appender against the storage.
-func (w *Storage) Appender(_ context.Context) storage.Appender {
-	return w.appenderPool.Get().(storage.Appender)
+	// MemoryBytes estimates the memory used by those series: their labels
+	// and the bookkeeping for each series. Labels are interned and may be
+	// shared with other series, so MemoryBytes may overestimate.
+	MemoryBytes int64
 }
 
-// StartTime always returns 0, nil. It is implemented for compatibility with
-// Prometheus, but is unused in the agent.
-func (*Storage) StartTime() (int64, error) {
-	return 0, nil
+// memSeriesOverhead estimates the memory used by a series apart from its
+// labels: the memSeries itself and its entries in the ID and hash maps of
+// stripeSeries, each estimated at 48 bytes.
+const memSeriesOverhead = int64(unsafe.Sizeof(memSeries{})) + 2*48
+
+// SeriesStats returns statistics about the series held in memory.
+func (w *Storage) SeriesStats() SeriesStats {
+	var stats SeriesStats
+
+	for i := 0; i < w.series.size; i++ {
+		w.series.locks[i].RLock()
+		for _, s := range w.series.series[i] {
+			stats.Series++
+			stats.MemoryBytes += memSeriesOverhead
+			for _, l := range s.lset {
+				stats.MemoryBytes += int64(len(l.Name) + len(l.Value))
+			}
+		}
+		w.series.locks[i].RUnlock()
+	}
+
+	return stats
 }
 
 // Truncate removes all data from the WAL prior to the timestamp specified by
@@ -374,6 +381,7 @@ func (w *Storage) Truncate(mint int64) error {
 	}
 
 	start := time.Now()
+	w.metrics.totalTruncations.Inc()
 
 	// Garbage collect series that haven't received an update since mint.
 	w.gc(mint)
@@ -403,6 +411,68 @@ func (w *Storage) Truncate(mint int64) error {
 		return nil
 	}
 
+	if err := w.checkpoint(first, last, mint); err != nil {
+		return err
+	}
+
+	level.Info(w.logger).Log("msg", "WAL checkpoint complete",
+		"first", first, "last", last, "duration", time.Since(start))
+	return nil
+}
+
+// DropOldestSegments removes every WAL segment except for the newest ones,
+// even if remote_write hasn't sent their samples yet. It's used to keep the
+// WAL under a size limit.
+//
+// Unlike Truncate, no series are garbage collected: series records are kept
+// in a checkpoint and samples can still be appended to them through cached
+// references. Only the samples in the removed segments are lost.
+func (w *Storage) DropOldestSegments() error {
+	w.walMtx.RLock()
+	defer w.walMtx.RUnlock()
+
+	if w.walClosed {
+		return ErrWALClosed
+	}
+
+	start := time.Now()
+
+	first, last, err := wal.Segments(w.wal.Dir())
+	if err != nil {
+		return errors.Wrap(err, "get segment range")
+	}
+
+	err = w.wal.NextSegment()
+	if err != nil {
+		return errors.Wrap(err, "next segment")
+	}
+
+	// Like Truncate, never consider the last segment, which remote_write may
+	// still be reading.
+	last--
+	if last < 0 || last < first {
+		return nil
+	}
+
+	w.metrics.totalTruncations.Inc()
+
+	// No sample has a timestamp of math.MaxInt64, so none of the samples of
+	// the segments are kept in the checkpoint.
+	if err := w.checkpoint(first, last, math.MaxInt64); err != nil {
+		return err
+	}
+	w.metrics.droppedSegments.Add(float64(last - first + 1))
+
+	level.Warn(w.logger).Log("msg", "dropped oldest WAL segments",
+		"first", first, "last", last, "duration", time.Since(start))
+	return nil
+}
+
+// checkpoint writes the series records of the segments first through last
+// and their samples not older than mint into a checkpoint, then removes the
+// segments. Series records are kept for series still in memory or deleted
+// after the segments were written.
+func (w *Storage) checkpoint(first, last int, mint int64) error {
 	keep := func(id uint64) bool {
 		if w.series.getByID(id) != nil {
 			return true
@@ -413,7 +483,7 @@ func (w *Storage) Truncate(mint int64) error {
 		w.deletedMtx.Unlock()
 		return ok
 	}
-	if _, err = wal.Checkpoint(w.logger, w.wal, first, last, keep, mint); err != nil {
+	if _, err := wal.Checkpoint(w.logger, w.wal, first, last, keep, mint); err != nil {
 		return errors.Wrap(err, "create checkpoint")
 	}
 	if err := w.wal.Truncate(last + 1); err != nil {
@@ -442,8 +512,6 @@ func (w *Storage) Truncate(mint int64) error {
 		level.Error(w.logger).Log("msg", "delete old checkpoints", "err", err)
 	}
 
-	level.Info(w.logger).Log("msg", "WAL checkpoint complete",
-		"first", first, "last", last, "duration", time.Since(start))
 	return nil
 }
 
@@ -476,7 +544,9 @@ func (w *Storage) WriteStalenessMarkers(remoteTsFunc func() int64) error {
 	var lastErr error
 	var lastTs int64
 
-	app := w.Appender(context.Background())
+	app := w.Appender(context.Background()).(*appender)
+	app.opts.DropStaleMarkers = false
+
 	it := w.series.iterator()
 	for series := range it.Channel() {
 		var (
@@ -549,11 +619,17 @@ func (w *Storage) Close() error {
 
 type appender struct {
 	w       *Storage
+	opts    AppendOptions
 	series  []record.RefSeries
 	samples []record.RefSample
 }
 
 func (a *appender) Append(ref uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	if a.opts.DropStaleMarkers && value.IsStaleNaN(v) {
+		a.w.metrics.droppedStaleMarkers.Inc()
+		return ref, nil
+	}
+
 	if ref == 0 {
 		return a.Add(l, t, v)
 	}
@@ -608,6 +684,15 @@ func (a *appender) AddFast(ref uint64, t int64, v float64) error {
 	series.Lock()
 	defer series.Unlock()
 
+	if t < series.lastTs {
+		tolerance := a.opts.OutOfOrderTolerance.Milliseconds()
+		if tolerance > 0 && series.lastTs-t > tolerance {
+			a.w.metrics.rejectedSamples.Inc()
+			return storage.ErrOutOfOrderSample
+		}
+		a.w.metrics.outOfOrderSamples.Inc()
+	}
+
 	// Update last recorded timestamp. Used by Storage.gc to determine if a
 	// series is dead.
 	series.updateTs(t)
@@ -641,7 +726,7 @@ func (a *appender) Commit() error {
 
 	if len(a.series) > 0 {
 		buf = encoder.Series(a.series, buf)
-		if err := a.w.wal.Log(buf); err != nil {
+		if err := a.w.log(buf); err != nil {
 			return err
 		}
 		buf = buf[:0]
@@ -649,7 +734,7 @@ func (a *appender) Commit() error {
 
 	if len(a.samples) > 0 {
 		buf = encoder.Samples(a.samples, buf)
-		if err := a.w.wal.Log(buf); err != nil {
+		if err := a.w.log(buf); err != nil {
 			return err
 		}
 		buf = buf[:0]
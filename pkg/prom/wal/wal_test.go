@@ -2,6 +2,7 @@ package wal
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"os"
@@ -10,10 +11,13 @@ import (
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/value"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,7 +26,7 @@ func TestStorage_InvalidSeries(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(walDir)
 
-	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
 	require.NoError(t, err)
 	defer func() {
 		require.NoError(t, s.Close())
@@ -46,7 +50,7 @@ func TestStorage(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(walDir)
 
-	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
 	require.NoError(t, err)
 	defer func() {
 		require.NoError(t, s.Close())
@@ -82,12 +86,60 @@ func TestStorage(t *testing.T) {
 	require.Equal(t, expectedSamples, actual)
 }
 
+func TestStorage_Compression(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	payload := seriesList{
+		{name: "foo", samples: []sample{{1, 10.0}, {10, 100.0}}},
+		{name: "bar", samples: []sample{{2, 20.0}, {20, 200.0}}},
+	}
+
+	// Write with compression disabled and then enabled. Both WALs must be
+	// readable regardless of the setting.
+	for _, compress := range []bool{false, true} {
+		s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, compress)
+		require.NoError(t, err)
+		require.Equal(t, compress, s.wal.CompressionEnabled())
+
+		app := s.Appender(context.Background())
+		for _, metric := range payload {
+			metric.Write(t, app)
+		}
+		require.NoError(t, app.Commit())
+
+		written := counterValue(t, s.metrics.recordBytes)
+		stored := counterValue(t, s.metrics.recordStoredBytes)
+		require.Greater(t, written, 0.0)
+		if compress {
+			require.LessOrEqual(t, stored, written)
+		} else {
+			require.Equal(t, written, stored)
+		}
+		require.NoError(t, s.Close())
+	}
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(SubDirectory(walDir)))
+	require.Len(t, collector.samples, 2*len(payload.ExpectedSamples()))
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	require.NoError(t, c.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
 func TestStorage_ExistingWAL(t *testing.T) {
 	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
 	require.NoError(t, err)
 	defer os.RemoveAll(walDir)
 
-	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
 	require.NoError(t, err)
 
 	app := s.Appender(context.Background())
@@ -111,7 +163,7 @@ func TestStorage_ExistingWAL(t *testing.T) {
 	time.Sleep(time.Millisecond * 150)
 
 	// Create a new storage, write the other half of samples.
-	s, err = NewStorage(log.NewNopLogger(), nil, walDir)
+	s, err = NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
 	require.NoError(t, err)
 	defer func() {
 		require.NoError(t, s.Close())
@@ -147,6 +199,64 @@ func TestStorage_ExistingWAL(t *testing.T) {
 	require.Equal(t, expectedSamples, actual)
 }
 
+func TestStorage_ReplaySegments(t *testing.T) {
+	// A memory limit of 1 byte forces segments to be replayed one at a time.
+	for _, limit := range []int64{0, 1, 1 << 20} {
+		t.Run(fmt.Sprintf("limit=%d", limit), func(t *testing.T) {
+			walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+			require.NoError(t, err)
+			defer os.RemoveAll(walDir)
+
+			s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
+			require.NoError(t, err)
+
+			payload := seriesList{
+				{name: "foo", samples: []sample{{1, 10.0}, {10, 100.0}}},
+				{name: "bar", samples: []sample{{2, 20.0}, {20, 200.0}}},
+				{name: "baz", samples: []sample{{3, 30.0}, {30, 300.0}}},
+				{name: "blerg", samples: []sample{{4, 40.0}, {40, 400.0}}},
+			}
+
+			// Write every series to its own segment.
+			for _, metric := range payload {
+				app := s.Appender(context.Background())
+				metric.Write(t, app)
+				require.NoError(t, app.Commit())
+				require.NoError(t, s.wal.NextSegment())
+			}
+
+			// Checkpoint the older segments so both the checkpoint and the WAL
+			// segments after it are replayed.
+			require.NoError(t, s.Truncate(0))
+
+			// Write a newer sample for foo in the last segment, after the one
+			// that created the series.
+			app := s.Appender(context.Background())
+			_, err = app.Append(*payload[0].ref, nil, 50, 500.0)
+			require.NoError(t, err)
+			require.NoError(t, app.Commit())
+			require.NoError(t, s.Close())
+
+			s, err = NewStorage(log.NewNopLogger(), nil, walDir, limit, true)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, s.Close())
+			}()
+
+			actual := map[string]int64{}
+			for series := range s.series.iterator().Channel() {
+				actual[series.lset.Get("__name__")] = series.lastTs
+			}
+			require.Equal(t, map[string]int64{"foo": 50, "bar": 20, "baz": 30, "blerg": 40}, actual)
+
+			// New series must not reuse the refs of replayed series.
+			for _, metric := range payload {
+				require.Greater(t, s.nextRef, *metric.ref)
+			}
+		})
+	}
+}
+
 func TestStorage_Truncate(t *testing.T) {
 	// Same as before but now do the following:
 	// after writing all the data, forcefully create 4 more segments,
@@ -156,7 +266,7 @@ func TestStorage_Truncate(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(walDir)
 
-	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
 	require.NoError(t, err)
 	defer func() {
 		require.NoError(t, s.Close())
@@ -210,12 +320,71 @@ func TestStorage_Truncate(t *testing.T) {
 	require.Equal(t, expectedSamples, actual)
 }
 
+func TestStorage_DropOldestSegments(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	payload := seriesList{
+		{name: "foo", samples: []sample{{1, 10.0}}},
+		{name: "bar", samples: []sample{{2, 20.0}}},
+	}
+
+	app := s.Appender(context.Background())
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.wal.NextSegment())
+	}
+	require.NoError(t, s.DropOldestSegments())
+
+	first, _, err := wal.Segments(s.wal.Dir())
+	require.NoError(t, err)
+	require.Greater(t, first, 0, "oldest segments weren't removed")
+
+	// Series aren't garbage collected, so appends through the cached refs of
+	// the series keep working.
+	for _, metric := range payload {
+		metric.samples = []sample{{metric.samples[0].ts + 10, 100.0}}
+	}
+	app = s.Appender(context.Background())
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	// Read back the WAL. The series are kept, but only the samples written
+	// after dropping the segments remain.
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	names := []string{}
+	for _, series := range collector.series {
+		names = append(names, series.Labels.Get("__name__"))
+	}
+	require.Equal(t, payload.SeriesNames(), names)
+
+	actual := collector.samples
+	sort.Sort(byRefSample(actual))
+	require.Equal(t, payload.ExpectedSamples(), actual)
+}
+
 func TestStorage_WriteStalenessMarkers(t *testing.T) {
 	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
 	require.NoError(t, err)
 	defer os.RemoveAll(walDir)
 
-	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
 	require.NoError(t, err)
 	defer func() {
 		require.NoError(t, s.Close())
@@ -264,18 +433,157 @@ func TestStorage_WriteStalenessMarkers(t *testing.T) {
 	}
 }
 
+func TestStorage_OutOfOrderTolerance(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	s.SetAppendOptions(AppendOptions{OutOfOrderTolerance: 5 * time.Second})
+
+	lset := labels.FromStrings("__name__", "foo")
+	app := s.Appender(context.Background())
+
+	ref, err := app.Append(0, lset, 10000, 1)
+	require.NoError(t, err)
+
+	_, err = app.Append(ref, lset, 6000, 2)
+	require.NoError(t, err, "sample within the tolerance should be accepted")
+
+	_, err = app.Append(ref, lset, 4000, 3)
+	require.Equal(t, storage.ErrOutOfOrderSample, err, "sample beyond the tolerance should be rejected")
+
+	require.NoError(t, app.Commit())
+
+	require.Equal(t, float64(1), counterValue(t, s.metrics.outOfOrderSamples))
+	require.Equal(t, float64(1), counterValue(t, s.metrics.rejectedSamples))
+	require.Equal(t, int64(10000), s.series.getByID(ref).lastTs, "out-of-order samples shouldn't move the series back in time")
+
+	// Without a tolerance, out-of-order samples of any age are accepted.
+	s.SetAppendOptions(AppendOptions{})
+	app = s.Appender(context.Background())
+	_, err = app.Append(ref, lset, 0, 4)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+}
+
+func TestStorage_DropStaleMarkers(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	s.SetAppendOptions(AppendOptions{DropStaleMarkers: true})
+
+	lset := labels.FromStrings("__name__", "foo")
+	app := s.Appender(context.Background())
+	ref, err := app.Append(0, lset, 1, 1)
+	require.NoError(t, err)
+	_, err = app.Append(ref, lset, 2, math.Float64frombits(value.StaleNaN))
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.Equal(t, float64(1), counterValue(t, s.metrics.droppedStaleMarkers))
+
+	// Staleness markers written on shutdown are always kept.
+	require.NoError(t, s.WriteStalenessMarkers(func() int64 { return math.MaxInt64 }))
+
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+
+	var stale int
+	for _, sample := range collector.samples {
+		if value.IsStaleNaN(sample.V) {
+			stale++
+		}
+	}
+	require.Equal(t, 1, stale)
+}
+
 func TestStoraeg_TruncateAfterClose(t *testing.T) {
 	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
 	require.NoError(t, err)
 	defer os.RemoveAll(walDir)
 
-	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
 	require.NoError(t, err)
 
 	require.NoError(t, s.Close())
 	require.Error(t, ErrWALClosed, s.Truncate(0))
 }
 
+func TestStorage_Size(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
+	require.NoError(t, err)
+
+	before, err := s.Size()
+	require.NoError(t, err)
+
+	app := s.Appender(context.Background())
+	payload := seriesList{
+		{name: "foo", samples: []sample{{1, 10.0}, {10, 100.0}}},
+		{name: "bar", samples: []sample{{2, 20.0}, {20, 200.0}}},
+	}
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	after, err := s.Size()
+	require.NoError(t, err)
+	require.Greater(t, after, before)
+
+	require.NoError(t, s.Close())
+	_, err = s.Size()
+	require.Equal(t, ErrWALClosed, err)
+}
+
+func TestStorage_SeriesStats(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir, 0, true)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	require.Equal(t, SeriesStats{}, s.SeriesStats())
+
+	app := s.Appender(context.Background())
+	payload := seriesList{
+		{name: "foo", samples: []sample{{1, 10.0}, {10, 100.0}}},
+		{name: "bar", samples: []sample{{2, 20.0}, {20, 200.0}}},
+	}
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	// Both series have a single __name__ label with a 3 character value.
+	labelBytes := int64(2 * (len("__name__") + 3))
+
+	stats := s.SeriesStats()
+	require.Equal(t, 2, stats.Series)
+	require.Equal(t, 2*memSeriesOverhead+labelBytes, stats.MemoryBytes)
+}
+
 type sample struct {
 	ts  int64
 	val float64
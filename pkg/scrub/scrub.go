@@ -0,0 +1,214 @@
+// Package scrub hashes or removes the values of sensitive labels, such as
+// user IDs or IP addresses, before metrics, logs and traces leave the Agent.
+package scrub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// Action is what a Rule does with the values it matches.
+type Action string
+
+// Supported actions.
+const (
+	// ActionHash replaces values with the hex-encoded SHA-256 hash of the
+	// salt followed by the value.
+	ActionHash Action = "hash"
+
+	// ActionDrop removes the label.
+	ActionDrop Action = "drop"
+)
+
+// DefaultRule holds the default settings for a Rule.
+var DefaultRule = Rule{
+	Regex:  ".*",
+	Action: ActionHash,
+}
+
+// Config controls which labels are scrubbed.
+type Config struct {
+	// Salt prepended to values before hashing them, so hashes can't be
+	// reversed by hashing likely values.
+	Salt config_util.Secret `yaml:"salt,omitempty"`
+
+	// Rules are checked in order. The first rule matching a label value is
+	// applied.
+	Rules []Rule `yaml:"rules,omitempty"`
+}
+
+// Rule scrubs the values of labels matching a regex.
+type Rule struct {
+	// Names of the labels, log labels or span attributes to scrub.
+	Labels []string `yaml:"labels"`
+
+	// Anchored regex matched against values. Values that don't match are
+	// kept as is.
+	Regex string `yaml:"regex,omitempty"`
+
+	// Action applied to matching values.
+	Action Action `yaml:"action,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*r = DefaultRule
+
+	type plain Rule
+	return unmarshal((*plain)(r))
+}
+
+// Validate returns an error if the Config is invalid.
+func (c *Config) Validate() error {
+	_, err := New(c)
+	return err
+}
+
+// Scrubber applies the rules of a Config. A nil Scrubber keeps every value.
+type Scrubber struct {
+	salt  string
+	rules map[string][]rule // label name -> rules applied to it
+}
+
+type rule struct {
+	regex  *regexp.Regexp
+	action Action
+}
+
+// New creates a Scrubber from c. Returns nil if c is nil or has no rules.
+func New(c *Config) (*Scrubber, error) {
+	if c == nil || len(c.Rules) == 0 {
+		return nil, nil
+	}
+
+	s := &Scrubber{
+		salt:  string(c.Salt),
+		rules: make(map[string][]rule),
+	}
+	for idx, r := range c.Rules {
+		if len(r.Labels) == 0 {
+			return nil, fmt.Errorf("scrubbing rule at index %d must have at least one label", idx)
+		}
+		switch r.Action {
+		case ActionHash, ActionDrop:
+		default:
+			return nil, fmt.Errorf("scrubbing rule at index %d: unsupported action %q, expected %q or %q", idx, r.Action, ActionHash, ActionDrop)
+		}
+		re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("scrubbing rule at index %d: invalid regex: %w", idx, err)
+		}
+
+		for _, name := range r.Labels {
+			switch name {
+			case "":
+				return nil, fmt.Errorf("scrubbing rule at index %d: label names must not be empty", idx)
+			case labels.MetricName:
+				return nil, errors.New("the metric name can't be scrubbed")
+			}
+			s.rules[name] = append(s.rules[name], rule{regex: re, action: r.Action})
+		}
+	}
+	return s, nil
+}
+
+// Hash returns the hex-encoded SHA-256 hash of salt followed by value.
+func Hash(salt, value string) string {
+	sum := sha256.Sum256([]byte(salt + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Applies returns true if any rule applies to the label name.
+func (s *Scrubber) Applies(name string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.rules[name]
+	return ok
+}
+
+// Scrub returns the scrubbed value of the label name. keep is false if the
+// label must be removed.
+func (s *Scrubber) Scrub(name, value string) (scrubbed string, keep bool) {
+	if s == nil {
+		return value, true
+	}
+	for _, r := range s.rules[name] {
+		if !r.regex.MatchString(value) {
+			continue
+		}
+		if r.action == ActionDrop {
+			return "", false
+		}
+		return Hash(s.salt, value), true
+	}
+	return value, true
+}
+
+// Labels returns ls with its labels scrubbed. ls is returned unmodified if
+// no label is scrubbed.
+func (s *Scrubber) Labels(ls labels.Labels) labels.Labels {
+	if s == nil || !s.matches(ls) {
+		return ls
+	}
+
+	res := make(labels.Labels, 0, len(ls))
+	for _, l := range ls {
+		if v, keep := s.Scrub(l.Name, l.Value); keep {
+			res = append(res, labels.Label{Name: l.Name, Value: v})
+		}
+	}
+	return res
+}
+
+func (s *Scrubber) matches(ls labels.Labels) bool {
+	for _, l := range ls {
+		if s.Applies(l.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelSet returns a copy of ls with its labels scrubbed. ls is returned
+// unmodified if no label is scrubbed.
+func (s *Scrubber) LabelSet(ls model.LabelSet) model.LabelSet {
+	if s == nil {
+		return ls
+	}
+
+	var res model.LabelSet
+	for name, value := range ls {
+		if !s.Applies(string(name)) {
+			continue
+		}
+		if res == nil {
+			res = ls.Clone()
+		}
+		if v, keep := s.Scrub(string(name), string(value)); keep {
+			res[name] = model.LabelValue(v)
+		} else {
+			delete(res, name)
+		}
+	}
+	if res == nil {
+		return ls
+	}
+	return res
+}
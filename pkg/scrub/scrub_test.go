@@ -0,0 +1,82 @@
+package scrub
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_UnmarshalYAML(t *testing.T) {
+	in := `
+salt: secret
+rules:
+  - labels: [user_id]
+  - labels: [client_ip]
+    regex: 10\..*
+    action: drop
+`
+	var c Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(in), &c))
+	require.Equal(t, []Rule{
+		{Labels: []string{"user_id"}, Regex: ".*", Action: ActionHash},
+		{Labels: []string{"client_ip"}, Regex: `10\..*`, Action: ActionDrop},
+	}, c.Rules)
+
+	tt := []struct {
+		in  string
+		err string
+	}{
+		{"rules: [{labels: []}]", "scrubbing rule at index 0 must have at least one label"},
+		{"rules: [{labels: [a], action: mask}]", `scrubbing rule at index 0: unsupported action "mask", expected "hash" or "drop"`},
+		{"rules: [{labels: [a], regex: '('}]", "scrubbing rule at index 0: invalid regex: error parsing regexp: missing closing ): `^(?:()$`"},
+		{"rules: [{labels: [__name__]}]", "the metric name can't be scrubbed"},
+	}
+	for _, tc := range tt {
+		require.EqualError(t, yaml.UnmarshalStrict([]byte(tc.in), &Config{}), tc.err, tc.in)
+	}
+}
+
+func TestScrubber(t *testing.T) {
+	s, err := New(&Config{
+		Salt: "salt",
+		Rules: []Rule{
+			{Labels: []string{"client_ip"}, Regex: `10\..*`, Action: ActionDrop},
+			{Labels: []string{"user_id", "client_ip"}, Regex: ".*", Action: ActionHash},
+		},
+	})
+	require.NoError(t, err)
+
+	hashed := Hash("salt", "1234")
+	require.Len(t, hashed, 64)
+
+	in := labels.FromStrings("__name__", "requests_total", "user_id", "1234", "client_ip", "10.0.0.1")
+	require.Equal(t, labels.FromStrings("__name__", "requests_total", "user_id", hashed), s.Labels(in))
+	require.Equal(t, labels.FromStrings("__name__", "requests_total", "user_id", "1234", "client_ip", "10.0.0.1"), in, "input must not be modified")
+
+	// The first matching rule wins.
+	v, keep := s.Scrub("client_ip", "192.168.0.1")
+	require.True(t, keep)
+	require.Equal(t, Hash("salt", "192.168.0.1"), v)
+
+	untouched := labels.FromStrings("__name__", "up", "job", "node")
+	require.Equal(t, untouched, s.Labels(untouched))
+
+	set := model.LabelSet{"job": "app", "user_id": "1234", "client_ip": "10.0.0.1"}
+	require.Equal(t, model.LabelSet{"job": "app", "user_id": model.LabelValue(hashed)}, s.LabelSet(set))
+	require.Len(t, set, 3, "input must not be modified")
+}
+
+func TestScrubber_Nil(t *testing.T) {
+	s, err := New(&Config{Salt: "salt"})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	ls := labels.FromStrings("user_id", "1234")
+	require.Equal(t, ls, s.Labels(ls))
+	v, keep := s.Scrub("user_id", "1234")
+	require.True(t, keep)
+	require.Equal(t, "1234", v)
+}
@@ -0,0 +1,91 @@
+package automaticloggingprocessor
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/agent/pkg/tempo/spaneventlogsprocessor"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the automatic logging processor.
+const TypeStr = "automatic_logging"
+
+// Backends log lines can be sent to.
+const (
+	// BackendStdout writes log lines to the standard output of the agent.
+	BackendStdout = "stdout"
+
+	// BackendLogs sends log lines to a config of the logs subsystem.
+	BackendLogs = "logs"
+)
+
+// DefaultTimeout is the default amount of time to wait for the logs subsystem
+// to accept a log line before dropping it.
+const DefaultTimeout = time.Millisecond
+
+// Config holds the configuration for the automatic logging processor.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	// Backend is where log lines are sent, either BackendStdout or
+	// BackendLogs.
+	Backend string `mapstructure:"backend"`
+
+	// LokiName is the name of the Loki config to send log lines to when
+	// Backend is BackendLogs.
+	LokiName string `mapstructure:"loki_name"`
+
+	// Roots logs spans without a parent.
+	Roots bool `mapstructure:"roots"`
+
+	// Errors logs spans with an error status.
+	Errors bool `mapstructure:"errors"`
+
+	// SpanAttributes are the span attributes included in log lines.
+	SpanAttributes []string `mapstructure:"span_attributes"`
+
+	// ResourceAttributes are the resource attributes included in log lines.
+	ResourceAttributes []string `mapstructure:"resource_attributes"`
+
+	// ResourceLabels maps resource attributes to labels added to log lines,
+	// like the resource labels of the span event logs processor.
+	ResourceLabels []spaneventlogsprocessor.ResourceLabel `mapstructure:"resource_labels"`
+
+	// Timeout is how long to wait for Loki to accept the log lines of a
+	// batch of spans before dropping the remaining lines.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// NewFactory returns a new factory for the automatic logging processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+		Backend: BackendStdout,
+		Timeout: DefaultTimeout,
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	cp component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg)
+}
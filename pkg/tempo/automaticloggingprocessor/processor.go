@@ -0,0 +1,266 @@
+// Package automaticloggingprocessor implements an OpenTelemetry processor
+// that logs a line for selected spans, such as root spans and spans that
+// failed, holding their trace ID. Log lines can be correlated with traces
+// even when the services that emitted the spans don't log trace IDs.
+package automaticloggingprocessor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	util "github.com/cortexproject/cortex/pkg/util/log"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/tempo/spaneventlogsprocessor"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	tracetranslator "go.opentelemetry.io/collector/translator/trace"
+)
+
+var (
+	logsSentTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_tempo_automatic_logging_lines_sent_total",
+		Help: "Total number of log lines written for spans by automatic logging",
+	})
+
+	logsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_tempo_automatic_logging_lines_dropped_total",
+		Help: "Total number of automatic logging lines dropped because Loki was unavailable or didn't accept them in time",
+	})
+)
+
+type automaticLoggingProcessor struct {
+	nextConsumer       consumer.TracesConsumer
+	backend            string
+	lokiName           string
+	roots, errors      bool
+	spanAttributes     []string
+	resourceAttributes []string
+	resourceLabels     []spaneventlogsprocessor.ResourceLabel
+	timeout            time.Duration
+	logger             log.Logger
+
+	// out receives log lines with the stdout backend.
+	outMut sync.Mutex
+	out    io.Writer
+
+	host spaneventlogsprocessor.Host
+}
+
+func newTraceProcessor(nextConsumer consumer.TracesConsumer, cfg *Config) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+
+	switch cfg.Backend {
+	case BackendStdout:
+	case BackendLogs:
+		if cfg.LokiName == "" {
+			return nil, fmt.Errorf("loki_name must be set with the %s backend", BackendLogs)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported backend %q, expected %q or %q", cfg.Backend, BackendStdout, BackendLogs)
+	}
+
+	if !cfg.Roots && !cfg.Errors {
+		return nil, fmt.Errorf("at least one of roots and errors must be enabled")
+	}
+
+	if err := spaneventlogsprocessor.ValidateResourceLabels(cfg.ResourceLabels); err != nil {
+		return nil, err
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &automaticLoggingProcessor{
+		nextConsumer:       nextConsumer,
+		backend:            cfg.Backend,
+		lokiName:           cfg.LokiName,
+		roots:              cfg.Roots,
+		errors:             cfg.Errors,
+		spanAttributes:     cfg.SpanAttributes,
+		resourceAttributes: cfg.ResourceAttributes,
+		resourceLabels:     cfg.ResourceLabels,
+		timeout:            timeout,
+		logger:             log.With(util.Logger, "component", "tempo automatic logging"),
+		out:                os.Stdout,
+	}, nil
+}
+
+func (p *automaticLoggingProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	entries := p.extractEntries(td)
+	if len(entries) > 0 {
+		p.send(entries)
+	}
+
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// extractEntries returns a log entry for every selected span in td.
+func (p *automaticLoggingProcessor) extractEntries(td pdata.Traces) []api.Entry {
+	var entries []api.Entry
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+		resource := rs.Resource().Attributes()
+		labels := spaneventlogsprocessor.ResourceLabelSet(resource, p.resourceLabels)
+
+		ilss := rs.InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				if !p.selected(span) {
+					continue
+				}
+
+				entry, err := p.newEntry(resource, labels, span)
+				if err != nil {
+					level.Debug(p.logger).Log("msg", "failed to create log line for span", "span", span.Name(), "err", err)
+					continue
+				}
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	return entries
+}
+
+func (p *automaticLoggingProcessor) selected(span pdata.Span) bool {
+	if p.roots && span.ParentSpanID().IsEmpty() {
+		return true
+	}
+	return p.errors && span.Status().Code() == pdata.StatusCodeError
+}
+
+// newEntry builds a logfmt-encoded log entry for a span, holding its trace
+// and span IDs, duration, status and the selected attributes. resourceLabels
+// are the labels of the entry.
+func (p *automaticLoggingProcessor) newEntry(resource pdata.AttributeMap, resourceLabels model.LabelSet, span pdata.Span) (api.Entry, error) {
+	var service string
+	if v, ok := resource.Get("service.name"); ok {
+		service = v.StringVal()
+	}
+
+	status := "unset"
+	switch span.Status().Code() {
+	case pdata.StatusCodeOk:
+		status = "ok"
+	case pdata.StatusCodeError:
+		status = "error"
+	}
+
+	keyvals := []interface{}{
+		"span", span.Name(),
+		"service", service,
+		"trace_id", span.TraceID().HexString(),
+		"span_id", span.SpanID().HexString(),
+		"duration", time.Duration(span.EndTime() - span.StartTime()).String(),
+		"status", status,
+	}
+	if msg := span.Status().Message(); msg != "" {
+		keyvals = append(keyvals, "status_message", msg)
+	}
+	keyvals = appendAttributes(keyvals, span.Attributes(), p.spanAttributes)
+	keyvals = appendAttributes(keyvals, resource, p.resourceAttributes)
+
+	var line bytes.Buffer
+	if err := log.NewLogfmtLogger(&line).Log(keyvals...); err != nil {
+		return api.Entry{}, err
+	}
+
+	ts := time.Unix(0, int64(span.EndTime()))
+	if span.EndTime() == 0 {
+		ts = time.Now()
+	}
+
+	return api.Entry{
+		Labels: resourceLabels.Clone(),
+		Entry: logproto.Entry{
+			Timestamp: ts,
+			Line:      strings.TrimSuffix(line.String(), "\n"),
+		},
+	}, nil
+}
+
+// appendAttributes appends the keys and values of the given attributes found
+// in attrs to keyvals.
+func appendAttributes(keyvals []interface{}, attrs pdata.AttributeMap, keys []string) []interface{} {
+	for _, key := range keys {
+		if v, ok := attrs.Get(key); ok {
+			keyvals = append(keyvals, key, tracetranslator.AttributeValueToString(v, false))
+		}
+	}
+	return keyvals
+}
+
+func (p *automaticLoggingProcessor) send(entries []api.Entry) {
+	if p.backend == BackendStdout {
+		p.outMut.Lock()
+		defer p.outMut.Unlock()
+
+		for _, entry := range entries {
+			if _, err := fmt.Fprintln(p.out, entry.Line); err != nil {
+				logsDroppedTotal.Inc()
+				continue
+			}
+			logsSentTotal.Inc()
+		}
+		return
+	}
+
+	var inst spaneventlogsprocessor.EntrySender
+	if p.host != nil {
+		inst = p.host.LogsInstance(p.lokiName)
+	}
+	if inst == nil {
+		logsDroppedTotal.Add(float64(len(entries)))
+		level.Debug(p.logger).Log("msg", "dropping automatic logging lines, loki config not found", "loki_name", p.lokiName, "count", len(entries))
+		return
+	}
+
+	sent := inst.SendEntries(entries, p.timeout)
+	logsSentTotal.Add(float64(sent))
+	logsDroppedTotal.Add(float64(len(entries) - sent))
+}
+
+func (p *automaticLoggingProcessor) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: false}
+}
+
+// Start is invoked during service startup.
+func (p *automaticLoggingProcessor) Start(_ context.Context, host component.Host) error {
+	if p.backend != BackendLogs {
+		return nil
+	}
+
+	h, ok := host.(spaneventlogsprocessor.Host)
+	if !ok {
+		return fmt.Errorf("%s requires a host that can send logs", TypeStr)
+	}
+	p.host = h
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *automaticLoggingProcessor) Shutdown(context.Context) error {
+	return nil
+}
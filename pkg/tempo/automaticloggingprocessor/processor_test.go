@@ -0,0 +1,216 @@
+package automaticloggingprocessor
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/tempo/spaneventlogsprocessor"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer/pdata"
+)
+
+func TestAutomaticLoggingProcessor(t *testing.T) {
+	tt := []struct {
+		name          string
+		roots, errors bool
+		expect        []string
+	}{
+		{
+			name:  "roots",
+			roots: true,
+			expect: []string{
+				`span=GET service=frontend trace_id=01000000000000000000000000000000 span_id=0100000000000000 duration=300ms status=ok http.status_code=200 k8s.pod.name=frontend-1`,
+			},
+		},
+		{
+			name:   "errors",
+			errors: true,
+			expect: []string{
+				`span=SELECT service=frontend trace_id=01000000000000000000000000000000 span_id=0200000000000000 duration=50ms status=error status_message="connection refused" k8s.pod.name=frontend-1`,
+			},
+		},
+		{
+			name:   "roots and errors",
+			roots:  true,
+			errors: true,
+			expect: []string{
+				`span=GET service=frontend trace_id=01000000000000000000000000000000 span_id=0100000000000000 duration=300ms status=ok http.status_code=200 k8s.pod.name=frontend-1`,
+				`span=SELECT service=frontend trace_id=01000000000000000000000000000000 span_id=0200000000000000 duration=50ms status=error status_message="connection refused" k8s.pod.name=frontend-1`,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := new(tracesSink)
+			p, err := newTraceProcessor(sink, &Config{
+				Backend:            BackendStdout,
+				Roots:              tc.roots,
+				Errors:             tc.errors,
+				SpanAttributes:     []string{"http.status_code", "missing"},
+				ResourceAttributes: []string{"k8s.pod.name"},
+			})
+			require.NoError(t, err)
+
+			var out bytes.Buffer
+			p.(*automaticLoggingProcessor).out = &out
+			require.NoError(t, p.Start(context.Background(), nopHost{}))
+
+			require.NoError(t, p.ConsumeTraces(context.Background(), testTraces()))
+			require.Equal(t, 3, sink.spans)
+			require.Equal(t, tc.expect, strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"))
+		})
+	}
+}
+
+func TestAutomaticLoggingProcessor_Logs(t *testing.T) {
+	p, err := newTraceProcessor(new(tracesSink), &Config{
+		Backend:  BackendLogs,
+		LokiName: "default",
+		Errors:   true,
+	})
+	require.NoError(t, err)
+
+	host := &mockHost{sender: &mockSender{}}
+	require.NoError(t, p.Start(context.Background(), host))
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces()))
+
+	require.Len(t, host.sender.entries, 1)
+	entry := host.sender.entries[0]
+	require.Equal(t, model.LabelSet{"service": "frontend"}, entry.Labels)
+	require.Equal(t, time.Unix(10, 50*int64(time.Millisecond)), entry.Timestamp)
+	require.Contains(t, entry.Line, "trace_id=01000000000000000000000000000000")
+}
+
+func TestAutomaticLoggingProcessor_ResourceLabels(t *testing.T) {
+	p, err := newTraceProcessor(new(tracesSink), &Config{
+		Backend:  BackendLogs,
+		LokiName: "default",
+		Errors:   true,
+		ResourceLabels: []spaneventlogsprocessor.ResourceLabel{
+			{Attribute: "k8s.pod.name", Label: "pod"},
+			{Attribute: "missing", Label: "missing"},
+		},
+	})
+	require.NoError(t, err)
+
+	host := &mockHost{sender: &mockSender{}}
+	require.NoError(t, p.Start(context.Background(), host))
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces()))
+
+	require.Len(t, host.sender.entries, 1)
+	require.Equal(t, model.LabelSet{
+		"service": "frontend",
+		"pod":     "frontend-1",
+	}, host.sender.entries[0].Labels)
+}
+
+func TestAutomaticLoggingProcessor_RequiresHost(t *testing.T) {
+	p, err := newTraceProcessor(new(tracesSink), &Config{Backend: BackendLogs, LokiName: "default", Roots: true})
+	require.NoError(t, err)
+	require.Error(t, p.Start(context.Background(), nopHost{}))
+}
+
+func TestAutomaticLoggingProcessor_InvalidConfig(t *testing.T) {
+	tt := []Config{
+		{Backend: "file", Roots: true},
+		{Backend: BackendLogs, Roots: true},
+		{Backend: BackendStdout},
+		{Backend: BackendStdout, Roots: true, ResourceLabels: []spaneventlogsprocessor.ResourceLabel{{Attribute: "k8s.pod.name", Label: "service"}}},
+	}
+	for _, cfg := range tt {
+		cfg := cfg
+		_, err := newTraceProcessor(new(tracesSink), &cfg)
+		require.Error(t, err, "expected error for %+v", cfg)
+	}
+}
+
+// testTraces returns a trace of the frontend service with a root span, a
+// successful child span and a failed child span.
+func testTraces() pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+
+	rs := td.ResourceSpans().At(0)
+	rs.Resource().Attributes().InsertString("service.name", "frontend")
+	rs.Resource().Attributes().InsertString("k8s.pod.name", "frontend-1")
+	rs.InstrumentationLibrarySpans().Resize(1)
+
+	spans := rs.InstrumentationLibrarySpans().At(0).Spans()
+	spans.Resize(3)
+
+	start := time.Unix(10, 0)
+	for i, s := range []struct {
+		name    string
+		parent  byte
+		latency time.Duration
+		code    pdata.StatusCode
+	}{
+		{name: "GET", latency: 300 * time.Millisecond, code: pdata.StatusCodeOk},
+		{name: "SELECT", parent: 1, latency: 50 * time.Millisecond, code: pdata.StatusCodeError},
+		{name: "cache", parent: 1, latency: 5 * time.Millisecond},
+	} {
+		span := spans.At(i)
+		span.SetName(s.name)
+		span.SetTraceID(pdata.NewTraceID([16]byte{1}))
+		span.SetSpanID(pdata.NewSpanID([8]byte{byte(i + 1)}))
+		if s.parent != 0 {
+			span.SetParentSpanID(pdata.NewSpanID([8]byte{s.parent}))
+		}
+		span.SetStartTime(pdata.TimeToUnixNano(start))
+		span.SetEndTime(pdata.TimeToUnixNano(start.Add(s.latency)))
+		span.Status().SetCode(s.code)
+		if s.code == pdata.StatusCodeError {
+			span.Status().SetMessage("connection refused")
+		}
+	}
+	spans.At(0).Attributes().InsertInt("http.status_code", 200)
+
+	return td
+}
+
+type tracesSink struct {
+	spans int
+}
+
+func (s *tracesSink) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	s.spans += td.SpanCount()
+	return nil
+}
+
+type nopHost struct{}
+
+func (nopHost) ReportFatalError(error) {}
+
+func (nopHost) GetFactory(component.Kind, configmodels.Type) component.Factory { return nil }
+
+func (nopHost) GetExtensions() map[configmodels.Extension]component.ServiceExtension { return nil }
+
+func (nopHost) GetExporters() map[configmodels.DataType]map[configmodels.Exporter]component.Exporter {
+	return nil
+}
+
+type mockHost struct {
+	nopHost
+	sender *mockSender
+}
+
+func (h *mockHost) LogsInstance(string) spaneventlogsprocessor.EntrySender {
+	return h.sender
+}
+
+type mockSender struct {
+	entries []api.Entry
+}
+
+func (s *mockSender) SendEntries(entries []api.Entry, _ time.Duration) int {
+	s.entries = append(s.entries, entries...)
+	return len(entries)
+}
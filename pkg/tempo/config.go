@@ -2,14 +2,32 @@ package tempo
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/grafana/agent/pkg/scrub"
+	"github.com/grafana/agent/pkg/tempo/automaticloggingprocessor"
+	"github.com/grafana/agent/pkg/tempo/jaegerremotesamplingextension"
 	"github.com/grafana/agent/pkg/tempo/noopreceiver"
+	"github.com/grafana/agent/pkg/tempo/oauth2exporter"
 	"github.com/grafana/agent/pkg/tempo/promsdprocessor"
+	"github.com/grafana/agent/pkg/tempo/receiverauthprocessor"
+	"github.com/grafana/agent/pkg/tempo/remotewriteexporter"
+	"github.com/grafana/agent/pkg/tempo/samplingattributesprocessor"
+	"github.com/grafana/agent/pkg/tempo/scrubprocessor"
+	"github.com/grafana/agent/pkg/tempo/servicegraphprocessor"
+	"github.com/grafana/agent/pkg/tempo/spaneventlogsprocessor"
+	"github.com/grafana/agent/pkg/tempo/spanfilterprocessor"
+	"github.com/grafana/agent/pkg/tempo/tenantexporter"
+	"github.com/grafana/agent/pkg/tempo/tenantprocessor"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/exporter/loadbalancingexporter"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/processor/spanmetricsprocessor"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/processor/tailsamplingprocessor"
@@ -22,20 +40,30 @@ import (
 	"go.opentelemetry.io/collector/exporter/prometheusexporter"
 	"go.opentelemetry.io/collector/processor/attributesprocessor"
 	"go.opentelemetry.io/collector/processor/batchprocessor"
+	"go.opentelemetry.io/collector/processor/probabilisticsamplerprocessor"
+	"go.opentelemetry.io/collector/processor/spanprocessor"
 	"go.opentelemetry.io/collector/receiver/jaegerreceiver"
 	"go.opentelemetry.io/collector/receiver/kafkareceiver"
 	"go.opentelemetry.io/collector/receiver/opencensusreceiver"
 	"go.opentelemetry.io/collector/receiver/otlpreceiver"
 	"go.opentelemetry.io/collector/receiver/zipkinreceiver"
+	"gopkg.in/yaml.v2"
 )
 
 const (
 	spanMetricsPipelineName    = "metrics/spanmetrics"
 	defaultSpanMetricsExporter = "prometheus"
 
+	serviceGraphsPipelineName = "metrics/service_graphs"
+	serviceGraphsExporter     = remotewriteexporter.TypeStr + "/service_graphs"
+	serviceGraphsNamespace    = "traces_service_graph"
+
 	// defaultDecisionWait is the default time to wait for a trace before making a sampling decision
 	defaultDecisionWait = time.Second * 5
 
+	// probabilisticSamplerName is the name of the processor used for sampling_percentage
+	probabilisticSamplerName = "probabilistic_sampler"
+
 	// defaultLoadBalancingPort is the default port the agent uses for internal load balancing
 	defaultLoadBalancingPort = "4318"
 	// agent's load balancing options
@@ -47,6 +75,8 @@ const (
 	stringAttributePolicy  = "string_attribute"
 	numericAttributePolicy = "numeric_attribute"
 	rateLimitingPolicy     = "rate_limiting"
+	latencyPolicy          = "latency"
+	statusCodePolicy       = "status_code"
 )
 
 // Config controls the configuration of Tempo trace pipelines.
@@ -66,6 +96,7 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 // Validate ensures that the Config is valid.
 func (c *Config) Validate() error {
 	names := make(map[string]struct{}, len(c.Configs))
+	endpoints := make(map[string]string)
 	for idx, c := range c.Configs {
 		if c.Name == "" {
 			return fmt.Errorf("tempo config at index %d is missing a name", idx)
@@ -74,11 +105,76 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("found multiple tempo configs with name %s", c.Name)
 		}
 		names[c.Name] = struct{}{}
+
+		// Each pipeline runs its own receivers, which can't share an endpoint
+		// with receivers of other pipelines.
+		for _, endpoint := range c.listenEndpoints() {
+			if other, exist := endpoints[endpoint]; exist && other != c.Name {
+				return fmt.Errorf("tempo configs %s and %s both listen on %s", other, c.Name, endpoint)
+			}
+			endpoints[endpoint] = c.Name
+		}
 	}
 
 	return nil
 }
 
+// listenEndpoints returns the endpoints explicitly configured for the
+// receivers of c, along with the endpoint receiving load balanced spans.
+// Endpoints without a host are returned as listening on 0.0.0.0.
+func (c *InstanceConfig) listenEndpoints() []string {
+	endpoints := receiverEndpoints(c.Receivers, nil)
+
+	if c.TailSampling != nil && c.TailSampling.LoadBalancing != nil {
+		port := defaultLoadBalancingPort
+		if c.TailSampling.Port != "" {
+			port = c.TailSampling.Port
+		}
+		endpoints = append(endpoints, net.JoinHostPort("0.0.0.0", port))
+	}
+
+	if c.JaegerRemoteSampling != nil {
+		endpoint := jaegerremotesamplingextension.DefaultEndpoint
+		if c.JaegerRemoteSampling.ListenAddress != "" {
+			endpoint = c.JaegerRemoteSampling.ListenAddress
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	for i, endpoint := range endpoints {
+		if host, port, err := net.SplitHostPort(endpoint); err == nil && host == "" {
+			endpoints[i] = net.JoinHostPort("0.0.0.0", port)
+		}
+	}
+	return endpoints
+}
+
+// receiverEndpoints appends the values of endpoint fields found in the
+// receiver config v to endpoints.
+func receiverEndpoints(v interface{}, endpoints []string) []string {
+	field := func(key string, v interface{}) {
+		if endpoint, ok := v.(string); ok && key == "endpoint" && endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+			return
+		}
+		endpoints = receiverEndpoints(v, endpoints)
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			field(k, child)
+		}
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			if k, ok := k.(string); ok {
+				field(k, child)
+			}
+		}
+	}
+	return endpoints
+}
+
 // InstanceConfig configures an individual Tempo trace pipeline.
 type InstanceConfig struct {
 	Name string `yaml:"name"`
@@ -98,6 +194,12 @@ type InstanceConfig struct {
 	// Attributes: https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34b5d387627875c498d7f43619f37ee3/processor/attributesprocessor/config.go#L30
 	Attributes map[string]interface{} `yaml:"attributes,omitempty"`
 
+	// Span: https://github.com/open-telemetry/opentelemetry-collector/blob/v0.21.0/processor/spanprocessor/config.go#L26
+	Span map[string]interface{} `yaml:"span,omitempty"`
+
+	// SpanFilter drops spans matching a set of rules
+	SpanFilter *SpanFilterConfig `yaml:"span_filter,omitempty"`
+
 	// prom service discovery
 	ScrapeConfigs []interface{} `yaml:"scrape_configs,omitempty"`
 
@@ -106,6 +208,33 @@ type InstanceConfig struct {
 
 	// TailSampling defines a sampling strategy for the pipeline
 	TailSampling *tailSamplingConfig `yaml:"tail_sampling"`
+
+	// SamplingPercentage is the percentage of traces to keep, sampled by trace ID.
+	// All traces are kept when unset.
+	SamplingPercentage *float32 `yaml:"sampling_percentage,omitempty"`
+
+	// SpanEventLogs sends span events to Loki as log lines
+	SpanEventLogs *SpanEventLogsConfig `yaml:"span_event_logs,omitempty"`
+
+	// AutomaticLogging logs a line for root spans and failed spans
+	AutomaticLogging *AutomaticLoggingConfig `yaml:"automatic_logging,omitempty"`
+
+	// ServiceGraphs writes metrics of the requests between services to a
+	// Prometheus instance
+	ServiceGraphs *ServiceGraphsConfig `yaml:"service_graphs,omitempty"`
+
+	// Tenant extracts the tenant of incoming spans and sends it to the backends
+	Tenant *TenantConfig `yaml:"tenant,omitempty"`
+
+	// ReceiverAuth rejects spans from requests without an accepted bearer token
+	ReceiverAuth *ReceiverAuthConfig `yaml:"receiver_auth,omitempty"`
+
+	// JaegerRemoteSampling serves sampling strategies to Jaeger clients
+	JaegerRemoteSampling *JaegerRemoteSamplingConfig `yaml:"jaeger_remote_sampling,omitempty"`
+
+	// Scrubbing hashes or removes span attributes. Set from the top-level
+	// scrubbing config of the Agent when unset.
+	Scrubbing *scrub.Config `yaml:"scrubbing,omitempty"`
 }
 
 const (
@@ -152,16 +281,32 @@ var DefaultRemoteWriteConfig = RemoteWriteConfig{
 
 // RemoteWriteConfig controls the configuration of an exporter
 type RemoteWriteConfig struct {
+	// Name identifies the backend in the names of its exporter and metrics.
+	// Defaults to the index of the backend.
+	Name               string                 `yaml:"name,omitempty"`
 	Endpoint           string                 `yaml:"endpoint,omitempty"`
 	Compression        string                 `yaml:"compression,omitempty"`
 	Insecure           bool                   `yaml:"insecure,omitempty"`
 	InsecureSkipVerify bool                   `yaml:"insecure_skip_verify,omitempty"`
 	BasicAuth          *prom_config.BasicAuth `yaml:"basic_auth,omitempty"`
+	TLSConfig          *prom_config.TLSConfig `yaml:"tls_config,omitempty"`
+	OAuth2             *OAuth2Config          `yaml:"oauth2,omitempty"`
 	Headers            map[string]string      `yaml:"headers,omitempty"`
 	SendingQueue       map[string]interface{} `yaml:"sending_queue,omitempty"`    // https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34b5d387627875c498d7f43619f37ee3/exporter/exporterhelper/queued_retry.go#L30
 	RetryOnFailure     map[string]interface{} `yaml:"retry_on_failure,omitempty"` // https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34b5d387627875c498d7f43619f37ee3/exporter/exporterhelper/queued_retry.go#L54
 }
 
+// OAuth2Config configures authenticating to a backend with tokens obtained
+// through the OAuth2 client credentials flow.
+type OAuth2Config struct {
+	ClientID         string             `yaml:"client_id"`
+	ClientSecret     prom_config.Secret `yaml:"client_secret,omitempty"`
+	ClientSecretFile string             `yaml:"client_secret_file,omitempty"`
+	TokenURL         string             `yaml:"token_url"`
+	Scopes           []string           `yaml:"scopes,omitempty"`
+	EndpointParams   map[string]string  `yaml:"endpoint_params,omitempty"`
+}
+
 // UnmarshalYAML implements yaml.Unmarshaler.
 func (c *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultRemoteWriteConfig
@@ -174,6 +319,17 @@ func (c *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) err
 	if c.Compression != compressionGzip && c.Compression != compressionNone {
 		return fmt.Errorf("unsupported compression '%s', expected 'gzip' or 'none'", c.Compression)
 	}
+	if c.OAuth2 != nil {
+		if c.BasicAuth != nil {
+			return errors.New("at most one of basic_auth and oauth2 must be configured")
+		}
+		if c.OAuth2.ClientID == "" || c.OAuth2.TokenURL == "" {
+			return errors.New("oauth2 requires client_id and token_url")
+		}
+		if c.OAuth2.ClientSecret != "" && c.OAuth2.ClientSecretFile != "" {
+			return errors.New("at most one of oauth2.client_secret and oauth2.client_secret_file must be configured")
+		}
+	}
 	return nil
 }
 
@@ -184,6 +340,288 @@ type SpanMetricsConfig struct {
 
 	// MetricsExporter is a Prometheus metrics exporter
 	MetricsExporter metricsExporterConfig `yaml:"metrics_exporter,omitempty"`
+
+	// PromInstance is the name of the Prometheus instance the metrics are
+	// written to, instead of being exposed by the metrics exporter.
+	PromInstance string `yaml:"prom_instance,omitempty"`
+}
+
+// ServiceGraphsConfig controls the service graph processor.
+type ServiceGraphsConfig struct {
+	// PromInstance is the name of the Prometheus instance the metrics are
+	// written to.
+	PromInstance string `yaml:"prom_instance"`
+	// Wait is how long to wait for the other span of a request.
+	Wait time.Duration `yaml:"wait,omitempty"`
+	// MaxItems is the maximum number of requests waiting for their other
+	// span.
+	MaxItems int `yaml:"max_items,omitempty"`
+	// LatencyHistogramBuckets are the buckets of the request latency
+	// histograms.
+	LatencyHistogramBuckets []time.Duration `yaml:"latency_histogram_buckets,omitempty"`
+}
+
+// SpanEventLogsConfig controls which span events are sent to Loki as log lines.
+type SpanEventLogsConfig struct {
+	// LokiName is the name of the Loki config to send log lines to.
+	LokiName string `yaml:"loki_name"`
+	// Rules select which span events are sent. Only exception events are sent
+	// if no rules are given.
+	Rules []SpanEventLogsRule `yaml:"rules,omitempty"`
+	// ResourceLabels maps resource attributes to labels added to log lines so
+	// they match the labels of the service's own logs.
+	ResourceLabels map[string]string `yaml:"resource_labels,omitempty"`
+	// Timeout is how long to wait for Loki to accept a log line before
+	// dropping it.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// SpanEventLogsRule selects span events to send as log lines.
+type SpanEventLogsRule struct {
+	// EventName is an anchored regular expression matched against the span
+	// event name.
+	EventName string `yaml:"event_name"`
+	// Attributes to include in the log line. All attributes are included if
+	// empty.
+	Attributes []string `yaml:"attributes,omitempty"`
+}
+
+// SpanFilterConfig controls which spans are dropped.
+type SpanFilterConfig struct {
+	// Drop lists the rules of spans to drop. Spans matching any rule are
+	// dropped.
+	Drop []SpanFilterRule `yaml:"drop"`
+}
+
+// SpanFilterRule matches spans when all of its set fields match.
+type SpanFilterRule struct {
+	// Service is an anchored regular expression matched against the
+	// service.name resource attribute.
+	Service string `yaml:"service,omitempty"`
+	// SpanName is an anchored regular expression matched against the span
+	// name.
+	SpanName string `yaml:"span_name,omitempty"`
+	// Attributes must all match.
+	Attributes []SpanFilterAttribute `yaml:"attributes,omitempty"`
+}
+
+// SpanFilterAttribute matches spans by a span or resource attribute.
+type SpanFilterAttribute struct {
+	// Key of the attribute. Span attributes take precedence over resource
+	// attributes.
+	Key string `yaml:"key"`
+	// Value is an anchored regular expression matched against the attribute
+	// value. Any value matches if empty.
+	Value string `yaml:"value,omitempty"`
+}
+
+// AutomaticLoggingConfig controls which spans automatic logging writes log
+// lines for, and where they're sent.
+type AutomaticLoggingConfig struct {
+	// Backend is where log lines are sent, stdout or logs.
+	Backend string `yaml:"backend,omitempty"`
+	// LokiName is the name of the Loki config to send log lines to with the
+	// logs backend.
+	LokiName string `yaml:"loki_name,omitempty"`
+	// Roots logs spans without a parent.
+	Roots bool `yaml:"roots,omitempty"`
+	// Errors logs spans with an error status.
+	Errors bool `yaml:"errors,omitempty"`
+	// SpanAttributes to include in log lines.
+	SpanAttributes []string `yaml:"span_attributes,omitempty"`
+	// ResourceAttributes to include in log lines.
+	ResourceAttributes []string `yaml:"resource_attributes,omitempty"`
+	// ResourceLabels maps resource attributes to labels added to log lines,
+	// like span_event_logs.resource_labels.
+	ResourceLabels map[string]string `yaml:"resource_labels,omitempty"`
+	// Timeout is how long to wait for Loki to accept a log line before
+	// dropping it.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// TenantConfig controls how the tenant of incoming spans is found and sent
+// to the backends.
+type TenantConfig struct {
+	// FromResourceAttribute is the resource attribute holding the tenant. It
+	// takes precedence over FromHeader.
+	FromResourceAttribute string `yaml:"from_resource_attribute,omitempty"`
+	// FromHeader is the header of incoming requests holding the tenant. Only
+	// gRPC receivers support headers.
+	FromHeader string `yaml:"from_header,omitempty"`
+	// Default is the tenant of spans without one. Spans without a tenant are
+	// sent without the tenant header if empty.
+	Default string `yaml:"default,omitempty"`
+	// Header is the header the tenant is sent to the backends in.
+	Header string `yaml:"header,omitempty"`
+}
+
+// ReceiverAuthConfig holds the bearer tokens accepted by the receivers.
+type ReceiverAuthConfig struct {
+	// BearerTokens are the accepted tokens. More than one token can be set
+	// while clients are moved to a new one.
+	BearerTokens []prom_config.Secret `yaml:"bearer_tokens,omitempty"`
+	// BearerTokenFile is a file holding an accepted token.
+	BearerTokenFile string `yaml:"bearer_token_file,omitempty"`
+}
+
+// tokens returns the accepted tokens, including the one read from
+// BearerTokenFile.
+func (c *ReceiverAuthConfig) tokens() ([]string, error) {
+	tokens := make([]string, 0, len(c.BearerTokens)+1)
+	for _, token := range c.BearerTokens {
+		if token == "" {
+			return nil, errors.New("receiver_auth.bearer_tokens must not be empty strings")
+		}
+		tokens = append(tokens, string(token))
+	}
+
+	if c.BearerTokenFile != "" {
+		buff, err := ioutil.ReadFile(c.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load bearer token file %s: %w", c.BearerTokenFile, err)
+		}
+		// Files usually end with a newline which isn't part of the token.
+		token := strings.TrimSpace(string(buff))
+		if token == "" {
+			return nil, fmt.Errorf("bearer token file %s is empty", c.BearerTokenFile)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("must set receiver_auth.bearer_tokens or receiver_auth.bearer_token_file")
+	}
+	return tokens, nil
+}
+
+// resourceLabelsConfig converts a resource_labels map into the config of the
+// processors. Resource attributes commonly contain dots, which the collector
+// config treats as nested keys, so they're passed as a list.
+func resourceLabelsConfig(m map[string]string) ([]map[string]interface{}, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	attrs := make([]string, 0, len(m))
+	for attr := range m {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	resourceLabels := make([]map[string]interface{}, 0, len(attrs))
+	processorResourceLabels := make([]spaneventlogsprocessor.ResourceLabel, 0, len(attrs))
+	for _, attr := range attrs {
+		resourceLabels = append(resourceLabels, map[string]interface{}{
+			"attribute": attr,
+			"label":     m[attr],
+		})
+		processorResourceLabels = append(processorResourceLabels, spaneventlogsprocessor.ResourceLabel{
+			Attribute: attr,
+			Label:     m[attr],
+		})
+	}
+	if err := spaneventlogsprocessor.ValidateResourceLabels(processorResourceLabels); err != nil {
+		return nil, err
+	}
+	return resourceLabels, nil
+}
+
+// unauthenticatedReceivers returns the receivers and protocols in receivers
+// that don't keep the headers of requests, so their spans would always be
+// rejected by receiver_auth.
+func unauthenticatedReceivers(receivers map[string]interface{}) []string {
+	var names []string
+	for name, cfg := range receivers {
+		switch strings.SplitN(name, "/", 2)[0] {
+		case "zipkin", "kafka":
+			names = append(names, name)
+		case "jaeger":
+			protocols, _ := mapValue(cfg, "protocols")
+			for _, protocol := range []string{"thrift_http", "thrift_compact", "thrift_binary"} {
+				if _, ok := mapValue(protocols, protocol); ok {
+					names = append(names, name+" "+protocol)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mapValue returns the value of key in the YAML map m.
+func mapValue(m interface{}, key string) (interface{}, bool) {
+	switch m := m.(type) {
+	case map[string]interface{}:
+		v, ok := m[key]
+		return v, ok
+	case map[interface{}]interface{}:
+		v, ok := m[key]
+		return v, ok
+	}
+	return nil, false
+}
+
+// JaegerRemoteSamplingConfig controls the sampling strategies served to
+// Jaeger clients. Exactly one of StrategyFile and Strategies must be set.
+type JaegerRemoteSamplingConfig struct {
+	// ListenAddress is the address sampling strategies are served on.
+	ListenAddress string `yaml:"listen_address,omitempty"`
+	// StrategyFile is the path or URL of a Jaeger sampling strategies file.
+	StrategyFile string `yaml:"strategy_file,omitempty"`
+	// ReloadInterval is how often StrategyFile is reloaded.
+	ReloadInterval time.Duration `yaml:"reload_interval,omitempty"`
+	// Strategies are inline sampling strategies.
+	Strategies *JaegerSamplingStrategies `yaml:"strategies,omitempty"`
+}
+
+// JaegerSamplingStrategies holds sampling strategies in the format of a
+// Jaeger sampling strategies file.
+type JaegerSamplingStrategies struct {
+	// DefaultStrategy applies to services without a strategy.
+	DefaultStrategy *JaegerSamplingStrategy `yaml:"default_strategy,omitempty" json:"default_strategy,omitempty"`
+	// ServiceStrategies are the strategies of individual services.
+	ServiceStrategies []JaegerSamplingStrategy `yaml:"service_strategies,omitempty" json:"service_strategies,omitempty"`
+}
+
+// JaegerSamplingStrategy is the sampling strategy of a service.
+type JaegerSamplingStrategy struct {
+	// Service the strategy applies to. Unset for the default strategy.
+	Service string `yaml:"service,omitempty" json:"service,omitempty"`
+	// Type is probabilistic or ratelimiting.
+	Type string `yaml:"type" json:"type"`
+	// Param is the sampling probability of probabilistic strategies and the
+	// maximum traces per second of ratelimiting strategies.
+	Param float64 `yaml:"param" json:"param"`
+	// OperationStrategies are probabilistic strategies of individual
+	// operations of the service.
+	OperationStrategies []JaegerOperationSamplingStrategy `yaml:"operation_strategies,omitempty" json:"operation_strategies,omitempty"`
+}
+
+// JaegerOperationSamplingStrategy is the sampling strategy of an operation.
+type JaegerOperationSamplingStrategy struct {
+	Operation string  `yaml:"operation" json:"operation"`
+	Type      string  `yaml:"type" json:"type"`
+	Param     float64 `yaml:"param" json:"param"`
+}
+
+// validate checks the types of all strategies.
+func (s *JaegerSamplingStrategies) validate() error {
+	strategies := s.ServiceStrategies
+	if s.DefaultStrategy != nil {
+		strategies = append([]JaegerSamplingStrategy{*s.DefaultStrategy}, strategies...)
+	}
+	for _, strategy := range strategies {
+		if strategy.Type != "probabilistic" && strategy.Type != "ratelimiting" {
+			return fmt.Errorf("invalid strategy type %q of service %q, expected probabilistic or ratelimiting", strategy.Type, strategy.Service)
+		}
+		// Jaeger only supports probabilistic sampling of operations.
+		for _, op := range strategy.OperationStrategies {
+			if op.Type != "probabilistic" {
+				return fmt.Errorf("invalid strategy type %q of operation %q, expected probabilistic", op.Type, op.Operation)
+			}
+		}
+	}
+	return nil
 }
 
 // Configuration for Prometheus exporter: https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34/exporter/prometheusexporter/README.md.
@@ -205,6 +643,10 @@ type tailSamplingConfig struct {
 	Policies []map[string]interface{} `yaml:"policies"`
 	// DecisionWait defines the time to wait for a complete trace before making a decision
 	DecisionWait time.Duration `yaml:"decision_wait,omitempty"`
+	// NumTraces is the number of traces kept in memory while waiting for a decision
+	NumTraces uint64 `yaml:"num_traces,omitempty"`
+	// ExpectedNewTracesPerSec is used to allocate the buffers of traces waiting for a decision
+	ExpectedNewTracesPerSec uint64 `yaml:"expected_new_traces_per_sec,omitempty"`
 	// Port is the port the instance will use to receive load balanced traces
 	Port string `yaml:"port"`
 	// LoadBalancing is used to distribute spans of the same trace to the same agent instance
@@ -267,6 +709,33 @@ func exporter(remoteWriteConfig RemoteWriteConfig) (map[string]interface{}, erro
 		"retry_on_failure":     remoteWriteConfig.RetryOnFailure,
 	}
 
+	if tlsConfig := remoteWriteConfig.TLSConfig; tlsConfig != nil {
+		otlpExporter["ca_file"] = tlsConfig.CAFile
+		otlpExporter["cert_file"] = tlsConfig.CertFile
+		otlpExporter["key_file"] = tlsConfig.KeyFile
+		otlpExporter["server_name_override"] = tlsConfig.ServerName
+		if tlsConfig.InsecureSkipVerify {
+			otlpExporter["insecure_skip_verify"] = true
+		}
+	}
+
+	if oauth2 := remoteWriteConfig.OAuth2; oauth2 != nil {
+		secret := string(oauth2.ClientSecret)
+		if len(oauth2.ClientSecretFile) > 0 {
+			buff, err := ioutil.ReadFile(oauth2.ClientSecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load client secret file %s: %w", oauth2.ClientSecretFile, err)
+			}
+			secret = string(buff)
+		}
+
+		otlpExporter["client_id"] = oauth2.ClientID
+		otlpExporter["client_secret"] = secret
+		otlpExporter["token_url"] = oauth2.TokenURL
+		otlpExporter["scopes"] = oauth2.Scopes
+		otlpExporter["endpoint_params"] = oauth2.EndpointParams
+	}
+
 	// Apply some sane defaults to the exporter. The
 	// sending_queue.retry_on_failure default is 300s which prevents any
 	// sending-related errors to not be logged for 5 minutes. We'll lower that
@@ -295,9 +764,12 @@ func (c *InstanceConfig) exporters() (map[string]interface{}, error) {
 			SendingQueue:       c.PushConfig.SendingQueue,
 			RetryOnFailure:     c.PushConfig.RetryOnFailure,
 		})
+		if err != nil {
+			return nil, err
+		}
 		return map[string]interface{}{
-			"otlp": otlpExporter,
-		}, err
+			c.exporterType(): c.withTenantHeader(otlpExporter),
+		}, nil
 	}
 
 	exporters := map[string]interface{}{}
@@ -306,16 +778,54 @@ func (c *InstanceConfig) exporters() (map[string]interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		exporterName := fmt.Sprintf("otlp/%d", i)
-		exporters[exporterName] = exporter
+		exporterType := c.exporterType()
+		if remoteWriteConfig.OAuth2 != nil {
+			if c.Tenant != nil {
+				return nil, errors.New("remote_write.oauth2 can't be used with tenant")
+			}
+			exporterType = oauth2exporter.TypeStr
+		}
+		name := strconv.Itoa(i)
+		if remoteWriteConfig.Name != "" {
+			name = remoteWriteConfig.Name
+		}
+		exporterName := fmt.Sprintf("%s/%s", exporterType, name)
+		if _, exist := exporters[exporterName]; exist {
+			return nil, fmt.Errorf("found multiple remote_write backends with name %s", name)
+		}
+		exporters[exporterName] = c.withTenantHeader(exporter)
 	}
 	return exporters, nil
 }
 
+// exporterType returns the type of the exporters sending to the backends.
+// The tenant exporter is used when the tenant of spans must be sent.
+func (c *InstanceConfig) exporterType() string {
+	if c.Tenant != nil {
+		return tenantexporter.TypeStr
+	}
+	return "otlp"
+}
+
+// withTenantHeader sets the tenant header of a tenant exporter config.
+func (c *InstanceConfig) withTenantHeader(exporter map[string]interface{}) map[string]interface{} {
+	if c.Tenant != nil {
+		header := tenantexporter.DefaultTenantHeader
+		if c.Tenant.Header != "" {
+			header = c.Tenant.Header
+		}
+		exporter["tenant_header"] = header
+	}
+	return exporter
+}
+
 func resolver(config map[string]interface{}) (map[string]interface{}, error) {
 	if len(config) == 0 {
 		return nil, fmt.Errorf("must configure one resolver (dns or static)")
 	}
+	if len(config) > 1 {
+		return nil, errors.New("only one resolver (dns or static) can be configured")
+	}
 	resolverCfg := make(map[string]interface{})
 	for typ, cfg := range config {
 		switch typ {
@@ -354,31 +864,108 @@ func (c *InstanceConfig) loadBalancingExporter() (map[string]interface{}, error)
 
 // formatPolicies creates sampling policies (i.e. rules) compatible with OTel's tail sampling processor
 // https://github.com/open-telemetry/opentelemetry-collector-contrib/tree/v0.21.0/processor/tailsamplingprocessor
-func formatPolicies(cfg []map[string]interface{}) ([]map[string]interface{}, error) {
-	policies := make([]map[string]interface{}, 0, len(cfg))
+// formatPolicies converts policies to the policies of the tail sampling
+// processor. spanAttributes is true if a policy samples by the attributes
+// set by the sampling attributes processor.
+func formatPolicies(cfg []map[string]interface{}) (policies []map[string]interface{}, spanAttributes bool, err error) {
+	policies = make([]map[string]interface{}, 0, len(cfg))
 	for i, policy := range cfg {
 		if len(policy) != 1 {
-			return nil, errors.New("malformed sampling policy")
+			return nil, false, errors.New("malformed sampling policy")
 		}
 		for typ, rules := range policy {
+			name := fmt.Sprintf("%s/%d", typ, i)
 			switch typ {
 			case alwaysSamplePolicy:
 				policies = append(policies, map[string]interface{}{
-					"name": fmt.Sprintf("%s/%d", typ, i),
+					"name": name,
 					"type": typ,
 				})
 			case stringAttributePolicy, rateLimitingPolicy, numericAttributePolicy:
 				policies = append(policies, map[string]interface{}{
-					"name": fmt.Sprintf("%s/%d", typ, i),
+					"name": name,
 					"type": typ,
 					typ:    rules,
 				})
+			case latencyPolicy:
+				var latency struct {
+					ThresholdMs int64 `yaml:"threshold_ms"`
+				}
+				if err := convertPolicy(rules, &latency); err != nil {
+					return nil, false, fmt.Errorf("invalid latency policy: %w", err)
+				}
+				if latency.ThresholdMs <= 0 {
+					return nil, false, errors.New("latency policy threshold_ms must be greater than 0")
+				}
+				policies = append(policies, map[string]interface{}{
+					"name": name,
+					"type": numericAttributePolicy,
+					numericAttributePolicy: map[string]interface{}{
+						"key":       samplingattributesprocessor.DurationKey,
+						"min_value": latency.ThresholdMs,
+						"max_value": int64(math.MaxInt64),
+					},
+				})
+				spanAttributes = true
+			case statusCodePolicy:
+				var statusCode struct {
+					StatusCodes []string `yaml:"status_codes"`
+				}
+				if err := convertPolicy(rules, &statusCode); err != nil {
+					return nil, false, fmt.Errorf("invalid status_code policy: %w", err)
+				}
+				if len(statusCode.StatusCodes) == 0 {
+					return nil, false, errors.New("status_code policy must have at least one status code")
+				}
+				for _, code := range statusCode.StatusCodes {
+					if _, ok := samplingattributesprocessor.StatusCodes[code]; !ok {
+						return nil, false, fmt.Errorf("unsupported status code %q in status_code policy, expected UNSET, OK or ERROR", code)
+					}
+				}
+				policies = append(policies, map[string]interface{}{
+					"name": name,
+					"type": stringAttributePolicy,
+					stringAttributePolicy: map[string]interface{}{
+						"key":    samplingattributesprocessor.StatusCodeKey,
+						"values": statusCode.StatusCodes,
+					},
+				})
+				spanAttributes = true
 			default:
-				return nil, fmt.Errorf("unsupported policy type %s", typ)
+				return nil, false, fmt.Errorf("unsupported policy type %s", typ)
 			}
 		}
 	}
-	return policies, nil
+	return policies, spanAttributes, nil
+}
+
+// convertPolicy decodes the rules of a policy into out.
+func convertPolicy(rules interface{}, out interface{}) error {
+	bb, err := yaml.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(bb, out)
+}
+
+// validateBatch checks the options of the batch processor, which would
+// otherwise only be rejected when the pipeline starts, if at all.
+func validateBatch(cfg map[string]interface{}) error {
+	var batch struct {
+		Timeout          time.Duration `yaml:"timeout"`
+		SendBatchSize    uint32        `yaml:"send_batch_size"`
+		SendBatchMaxSize uint32        `yaml:"send_batch_max_size"`
+	}
+	if err := convertPolicy(cfg, &batch); err != nil {
+		return fmt.Errorf("invalid batch config: %w", err)
+	}
+	if batch.Timeout < 0 {
+		return errors.New("batch.timeout must not be negative")
+	}
+	if batch.SendBatchMaxSize != 0 && batch.SendBatchMaxSize < batch.SendBatchSize {
+		return errors.New("batch.send_batch_max_size must be greater than or equal to batch.send_batch_size")
+	}
+	return nil
 }
 
 func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
@@ -420,7 +1007,15 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 		processorNames = append(processorNames, "attributes")
 	}
 
+	if c.Span != nil {
+		processors["span"] = c.Span
+		processorNames = append(processorNames, "span")
+	}
+
 	if c.Batch != nil {
+		if err := validateBatch(c.Batch); err != nil {
+			return nil, err
+		}
 		processors["batch"] = c.Batch
 		processorNames = append(processorNames, "batch")
 	} else if c.PushConfig.Batch != nil {
@@ -428,6 +1023,7 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 		processorNames = append(processorNames, "batch")
 	}
 
+	spanMetricsExporter := defaultSpanMetricsExporter
 	if c.SpanMetrics != nil {
 		// Configure the metrics exporter.
 		namespace := "tempo_spanmetrics"
@@ -435,21 +1031,72 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 			namespace = fmt.Sprintf("%s_%s", c.SpanMetrics.MetricsExporter.Namespace, namespace)
 		}
 
-		exporters[defaultSpanMetricsExporter] = map[string]interface{}{
-			"endpoint":        c.SpanMetrics.MetricsExporter.Endpoint,
-			"namespace":       namespace,
-			"const_labels":    c.SpanMetrics.MetricsExporter.ConstLabels,
-			"send_timestamps": c.SpanMetrics.MetricsExporter.SendTimestamps,
+		if c.SpanMetrics.PromInstance != "" {
+			if c.SpanMetrics.MetricsExporter.Endpoint != "" {
+				return nil, errors.New("must not configure spanmetrics.metrics_exporter.endpoint and spanmetrics.prom_instance")
+			}
+
+			spanMetricsExporter = remotewriteexporter.TypeStr
+			exporters[spanMetricsExporter] = map[string]interface{}{
+				"prom_instance": c.SpanMetrics.PromInstance,
+				"namespace":     namespace,
+				"const_labels":  c.SpanMetrics.MetricsExporter.ConstLabels,
+			}
+		} else {
+			exporters[spanMetricsExporter] = map[string]interface{}{
+				"endpoint":        c.SpanMetrics.MetricsExporter.Endpoint,
+				"namespace":       namespace,
+				"const_labels":    c.SpanMetrics.MetricsExporter.ConstLabels,
+				"send_timestamps": c.SpanMetrics.MetricsExporter.SendTimestamps,
+			}
 		}
 
 		processorNames = append(processorNames, "spanmetrics")
 		processors["spanmetrics"] = map[string]interface{}{
-			"metrics_exporter":          defaultSpanMetricsExporter,
+			"metrics_exporter":          spanMetricsExporter,
 			"latency_histogram_buckets": c.SpanMetrics.LatencyHistogramBuckets,
 			"dimensions":                c.SpanMetrics.Dimensions,
 		}
 	}
 
+	if c.ServiceGraphs != nil {
+		if c.ServiceGraphs.PromInstance == "" {
+			return nil, errors.New("must set service_graphs.prom_instance")
+		}
+
+		exporters[serviceGraphsExporter] = map[string]interface{}{
+			"prom_instance": c.ServiceGraphs.PromInstance,
+			"namespace":     serviceGraphsNamespace,
+		}
+
+		processor := map[string]interface{}{
+			"metrics_exporter": serviceGraphsExporter,
+		}
+		if c.ServiceGraphs.Wait != 0 {
+			processor["wait"] = c.ServiceGraphs.Wait
+		}
+		if c.ServiceGraphs.MaxItems != 0 {
+			processor["max_items"] = c.ServiceGraphs.MaxItems
+		}
+		if len(c.ServiceGraphs.LatencyHistogramBuckets) != 0 {
+			processor["latency_histogram_buckets"] = c.ServiceGraphs.LatencyHistogramBuckets
+		}
+		processorNames = append(processorNames, servicegraphprocessor.TypeStr)
+		processors[servicegraphprocessor.TypeStr] = processor
+	}
+
+	if c.SamplingPercentage != nil {
+		if *c.SamplingPercentage < 0 || *c.SamplingPercentage > 100 {
+			return nil, errors.New("sampling_percentage must be between 0 and 100")
+		}
+
+		// traces are sampled last, so spanmetrics still sees every span.
+		processorNames = append(processorNames, probabilisticSamplerName)
+		processors[probabilisticSamplerName] = map[string]interface{}{
+			"sampling_percentage": *c.SamplingPercentage,
+		}
+	}
+
 	// receivers
 	receiverNames := []string{}
 	for name := range c.Receivers {
@@ -462,7 +1109,7 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 			wait = c.TailSampling.DecisionWait
 		}
 
-		policies, err := formatPolicies(c.TailSampling.Policies)
+		policies, spanAttributes, err := formatPolicies(c.TailSampling.Policies)
 		if err != nil {
 			return nil, err
 		}
@@ -470,10 +1117,28 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 		// tail_sampling should be executed before the batch processor
 		// TODO(mario.rodriguez): put attributes processor before tail_sampling. Maybe we want to sample on mutated spans
 		processorNames = append([]string{"tail_sampling"}, processorNames...)
-		processors["tail_sampling"] = map[string]interface{}{
+		tailSampling := map[string]interface{}{
 			"policies":      policies,
 			"decision_wait": wait,
 		}
+		if c.TailSampling.NumTraces != 0 {
+			tailSampling["num_traces"] = c.TailSampling.NumTraces
+		}
+		if c.TailSampling.ExpectedNewTracesPerSec != 0 {
+			tailSampling["expected_new_traces_per_sec"] = c.TailSampling.ExpectedNewTracesPerSec
+		}
+		processors["tail_sampling"] = tailSampling
+
+		// latency and status_code policies sample by attributes set on spans
+		// right before tail_sampling, which are removed right after it.
+		if spanAttributes {
+			removeName := samplingattributesprocessor.TypeStr + "/remove"
+			processorNames = append([]string{samplingattributesprocessor.TypeStr, processorNames[0], removeName}, processorNames[1:]...)
+			processors[samplingattributesprocessor.TypeStr] = map[string]interface{}{}
+			processors[removeName] = map[string]interface{}{
+				"remove": true,
+			}
+		}
 
 		if c.TailSampling.LoadBalancing != nil {
 			internalExporter, err := c.loadBalancingExporter()
@@ -496,13 +1161,203 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 		}
 	}
 
+	if c.SpanEventLogs != nil {
+		if c.SpanEventLogs.LokiName == "" {
+			return nil, errors.New("must set span_event_logs.loki_name")
+		}
+
+		rules := make([]map[string]interface{}, 0, len(c.SpanEventLogs.Rules))
+		processorRules := make([]spaneventlogsprocessor.Rule, 0, len(c.SpanEventLogs.Rules))
+		for _, r := range c.SpanEventLogs.Rules {
+			rules = append(rules, map[string]interface{}{
+				"event_name": r.EventName,
+				"attributes": r.Attributes,
+			})
+			processorRules = append(processorRules, spaneventlogsprocessor.Rule{
+				EventName:  r.EventName,
+				Attributes: r.Attributes,
+			})
+		}
+		if err := spaneventlogsprocessor.ValidateRules(processorRules); err != nil {
+			return nil, fmt.Errorf("invalid span_event_logs: %w", err)
+		}
+
+		resourceLabels, err := resourceLabelsConfig(c.SpanEventLogs.ResourceLabels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid span_event_logs: %w", err)
+		}
+
+		timeout := spaneventlogsprocessor.DefaultTimeout
+		if c.SpanEventLogs.Timeout != 0 {
+			timeout = c.SpanEventLogs.Timeout
+		}
+
+		// span events should be logged before tail_sampling so they're kept
+		// even when their trace is sampled away.
+		processorNames = append([]string{spaneventlogsprocessor.TypeStr}, processorNames...)
+		processors[spaneventlogsprocessor.TypeStr] = map[string]interface{}{
+			"loki_name":       c.SpanEventLogs.LokiName,
+			"rules":           rules,
+			"resource_labels": resourceLabels,
+			"timeout":         timeout,
+		}
+	}
+
+	if c.AutomaticLogging != nil {
+		backend := automaticloggingprocessor.BackendStdout
+		if c.AutomaticLogging.Backend != "" {
+			backend = c.AutomaticLogging.Backend
+		}
+		switch backend {
+		case automaticloggingprocessor.BackendStdout:
+		case automaticloggingprocessor.BackendLogs:
+			if c.AutomaticLogging.LokiName == "" {
+				return nil, errors.New("must set automatic_logging.loki_name with the logs backend")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported automatic_logging.backend %q, expected stdout or logs", backend)
+		}
+		if !c.AutomaticLogging.Roots && !c.AutomaticLogging.Errors {
+			return nil, errors.New("must enable at least one of automatic_logging.roots and automatic_logging.errors")
+		}
+
+		resourceLabels, err := resourceLabelsConfig(c.AutomaticLogging.ResourceLabels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid automatic_logging: %w", err)
+		}
+
+		timeout := automaticloggingprocessor.DefaultTimeout
+		if c.AutomaticLogging.Timeout != 0 {
+			timeout = c.AutomaticLogging.Timeout
+		}
+
+		// like span events, spans are logged before tail_sampling so they're
+		// kept even when their trace is sampled away.
+		processorNames = append([]string{automaticloggingprocessor.TypeStr}, processorNames...)
+		processors[automaticloggingprocessor.TypeStr] = map[string]interface{}{
+			"backend":             backend,
+			"loki_name":           c.AutomaticLogging.LokiName,
+			"roots":               c.AutomaticLogging.Roots,
+			"errors":              c.AutomaticLogging.Errors,
+			"span_attributes":     c.AutomaticLogging.SpanAttributes,
+			"resource_attributes": c.AutomaticLogging.ResourceAttributes,
+			"resource_labels":     resourceLabels,
+			"timeout":             timeout,
+		}
+	}
+
+	if c.SpanFilter != nil {
+		rules := make([]map[string]interface{}, 0, len(c.SpanFilter.Drop))
+		processorRules := make([]spanfilterprocessor.Rule, 0, len(c.SpanFilter.Drop))
+		for _, r := range c.SpanFilter.Drop {
+			attributes := make([]map[string]interface{}, 0, len(r.Attributes))
+			processorAttributes := make([]spanfilterprocessor.Attribute, 0, len(r.Attributes))
+			for _, a := range r.Attributes {
+				attributes = append(attributes, map[string]interface{}{
+					"key":   a.Key,
+					"value": a.Value,
+				})
+				processorAttributes = append(processorAttributes, spanfilterprocessor.Attribute{
+					Key:   a.Key,
+					Value: a.Value,
+				})
+			}
+
+			rule := map[string]interface{}{
+				"service":   r.Service,
+				"span_name": r.SpanName,
+			}
+			if len(attributes) > 0 {
+				rule["attributes"] = attributes
+			}
+			rules = append(rules, rule)
+			processorRules = append(processorRules, spanfilterprocessor.Rule{
+				Service:    r.Service,
+				SpanName:   r.SpanName,
+				Attributes: processorAttributes,
+			})
+		}
+		if err := spanfilterprocessor.ValidateRules(processorRules); err != nil {
+			return nil, fmt.Errorf("invalid span_filter: %w", err)
+		}
+
+		// spans are dropped before any processor logs, samples or batches
+		// them, but after attributes are scrubbed.
+		processorNames = append([]string{spanfilterprocessor.TypeStr}, processorNames...)
+		processors[spanfilterprocessor.TypeStr] = map[string]interface{}{
+			"drop": rules,
+		}
+	}
+
+	if c.Scrubbing != nil && len(c.Scrubbing.Rules) > 0 {
+		rules := make([]map[string]interface{}, 0, len(c.Scrubbing.Rules))
+		for _, r := range c.Scrubbing.Rules {
+			rules = append(rules, map[string]interface{}{
+				"attributes": r.Labels,
+				"regex":      r.Regex,
+				"action":     string(r.Action),
+			})
+		}
+
+		// attributes are scrubbed before any other processor can use them,
+		// but after the tenant is found.
+		processorNames = append([]string{scrubprocessor.TypeStr}, processorNames...)
+		processors[scrubprocessor.TypeStr] = map[string]interface{}{
+			"salt":  string(c.Scrubbing.Salt),
+			"rules": rules,
+		}
+	}
+
+	if c.Tenant != nil {
+		if c.Tenant.FromResourceAttribute == "" && c.Tenant.FromHeader == "" && c.Tenant.Default == "" {
+			return nil, errors.New("must set one of tenant.from_resource_attribute, tenant.from_header or tenant.default")
+		}
+		if c.Tenant.FromHeader != "" && c.TailSampling != nil && c.TailSampling.LoadBalancing != nil {
+			return nil, errors.New("tenant.from_header can't be used with tail_sampling.load_balancing, as headers aren't forwarded between agents")
+		}
+
+		// the tenant must be found first, while the context of the incoming
+		// request is still available.
+		processorNames = append([]string{tenantprocessor.TypeStr}, processorNames...)
+		processors[tenantprocessor.TypeStr] = map[string]interface{}{
+			"from_resource_attribute": c.Tenant.FromResourceAttribute,
+			"from_header":             c.Tenant.FromHeader,
+			"default":                 c.Tenant.Default,
+		}
+	}
+
+	// requests must be authenticated before any other processor runs, in
+	// the pipeline of the receivers.
+	var lbProcessorNames []string
+	if c.ReceiverAuth != nil {
+		tokens, err := c.ReceiverAuth.tokens()
+		if err != nil {
+			return nil, err
+		}
+		if names := unauthenticatedReceivers(c.Receivers); len(names) > 0 {
+			return nil, fmt.Errorf("receiver_auth can't be used with %s, as they don't receive the headers of requests", strings.Join(names, ", "))
+		}
+		if c.TailSampling != nil && c.TailSampling.LoadBalancing != nil {
+			lbProcessorNames = []string{receiverauthprocessor.TypeStr}
+		} else {
+			processorNames = append([]string{receiverauthprocessor.TypeStr}, processorNames...)
+		}
+		processors[receiverauthprocessor.TypeStr] = map[string]interface{}{
+			"bearer_tokens": tokens,
+		}
+	}
+
 	pipelines := make(map[string]interface{})
 	if c.TailSampling != nil && c.TailSampling.LoadBalancing != nil {
 		// load balancing pipeline
-		pipelines["traces/0"] = map[string]interface{}{
+		lbPipeline := map[string]interface{}{
 			"receivers": receiverNames,
 			"exporters": []string{"loadbalancing"},
 		}
+		if len(lbProcessorNames) > 0 {
+			lbPipeline["processors"] = lbProcessorNames
+		}
+		pipelines["traces/0"] = lbPipeline
 		// processing pipeline
 		pipelines["traces/1"] = map[string]interface{}{
 			"exporters":  exportersNames,
@@ -524,18 +1379,71 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 
 		pipelines[spanMetricsPipelineName] = map[string]interface{}{
 			"receivers": []string{noopreceiver.TypeStr},
-			"exporters": []string{defaultSpanMetricsExporter},
+			"exporters": []string{spanMetricsExporter},
+		}
+	}
+
+	if c.ServiceGraphs != nil {
+		c.Receivers[noopreceiver.TypeStr] = nil
+
+		pipelines[serviceGraphsPipelineName] = map[string]interface{}{
+			"receivers": []string{noopreceiver.TypeStr},
+			"exporters": []string{serviceGraphsExporter},
+		}
+	}
+
+	extensions := make(map[string]interface{})
+	if c.JaegerRemoteSampling != nil {
+		rs := c.JaegerRemoteSampling
+		if (rs.StrategyFile == "") == (rs.Strategies == nil) {
+			return nil, errors.New("must set exactly one of jaeger_remote_sampling.strategy_file or jaeger_remote_sampling.strategies")
+		}
+		if rs.ReloadInterval != 0 && rs.StrategyFile == "" {
+			return nil, errors.New("jaeger_remote_sampling.reload_interval can only be used with strategy_file")
+		}
+
+		var strategies string
+		if rs.Strategies != nil {
+			if err := rs.Strategies.validate(); err != nil {
+				return nil, fmt.Errorf("invalid jaeger_remote_sampling.strategies: %w", err)
+			}
+			buf, err := json.Marshal(rs.Strategies)
+			if err != nil {
+				return nil, err
+			}
+			strategies = string(buf)
+		}
+
+		endpoint := jaegerremotesamplingextension.DefaultEndpoint
+		if rs.ListenAddress != "" {
+			endpoint = rs.ListenAddress
+		}
+		extensions[jaegerremotesamplingextension.TypeStr] = map[string]interface{}{
+			"endpoint":        endpoint,
+			"strategy_file":   rs.StrategyFile,
+			"reload_interval": rs.ReloadInterval,
+			"strategies":      strategies,
 		}
 	}
 
 	otelMapStructure["exporters"] = exporters
 	otelMapStructure["processors"] = processors
 	otelMapStructure["receivers"] = c.Receivers
+	otelMapStructure["extensions"] = extensions
 
 	// pipelines
-	otelMapStructure["service"] = map[string]interface{}{
+	service := map[string]interface{}{
 		"pipelines": pipelines,
 	}
+	if len(extensions) > 0 {
+		extensionNames := make([]string, 0, len(extensions))
+		for name := range extensions {
+			extensionNames = append(extensionNames, name)
+		}
+		sort.Strings(extensionNames)
+		service["extensions"] = extensionNames
+	}
+	otelMapStructure["service"] = service
 
 	// now build the otel configmodel from the mapstructure
 	v := viper.New()
@@ -560,7 +1468,9 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 // tracingFactories() only creates the needed factories.  if we decide to add support for a new
 // processor, exporter, receiver we need to add it here
 func tracingFactories() (component.Factories, error) {
-	extensions, err := component.MakeExtensionFactoryMap()
+	extensions, err := component.MakeExtensionFactoryMap(
+		jaegerremotesamplingextension.NewFactory(),
+	)
 	if err != nil {
 		return component.Factories{}, err
 	}
@@ -581,6 +1491,9 @@ func tracingFactories() (component.Factories, error) {
 		otlpexporter.NewFactory(),
 		prometheusexporter.NewFactory(),
 		loadbalancingexporter.NewFactory(),
+		remotewriteexporter.NewFactory(),
+		tenantexporter.NewFactory(),
+		oauth2exporter.NewFactory(),
 	)
 	if err != nil {
 		return component.Factories{}, err
@@ -589,9 +1502,19 @@ func tracingFactories() (component.Factories, error) {
 	processors, err := component.MakeProcessorFactoryMap(
 		batchprocessor.NewFactory(),
 		attributesprocessor.NewFactory(),
+		automaticloggingprocessor.NewFactory(),
+		probabilisticsamplerprocessor.NewFactory(),
+		spanprocessor.NewFactory(),
 		promsdprocessor.NewFactory(),
+		samplingattributesprocessor.NewFactory(),
+		scrubprocessor.NewFactory(),
+		servicegraphprocessor.NewFactory(),
+		spaneventlogsprocessor.NewFactory(),
 		spanmetricsprocessor.NewFactory(),
 		tailsamplingprocessor.NewFactory(),
+		tenantprocessor.NewFactory(),
+		receiverauthprocessor.NewFactory(),
+		spanfilterprocessor.NewFactory(),
 	)
 	if err != nil {
 		return component.Factories{}, err
@@ -92,6 +92,158 @@ service:
       receivers: ["jaeger"]
 `,
 		},
+		{
+			name: "jaeger receiver protocols",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:14250
+      thrift_http:
+        endpoint: 0.0.0.0:14268
+      thrift_compact:
+        endpoint: 0.0.0.0:6831
+push_config:
+  endpoint: example.com:12345
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:14250
+      thrift_http:
+        endpoint: 0.0.0.0:14268
+      thrift_compact:
+        endpoint: 0.0.0.0:6831
+exporters:
+  otlp:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp"]
+      processors: []
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "jaeger receiver unknown protocol",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      thrift_udp:
+push_config:
+  endpoint: example.com:12345
+`,
+			expectedError: true,
+		},
+		{
+			name: "zipkin receiver",
+			cfg: `
+receivers:
+  zipkin:
+    endpoint: 0.0.0.0:9411
+    parse_string_tags: true
+push_config:
+  endpoint: example.com:12345
+`,
+			expectedConfig: `
+receivers:
+  zipkin:
+    endpoint: 0.0.0.0:9411
+    parse_string_tags: true
+exporters:
+  otlp:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp"]
+      processors: []
+      receivers: ["zipkin"]
+`,
+		},
+		{
+			name: "zipkin receiver unknown option",
+			cfg: `
+receivers:
+  zipkin:
+    parse_tags: true
+push_config:
+  endpoint: example.com:12345
+`,
+			expectedError: true,
+		},
+		{
+			name: "kafka receiver",
+			cfg: `
+receivers:
+  kafka:
+    brokers: ["broker-1:9092", "broker-2:9092"]
+    topic: spans
+    encoding: jaeger_proto
+    group_id: agent
+    auth:
+      sasl:
+        username: user
+        password: pass
+        mechanism: SCRAM-SHA-512
+      tls:
+        ca_file: /etc/kafka/ca.pem
+push_config:
+  endpoint: example.com:12345
+`,
+			expectedConfig: `
+receivers:
+  kafka:
+    brokers: ["broker-1:9092", "broker-2:9092"]
+    topic: spans
+    encoding: jaeger_proto
+    group_id: agent
+    auth:
+      sasl:
+        username: user
+        password: pass
+        mechanism: SCRAM-SHA-512
+      tls:
+        ca_file: /etc/kafka/ca.pem
+exporters:
+  otlp:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp"]
+      processors: []
+      receivers: ["kafka"]
+`,
+		},
+		{
+			name: "kafka receiver unknown auth",
+			cfg: `
+receivers:
+  kafka:
+    brokers: ["broker-1:9092"]
+    auth:
+      oauth:
+        token: secret
+push_config:
+  endpoint: example.com:12345
+`,
+			expectedError: true,
+		},
 		{
 			name: "push_config options",
 			cfg: `
@@ -180,6 +332,70 @@ service:
       exporters: ["otlp"]
       processors: ["attributes", "batch"]
       receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "span processor config",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+attributes:
+  actions:
+  - key: http.request.header.authorization
+    action: delete
+  - key: http.url
+    pattern: ^(?P<http_url_path>[^?]*)
+    action: extract
+  - key: http.url
+    from_attribute: http_url_path
+    action: update
+  - key: http_url_path
+    action: delete
+span:
+  name:
+    to_attributes:
+      rules:
+      - ^/api/users/(?P<user_id>.*)$
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  attributes:
+    actions:
+    - key: http.request.header.authorization
+      action: delete
+    - key: http.url
+      pattern: ^(?P<http_url_path>[^?]*)
+      action: extract
+    - key: http.url
+      from_attribute: http_url_path
+      action: update
+    - key: http_url_path
+      action: delete
+  span:
+    name:
+      to_attributes:
+        rules:
+        - ^/api/users/(?P<user_id>.*)$
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["attributes", "span"]
+      receivers: ["jaeger"]
 `,
 		},
 		{
@@ -275,6 +491,34 @@ service:
       exporters: ["otlp"]
       processors: []
       receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "remote_write without compression",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+    compression: none`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    retry_on_failure:
+      max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: []
+      receivers: ["jaeger"]
 `,
 		},
 		{
@@ -340,6 +584,71 @@ service:
       receivers: ["jaeger"]
 `,
 		},
+		{
+			name: "remote_write tls and oauth2",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+    tls_config:
+      ca_file: /etc/ca.pem
+      cert_file: /etc/cert.pem
+      key_file: /etc/key.pem
+      server_name: tempo.example.com
+    oauth2:
+      client_id: agent
+      client_secret: secret
+      token_url: https://auth.example.com/token
+      scopes: ["traces:write"]
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp_oauth2/0:
+    endpoint: example.com:12345
+    compression: gzip
+    ca_file: /etc/ca.pem
+    cert_file: /etc/cert.pem
+    key_file: /etc/key.pem
+    server_name_override: tempo.example.com
+    client_id: agent
+    client_secret: secret
+    token_url: https://auth.example.com/token
+    scopes: ["traces:write"]
+    retry_on_failure:
+      max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp_oauth2/0"]
+      processors: []
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "remote_write oauth2 with tenant",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+    oauth2:
+      client_id: agent
+      client_secret: secret
+      token_url: https://auth.example.com/token
+tenant:
+  from_resource_attribute: tenant
+`,
+			expectedError: true,
+		},
 		{
 			name: "two backends in a remote_write block",
 			cfg: `
@@ -397,17 +706,21 @@ service:
 `,
 		},
 		{
-			name: "batch block",
+			name: "named backends in a remote_write block",
 			cfg: `
 receivers:
   jaeger:
     protocols:
       grpc:
 remote_write:
-  - endpoint: example.com:12345
-batch:
-  timeout: 5s
-  send_batch_size: 100
+  - name: tempo
+    endpoint: example.com:12345
+    sending_queue:
+      queue_size: 10000
+  - endpoint: vendor.example.com:4317
+    compression: none
+    retry_on_failure:
+      max_elapsed_time: 10m
 `,
 			expectedConfig: `
 receivers:
@@ -415,25 +728,27 @@ receivers:
     protocols:
       grpc:
 exporters:
-  otlp/0:
+  otlp/tempo:
     endpoint: example.com:12345
     compression: gzip
     retry_on_failure:
       max_elapsed_time: 60s
-processors:
-  batch:
-    timeout: 5s
-    send_batch_size: 100
+    sending_queue:
+      queue_size: 10000
+  otlp/1:
+    endpoint: vendor.example.com:4317
+    retry_on_failure:
+      max_elapsed_time: 10m
 service:
   pipelines:
     traces:
-      exporters: ["otlp/0"]
-      processors: ["batch"]
+      exporters: ["otlp/tempo", "otlp/1"]
+      processors: []
       receivers: ["jaeger"]
 `,
 		},
 		{
-			name: "span metrics prometheus exporter",
+			name: "duplicate remote_write names",
 			cfg: `
 receivers:
   jaeger:
@@ -441,55 +756,954 @@ receivers:
       grpc:
 remote_write:
   - endpoint: example.com:12345
-spanmetrics:
-  latency_histogram_buckets: [2ms, 6ms, 10ms, 100ms, 250ms]
-  dimensions:
-    - name: http.method
-      default: GET
-    - name: http.status_code
-  metrics_exporter:
-    endpoint: "0.0.0.0:8889"
-    namespace: promexample
+  - name: "0"
+    endpoint: vendor.example.com:4317
 `,
-			expectedConfig: `
+			expectedError: true,
+		},
+		{
+			name: "batch max size below batch size",
+			cfg: `
 receivers:
-  noop:
   jaeger:
     protocols:
       grpc:
-exporters:
-  otlp/0:
-    endpoint: example.com:12345
-    compression: gzip
-    retry_on_failure:
-      max_elapsed_time: 60s
-  prometheus:
-    endpoint: "0.0.0.0:8889"
-    namespace: promexample_tempo_spanmetrics
-processors:
-  spanmetrics:
-    metrics_exporter: prometheus
-    latency_histogram_buckets: [2ms, 6ms, 10ms, 100ms, 250ms]
-    dimensions:
-      - name: http.method
-        default: GET
-      - name: http.status_code
-service:
-  pipelines:
-    traces:
-      exporters: ["otlp/0"]
-      processors: ["spanmetrics"]
-      receivers: ["jaeger"]
-    metrics/spanmetrics:
-      exporters: ["prometheus"]
-      receivers: ["noop"]
-`,
+remote_write:
+  - endpoint: example.com:12345
+batch:
+  send_batch_size: 1000
+  send_batch_max_size: 100
+`,
+			expectedError: true,
+		},
+		{
+			name: "unknown batch option",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+batch:
+  send_batch_maxsize: 100
+`,
+			expectedError: true,
+		},
+		{
+			name: "batch block",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+batch:
+  timeout: 5s
+  send_batch_size: 100
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  batch:
+    timeout: 5s
+    send_batch_size: 100
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["batch"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "span metrics prometheus exporter",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+spanmetrics:
+  latency_histogram_buckets: [2ms, 6ms, 10ms, 100ms, 250ms]
+  dimensions:
+    - name: http.method
+      default: GET
+    - name: http.status_code
+  metrics_exporter:
+    endpoint: "0.0.0.0:8889"
+    namespace: promexample
+`,
+			expectedConfig: `
+receivers:
+  noop:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+  prometheus:
+    endpoint: "0.0.0.0:8889"
+    namespace: promexample_tempo_spanmetrics
+processors:
+  spanmetrics:
+    metrics_exporter: prometheus
+    latency_histogram_buckets: [2ms, 6ms, 10ms, 100ms, 250ms]
+    dimensions:
+      - name: http.method
+        default: GET
+      - name: http.status_code
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["spanmetrics"]
+      receivers: ["jaeger"]
+    metrics/spanmetrics:
+      exporters: ["prometheus"]
+      receivers: ["noop"]
+`,
+		},
+		{
+			name: "span metrics prom instance",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+spanmetrics:
+  prom_instance: traces
+  metrics_exporter:
+    const_labels:
+      cluster: us-east
+`,
+			expectedConfig: `
+receivers:
+  noop:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+  remote_write:
+    prom_instance: traces
+    namespace: tempo_spanmetrics
+    const_labels:
+      cluster: us-east
+processors:
+  spanmetrics:
+    metrics_exporter: remote_write
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["spanmetrics"]
+      receivers: ["jaeger"]
+    metrics/spanmetrics:
+      exporters: ["remote_write"]
+      receivers: ["noop"]
+`,
+		},
+		{
+			name: "span metrics prom instance and endpoint",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+spanmetrics:
+  prom_instance: traces
+  metrics_exporter:
+    endpoint: "0.0.0.0:8889"
+`,
+			expectedError: true,
+		},
+		{
+			name: "service graphs",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+service_graphs:
+  prom_instance: traces
+  wait: 5s
+`,
+			expectedConfig: `
+receivers:
+  noop:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+  remote_write/service_graphs:
+    prom_instance: traces
+    namespace: traces_service_graph
+processors:
+  service_graphs:
+    metrics_exporter: remote_write/service_graphs
+    wait: 5s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["service_graphs"]
+      receivers: ["jaeger"]
+    metrics/service_graphs:
+      exporters: ["remote_write/service_graphs"]
+      receivers: ["noop"]
+`,
+		},
+		{
+			name: "service graphs without prom instance",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+service_graphs:
+  wait: 5s
+`,
+			expectedError: true,
+		},
+		{
+			name: "tail sampling config",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+tail_sampling:
+  policies:
+    - always_sample:
+    - string_attribute:
+        key: key
+        values:
+          - value1
+          - value2
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  tail_sampling:
+    decision_wait: 5s
+    policies:
+      - name: always_sample/0
+        type: always_sample
+      - name: string_attribute/1
+        type: string_attribute
+        string_attribute:
+          key: key
+          values:
+            - value1
+            - value2
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["tail_sampling"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "tail sampling latency and status code",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+batch:
+  timeout: 5s
+tail_sampling:
+  decision_wait: 10s
+  num_traces: 1000
+  expected_new_traces_per_sec: 100
+  policies:
+    - latency:
+        threshold_ms: 5000
+    - status_code:
+        status_codes: [ERROR]
+    - rate_limiting:
+        spans_per_second: 35
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  sampling_attributes:
+  sampling_attributes/remove:
+    remove: true
+  tail_sampling:
+    decision_wait: 10s
+    num_traces: 1000
+    expected_new_traces_per_sec: 100
+    policies:
+      - name: latency/0
+        type: numeric_attribute
+        numeric_attribute:
+          key: grafana_agent.duration_ms
+          min_value: 5000
+          max_value: 9223372036854775807
+      - name: status_code/1
+        type: string_attribute
+        string_attribute:
+          key: grafana_agent.status_code
+          values: [ERROR]
+      - name: rate_limiting/2
+        type: rate_limiting
+        rate_limiting:
+          spans_per_second: 35
+  batch:
+    timeout: 5s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["sampling_attributes", "tail_sampling", "sampling_attributes/remove", "batch"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "tail sampling invalid status code",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+tail_sampling:
+  policies:
+    - status_code:
+        status_codes: [FAILED]
+`,
+			expectedError: true,
+		},
+		{
+			name: "tail sampling latency without threshold",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+tail_sampling:
+  policies:
+    - latency:
+`,
+			expectedError: true,
+		},
+		{
+			name: "tail sampling config with load balancing",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+tail_sampling:
+  policies:
+    - always_sample:
+    - string_attribute:
+        key: key
+        values:
+          - value1
+          - value2
+  load_balancing:
+    exporter:
+      insecure: true
+    resolver:
+      dns:
+        hostname: agent
+        port: 4318
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+  otlp/lb:
+    protocols:
+      grpc:
+        endpoint: "0.0.0.0:4318"
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+  loadbalancing:
+    protocol:
+      otlp:
+        insecure: true
+        endpoint: noop
+        retry_on_failure:
+          max_elapsed_time: 60s
+    resolver:
+      dns:
+        hostname: agent
+        port: 4318
+processors:
+  tail_sampling:
+    decision_wait: 5s
+    policies:
+      - name: always_sample/0
+        type: always_sample
+      - name: string_attribute/1
+        type: string_attribute
+        string_attribute:
+          key: key
+          values:
+            - value1
+            - value2
+service:
+  pipelines:
+    traces/0:
+      exporters: ["loadbalancing"]
+      receivers: ["jaeger"]
+    traces/1:
+      exporters: ["otlp/0"]
+      processors: ["tail_sampling"]
+      receivers: ["otlp/lb"]
+`,
+		},
+		{
+			name: "span filter",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+batch:
+  timeout: 5s
+automatic_logging:
+  roots: true
+span_filter:
+  drop:
+    - span_name: /healthz
+    - service: frontend
+      attributes:
+        - key: http.target
+          value: /metrics
+        - key: k8s.pod.name
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  batch:
+    timeout: 5s
+  automatic_logging:
+    backend: stdout
+    roots: true
+    timeout: 1ms
+  span_filter:
+    drop:
+      - span_name: /healthz
+      - service: frontend
+        attributes:
+          - key: http.target
+            value: /metrics
+          - key: k8s.pod.name
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["span_filter", "automatic_logging", "batch"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "span filter with empty rule",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+span_filter:
+  drop:
+    - attributes: []
+`,
+			expectedError: true,
+		},
+		{
+			name: "span filter with invalid regex",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+span_filter:
+  drop:
+    - span_name: "("
+`,
+			expectedError: true,
+		},
+		{
+			name: "span event logs",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+span_event_logs:
+  loki_name: default
+  rules:
+    - event_name: exception
+      attributes: [exception.message, exception.stacktrace]
+    - event_name: cache_.*
+  resource_labels:
+    k8s.pod.name: pod
+    k8s.namespace.name: namespace
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  span_event_logs:
+    loki_name: default
+    timeout: 1ms
+    rules:
+      - event_name: exception
+        attributes: [exception.message, exception.stacktrace]
+      - event_name: cache_.*
+    resource_labels:
+      - attribute: k8s.namespace.name
+        label: namespace
+      - attribute: k8s.pod.name
+        label: pod
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["span_event_logs"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "automatic logging",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+automatic_logging:
+  backend: logs
+  loki_name: default
+  roots: true
+  errors: true
+  span_attributes: [http.status_code]
+  resource_attributes: [k8s.pod.name]
+  resource_labels:
+    k8s.namespace.name: namespace
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  automatic_logging:
+    backend: logs
+    loki_name: default
+    roots: true
+    errors: true
+    span_attributes: [http.status_code]
+    resource_attributes: [k8s.pod.name]
+    resource_labels:
+      - attribute: k8s.namespace.name
+        label: namespace
+    timeout: 1ms
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["automatic_logging"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "automatic logging without selected spans",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+automatic_logging:
+  backend: stdout
+`,
+			expectedError: true,
+		},
+		{
+			name: "automatic logging to logs without loki_name",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+automatic_logging:
+  backend: logs
+  roots: true
+`,
+			expectedError: true,
+		},
+		{
+			name: "automatic logging with reserved resource label",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+automatic_logging:
+  roots: true
+  resource_labels:
+    service.namespace: service
+`,
+			expectedError: true,
+		},
+		{
+			name: "span event logs without loki_name",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+span_event_logs:
+  rules:
+    - event_name: exception
+`,
+			expectedError: true,
+		},
+		{
+			name: "span event logs with invalid rule",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+span_event_logs:
+  loki_name: default
+  rules:
+    - event_name: "("
+`,
+			expectedError: true,
+		},
+		{
+			name: "span event logs with reserved resource label",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+span_event_logs:
+  loki_name: default
+  resource_labels:
+    service.namespace: service
+`,
+			expectedError: true,
+		},
+		{
+			name: "tenant",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+batch:
+  timeout: 5s
+tenant:
+  from_resource_attribute: tenant
+  from_header: x-scope-orgid
+  default: anonymous
+`,
+			expectedConfig: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+exporters:
+  otlp_tenant/0:
+    endpoint: example.com:12345
+    compression: gzip
+    tenant_header: X-Scope-OrgID
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  tenant:
+    from_resource_attribute: tenant
+    from_header: x-scope-orgid
+    default: anonymous
+  batch:
+    timeout: 5s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp_tenant/0"]
+      processors: ["tenant", "batch"]
+      receivers: ["otlp"]
+`,
+		},
+		{
+			name: "tenant with custom header",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+push_config:
+  endpoint: example.com:12345
+tenant:
+  from_resource_attribute: tenant
+  header: X-Tenant
+`,
+			expectedConfig: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+exporters:
+  otlp_tenant:
+    endpoint: example.com:12345
+    compression: gzip
+    tenant_header: X-Tenant
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  tenant:
+    from_resource_attribute: tenant
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp_tenant"]
+      processors: ["tenant"]
+      receivers: ["otlp"]
+`,
+		},
+		{
+			name: "scrubbing",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+batch:
+  timeout: 5s
+tenant:
+  default: anonymous
+scrubbing:
+  salt: secret
+  rules:
+    - labels: [enduser.id]
+    - labels: [net.peer.ip]
+      regex: 10\..*
+      action: drop
+`,
+			expectedConfig: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+exporters:
+  otlp_tenant/0:
+    endpoint: example.com:12345
+    compression: gzip
+    tenant_header: X-Scope-OrgID
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  tenant:
+    default: anonymous
+  scrub:
+    salt: secret
+    rules:
+      - attributes: [enduser.id]
+        regex: .*
+        action: hash
+      - attributes: [net.peer.ip]
+        regex: 10\..*
+        action: drop
+  batch:
+    timeout: 5s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp_tenant/0"]
+      processors: ["tenant", "scrub", "batch"]
+      receivers: ["otlp"]
+`,
+		},
+		{
+			name: "sampling percentage",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+batch:
+  timeout: 5s
+sampling_percentage: 12.5
+`,
+			expectedConfig: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  batch:
+    timeout: 5s
+  probabilistic_sampler:
+    sampling_percentage: 12.5
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["batch", "probabilistic_sampler"]
+      receivers: ["otlp"]
+`,
+		},
+		{
+			name: "sampling percentage out of range",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+sampling_percentage: 120
+`,
+			expectedError: true,
 		},
 		{
-			name: "tail sampling config",
+			name: "tenant without source",
 			cfg: `
 receivers:
-  jaeger:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+tenant:
+  header: X-Tenant
+`,
+			expectedError: true,
+		},
+		{
+			name: "load balancing with multiple resolvers",
+			cfg: `
+receivers:
+  otlp:
     protocols:
       grpc:
 remote_write:
@@ -497,49 +1711,80 @@ remote_write:
 tail_sampling:
   policies:
     - always_sample:
-    - string_attribute:
-        key: key
-        values:
-          - value1
-          - value2
+  load_balancing:
+    resolver:
+      static:
+        hostnames: ["agent1"]
+      dns:
+        hostname: agent
+`,
+			expectedError: true,
+		},
+		{
+			name: "tenant from header with load balancing",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+tail_sampling:
+  policies:
+    - always_sample:
+  load_balancing:
+    resolver:
+      static:
+        hostnames: ["agent1"]
+tenant:
+  from_header: x-scope-orgid
 `,
+			expectedError: true,
+		},
+		{
+			name: "receiver auth",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+tenant:
+  from_header: x-scope-orgid
+receiver_auth:
+  bearer_tokens: ["token"]
+  bearer_token_file: ` + tmpfile.Name(),
 			expectedConfig: `
 receivers:
-  jaeger:
+  otlp:
     protocols:
       grpc:
 exporters:
-  otlp/0:
+  otlp_tenant/0:
     endpoint: example.com:12345
     compression: gzip
+    tenant_header: X-Scope-OrgID
     retry_on_failure:
       max_elapsed_time: 60s
 processors:
-  tail_sampling:
-    decision_wait: 5s
-    policies:
-      - name: always_sample/0
-        type: always_sample
-      - name: string_attribute/1
-        type: string_attribute
-        string_attribute:
-          key: key
-          values:
-            - value1
-            - value2
+  receiver_auth:
+    bearer_tokens: ["token", "password_in_file"]
+  tenant:
+    from_header: x-scope-orgid
 service:
   pipelines:
     traces:
-      exporters: ["otlp/0"]
-      processors: ["tail_sampling"]
-      receivers: ["jaeger"]
+      exporters: ["otlp_tenant/0"]
+      processors: ["receiver_auth", "tenant"]
+      receivers: ["otlp"]
 `,
 		},
 		{
-			name: "tail sampling config with load balancing",
+			name: "receiver auth with load balancing",
 			cfg: `
 receivers:
-  jaeger:
+  otlp:
     protocols:
       grpc:
 remote_write:
@@ -547,22 +1792,16 @@ remote_write:
 tail_sampling:
   policies:
     - always_sample:
-    - string_attribute:
-        key: key
-        values:
-          - value1
-          - value2
   load_balancing:
-    exporter:
-      insecure: true
     resolver:
-      dns:
-        hostname: agent
-        port: 4318
+      static:
+        hostnames: ["agent1"]
+receiver_auth:
+  bearer_tokens: ["token"]
 `,
 			expectedConfig: `
 receivers:
-  jaeger:
+  otlp:
     protocols:
       grpc:
   otlp/lb:
@@ -578,38 +1817,191 @@ exporters:
   loadbalancing:
     protocol:
       otlp:
-        insecure: true
         endpoint: noop
         retry_on_failure:
           max_elapsed_time: 60s
     resolver:
-      dns:
-        hostname: agent
-        port: 4318
+      static:
+        hostnames: ["agent1"]
 processors:
+  receiver_auth:
+    bearer_tokens: ["token"]
   tail_sampling:
     decision_wait: 5s
     policies:
       - name: always_sample/0
         type: always_sample
-      - name: string_attribute/1
-        type: string_attribute
-        string_attribute:
-          key: key
-          values:
-            - value1
-            - value2
 service:
   pipelines:
     traces/0:
       exporters: ["loadbalancing"]
-      receivers: ["jaeger"]
+      processors: ["receiver_auth"]
+      receivers: ["otlp"]
     traces/1:
       exporters: ["otlp/0"]
       processors: ["tail_sampling"]
       receivers: ["otlp/lb"]
 `,
 		},
+		{
+			name: "receiver auth without tokens",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+receiver_auth: {}
+`,
+			expectedError: true,
+		},
+		{
+			name: "receiver auth with jaeger thrift_http",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+      thrift_http:
+remote_write:
+  - endpoint: example.com:12345
+receiver_auth:
+  bearer_tokens: ["token"]
+`,
+			expectedError: true,
+		},
+		{
+			name: "receiver auth with zipkin",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+  zipkin:
+remote_write:
+  - endpoint: example.com:12345
+receiver_auth:
+  bearer_tokens: ["token"]
+`,
+			expectedError: true,
+		},
+		{
+			name: "jaeger remote sampling",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+jaeger_remote_sampling:
+  listen_address: 127.0.0.1:5778
+  strategies:
+    default_strategy:
+      type: probabilistic
+      param: 0.5
+    service_strategies:
+      - service: frontend
+        type: ratelimiting
+        param: 10
+        operation_strategies:
+          - operation: /healthz
+            type: probabilistic
+            param: 0
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+extensions:
+  jaeger_remote_sampling:
+    endpoint: 127.0.0.1:5778
+    strategies: '{"default_strategy":{"type":"probabilistic","param":0.5},"service_strategies":[{"service":"frontend","type":"ratelimiting","param":10,"operation_strategies":[{"operation":"/healthz","type":"probabilistic","param":0}]}]}'
+service:
+  extensions: ["jaeger_remote_sampling"]
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: []
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "jaeger remote sampling strategy file",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+jaeger_remote_sampling:
+  strategy_file: /etc/agent/strategies.json
+  reload_interval: 1m
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+extensions:
+  jaeger_remote_sampling:
+    endpoint: 0.0.0.0:5778
+    strategy_file: /etc/agent/strategies.json
+    reload_interval: 1m
+service:
+  extensions: ["jaeger_remote_sampling"]
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: []
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "jaeger remote sampling without strategies",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+jaeger_remote_sampling:
+  listen_address: 127.0.0.1:5778
+`,
+			expectedError: true,
+		},
+		{
+			name: "jaeger remote sampling with invalid strategy type",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+jaeger_remote_sampling:
+  strategies:
+    default_strategy:
+      type: always
+`,
+			expectedError: true,
+		},
 	}
 
 	for _, tc := range tt {
@@ -658,3 +2050,130 @@ func sortPipelines(cfg *configmodels.Config) {
 		sort.Strings(p.Processors)
 	}
 }
+
+func TestRemoteWriteConfig_OAuth2(t *testing.T) {
+	tt := []struct {
+		name        string
+		cfg         string
+		expectedErr string
+	}{
+		{
+			name: "with basic_auth",
+			cfg: `
+endpoint: example.com:12345
+basic_auth:
+  username: agent
+oauth2:
+  client_id: agent
+  token_url: https://auth.example.com/token
+`,
+			expectedErr: "at most one of basic_auth and oauth2 must be configured",
+		},
+		{
+			name: "missing token_url",
+			cfg: `
+endpoint: example.com:12345
+oauth2:
+  client_id: agent
+`,
+			expectedErr: "oauth2 requires client_id and token_url",
+		},
+		{
+			name: "secret and secret file",
+			cfg: `
+endpoint: example.com:12345
+oauth2:
+  client_id: agent
+  client_secret: secret
+  client_secret_file: /etc/secret
+  token_url: https://auth.example.com/token
+`,
+			expectedErr: "at most one of oauth2.client_secret and oauth2.client_secret_file must be configured",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg RemoteWriteConfig
+			err := yaml.Unmarshal([]byte(tc.cfg), &cfg)
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tt := []struct {
+		name        string
+		cfg         string
+		expectedErr string
+	}{
+		{
+			name: "distinct endpoints",
+			cfg: `
+configs:
+  - name: a
+    receivers:
+      otlp:
+        protocols:
+          grpc:
+            endpoint: 0.0.0.0:4317
+  - name: b
+    receivers:
+      otlp:
+        protocols:
+          grpc:
+            endpoint: 0.0.0.0:5317
+`,
+		},
+		{
+			name: "shared endpoint",
+			cfg: `
+configs:
+  - name: a
+    receivers:
+      jaeger:
+        protocols:
+          thrift_http:
+            endpoint: :14268
+  - name: b
+    receivers:
+      zipkin:
+        endpoint: 0.0.0.0:14268
+`,
+			expectedErr: "tempo configs a and b both listen on 0.0.0.0:14268",
+		},
+		{
+			name: "shared load balancing port",
+			cfg: `
+configs:
+  - name: a
+    receivers:
+      otlp:
+        protocols:
+          grpc:
+            endpoint: 0.0.0.0:4318
+  - name: b
+    tail_sampling:
+      policies:
+        - always_sample:
+      load_balancing:
+        resolver:
+          static:
+            hostnames: ["agent1"]
+`,
+			expectedErr: "tempo configs a and b both listen on 0.0.0.0:4318",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			err := yaml.Unmarshal([]byte(tc.cfg), &cfg)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
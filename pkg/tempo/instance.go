@@ -7,9 +7,11 @@ import (
 	"time"
 
 	"github.com/grafana/agent/pkg/build"
+	"github.com/grafana/agent/pkg/loki"
+	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/grafana/agent/pkg/tempo/spaneventlogsprocessor"
 	"github.com/grafana/agent/pkg/util"
-	"github.com/prometheus/client_golang/prometheus"
-	"go.opencensus.io/stats/view"
+	"github.com/prometheus/prometheus/storage"
 	"go.uber.org/zap"
 
 	"go.opentelemetry.io/collector/component"
@@ -19,26 +21,26 @@ import (
 
 // Instance wraps the OpenTelemetry collector to enable tracing pipelines
 type Instance struct {
-	mut         sync.Mutex
-	cfg         InstanceConfig
-	logger      *zap.Logger
-	metricViews []*view.View
-
-	exporter  builder.Exporters
-	pipelines builder.BuiltPipelines
-	receivers builder.Receivers
+	mut     sync.Mutex
+	cfg     InstanceConfig
+	logger  *zap.Logger
+	logs    *loki.Loki
+	metrics instance.Manager
+
+	extensions builder.Extensions
+	exporter   builder.Exporters
+	pipelines  builder.BuiltPipelines
+	receivers  builder.Receivers
 }
 
-// NewInstance creates and starts an instance of tracing pipelines.
-func NewInstance(reg prometheus.Registerer, cfg InstanceConfig, logger *zap.Logger) (*Instance, error) {
-	var err error
-
+// NewInstance creates and starts an instance of tracing pipelines. logs is
+// used to send span events as log lines and metrics is used to write span
+// metrics to Prometheus instances; both may be nil.
+func NewInstance(cfg InstanceConfig, logs *loki.Loki, metrics instance.Manager, logger *zap.Logger) (*Instance, error) {
 	instance := &Instance{}
 	instance.logger = logger
-	instance.metricViews, err = newMetricViews(reg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create metric views: %w", err)
-	}
+	instance.logs = logs
+	instance.metrics = metrics
 
 	if err := instance.ApplyConfig(cfg); err != nil {
 		return nil, err
@@ -75,7 +77,6 @@ func (i *Instance) Stop() {
 	defer i.mut.Unlock()
 
 	i.stop()
-	view.Unregister(i.metricViews...)
 }
 
 func (i *Instance) stop() {
@@ -113,6 +114,15 @@ func (i *Instance) stop() {
 				return i.exporter.ShutdownAll(shutdownCtx)
 			},
 		},
+		{
+			name: "extensions",
+			shutdown: func() error {
+				if i.extensions == nil {
+					return nil
+				}
+				return i.extensions.ShutdownAll(shutdownCtx)
+			},
+		},
 	}
 
 	for _, dep := range dependencies {
@@ -125,6 +135,7 @@ func (i *Instance) stop() {
 	i.receivers = nil
 	i.pipelines = nil
 	i.exporter = nil
+	i.extensions = nil
 }
 
 func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig) error {
@@ -149,6 +160,17 @@ func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig
 		Version:  build.Version,
 	}
 
+	// start extensions
+	i.extensions, err = builder.NewExtensionsBuilder(i.logger, appinfo, otelConfig, factories.Extensions).Build()
+	if err != nil {
+		return fmt.Errorf("failed to create extensions builder: %w", err)
+	}
+
+	err = i.extensions.StartAll(ctx, i)
+	if err != nil {
+		return fmt.Errorf("failed to start extensions: %w", err)
+	}
+
 	// start exporter
 	i.exporter, err = builder.NewExportersBuilder(i.logger, appinfo, otelConfig, factories.Exporters).Build()
 	if err != nil {
@@ -197,9 +219,32 @@ func (i *Instance) GetFactory(kind component.Kind, componentType configmodels.Ty
 
 // GetExtensions implements component.Host
 func (i *Instance) GetExtensions() map[configmodels.Extension]component.ServiceExtension {
+	return i.extensions.ToMap()
+}
+
+// LogsInstance implements spaneventlogsprocessor.Host
+func (i *Instance) LogsInstance(name string) spaneventlogsprocessor.EntrySender {
+	if i.logs == nil {
+		return nil
+	}
+	if inst := i.logs.Instance(name); inst != nil {
+		return inst
+	}
 	return nil
 }
 
+// MetricsInstance implements remotewriteexporter.Host
+func (i *Instance) MetricsInstance(name string) storage.Appendable {
+	if i.metrics == nil {
+		return nil
+	}
+	inst, err := i.metrics.GetInstance(name)
+	if err != nil {
+		return nil
+	}
+	return inst
+}
+
 // GetExporters implements component.Host
 func (i *Instance) GetExporters() map[configmodels.DataType]map[configmodels.Exporter]component.Exporter {
 	// SpanMetricsProcessor needs to get the configured exporters.
@@ -0,0 +1,117 @@
+// Package jaegerremotesamplingextension implements an OpenTelemetry
+// extension serving sampling strategies to Jaeger clients over the HTTP API
+// of the Jaeger agent, so clients can fetch them from their local agent.
+package jaegerremotesamplingextension
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/jaegertracing/jaeger/cmd/agent/app/httpserver"
+	"github.com/jaegertracing/jaeger/cmd/collector/app/sampling/strategystore"
+	"github.com/jaegertracing/jaeger/pkg/clientcfg/clientcfghttp"
+	"github.com/jaegertracing/jaeger/plugin/sampling/strategystore/static"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.opentelemetry.io/collector/component"
+	"go.uber.org/zap"
+)
+
+type remoteSamplingExtension struct {
+	cfg    Config
+	logger *zap.Logger
+
+	store strategystore.StrategyStore
+	srv   *http.Server
+}
+
+func newExtension(params component.ExtensionCreateParams, cfg *Config) (component.ServiceExtension, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("endpoint must be set")
+	}
+	if cfg.StrategyFile == "" && cfg.Strategies == "" {
+		return nil, errors.New("one of strategy_file or strategies must be set")
+	}
+	if cfg.StrategyFile != "" && cfg.Strategies != "" {
+		return nil, errors.New("strategy_file and strategies can't be used together")
+	}
+	if cfg.Strategies != "" && !json.Valid([]byte(cfg.Strategies)) {
+		return nil, errors.New("strategies must be a JSON document")
+	}
+
+	return &remoteSamplingExtension{
+		cfg:    *cfg,
+		logger: params.Logger,
+	}, nil
+}
+
+// Start is invoked during service startup.
+func (e *remoteSamplingExtension) Start(_ context.Context, host component.Host) error {
+	store, err := e.newStrategyStore()
+	if err != nil {
+		return fmt.Errorf("failed to load sampling strategies: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", e.cfg.Endpoint)
+	if err != nil {
+		closeStore(store)
+		return fmt.Errorf("failed to listen on %s: %w", e.cfg.Endpoint, err)
+	}
+
+	e.store = store
+	e.srv = httpserver.NewHTTPServer(e.cfg.Endpoint, &clientcfghttp.ConfigManager{SamplingStrategyStore: store}, metrics.NullFactory)
+	go func() {
+		if err := e.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			host.ReportFatalError(err)
+		}
+	}()
+	return nil
+}
+
+// newStrategyStore returns a store holding the configured strategies.
+func (e *remoteSamplingExtension) newStrategyStore() (strategystore.StrategyStore, error) {
+	if e.cfg.Strategies == "" {
+		return static.NewStrategyStore(static.Options{
+			StrategiesFile: e.cfg.StrategyFile,
+			ReloadInterval: e.cfg.ReloadInterval,
+		}, e.logger)
+	}
+
+	// The store only loads strategies from files, so inline strategies are
+	// written to a file which is removed once they're loaded.
+	f, err := ioutil.TempFile("", "agent-sampling-strategies-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(e.cfg.Strategies)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return static.NewStrategyStore(static.Options{StrategiesFile: f.Name()}, e.logger)
+}
+
+// closeStore stops the reloading of strategies by store.
+func closeStore(store strategystore.StrategyStore) {
+	if c, ok := store.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// Shutdown is invoked during service shutdown.
+func (e *remoteSamplingExtension) Shutdown(ctx context.Context) error {
+	if e.srv == nil {
+		return nil
+	}
+	closeStore(e.store)
+	return e.srv.Shutdown(ctx)
+}
@@ -0,0 +1,115 @@
+package jaegerremotesamplingextension
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.uber.org/zap"
+)
+
+const testStrategies = `{
+  "default_strategy": {"type": "probabilistic", "param": 0.5},
+  "service_strategies": [
+    {"service": "frontend", "type": "ratelimiting", "param": 10}
+  ]
+}`
+
+func TestRemoteSamplingExtension(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "strategies.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(testStrategies), 0600))
+
+	tt := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "strategy file", cfg: Config{StrategyFile: file}},
+		{name: "inline strategies", cfg: Config{Strategies: testStrategies}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Endpoint = freeAddress(t)
+
+			ext, err := newExtension(component.ExtensionCreateParams{Logger: zap.NewNop()}, &tc.cfg)
+			require.NoError(t, err)
+			require.NoError(t, ext.Start(context.Background(), nopHost{}))
+			t.Cleanup(func() {
+				require.NoError(t, ext.Shutdown(context.Background()))
+			})
+
+			frontend := getStrategy(t, tc.cfg.Endpoint, "frontend")
+			require.Equal(t, "RATE_LIMITING", frontend["strategyType"])
+
+			backend := getStrategy(t, tc.cfg.Endpoint, "backend")
+			require.Equal(t, "PROBABILISTIC", backend["strategyType"])
+			require.Equal(t, map[string]interface{}{"samplingRate": 0.5}, backend["probabilisticSampling"])
+		})
+	}
+}
+
+func TestRemoteSamplingExtension_InvalidConfig(t *testing.T) {
+	tt := []Config{
+		{StrategyFile: "strategies.json"},
+		{Endpoint: DefaultEndpoint},
+		{Endpoint: DefaultEndpoint, StrategyFile: "strategies.json", Strategies: testStrategies},
+		{Endpoint: DefaultEndpoint, Strategies: "default_strategy:"},
+	}
+	for _, cfg := range tt {
+		cfg := cfg
+		_, err := newExtension(component.ExtensionCreateParams{Logger: zap.NewNop()}, &cfg)
+		require.Error(t, err, "expected error for %+v", cfg)
+	}
+}
+
+func TestRemoteSamplingExtension_MissingFile(t *testing.T) {
+	cfg := Config{Endpoint: freeAddress(t), StrategyFile: filepath.Join(t.TempDir(), "missing.json")}
+	ext, err := newExtension(component.ExtensionCreateParams{Logger: zap.NewNop()}, &cfg)
+	require.NoError(t, err)
+	require.Error(t, ext.Start(context.Background(), nopHost{}))
+	require.NoError(t, ext.Shutdown(context.Background()))
+}
+
+// getStrategy returns the sampling strategy of service from the sampling
+// endpoint served on addr.
+func getStrategy(t *testing.T, addr, service string) map[string]interface{} {
+	t.Helper()
+
+	resp, err := http.Get("http://" + addr + "/sampling?service=" + service)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var strategy map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&strategy))
+	return strategy
+}
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+type nopHost struct{}
+
+func (nopHost) ReportFatalError(error) {}
+
+func (nopHost) GetFactory(component.Kind, configmodels.Type) component.Factory { return nil }
+
+func (nopHost) GetExtensions() map[configmodels.Extension]component.ServiceExtension { return nil }
+
+func (nopHost) GetExporters() map[configmodels.DataType]map[configmodels.Exporter]component.Exporter {
+	return nil
+}
@@ -0,0 +1,65 @@
+package jaegerremotesamplingextension
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+)
+
+// TypeStr is the unique identifier for the Jaeger remote sampling extension.
+const TypeStr = "jaeger_remote_sampling"
+
+// DefaultEndpoint is the default address sampling strategies are served on,
+// the one of the sampling endpoint of the Jaeger agent.
+const DefaultEndpoint = "0.0.0.0:5778"
+
+// Config holds the configuration for the Jaeger remote sampling extension.
+type Config struct {
+	configmodels.ExtensionSettings `mapstructure:",squash"`
+
+	// Endpoint is the address to serve sampling strategies on.
+	Endpoint string `mapstructure:"endpoint"`
+
+	// StrategyFile is the path or URL of a Jaeger sampling strategies file.
+	StrategyFile string `mapstructure:"strategy_file"`
+
+	// ReloadInterval is how often StrategyFile is reloaded. It's only loaded
+	// once if zero.
+	ReloadInterval time.Duration `mapstructure:"reload_interval"`
+
+	// Strategies is a JSON document of sampling strategies, in the format of
+	// a strategies file. Exclusive with StrategyFile.
+	Strategies string `mapstructure:"strategies"`
+}
+
+// NewFactory returns a new factory for the Jaeger remote sampling extension.
+func NewFactory() component.ExtensionFactory {
+	return factory{}
+}
+
+type factory struct{}
+
+func (factory) Type() configmodels.Type {
+	return TypeStr
+}
+
+func (factory) CreateDefaultConfig() configmodels.Extension {
+	return &Config{
+		ExtensionSettings: configmodels.ExtensionSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+		Endpoint: DefaultEndpoint,
+	}
+}
+
+func (factory) CreateExtension(
+	_ context.Context,
+	params component.ExtensionCreateParams,
+	cfg configmodels.Extension,
+) (component.ServiceExtension, error) {
+	oCfg := cfg.(*Config)
+	return newExtension(params, oCfg)
+}
@@ -0,0 +1,156 @@
+// Package oauth2exporter implements an OpenTelemetry exporter that sends
+// spans over OTLP, authenticated with tokens obtained through the OAuth2
+// client credentials flow. Tokens are refreshed before they expire.
+package oauth2exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+type oauth2Exporter struct {
+	cfg     Config
+	params  component.ExporterCreateParams
+	factory component.ExporterFactory
+	tokens  oauth2.TokenSource
+	logger  *zap.Logger
+
+	mut      sync.Mutex
+	host     component.Host
+	token    string
+	exporter component.TracesExporter
+	stopped  bool
+
+	// draining tracks exporters replaced after a token change which are
+	// still sending their queued spans.
+	draining sync.WaitGroup
+}
+
+func newTraceExporter(params component.ExporterCreateParams, cfg *Config) (component.TracesExporter, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("OTLP exporter config requires an Endpoint")
+	}
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, errors.New("client_id and client_secret must be set")
+	}
+	if cfg.TokenURL == "" {
+		return nil, errors.New("token_url must be set")
+	}
+
+	endpointParams := url.Values{}
+	for k, v := range cfg.EndpointParams {
+		endpointParams.Set(k, v)
+	}
+	ccCfg := clientcredentials.Config{
+		ClientID:       cfg.ClientID,
+		ClientSecret:   cfg.ClientSecret,
+		TokenURL:       cfg.TokenURL,
+		Scopes:         cfg.Scopes,
+		EndpointParams: endpointParams,
+	}
+
+	return &oauth2Exporter{
+		cfg:     *cfg,
+		params:  params,
+		factory: otlpexporter.NewFactory(),
+		tokens:  ccCfg.TokenSource(context.Background()),
+		logger:  params.Logger,
+	}, nil
+}
+
+// Start is invoked during service startup.
+func (e *oauth2Exporter) Start(_ context.Context, host component.Host) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.host = host
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (e *oauth2Exporter) Shutdown(ctx context.Context) error {
+	e.mut.Lock()
+	e.stopped = true
+	exp := e.exporter
+	e.exporter = nil
+	e.mut.Unlock()
+
+	var err error
+	if exp != nil {
+		err = exp.Shutdown(ctx)
+	}
+	e.draining.Wait()
+	return err
+}
+
+func (e *oauth2Exporter) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: false}
+}
+
+func (e *oauth2Exporter) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	exp, err := e.currentExporter(ctx)
+	if err != nil {
+		return err
+	}
+	return exp.ConsumeTraces(ctx, td)
+}
+
+// currentExporter returns the OTLP exporter sending the current token. When
+// the token changed, a new exporter is created and the previous one is shut
+// down once it sent its queued spans.
+func (e *oauth2Exporter) currentExporter(ctx context.Context) (component.TracesExporter, error) {
+	// The token source caches tokens until they expire, so this only
+	// reaches the token endpoint when a new token is needed.
+	token, err := e.tokens.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth2 token: %w", err)
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if e.stopped {
+		return nil, errors.New("exporter is shut down")
+	}
+	if e.exporter != nil && e.token == token.AccessToken {
+		return e.exporter, nil
+	}
+
+	cfg := e.cfg.Config
+	cfg.Headers = make(map[string]string, len(e.cfg.Headers)+1)
+	for k, v := range e.cfg.Headers {
+		cfg.Headers[k] = v
+	}
+	cfg.Headers["authorization"] = token.Type() + " " + token.AccessToken
+
+	exp, err := e.factory.CreateTracesExporter(ctx, e.params, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+	if err := exp.Start(ctx, e.host); err != nil {
+		return nil, fmt.Errorf("failed to start exporter: %w", err)
+	}
+
+	if old := e.exporter; old != nil {
+		e.logger.Debug("OAuth2 token changed, replacing exporter")
+		e.draining.Add(1)
+		go func() {
+			defer e.draining.Done()
+			if err := old.Shutdown(context.Background()); err != nil {
+				e.logger.Warn("failed to shut down exporter using the previous OAuth2 token", zap.Error(err))
+			}
+		}()
+	}
+
+	e.exporter, e.token = exp, token.AccessToken
+	return exp, nil
+}
@@ -0,0 +1,179 @@
+package oauth2exporter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestOAuth2Exporter(t *testing.T) {
+	tt := []struct {
+		name      string
+		expiresIn int
+		expected  []string
+	}{
+		// Tokens expiring within the refresh margin are fetched again for
+		// every request.
+		{name: "expiring tokens", expiresIn: 1, expected: []string{"Bearer token-1", "Bearer token-2"}},
+		{name: "cached token", expiresIn: 3600, expected: []string{"Bearer token-1", "Bearer token-1"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tokenURL := newTestTokenServer(t, tc.expiresIn)
+			sink := &authSink{}
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = newTestReceiver(t, sink)
+			cfg.TLSSetting.Insecure = true
+			cfg.Headers = map[string]string{"x-static": "true"}
+			cfg.QueueSettings.Enabled = false
+			cfg.RetrySettings.Enabled = false
+			cfg.ClientID = "agent"
+			cfg.ClientSecret = "secret"
+			cfg.TokenURL = tokenURL
+
+			exp, err := newTraceExporter(component.ExporterCreateParams{Logger: zap.NewNop()}, cfg)
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), nopHost{}))
+			t.Cleanup(func() {
+				require.NoError(t, exp.Shutdown(context.Background()))
+			})
+
+			for i := range tc.expected {
+				require.NoError(t, exp.ConsumeTraces(context.Background(), testTraces()))
+				require.Eventually(t, func() bool {
+					return len(sink.received()) == i+1
+				}, 10*time.Second, 10*time.Millisecond)
+			}
+			require.Equal(t, tc.expected, sink.received())
+			require.True(t, sink.staticHeaders, "configured headers should be sent")
+		})
+	}
+}
+
+func TestOAuth2Exporter_TokenError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = "localhost:4317"
+	cfg.ClientID = "agent"
+	cfg.ClientSecret = "secret"
+	cfg.TokenURL = srv.URL
+
+	exp, err := newTraceExporter(component.ExporterCreateParams{Logger: zap.NewNop()}, cfg)
+	require.NoError(t, err)
+	err = exp.ConsumeTraces(context.Background(), testTraces())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to get OAuth2 token")
+}
+
+// newTestTokenServer starts a token endpoint accepting the agent/secret
+// client and returning a new token for every request. It returns the URL of
+// the endpoint.
+func newTestTokenServer(t *testing.T, expiresIn int) string {
+	t.Helper()
+
+	var issued atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "agent" || secret != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, issued.Inc(), expiresIn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func testTraces() pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+	rs := td.ResourceSpans().At(0)
+	rs.Resource().Attributes().InsertString("service.name", "checkout")
+	rs.InstrumentationLibrarySpans().Resize(1)
+	spans := rs.InstrumentationLibrarySpans().At(0).Spans()
+	spans.Resize(1)
+	spans.At(0).SetName("GET")
+	spans.At(0).SetTraceID(pdata.NewTraceID([16]byte{1}))
+	spans.At(0).SetSpanID(pdata.NewSpanID([8]byte{1}))
+	return td
+}
+
+// newTestReceiver starts an OTLP gRPC receiver sending traces to sink and
+// returns its address.
+func newTestReceiver(t *testing.T, sink *authSink) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	factory := otlpreceiver.NewFactory()
+	cfg := factory.CreateDefaultConfig().(*otlpreceiver.Config)
+	cfg.GRPC.NetAddr.Endpoint = addr
+	cfg.HTTP = nil
+
+	r, err := factory.CreateTracesReceiver(context.Background(), component.ReceiverCreateParams{Logger: zap.NewNop()}, cfg, sink)
+	require.NoError(t, err)
+	require.NoError(t, r.Start(context.Background(), nopHost{}))
+	t.Cleanup(func() {
+		require.NoError(t, r.Shutdown(context.Background()))
+	})
+
+	return addr
+}
+
+// authSink records the authorization header of every request.
+type authSink struct {
+	mut           sync.Mutex
+	authorization []string
+	staticHeaders bool
+}
+
+func (s *authSink) ConsumeTraces(ctx context.Context, _ pdata.Traces) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.authorization = append(s.authorization, md.Get("authorization")...)
+	s.staticHeaders = len(md.Get("x-static")) > 0
+	return nil
+}
+
+func (s *authSink) received() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]string(nil), s.authorization...)
+}
+
+type nopHost struct{}
+
+func (nopHost) ReportFatalError(error) {}
+
+func (nopHost) GetFactory(component.Kind, configmodels.Type) component.Factory { return nil }
+
+func (nopHost) GetExtensions() map[configmodels.Extension]component.ServiceExtension { return nil }
+
+func (nopHost) GetExporters() map[configmodels.DataType]map[configmodels.Exporter]component.Exporter {
+	return nil
+}
@@ -0,0 +1,60 @@
+package oauth2exporter
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+)
+
+// TypeStr is the unique identifier for the OAuth2 exporter.
+const TypeStr = "otlp_oauth2"
+
+// Config holds the configuration for the OAuth2 exporter. It accepts every
+// setting of the OTLP exporter.
+type Config struct {
+	otlpexporter.Config `mapstructure:",squash"`
+
+	// ClientID and ClientSecret identify the agent to the token endpoint.
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+
+	// TokenURL is the URL of the endpoint tokens are requested from.
+	TokenURL string `mapstructure:"token_url"`
+
+	// Scopes are the scopes requested for tokens.
+	Scopes []string `mapstructure:"scopes"`
+
+	// EndpointParams are extra parameters sent to the token endpoint.
+	EndpointParams map[string]string `mapstructure:"endpoint_params"`
+}
+
+// NewFactory returns a new factory for the OAuth2 exporter.
+func NewFactory() component.ExporterFactory {
+	return exporterhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		exporterhelper.WithTraces(createTraceExporter),
+	)
+}
+
+func createDefaultConfig() configmodels.Exporter {
+	otlpCfg := otlpexporter.NewFactory().CreateDefaultConfig().(*otlpexporter.Config)
+	otlpCfg.TypeVal = TypeStr
+	otlpCfg.NameVal = TypeStr
+
+	return &Config{
+		Config: *otlpCfg,
+	}
+}
+
+func createTraceExporter(
+	_ context.Context,
+	params component.ExporterCreateParams,
+	cfg configmodels.Exporter,
+) (component.TracesExporter, error) {
+	oCfg := cfg.(*Config)
+	return newTraceExporter(params, oCfg)
+}
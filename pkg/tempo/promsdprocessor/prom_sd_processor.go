@@ -15,6 +15,7 @@ import (
 	"github.com/prometheus/prometheus/discovery/targetgroup"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/relabel"
+	"go.opentelemetry.io/collector/client"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/component/componenterror"
 	"go.opentelemetry.io/collector/consumer"
@@ -68,17 +69,24 @@ func newTraceProcessor(nextConsumer consumer.TracesConsumer, scrapeConfigs []*co
 }
 
 func (p *promServiceDiscoProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	// Resources without an ip attribute are matched by the address of the
+	// client that sent them, as recorded by the receiver.
+	var sourceIP string
+	if c, ok := client.FromContext(ctx); ok {
+		sourceIP = c.IP
+	}
+
 	rss := td.ResourceSpans()
 	for i := 0; i < rss.Len(); i++ {
 		rs := rss.At(i)
 
-		p.processAttributes(rs.Resource().Attributes())
+		p.processAttributes(rs.Resource().Attributes(), sourceIP)
 	}
 
 	return p.nextConsumer.ConsumeTraces(ctx, td)
 }
 
-func (p *promServiceDiscoProcessor) processAttributes(attrs pdata.AttributeMap) {
+func (p *promServiceDiscoProcessor) processAttributes(attrs pdata.AttributeMap, sourceIP string) {
 	// find the ip
 	ipTagNames := []string{
 		"ip",          // jaeger/opentracing? default
@@ -96,6 +104,10 @@ func (p *promServiceDiscoProcessor) processAttributes(attrs pdata.AttributeMap)
 		break
 	}
 
+	if ip == "" {
+		ip = sourceIP
+	}
+
 	// have to have an ip for labels lookup
 	if ip == "" {
 		return
@@ -1,6 +1,7 @@
 package promsdprocessor
 
 import (
+	"context"
 	"testing"
 
 	"github.com/go-kit/kit/log"
@@ -8,6 +9,10 @@ import (
 	"github.com/prometheus/prometheus/discovery/targetgroup"
 	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/client"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/consumer/pdata"
 )
 
 func TestSyncGroups(t *testing.T) {
@@ -114,3 +119,67 @@ func TestSyncGroups(t *testing.T) {
 		})
 	}
 }
+
+func TestConsumeTraces(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		sourceIP   string
+		expected   map[string]string
+	}{
+		{
+			name:       "ip attribute",
+			attributes: map[string]string{"ip": "10.0.0.1"},
+			sourceIP:   "10.0.0.2",
+			expected:   map[string]string{"ip": "10.0.0.1", "pod": "a"},
+		},
+		{
+			name:       "otel host ip attribute",
+			attributes: map[string]string{"net.host.ip": "10.0.0.2"},
+			expected:   map[string]string{"net.host.ip": "10.0.0.2", "pod": "b"},
+		},
+		{
+			name:     "source ip",
+			sourceIP: "10.0.0.2",
+			expected: map[string]string{"pod": "b"},
+		},
+		{
+			name:     "unknown source ip",
+			sourceIP: "10.0.0.3",
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &consumertest.TracesSink{}
+			p := &promServiceDiscoProcessor{
+				nextConsumer: sink,
+				logger:       log.NewNopLogger(),
+				hostLabels: map[string]model.LabelSet{
+					"10.0.0.1": {"pod": "a"},
+					"10.0.0.2": {"pod": "b"},
+				},
+			}
+
+			td := pdata.NewTraces()
+			td.ResourceSpans().Resize(1)
+			attrs := td.ResourceSpans().At(0).Resource().Attributes()
+			for k, v := range tc.attributes {
+				attrs.InsertString(k, v)
+			}
+
+			ctx := context.Background()
+			if tc.sourceIP != "" {
+				ctx = client.NewContext(ctx, &client.Client{IP: tc.sourceIP})
+			}
+			require.NoError(t, p.ConsumeTraces(ctx, td))
+
+			actual := make(map[string]string)
+			attrs.ForEach(func(k string, v pdata.AttributeValue) {
+				actual[k] = v.StringVal()
+			})
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
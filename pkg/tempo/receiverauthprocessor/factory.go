@@ -0,0 +1,50 @@
+package receiverauthprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the receiver auth processor.
+const TypeStr = "receiver_auth"
+
+// Config holds the configuration for the receiver auth processor.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	// BearerTokens are the tokens accepted in the authorization header of
+	// incoming requests.
+	BearerTokens []string `mapstructure:"bearer_tokens"`
+}
+
+// NewFactory returns a new factory for the receiver auth processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	cp component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg)
+}
@@ -0,0 +1,104 @@
+// Package receiverauthprocessor implements an OpenTelemetry processor that
+// rejects spans from requests without an accepted bearer token. It must be
+// the first processor of the pipeline of the receivers it protects.
+package receiverauthprocessor
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var rejectedSpansTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "agent_tempo_receiver_auth_rejected_spans_total",
+	Help: "Total number of spans rejected because their request didn't hold an accepted bearer token",
+})
+
+const bearerPrefix = "bearer "
+
+// errUnauthenticated is returned to the receiver for requests without an
+// accepted token. gRPC receivers send its status code to clients.
+var errUnauthenticated = status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+
+type receiverAuthProcessor struct {
+	nextConsumer consumer.TracesConsumer
+	tokens       [][]byte
+}
+
+func newTraceProcessor(nextConsumer consumer.TracesConsumer, cfg *Config) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+	if len(cfg.BearerTokens) == 0 {
+		return nil, errors.New("at least one bearer token must be set")
+	}
+
+	tokens := make([][]byte, 0, len(cfg.BearerTokens))
+	for _, token := range cfg.BearerTokens {
+		if token == "" {
+			return nil, errors.New("bearer tokens must not be empty")
+		}
+		tokens = append(tokens, []byte(token))
+	}
+
+	return &receiverAuthProcessor{
+		nextConsumer: nextConsumer,
+		tokens:       tokens,
+	}, nil
+}
+
+func (p *receiverAuthProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	if !p.authenticated(ctx) {
+		rejectedSpansTotal.Add(float64(td.SpanCount()))
+		return errUnauthenticated
+	}
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// authenticated returns whether the incoming request holds an accepted
+// token. Only gRPC and OTLP HTTP receivers keep the headers of requests in
+// ctx, so requests of other receivers are always rejected.
+func (p *receiverAuthProcessor) authenticated(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	for _, value := range md.Get("authorization") {
+		if len(value) <= len(bearerPrefix) || !strings.EqualFold(value[:len(bearerPrefix)], bearerPrefix) {
+			continue
+		}
+		token := []byte(value[len(bearerPrefix):])
+		for _, accepted := range p.tokens {
+			if subtle.ConstantTimeCompare(token, accepted) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *receiverAuthProcessor) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: false}
+}
+
+// Start is invoked during service startup.
+func (p *receiverAuthProcessor) Start(context.Context, component.Host) error {
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *receiverAuthProcessor) Shutdown(context.Context) error {
+	return nil
+}
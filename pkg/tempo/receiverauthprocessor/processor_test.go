@@ -0,0 +1,76 @@
+package receiverauthprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestReceiverAuthProcessor(t *testing.T) {
+	tt := []struct {
+		name          string
+		md            metadata.MD
+		authenticated bool
+	}{
+		{name: "no metadata"},
+		{name: "no authorization", md: metadata.Pairs("x-scope-orgid", "team-a")},
+		{name: "basic auth", md: metadata.Pairs("authorization", "Basic c2VjcmV0")},
+		{name: "wrong token", md: metadata.Pairs("authorization", "Bearer wrong")},
+		{name: "empty token", md: metadata.Pairs("authorization", "Bearer ")},
+		{name: "token", md: metadata.Pairs("authorization", "Bearer secret-a"), authenticated: true},
+		{name: "second token", md: metadata.Pairs("authorization", "Bearer secret-b"), authenticated: true},
+		{name: "lowercase scheme", md: metadata.Pairs("authorization", "bearer secret-a"), authenticated: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := new(tracesSink)
+			p, err := newTraceProcessor(sink, &Config{BearerTokens: []string{"secret-a", "secret-b"}})
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+			err = p.ConsumeTraces(ctx, testTraces())
+
+			if tc.authenticated {
+				require.NoError(t, err)
+				require.Equal(t, 1, sink.spans)
+				return
+			}
+			require.Equal(t, codes.Unauthenticated, status.Code(err))
+			require.Equal(t, 0, sink.spans)
+		})
+	}
+}
+
+func TestReceiverAuthProcessor_InvalidConfig(t *testing.T) {
+	for _, tokens := range [][]string{nil, {""}} {
+		_, err := newTraceProcessor(new(tracesSink), &Config{BearerTokens: tokens})
+		require.Error(t, err, "expected error for %q", tokens)
+	}
+}
+
+func testTraces() pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+	rs := td.ResourceSpans().At(0)
+	rs.InstrumentationLibrarySpans().Resize(1)
+	rs.InstrumentationLibrarySpans().At(0).Spans().Resize(1)
+	return td
+}
+
+type tracesSink struct {
+	spans int
+}
+
+func (s *tracesSink) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	s.spans += td.SpanCount()
+	return nil
+}
@@ -0,0 +1,251 @@
+// Package remotewriteexporter implements an OpenTelemetry exporter that
+// appends metrics, such as the RED metrics generated by the spanmetrics
+// processor, to the WAL of a Prometheus instance of the Agent. The samples are
+// then sent by the remote_write of that instance along with scraped metrics.
+package remotewriteexporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/strutil"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.uber.org/zap"
+)
+
+var (
+	samplesWrittenTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_tempo_remote_write_samples_written_total",
+		Help: "Total number of samples generated from traces written to a Prometheus instance",
+	})
+
+	samplesDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_tempo_remote_write_samples_dropped_total",
+		Help: "Total number of samples generated from traces dropped because their Prometheus instance wasn't found or their metric type isn't supported",
+	})
+)
+
+// Host is implemented by component.Hosts that can look up Prometheus
+// instances by name.
+type Host interface {
+	// MetricsInstance returns the storage of the Prometheus instance with the
+	// given name, or nil if it doesn't exist.
+	MetricsInstance(name string) storage.Appendable
+}
+
+type remoteWriteExporter struct {
+	promInstance string
+	namespace    string
+	constLabels  labels.Labels
+	logger       *zap.Logger
+
+	host Host
+}
+
+func newMetricsExporter(params component.ExporterCreateParams, cfg *Config) (component.MetricsExporter, error) {
+	if cfg.PromInstance == "" {
+		return nil, errors.New("prom_instance must be set")
+	}
+
+	constLabels := make(labels.Labels, 0, len(cfg.ConstLabels))
+	for name, value := range cfg.ConstLabels {
+		if !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("invalid const label name %q", name)
+		}
+		constLabels = append(constLabels, labels.Label{Name: name, Value: value})
+	}
+
+	return &remoteWriteExporter{
+		promInstance: cfg.PromInstance,
+		namespace:    cfg.Namespace,
+		constLabels:  constLabels,
+		logger:       params.Logger,
+	}, nil
+}
+
+// Start is invoked during service startup.
+func (e *remoteWriteExporter) Start(_ context.Context, host component.Host) error {
+	h, ok := host.(Host)
+	if !ok {
+		return errors.New("host can't look up Prometheus instances")
+	}
+	e.host = h
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (e *remoteWriteExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func (e *remoteWriteExporter) ConsumeMetrics(ctx context.Context, md pdata.Metrics) error {
+	samples, dropped := e.samples(md)
+	samplesDroppedTotal.Add(float64(dropped))
+	if len(samples) == 0 {
+		return nil
+	}
+
+	inst := e.host.MetricsInstance(e.promInstance)
+	if inst == nil {
+		samplesDroppedTotal.Add(float64(len(samples)))
+		e.logger.Debug("dropping samples, prometheus instance not found", zap.String("prom_instance", e.promInstance), zap.Int("count", len(samples)))
+		return nil
+	}
+
+	app := inst.Appender(ctx)
+	for _, s := range samples {
+		if _, err := app.Append(0, s.labels, s.t, s.v); err != nil {
+			_ = app.Rollback()
+			return fmt.Errorf("failed to append sample to prometheus instance %q: %w", e.promInstance, err)
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return fmt.Errorf("failed to commit samples to prometheus instance %q: %w", e.promInstance, err)
+	}
+	samplesWrittenTotal.Add(float64(len(samples)))
+	return nil
+}
+
+type sample struct {
+	labels labels.Labels
+	t      int64
+	v      float64
+}
+
+// samples converts the cumulative sums and histograms of md into samples,
+// following the naming of the Prometheus exporter. It returns the number of
+// data points dropped because they have an unsupported type or temporality.
+func (e *remoteWriteExporter) samples(md pdata.Metrics) (samples []sample, dropped int) {
+	rms := md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		ilms := rms.At(i).InstrumentationLibraryMetrics()
+		for j := 0; j < ilms.Len(); j++ {
+			metrics := ilms.At(j).Metrics()
+			for k := 0; k < metrics.Len(); k++ {
+				m := metrics.At(k)
+				name := e.metricName(m.Name())
+
+				switch m.DataType() {
+				case pdata.MetricDataTypeIntSum:
+					sum := m.IntSum()
+					if sum.AggregationTemporality() != pdata.AggregationTemporalityCumulative {
+						dropped += sum.DataPoints().Len()
+						continue
+					}
+					if sum.IsMonotonic() {
+						name += "_total"
+					}
+					for l := 0; l < sum.DataPoints().Len(); l++ {
+						dp := sum.DataPoints().At(l)
+						samples = append(samples, e.sample(name, dp.LabelsMap(), dp.Timestamp(), float64(dp.Value())))
+					}
+
+				case pdata.MetricDataTypeDoubleSum:
+					sum := m.DoubleSum()
+					if sum.AggregationTemporality() != pdata.AggregationTemporalityCumulative {
+						dropped += sum.DataPoints().Len()
+						continue
+					}
+					if sum.IsMonotonic() {
+						name += "_total"
+					}
+					for l := 0; l < sum.DataPoints().Len(); l++ {
+						dp := sum.DataPoints().At(l)
+						samples = append(samples, e.sample(name, dp.LabelsMap(), dp.Timestamp(), dp.Value()))
+					}
+
+				case pdata.MetricDataTypeIntHistogram:
+					hist := m.IntHistogram()
+					if hist.AggregationTemporality() != pdata.AggregationTemporalityCumulative {
+						dropped += hist.DataPoints().Len()
+						continue
+					}
+					for l := 0; l < hist.DataPoints().Len(); l++ {
+						dp := hist.DataPoints().At(l)
+						samples = append(samples, e.histogramSamples(name, dp.LabelsMap(), dp.Timestamp(),
+							dp.ExplicitBounds(), dp.BucketCounts(), float64(dp.Sum()), dp.Count())...)
+					}
+
+				case pdata.MetricDataTypeDoubleHistogram:
+					hist := m.DoubleHistogram()
+					if hist.AggregationTemporality() != pdata.AggregationTemporalityCumulative {
+						dropped += hist.DataPoints().Len()
+						continue
+					}
+					for l := 0; l < hist.DataPoints().Len(); l++ {
+						dp := hist.DataPoints().At(l)
+						samples = append(samples, e.histogramSamples(name, dp.LabelsMap(), dp.Timestamp(),
+							dp.ExplicitBounds(), dp.BucketCounts(), dp.Sum(), dp.Count())...)
+					}
+
+				default:
+					e.logger.Debug("dropping metric with unsupported type", zap.String("metric", m.Name()), zap.String("type", m.DataType().String()))
+					dropped++
+				}
+			}
+		}
+	}
+	return samples, dropped
+}
+
+func (e *remoteWriteExporter) metricName(name string) string {
+	if e.namespace != "" {
+		name = e.namespace + "_" + name
+	}
+	return strutil.SanitizeLabelName(name)
+}
+
+// histogramSamples returns the _bucket, _sum and _count samples of a
+// histogram data point. counts has one more element than bounds, holding the
+// count of the +Inf bucket.
+func (e *remoteWriteExporter) histogramSamples(name string, lbls pdata.StringMap, ts pdata.TimestampUnixNano, bounds []float64, counts []uint64, sum float64, count uint64) []sample {
+	samples := make([]sample, 0, len(bounds)+3)
+
+	var cumulative uint64
+	for i, bound := range bounds {
+		if i < len(counts) {
+			cumulative += counts[i]
+		}
+		s := e.sample(name+"_bucket", lbls, ts, float64(cumulative), labels.Label{Name: model.BucketLabel, Value: formatBound(bound)})
+		samples = append(samples, s)
+	}
+	samples = append(samples,
+		e.sample(name+"_bucket", lbls, ts, float64(count), labels.Label{Name: model.BucketLabel, Value: "+Inf"}),
+		e.sample(name+"_sum", lbls, ts, sum),
+		e.sample(name+"_count", lbls, ts, float64(count)),
+	)
+	return samples
+}
+
+func (e *remoteWriteExporter) sample(name string, lbls pdata.StringMap, ts pdata.TimestampUnixNano, v float64, extra ...labels.Label) sample {
+	b := labels.NewBuilder(e.constLabels)
+	lbls.ForEach(func(k, v string) {
+		b.Set(strutil.SanitizeLabelName(k), v)
+	})
+	for _, l := range extra {
+		b.Set(l.Name, l.Value)
+	}
+	b.Set(model.MetricNameLabel, name)
+
+	return sample{
+		labels: b.Labels(),
+		t:      int64(ts) / 1e6,
+		v:      v,
+	}
+}
+
+func formatBound(bound float64) string {
+	if math.IsInf(bound, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(bound, 'f', -1, 64)
+}
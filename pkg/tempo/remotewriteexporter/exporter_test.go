@@ -0,0 +1,153 @@
+package remotewriteexporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.uber.org/zap"
+)
+
+func TestRemoteWriteExporter(t *testing.T) {
+	app := &recordingAppendable{}
+	exp := newTestExporter(t, testHost{"traces": app})
+
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), testMetrics(time.Unix(10, 0))))
+
+	series := labels.FromStrings("cluster", "us-east", "service_name", "checkout", "operation", "GET /cart")
+	withName := func(name string, extra ...string) labels.Labels {
+		b := labels.NewBuilder(series).Set("__name__", name)
+		for i := 0; i < len(extra); i += 2 {
+			b.Set(extra[i], extra[i+1])
+		}
+		return b.Labels()
+	}
+
+	require.Equal(t, []sample{
+		{labels: withName("tempo_spanmetrics_calls_total"), t: 10_000, v: 3},
+		{labels: withName("tempo_spanmetrics_latency_bucket", "le", "2"), t: 10_000, v: 1},
+		{labels: withName("tempo_spanmetrics_latency_bucket", "le", "10"), t: 10_000, v: 2},
+		{labels: withName("tempo_spanmetrics_latency_bucket", "le", "+Inf"), t: 10_000, v: 3},
+		{labels: withName("tempo_spanmetrics_latency_sum"), t: 10_000, v: 120},
+		{labels: withName("tempo_spanmetrics_latency_count"), t: 10_000, v: 3},
+	}, app.committed)
+}
+
+func TestRemoteWriteExporter_MissingInstance(t *testing.T) {
+	exp := newTestExporter(t, testHost{})
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), testMetrics(time.Now())))
+}
+
+func TestRemoteWriteExporter_Host(t *testing.T) {
+	exp := newTestExporter(t, nil)
+	require.Error(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+}
+
+func newTestExporter(t *testing.T, host component.Host) component.MetricsExporter {
+	t.Helper()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.PromInstance = "traces"
+	cfg.Namespace = "tempo_spanmetrics"
+	cfg.ConstLabels = map[string]string{"cluster": "us-east"}
+
+	exp, err := newMetricsExporter(component.ExporterCreateParams{Logger: zap.NewNop()}, cfg)
+	require.NoError(t, err)
+	if host != nil {
+		require.NoError(t, exp.Start(context.Background(), host))
+	}
+	return exp
+}
+
+// testMetrics returns the calls and latency metrics of the spanmetrics
+// processor for a single operation.
+func testMetrics(ts time.Time) pdata.Metrics {
+	ilm := pdata.NewInstrumentationLibraryMetrics()
+
+	calls := pdata.NewMetric()
+	calls.SetName("calls")
+	calls.SetDataType(pdata.MetricDataTypeIntSum)
+	calls.IntSum().SetIsMonotonic(true)
+	calls.IntSum().SetAggregationTemporality(pdata.AggregationTemporalityCumulative)
+	callsDP := pdata.NewIntDataPoint()
+	callsDP.LabelsMap().InitFromMap(map[string]string{"service.name": "checkout", "operation": "GET /cart"})
+	callsDP.SetTimestamp(pdata.TimeToUnixNano(ts))
+	callsDP.SetValue(3)
+	calls.IntSum().DataPoints().Append(callsDP)
+	ilm.Metrics().Append(calls)
+
+	latency := pdata.NewMetric()
+	latency.SetName("latency")
+	latency.SetDataType(pdata.MetricDataTypeIntHistogram)
+	latency.IntHistogram().SetAggregationTemporality(pdata.AggregationTemporalityCumulative)
+	latencyDP := pdata.NewIntHistogramDataPoint()
+	latencyDP.LabelsMap().InitFromMap(map[string]string{"service.name": "checkout", "operation": "GET /cart"})
+	latencyDP.SetTimestamp(pdata.TimeToUnixNano(ts))
+	latencyDP.SetExplicitBounds([]float64{2, 10})
+	latencyDP.SetBucketCounts([]uint64{1, 1, 1})
+	latencyDP.SetCount(3)
+	latencyDP.SetSum(120)
+	latency.IntHistogram().DataPoints().Append(latencyDP)
+	ilm.Metrics().Append(latency)
+
+	rm := pdata.NewResourceMetrics()
+	rm.InstrumentationLibraryMetrics().Append(ilm)
+	md := pdata.NewMetrics()
+	md.ResourceMetrics().Append(rm)
+	return md
+}
+
+type testHost map[string]storage.Appendable
+
+func (h testHost) ReportFatalError(error) {}
+
+func (h testHost) GetFactory(component.Kind, configmodels.Type) component.Factory { return nil }
+
+func (h testHost) GetExtensions() map[configmodels.Extension]component.ServiceExtension {
+	return nil
+}
+
+func (h testHost) GetExporters() map[configmodels.DataType]map[configmodels.Exporter]component.Exporter {
+	return nil
+}
+
+func (h testHost) MetricsInstance(name string) storage.Appendable {
+	if app, ok := h[name]; ok {
+		return app
+	}
+	return nil
+}
+
+// recordingAppendable records committed samples.
+type recordingAppendable struct {
+	committed []sample
+}
+
+func (r *recordingAppendable) Appender(context.Context) storage.Appender {
+	return &recordingAppender{parent: r}
+}
+
+type recordingAppender struct {
+	storage.Appender
+	parent  *recordingAppendable
+	pending []sample
+}
+
+func (a *recordingAppender) Append(_ uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	a.pending = append(a.pending, sample{labels: l, t: t, v: v})
+	return 0, nil
+}
+
+func (a *recordingAppender) Commit() error {
+	a.parent.committed = append(a.parent.committed, a.pending...)
+	return nil
+}
+
+func (a *recordingAppender) Rollback() error { return nil }
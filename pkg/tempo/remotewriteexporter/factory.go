@@ -0,0 +1,54 @@
+package remotewriteexporter
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+)
+
+// TypeStr is the unique identifier for the remote write exporter.
+const TypeStr = "remote_write"
+
+// Config holds the configuration for the remote write exporter.
+type Config struct {
+	configmodels.ExporterSettings `mapstructure:",squash"`
+
+	// PromInstance is the name of the Prometheus instance to write samples
+	// to.
+	PromInstance string `mapstructure:"prom_instance"`
+
+	// Namespace is prepended to the name of every metric.
+	Namespace string `mapstructure:"namespace"`
+
+	// ConstLabels are added to every sample.
+	ConstLabels map[string]string `mapstructure:"const_labels"`
+}
+
+// NewFactory returns a new factory for the remote write exporter.
+func NewFactory() component.ExporterFactory {
+	return exporterhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		exporterhelper.WithMetrics(createMetricsExporter),
+	)
+}
+
+func createDefaultConfig() configmodels.Exporter {
+	return &Config{
+		ExporterSettings: configmodels.ExporterSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+	}
+}
+
+func createMetricsExporter(
+	_ context.Context,
+	params component.ExporterCreateParams,
+	cfg configmodels.Exporter,
+) (component.MetricsExporter, error) {
+	oCfg := cfg.(*Config)
+	return newMetricsExporter(params, oCfg)
+}
@@ -0,0 +1,51 @@
+package samplingattributesprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the sampling attributes processor.
+const TypeStr = "sampling_attributes"
+
+// Config holds the configuration for the sampling attributes processor.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	// Remove removes the attributes instead of adding them. A processor
+	// removing them must run after the tail sampling processor, so they're
+	// never sent.
+	Remove bool `mapstructure:"remove"`
+}
+
+// NewFactory returns a new factory for the sampling attributes processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	cp component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg)
+}
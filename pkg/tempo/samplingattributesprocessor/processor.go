@@ -0,0 +1,98 @@
+// Package samplingattributesprocessor implements an OpenTelemetry processor
+// that sets the duration and status code of spans as span attributes. The
+// tail sampling processor only samples by attributes, so latency and
+// status_code sampling policies are implemented as attribute policies on
+// them.
+package samplingattributesprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+)
+
+// Span attributes set by the processor.
+const (
+	// DurationKey holds the duration of the span in milliseconds.
+	DurationKey = "grafana_agent.duration_ms"
+
+	// StatusCodeKey holds the status code of the span: UNSET, OK or ERROR.
+	StatusCodeKey = "grafana_agent.status_code"
+)
+
+// StatusCodes maps the status codes used in sampling policies to the values
+// of StatusCodeKey.
+var StatusCodes = map[string]pdata.StatusCode{
+	"UNSET": pdata.StatusCodeUnset,
+	"OK":    pdata.StatusCodeOk,
+	"ERROR": pdata.StatusCodeError,
+}
+
+type samplingAttributesProcessor struct {
+	nextConsumer consumer.TracesConsumer
+	remove       bool
+	statusCodes  map[pdata.StatusCode]string
+}
+
+func newTraceProcessor(nextConsumer consumer.TracesConsumer, cfg *Config) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+
+	statusCodes := make(map[pdata.StatusCode]string, len(StatusCodes))
+	for name, code := range StatusCodes {
+		statusCodes[code] = name
+	}
+
+	return &samplingAttributesProcessor{
+		nextConsumer: nextConsumer,
+		remove:       cfg.Remove,
+		statusCodes:  statusCodes,
+	}, nil
+}
+
+func (p *samplingAttributesProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		ilss := rss.At(i).InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				attrs := span.Attributes()
+
+				if p.remove {
+					attrs.Delete(DurationKey)
+					attrs.Delete(StatusCodeKey)
+					continue
+				}
+
+				var durationMs int64
+				if span.EndTime() > span.StartTime() {
+					durationMs = int64(span.EndTime()-span.StartTime()) / 1e6
+				}
+				attrs.UpsertInt(DurationKey, durationMs)
+				attrs.UpsertString(StatusCodeKey, p.statusCodes[span.Status().Code()])
+			}
+		}
+	}
+
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+func (p *samplingAttributesProcessor) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: true}
+}
+
+// Start is invoked during service startup.
+func (p *samplingAttributesProcessor) Start(context.Context, component.Host) error {
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *samplingAttributesProcessor) Shutdown(context.Context) error {
+	return nil
+}
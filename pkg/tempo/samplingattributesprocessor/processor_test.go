@@ -0,0 +1,68 @@
+package samplingattributesprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/pdata"
+)
+
+func TestSamplingAttributesProcessor(t *testing.T) {
+	sink := new(tracesSink)
+	add, err := newTraceProcessor(sink, &Config{})
+	require.NoError(t, err)
+
+	start := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+	td.ResourceSpans().At(0).InstrumentationLibrarySpans().Resize(1)
+	spans := td.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans()
+	spans.Resize(2)
+
+	slow := spans.At(0)
+	slow.SetStartTime(pdata.TimestampUnixNano(start.UnixNano()))
+	slow.SetEndTime(pdata.TimestampUnixNano(start.Add(1500 * time.Millisecond).UnixNano()))
+	slow.Status().SetCode(pdata.StatusCodeError)
+
+	// Spans without an end time get a duration of 0.
+	unfinished := spans.At(1)
+	unfinished.SetStartTime(pdata.TimestampUnixNano(start.UnixNano()))
+
+	require.NoError(t, add.ConsumeTraces(context.Background(), td))
+
+	spans = sink.traces.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans()
+	requireAttributes(t, spans.At(0), 1500, "ERROR")
+	requireAttributes(t, spans.At(1), 0, "UNSET")
+
+	remove, err := newTraceProcessor(sink, &Config{Remove: true})
+	require.NoError(t, err)
+	require.NoError(t, remove.ConsumeTraces(context.Background(), sink.traces))
+
+	spans = sink.traces.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans()
+	require.Equal(t, 0, spans.At(0).Attributes().Len())
+	require.Equal(t, 0, spans.At(1).Attributes().Len())
+}
+
+func requireAttributes(t *testing.T, span pdata.Span, durationMs int64, statusCode string) {
+	t.Helper()
+
+	v, ok := span.Attributes().Get(DurationKey)
+	require.True(t, ok)
+	require.Equal(t, durationMs, v.IntVal())
+
+	v, ok = span.Attributes().Get(StatusCodeKey)
+	require.True(t, ok)
+	require.Equal(t, statusCode, v.StringVal())
+}
+
+type tracesSink struct {
+	traces pdata.Traces
+}
+
+func (s *tracesSink) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	s.traces = td
+	return nil
+}
@@ -0,0 +1,61 @@
+package scrubprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the scrub processor.
+const TypeStr = "scrub"
+
+// Config holds the configuration for the scrub processor. It mirrors
+// scrub.Config.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	// Salt prepended to values before hashing them.
+	Salt string `mapstructure:"salt"`
+
+	// Rules are checked in order. The first rule matching an attribute value
+	// is applied.
+	Rules []Rule `mapstructure:"rules"`
+}
+
+// Rule scrubs the values of attributes matching a regex.
+type Rule struct {
+	Attributes []string `mapstructure:"attributes"`
+	Regex      string   `mapstructure:"regex"`
+	Action     string   `mapstructure:"action"`
+}
+
+// NewFactory returns a new factory for the scrub processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	cp component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg)
+}
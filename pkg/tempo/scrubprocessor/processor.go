@@ -0,0 +1,111 @@
+// Package scrubprocessor implements an OpenTelemetry processor that hashes
+// or removes sensitive span attributes, applying the same rules as the
+// scrubbing of metrics and logs.
+package scrubprocessor
+
+import (
+	"context"
+
+	"github.com/grafana/agent/pkg/scrub"
+	config_util "github.com/prometheus/common/config"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	tracetranslator "go.opentelemetry.io/collector/translator/trace"
+)
+
+type scrubProcessor struct {
+	nextConsumer consumer.TracesConsumer
+	scrubber     *scrub.Scrubber
+}
+
+func newTraceProcessor(nextConsumer consumer.TracesConsumer, cfg *Config) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+
+	scrubCfg := scrub.Config{Salt: config_util.Secret(cfg.Salt)}
+	for _, r := range cfg.Rules {
+		rule := scrub.DefaultRule
+		rule.Labels = r.Attributes
+		if r.Regex != "" {
+			rule.Regex = r.Regex
+		}
+		if r.Action != "" {
+			rule.Action = scrub.Action(r.Action)
+		}
+		scrubCfg.Rules = append(scrubCfg.Rules, rule)
+	}
+	scrubber, err := scrub.New(&scrubCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scrubProcessor{
+		nextConsumer: nextConsumer,
+		scrubber:     scrubber,
+	}, nil
+}
+
+func (p *scrubProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+		p.scrubAttributes(rs.Resource().Attributes())
+
+		ilss := rs.InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				p.scrubAttributes(span.Attributes())
+
+				events := span.Events()
+				for l := 0; l < events.Len(); l++ {
+					p.scrubAttributes(events.At(l).Attributes())
+				}
+			}
+		}
+	}
+
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// scrubAttributes scrubs attrs in place. Values that aren't strings are
+// matched by their string form and hashed into a string.
+func (p *scrubProcessor) scrubAttributes(attrs pdata.AttributeMap) {
+	var names []string
+	attrs.ForEach(func(k string, _ pdata.AttributeValue) {
+		if p.scrubber.Applies(k) {
+			names = append(names, k)
+		}
+	})
+
+	for _, name := range names {
+		v, _ := attrs.Get(name)
+		value := tracetranslator.AttributeValueToString(v, false)
+
+		scrubbed, keep := p.scrubber.Scrub(name, value)
+		switch {
+		case !keep:
+			attrs.Delete(name)
+		case scrubbed != value:
+			attrs.UpsertString(name, scrubbed)
+		}
+	}
+}
+
+func (p *scrubProcessor) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: true}
+}
+
+// Start is invoked during service startup.
+func (p *scrubProcessor) Start(context.Context, component.Host) error {
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *scrubProcessor) Shutdown(context.Context) error {
+	return nil
+}
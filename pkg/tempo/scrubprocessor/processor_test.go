@@ -0,0 +1,77 @@
+package scrubprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/agent/pkg/scrub"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/pdata"
+)
+
+func TestScrubProcessor(t *testing.T) {
+	sink := new(tracesSink)
+	p, err := newTraceProcessor(sink, &Config{
+		Salt: "salt",
+		Rules: []Rule{
+			{Attributes: []string{"net.peer.ip"}, Regex: `10\..*`, Action: "drop"},
+			{Attributes: []string{"enduser.id", "net.peer.ip", "host.ip"}},
+		},
+	})
+	require.NoError(t, err)
+
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+	rs := td.ResourceSpans().At(0)
+	rs.Resource().Attributes().InsertString("service.name", "checkout")
+	rs.Resource().Attributes().InsertString("host.ip", "192.168.0.1")
+
+	rs.InstrumentationLibrarySpans().Resize(1)
+	spans := rs.InstrumentationLibrarySpans().At(0).Spans()
+	spans.Resize(1)
+	span := spans.At(0)
+	span.Attributes().InsertInt("enduser.id", 42)
+	span.Attributes().InsertString("net.peer.ip", "10.0.0.1")
+	span.Events().Resize(1)
+	span.Events().At(0).Attributes().InsertString("enduser.id", "42")
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), td))
+
+	rs = sink.traces.ResourceSpans().At(0)
+	require.Equal(t, map[string]string{
+		"service.name": "checkout",
+		"host.ip":      scrub.Hash("salt", "192.168.0.1"),
+	}, attributes(rs.Resource().Attributes()))
+
+	span = rs.InstrumentationLibrarySpans().At(0).Spans().At(0)
+	require.Equal(t, map[string]string{
+		"enduser.id": scrub.Hash("salt", "42"),
+	}, attributes(span.Attributes()))
+	require.Equal(t, map[string]string{
+		"enduser.id": scrub.Hash("salt", "42"),
+	}, attributes(span.Events().At(0).Attributes()))
+}
+
+func TestScrubProcessor_InvalidRule(t *testing.T) {
+	_, err := newTraceProcessor(new(tracesSink), &Config{
+		Rules: []Rule{{Attributes: []string{"enduser.id"}, Action: "mask"}},
+	})
+	require.EqualError(t, err, `scrubbing rule at index 0: unsupported action "mask", expected "hash" or "drop"`)
+}
+
+func attributes(attrs pdata.AttributeMap) map[string]string {
+	res := make(map[string]string, attrs.Len())
+	attrs.ForEach(func(k string, v pdata.AttributeValue) {
+		res[k] = v.StringVal()
+	})
+	return res
+}
+
+type tracesSink struct {
+	traces pdata.Traces
+}
+
+func (s *tracesSink) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	s.traces = td
+	return nil
+}
@@ -0,0 +1,87 @@
+package servicegraphprocessor
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the service graph processor.
+const TypeStr = "service_graphs"
+
+const (
+	// DefaultWait is the default amount of time to wait for the other span
+	// of a request.
+	DefaultWait = 10 * time.Second
+
+	// DefaultMaxItems is the default number of requests waiting for their
+	// other span.
+	DefaultMaxItems = 10000
+)
+
+// DefaultLatencyHistogramBuckets are the default buckets of the request
+// latency histograms.
+var DefaultLatencyHistogramBuckets = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Config holds the configuration for the service graph processor.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	// MetricsExporter is the name of the metrics exporter the service graph
+	// metrics are sent to.
+	MetricsExporter string `mapstructure:"metrics_exporter"`
+
+	// Wait is how long to wait for the other span of a request before it's
+	// counted as unpaired.
+	Wait time.Duration `mapstructure:"wait"`
+
+	// MaxItems is the maximum number of requests waiting for their other
+	// span. Spans of new requests are dropped while the limit is reached.
+	MaxItems int `mapstructure:"max_items"`
+
+	// LatencyHistogramBuckets are the buckets of the request latency
+	// histograms.
+	LatencyHistogramBuckets []time.Duration `mapstructure:"latency_histogram_buckets"`
+}
+
+// NewFactory returns a new factory for the service graph processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+		Wait:     DefaultWait,
+		MaxItems: DefaultMaxItems,
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	cp component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg, cp.Logger)
+}
@@ -0,0 +1,347 @@
+// Package servicegraphprocessor implements an OpenTelemetry processor that
+// pairs the client and server spans of requests between services and sends
+// metrics describing the edges between services, such as their request rate,
+// failures and latency, to a metrics exporter. The metrics can be used to
+// draw the topology of a system on dashboards.
+package servicegraphprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.uber.org/zap"
+)
+
+var droppedSpansTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "agent_tempo_service_graph_dropped_spans_total",
+	Help: "Total number of spans dropped by the service graph processor because max_items requests were waiting for their other span",
+})
+
+// Labels of the service graph metrics.
+const (
+	clientLabel = "client"
+	serverLabel = "server"
+)
+
+// edgeKey identifies a request by the span ID of its client span.
+type edgeKey struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// edge is a request between two services whose client or server span has
+// been seen.
+type edge struct {
+	client, server                 string
+	clientLatency, serverLatency   float64
+	clientSeen, serverSeen, failed bool
+	expiration                     time.Time
+}
+
+type edgeLabels struct {
+	client, server string
+}
+
+// edgeStats are the cumulative metrics of the requests between two services.
+type edgeStats struct {
+	requests, failed uint64
+
+	clientBuckets, serverBuckets []uint64
+	clientSum, serverSum         float64
+}
+
+type serviceGraphProcessor struct {
+	nextConsumer        consumer.TracesConsumer
+	metricsExporterName string
+	metricsExporter     component.MetricsExporter
+	wait                time.Duration
+	maxItems            int
+	bounds              []float64
+	logger              *zap.Logger
+	startTime           time.Time
+	now                 func() time.Time
+
+	mut      sync.Mutex
+	pending  map[edgeKey]*edge
+	stats    map[edgeLabels]*edgeStats
+	unpaired map[edgeLabels]uint64
+}
+
+func newTraceProcessor(nextConsumer consumer.TracesConsumer, cfg *Config, logger *zap.Logger) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+	if cfg.MetricsExporter == "" {
+		return nil, errors.New("metrics_exporter must be set")
+	}
+	if cfg.Wait <= 0 {
+		return nil, errors.New("wait must be greater than 0")
+	}
+	if cfg.MaxItems <= 0 {
+		return nil, errors.New("max_items must be greater than 0")
+	}
+
+	buckets := cfg.LatencyHistogramBuckets
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyHistogramBuckets
+	}
+	bounds := make([]float64, 0, len(buckets))
+	for _, b := range buckets {
+		bounds = append(bounds, b.Seconds())
+	}
+	if !sort.Float64sAreSorted(bounds) {
+		return nil, errors.New("latency_histogram_buckets must be sorted")
+	}
+
+	return &serviceGraphProcessor{
+		nextConsumer:        nextConsumer,
+		metricsExporterName: cfg.MetricsExporter,
+		wait:                cfg.Wait,
+		maxItems:            cfg.MaxItems,
+		bounds:              bounds,
+		logger:              logger,
+		startTime:           time.Now(),
+		now:                 time.Now,
+
+		pending:  make(map[edgeKey]*edge),
+		stats:    make(map[edgeLabels]*edgeStats),
+		unpaired: make(map[edgeLabels]uint64),
+	}, nil
+}
+
+func (p *serviceGraphProcessor) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: false}
+}
+
+// Start is invoked during service startup.
+func (p *serviceGraphProcessor) Start(_ context.Context, host component.Host) error {
+	for k, exp := range host.GetExporters()[configmodels.MetricsDataType] {
+		if k.Name() != p.metricsExporterName {
+			continue
+		}
+		metricsExp, ok := exp.(component.MetricsExporter)
+		if !ok {
+			return fmt.Errorf("the exporter %q isn't a metrics exporter", k.Name())
+		}
+		p.metricsExporter = metricsExp
+		return nil
+	}
+	return fmt.Errorf("failed to find metrics exporter %q", p.metricsExporterName)
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *serviceGraphProcessor) Shutdown(context.Context) error {
+	return nil
+}
+
+func (p *serviceGraphProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	p.mut.Lock()
+	now := p.now()
+	p.expire(now)
+	p.consume(td, now)
+	md, ok := p.buildMetrics(now)
+	p.mut.Unlock()
+
+	if ok {
+		if err := p.metricsExporter.ConsumeMetrics(ctx, md); err != nil {
+			return err
+		}
+	}
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// expire counts and removes the requests whose other span wasn't seen in
+// time.
+func (p *serviceGraphProcessor) expire(now time.Time) {
+	for k, e := range p.pending {
+		if now.Before(e.expiration) {
+			continue
+		}
+		p.unpaired[edgeLabels{client: e.client, server: e.server}]++
+		delete(p.pending, k)
+	}
+}
+
+func (p *serviceGraphProcessor) consume(td pdata.Traces, now time.Time) {
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+
+		var service string
+		if v, ok := rs.Resource().Attributes().Get("service.name"); ok {
+			service = v.StringVal()
+		}
+
+		ilss := rs.InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				p.consumeSpan(service, spans.At(k), now)
+			}
+		}
+	}
+}
+
+func (p *serviceGraphProcessor) consumeSpan(service string, span pdata.Span, now time.Time) {
+	key := edgeKey{traceID: span.TraceID().Bytes()}
+	switch span.Kind() {
+	case pdata.SpanKindCLIENT:
+		key.spanID = span.SpanID().Bytes()
+	case pdata.SpanKindSERVER:
+		if span.ParentSpanID().IsEmpty() {
+			return
+		}
+		key.spanID = span.ParentSpanID().Bytes()
+	default:
+		return
+	}
+
+	e, ok := p.pending[key]
+	if !ok {
+		if len(p.pending) >= p.maxItems {
+			droppedSpansTotal.Inc()
+			return
+		}
+		e = &edge{expiration: now.Add(p.wait)}
+		p.pending[key] = e
+	}
+
+	latency := time.Duration(span.EndTime() - span.StartTime()).Seconds()
+	if span.Kind() == pdata.SpanKindCLIENT {
+		e.client, e.clientLatency, e.clientSeen = service, latency, true
+	} else {
+		e.server, e.serverLatency, e.serverSeen = service, latency, true
+	}
+	if span.Status().Code() == pdata.StatusCodeError {
+		e.failed = true
+	}
+
+	if e.clientSeen && e.serverSeen {
+		p.record(e)
+		delete(p.pending, key)
+	}
+}
+
+// record adds a request whose client and server spans were both seen to the
+// metrics of its edge.
+func (p *serviceGraphProcessor) record(e *edge) {
+	labels := edgeLabels{client: e.client, server: e.server}
+	stats, ok := p.stats[labels]
+	if !ok {
+		stats = &edgeStats{
+			clientBuckets: make([]uint64, len(p.bounds)+1),
+			serverBuckets: make([]uint64, len(p.bounds)+1),
+		}
+		p.stats[labels] = stats
+	}
+
+	stats.requests++
+	if e.failed {
+		stats.failed++
+	}
+	stats.clientBuckets[sort.SearchFloat64s(p.bounds, e.clientLatency)]++
+	stats.clientSum += e.clientLatency
+	stats.serverBuckets[sort.SearchFloat64s(p.bounds, e.serverLatency)]++
+	stats.serverSum += e.serverLatency
+}
+
+// buildMetrics returns the cumulative metrics of every edge. It returns false
+// if there are no metrics yet.
+func (p *serviceGraphProcessor) buildMetrics(now time.Time) (pdata.Metrics, bool) {
+	if len(p.stats) == 0 && len(p.unpaired) == 0 {
+		return pdata.Metrics{}, false
+	}
+
+	var (
+		start = pdata.TimeToUnixNano(p.startTime)
+		ts    = pdata.TimeToUnixNano(now)
+	)
+
+	requests := newSum("request")
+	failed := newSum("request_failed")
+	clientLatency := newHistogram("request_client_seconds")
+	serverLatency := newHistogram("request_server_seconds")
+	unpaired := newSum("unpaired_spans")
+
+	for labels, stats := range p.stats {
+		appendIntPoint(requests, labels, start, ts, stats.requests)
+		appendIntPoint(failed, labels, start, ts, stats.failed)
+		p.appendHistogramPoint(clientLatency, labels, start, ts, stats.clientBuckets, stats.clientSum, stats.requests)
+		p.appendHistogramPoint(serverLatency, labels, start, ts, stats.serverBuckets, stats.serverSum, stats.requests)
+	}
+	for labels, count := range p.unpaired {
+		appendIntPoint(unpaired, labels, start, ts, count)
+	}
+
+	ilm := pdata.NewInstrumentationLibraryMetrics()
+	ilm.InstrumentationLibrary().SetName(TypeStr)
+	for _, m := range []pdata.Metric{requests, failed, clientLatency, serverLatency, unpaired} {
+		ilm.Metrics().Append(m)
+	}
+
+	rm := pdata.NewResourceMetrics()
+	rm.InstrumentationLibraryMetrics().Append(ilm)
+	md := pdata.NewMetrics()
+	md.ResourceMetrics().Append(rm)
+	return md, true
+}
+
+func newSum(name string) pdata.Metric {
+	m := pdata.NewMetric()
+	m.SetName(name)
+	m.SetDataType(pdata.MetricDataTypeIntSum)
+	m.IntSum().SetIsMonotonic(true)
+	m.IntSum().SetAggregationTemporality(pdata.AggregationTemporalityCumulative)
+	return m
+}
+
+func newHistogram(name string) pdata.Metric {
+	m := pdata.NewMetric()
+	m.SetName(name)
+	m.SetDataType(pdata.MetricDataTypeDoubleHistogram)
+	m.DoubleHistogram().SetAggregationTemporality(pdata.AggregationTemporalityCumulative)
+	return m
+}
+
+func appendIntPoint(m pdata.Metric, labels edgeLabels, start, ts pdata.TimestampUnixNano, v uint64) {
+	dp := pdata.NewIntDataPoint()
+	dp.LabelsMap().InitFromMap(labels.toMap())
+	dp.SetStartTime(start)
+	dp.SetTimestamp(ts)
+	dp.SetValue(int64(v))
+	m.IntSum().DataPoints().Append(dp)
+}
+
+func (p *serviceGraphProcessor) appendHistogramPoint(m pdata.Metric, labels edgeLabels, start, ts pdata.TimestampUnixNano, buckets []uint64, sum float64, count uint64) {
+	dp := pdata.NewDoubleHistogramDataPoint()
+	dp.LabelsMap().InitFromMap(labels.toMap())
+	dp.SetStartTime(start)
+	dp.SetTimestamp(ts)
+	dp.SetExplicitBounds(p.bounds)
+	dp.SetBucketCounts(append([]uint64(nil), buckets...))
+	dp.SetSum(sum)
+	dp.SetCount(count)
+	m.DoubleHistogram().DataPoints().Append(dp)
+}
+
+func (l edgeLabels) toMap() map[string]string {
+	labels := make(map[string]string, 2)
+	if l.client != "" {
+		labels[clientLabel] = l.client
+	}
+	if l.server != "" {
+		labels[serverLabel] = l.server
+	}
+	return labels
+}
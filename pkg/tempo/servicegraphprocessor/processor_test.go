@@ -0,0 +1,166 @@
+package servicegraphprocessor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.uber.org/zap"
+)
+
+func TestServiceGraphProcessor(t *testing.T) {
+	p, sink, now := newTestProcessor(t)
+
+	// The client and server spans of a request arrive in separate batches.
+	require.NoError(t, p.ConsumeTraces(context.Background(), traces("frontend", span{kind: pdata.SpanKindCLIENT, id: 1, latency: 300 * time.Millisecond})))
+	require.Empty(t, sink.AllMetrics())
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), traces("checkout",
+		span{kind: pdata.SpanKindSERVER, id: 10, parent: 1, latency: 200 * time.Millisecond, failed: true},
+		// Internal spans aren't paired.
+		span{kind: pdata.SpanKindINTERNAL, id: 11, parent: 10},
+		// Calls checkout makes to the database, which isn't traced.
+		span{kind: pdata.SpanKindCLIENT, id: 12, parent: 10, latency: 50 * time.Millisecond},
+	)))
+
+	require.Equal(t, map[string]float64{
+		"request{client=frontend,server=checkout}":                      1,
+		"request_failed{client=frontend,server=checkout}":               1,
+		"request_client_seconds_count{client=frontend,server=checkout}": 1,
+		"request_client_seconds_sum{client=frontend,server=checkout}":   0.3,
+		"request_server_seconds_count{client=frontend,server=checkout}": 1,
+		"request_server_seconds_sum{client=frontend,server=checkout}":   0.2,
+	}, flatten(lastMetrics(sink)))
+
+	// The database call is counted as unpaired once it expires.
+	*now = now.Add(DefaultWait)
+	require.NoError(t, p.ConsumeTraces(context.Background(), pdata.NewTraces()))
+	require.Equal(t, 1.0, flatten(lastMetrics(sink))["unpaired_spans{client=checkout}"])
+	require.Empty(t, p.(*serviceGraphProcessor).pending)
+}
+
+func TestServiceGraphProcessor_MaxItems(t *testing.T) {
+	p, _, _ := newTestProcessor(t)
+	p.(*serviceGraphProcessor).maxItems = 1
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), traces("frontend",
+		span{kind: pdata.SpanKindCLIENT, id: 1},
+		span{kind: pdata.SpanKindCLIENT, id: 2},
+	)))
+	require.Len(t, p.(*serviceGraphProcessor).pending, 1)
+}
+
+func TestServiceGraphProcessor_Buckets(t *testing.T) {
+	_, err := newTraceProcessor(consumertest.NewTracesNop(), &Config{
+		MetricsExporter:         "remote_write",
+		Wait:                    time.Second,
+		MaxItems:                1,
+		LatencyHistogramBuckets: []time.Duration{time.Second, time.Millisecond},
+	}, zap.NewNop())
+	require.EqualError(t, err, "latency_histogram_buckets must be sorted")
+}
+
+func newTestProcessor(t *testing.T) (component.TracesProcessor, *consumertest.MetricsSink, *time.Time) {
+	t.Helper()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.MetricsExporter = "remote_write"
+
+	p, err := newTraceProcessor(consumertest.NewTracesNop(), cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	sink := &consumertest.MetricsSink{}
+	now := time.Unix(100, 0)
+
+	sgp := p.(*serviceGraphProcessor)
+	sgp.metricsExporter = &sinkExporter{MetricsSink: sink}
+	sgp.now = func() time.Time { return now }
+	return p, sink, &now
+}
+
+type span struct {
+	kind       pdata.SpanKind
+	id, parent byte
+	latency    time.Duration
+	failed     bool
+}
+
+// traces returns traces of a service holding spans of the same trace.
+func traces(service string, spans ...span) pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+	rs := td.ResourceSpans().At(0)
+	rs.Resource().Attributes().InsertString("service.name", service)
+	rs.InstrumentationLibrarySpans().Resize(1)
+
+	out := rs.InstrumentationLibrarySpans().At(0).Spans()
+	out.Resize(len(spans))
+	for i, s := range spans {
+		sp := out.At(i)
+		sp.SetTraceID(pdata.NewTraceID([16]byte{1}))
+		sp.SetSpanID(pdata.NewSpanID([8]byte{s.id}))
+		if s.parent != 0 {
+			sp.SetParentSpanID(pdata.NewSpanID([8]byte{s.parent}))
+		}
+		sp.SetKind(s.kind)
+		sp.SetStartTime(pdata.TimeToUnixNano(time.Unix(0, 0)))
+		sp.SetEndTime(pdata.TimeToUnixNano(time.Unix(0, 0).Add(s.latency)))
+		if s.failed {
+			sp.Status().SetCode(pdata.StatusCodeError)
+		}
+	}
+	return td
+}
+
+func lastMetrics(sink *consumertest.MetricsSink) pdata.Metrics {
+	all := sink.AllMetrics()
+	return all[len(all)-1]
+}
+
+// flatten returns the values of sums and the sums and counts of histograms in
+// md, keyed by metric name and labels.
+func flatten(md pdata.Metrics) map[string]float64 {
+	res := make(map[string]float64)
+
+	key := func(name string, labels pdata.StringMap) string {
+		var pairs []string
+		for _, l := range []string{clientLabel, serverLabel} {
+			if v, ok := labels.Get(l); ok {
+				pairs = append(pairs, l+"="+v)
+			}
+		}
+		return fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+	}
+
+	metrics := md.ResourceMetrics().At(0).InstrumentationLibraryMetrics().At(0).Metrics()
+	for i := 0; i < metrics.Len(); i++ {
+		m := metrics.At(i)
+		switch m.DataType() {
+		case pdata.MetricDataTypeIntSum:
+			for j := 0; j < m.IntSum().DataPoints().Len(); j++ {
+				dp := m.IntSum().DataPoints().At(j)
+				res[key(m.Name(), dp.LabelsMap())] = float64(dp.Value())
+			}
+		case pdata.MetricDataTypeDoubleHistogram:
+			for j := 0; j < m.DoubleHistogram().DataPoints().Len(); j++ {
+				dp := m.DoubleHistogram().DataPoints().At(j)
+				res[key(m.Name()+"_count", dp.LabelsMap())] = float64(dp.Count())
+				res[key(m.Name()+"_sum", dp.LabelsMap())] = dp.Sum()
+			}
+		}
+	}
+	return res
+}
+
+type sinkExporter struct {
+	*consumertest.MetricsSink
+}
+
+func (e *sinkExporter) Start(context.Context, component.Host) error { return nil }
+func (e *sinkExporter) Shutdown(context.Context) error              { return nil }
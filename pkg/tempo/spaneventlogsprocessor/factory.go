@@ -0,0 +1,89 @@
+package spaneventlogsprocessor
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the span event logs processor.
+const TypeStr = "span_event_logs"
+
+// DefaultTimeout is the default amount of time to wait for the logs subsystem
+// to accept a log line before dropping it.
+const DefaultTimeout = time.Millisecond
+
+// Config holds the configuration for the span event logs processor.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	// LokiName is the name of the Loki config to send log lines to.
+	LokiName string `mapstructure:"loki_name"`
+
+	// Rules select which span events are sent as log lines. If empty, only
+	// exception events are sent.
+	Rules []Rule `mapstructure:"rules"`
+
+	// ResourceLabels maps resource attributes to labels added to log lines,
+	// so log lines can share labels with the logs of the service that
+	// emitted the span.
+	ResourceLabels []ResourceLabel `mapstructure:"resource_labels"`
+
+	// Timeout is how long to wait for Loki to accept the log lines of a
+	// batch of spans before dropping the remaining lines.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Rule selects span events to send as log lines.
+type Rule struct {
+	// EventName is an anchored regular expression matched against the name of
+	// span events.
+	EventName string `mapstructure:"event_name"`
+
+	// Attributes is the list of event attributes to include in the log line.
+	// If empty, all attributes are included.
+	Attributes []string `mapstructure:"attributes"`
+}
+
+// ResourceLabel adds the value of a resource attribute as a label to log
+// lines.
+type ResourceLabel struct {
+	// Attribute is the name of the resource attribute.
+	Attribute string `mapstructure:"attribute"`
+
+	// Label is the name of the label to add.
+	Label string `mapstructure:"label"`
+}
+
+// NewFactory returns a new factory for the span event logs processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+		Timeout: DefaultTimeout,
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	cp component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg)
+}
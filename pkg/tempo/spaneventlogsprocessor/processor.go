@@ -0,0 +1,320 @@
+// Package spaneventlogsprocessor implements an OpenTelemetry processor that
+// sends selected span events to the logs subsystem as log lines.
+package spaneventlogsprocessor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	util "github.com/cortexproject/cortex/pkg/util/log"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	tracetranslator "go.opentelemetry.io/collector/translator/trace"
+)
+
+var (
+	logsSentTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_tempo_span_event_logs_sent_total",
+		Help: "Total number of span events sent to Loki as log lines",
+	})
+
+	logsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_tempo_span_event_logs_dropped_total",
+		Help: "Total number of span event log lines dropped because Loki was unavailable or didn't accept them in time",
+	})
+)
+
+// defaultEventName is the span event selected when no rules are configured.
+const defaultEventName = "exception"
+
+// Labels set on every log line.
+const (
+	eventLabel   = "event"
+	serviceLabel = "service"
+)
+
+// EntrySender sends log entries to an instance of the logs subsystem.
+type EntrySender interface {
+	// SendEntries sends entries, waiting at most timeout for all of them to
+	// be accepted. Returns the number of entries sent.
+	SendEntries(entries []api.Entry, timeout time.Duration) int
+}
+
+// Host is implemented by component.Hosts that can look up instances of the
+// logs subsystem by name.
+type Host interface {
+	// LogsInstance returns the logs instance with the given name, or nil if
+	// it doesn't exist.
+	LogsInstance(name string) EntrySender
+}
+
+type rule struct {
+	eventName  *regexp.Regexp
+	attributes []string
+}
+
+type spanEventLogsProcessor struct {
+	nextConsumer   consumer.TracesConsumer
+	lokiName       string
+	rules          []rule
+	resourceLabels []ResourceLabel
+	timeout        time.Duration
+	logger         log.Logger
+
+	host Host
+}
+
+func newTraceProcessor(nextConsumer consumer.TracesConsumer, cfg *Config) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+
+	rules, err := compileRules(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateResourceLabels(cfg.ResourceLabels); err != nil {
+		return nil, err
+	}
+
+	if cfg.LokiName == "" {
+		return nil, fmt.Errorf("loki_name must be set")
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &spanEventLogsProcessor{
+		nextConsumer:   nextConsumer,
+		lokiName:       cfg.LokiName,
+		rules:          rules,
+		resourceLabels: cfg.ResourceLabels,
+		timeout:        timeout,
+		logger:         log.With(util.Logger, "component", "tempo span event logs"),
+	}, nil
+}
+
+// ValidateRules checks that all rules can be compiled.
+func ValidateRules(rules []Rule) error {
+	_, err := compileRules(rules)
+	return err
+}
+
+// ValidateResourceLabels checks that resource attributes are mapped to valid
+// label names that don't replace the labels set by the processor.
+func ValidateResourceLabels(resourceLabels []ResourceLabel) error {
+	for _, rl := range resourceLabels {
+		if rl.Attribute == "" {
+			return fmt.Errorf("resource_labels: attribute must be set")
+		}
+		if !model.LabelName(rl.Label).IsValid() {
+			return fmt.Errorf("resource_labels: invalid label name %q for attribute %q", rl.Label, rl.Attribute)
+		}
+		if rl.Label == eventLabel || rl.Label == serviceLabel {
+			return fmt.Errorf("resource_labels: label name %q for attribute %q is reserved", rl.Label, rl.Attribute)
+		}
+	}
+	return nil
+}
+
+func compileRules(rules []Rule) ([]rule, error) {
+	if len(rules) == 0 {
+		rules = []Rule{{EventName: defaultEventName}}
+	}
+
+	compiled := make([]rule, 0, len(rules))
+	for i, r := range rules {
+		if r.EventName == "" {
+			return nil, fmt.Errorf("rule %d: event_name must be set", i)
+		}
+		re, err := regexp.Compile("^(?:" + r.EventName + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid event_name: %w", i, err)
+		}
+		compiled = append(compiled, rule{eventName: re, attributes: r.Attributes})
+	}
+	return compiled, nil
+}
+
+func (p *spanEventLogsProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	entries := p.extractEntries(td)
+	if len(entries) > 0 {
+		p.send(entries)
+	}
+
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// extractEntries returns a log entry for every span event in td that matches
+// one of the rules.
+func (p *spanEventLogsProcessor) extractEntries(td pdata.Traces) []api.Entry {
+	var entries []api.Entry
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+
+		labels := ResourceLabelSet(rs.Resource().Attributes(), p.resourceLabels)
+
+		ilss := rs.InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+
+				events := span.Events()
+				for l := 0; l < events.Len(); l++ {
+					event := events.At(l)
+
+					r, ok := p.match(event.Name())
+					if !ok {
+						continue
+					}
+
+					entry, err := newEntry(labels, span, event, r)
+					if err != nil {
+						level.Debug(p.logger).Log("msg", "failed to create log line for span event", "event", event.Name(), "err", err)
+						continue
+					}
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+
+	return entries
+}
+
+// ResourceLabelSet returns the labels shared by all log lines of spans with
+// the given resource attributes: the service label and the labels mapped
+// from resource attributes by resourceLabels.
+func ResourceLabelSet(attrs pdata.AttributeMap, resourceLabels []ResourceLabel) model.LabelSet {
+	labels := make(model.LabelSet, len(resourceLabels)+1)
+	if v, ok := attrs.Get("service.name"); ok && v.StringVal() != "" {
+		labels[serviceLabel] = model.LabelValue(v.StringVal())
+	}
+	for _, rl := range resourceLabels {
+		v, ok := attrs.Get(rl.Attribute)
+		if !ok {
+			continue
+		}
+		if value := tracetranslator.AttributeValueToString(v, false); value != "" {
+			labels[model.LabelName(rl.Label)] = model.LabelValue(value)
+		}
+	}
+	return labels
+}
+
+func (p *spanEventLogsProcessor) match(eventName string) (rule, bool) {
+	for _, r := range p.rules {
+		if r.eventName.MatchString(eventName) {
+			return r, true
+		}
+	}
+	return rule{}, false
+}
+
+func (p *spanEventLogsProcessor) send(entries []api.Entry) {
+	var inst EntrySender
+	if p.host != nil {
+		inst = p.host.LogsInstance(p.lokiName)
+	}
+	if inst == nil {
+		logsDroppedTotal.Add(float64(len(entries)))
+		level.Debug(p.logger).Log("msg", "dropping span event log lines, loki config not found", "loki_name", p.lokiName, "count", len(entries))
+		return
+	}
+
+	sent := inst.SendEntries(entries, p.timeout)
+	logsSentTotal.Add(float64(sent))
+	logsDroppedTotal.Add(float64(len(entries) - sent))
+}
+
+// newEntry builds a log entry for a span event. The line is logfmt-encoded and
+// holds the span and trace IDs so it can be correlated with the trace.
+// resourceLabels are added to the labels of the entry.
+func newEntry(resourceLabels model.LabelSet, span pdata.Span, event pdata.SpanEvent, r rule) (api.Entry, error) {
+	keyvals := []interface{}{
+		"span", span.Name(),
+		"trace_id", span.TraceID().HexString(),
+		"span_id", span.SpanID().HexString(),
+		"event", event.Name(),
+	}
+
+	attrs := event.Attributes()
+	if len(r.attributes) > 0 {
+		for _, key := range r.attributes {
+			if v, ok := attrs.Get(key); ok {
+				keyvals = append(keyvals, key, tracetranslator.AttributeValueToString(v, false))
+			}
+		}
+	} else {
+		keys := make([]string, 0, attrs.Len())
+		attrs.ForEach(func(k string, _ pdata.AttributeValue) {
+			keys = append(keys, k)
+		})
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			v, _ := attrs.Get(key)
+			keyvals = append(keyvals, key, tracetranslator.AttributeValueToString(v, false))
+		}
+	}
+
+	var line bytes.Buffer
+	if err := log.NewLogfmtLogger(&line).Log(keyvals...); err != nil {
+		return api.Entry{}, err
+	}
+
+	ts := time.Unix(0, int64(event.Timestamp()))
+	if event.Timestamp() == 0 {
+		ts = time.Now()
+	}
+
+	labels := resourceLabels.Clone()
+	labels[eventLabel] = model.LabelValue(event.Name())
+
+	return api.Entry{
+		Labels: labels,
+		Entry: logproto.Entry{
+			Timestamp: ts,
+			Line:      strings.TrimSuffix(line.String(), "\n"),
+		},
+	}, nil
+}
+
+func (p *spanEventLogsProcessor) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: false}
+}
+
+// Start is invoked during service startup.
+func (p *spanEventLogsProcessor) Start(_ context.Context, host component.Host) error {
+	h, ok := host.(Host)
+	if !ok {
+		return fmt.Errorf("%s requires a host that can send logs", TypeStr)
+	}
+	p.host = h
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *spanEventLogsProcessor) Shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,194 @@
+package spaneventlogsprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/loki/pkg/promtail/api"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer/pdata"
+)
+
+func TestSpanEventLogsProcessor(t *testing.T) {
+	tt := []struct {
+		name   string
+		rules  []Rule
+		expect []string
+	}{
+		{
+			name: "default rules",
+			expect: []string{
+				`span=GET trace_id=01000000000000000000000000000000 span_id=0200000000000000 event=exception exception.message=boom exception.stacktrace="panic: boom"`,
+			},
+		},
+		{
+			name: "selected attributes",
+			rules: []Rule{
+				{EventName: "exception", Attributes: []string{"exception.message"}},
+				{EventName: "cache_.*"},
+			},
+			expect: []string{
+				`span=GET trace_id=01000000000000000000000000000000 span_id=0200000000000000 event=exception exception.message=boom`,
+				`span=GET trace_id=01000000000000000000000000000000 span_id=0200000000000000 event=cache_miss key=user`,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := new(tracesSink)
+			p, err := newTraceProcessor(sink, &Config{LokiName: "default", Rules: tc.rules})
+			require.NoError(t, err)
+
+			host := &mockHost{sender: &mockSender{}}
+			require.NoError(t, p.Start(context.Background(), host))
+
+			require.NoError(t, p.ConsumeTraces(context.Background(), testTraces()))
+			require.Equal(t, 1, sink.spans)
+
+			var lines []string
+			for _, e := range host.sender.entries {
+				require.Equal(t, model.LabelValue("checkout"), e.Labels["service"])
+				lines = append(lines, e.Line)
+			}
+			require.Equal(t, tc.expect, lines)
+		})
+	}
+}
+
+func TestSpanEventLogsProcessor_ResourceLabels(t *testing.T) {
+	sink := new(tracesSink)
+	p, err := newTraceProcessor(sink, &Config{
+		LokiName: "default",
+		ResourceLabels: []ResourceLabel{
+			{Attribute: "k8s.pod.name", Label: "pod"},
+			{Attribute: "missing", Label: "missing"},
+		},
+	})
+	require.NoError(t, err)
+
+	host := &mockHost{sender: &mockSender{}}
+	require.NoError(t, p.Start(context.Background(), host))
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces()))
+
+	require.Len(t, host.sender.entries, 1)
+	require.Equal(t, model.LabelSet{
+		"event":   "exception",
+		"service": "checkout",
+		"pod":     "checkout-7d9f8",
+	}, host.sender.entries[0].Labels)
+}
+
+func TestSpanEventLogsProcessor_InvalidResourceLabels(t *testing.T) {
+	tt := []ResourceLabel{
+		{Label: "pod"},
+		{Attribute: "k8s.pod.name", Label: "k8s.pod.name"},
+		{Attribute: "k8s.pod.name", Label: "event"},
+	}
+	for _, rl := range tt {
+		_, err := newTraceProcessor(new(tracesSink), &Config{
+			LokiName:       "default",
+			ResourceLabels: []ResourceLabel{rl},
+		})
+		require.Error(t, err, "expected error for %v", rl)
+	}
+}
+
+func TestSpanEventLogsProcessor_MissingInstance(t *testing.T) {
+	sink := new(tracesSink)
+	p, err := newTraceProcessor(sink, &Config{LokiName: "missing"})
+	require.NoError(t, err)
+
+	require.NoError(t, p.Start(context.Background(), &mockHost{}))
+
+	// Traces should still be passed along when logs can't be sent.
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces()))
+	require.Equal(t, 1, sink.spans)
+}
+
+func TestSpanEventLogsProcessor_RequiresHost(t *testing.T) {
+	p, err := newTraceProcessor(new(tracesSink), &Config{LokiName: "default"})
+	require.NoError(t, err)
+	require.Error(t, p.Start(context.Background(), nopHost{}))
+}
+
+func testTraces() pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+
+	rs := td.ResourceSpans().At(0)
+	rs.Resource().Attributes().InsertString("service.name", "checkout")
+	rs.Resource().Attributes().InsertString("k8s.pod.name", "checkout-7d9f8")
+	rs.InstrumentationLibrarySpans().Resize(1)
+
+	spans := rs.InstrumentationLibrarySpans().At(0).Spans()
+	spans.Resize(1)
+
+	span := spans.At(0)
+	span.SetName("GET")
+	span.SetTraceID(pdata.NewTraceID([16]byte{1}))
+	span.SetSpanID(pdata.NewSpanID([8]byte{2}))
+
+	span.Events().Resize(3)
+
+	exception := span.Events().At(0)
+	exception.SetName("exception")
+	exception.SetTimestamp(pdata.TimestampUnixNano(time.Now().UnixNano()))
+	exception.Attributes().InsertString("exception.message", "boom")
+	exception.Attributes().InsertString("exception.stacktrace", "panic: boom")
+
+	miss := span.Events().At(1)
+	miss.SetName("cache_miss")
+	miss.Attributes().InsertString("key", "user")
+
+	other := span.Events().At(2)
+	other.SetName("other")
+
+	return td
+}
+
+type tracesSink struct {
+	spans int
+}
+
+func (s *tracesSink) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	s.spans += td.SpanCount()
+	return nil
+}
+
+type nopHost struct{}
+
+func (nopHost) ReportFatalError(error) {}
+
+func (nopHost) GetFactory(component.Kind, configmodels.Type) component.Factory { return nil }
+
+func (nopHost) GetExtensions() map[configmodels.Extension]component.ServiceExtension { return nil }
+
+func (nopHost) GetExporters() map[configmodels.DataType]map[configmodels.Exporter]component.Exporter {
+	return nil
+}
+
+type mockHost struct {
+	nopHost
+	sender *mockSender
+}
+
+func (h *mockHost) LogsInstance(name string) EntrySender {
+	if h.sender == nil {
+		return nil
+	}
+	return h.sender
+}
+
+type mockSender struct {
+	entries []api.Entry
+}
+
+func (s *mockSender) SendEntries(entries []api.Entry, _ time.Duration) int {
+	s.entries = append(s.entries, entries...)
+	return len(entries)
+}
@@ -0,0 +1,76 @@
+package spanfilterprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the span filter processor.
+const TypeStr = "span_filter"
+
+// Config holds the configuration for the span filter processor.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	// Drop lists the rules of spans to drop. Spans matching any rule are
+	// dropped.
+	Drop []Rule `mapstructure:"drop"`
+}
+
+// Rule matches spans by their service, name and attributes. Spans match when
+// all of the set fields match.
+type Rule struct {
+	// Service is an anchored regular expression matched against the
+	// service.name resource attribute.
+	Service string `mapstructure:"service"`
+
+	// SpanName is an anchored regular expression matched against the name of
+	// spans.
+	SpanName string `mapstructure:"span_name"`
+
+	// Attributes must all match.
+	Attributes []Attribute `mapstructure:"attributes"`
+}
+
+// Attribute matches spans by the value of a span or resource attribute.
+type Attribute struct {
+	// Key of the attribute. Span attributes take precedence over resource
+	// attributes.
+	Key string `mapstructure:"key"`
+
+	// Value is an anchored regular expression matched against the string
+	// form of the attribute value. Any value matches if empty.
+	Value string `mapstructure:"value"`
+}
+
+// NewFactory returns a new factory for the span filter processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	cp component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg)
+}
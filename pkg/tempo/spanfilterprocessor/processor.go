@@ -0,0 +1,196 @@
+// Package spanfilterprocessor implements an OpenTelemetry processor that
+// drops spans matching a set of rules, such as spans of health checks, so
+// they aren't sent to the backends.
+package spanfilterprocessor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	tracetranslator "go.opentelemetry.io/collector/translator/trace"
+)
+
+var droppedSpansTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "agent_tempo_span_filter_dropped_spans_total",
+	Help: "Total number of spans dropped by the span filter",
+})
+
+type rule struct {
+	service    *regexp.Regexp
+	spanName   *regexp.Regexp
+	attributes []attribute
+}
+
+type attribute struct {
+	key   string
+	value *regexp.Regexp
+}
+
+type spanFilterProcessor struct {
+	nextConsumer consumer.TracesConsumer
+	rules        []rule
+}
+
+func newTraceProcessor(nextConsumer consumer.TracesConsumer, cfg *Config) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+
+	rules, err := compileRules(cfg.Drop)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spanFilterProcessor{
+		nextConsumer: nextConsumer,
+		rules:        rules,
+	}, nil
+}
+
+// ValidateRules checks that all rules can be compiled.
+func ValidateRules(rules []Rule) error {
+	_, err := compileRules(rules)
+	return err
+}
+
+func compileRules(rules []Rule) ([]rule, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("at least one drop rule must be set")
+	}
+
+	compiled := make([]rule, 0, len(rules))
+	for i, r := range rules {
+		if r.Service == "" && r.SpanName == "" && len(r.Attributes) == 0 {
+			return nil, fmt.Errorf("rule %d: one of service, span_name or attributes must be set", i)
+		}
+
+		var (
+			c   rule
+			err error
+		)
+		if c.service, err = compileRegex(r.Service); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid service: %w", i, err)
+		}
+		if c.spanName, err = compileRegex(r.SpanName); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid span_name: %w", i, err)
+		}
+		for _, a := range r.Attributes {
+			if a.Key == "" {
+				return nil, fmt.Errorf("rule %d: attribute key must be set", i)
+			}
+			value, err := compileRegex(a.Value)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid value of attribute %q: %w", i, a.Key, err)
+			}
+			c.attributes = append(c.attributes, attribute{key: a.Key, value: value})
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// compileRegex compiles an anchored regular expression. It returns nil for
+// an empty expression, which matches everything.
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (p *spanFilterProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	var dropped int
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+		resource := rs.Resource().Attributes()
+
+		var service string
+		if v, ok := resource.Get("service.name"); ok {
+			service = v.StringVal()
+		}
+
+		ilss := rs.InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+
+			kept := pdata.NewSpanSlice()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				if p.matches(service, resource, span) {
+					dropped++
+					continue
+				}
+				kept.Append(span)
+			}
+
+			if kept.Len() < spans.Len() {
+				spans.Resize(0)
+				kept.MoveAndAppendTo(spans)
+			}
+		}
+	}
+
+	if dropped > 0 {
+		droppedSpansTotal.Add(float64(dropped))
+		if td.SpanCount() == 0 {
+			return nil
+		}
+	}
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// matches returns whether span matches any of the rules.
+func (p *spanFilterProcessor) matches(service string, resource pdata.AttributeMap, span pdata.Span) bool {
+	for _, r := range p.rules {
+		if r.matches(service, resource, span) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) matches(service string, resource pdata.AttributeMap, span pdata.Span) bool {
+	if r.service != nil && !r.service.MatchString(service) {
+		return false
+	}
+	if r.spanName != nil && !r.spanName.MatchString(span.Name()) {
+		return false
+	}
+
+	for _, a := range r.attributes {
+		v, ok := span.Attributes().Get(a.key)
+		if !ok {
+			v, ok = resource.Get(a.key)
+		}
+		if !ok {
+			return false
+		}
+		if a.value != nil && !a.value.MatchString(tracetranslator.AttributeValueToString(v, false)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *spanFilterProcessor) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: true}
+}
+
+// Start is invoked during service startup.
+func (p *spanFilterProcessor) Start(context.Context, component.Host) error {
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *spanFilterProcessor) Shutdown(context.Context) error {
+	return nil
+}
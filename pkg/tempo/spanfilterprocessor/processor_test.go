@@ -0,0 +1,146 @@
+package spanfilterprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/pdata"
+)
+
+func TestSpanFilterProcessor(t *testing.T) {
+	tt := []struct {
+		name   string
+		rules  []Rule
+		expect []string
+	}{
+		{
+			name:   "span name",
+			rules:  []Rule{{SpanName: "/healthz|/ready"}},
+			expect: []string{"GET /users", "SELECT"},
+		},
+		{
+			name:   "service",
+			rules:  []Rule{{Service: "db.*"}},
+			expect: []string{"/healthz", "GET /users", "/ready"},
+		},
+		{
+			name:   "service and span name",
+			rules:  []Rule{{Service: "frontend", SpanName: "/healthz"}},
+			expect: []string{"GET /users", "/ready", "SELECT"},
+		},
+		{
+			name:   "span attribute",
+			rules:  []Rule{{Attributes: []Attribute{{Key: "http.status_code", Value: "2.."}}}},
+			expect: []string{"/ready", "SELECT"},
+		},
+		{
+			name:   "resource attribute",
+			rules:  []Rule{{Attributes: []Attribute{{Key: "k8s.namespace.name", Value: "kube-.*"}}}},
+			expect: []string{"/healthz", "GET /users", "/ready"},
+		},
+		{
+			name:   "attribute presence",
+			rules:  []Rule{{Attributes: []Attribute{{Key: "http.status_code"}}}},
+			expect: []string{"SELECT"},
+		},
+		{
+			name:   "all attributes must match",
+			rules:  []Rule{{Attributes: []Attribute{{Key: "http.status_code"}, {Key: "missing"}}}},
+			expect: []string{"/healthz", "GET /users", "/ready", "SELECT"},
+		},
+		{
+			name:   "any rule",
+			rules:  []Rule{{SpanName: "/healthz"}, {Service: "db"}},
+			expect: []string{"GET /users", "/ready"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := new(tracesSink)
+			p, err := newTraceProcessor(sink, &Config{Drop: tc.rules})
+			require.NoError(t, err)
+
+			require.NoError(t, p.ConsumeTraces(context.Background(), testTraces()))
+			require.Equal(t, tc.expect, sink.spanNames)
+		})
+	}
+}
+
+func TestSpanFilterProcessor_AllDropped(t *testing.T) {
+	sink := new(tracesSink)
+	p, err := newTraceProcessor(sink, &Config{Drop: []Rule{{SpanName: ".*"}}})
+	require.NoError(t, err)
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces()))
+	require.Equal(t, 0, sink.calls, "empty traces should not be sent")
+}
+
+func TestValidateRules(t *testing.T) {
+	tt := [][]Rule{
+		nil,
+		{{}},
+		{{SpanName: "("}},
+		{{Service: "("}},
+		{{Attributes: []Attribute{{Value: "foo"}}}},
+		{{Attributes: []Attribute{{Key: "foo", Value: "("}}}},
+	}
+	for _, rules := range tt {
+		require.Error(t, ValidateRules(rules), "expected error for %+v", rules)
+	}
+	require.NoError(t, ValidateRules([]Rule{{SpanName: "/healthz"}}))
+}
+
+// testTraces returns spans of the frontend and db services.
+func testTraces() pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(2)
+
+	for i, svc := range []struct {
+		service, namespace string
+		spans              []string
+	}{
+		{service: "frontend", namespace: "default", spans: []string{"/healthz", "GET /users", "/ready"}},
+		{service: "db", namespace: "kube-system", spans: []string{"SELECT"}},
+	} {
+		rs := td.ResourceSpans().At(i)
+		rs.Resource().Attributes().InsertString("service.name", svc.service)
+		rs.Resource().Attributes().InsertString("k8s.namespace.name", svc.namespace)
+		rs.InstrumentationLibrarySpans().Resize(1)
+
+		spans := rs.InstrumentationLibrarySpans().At(0).Spans()
+		spans.Resize(len(svc.spans))
+		for j, name := range svc.spans {
+			spans.At(j).SetName(name)
+		}
+	}
+
+	spans := td.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans()
+	spans.At(0).Attributes().InsertInt("http.status_code", 200)
+	spans.At(1).Attributes().InsertInt("http.status_code", 200)
+	spans.At(2).Attributes().InsertInt("http.status_code", 503)
+
+	return td
+}
+
+type tracesSink struct {
+	calls     int
+	spanNames []string
+}
+
+func (s *tracesSink) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	s.calls++
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		ilss := rss.At(i).InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				s.spanNames = append(s.spanNames, spans.At(k).Name())
+			}
+		}
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
+	"github.com/grafana/agent/pkg/loki"
+	"github.com/grafana/agent/pkg/prom/instance"
 	zaplogfmt "github.com/jsternberg/zap-logfmt"
 	prom_client "github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
@@ -25,20 +27,37 @@ type Tempo struct {
 
 	leveller *logLeveller
 	logger   *zap.Logger
-	reg      prom_client.Registerer
+	logs     *loki.Loki
+	metrics  instance.Manager
+
+	// The collector records its metrics in global views, shared by all
+	// instances.
+	metricViews    []*view.View
+	metricExporter view.Exporter
 }
 
-// New creates and starts Loki log collection.
-func New(reg prom_client.Registerer, cfg Config, level logrus.Level) (*Tempo, error) {
+// New creates and starts trace collection. logs is used to send span events
+// as log lines and metrics is used to write span metrics to Prometheus
+// instances; both may be nil.
+func New(reg prom_client.Registerer, cfg Config, logs *loki.Loki, metrics instance.Manager, level logrus.Level) (*Tempo, error) {
 	var leveller logLeveller
 
+	views, exporter, err := newMetricViews(reg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric views: %w", err)
+	}
+
 	tempo := &Tempo{
-		instances: make(map[string]*Instance),
-		leveller:  &leveller,
-		logger:    newLogger(&leveller),
-		reg:       reg,
+		instances:      make(map[string]*Instance),
+		leveller:       &leveller,
+		logger:         newLogger(&leveller),
+		logs:           logs,
+		metrics:        metrics,
+		metricViews:    views,
+		metricExporter: exporter,
 	}
 	if err := tempo.ApplyConfig(cfg, level); err != nil {
+		tempo.Stop()
 		return nil, err
 	}
 	return tempo, nil
@@ -66,12 +85,9 @@ func (t *Tempo) ApplyConfig(cfg Config, level logrus.Level) error {
 			continue
 		}
 
-		var (
-			instLogger = t.logger.With(zap.String("tempo_config", c.Name))
-			instReg    = prom_client.WrapRegistererWith(prom_client.Labels{"tempo_config": c.Name}, t.reg)
-		)
+		instLogger := t.logger.With(zap.String("tempo_config", c.Name))
 
-		inst, err := NewInstance(instReg, c, instLogger)
+		inst, err := NewInstance(c, t.logs, t.metrics, instLogger)
 		if err != nil {
 			return fmt.Errorf("failed to create tempo instance %s: %w", c.Name, err)
 		}
@@ -99,6 +115,9 @@ func (t *Tempo) Stop() {
 	for _, i := range t.instances {
 		i.Stop()
 	}
+
+	view.UnregisterExporter(t.metricExporter)
+	view.Unregister(t.metricViews...)
 }
 
 func newLogger(zapLevel zapcore.LevelEnabler) *zap.Logger {
@@ -155,22 +174,26 @@ func (l *logLeveller) Enabled(target zapcore.Level) bool {
 	return l.inner.Enabled(target)
 }
 
-func newMetricViews(reg prom_client.Registerer) ([]*view.View, error) {
+// newMetricViews registers the views of the collector's metrics, such as
+// the spans accepted by receivers and sent by exporters, and exposes them in
+// reg as agent_tempo_ metrics.
+func newMetricViews(reg prom_client.Registerer) ([]*view.View, view.Exporter, error) {
 	views := obsreport.Configure(configtelemetry.LevelBasic)
 	err := view.Register(views...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to register views: %w", err)
+		return nil, nil, fmt.Errorf("failed to register views: %w", err)
 	}
 
 	pe, err := prometheus.NewExporter(prometheus.Options{
-		Namespace:  "tempo",
+		Namespace:  "agent_tempo",
 		Registerer: reg,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create prometheus exporter: %w", err)
+		view.Unregister(views...)
+		return nil, nil, fmt.Errorf("failed to create prometheus exporter: %w", err)
 	}
 
 	view.RegisterExporter(pe)
 
-	return views, nil
+	return views, pe, nil
 }
@@ -1,7 +1,10 @@
 package tempo
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
@@ -48,7 +51,8 @@ configs:
 	var loggingLevel logging.Level
 	require.NoError(t, loggingLevel.Set("debug"))
 
-	tempo, err := New(prometheus.NewRegistry(), cfg, logrus.InfoLevel)
+	reg := prometheus.NewRegistry()
+	tempo, err := New(reg, cfg, nil, nil, logrus.InfoLevel)
 	require.NoError(t, err)
 	t.Cleanup(tempo.Stop)
 
@@ -63,6 +67,18 @@ configs:
 		require.Equal(t, 1, tr.SpanCount())
 		// Nothing to do, send succeeded.
 	}
+
+	// The collector's metrics are exposed with the agent_tempo_ prefix.
+	require.Eventually(t, func() bool {
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		for _, mf := range families {
+			if mf.GetName() == "agent_tempo_exporter_sent_spans" {
+				return len(mf.GetMetric()) == 1 && mf.GetMetric()[0].GetCounter().GetValue() == 1
+			}
+		}
+		return false
+	}, 10*time.Second, 100*time.Millisecond)
 }
 
 func TestTempo_ApplyConfig(t *testing.T) {
@@ -92,7 +108,7 @@ configs:
 	err := dec.Decode(&cfg)
 	require.NoError(t, err)
 
-	tempo, err := New(prometheus.NewRegistry(), cfg, logrus.DebugLevel)
+	tempo, err := New(prometheus.NewRegistry(), cfg, nil, nil, logrus.DebugLevel)
 	require.NoError(t, err)
 	t.Cleanup(tempo.Stop)
 
@@ -134,6 +150,79 @@ configs:
 	}
 }
 
+func TestTempo_OTLPHTTP(t *testing.T) {
+	tracesCh := make(chan pdata.Traces)
+	tracesAddr := tempoutils.NewTestServer(t, func(t pdata.Traces) {
+		tracesCh <- t
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	httpAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	tempoCfgText := util.Untab(fmt.Sprintf(`
+configs:
+- name: default
+  receivers:
+		otlp:
+			protocols:
+				http:
+					endpoint: %s
+					cors_allowed_origins: ["https://*.example.com"]
+	push_config:
+		endpoint: %s
+		insecure: true
+		batch:
+			timeout: 100ms
+			send_batch_size: 1
+	`, httpAddr, tracesAddr))
+
+	var cfg Config
+	dec := yaml.NewDecoder(strings.NewReader(tempoCfgText))
+	dec.SetStrict(true)
+	require.NoError(t, dec.Decode(&cfg))
+
+	tempo, err := New(prometheus.NewRegistry(), cfg, nil, nil, logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	url := fmt.Sprintf("http://%s/v1/trace", httpAddr)
+
+	// Browsers send a preflight request before pushing spans.
+	req, err := http.NewRequest(http.MethodOptions, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+	td.ResourceSpans().At(0).InstrumentationLibrarySpans().Resize(1)
+	spans := td.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans()
+	spans.Resize(1)
+	spans.At(0).SetName("test-span")
+	spans.At(0).SetTraceID(pdata.NewTraceID([16]byte{1}))
+	spans.At(0).SetSpanID(pdata.NewSpanID([8]byte{1}))
+	body, err := td.ToOtlpProtoBytes()
+	require.NoError(t, err)
+
+	resp, err = http.Post(url, "application/x-protobuf", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case <-time.After(30 * time.Second):
+		require.Fail(t, "failed to receive a span after 30 seconds")
+	case tr := <-tracesCh:
+		require.Equal(t, 1, tr.SpanCount())
+	}
+}
+
 func testJaegerTracer(t *testing.T) opentracing.Tracer {
 	t.Helper()
 
@@ -0,0 +1,155 @@
+// Package tenantexporter implements an OpenTelemetry exporter that sends
+// spans over OTLP with their tenant, as found by the tenant processor, in a
+// header. Spans of different tenants are sent in separate requests.
+package tenantexporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/agent/pkg/tempo/tenantprocessor"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+	"go.uber.org/zap"
+)
+
+type tenantExporter struct {
+	cfg     Config
+	params  component.ExporterCreateParams
+	factory component.ExporterFactory
+	logger  *zap.Logger
+
+	mut       sync.Mutex
+	host      component.Host
+	exporters map[string]component.TracesExporter
+	stopped   bool
+}
+
+func newTraceExporter(params component.ExporterCreateParams, cfg *Config) (component.TracesExporter, error) {
+	if cfg.TenantHeader == "" {
+		return nil, errors.New("tenant_header must be set")
+	}
+	if cfg.Endpoint == "" {
+		return nil, errors.New("OTLP exporter config requires an Endpoint")
+	}
+
+	return &tenantExporter{
+		cfg:       *cfg,
+		params:    params,
+		factory:   otlpexporter.NewFactory(),
+		logger:    params.Logger,
+		exporters: make(map[string]component.TracesExporter),
+	}, nil
+}
+
+// Start is invoked during service startup.
+func (e *tenantExporter) Start(_ context.Context, host component.Host) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.host = host
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (e *tenantExporter) Shutdown(ctx context.Context) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	e.stopped = true
+
+	var errs []error
+	for tenant, exp := range e.exporters {
+		if err := exp.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		delete(e.exporters, tenant)
+	}
+	return componenterror.CombineErrors(errs)
+}
+
+func (e *tenantExporter) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: false}
+}
+
+func (e *tenantExporter) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	var errs []error
+	for tenant, batch := range splitByTenant(td) {
+		exp, err := e.exporter(ctx, tenant)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := exp.ConsumeTraces(ctx, batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return componenterror.CombineErrors(errs)
+}
+
+// exporter returns the OTLP exporter for a tenant, creating it if it doesn't
+// exist yet. Spans without a tenant are sent without the tenant header.
+func (e *tenantExporter) exporter(ctx context.Context, tenant string) (component.TracesExporter, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if e.stopped {
+		return nil, errors.New("exporter is shut down")
+	}
+	if exp, ok := e.exporters[tenant]; ok {
+		return exp, nil
+	}
+
+	cfg := e.cfg.Config
+	cfg.Headers = make(map[string]string, len(e.cfg.Headers)+1)
+	for k, v := range e.cfg.Headers {
+		cfg.Headers[k] = v
+	}
+	if tenant != "" {
+		cfg.Headers[e.cfg.TenantHeader] = tenant
+	}
+
+	exp, err := e.factory.CreateTracesExporter(ctx, e.params, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter for tenant %q: %w", tenant, err)
+	}
+	if err := exp.Start(ctx, e.host); err != nil {
+		return nil, fmt.Errorf("failed to start exporter for tenant %q: %w", tenant, err)
+	}
+	e.logger.Debug("created exporter for tenant", zap.String("tenant", tenant))
+
+	e.exporters[tenant] = exp
+	return exp, nil
+}
+
+// splitByTenant groups the resource spans of td by their tenant. td isn't
+// modified, as it may be shared with other exporters.
+func splitByTenant(td pdata.Traces) map[string]pdata.Traces {
+	batches := make(map[string]pdata.Traces)
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+
+		var tenant string
+		if v, ok := rs.Resource().Attributes().Get(tenantprocessor.AttributeKey); ok {
+			tenant = v.StringVal()
+		}
+
+		batch, ok := batches[tenant]
+		if !ok {
+			batch = pdata.NewTraces()
+			batches[tenant] = batch
+		}
+
+		dest := batch.ResourceSpans()
+		dest.Resize(dest.Len() + 1)
+		rs.CopyTo(dest.At(dest.Len() - 1))
+		dest.At(dest.Len() - 1).Resource().Attributes().Delete(tenantprocessor.AttributeKey)
+	}
+
+	return batches
+}
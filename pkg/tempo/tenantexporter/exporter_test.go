@@ -0,0 +1,184 @@
+package tenantexporter
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/tempo/tenantprocessor"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTenantExporter(t *testing.T) {
+	sink := &tenantSink{}
+	addr := newTestReceiver(t, sink)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.TLSSetting.Insecure = true
+	cfg.Headers = map[string]string{"x-static": "true"}
+	cfg.QueueSettings.Enabled = false
+	cfg.RetrySettings.Enabled = false
+
+	exp, err := newTraceExporter(component.ExporterCreateParams{Logger: zap.NewNop()}, cfg)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), nopHost{}))
+	t.Cleanup(func() {
+		require.NoError(t, exp.Shutdown(context.Background()))
+	})
+
+	td := testTraces("team-a", "team-b", "team-a", "")
+	require.NoError(t, exp.ConsumeTraces(context.Background(), td))
+
+	require.Eventually(t, func() bool {
+		return sink.spanCount() == 4
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, map[string]int{"team-a": 2, "team-b": 1, "": 1}, sink.spansPerTenant())
+	require.True(t, sink.staticHeaders, "configured headers should be sent for every tenant")
+	require.False(t, sink.internalAttribute, "internal tenant attribute should not be sent")
+
+	// The consumed traces may be shared with other exporters and must not be
+	// changed.
+	_, ok := td.ResourceSpans().At(0).Resource().Attributes().Get(tenantprocessor.AttributeKey)
+	require.True(t, ok)
+}
+
+func TestSplitByTenant(t *testing.T) {
+	batches := splitByTenant(testTraces("team-a", "team-b", "team-a"))
+
+	var tenants []string
+	for tenant := range batches {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	require.Equal(t, []string{"team-a", "team-b"}, tenants)
+
+	require.Equal(t, 2, batches["team-a"].ResourceSpans().Len())
+	require.Equal(t, 1, batches["team-b"].ResourceSpans().Len())
+}
+
+// testTraces returns traces with one resource with a single span for every
+// given tenant. Resources with an empty tenant don't have a tenant.
+func testTraces(tenants ...string) pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(len(tenants))
+
+	for i, tenant := range tenants {
+		rs := td.ResourceSpans().At(i)
+		rs.Resource().Attributes().InsertString("service.name", "checkout")
+		if tenant != "" {
+			rs.Resource().Attributes().InsertString(tenantprocessor.AttributeKey, tenant)
+		}
+
+		rs.InstrumentationLibrarySpans().Resize(1)
+		spans := rs.InstrumentationLibrarySpans().At(0).Spans()
+		spans.Resize(1)
+		spans.At(0).SetName("GET")
+		spans.At(0).SetTraceID(pdata.NewTraceID([16]byte{byte(i + 1)}))
+		spans.At(0).SetSpanID(pdata.NewSpanID([8]byte{byte(i + 1)}))
+	}
+
+	return td
+}
+
+// newTestReceiver starts an OTLP gRPC receiver sending traces to sink and
+// returns its address.
+func newTestReceiver(t *testing.T, sink *tenantSink) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	factory := otlpreceiver.NewFactory()
+	cfg := factory.CreateDefaultConfig().(*otlpreceiver.Config)
+	cfg.GRPC.NetAddr.Endpoint = addr
+	cfg.HTTP = nil
+
+	r, err := factory.CreateTracesReceiver(context.Background(), component.ReceiverCreateParams{Logger: zap.NewNop()}, cfg, sink)
+	require.NoError(t, err)
+	require.NoError(t, r.Start(context.Background(), nopHost{}))
+	t.Cleanup(func() {
+		require.NoError(t, r.Shutdown(context.Background()))
+	})
+
+	return addr
+}
+
+// tenantSink records the spans received per value of the tenant header.
+type tenantSink struct {
+	mut               sync.Mutex
+	spans             map[string]int
+	staticHeaders     bool
+	internalAttribute bool
+}
+
+func (s *tenantSink) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var tenant string
+	if values := md.Get(DefaultTenantHeader); len(values) > 0 {
+		tenant = values[0]
+	}
+	if s.spans == nil {
+		s.spans = make(map[string]int)
+	}
+	s.spans[tenant] += td.SpanCount()
+	s.staticHeaders = len(md.Get("x-static")) > 0
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		if _, ok := rss.At(i).Resource().Attributes().Get(tenantprocessor.AttributeKey); ok {
+			s.internalAttribute = true
+		}
+	}
+	return nil
+}
+
+func (s *tenantSink) spanCount() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var total int
+	for _, n := range s.spans {
+		total += n
+	}
+	return total
+}
+
+func (s *tenantSink) spansPerTenant() map[string]int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	res := make(map[string]int, len(s.spans))
+	for tenant, n := range s.spans {
+		res[tenant] = n
+	}
+	return res
+}
+
+type nopHost struct{}
+
+func (nopHost) ReportFatalError(error) {}
+
+func (nopHost) GetFactory(component.Kind, configmodels.Type) component.Factory { return nil }
+
+func (nopHost) GetExtensions() map[configmodels.Extension]component.ServiceExtension { return nil }
+
+func (nopHost) GetExporters() map[configmodels.DataType]map[configmodels.Exporter]component.Exporter {
+	return nil
+}
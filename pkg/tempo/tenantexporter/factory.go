@@ -0,0 +1,54 @@
+package tenantexporter
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+)
+
+// TypeStr is the unique identifier for the tenant exporter.
+const TypeStr = "otlp_tenant"
+
+// DefaultTenantHeader is the default header the tenant is sent in.
+const DefaultTenantHeader = "X-Scope-OrgID"
+
+// Config holds the configuration for the tenant exporter. It accepts every
+// setting of the OTLP exporter.
+type Config struct {
+	otlpexporter.Config `mapstructure:",squash"`
+
+	// TenantHeader is the header the tenant of spans is sent in.
+	TenantHeader string `mapstructure:"tenant_header"`
+}
+
+// NewFactory returns a new factory for the tenant exporter.
+func NewFactory() component.ExporterFactory {
+	return exporterhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		exporterhelper.WithTraces(createTraceExporter),
+	)
+}
+
+func createDefaultConfig() configmodels.Exporter {
+	otlpCfg := otlpexporter.NewFactory().CreateDefaultConfig().(*otlpexporter.Config)
+	otlpCfg.TypeVal = TypeStr
+	otlpCfg.NameVal = TypeStr
+
+	return &Config{
+		Config:       *otlpCfg,
+		TenantHeader: DefaultTenantHeader,
+	}
+}
+
+func createTraceExporter(
+	_ context.Context,
+	params component.ExporterCreateParams,
+	cfg configmodels.Exporter,
+) (component.TracesExporter, error) {
+	oCfg := cfg.(*Config)
+	return newTraceExporter(params, oCfg)
+}
@@ -0,0 +1,57 @@
+package tenantprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the tenant processor.
+const TypeStr = "tenant"
+
+// Config holds the configuration for the tenant processor.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	// FromResourceAttribute is the resource attribute holding the tenant of
+	// spans. It takes precedence over FromHeader.
+	FromResourceAttribute string `mapstructure:"from_resource_attribute"`
+
+	// FromHeader is the gRPC metadata key of incoming requests holding the
+	// tenant of spans.
+	FromHeader string `mapstructure:"from_header"`
+
+	// Default is the tenant of spans without one.
+	Default string `mapstructure:"default"`
+}
+
+// NewFactory returns a new factory for the tenant processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	cp component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg)
+}
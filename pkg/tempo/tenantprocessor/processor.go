@@ -0,0 +1,101 @@
+// Package tenantprocessor implements an OpenTelemetry processor that finds
+// the tenant of incoming spans so it can be sent along with them by the
+// tenant exporter.
+package tenantprocessor
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"google.golang.org/grpc/metadata"
+)
+
+// AttributeKey is the resource attribute the resolved tenant is stored in.
+// It's removed from spans by the tenant exporter before they're sent.
+const AttributeKey = "grafana_agent.tenant"
+
+type tenantProcessor struct {
+	nextConsumer          consumer.TracesConsumer
+	fromResourceAttribute string
+	fromHeader            string
+	defaultTenant         string
+}
+
+func newTraceProcessor(nextConsumer consumer.TracesConsumer, cfg *Config) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+	if cfg.FromResourceAttribute == "" && cfg.FromHeader == "" && cfg.Default == "" {
+		return nil, errors.New("one of from_resource_attribute, from_header or default must be set")
+	}
+
+	return &tenantProcessor{
+		nextConsumer:          nextConsumer,
+		fromResourceAttribute: cfg.FromResourceAttribute,
+		fromHeader:            cfg.FromHeader,
+		defaultTenant:         cfg.Default,
+	}, nil
+}
+
+func (p *tenantProcessor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	headerTenant := p.headerTenant(ctx)
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		attrs := rss.At(i).Resource().Attributes()
+
+		tenant := headerTenant
+		if p.fromResourceAttribute != "" {
+			if v, ok := attrs.Get(p.fromResourceAttribute); ok && v.Type() == pdata.AttributeValueSTRING && v.StringVal() != "" {
+				tenant = v.StringVal()
+			}
+		}
+		if tenant == "" {
+			tenant = p.defaultTenant
+		}
+
+		// The attribute is always replaced so clients can't pick a tenant
+		// by setting it themselves.
+		if tenant == "" {
+			attrs.Delete(AttributeKey)
+		} else {
+			attrs.UpsertString(AttributeKey, tenant)
+		}
+	}
+
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// headerTenant returns the tenant from the metadata of the incoming request.
+// Only gRPC receivers keep the metadata of requests in ctx.
+func (p *tenantProcessor) headerTenant(ctx context.Context) string {
+	if p.fromHeader == "" {
+		return ""
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(p.fromHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (p *tenantProcessor) GetCapabilities() component.ProcessorCapabilities {
+	return component.ProcessorCapabilities{MutatesConsumedData: true}
+}
+
+// Start is invoked during service startup.
+func (p *tenantProcessor) Start(context.Context, component.Host) error {
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *tenantProcessor) Shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package tenantprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTenantProcessor(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    Config
+		md     metadata.MD
+		expect []string
+	}{
+		{
+			name:   "from resource attribute",
+			cfg:    Config{FromResourceAttribute: "tenant"},
+			expect: []string{"team-a", ""},
+		},
+		{
+			name:   "from header",
+			cfg:    Config{FromHeader: "X-Scope-OrgID"},
+			md:     metadata.Pairs("x-scope-orgid", "team-b"),
+			expect: []string{"team-b", "team-b"},
+		},
+		{
+			name:   "resource attribute takes precedence",
+			cfg:    Config{FromResourceAttribute: "tenant", FromHeader: "x-scope-orgid"},
+			md:     metadata.Pairs("x-scope-orgid", "team-b"),
+			expect: []string{"team-a", "team-b"},
+		},
+		{
+			name:   "default",
+			cfg:    Config{FromResourceAttribute: "tenant", FromHeader: "x-scope-orgid", Default: "anonymous"},
+			expect: []string{"team-a", "anonymous"},
+		},
+		{
+			name:   "internal attribute is replaced",
+			cfg:    Config{FromHeader: "x-scope-orgid"},
+			expect: []string{"", ""},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := new(tracesSink)
+			p, err := newTraceProcessor(sink, &tc.cfg)
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+			require.NoError(t, p.ConsumeTraces(ctx, testTraces()))
+
+			var tenants []string
+			rss := sink.traces.ResourceSpans()
+			for i := 0; i < rss.Len(); i++ {
+				var tenant string
+				if v, ok := rss.At(i).Resource().Attributes().Get(AttributeKey); ok {
+					tenant = v.StringVal()
+				}
+				tenants = append(tenants, tenant)
+			}
+			require.Equal(t, tc.expect, tenants)
+		})
+	}
+}
+
+func TestTenantProcessor_RequiresSource(t *testing.T) {
+	_, err := newTraceProcessor(new(tracesSink), &Config{})
+	require.Error(t, err)
+}
+
+// testTraces returns traces with two resources. Only the first one has a
+// tenant attribute, while the second one tries to set the internal attribute
+// directly.
+func testTraces() pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(2)
+
+	first := td.ResourceSpans().At(0)
+	first.Resource().Attributes().InsertString("service.name", "checkout")
+	first.Resource().Attributes().InsertString("tenant", "team-a")
+
+	second := td.ResourceSpans().At(1)
+	second.Resource().Attributes().InsertString("service.name", "cart")
+	second.Resource().Attributes().InsertString(AttributeKey, "spoofed")
+
+	return td
+}
+
+type tracesSink struct {
+	traces pdata.Traces
+}
+
+func (s *tracesSink) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	s.traces = td
+	return nil
+}
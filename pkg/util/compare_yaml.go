@@ -3,19 +3,41 @@ package util
 import (
 	"bytes"
 
+	config_util "github.com/prometheus/common/config"
 	"gopkg.in/yaml.v2"
 )
 
 // CompareYAML marshals a and b to YAML and ensures that their contents are
 // equal. If either Marshal fails, CompareYAML returns false.
+//
+// Secrets are compared by their real values rather than the scrubbed
+// <secret> placeholder so changing a secret is detected as a change.
 func CompareYAML(a, b interface{}) bool {
-	aBytes, err := yaml.Marshal(a)
+	aBytes, err := marshalUnscrubbed(a)
 	if err != nil {
 		return false
 	}
-	bBytes, err := yaml.Marshal(b)
+	bBytes, err := marshalUnscrubbed(b)
 	if err != nil {
 		return false
 	}
 	return bytes.Equal(aBytes, bBytes)
 }
+
+func marshalUnscrubbed(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetHook(func(in interface{}) (ok bool, out interface{}, err error) {
+		switch v := in.(type) {
+		case config_util.Secret:
+			return true, string(v), nil
+		default:
+			return false, nil, nil
+		}
+	})
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	err := enc.Close()
+	return buf.Bytes(), err
+}
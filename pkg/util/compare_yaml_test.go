@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCompareYAML_Secrets(t *testing.T) {
+	type config struct {
+		Password config_util.Secret `yaml:"password"`
+	}
+
+	var (
+		a = config{Password: "foo"}
+		b = config{Password: "bar"}
+	)
+
+	// Secrets should still be scrubbed when marshaling normally.
+	bb, err := yaml.Marshal(a)
+	require.NoError(t, err)
+	require.Equal(t, "password: <secret>\n", string(bb))
+
+	require.True(t, CompareYAML(a, a))
+	require.False(t, CompareYAML(a, b))
+}
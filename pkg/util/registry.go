@@ -0,0 +1,94 @@
+package util
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// HighCardinalityMetrics are internal metrics with a series per file, scrape
+// job, or discovered target group. They can be dropped to keep the size of
+// the Agent's own metrics independent of what it collects.
+var HighCardinalityMetrics = []string{
+	// Promtail file targets, one series per tailed file.
+	"promtail_read_bytes_total",
+	"promtail_file_bytes_total",
+	"promtail_read_lines_total",
+
+	// Prometheus scrape and discovery, one series per scrape job or SD
+	// config.
+	"prometheus_target_sync_length_seconds",
+	"prometheus_sd_discovered_targets",
+}
+
+// SubsystemRegistry is a registry for the metrics of a single subsystem of
+// the Agent. Metrics gathered from it are renamed to start with its prefix,
+// so metrics of different subsystems never collide.
+type SubsystemRegistry struct {
+	*prometheus.Registry
+
+	prefix string
+	drop   map[string]struct{}
+}
+
+// NewSubsystemRegistry creates a new SubsystemRegistry. Metric families named
+// in drop are removed when gathering; names are matched before prefixing.
+func NewSubsystemRegistry(prefix string, drop []string) *SubsystemRegistry {
+	return &SubsystemRegistry{
+		Registry: prometheus.NewRegistry(),
+		prefix:   prefix,
+		drop:     dropSet(drop),
+	}
+}
+
+// Gather implements prometheus.Gatherer.
+func (r *SubsystemRegistry) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := r.Registry.Gather()
+	mfs = dropFamilies(mfs, r.drop)
+	for _, mf := range mfs {
+		mf.Name = prefixName(r.prefix, mf.GetName())
+	}
+	return mfs, err
+}
+
+// prefixName returns name with prefix. The agent_ prefix of names is replaced
+// rather than repeated, and names that already have prefix are unchanged.
+func prefixName(prefix, name string) *string {
+	if prefix != "" && !strings.HasPrefix(name, prefix) {
+		name = prefix + strings.TrimPrefix(name, "agent_")
+	}
+	return &name
+}
+
+// DropMetrics returns a Gatherer that removes metric families named in drop
+// from the metrics gathered by g.
+func DropMetrics(g prometheus.Gatherer, drop []string) prometheus.Gatherer {
+	set := dropSet(drop)
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		return dropFamilies(mfs, set), err
+	})
+}
+
+func dropSet(names []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return set
+}
+
+func dropFamilies(mfs []*dto.MetricFamily, drop map[string]struct{}) []*dto.MetricFamily {
+	if len(drop) == 0 {
+		return mfs
+	}
+
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		if _, ok := drop[mf.GetName()]; !ok {
+			kept = append(kept, mf)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubsystemRegistry(t *testing.T) {
+	reg := NewSubsystemRegistry("agent_prom_", []string{"prometheus_target_sync_length_seconds"})
+
+	for _, name := range []string{
+		"agent_wal_samples_appended_total",
+		"agent_prom_already_prefixed_total",
+		"prometheus_remote_storage_samples_total",
+		"prometheus_target_sync_length_seconds",
+	} {
+		promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: name, Help: name})
+	}
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	require.ElementsMatch(t, []string{
+		"agent_prom_wal_samples_appended_total",
+		"agent_prom_already_prefixed_total",
+		"agent_prom_prometheus_remote_storage_samples_total",
+	}, names)
+}
+
+func TestDropMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "promtail_read_bytes_total", Help: "dropped"})
+	promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "promtail_sent_bytes_total", Help: "kept"})
+
+	mfs, err := DropMetrics(reg, HighCardinalityMetrics).Gather()
+	require.NoError(t, err)
+	require.Len(t, mfs, 1)
+	require.Equal(t, "promtail_sent_bytes_total", mfs[0].GetName())
+}
@@ -20,8 +20,9 @@ type Config = server.Config
 
 // Server is a Weaveworks server with support for reloading.
 type Server struct {
-	reg *util.Unregisterer
-	log log.Logger
+	reg      *util.Unregisterer
+	gatherer prometheus.Gatherer
+	log      log.Logger
 
 	// Last received config, used for seeing if any changes need to be made.
 	cfg Config
@@ -45,11 +46,14 @@ type Server struct {
 	doneCh    chan bool
 }
 
-// New creates a new Server. ApplyConfig must be called after creating a server.
-func New(r prometheus.Registerer, l log.Logger) *Server {
+// New creates a new Server. Metrics of the server are registered to r, and
+// the /metrics endpoint serves the metrics gathered from g. ApplyConfig must
+// be called after creating a server.
+func New(r prometheus.Registerer, g prometheus.Gatherer, l log.Logger) *Server {
 	return &Server{
-		reg: util.WrapWithUnregisterer(r),
-		log: l,
+		reg:      util.WrapWithUnregisterer(r),
+		gatherer: g,
+		log:      l,
 
 		srvCh:     make(chan *server.Server, 1),
 		reloading: atomic.NewBool(false),
@@ -64,8 +68,8 @@ func New(r prometheus.Registerer, l log.Logger) *Server {
 // signals. This contrasts the upstream default of watching for termination
 // signals.
 //
-// ApplyConfig will override the registerer of the Config to the registerer
-// passed to New.
+// ApplyConfig will override the registerer and gatherer of the Config to the
+// ones passed to New.
 func (s *Server) ApplyConfig(cfg Config, wire func(mux *mux.Router, grpc *grpc.Server)) error {
 	s.srvMut.Lock()
 	defer s.srvMut.Unlock()
@@ -80,6 +84,7 @@ func (s *Server) ApplyConfig(cfg Config, wire func(mux *mux.Router, grpc *grpc.S
 	// metrics.
 	s.reg.UnregisterAll()
 	cfg.Registerer = s.reg
+	cfg.Gatherer = s.gatherer
 
 	if cfg.SignalHandler == nil {
 		cfg.SignalHandler = newNoopSignalHandler()
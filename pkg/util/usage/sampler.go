@@ -0,0 +1,221 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/google/pprof/profile"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxProfileShare is the largest share of the sample interval that the CPU
+// is profiled for. The CPU profiler is process-wide, so it's kept free for
+// operators profiling the Agent through /debug/pprof most of the time.
+const maxProfileShare = 10
+
+// attribution identifies the component that usage is attributed to. Usage
+// of goroutines without labels has an empty attribution.
+type attribution struct {
+	component string
+	name      string
+}
+
+// Sampler periodically profiles the process to attribute CPU time and
+// goroutines to components.
+type Sampler struct {
+	logger   log.Logger
+	interval time.Duration
+	duration time.Duration
+
+	mut        sync.Mutex
+	cpu        map[attribution]float64
+	goroutines map[attribution]float64
+	skipped    float64
+
+	cpuDesc        *prometheus.Desc
+	goroutinesDesc *prometheus.Desc
+	skippedDesc    *prometheus.Desc
+}
+
+// NewSampler creates a new Sampler and registers its metrics to reg. Every
+// interval, the CPU is profiled for duration. duration is capped to a tenth
+// of interval. Call Run to start sampling.
+func NewSampler(logger log.Logger, reg prometheus.Registerer, interval, duration time.Duration) *Sampler {
+	if maxDuration := interval / maxProfileShare; duration <= 0 || duration > maxDuration {
+		duration = maxDuration
+	}
+
+	s := &Sampler{
+		logger:   logger,
+		interval: interval,
+		duration: duration,
+
+		cpu:        make(map[attribution]float64),
+		goroutines: make(map[attribution]float64),
+
+		cpuDesc: prometheus.NewDesc(
+			"agent_usage_cpu_seconds_total",
+			"Estimated CPU time spent by goroutines of an instance or integration. Extrapolated from CPU profiles when the profile duration is shorter than the sample interval.",
+			[]string{"component", "name"}, nil,
+		),
+		goroutinesDesc: prometheus.NewDesc(
+			"agent_usage_goroutines",
+			"Number of goroutines of an instance or integration as of the last sample.",
+			[]string{"component", "name"}, nil,
+		),
+		skippedDesc: prometheus.NewDesc(
+			"agent_usage_cpu_samples_skipped_total",
+			"Total number of CPU samples skipped because the CPU profiler was already in use.",
+			nil, nil,
+		),
+	}
+	if reg != nil {
+		reg.MustRegister(s)
+	}
+	return s
+}
+
+// Run samples usage every interval until ctx is canceled.
+func (s *Sampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.sample(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Sampler) sample(ctx context.Context) {
+	if err := s.sampleGoroutines(); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to sample goroutines", "err", err)
+	}
+	if err := s.sampleCPU(ctx); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to sample CPU usage", "err", err)
+	}
+}
+
+func (s *Sampler) sampleGoroutines() error {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 0); err != nil {
+		return err
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		return err
+	}
+	idx, err := sampleIndex(p, "goroutine")
+	if err != nil {
+		return err
+	}
+
+	goroutines := make(map[attribution]float64)
+	for _, sample := range p.Sample {
+		goroutines[sampleAttribution(sample)] += float64(sample.Value[idx])
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.goroutines = goroutines
+	return nil
+}
+
+// sampleCPU profiles the CPU for the sample duration. Only one CPU profile
+// can run at a time: if the process is already being profiled, for example
+// through /debug/pprof, the sample is skipped and an error is returned.
+func (s *Sampler) sampleCPU(ctx context.Context) error {
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		s.mut.Lock()
+		s.skipped++
+		s.mut.Unlock()
+		return fmt.Errorf("skipped CPU sample: %w", err)
+	}
+
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+	case <-time.After(s.duration):
+	}
+	pprof.StopCPUProfile()
+	elapsed := time.Since(start)
+
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		return err
+	}
+	idx, err := sampleIndex(p, "cpu")
+	if err != nil {
+		return err
+	}
+
+	// The CPU time outside of the profile is estimated from the CPU time
+	// within it.
+	scale := 1.0
+	if elapsed < s.interval {
+		scale = s.interval.Seconds() / elapsed.Seconds()
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	// CPU time is recorded in nanoseconds.
+	for _, sample := range p.Sample {
+		s.cpu[sampleAttribution(sample)] += float64(sample.Value[idx]) / 1e9 * scale
+	}
+	return nil
+}
+
+// sampleIndex returns the index of the sample values of p with the given
+// type.
+func sampleIndex(p *profile.Profile, typ string) (int, error) {
+	for i, st := range p.SampleType {
+		if st.Type == typ {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("profile has no %q sample values", typ)
+}
+
+func sampleAttribution(s *profile.Sample) attribution {
+	var a attribution
+	if v := s.Label[componentLabel]; len(v) > 0 {
+		a.component = v[0]
+	}
+	if v := s.Label[nameLabel]; len(v) > 0 {
+		a.name = v[0]
+	}
+	return a
+}
+
+// Describe implements prometheus.Collector.
+func (s *Sampler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.cpuDesc
+	ch <- s.goroutinesDesc
+	ch <- s.skippedDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *Sampler) Collect(ch chan<- prometheus.Metric) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for a, v := range s.cpu {
+		ch <- prometheus.MustNewConstMetric(s.cpuDesc, prometheus.CounterValue, v, a.component, a.name)
+	}
+	for a, v := range s.goroutines {
+		ch <- prometheus.MustNewConstMetric(s.goroutinesDesc, prometheus.GaugeValue, v, a.component, a.name)
+	}
+	ch <- prometheus.MustNewConstMetric(s.skippedDesc, prometheus.CounterValue, s.skipped)
+}
@@ -0,0 +1,71 @@
+package usage
+
+import (
+	"context"
+	"io/ioutil"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampler_Goroutines(t *testing.T) {
+	var (
+		stop    = make(chan struct{})
+		started = make(chan struct{})
+	)
+	defer close(stop)
+
+	Do(context.Background(), ComponentInstance, "test", func(_ context.Context) {
+		for i := 0; i < 3; i++ {
+			go func() {
+				started <- struct{}{}
+				<-stop
+			}()
+		}
+	})
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+
+	s := NewSampler(log.NewNopLogger(), nil, time.Minute, 0)
+	require.NoError(t, s.sampleGoroutines())
+
+	require.Equal(t, float64(3), s.goroutines[attribution{ComponentInstance, "test"}])
+	require.Greater(t, s.goroutines[attribution{}], float64(0), "unlabeled goroutines should be counted")
+}
+
+func TestSampler_CPU(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	Do(ctx, ComponentIntegration, "busy", func(ctx context.Context) {
+		go func() {
+			for ctx.Err() == nil {
+			}
+		}()
+	})
+
+	s := NewSampler(log.NewNopLogger(), nil, 500*time.Millisecond, 0)
+	require.NoError(t, s.sampleCPU(context.Background()))
+	require.Greater(t, s.cpu[attribution{ComponentIntegration, "busy"}], float64(0))
+}
+
+func TestSampler_CPU_ProfilerInUse(t *testing.T) {
+	require.NoError(t, pprof.StartCPUProfile(ioutil.Discard))
+	defer pprof.StopCPUProfile()
+
+	s := NewSampler(log.NewNopLogger(), nil, time.Minute, 0)
+	require.Error(t, s.sampleCPU(context.Background()))
+	require.Equal(t, float64(1), s.skipped)
+}
+
+func TestNewSampler_ProfileDuration(t *testing.T) {
+	s := NewSampler(log.NewNopLogger(), nil, time.Minute, time.Minute)
+	require.Equal(t, 6*time.Second, s.duration, "profiles should take at most a tenth of the interval")
+
+	s = NewSampler(log.NewNopLogger(), nil, time.Minute, time.Second)
+	require.Equal(t, time.Second, s.duration)
+}
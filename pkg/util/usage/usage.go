@@ -0,0 +1,43 @@
+// Package usage attributes the CPU time and goroutines of the Agent to the
+// instances and integrations running in it.
+//
+// Goroutines are tagged with runtime/pprof labels naming the component they
+// belong to. Goroutines inherit the labels of the goroutine that started
+// them, so work started by a tagged goroutine is attributed to the same
+// component. A Sampler periodically profiles the process and exposes the
+// CPU time and goroutines of each component as metrics.
+package usage
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+)
+
+// Profile label keys used to tag goroutines.
+const (
+	componentLabel = "agent_component"
+	nameLabel      = "agent_name"
+)
+
+// Components that usage is attributed to.
+const (
+	ComponentInstance    = "instance"
+	ComponentIntegration = "integration"
+)
+
+// Do calls f with goroutines started by f attributed to the component with
+// the given name.
+func Do(ctx context.Context, component, name string, f func(context.Context)) {
+	pprof.Do(ctx, pprof.Labels(componentLabel, component, nameLabel, name), f)
+}
+
+// Handler returns an http.Handler that serves requests with h, attributing
+// the work done for each request to the component with the given name.
+func Handler(component, name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		Do(r.Context(), component, name, func(ctx context.Context) {
+			h.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	})
+}